@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"text/template"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/verifier"
@@ -19,6 +24,7 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb/labels"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -32,7 +38,8 @@ var (
 		for id := range issuesMap {
 			s = append(s, id)
 		}
-		return s
+		// Configured by flags, so it is created on demand.
+		return append(s, verifier.NonFiniteSamplesIssueID)
 	}
 )
 
@@ -44,6 +51,8 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 
 	s3Config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+
 	// Verify command.
 	verify := cmd.Command("verify", "verify all blocks in the bucket against specified issues")
 	verifyRepair := verify.Flag("repair", "attempt to repair blocks for which issues were detected").
@@ -57,8 +66,10 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 		Short('i').Default(verifier.IndexIssueID, verifier.OverlappedBlocksIssueID).Strings()
 	verifyIDWhitelist := verify.Flag("id-whitelist", "Block IDs to verify (and optionally repair) only. "+
 		"If none is specified, all blocks will be verified. Repeated field").Strings()
+	verifyZeroNonFinite := verify.Flag("non-finite.zero", fmt.Sprintf("Set NaN and infinite sample values to 0 when repairing the %s issue instead of dropping them.", verifier.NonFiniteSamplesIssueID)).
+		Default("false").Bool()
 	m[name+" verify"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
-		bkt, err := client.NewBucket(gcsBucket, *s3Config, reg, name)
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
 		if err != nil {
 			return err
 		}
@@ -66,7 +77,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 
 		backupS3Config := *s3Config
 		backupS3Config.Bucket = *verifyBackupS3Bucket
-		backupBkt, err := client.NewBucket(verifyBackupGCSBucket, backupS3Config, reg, name)
+		backupBkt, err := client.NewBucket(verifyBackupGCSBucket, backupS3Config, filesystem.Config{}, reg, name)
 		if err == client.ErrNotFound {
 			if *verifyRepair {
 				return errors.Wrap(err, "repair is specified, so backup client is required")
@@ -87,6 +98,10 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 		)
 
 		for _, i := range *verifyIssues {
+			if i == verifier.NonFiniteSamplesIssueID {
+				issues = append(issues, verifier.NonFiniteSamplesIssue(reg, *verifyZeroNonFinite))
+				continue
+			}
 			issueFn, ok := issuesMap[i]
 			if !ok {
 				return errors.Errorf("no such issue name %s", i)
@@ -126,7 +141,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 	lsOutput := ls.Flag("output", "Format in which to print each block's information. May be 'json' or custom template.").
 		Short('o').Default("").String()
 	m[name+" ls"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
-		bkt, err := client.NewBucket(gcsBucket, *s3Config, reg, name)
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
 		if err != nil {
 			return err
 		}
@@ -155,7 +170,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 			enc.SetIndent("", "\t")
 
 			printBlock = func(id ulid.ULID) error {
-				m, err := block.DownloadMeta(ctx, bkt, id)
+				m, err := block.DownloadMeta(ctx, logger, bkt, id)
 				if err != nil {
 					return err
 				}
@@ -167,7 +182,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 				return errors.Wrap(err, "invalid template")
 			}
 			printBlock = func(id ulid.ULID) error {
-				m, err := block.DownloadMeta(ctx, bkt, id)
+				m, err := block.DownloadMeta(ctx, logger, bkt, id)
 				if err != nil {
 					return err
 				}
@@ -188,4 +203,331 @@ func registerBucket(m map[string]setupFunc, app *kingpin.Application, name strin
 			return printBlock(id)
 		})
 	}
+
+	mark := cmd.Command("mark", "mark blocks for deletion or to be excluded from compaction. Compactors delete blocks marked for deletion after --delete-delay and stores stop serving them")
+	markIDs := mark.Flag("id", "ID (ULID) of a block to mark. All blocks must exist before any is marked.").
+		Required().Strings()
+	markMarker := mark.Flag("marker", "Marker to put into the block directories.").
+		Required().Enum(block.DeletionMarkFilename, block.NoCompactMarkFilename)
+	markDetails := mark.Flag("details", "Why the blocks are marked, recorded in the marker for auditing.").
+		String()
+	markRemove := mark.Flag("remove", "Remove the marker from the blocks instead.").
+		Default("false").Bool()
+	m[name+" mark"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		var ids []ulid.ULID
+		for _, s := range *markIDs {
+			id, err := ulid.Parse(s)
+			if err != nil {
+				return errors.Wrapf(err, "invalid block ID %q", s)
+			}
+			ok, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+			if err != nil {
+				return errors.Wrapf(err, "check meta.json of block %s", id)
+			}
+			if !ok {
+				return errors.Errorf("block %s does not exist", id)
+			}
+			ids = append(ids, id)
+		}
+		for _, id := range ids {
+			if *markRemove {
+				if err := block.UnmarkBlock(ctx, logger, bkt, id, *markMarker); err != nil {
+					return errors.Wrapf(err, "unmark block %s", id)
+				}
+				level.Info(logger).Log("msg", "removed marker from block", "block", id, "marker", *markMarker)
+				continue
+			}
+			if err := block.MarkBlock(ctx, logger, bkt, id, *markMarker, *markDetails); err != nil {
+				return errors.Wrapf(err, "mark block %s", id)
+			}
+			level.Info(logger).Log("msg", "marked block", "block", id, "marker", *markMarker)
+		}
+		return nil
+	}
+
+	retention := cmd.Command("retention", "list all blocks that exceed the retention of their resolution as JSON, grouped by external labels. Nothing is deleted, and the compactor does not apply this retention either")
+	retentionRaw := retention.Flag("retention.resolution-raw", "How long to retain raw samples. 0 retains them forever.").
+		Default("0s").Duration()
+	retention5m := retention.Flag("retention.resolution-5m", "How long to retain samples of resolution 1 (5 minutes). 0 retains them forever.").
+		Default("0s").Duration()
+	retention1h := retention.Flag("retention.resolution-1h", "How long to retain samples of resolution 2 (1 hour). 0 retains them forever.").
+		Default("0s").Duration()
+	m[name+" retention"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		plan, err := compact.PlanRetention(ctx, logger, bkt, map[int64]time.Duration{
+			downsample.ResLevel0: *retentionRaw,
+			downsample.ResLevel1: *retention5m,
+			downsample.ResLevel2: *retention1h,
+		}, time.Now())
+		if err != nil {
+			return errors.Wrap(err, "plan retention")
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+
+		return enc.Encode(plan)
+	}
+
+	repairStats := cmd.Command("repair-stats", "recompute the series, chunk and sample counts of blocks from their index and chunks and fix them in the meta.json of blocks where they are wrong. Chunks and index are not modified")
+	repairStatsIDs := repairStats.Flag("id", "ID (ULID) of a block to repair (repeatable). If none is specified, all blocks are repaired.").
+		Strings()
+	repairStatsDataDir := repairStats.Flag("data-dir", "Data directory in which to download the index of blocks to recompute their stats.").
+		Default("./data").String()
+	repairStatsDryRun := repairStats.Flag("dry-run", "Only report blocks with wrong stats without fixing them.").
+		Default("false").Bool()
+	m[name+" repair-stats"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+		ctx := context.Background()
+
+		var ids []ulid.ULID
+		for _, s := range *repairStatsIDs {
+			id, err := ulid.Parse(s)
+			if err != nil {
+				return errors.Wrapf(err, "invalid block ID %q", s)
+			}
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			err := bkt.Iter(ctx, "", func(name string) error {
+				if id, ok := block.IsBlockDir(name); ok {
+					ids = append(ids, id)
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "list blocks")
+			}
+		}
+		if err := os.MkdirAll(*repairStatsDataDir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "create data dir")
+		}
+
+		wrong := 0
+		for _, id := range ids {
+			recorded, actual, err := block.RepairStats(ctx, logger, bkt, id, *repairStatsDataDir, downsample.NewPool(), *repairStatsDryRun)
+			if err != nil {
+				return errors.Wrapf(err, "repair stats of block %s", id)
+			}
+			if recorded == actual {
+				continue
+			}
+			wrong++
+			level.Warn(logger).Log("msg", "block has wrong stats", "block", id, "fixed", !*repairStatsDryRun,
+				"recorded", fmt.Sprintf("%+v", recorded), "actual", fmt.Sprintf("%+v", actual))
+		}
+		level.Info(logger).Log("msg", "checked stats of blocks", "blocks", len(ids), "wrong", wrong, "dry-run", *repairStatsDryRun)
+		return nil
+	}
+
+	cleanup := cmd.Command("cleanup", "find auxiliary objects not tied to a live block, like index caches, markers of deleted blocks and their debug metas, and report them with their size. Block files are never removed")
+	cleanupMinAge := cleanup.Flag("min-age", "Minimum age of blocks, by the time of their ID, whose debug metas are removed. Protects the debug metas of blocks that are being uploaded.").
+		Default("48h").Duration()
+	cleanupDelete := cleanup.Flag("delete", "Delete the orphaned objects. Without it, they are only reported.").
+		Default("false").Bool()
+	m[name+" cleanup"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+		ctx := context.Background()
+
+		orphaned, err := block.FindOrphanedObjects(ctx, bkt, *cleanupMinAge, time.Now())
+		if err != nil {
+			return errors.Wrap(err, "find orphaned objects")
+		}
+		var size uint64
+		for _, o := range orphaned {
+			if *cleanupDelete {
+				if err := bkt.Delete(ctx, o.Name); err != nil {
+					return errors.Wrapf(err, "delete %s", o.Name)
+				}
+			}
+			size += o.Size
+			level.Info(logger).Log("msg", "orphaned object", "name", o.Name, "size", o.Size, "reason", o.Reason, "deleted", *cleanupDelete)
+		}
+		level.Info(logger).Log("msg", "cleaned up bucket", "objects", len(orphaned), "bytes", size, "deleted", *cleanupDelete)
+		return nil
+	}
+
+	downsampleCmd := cmd.Command("downsample", "downsample selected blocks to a resolution and upload the results, e.g. to backfill downsampled data of old blocks the compactor skipped. Blocks that are already downsampled to the resolution are skipped")
+	downsampleIDs := downsampleCmd.Flag("id", "ID (ULID) of a block to downsample (repeatable).").
+		Strings()
+	downsampleLabels := downsampleCmd.Flag("label", "External label the blocks to downsample must have (repeatable).").
+		PlaceHolder("<name>=\"<value>\"").Strings()
+	downsampleResolution := downsampleCmd.Flag("resolution", "Resolution to downsample the blocks to.").
+		Default("5m").Enum("5m", "1h")
+	downsampleDataDir := downsampleCmd.Flag("data-dir", "Scratch directory in which to download and downsample blocks. It is cleaned on start.").
+		Default("./data").String()
+	downsampleMark := downsampleCmd.Flag("mark-source", "Marker to put into the directory of each source block once it is downsampled, e.g. to delete raw data that is kept as downsampled data only.").
+		Enum(block.DeletionMarkFilename, block.NoCompactMarkFilename)
+	downsampleGaugePatterns := regDownsampleOverrideFlags(downsampleCmd)
+	m[name+" downsample"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ bool) error {
+		if len(*downsampleIDs) == 0 && len(*downsampleLabels) == 0 {
+			return errors.New("at least one --id or --label must be specified")
+		}
+		want := map[ulid.ULID]struct{}{}
+		for _, s := range *downsampleIDs {
+			id, err := ulid.Parse(s)
+			if err != nil {
+				return errors.Wrapf(err, "invalid block ID %q", s)
+			}
+			want[id] = struct{}{}
+		}
+		lset, err := parseFlagLabels(*downsampleLabels)
+		if err != nil {
+			return errors.Wrap(err, "parse labels")
+		}
+		resolution := downsample.ResLevel1
+		if *downsampleResolution == "1h" {
+			resolution = downsample.ResLevel2
+		}
+		overrides, err := downsample.NewOverrides(reg, *downsampleGaugePatterns)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling overrides")
+		}
+
+		bkt, err := client.NewBucket(gcsBucket, *s3Config, *fsConfig, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+		ctx := context.Background()
+
+		var metas []block.Meta
+		err = bkt.Iter(ctx, "", func(name string) error {
+			id, ok := block.IsBlockDir(name)
+			if !ok {
+				return nil
+			}
+			m, err := block.DownloadMeta(ctx, logger, bkt, id)
+			if bkt.IsObjNotFoundErr(errors.Cause(err)) {
+				level.Warn(logger).Log("msg", "block without meta.json, ignoring", "block", id)
+				return nil
+			}
+			if err != nil {
+				return errors.Wrapf(err, "download meta of block %s", id)
+			}
+			metas = append(metas, m)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "retrieve bucket block metas")
+		}
+
+		// Sources of blocks of the resolution, which do not need to be downsampled again.
+		downsampled := map[ulid.ULID]struct{}{}
+		for _, m := range metas {
+			if m.Thanos.Downsample.Resolution != resolution {
+				continue
+			}
+			for _, id := range m.Compaction.Sources {
+				downsampled[id] = struct{}{}
+			}
+		}
+
+		if err := os.RemoveAll(*downsampleDataDir); err != nil {
+			return errors.Wrap(err, "clean data dir")
+		}
+		if err := os.MkdirAll(*downsampleDataDir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "create data dir")
+		}
+
+		processed := 0
+		for i := range metas {
+			m := &metas[i]
+
+			if _, ok := want[m.ULID]; len(want) > 0 && !ok {
+				continue
+			}
+			if !matchesExternalLabels(m, lset) {
+				continue
+			}
+			if m.Thanos.Downsample.Resolution >= resolution {
+				level.Info(logger).Log("msg", "skipping block of same or lower resolution", "block", m.ULID, "resolution", m.Thanos.Downsample.Resolution)
+				continue
+			}
+			missing := false
+			for _, id := range m.Compaction.Sources {
+				if _, ok := downsampled[id]; !ok {
+					missing = true
+					break
+				}
+			}
+			if !missing {
+				level.Info(logger).Log("msg", "skipping block that is already downsampled", "block", m.ULID)
+				continue
+			}
+
+			id, err := processDownsampling(ctx, logger, bkt, m, *downsampleDataDir, resolution, overrides)
+			if err != nil {
+				return err
+			}
+			processed++
+
+			if *downsampleMark == "" {
+				continue
+			}
+			if err := block.MarkBlock(ctx, logger, bkt, m.ULID, *downsampleMark, fmt.Sprintf("downsampled to block %s", id)); err != nil {
+				return errors.Wrapf(err, "mark block %s", m.ULID)
+			}
+			level.Info(logger).Log("msg", "marked block", "block", m.ULID, "marker", *downsampleMark)
+		}
+		level.Info(logger).Log("msg", "downsampled blocks", "blocks", processed, "resolution", *downsampleResolution)
+		return nil
+	}
+}
+
+// matchesExternalLabels returns true if the block has all the given external labels.
+func matchesExternalLabels(m *block.Meta, lset labels.Labels) bool {
+	for _, l := range lset {
+		if m.Thanos.Labels[l.Name] != l.Value {
+			return false
+		}
+	}
+	return true
 }
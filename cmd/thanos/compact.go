@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"net/http"
 	"path"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/compact"
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/run"
@@ -37,26 +44,120 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application, name stri
 
 	s3config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
+	s3OutputBucket := cmd.Flag("s3.output-bucket", "S3 bucket, using the same endpoint and credentials as --s3.bucket, to write all compaction and downsampling results to. Uploads and deletions only target this bucket, blocks of --s3.bucket are read but never modified. Deleted blocks of --s3.bucket are hidden by tombstones in the output bucket. Allows to validate compaction against real data.").
+		PlaceHolder("<bucket>").String()
+
 	syncDelay := cmd.Flag("sync-delay", "Minimum age of fresh (non-compacted) blocks before they are being processed.").
 		Default("30m").Duration()
 
 	wait := cmd.Flag("wait", "Do not exit after all compactions have been processed and wait for new work.").
 		Short('w').Bool()
 
+	cleanupInterval := cmd.Flag("compact.cleanup-interval", "How often to delete blocks left behind by interrupted uploads, i.e. blocks without a valid meta.json. The cleanup runs as part of a compaction pass. 0 disables the cleanup.").
+		Default("5m").Duration()
+
+	deleteDelay := cmd.Flag("delete-delay", "Minimum age of blocks without a valid meta.json before they are deleted. Must be longer than any block upload may take. Blocks marked for deletion are deleted once their mark is this old, which gives stores time to stop serving them.").
+		Default("48h").Duration()
+
+	quarantineAfter := cmd.Flag("compact.quarantine-after", "Number of consecutive failures after which a compaction group is quarantined. Quarantined groups are skipped so that other groups keep compacting, and only retried after --compact.quarantine-retry-interval. Failures are only counted in memory, so they are lost on restart, and across passes, so values above 1 require --wait. 0 disables the quarantine, so that every failure stops the compaction pass.").
+		Default("0").Int()
+
+	quarantineRetryInterval := cmd.Flag("compact.quarantine-retry-interval", "How often to retry the compaction of a quarantined group.").
+		Default("6h").Duration()
+
+	downloadConcurrency := cmd.Flag("compact.download-concurrency", "Number of files of a block that are downloaded in parallel for compaction. Higher values make better use of the bandwidth of high latency object storage like S3.").
+		Default("1").Int()
+
+	downloadBufferSize := cmd.Flag("compact.download-buffer-size", "Size of the buffer each file of a block is downloaded through. 0 uses the default buffer size.").
+		Default("0B").Bytes()
+
+	retentionSize := cmd.Flag("retention.size", "Maximum total size of all blocks in the bucket. Once it is exceeded, blocks are deleted after each compaction and downsampling pass until the bucket is within the budget. Each deletion takes the oldest block of the group of blocks with the same external labels that holds the most data, raw blocks before downsampled ones of the same time range. The compactor has no age based retention. 0 disables the size based retention.").
+		Default("0B").Bytes()
+
+	gaugePatterns := regDownsampleOverrideFlags(cmd)
+
+	sourceGracePeriod := cmd.Flag("downsample.source-grace-period", "Minimum time to keep blocks after their data was downsampled, even if they were compacted into another block. Allows to recreate a broken downsampled block from its source. Stores do not query kept blocks whose data is contained in a compacted block. 0 disables the grace period.").
+		Default("0s").Duration()
+
+	manualTrigger := cmd.Flag("compact.enable-manual-trigger", "Enable the /-/compact HTTP endpoint. A POST request to it triggers a single compaction and downsampling pass and returns once the pass is done. Responds with 409 if a pass is already in progress or the compactor halted.").
+		Default("false").Bool()
+
+	labelEquivalencesFile := cmd.Flag("compact.label-equivalences-file", "YAML file listing external label sets whose blocks are compacted into the blocks of a canonical label set, e.g. after a relabel change. Merging is irreversible and requires the blocks not to overlap in time. See the docs for the format.").
+		PlaceHolder("<path>").String()
+
+	strict := cmd.Flag("compact.strict", "Verify the checksums of all chunks of blocks before compacting them and of the compacted block before uploading it. A source block that fails the verification is marked for no compaction and halts the compactor, so corruption is not carried over into compacted blocks. Requires reading all downloaded data once more.").
+		Default("false").Bool()
+
+	skipOverlapping := cmd.Flag("compact.skip-overlapping-groups", "Skip groups whose blocks overlap, e.g. because multiple Prometheus servers upload blocks with the same external labels, instead of halting the compactor. Such groups are logged with the overlapping blocks and the other groups are still compacted.").
+		Default("false").Bool()
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
+		overrides, err := downsample.NewOverrides(reg, *gaugePatterns)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling overrides")
+		}
+		var equivalences *compact.LabelEquivalences
+		if *labelEquivalencesFile != "" {
+			equivalences, err = compact.LoadLabelEquivalences(*labelEquivalencesFile)
+			if err != nil {
+				return err
+			}
+			for canonical, lsets := range equivalences.Mappings() {
+				level.Warn(logger).Log("msg", "blocks with equivalent external labels are compacted into the canonical labels, this cannot be undone",
+					"canonical", canonical, "equivalent", strings.Join(lsets, ", "))
+			}
+		}
 		return runCompact(g, logger, reg,
 			*httpAddr,
 			*dataDir,
 			*gcsBucket,
 			s3config,
+			fsConfig,
+			rateLimits,
+			*s3OutputBucket,
 			*syncDelay,
 			*haltOnError,
 			*wait,
+			compactConfig{
+				sourceGracePeriod:       *sourceGracePeriod,
+				cleanupInterval:         *cleanupInterval,
+				deleteDelay:             *deleteDelay,
+				skipOverlapping:         *skipOverlapping,
+				manualTrigger:           *manualTrigger,
+				quarantineAfter:         *quarantineAfter,
+				quarantineRetryInterval: *quarantineRetryInterval,
+				downloadConcurrency:     *downloadConcurrency,
+				downloadBufferSize:      int(*downloadBufferSize),
+				retentionSize:           uint64(*retentionSize),
+				overrides:               overrides,
+				equivalences:            equivalences,
+				strict:                  *strict,
+			},
 			name,
 		)
 	}
 }
 
+// compactConfig holds the options of the compaction and downsampling passes, see the flags of the same names.
+type compactConfig struct {
+	sourceGracePeriod       time.Duration
+	cleanupInterval         time.Duration
+	deleteDelay             time.Duration
+	skipOverlapping         bool
+	manualTrigger           bool
+	quarantineAfter         int
+	quarantineRetryInterval time.Duration
+	downloadConcurrency     int
+	downloadBufferSize      int
+	retentionSize           uint64
+	overrides               *downsample.Overrides
+	equivalences            *compact.LabelEquivalences
+	strict                  bool
+}
+
 func runCompact(
 	g *run.Group,
 	logger log.Logger,
@@ -65,9 +166,13 @@ func runCompact(
 	dataDir string,
 	gcsBucket string,
 	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
+	s3OutputBucket string,
 	syncDelay time.Duration,
 	haltOnError bool,
 	wait bool,
+	conf compactConfig,
 	component string,
 ) error {
 	halted := prometheus.NewGauge(prometheus.GaugeOpts{
@@ -78,11 +183,15 @@ func runCompact(
 		Name: "thanos_compactor_retries_total",
 		Help: "Total number of retries after retriable compactor error",
 	})
+	overlapSkips := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compactor_overlapping_groups_skipped_total",
+		Help: "Total number of times a group was skipped because its blocks overlap",
+	})
 	halted.Set(0)
 
-	reg.MustRegister(halted)
+	reg.MustRegister(halted, overlapSkips)
 
-	bkt, err := client.NewBucket(&gcsBucket, *s3Config, reg, component)
+	bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
 	if err != nil {
 		return err
 	}
@@ -94,7 +203,42 @@ func runCompact(
 		}
 	}()
 
-	sy, err := compact.NewSyncer(logger, reg, bkt, syncDelay)
+	if s3OutputBucket != "" {
+		if s3OutputBucket == s3Config.Bucket {
+			runutil.LogOnErr(logger, bkt, "bucket client")
+			return errors.New("output bucket must differ from the source bucket")
+		}
+		cfg := *s3Config
+		cfg.Bucket = s3OutputBucket
+
+		out, err := client.NewBucket(&gcsBucket, cfg, filesystem.Config{}, reg, component)
+		if err != nil {
+			runutil.LogOnErr(logger, bkt, "bucket client")
+			return errors.Wrap(err, "create output bucket client")
+		}
+		level.Info(logger).Log("msg", "writing compaction results to output bucket, source bucket is read-only",
+			"source", s3Config.Bucket, "output", s3OutputBucket)
+		overlay, err := objstore.NewOverlayBucket(context.Background(), bkt, out)
+		if err != nil {
+			runutil.LogOnErr(logger, bkt, "bucket client")
+			runutil.LogOnErr(logger, out, "output bucket client")
+			return errors.Wrap(err, "create overlay bucket")
+		}
+		bkt = overlay
+	}
+	bkt = objstore.NewRateLimiter(reg, *rateLimits).Bucket(bkt)
+
+	var tryRunPass func() error
+
+	quarantine := compact.NewQuarantine(logger, reg, conf.quarantineAfter, conf.quarantineRetryInterval)
+	progress := compact.NewProgress(reg)
+
+	if conf.downloadConcurrency < 1 {
+		runutil.LogOnErr(logger, bkt, "bucket client")
+		return errors.New("download concurrency must be at least 1")
+	}
+
+	sy, err := compact.NewSyncer(logger, reg, bkt, syncDelay, conf.sourceGracePeriod, conf.downloadConcurrency, conf.downloadBufferSize, conf.equivalences, conf.strict)
 	if err != nil {
 		return err
 	}
@@ -115,12 +259,23 @@ func runCompact(
 
 		ctx, cancel := context.WithCancel(context.Background())
 
+		var lastCleanup time.Time
+
 		f := func() error {
 			var (
 				compactDir      = path.Join(dataDir, "compact")
 				downsamplingDir = path.Join(dataDir, "downsample")
 			)
 
+			if conf.cleanupInterval > 0 && time.Since(lastCleanup) >= conf.cleanupInterval {
+				level.Info(logger).Log("msg", "start cleanup of partially uploaded blocks")
+
+				if err := sy.CleanPartialUploads(ctx, conf.deleteDelay); err != nil {
+					return errors.Wrap(err, "cleanup partial uploads")
+				}
+				lastCleanup = time.Now()
+			}
+
 			// Loop over bucket and compact until there's no work left.
 			for {
 				level.Info(logger).Log("msg", "start sync of metas")
@@ -129,6 +284,10 @@ func runCompact(
 					return errors.Wrap(err, "sync")
 				}
 
+				if err := sy.DeleteMarkedBlocks(ctx, conf.deleteDelay); err != nil {
+					return errors.Wrap(err, "delete marked blocks")
+				}
+
 				level.Info(logger).Log("msg", "start of GC")
 
 				if err := sy.GarbageCollect(ctx); err != nil {
@@ -139,18 +298,41 @@ func runCompact(
 				if err != nil {
 					return errors.Wrap(err, "build compaction groups")
 				}
-				done := true
+				var todo []*compact.Group
 				for _, g := range groups {
+					if !quarantine.Skip(g.Key()) {
+						todo = append(todo, g)
+					}
+				}
+				// The estimate only feeds metrics and logs, failing to compute it does not stop the compaction.
+				if err := progress.Plan(ctx, sy, todo, compactDir, comp); err != nil {
+					level.Warn(logger).Log("msg", "estimating compaction progress failed", "err", err)
+				}
+
+				done := true
+				for _, g := range todo {
+					begin := time.Now()
 					id, err := g.Compact(ctx, compactDir, comp)
 					if err == nil {
+						quarantine.Succeeded(g.Key())
 						// If the returned ID has a zero value, the group had no blocks to be compacted.
 						// We keep going through the outer loop until no group has any work left.
 						if id != (ulid.ULID{}) {
 							done = false
+
+							progress.Done(g.Key(), time.Since(begin))
+							remaining, compactions, bytes, eta := progress.Remaining()
+							level.Info(logger).Log("msg", "compaction progress", "group", g.Key(), "remaining_groups", remaining,
+								"remaining_compactions", compactions, "remaining_bytes", bytes, "eta", eta)
 						}
 						continue
 					}
 
+					if conf.skipOverlapping && compact.IsOverlapError(err) {
+						level.Error(logger).Log("msg", "skipping group with overlapping blocks", "group", g.Key(), "err", err)
+						overlapSkips.Inc()
+						continue
+					}
 					if compact.IsIssue347Error(err) {
 						err = compact.RepairIssue347(ctx, logger, bkt, err)
 						if err == nil {
@@ -158,6 +340,10 @@ func runCompact(
 							continue
 						}
 					}
+					// Keep compacting the other groups if the failing group got quarantined.
+					if ctx.Err() == nil && quarantine.Failed(g.Key(), err) {
+						continue
+					}
 					return errors.Wrap(err, "compaction")
 				}
 				if done {
@@ -170,30 +356,71 @@ func runCompact(
 			// for 5m downsamplings created in the first run.
 			level.Info(logger).Log("msg", "start first pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir, conf.overrides); err != nil {
 				return errors.Wrap(err, "first pass of downsampling failed")
 			}
 
 			level.Info(logger).Log("msg", "start second pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, downsamplingDir, conf.overrides); err != nil {
 				return errors.Wrap(err, "second pass of downsampling failed")
 			}
 
+			if conf.retentionSize > 0 {
+				level.Info(logger).Log("msg", "start of size based retention")
+
+				// Downsampling created new blocks, which have to be synced first.
+				if err := sy.SyncMetas(ctx); err != nil {
+					return errors.Wrap(err, "sync before retention")
+				}
+				if err := sy.ApplySizeRetention(ctx, conf.retentionSize); err != nil {
+					return errors.Wrap(err, "size based retention")
+				}
+			}
+
 			level.Info(logger).Log("msg", "compaction iteration done")
 			return nil
 		}
 
+		// Scheduled and manually triggered passes must never run concurrently. A halted compactor holds
+		// the slot of the pass forever, so no pass runs while it is halted.
+		passc := make(chan struct{}, 1)
+		var isHalted int32
+		runPass := func() error {
+			select {
+			case passc <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-passc }()
+
+			return f()
+		}
+		// tryRunPass runs a pass unless one is already in progress or the compactor halted.
+		tryRunPass = func() error {
+			select {
+			case passc <- struct{}{}:
+			default:
+				if atomic.LoadInt32(&isHalted) == 1 {
+					return errCompactorHalted
+				}
+				return errPassInProgress
+			}
+			defer func() { <-passc }()
+
+			return f()
+		}
+
 		g.Add(func() error {
 			defer runutil.LogOnErr(logger, bkt, "bucket client")
 
 			if !wait {
-				return f()
+				return runPass()
 			}
 
 			// --wait=true is specified.
 			return runutil.Repeat(5*time.Minute, ctx.Done(), func() error {
-				err := f()
+				err := runPass()
 				if err != nil {
 					// The HaltError type signals that we hit a critical bug and should block
 					// for investigation.
@@ -202,6 +429,8 @@ func runCompact(
 						if haltOnError {
 							level.Error(logger).Log("msg", "critical error detected; halting", "err", err)
 							halted.Set(1)
+							atomic.StoreInt32(&isHalted, 1)
+							passc <- struct{}{}
 							select {}
 						} else {
 							return errors.Wrap(err, "critical error detected")
@@ -224,10 +453,58 @@ func runCompact(
 			cancel()
 		})
 	}
-	if err := metricHTTPListenGroup(g, logger, reg, httpBindAddr); err != nil {
-		return err
+	// Start HTTP server for metrics, profiling and the optional manual trigger.
+	{
+		mux := http.NewServeMux()
+		registerMetrics(mux, reg)
+		registerProfile(mux)
+		if conf.manualTrigger {
+			mux.Handle("/-/compact", compactTriggerHandler(logger, tryRunPass))
+		}
+
+		l, err := net.Listen("tcp", httpBindAddr)
+		if err != nil {
+			return errors.Wrap(err, "listen metrics address")
+		}
+
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "Listening for metrics", "address", httpBindAddr)
+			return errors.Wrap(http.Serve(l, mux), "serve metrics")
+		}, func(error) {
+			runutil.LogOnErr(logger, l, "metric listener")
+		})
 	}
 
 	level.Info(logger).Log("msg", "starting compact node")
 	return nil
 }
+
+var (
+	errPassInProgress  = errors.New("compaction pass already in progress")
+	errCompactorHalted = errors.New("compactor halted due to a critical error")
+)
+
+// compactTriggerHandler returns a handler that runs a single compaction pass on POST requests
+// and responds once the pass finished. It responds with 409 if the pass cannot run.
+func compactTriggerHandler(logger log.Logger, tryRunPass func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		level.Info(logger).Log("msg", "manual compaction pass triggered")
+
+		err := tryRunPass()
+		if err == errPassInProgress || err == errCompactorHalted {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "manual compaction pass failed", "err", err)
+			http.Error(w, fmt.Sprintf("compaction pass failed: %s", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,6 +15,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/run"
@@ -38,11 +38,26 @@ func registerDownsample(m map[string]setupFunc, app *kingpin.Application, name s
 
 	s3Config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
+	gaugePatterns := regDownsampleOverrideFlags(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
-		return runDownsample(g, logger, reg, *dataDir, *gcsBucket, s3Config, name)
+		overrides, err := downsample.NewOverrides(reg, *gaugePatterns)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling overrides")
+		}
+		return runDownsample(g, logger, reg, *dataDir, *gcsBucket, s3Config, fsConfig, rateLimits, overrides, name)
 	}
 }
 
+// regDownsampleOverrideFlags registers flags that override the kind of series during downsampling.
+func regDownsampleOverrideFlags(cmd *kingpin.CmdClause) (gaugePatterns *[]string) {
+	return cmd.Flag("downsample.gauge-pattern", "Regular expression over metric names of series that should be downsampled as gauges. No counter aggregate is computed for them, rate() and increase() over their downsampled data use the max aggregate instead (repeated).").
+		PlaceHolder("<regex>").Strings()
+}
+
 func runDownsample(
 	g *run.Group,
 	logger log.Logger,
@@ -50,13 +65,17 @@ func runDownsample(
 	dataDir string,
 	gcsBucket string,
 	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
+	overrides *downsample.Overrides,
 	component string,
 ) error {
 
-	bkt, err := client.NewBucket(&gcsBucket, *s3Config, reg, component)
+	bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
 	if err != nil {
 		return err
 	}
+	bkt = objstore.NewRateLimiter(reg, *rateLimits).Bucket(bkt)
 
 	// Ensure we close up everything properly.
 	defer func() {
@@ -74,13 +93,13 @@ func runDownsample(
 
 			level.Info(logger).Log("msg", "start first pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, dataDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, dataDir, overrides); err != nil {
 				return errors.Wrap(err, "downsampling failed")
 			}
 
 			level.Info(logger).Log("msg", "start second pass of downsampling")
 
-			if err := downsampleBucket(ctx, logger, bkt, dataDir); err != nil {
+			if err := downsampleBucket(ctx, logger, bkt, dataDir, overrides); err != nil {
 				return errors.Wrap(err, "downsampling failed")
 			}
 
@@ -99,6 +118,7 @@ func downsampleBucket(
 	logger log.Logger,
 	bkt objstore.Bucket,
 	dir string,
+	overrides *downsample.Overrides,
 ) error {
 	if err := os.RemoveAll(dir); err != nil {
 		return errors.Wrap(err, "clean working directory")
@@ -115,16 +135,20 @@ func downsampleBucket(
 		}
 
 		rc, err := bkt.Get(ctx, path.Join(id.String(), block.MetaFilename))
+		if bkt.IsObjNotFoundErr(err) {
+			level.Warn(logger).Log("msg", "block without meta.json, ignoring", "block", id)
+			return nil
+		}
 		if err != nil {
 			return errors.Wrapf(err, "get meta for block %s", id)
 		}
 		defer runutil.LogOnErr(logger, rc, "block reader")
 
-		var m block.Meta
-		if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		m, err := block.ReadMeta(logger, rc)
+		if err != nil {
 			return errors.Wrap(err, "decode meta")
 		}
-		metas = append(metas, &m)
+		metas = append(metas, m)
 
 		return nil
 	})
@@ -173,7 +197,7 @@ func downsampleBucket(
 			if m.MaxTime-m.MinTime < 40*60*60*1000 {
 				continue
 			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, 5*60*1000); err != nil {
+			if _, err := processDownsampling(ctx, logger, bkt, m, dir, 5*60*1000, overrides); err != nil {
 				return err
 			}
 
@@ -194,7 +218,7 @@ func downsampleBucket(
 			if m.MaxTime-m.MinTime < 10*24*60*60*1000 {
 				continue
 			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, 60*60*1000); err != nil {
+			if _, err := processDownsampling(ctx, logger, bkt, m, dir, 60*60*1000, overrides); err != nil {
 				return err
 			}
 		}
@@ -202,18 +226,28 @@ func downsampleBucket(
 	return nil
 }
 
-func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bucket, m *block.Meta, dir string, resolution int64) error {
+// processDownsampling downsamples the block to the given resolution in dir and uploads the result after verifying
+// the index of the source and the downsampled block. It returns the ID of the downsampled block.
+func processDownsampling(
+	ctx context.Context,
+	logger log.Logger,
+	bkt objstore.Bucket,
+	m *block.Meta,
+	dir string,
+	resolution int64,
+	overrides *downsample.Overrides,
+) (ulid.ULID, error) {
 	begin := time.Now()
 	bdir := filepath.Join(dir, m.ULID.String())
 
 	err := block.Download(ctx, bkt, m.ULID, bdir)
 	if err != nil {
-		return errors.Wrapf(err, "download block %s", m.ULID)
+		return ulid.ULID{}, errors.Wrapf(err, "download block %s", m.ULID)
 	}
 	level.Info(logger).Log("msg", "downloaded block", "id", m.ULID, "duration", time.Since(begin))
 
 	if err := block.VerifyIndex(filepath.Join(bdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil {
-		return errors.Wrap(err, "input block index not valid")
+		return ulid.ULID{}, errors.Wrap(err, "input block index not valid")
 	}
 
 	begin = time.Now()
@@ -227,13 +261,13 @@ func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bu
 
 	b, err := tsdb.OpenBlock(bdir, pool)
 	if err != nil {
-		return errors.Wrapf(err, "open block %s", m.ULID)
+		return ulid.ULID{}, errors.Wrapf(err, "open block %s", m.ULID)
 	}
 	defer runutil.LogOnErr(log.With(logger, "outcome", "potential left mmap file handlers left"), b, "tsdb reader")
 
-	id, err := downsample.Downsample(m, b, dir, resolution)
+	id, err := downsample.Downsample(m, b, dir, resolution, overrides)
 	if err != nil {
-		return errors.Wrapf(err, "downsample block %s to window %d", m.ULID, resolution)
+		return ulid.ULID{}, errors.Wrapf(err, "downsample block %s to window %d", m.ULID, resolution)
 	}
 	resdir := filepath.Join(dir, id.String())
 
@@ -241,14 +275,14 @@ func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bu
 		"from", m.ULID, "to", id, "duration", time.Since(begin))
 
 	if err := block.VerifyIndex(filepath.Join(resdir, block.IndexFilename), m.MinTime, m.MaxTime); err != nil {
-		return errors.Wrap(err, "output block index not valid")
+		return ulid.ULID{}, errors.Wrap(err, "output block index not valid")
 	}
 
 	begin = time.Now()
 
 	err = block.Upload(ctx, bkt, resdir)
 	if err != nil {
-		return errors.Wrapf(err, "upload downsampled block %s", id)
+		return ulid.ULID{}, errors.Wrapf(err, "upload downsampled block %s", id)
 	}
 
 	level.Info(logger).Log("msg", "uploaded block", "id", id, "duration", time.Since(begin))
@@ -263,5 +297,5 @@ func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bu
 		level.Warn(logger).Log("msg", "failed to clean directory", "resdir", bdir, "err", err)
 	}
 
-	return nil
+	return id, nil
 }
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
+	"math"
+	"time"
 
+	"github.com/alecthomas/units"
+	gmetrics "github.com/armon/go-metrics"
+	gprom "github.com/armon/go-metrics/prometheus"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/cluster"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -36,6 +43,12 @@ func regCommonServerFlags(cmd *kingpin.CmdClause) (*string, *string, func(log.Lo
 
 	refreshInterval := cmd.Flag("cluster.refresh-interval", "Interval for membership to refresh cluster.peers state, 0 disables refresh.").Default(cluster.DefaultRefreshInterval.String()).Duration()
 
+	secretKeys := cmd.Flag("cluster.secret-key", "Base64 encoded key of 16, 24 or 32 bytes to encrypt the gossip traffic with (repeated). Peers decrypt messages with any of the keys, so keys can be rotated without splitting the cluster. If empty, gossip is not encrypted.").
+		PlaceHolder("<key>").Strings()
+
+	primaryKey := cmd.Flag("cluster.secret-key-primary", "Index of the --cluster.secret-key that encrypts the gossip messages sent by this peer.").
+		Default("0").Int()
+
 	return grpcBindAddr,
 		httpBindAddr,
 		func(logger log.Logger, reg *prometheus.Registry, waitIfEmpty bool, httpAdvertiseAddr string, queryAPIEnabled bool) (*cluster.Peer, error) {
@@ -68,10 +81,103 @@ func regCommonServerFlags(cmd *kingpin.CmdClause) (*string, *string, func(log.Lo
 				level.Info(logger).Log("msg", "QueryAPI address that will be propagated through gossip", "address", advQueryAPIAddress)
 			}
 
-			return cluster.New(logger, reg, *clusterBindAddr, *clusterAdvertiseAddr, advStoreAPIAddress, advQueryAPIAddress, *peers, waitIfEmpty, *gossipInterval, *pushPullInterval, *refreshInterval)
+			var keys [][]byte
+			for i, k := range *secretKeys {
+				key, err := base64.StdEncoding.DecodeString(k)
+				if err != nil {
+					return nil, errors.Wrapf(err, "decode cluster secret key %d", i)
+				}
+				keys = append(keys, key)
+			}
+
+			peer, err := cluster.New(logger, reg, *clusterBindAddr, *clusterAdvertiseAddr, advStoreAPIAddress, advQueryAPIAddress, *peers, waitIfEmpty, *gossipInterval, *pushPullInterval, *refreshInterval, keys, *primaryKey)
+			if err != nil {
+				return nil, err
+			}
+			if err := registerGossipMetrics(cmd.FullCommand(), peer); err != nil {
+				return nil, errors.Wrap(err, "register memberlist metrics")
+			}
+			return peer, nil
 		}
 }
 
+// registerGossipMetrics exposes the go-metrics of memberlist through the default registerer and translates the
+// suspicions of peers into the metrics of the given peer. Only components that join a gossip cluster register it,
+// so that others do not expose empty memberlist metrics.
+func registerGossipMetrics(service string, peer *cluster.Peer) error {
+	sink, err := gprom.NewPrometheusSink()
+	if err != nil {
+		return err
+	}
+	_, err = gmetrics.NewGlobal(gmetrics.DefaultConfig(service), peer.MetricSink(sink))
+	return err
+}
+
 func regHTTPAddrFlag(cmd *kingpin.CmdClause) *string {
 	return cmd.Flag("http-address", "Listen host:port for HTTP endpoints.").Default("0.0.0.0:10902").String()
 }
+
+// grpcWindowSizes holds the HTTP/2 flow control window sizes of gRPC connections.
+// Zero values keep the gRPC defaults. There is no flag for the maximum HTTP/2 frame size, as gRPC
+// always advertises and writes frames of at most 16KiB and offers no option to change it.
+type grpcWindowSizes struct {
+	stream *units.Base2Bytes
+	conn   *units.Base2Bytes
+}
+
+func regGRPCWindowFlags(cmd *kingpin.CmdClause) *grpcWindowSizes {
+	return &grpcWindowSizes{
+		stream: cmd.Flag("grpc.initial-window-size", "Initial HTTP/2 flow control window size of gRPC streams. Larger windows increase StoreAPI throughput on links with high latency and bandwidth. Sizes below 64KiB are ignored. Applies to served and, for the querier, dialed connections.").
+			Default("0B").Bytes(),
+		conn: cmd.Flag("grpc.initial-conn-window-size", "Initial HTTP/2 flow control window size of gRPC connections. Sizes below 64KiB are ignored. Applies to served and, for the querier, dialed connections.").
+			Default("0B").Bytes(),
+	}
+}
+
+func regSelfScrapeFlag(cmd *kingpin.CmdClause) *time.Duration {
+	return cmd.Flag("self-scrape-interval", "Interval at which the component's own metrics are stored in its TSDB, with the job label thanos-<component>. Allows to monitor small deployments without a separate Prometheus. 0 disables it.").
+		Default("0s").Duration()
+}
+
+func regGRPCReflectionFlag(cmd *kingpin.CmdClause) *bool {
+	return cmd.Flag("grpc.enable-reflection", "Register the gRPC reflection service, which allows tools like grpcurl to list and call the served gRPC services without their protobuf definitions. Keep it disabled in production unless needed for debugging.").
+		Default("false").Bool()
+}
+
+func (w *grpcWindowSizes) validate() error {
+	if *w.stream > math.MaxInt32 {
+		return errors.Errorf("gRPC stream window size %s exceeds the maximum of 2GiB", *w.stream)
+	}
+	if *w.conn > math.MaxInt32 {
+		return errors.Errorf("gRPC connection window size %s exceeds the maximum of 2GiB", *w.conn)
+	}
+	return nil
+}
+
+func (w *grpcWindowSizes) serverOpts() ([]grpc.ServerOption, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	var opts []grpc.ServerOption
+	if *w.stream > 0 {
+		opts = append(opts, grpc.InitialWindowSize(int32(*w.stream)))
+	}
+	if *w.conn > 0 {
+		opts = append(opts, grpc.InitialConnWindowSize(int32(*w.conn)))
+	}
+	return opts, nil
+}
+
+func (w *grpcWindowSizes) dialOpts() ([]grpc.DialOption, error) {
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	var opts []grpc.DialOption
+	if *w.stream > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(int32(*w.stream)))
+	}
+	if *w.conn > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(int32(*w.conn)))
+	}
+	return opts, nil
+}
@@ -12,16 +12,20 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	rpprof "runtime/pprof"
 	"syscall"
+	"time"
 
-	gmetrics "github.com/armon/go-metrics"
-	gprom "github.com/armon/go-metrics/prometheus"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	gogo_proto "github.com/gogo/protobuf/proto"
+	golang_proto "github.com/golang/protobuf/proto"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/improbable-eng/thanos/pkg/compression"
 	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/selfscrape"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
@@ -29,8 +33,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -52,6 +58,9 @@ func main() {
 
 	logLevel := app.Flag("log.level", "Log filtering level.").
 		Default("info").Enum("error", "warn", "info", "debug")
+
+	profileDir := app.Flag("debug.profile-dir", "Directory to which goroutine and heap profiles are written on SIGUSR1 or SIGUSR2, typically the data directory of the component. If empty, the signals are ignored.").
+		PlaceHolder("<dir>").String()
 	cmds := map[string]setupFunc{}
 	registerSidecar(cmds, app, "sidecar")
 	registerStore(cmds, app, "store")
@@ -99,19 +108,12 @@ func main() {
 		version.NewCollector("thanos"),
 		prometheus.NewGoCollector(),
 	)
+	registerComponentInfo(metrics, cmd, time.Now())
+	// Servers of all components accept requests of clients that compress them.
+	compression.Register(metrics)
 
+	// The go-metrics sink of memberlist registers with the default registerer once a component joins a gossip cluster.
 	prometheus.DefaultRegisterer = metrics
-	// Memberlist uses go-metrics
-	sink, err := gprom.NewPrometheusSink()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "%s command failed", cmd))
-		os.Exit(1)
-	}
-	_, err = gmetrics.NewGlobal(gmetrics.DefaultConfig(cmd), sink)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "%s command failed", cmd))
-		os.Exit(1)
-	}
 
 	var g run.Group
 	var tracer opentracing.Tracer
@@ -143,7 +145,7 @@ func main() {
 	{
 		cancel := make(chan struct{})
 		g.Add(func() error {
-			return interrupt(logger, cancel)
+			return interrupt(logger, cancel, *profileDir)
 		}, func(error) {
 			close(cancel)
 		})
@@ -156,18 +158,65 @@ func main() {
 	level.Info(logger).Log("msg", "exiting")
 }
 
-func interrupt(logger log.Logger, cancel <-chan struct{}) error {
+func interrupt(logger log.Logger, cancel <-chan struct{}, profileDir string) error {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case s := <-c:
-		level.Info(logger).Log("msg", "caught signal. Exiting.", "signal", s)
-		return nil
-	case <-cancel:
-		return errors.New("canceled")
+	signal.Notify(c, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, profileSignals...)...)
+	for {
+		select {
+		case s := <-c:
+			if s == syscall.SIGINT || s == syscall.SIGTERM {
+				level.Info(logger).Log("msg", "caught signal. Exiting.", "signal", s)
+				return nil
+			}
+			if profileDir == "" {
+				level.Warn(logger).Log("msg", "caught signal, but no profile directory is set. Ignoring.", "signal", s)
+				continue
+			}
+			level.Info(logger).Log("msg", "caught signal. Dumping profiles.", "signal", s)
+			dumpProfiles(logger, profileDir, time.Now())
+		case <-cancel:
+			return errors.New("canceled")
+		}
+	}
+}
+
+// dumpProfiles writes goroutine and heap profiles to timestamped files in the given directory.
+// It does not depend on the HTTP server, so it works even if that one is stuck.
+func dumpProfiles(logger log.Logger, dir string, now time.Time) {
+	ts := now.UTC().Format("20060102T150405Z")
+
+	for _, p := range []struct {
+		name  string
+		debug int
+	}{
+		// Full stack traces of all goroutines are most useful to find a hanging one.
+		{name: "goroutine", debug: 2},
+		{name: "heap", debug: 0},
+	} {
+		fn := filepath.Join(dir, fmt.Sprintf("%s-%s.pprof", p.name, ts))
+
+		if err := writeProfile(fn, p.name, p.debug); err != nil {
+			level.Error(logger).Log("msg", "writing profile failed", "profile", p.name, "err", err)
+			continue
+		}
+		level.Info(logger).Log("msg", "wrote profile", "profile", p.name, "path", fn)
 	}
 }
 
+func writeProfile(fn string, name string, debug int) (err error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = errors.Wrap(cerr, "close file")
+		}
+	}()
+
+	return errors.Wrap(rpprof.Lookup(name).WriteTo(f, debug), "write profile")
+}
+
 func registerProfile(mux *http.ServeMux) {
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -180,6 +229,26 @@ func registerProfile(mux *http.ServeMux) {
 	mux.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 }
 
+// registerComponentInfo registers build information and start time of the running component. Unlike
+// the version collector, they are labeled with the component, so a fleet of mixed components can be
+// shown on a single dashboard.
+func registerComponentInfo(reg prometheus.Registerer, component string, start time.Time) {
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_component_build_info",
+		Help: "A metric with a constant '1' value labeled by component, version, revision, branch, build date and goversion from which Thanos was built.",
+	}, []string{"component", "version", "revision", "branch", "build_date", "goversion"})
+	buildInfo.WithLabelValues(component, version.Version, version.Revision, version.Branch, version.BuildDate, runtime.Version()).Set(1)
+
+	startTime := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "thanos_component_start_time_seconds",
+		Help:        "Start time of the component since unix epoch in seconds.",
+		ConstLabels: prometheus.Labels{"component": component},
+	})
+	startTime.Set(float64(start.UnixNano()) / 1e9)
+
+	reg.MustRegister(buildInfo, startTime)
+}
+
 func registerMetrics(mux *http.ServeMux, g prometheus.Gatherer) {
 	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
 }
@@ -188,7 +257,13 @@ func registerMetrics(mux *http.ServeMux, g prometheus.Gatherer) {
 // - request histogram
 // - tracing
 // - panic recovery with panic counter
-func defaultGRPCServerOpts(logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer) []grpc.ServerOption {
+// - configured flow control window sizes
+func defaultGRPCServerOpts(logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, windows *grpcWindowSizes) ([]grpc.ServerOption, error) {
+	windowOpts, err := windows.serverOpts()
+	if err != nil {
+		return nil, err
+	}
+
 	met := grpc_prometheus.NewServerMetrics()
 	met.EnableHandlingTimeHistogram(
 		grpc_prometheus.WithHistogramBuckets([]float64{
@@ -206,7 +281,7 @@ func defaultGRPCServerOpts(logger log.Logger, reg *prometheus.Registry, tracer o
 		return status.Errorf(codes.Internal, "%s", p)
 	}
 	reg.MustRegister(met, panicsTotal)
-	return []grpc.ServerOption{
+	opts := []grpc.ServerOption{
 		grpc.MaxSendMsgSize(math.MaxInt32),
 		grpc_middleware.WithUnaryServerChain(
 			met.UnaryServerInterceptor(),
@@ -219,13 +294,45 @@ func defaultGRPCServerOpts(logger log.Logger, reg *prometheus.Registry, tracer o
 			grpc_recovery.StreamServerInterceptor(grpc_recovery.WithRecoveryHandler(grpcPanicRecoveryHandler)),
 		),
 	}
+	return append(opts, windowOpts...), nil
+}
+
+// reflectionProtoFiles maps the protobuf files of the served gRPC services and their imports to the names gogo/protobuf
+// registered them with.
+var reflectionProtoFiles = map[string]string{
+	"rpc.proto":            "rpc.proto",
+	"types.proto":          "types.proto",
+	"gogoproto/gogo.proto": "gogo.proto",
+}
+
+// registerGRPCReflection registers the gRPC reflection service on the server if enabled. The reflection service looks
+// up file descriptors in the golang/protobuf registry, while the generated code registers them with gogo/protobuf, so
+// they are registered with golang/protobuf as well.
+func registerGRPCReflection(logger log.Logger, s *grpc.Server, enabled bool) {
+	if !enabled {
+		return
+	}
+	for name, gogoName := range reflectionProtoFiles {
+		if golang_proto.FileDescriptor(name) != nil {
+			continue
+		}
+		if d := gogo_proto.FileDescriptor(gogoName); d != nil {
+			golang_proto.RegisterFile(name, d)
+		}
+	}
+	reflection.Register(s)
+	level.Info(logger).Log("msg", "gRPC reflection enabled")
 }
 
 // metricHTTPListenGroup is a run.Group that servers HTTP endpoint with only Prometheus metrics.
-func metricHTTPListenGroup(g *run.Group, logger log.Logger, reg *prometheus.Registry, httpBindAddr string) error {
+// Components may register additional handlers on the mux through register.
+func metricHTTPListenGroup(g *run.Group, logger log.Logger, reg *prometheus.Registry, httpBindAddr string, register ...func(*http.ServeMux)) error {
 	mux := http.NewServeMux()
 	registerMetrics(mux, reg)
 	registerProfile(mux)
+	for _, r := range register {
+		r(mux)
+	}
 	l, err := net.Listen("tcp", httpBindAddr)
 	if err != nil {
 		return errors.Wrap(err, "listen metrics address")
@@ -239,3 +346,26 @@ func metricHTTPListenGroup(g *run.Group, logger log.Logger, reg *prometheus.Regi
 	})
 	return nil
 }
+
+// selfScrapeGroup is a run.Group that regularly appends the component's own metrics to its storage. The
+// series get the component as job label and the HTTP address as instance label. A zero interval disables it.
+func selfScrapeGroup(g *run.Group, logger log.Logger, reg *prometheus.Registry, app selfscrape.Appendable, interval time.Duration, component, httpBindAddr string) {
+	if interval == 0 {
+		return
+	}
+	s := selfscrape.New(reg, app, promlabels.FromStrings("job", "thanos-"+component, "instance", httpBindAddr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		level.Info(logger).Log("msg", "scraping own metrics", "interval", interval)
+
+		return runutil.Repeat(interval, ctx.Done(), func() error {
+			if err := s.Scrape(time.Now()); err != nil {
+				level.Warn(logger).Log("msg", "self-scrape failed", "err", err)
+			}
+			return nil
+		})
+	}, func(error) {
+		cancel()
+	})
+}
@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -13,6 +19,7 @@ import (
 	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/compression"
 	"github.com/improbable-eng/thanos/pkg/query"
 	"github.com/improbable-eng/thanos/pkg/query/api"
 	"github.com/improbable-eng/thanos/pkg/query/ui"
@@ -24,6 +31,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/tsdb/labels"
@@ -36,26 +44,124 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 	cmd := app.Command(name, "query node exposing PromQL enabled Query API with data retrieved from multiple store nodes")
 
 	grpcBindAddr, httpBindAddr, newPeerFn := regCommonServerFlags(cmd)
+	grpcWindows := regGRPCWindowFlags(cmd)
+	grpcReflection := regGRPCReflectionFlag(cmd)
+
+	grpcCompression := cmd.Flag("grpc.compression", "Compression of the gRPC messages exchanged with stores: none, snappy or gzip. Compression saves bandwidth at the cost of CPU, e.g. for stores in other regions. Can be overridden for each store with a <compression>:// prefix of its --store address.").
+		Default(compression.None).Enum(compression.Names...)
 
 	httpAdvertiseAddr := cmd.Flag("http-advertise-address", "Explicit (external) host:port address to advertise for HTTP QueryAPI in gossip cluster. If empty, 'http-address' will be used.").
 		String()
 
-	queryTimeout := cmd.Flag("query.timeout", "Maximum time to process query by query node.").
+	queryTimeout := cmd.Flag("query.timeout", "Maximum time to process query by query node. Queries can lower it with the 'timeout' parameter of the HTTP API.").
 		Default("2m").Duration()
 
 	maxConcurrentQueries := cmd.Flag("query.max-concurrent", "Maximum number of queries processed concurrently by query node.").
 		Default("20").Int()
 
+	maxSamples := cmd.Flag("query.max-samples", "Maximum number of samples a single query may hold in memory. A query holds all series it fetched from the store APIs until it is evaluated, so the samples of all its selects count. Queries exceeding it are aborted with an error. Together with --query.max-concurrent it bounds the samples held by query evaluation. 0 disables the limit.").
+		Default("50000000").Int64()
+
 	replicaLabel := cmd.Flag("query.replica-label", "Label to treat as a replica indicator along which data is deduplicated. Still you will be able to query without deduplication using 'dedup=false' parameter.").
 		String()
 
+	defaultDedup := cmd.Flag("query.default-dedup", "Deduplicate queries that do not set the 'dedup' parameter. Requires --query.replica-label to have an effect.").
+		Default("true").Bool()
+
 	selectorLabels := cmd.Flag("selector-label", "Query selector labels that will be exposed in info endpoint (repeated).").
 		PlaceHolder("<name>=\"<value>\"").Strings()
 
-	stores := cmd.Flag("store", "Addresses of statically configured store API servers (repeatable).").
+	stores := cmd.Flag("store", "Addresses of statically configured store API servers (repeatable). Prefix an address with <compression>:// to override --grpc.compression for it.").
 		PlaceHolder("<store>").Strings()
 
+	remoteReadStores := cmd.Flag("store.remote-read", "URLs of Prometheus servers without a sidecar that are queried through their remote read API (repeatable). Their external labels and retention are fetched from the Prometheus HTTP API. Label APIs are not available for them.").
+		PlaceHolder("<url>").URLList()
+
+	federateStores := cmd.Flag("store.federate", "URLs of Prometheus servers without a sidecar whose recent data is read through their /federate endpoint (repeatable). Federation only returns the latest sample of each series, so they only serve the window set by --store.federate-window, older data must be read from other stores. Their external labels are fetched from the Prometheus HTTP API. Experimental, requires --enable-feature=federate-store.").
+		PlaceHolder("<url>").URLList()
+
+	federateWindow := cmd.Flag("store.federate-window", "Window of recent data before now that Prometheus servers of --store.federate advertise. Should be close to the lookback delta of the Prometheus servers.").
+		Default("5m").Duration()
+
+	timeSplitOffset := cmd.Flag("store.time-split-offset", "If set, data older than this offset is read only from stores with a bounded time range like store gateways and newer data only from stores like sidecars. Live stores are paired with historical stores with the same external labels or without any, like store gateways. Must exceed the time it takes until blocks are uploaded. 0 disables the split.").
+		Default("0s").Duration()
+
+	storeResponseTimeout := cmd.Flag("query.store-response-timeout", "If a store does not finish its series response within this time, the query proceeds without the rest of its data and reports it as partial response. Other stores are not affected. 0 disables the timeout.").
+		Default("0s").Duration()
+
+	mergeConcurrency := cmd.Flag("query.merge-concurrency", "Number of goroutines merging the series of all stores of a query. Speeds up queries that fan out to hundreds of stores, at most 10 series are buffered per store and goroutine. 1 merges in a single goroutine.").
+		Default("1").Int()
+
+	requiredStores := cmd.Flag("store.required", "Regular expression matching the addresses of stores without which queries fail instead of returning a partial response (repeatable). Queries fail if any matching store is unhealthy or fails to respond. Other stores still only degrade the response.").
+		PlaceHolder("<regex>").Strings()
+
+	storeUnhealthyTimeout := cmd.Flag("query.store.unhealthy-timeout", "Time after which a store that continuously failed its info checks is dropped and only checked once per timeout, or right away when it is removed from and re-added to the gossip discovered stores. Allows to clean up stores stuck in a half-open connection while static stores still recover. 0 checks unhealthy stores on every update.").
+		Default("0s").Duration()
+
+	storeGossipMetadataMaxAge := cmd.Flag("query.store.gossip-metadata-max-age", "Age after which the gossiped labels and time range of a store are considered stale and are fetched through its Info call instead. The stores propagate them on every gossip push/pull. 0 always uses the gossiped metadata.").
+		Default("1m").Duration()
+
+	storeClockSkewThreshold := cmd.Flag("query.store.clock-skew-threshold", "Difference between the clock of a store and the querier above which a warning is logged. Skewed clocks break deduplication and time range based store selection. The skew of each store is exported as thanos_query_store_clock_skew_seconds. 0 disables the warnings.").
+		Default("30s").Duration()
+
+	tenantHeader := cmd.Flag("query.tenant-header", "HTTP header determining the tenant of queries for the per-tenant limits.").
+		Default("THANOS-TENANT").String()
+
+	defaultTenant := cmd.Flag("query.default-tenant-id", "Tenant of queries without a tenant header.").
+		Default("default-tenant").String()
+
+	tenantLabel := cmd.Flag("query.tenant-label", "External label holding the tenant of stores. If set, queries of a tenant, as determined by --query.tenant-header, are only sent to stores whose label matches the tenant and stores without the label, which serve data of all tenants. Requests to the gRPC StoreAPI of the querier are sent to all stores.").
+		PlaceHolder("<name>").String()
+
+	tenantMaxConcurrent := cmd.Flag("query.tenant-max-concurrent", "Maximum number of queries of a single tenant processed concurrently. Further queries are rejected with 429. 0 disables the limit.").
+		Default("0").Int()
+
+	tenantRateLimit := cmd.Flag("query.tenant-rate-limit", "Maximum number of queries per second a single tenant may start. Bursts of up to one second worth of queries are allowed, further queries are rejected with 429. 0 disables the limit.").
+		Default("0").Float64()
+
+	tenantMaxSamples := cmd.Flag("query.tenant-max-samples", "Maximum number of samples a single query of a tenant may fetch from the store APIs. Queries exceeding it are rejected with 429. 0 disables the limit.").
+		Default("0").Int64()
+
+	tenantMaxTenants := cmd.Flag("query.tenant-max-tenants", "Maximum number of tenants tracked at once for the per-tenant limits and metrics. Tenants are only tracked if any other per-tenant limit is set and are forgotten after 10m without queries. Queries of further tenants are rejected with 429. 0 disables the limit.").
+		Default("1000").Int()
+
+	resourceHeaders := cmd.Flag("query.resource-headers", "Report the samples scanned, series touched, bytes fetched from object storage and wall time of each query in X-Thanos-* response headers of the query APIs.").
+		Default("false").Bool()
+
+	seriesHints := cmd.Flag("query.series-hints", "Ask stores to estimate the number of series and chunks of their responses up front. Store gateways answer with estimates from the postings of their blocks before fetching any series, which are used to size buffers. Other stores ignore the request.").
+		Default("false").Bool()
+
+	accessLog := cmd.Flag("web.access-log", "Log every request to the query API with its method, path, status, duration, remote address and query parameters as a JSON line, e.g. for auditing.").
+		Default("false").Bool()
+
+	accessLogFile := cmd.Flag("web.access-log-file", "File to append the access log to. Defaults to stderr, alongside the main log.").
+		PlaceHolder("<path>").String()
+
+	queryShards := cmd.Flag("query.shards", "Number of shards aggregations that group by labels are evaluated in concurrently, if the query-sharding feature is enabled. Stores that support sharding only return the series of the requested shard, the querier filters the series of other stores.").
+		Default("4").Int()
+
+	defaultStep := cmd.Flag("query.default-step", "Step of range queries without a step parameter. If 0, the step parameter is required.").
+		Default("0s").Duration()
+
+	maxPoints := cmd.Flag("query.max-points-per-series", "If positive, the step of range queries that would return more points per series is increased to return at most this many, and a warning is returned. Queries with more than 11000 points per series are rejected regardless.").
+		Default("0").Int()
+
+	maxEstimatedSeries := cmd.Flag("query.max-estimated-series", "Maximum number of series a query may select, estimated before executing it from the series hints of the stores. Queries estimated to exceed it are rejected with a 422 before any data is fetched. Only stores that estimate their series from their index, like store gateways, count towards the estimate. 0 disables the estimate.").
+		Default("0").Int64()
+
+	enableFeatures := cmd.Flag("enable-feature", "Comma separated names of experimental features to enable (repeatable). Unknown features are ignored with a warning. See the docs for the available features.").
+		PlaceHolder("<feature>").Strings()
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
+		features, unknown := query.ParseFeatures(*enableFeatures)
+		for _, f := range unknown {
+			level.Warn(logger).Log("msg", "ignoring unknown feature", "feature", f)
+		}
+
+		if len(*federateStores) > 0 && !features.FederateStore {
+			return errors.Errorf("--store.federate requires --enable-feature=%s", query.FeatureFederateStore)
+		}
+
 		peer, err := newPeerFn(logger, reg, true, *httpAdvertiseAddr, true)
 		if err != nil {
 			return errors.Wrap(err, "new cluster peer")
@@ -65,6 +171,11 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 			return errors.Wrap(err, "parse federation labels")
 		}
 
+		required, err := store.NewRequiredStores(*requiredStores)
+		if err != nil {
+			return errors.Wrap(err, "parse required stores")
+		}
+
 		lookupStores := map[string]struct{}{}
 		for _, s := range *stores {
 			if _, ok := lookupStores[s]; ok {
@@ -80,18 +191,56 @@ func registerQuery(m map[string]setupFunc, app *kingpin.Application, name string
 			reg,
 			tracer,
 			*grpcBindAddr,
+			grpcWindows,
+			*grpcReflection,
+			*grpcCompression,
 			*httpBindAddr,
 			*maxConcurrentQueries,
+			*maxSamples,
 			*queryTimeout,
 			*replicaLabel,
+			*defaultDedup,
 			peer,
 			selectorLset,
 			*stores,
+			*remoteReadStores,
+			*federateStores,
+			*federateWindow,
+			*timeSplitOffset,
+			*storeResponseTimeout,
+			*mergeConcurrency,
+			required,
+			*storeUnhealthyTimeout,
+			*storeGossipMetadataMaxAge,
+			*storeClockSkewThreshold,
+			*tenantLabel,
+			v1.TenantLimits{
+				Header:           *tenantHeader,
+				DefaultTenant:    *defaultTenant,
+				MaxConcurrent:    *tenantMaxConcurrent,
+				QueriesPerSecond: *tenantRateLimit,
+				MaxSamples:       *tenantMaxSamples,
+				MaxTenants:       *tenantMaxTenants,
+			},
+			*resourceHeaders,
+			*seriesHints,
+			*accessLog,
+			*accessLogFile,
+			features,
+			*queryShards,
+			*defaultStep,
+			*maxPoints,
+			*maxEstimatedSeries,
 		)
 	}
 }
 
-func storeClientGRPCOpts(reg *prometheus.Registry, tracer opentracing.Tracer) []grpc.DialOption {
+func storeClientGRPCOpts(reg *prometheus.Registry, tracer opentracing.Tracer, windows *grpcWindowSizes, grpcCompression string) ([]grpc.DialOption, error) {
+	windowOpts, err := windows.dialOpts()
+	if err != nil {
+		return nil, err
+	}
+
 	grpcMets := grpc_prometheus.NewClientMetrics()
 	grpcMets.EnableClientHandlingTimeHistogram(
 		grpc_prometheus.WithHistogramBuckets([]float64{
@@ -119,11 +268,15 @@ func storeClientGRPCOpts(reg *prometheus.Registry, tracer opentracing.Tracer) []
 		),
 	}
 
+	if grpcCompression != compression.None {
+		dialOpts = append(dialOpts, compression.DialOption(grpcCompression))
+	}
+
 	if reg != nil {
 		reg.MustRegister(grpcMets)
 	}
 
-	return dialOpts
+	return append(dialOpts, windowOpts...), nil
 }
 
 // runQuery starts a server that exposes PromQL Query API. It is responsible for querying configured
@@ -134,13 +287,39 @@ func runQuery(
 	reg *prometheus.Registry,
 	tracer opentracing.Tracer,
 	grpcBindAddr string,
+	grpcWindows *grpcWindowSizes,
+	grpcReflection bool,
+	grpcCompression string,
 	httpBindAddr string,
 	maxConcurrentQueries int,
+	maxSamples int64,
 	queryTimeout time.Duration,
 	replicaLabel string,
+	defaultDedup bool,
 	peer *cluster.Peer,
 	selectorLset labels.Labels,
 	storeAddrs []string,
+	remoteReadURLs []*url.URL,
+	federateURLs []*url.URL,
+	federateWindow time.Duration,
+	timeSplitOffset time.Duration,
+	storeResponseTimeout time.Duration,
+	mergeConcurrency int,
+	requiredStores store.RequiredStores,
+	storeUnhealthyTimeout time.Duration,
+	storeGossipMetadataMaxAge time.Duration,
+	storeClockSkewThreshold time.Duration,
+	tenantLabel string,
+	tenantLimits v1.TenantLimits,
+	resourceHeaders bool,
+	seriesHints bool,
+	accessLog bool,
+	accessLogFile string,
+	features query.Features,
+	queryShards int,
+	defaultStep time.Duration,
+	maxPoints int,
+	maxEstimatedSeries int64,
 ) error {
 	var staticSpecs []query.StoreSpec
 	for _, addr := range storeAddrs {
@@ -148,7 +327,37 @@ func runQuery(
 			return errors.New("static store address cannot be empty")
 		}
 
-		staticSpecs = append(staticSpecs, query.NewGRPCStoreSpec(addr))
+		c, addr, err := compression.SplitAddr(addr)
+		if err != nil {
+			return errors.Wrap(err, "parse static store address")
+		}
+		staticSpecs = append(staticSpecs, query.NewGRPCStoreSpec(addr, c))
+	}
+	dialOpts, err := storeClientGRPCOpts(reg, tracer, grpcWindows, grpcCompression)
+	if err != nil {
+		return errors.Wrap(err, "gRPC dial options")
+	}
+	var remoteReadClients []*store.RemoteReadClient
+	for _, u := range remoteReadURLs {
+		c, err := store.NewRemoteReadClient(logger, nil, u)
+		if err != nil {
+			return errors.Wrapf(err, "create remote read store for %s", u)
+		}
+		remoteReadClients = append(remoteReadClients, c)
+	}
+	gossipMetadataFallbacks := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_gossip_metadata_fallbacks_total",
+		Help: "Total number of metadata updates of gossip discovered stores that used the Info call, as the gossiped metadata was stale.",
+	})
+	reg.MustRegister(gossipMetadataFallbacks)
+
+	var federateClients []*store.FederateClient
+	for _, u := range federateURLs {
+		c, err := store.NewFederateClient(logger, nil, u, federateWindow)
+		if err != nil {
+			return errors.Wrapf(err, "create federate store for %s", u)
+		}
+		federateClients = append(federateClients, c)
 	}
 	var (
 		stores = query.NewStoreSet(
@@ -163,16 +372,40 @@ func runQuery(
 						continue
 					}
 
-					specs = append(specs, &gossipSpec{id: id, addr: ps.StoreAPIAddr, peer: peer})
+					specs = append(specs, &gossipSpec{id: id, addr: ps.StoreAPIAddr, peer: peer, maxAge: storeGossipMetadataMaxAge, fallbacks: gossipMetadataFallbacks})
 				}
 				return specs
 			},
-			storeClientGRPCOpts(reg, tracer),
+			dialOpts,
+			storeUnhealthyTimeout,
+			storeClockSkewThreshold,
 		)
-		proxy = store.NewProxyStore(logger, func(context.Context) ([]store.Client, error) {
-			return stores.Get(), nil
-		}, selectorLset)
-		queryableCreator = query.NewQueryableCreator(logger, proxy, replicaLabel)
+		proxyStores = func(context.Context) ([]store.Client, error) {
+			if unavailable := requiredStores.Filter(stores.Unhealthy()); len(unavailable) > 0 {
+				return nil, errors.Errorf("required stores unavailable: %s", strings.Join(unavailable, ", "))
+			}
+			clients := stores.Get()
+			for _, c := range remoteReadClients {
+				clients = append(clients, c)
+			}
+			for _, c := range federateClients {
+				clients = append(clients, c)
+			}
+			return clients, nil
+		}
+	)
+	if tenantLabel != "" {
+		proxyStores = store.TenantStores(reg, tenantLabel, proxyStores)
+	}
+	proxyOpts := store.ProxyStoreOptions{
+		TimeSplitOffset:  timeSplitOffset,
+		ResponseTimeout:  storeResponseTimeout,
+		RequiredStores:   requiredStores,
+		MergeConcurrency: mergeConcurrency,
+	}
+	var (
+		proxy            = store.NewProxyStore(logger, reg, proxyStores, selectorLset, proxyOpts)
+		queryableCreator = query.NewQueryableCreator(logger, reg, proxy, replicaLabel, seriesHints, maxSamples)
 		engine           = promql.NewEngine(logger, reg, maxConcurrentQueries, queryTimeout)
 	)
 	// Periodically update the store set with the addresses we see in our cluster.
@@ -188,6 +421,48 @@ func runQuery(
 			stores.Close()
 		})
 	}
+	// Periodically update external labels and time ranges of remote read stores.
+	if len(remoteReadClients) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
+				for i, c := range remoteReadClients {
+					iterCtx, iterCancel := context.WithTimeout(ctx, 5*time.Second)
+					err := updateRemoteReadClient(iterCtx, logger, c, remoteReadURLs[i])
+					iterCancel()
+
+					if err != nil {
+						level.Warn(logger).Log("msg", "update remote read store metadata failed", "store", c, "err", err)
+					}
+				}
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
+	// Periodically update external labels of federate stores.
+	if len(federateClients) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
+				for i, c := range federateClients {
+					iterCtx, iterCancel := context.WithTimeout(ctx, 5*time.Second)
+					lset, err := queryExternalLabels(iterCtx, logger, federateURLs[i])
+					iterCancel()
+
+					if err != nil {
+						level.Warn(logger).Log("msg", "update federate store external labels failed", "store", c, "err", err)
+						continue
+					}
+					c.Update(lset)
+				}
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
 	{
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
@@ -208,7 +483,24 @@ func runQuery(
 		router := route.New()
 		ui.New(logger, nil).Register(router)
 
-		api := v1.NewAPI(reg, engine, queryableCreator)
+		var accessLogger log.Logger
+		if accessLog {
+			w := io.Writer(os.Stderr)
+			if accessLogFile != "" {
+				f, err := os.OpenFile(accessLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+				if err != nil {
+					return errors.Wrap(err, "open access log file")
+				}
+				w = f
+			}
+			accessLogger = log.With(log.NewJSONLogger(log.NewSyncWriter(w)), "ts", log.DefaultTimestampUTC)
+		}
+
+		var estimator *query.SeriesEstimator
+		if maxEstimatedSeries > 0 {
+			estimator = query.NewSeriesEstimator(logger, reg, proxy, maxEstimatedSeries)
+		}
+		api := v1.NewAPI(reg, engine, queryableCreator, proxy, defaultDedup, tenantLimits, resourceHeaders, accessLogger, features, queryShards, defaultStep, maxPoints, estimator)
 		api.Register(router.WithPrefix("/api/v1"), tracer, logger)
 
 		mux := http.NewServeMux()
@@ -236,8 +528,13 @@ func runQuery(
 		}
 		logger := log.With(logger, "component", "query")
 
-		s := grpc.NewServer(defaultGRPCServerOpts(logger, reg, tracer)...)
-		storepb.RegisterStoreServer(s, proxy)
+		opts, err := defaultGRPCServerOpts(logger, reg, tracer, grpcWindows)
+		if err != nil {
+			return errors.Wrap(err, "gRPC server options")
+		}
+		s := grpc.NewServer(opts...)
+		storepb.RegisterStoreServer(s, query.NewDedupStore(proxy, replicaLabel))
+		registerGRPCReflection(logger, s, grpcReflection)
 
 		g.Add(func() error {
 			level.Info(logger).Log("msg", "Listening for StoreAPI gRPC", "address", grpcBindAddr)
@@ -248,7 +545,7 @@ func runQuery(
 		})
 	}
 
-	level.Info(logger).Log("msg", "starting query node")
+	level.Info(logger).Log("msg", "starting query node", "features", fmt.Sprintf("%+v", features))
 	return nil
 }
 
@@ -256,18 +553,74 @@ type gossipSpec struct {
 	id   string
 	addr string
 
-	peer *cluster.Peer
+	peer      *cluster.Peer
+	maxAge    time.Duration
+	fallbacks prometheus.Counter
 }
 
 func (s *gossipSpec) Addr() string {
 	return s.addr
 }
 
-// Metadata method for gossip store tries get current peer state.
-func (s *gossipSpec) Metadata(_ context.Context, _ storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
+// Compression returns an empty string, gossip peers use the default compression.
+func (s *gossipSpec) Compression() string {
+	return ""
+}
+
+// Metadata method for gossip store tries get current peer state. If the state is older than the max age,
+// e.g. because the peer runs a version that does not refresh it, the metadata are fetched through the Info call.
+func (s *gossipSpec) Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
 	state, ok := s.peer.PeerState(s.id)
 	if !ok {
 		return nil, 0, 0, errors.Errorf("peer %s is no longer in gossip cluster", s.id)
 	}
+	if s.maxAge > 0 && time.Since(time.Unix(0, state.Updated*int64(time.Millisecond))) > s.maxAge {
+		s.fallbacks.Inc()
+		return query.NewGRPCStoreSpec(s.addr, "").Metadata(ctx, client)
+	}
 	return state.Metadata.Labels, state.Metadata.MinTime, state.Metadata.MaxTime, nil
 }
+
+// updateRemoteReadClient sets the external labels of the client's Prometheus server and
+// a time range starting at the oldest data its retention allows.
+func updateRemoteReadClient(ctx context.Context, logger log.Logger, c *store.RemoteReadClient, base *url.URL) error {
+	lset, err := queryExternalLabels(ctx, logger, base)
+	if err != nil {
+		return errors.Wrap(err, "query external labels")
+	}
+	retention, err := queryRetention(ctx, logger, base)
+	if err != nil {
+		return errors.Wrap(err, "query retention")
+	}
+	mint := time.Now().Add(-retention).UnixNano() / int64(time.Millisecond)
+
+	c.Update(lset, mint, math.MaxInt64)
+	return nil
+}
+
+func queryRetention(ctx context.Context, logger log.Logger, base *url.URL) (time.Duration, error) {
+	u := *base
+	u.Path = path.Join(u.Path, "/api/v1/status/flags")
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "create request")
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, errors.Wrapf(err, "request flags against %s", u.String())
+	}
+	defer runutil.LogOnErr(logger, resp.Body, "query body")
+
+	var d struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return 0, errors.Wrap(err, "decode response")
+	}
+	retention, err := model.ParseDuration(d.Data["storage.tsdb.retention"])
+	if err != nil {
+		return 0, errors.Wrap(err, "parse retention flag")
+	}
+	return time.Duration(retention), nil
+}
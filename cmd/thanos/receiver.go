@@ -1,11 +1,35 @@
 package main
 
 import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
+	"github.com/improbable-eng/thanos/pkg/objstore/s3"
+	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/query/api"
+	"github.com/improbable-eng/thanos/pkg/receive"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/oklog/run"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage/tsdb"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -13,23 +37,141 @@ func registerReceiver(m map[string]setupFunc, app *kingpin.Application, name str
 
 	cmd := app.Command(name, "receiver node exposing URL For  Receive Collector Push Metric")
 	grpcBindAddr, httpBindAddr, newPeerFn := regCommonServerFlags(cmd)
+	grpcWindows := regGRPCWindowFlags(cmd)
+	grpcReflection := regGRPCReflectionFlag(cmd)
+	selfScrapeInterval := regSelfScrapeFlag(cmd)
 	httpReceiverAddr := cmd.Flag("http-receiver-address", "Explicit (external) host:port address to receiver for HTTP Post in gossip cluster.").
 		String()
 
 	dataDir := cmd.Flag("tsdb.path", "Data directory of TSDB.").
 		Default("./data").String()
 
+	labelStrs := cmd.Flag("receive.external-label", "External label to set on all received series, overwriting the value sent by the client (repeated).").
+		PlaceHolder("<name>=\"<value>\"").Strings()
+
+	requestLimit := cmd.Flag("receive.request-limit", "Maximum uncompressed size of a single write request. Larger requests are rejected. 0 disables the limit.").
+		Default("32MB").Bytes()
+	seriesLimit := cmd.Flag("receive.series-limit", "Maximum number of series in a single write request. 0 disables the limit.").
+		Default("0").Int()
+	samplesLimit := cmd.Flag("receive.samples-limit", "Maximum number of samples in a single write request. 0 disables the limit.").
+		Default("0").Int()
+
+	var seriesLimits receive.SeriesLimits
+	cmd.Flag("receive.tenant-max-active-series", "Maximum number of series in the head of the TSDB of a tenant. New series of tenants at the limit are rejected with status code 429, samples of existing series are still written. 0 disables the limit.").
+		Default("0").IntVar(&seriesLimits.MaxActiveSeries)
+	cmd.Flag("receive.tenant-new-series-per-second", "Rate at which a tenant may create new series, allowing bursts of a minute worth of series. New series of tenants exceeding it are rejected with status code 429, samples of existing series are still written. 0 disables the limit.").
+		Default("0").Float64Var(&seriesLimits.NewSeriesPerSecond)
+
+	tenantHeader := cmd.Flag("receive.tenant-header", "HTTP header determining the tenant for write requests.").
+		Default("THANOS-TENANT").String()
+	defaultTenant := cmd.Flag("receive.default-tenant-id", "Tenant of write requests without a tenant header.").
+		Default("default-tenant").String()
+	tenantLabelName := cmd.Flag("receive.tenant-label-name", "External label name identifying the tenant of uploaded blocks and series returned by the Store API.").
+		Default("tenant_id").String()
+
+	enableFlush := cmd.Flag("receive.enable-flush-endpoint", "Enable the /api/v1/flush HTTP endpoint. A POST request to it cuts a block from the head of all tenants, or of the tenant given in the tenant parameter, and truncates their write ahead logs. Writes of the flushed tenants are blocked meanwhile.").
+		Default("false").Bool()
+
+	enableLocalQuery := cmd.Flag("receive.enable-local-query", "Expose the Prometheus query API under /api/v1 over the local TSDBs. Queries only read the data of the tenant set in the tenant header and are subject to the query limits.").
+		Default("false").Bool()
+
+	queryTimeout := cmd.Flag("query.timeout", "Maximum time to process a local query.").
+		Default("2m").Duration()
+
+	maxConcurrentQueries := cmd.Flag("query.max-concurrent", "Maximum number of local queries processed concurrently.").
+		Default("20").Int()
+
+	tenantMaxConcurrent := cmd.Flag("query.tenant-max-concurrent", "Maximum number of local queries of a single tenant processed concurrently. Further queries are rejected with 429. 0 disables the limit.").
+		Default("0").Int()
+
+	tenantRateLimit := cmd.Flag("query.tenant-rate-limit", "Maximum number of local queries per second a single tenant may start. Bursts of up to one second worth of queries are allowed, further queries are rejected with 429. 0 disables the limit.").
+		Default("0").Float64()
+
+	tenantMaxSamples := cmd.Flag("query.tenant-max-samples", "Maximum number of samples a single local query of a tenant may fetch. Queries exceeding it are rejected with 429. 0 disables the limit.").
+		Default("0").Int64()
+
+	tenantMaxTenants := cmd.Flag("query.tenant-max-tenants", "Maximum number of tenants tracked at once for the limits and metrics of local queries. Tenants are only tracked if any other tenant limit is set and are forgotten after 10m without queries. Queries of further tenants are rejected with 429. 0 disables the limit.").
+		Default("1000").Int()
+
+	retention := cmd.Flag("receive.tsdb.retention", "How long to keep blocks on local disk. If a bucket is configured, blocks are only deleted once they were uploaded, so blocks failing to upload are kept beyond it.").
+		Default("360h").Duration()
+
+	maxTenants := cmd.Flag("receive.max-tenants", "Maximum number of tenants, each of which has its own TSDB. Write requests of further tenants are rejected with 429. Tenants with a TSDB on local disk are always opened on startup. 0 disables the limit.").
+		Default("100").Int()
+
+	minBlockDuration := cmd.Flag("receive.tsdb.min-block-duration", "Duration of the blocks cut from the head. Shorter blocks reduce the memory of the head and the time to replay the write ahead log.").
+		Default("2h").Duration()
+
+	maxBlockDuration := cmd.Flag("receive.tsdb.max-block-duration", "Maximum duration of blocks compacted locally. Keep it equal to the minimum block duration if blocks are uploaded, as the compactor is responsible for compacting them.").
+		Default("2h").Duration()
+
+	gcsBucket := cmd.Flag("gcs.bucket", "Google Cloud Storage bucket name for stored blocks. If empty, receiver won't store any block inside Google Cloud Storage.").
+		PlaceHolder("<bucket>").String()
+
+	s3Config := s3.RegisterS3Params(cmd)
+
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, debugLogging bool) error {
+		lset, err := parseFlagLabels(*labelStrs)
+		if err != nil {
+			return errors.Wrap(err, "parse external labels")
+		}
 		peer, err := newPeerFn(logger, reg, false, "", false)
 		if err != nil {
 			return errors.Wrap(err, "new cluster peer")
 		}
+		if lset.Get(*tenantLabelName) != "" {
+			return errors.Errorf("external labels must not contain the tenant label %q", *tenantLabelName)
+		}
+		if *minBlockDuration > *maxBlockDuration {
+			return errors.Errorf("minimum block duration %s must not exceed the maximum block duration %s", *minBlockDuration, *maxBlockDuration)
+		}
+		tsdbOpts := &tsdb.Options{
+			MinBlockDuration: model.Duration(*minBlockDuration),
+			MaxBlockDuration: model.Duration(*maxBlockDuration),
+			Retention:        model.Duration(*retention),
+			NoLockfile:       true,
+			WALFlushInterval: 30 * time.Second,
+		}
 		return runReceiver(g,
 			logger,
 			reg,
 			tracer,
 			*dataDir,
+			tsdbOpts,
+			lset,
+			receiverConfig{
+				requestLimit:         int64(*requestLimit),
+				seriesLimit:          *seriesLimit,
+				samplesLimit:         *samplesLimit,
+				seriesLimits:         seriesLimits,
+				tenantHeader:         *tenantHeader,
+				defaultTenant:        *defaultTenant,
+				tenantLabelName:      *tenantLabelName,
+				maxTenants:           *maxTenants,
+				enableFlush:          *enableFlush,
+				enableLocalQuery:     *enableLocalQuery,
+				queryTimeout:         *queryTimeout,
+				maxConcurrentQueries: *maxConcurrentQueries,
+				queryLimits: v1.TenantLimits{
+					Header:           *tenantHeader,
+					DefaultTenant:    *defaultTenant,
+					MaxConcurrent:    *tenantMaxConcurrent,
+					QueriesPerSecond: *tenantRateLimit,
+					MaxSamples:       *tenantMaxSamples,
+					MaxTenants:       *tenantMaxTenants,
+				},
+				selfScrapeInterval: *selfScrapeInterval,
+			},
+			*gcsBucket,
+			s3Config,
+			fsConfig,
+			rateLimits,
 			*grpcBindAddr,
+			grpcWindows,
+			*grpcReflection,
 			*httpBindAddr,
 			*httpReceiverAddr,
 			peer,
@@ -39,3 +181,207 @@ func registerReceiver(m map[string]setupFunc, app *kingpin.Application, name str
 	}
 
 }
+
+// receiverConfig holds the write limits, tenancy and local query options of the receiver, see the flags of the same names.
+type receiverConfig struct {
+	requestLimit         int64
+	seriesLimit          int
+	samplesLimit         int
+	seriesLimits         receive.SeriesLimits
+	tenantHeader         string
+	defaultTenant        string
+	tenantLabelName      string
+	maxTenants           int
+	enableFlush          bool
+	enableLocalQuery     bool
+	queryTimeout         time.Duration
+	maxConcurrentQueries int
+	queryLimits          v1.TenantLimits
+	selfScrapeInterval   time.Duration
+}
+
+// runReceiver runs a component that accepts Prometheus remote write requests, writes the samples
+// into a local TSDB and exposes them through the Store API.
+func runReceiver(
+	g *run.Group,
+	logger log.Logger,
+	reg *prometheus.Registry,
+	tracer opentracing.Tracer,
+	dataDir string,
+	tsdbOpts *tsdb.Options,
+	lset labels.Labels,
+	conf receiverConfig,
+	gcsBucket string,
+	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
+	grpcBindAddr string,
+	grpcWindows *grpcWindowSizes,
+	grpcReflection bool,
+	httpBindAddr string,
+	httpReceiverAddr string,
+	peer *cluster.Peer,
+	component string,
+	debugLogging bool,
+) error {
+	// Uploads to Google Cloud Storage or an S3-compatible storage service are optional.
+	bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
+	if err != nil && err != client.ErrNotFound {
+		return err
+	}
+	uploads := err != client.ErrNotFound
+	if !uploads {
+		level.Info(logger).Log("msg", "No GCS or S3 bucket was configured, uploads will be disabled")
+		bkt = nil
+	} else {
+		bkt = objstore.NewRateLimiter(reg, *rateLimits).Bucket(bkt)
+	}
+
+	// Each tenant has its own TSDB. The TSDBs always log received samples to their write ahead
+	// logs, which are replayed when they are opened on startup.
+	dbs := receive.NewMultiTSDB(
+		log.With(logger, "component", "multi-tsdb"),
+		reg,
+		dataDir,
+		tsdbOpts,
+		lset,
+		conf.tenantLabelName,
+		bkt,
+		conf.maxTenants,
+	)
+	if err := dbs.Open(); err != nil {
+		return errors.Wrap(err, "open TSDBs")
+	}
+	{
+		done := make(chan struct{})
+		g.Add(func() error {
+			<-done
+			return dbs.Close()
+		}, func(error) {
+			close(done)
+		})
+	}
+	if conf.selfScrapeInterval > 0 {
+		// The metrics are stored as the ones of the default tenant.
+		app, err := dbs.TenantAppendable(conf.defaultTenant)
+		if err != nil {
+			return errors.Wrap(err, "open TSDB of default tenant")
+		}
+		selfScrapeGroup(g, log.With(logger, "component", "self-scrape"), reg, app, conf.selfScrapeInterval, component, httpBindAddr)
+	}
+	{
+		var storeLset []storepb.Label
+		for _, l := range lset {
+			storeLset = append(storeLset, storepb.Label{Name: l.Name, Value: l.Value})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			if err = peer.Join(cluster.PeerTypeSource, cluster.PeerMetadata{
+				Labels:  storeLset,
+				MinTime: 0,
+				MaxTime: math.MaxInt64,
+			}); err != nil {
+				return errors.Wrap(err, "join cluster")
+			}
+
+			<-ctx.Done()
+			return nil
+		}, func(error) {
+			cancel()
+			peer.Close(5 * time.Second)
+		})
+	}
+
+	// Start HTTP and gRPC servers.
+	{
+		l, err := net.Listen("tcp", grpcBindAddr)
+		if err != nil {
+			return errors.Wrap(err, "listen API address")
+		}
+		logger := log.With(logger, "component", "store")
+
+		store := store.NewProxyStore(logger, reg, dbs.StoreClients, lset, store.ProxyStoreOptions{})
+
+		opts, err := defaultGRPCServerOpts(logger, reg, tracer, grpcWindows)
+		if err != nil {
+			return errors.Wrap(err, "gRPC server options")
+		}
+		s := grpc.NewServer(opts...)
+		storepb.RegisterStoreServer(s, store)
+		registerGRPCReflection(logger, s, grpcReflection)
+
+		g.Add(func() error {
+			return errors.Wrap(s.Serve(l), "serve gRPC")
+		}, func(error) {
+			s.Stop()
+			runutil.LogOnErr(logger, l, "store gRPC listener")
+		})
+	}
+	{
+		mux := http.NewServeMux()
+		registerMetrics(mux, reg)
+		registerProfile(mux)
+		mux.Handle("/api/v1/receive", receive.NewHandler(
+			log.With(logger, "component", "receive-handler"),
+			reg,
+			dbs,
+			conf.tenantHeader,
+			conf.defaultTenant,
+			labelsTSDBToProm(lset),
+			conf.requestLimit,
+			conf.seriesLimit,
+			conf.samplesLimit,
+			conf.seriesLimits,
+		))
+		if conf.enableFlush {
+			mux.Handle("/api/v1/flush", receive.NewFlushHandler(log.With(logger, "component", "flush"), dbs))
+		}
+		if conf.enableLocalQuery {
+			logger := log.With(logger, "component", "query")
+
+			// Queries read through a proxy of their tenant's TSDB only. It is not registered, as its
+			// metrics would collide with the ones of the Store API.
+			proxy := store.NewProxyStore(logger, nil, dbs.TenantStoreClients, lset, store.ProxyStoreOptions{})
+			engine := promql.NewEngine(logger, reg, conf.maxConcurrentQueries, conf.queryTimeout)
+			// Received series have no replica label, so there is nothing to deduplicate.
+			queryableCreator := query.NewQueryableCreator(logger, reg, proxy, "", false, 0)
+
+			router := route.New()
+			api := v1.NewAPI(reg, engine, queryableCreator, proxy, false, conf.queryLimits, false, nil, query.Features{}, 0, 0, 0, nil)
+			api.Register(router.WithPrefix("/api/v1"), tracer, logger)
+
+			mux.Handle("/api/v1/", receive.NewQueryHandler(router, conf.queryLimits.Header, conf.queryLimits.DefaultTenant))
+		}
+
+		l, err := net.Listen("tcp", httpBindAddr)
+		if err != nil {
+			return errors.Wrap(err, "listen HTTP address")
+		}
+		g.Add(func() error {
+			level.Info(logger).Log("msg", "Listening for remote write requests and metrics", "address", httpBindAddr)
+			return errors.Wrap(http.Serve(l, mux), "serve HTTP")
+		}, func(error) {
+			runutil.LogOnErr(logger, l, "HTTP listener")
+		})
+	}
+
+	if uploads {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		g.Add(func() error {
+			defer runutil.LogOnErr(logger, bkt, "bucket client")
+
+			// The TSDBs cut new blocks regularly, which are uploaded like the sidecar does for Prometheus.
+			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
+				dbs.Sync(ctx)
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	level.Info(logger).Log("msg", "starting receiver", "peer", peer.Name())
+	return nil
+}
@@ -24,7 +24,9 @@ import (
 	"github.com/improbable-eng/thanos/pkg/alert"
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/shipper"
@@ -36,6 +38,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
 	promlabels "github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/rules"
@@ -51,8 +54,11 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 	cmd := app.Command(name, "ruler evaluating Prometheus rules against given Query nodes, exposing Store API and storing old blocks in bucket")
 
 	grpcBindAddr, httpBindAddr, newPeerFn := regCommonServerFlags(cmd)
+	grpcWindows := regGRPCWindowFlags(cmd)
+	grpcReflection := regGRPCReflectionFlag(cmd)
+	selfScrapeInterval := regSelfScrapeFlag(cmd)
 
-	labelStrs := cmd.Flag("label", "Labels to be applied to all generated metrics (repeated).").
+	labelStrs := cmd.Flag("label", "Labels to be applied to all generated metrics and alerts (repeated).").
 		PlaceHolder("<name>=\"<value>\"").Strings()
 
 	dataDir := cmd.Flag("data-dir", "data directory").Default("data/").String()
@@ -66,10 +72,24 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 		Default("2h").Duration()
 	tsdbRetention := cmd.Flag("tsdb.retention", "Block retention time on local disk.").
 		Default("48h").Duration()
+	tsdbDeleteUploadedAfter := cmd.Flag("tsdb.delete-uploaded-after", "Delete blocks from local disk once they were uploaded and all their data is older than this duration, instead of keeping them until the retention. Data of deleted blocks is only served by store gateways. 0 disables the deletion.").
+		Default("0s").Duration()
 
 	alertmgrs := cmd.Flag("alertmanagers.url", "Alertmanager URLs to push firing alerts to. The scheme may be prefixed with 'dns+' or 'dnssrv+' to detect Alertmanager IPs through respective DNS lookups. The port defaults to 9093 or the SRV record's value. The URL path is used as a prefix for the regular Alertmanager API path.").
 		Strings()
 
+	alertmgrsRetries := cmd.Flag("alertmanagers.notification-retries", "Number of times a failed request to send alerts to an Alertmanager is retried with an exponential backoff.").
+		Default("3").Int()
+
+	alertmgrsTimeout := cmd.Flag("alertmanagers.send-timeout", "Timeout for a single request to send alerts to an Alertmanager. 0 disables the timeout.").
+		Default("10s").Duration()
+
+	alertQueueCapacity := cmd.Flag("alert.queue-capacity", "Maximum number of alerts buffered for sending. Alerts that could not be delivered to any Alertmanager are kept and sent again once an Alertmanager is reachable. Alerts are only dropped if the queue overflows.").
+		Default("10000").Int()
+
+	alertRelabelConfigFile := cmd.Flag("alert.relabel-config-file", "Path to a YAML file with a list of relabel configs applied to the labels of all alerts, after attaching the --label labels and before sending them to Alertmanager. Uses the format of Prometheus' alert_relabel_configs.").
+		PlaceHolder("<path>").String()
+
 	gcsBucket := cmd.Flag("gcs.bucket", "Google Cloud Storage bucket name for stored blocks. If empty, ruler won't store any block inside Google Cloud Storage.").
 		PlaceHolder("<bucket>").String()
 
@@ -77,6 +97,9 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 
 	s3Config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ bool) error {
 		lset, err := parseFlagLabels(*labelStrs)
 		if err != nil {
@@ -90,6 +113,13 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 		if err != nil {
 			return errors.Wrap(err, "parse alert query url")
 		}
+		var alertRelabelConfigs []*config.RelabelConfig
+		if *alertRelabelConfigFile != "" {
+			alertRelabelConfigs, err = alert.LoadRelabelConfigs(*alertRelabelConfigFile)
+			if err != nil {
+				return errors.Wrap(err, "load alert relabel configs")
+			}
+		}
 
 		tsdbOpts := &tsdb.Options{
 			MinBlockDuration: model.Duration(*tsdbBlockDuration),
@@ -98,7 +128,7 @@ func registerRule(m map[string]setupFunc, app *kingpin.Application, name string)
 			NoLockfile:       true,
 			WALFlushInterval: 30 * time.Second,
 		}
-		return runRule(g, logger, reg, tracer, lset, *alertmgrs, *grpcBindAddr, *httpBindAddr, *evalInterval, *dataDir, *ruleFiles, peer, *gcsBucket, s3Config, tsdbOpts, name, alertQueryURL)
+		return runRule(g, logger, reg, tracer, lset, *alertmgrs, *alertmgrsRetries, *alertmgrsTimeout, *alertQueueCapacity, alertRelabelConfigs, *grpcBindAddr, grpcWindows, *grpcReflection, *httpBindAddr, *evalInterval, *dataDir, *ruleFiles, peer, *gcsBucket, s3Config, fsConfig, rateLimits, tsdbOpts, *tsdbDeleteUploadedAfter, name, alertQueryURL, *selfScrapeInterval)
 	}
 }
 
@@ -111,7 +141,13 @@ func runRule(
 	tracer opentracing.Tracer,
 	lset labels.Labels,
 	alertmgrURLs []string,
+	alertmgrsRetries int,
+	alertmgrsTimeout time.Duration,
+	alertQueueCapacity int,
+	alertRelabelConfigs []*config.RelabelConfig,
 	grpcBindAddr string,
+	grpcWindows *grpcWindowSizes,
+	grpcReflection bool,
 	httpBindAddr string,
 	evalInterval time.Duration,
 	dataDir string,
@@ -119,9 +155,13 @@ func runRule(
 	peer *cluster.Peer,
 	gcsBucket string,
 	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
 	tsdbOpts *tsdb.Options,
+	deleteUploadedAfter time.Duration,
 	component string,
 	alertQueryURL *url.URL,
+	selfScrapeInterval time.Duration,
 ) error {
 	db, err := tsdb.Open(dataDir, log.With(logger, "component", "tsdb"), reg, tsdbOpts)
 	if err != nil {
@@ -136,6 +176,20 @@ func runRule(
 			close(done)
 		})
 	}
+	diskUsage := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_rule_tsdb_disk_usage_bytes",
+		Help: "Size of all files in the data directory of the local TSDB, including the WAL.",
+	}, func() float64 {
+		return float64(dirSize(logger, dataDir))
+	})
+	walSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_rule_tsdb_wal_bytes",
+		Help: "Size of the WAL of the local TSDB.",
+	}, func() float64 {
+		return float64(dirSize(logger, filepath.Join(dataDir, "wal")))
+	})
+	reg.MustRegister(diskUsage, walSize)
+	selfScrapeGroup(g, log.With(logger, "component", "self-scrape"), reg, tsdb.Adapter(db, 0), selfScrapeInterval, component, httpBindAddr)
 
 	// Hit the HTTP query API of query peers in randomized order until we get a result
 	// back or the context get canceled.
@@ -162,7 +216,7 @@ func runRule(
 	// Run rule evaluation and alert notifications.
 	var (
 		alertmgrs = newAlertmanagerSet(alertmgrURLs, nil)
-		alertQ    = alert.NewQueue(logger, reg, 10000, 100, labelsTSDBToProm(lset))
+		alertQ    = alert.NewQueue(logger, reg, alertQueueCapacity, 100, labelsTSDBToProm(lset), alertRelabelConfigs)
 		mgr       *rules.Manager
 	)
 	{
@@ -235,14 +289,22 @@ func runRule(
 		})
 	}
 	{
-		sdr := alert.NewSender(logger, reg, alertmgrs.get, nil)
+		sdr := alert.NewSender(logger, reg, alertmgrs.get, nil, alertmgrsRetries, alertmgrsTimeout)
 		ctx, cancel := context.WithCancel(context.Background())
 
 		g.Add(func() error {
 			for {
-				// TODO(bplotka): Investigate what errors it can return and if just "sdr.Send" retry is enough.
-				if err := sdr.Send(ctx, alertQ.Pop(ctx.Done())); err != nil {
-					level.Warn(logger).Log("msg", "sending alerts failed", "err", err)
+				batch := alertQ.Pop(ctx.Done())
+				if err := sdr.Send(ctx, batch); err != nil {
+					// No Alertmanager received the alerts. Keep them queued and wait a bit
+					// before trying again, so that they are delivered once an Alertmanager recovers.
+					level.Warn(logger).Log("msg", "sending alerts failed, requeueing them", "err", err)
+					alertQ.Requeue(batch)
+
+					select {
+					case <-ctx.Done():
+					case <-time.After(5 * time.Second):
+					}
 				}
 
 				select {
@@ -337,8 +399,13 @@ func runRule(
 
 		store := store.NewTSDBStore(logger, reg, db, lset)
 
-		s := grpc.NewServer(defaultGRPCServerOpts(logger, reg, tracer)...)
+		opts, err := defaultGRPCServerOpts(logger, reg, tracer, grpcWindows)
+		if err != nil {
+			return errors.Wrap(err, "gRPC server options")
+		}
+		s := grpc.NewServer(opts...)
 		storepb.RegisterStoreServer(s, store)
+		registerGRPCReflection(logger, s, grpcReflection)
 
 		g.Add(func() error {
 			return errors.Wrap(s.Serve(l), "serve gRPC")
@@ -355,7 +422,7 @@ func runRule(
 
 	// The background shipper continuously scans the data directory and uploads
 	// new blocks to Google Cloud Storage or an S3-compatible storage service.
-	bkt, err := client.NewBucket(&gcsBucket, *s3Config, reg, component)
+	bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
 	if err != nil && err != client.ErrNotFound {
 		return err
 	}
@@ -363,9 +430,17 @@ func runRule(
 	if err == client.ErrNotFound {
 		level.Info(logger).Log("msg", "No GCS or S3 bucket was configured, uploads will be disabled")
 		uploads = false
+	} else {
+		bkt = objstore.NewRateLimiter(reg, *rateLimits).Bucket(bkt)
 	}
 
 	if uploads {
+		// Blocks of rulers without labels, e.g. of both rulers of a HA pair, cannot be told apart
+		// by the compactor and the querier's deduplication.
+		if len(lset) == 0 {
+			runutil.LogOnErr(logger, bkt, "bucket client")
+			return errors.New("no --label configured although blocks are uploaded; uniquely identifying labels like a replica label must be configured")
+		}
 		// Ensure we close up everything properly.
 		defer func() {
 			if err != nil {
@@ -373,7 +448,7 @@ func runRule(
 			}
 		}()
 
-		s := shipper.New(logger, nil, dataDir, bkt, func() labels.Labels { return lset }, block.RulerSource)
+		s := shipper.New(logger, nil, dataDir, bkt, func() labels.Labels { return lset }, block.RulerSource, false, false)
 
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -383,6 +458,13 @@ func runRule(
 			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
 				s.Sync(ctx)
 
+				if deleteUploadedAfter > 0 {
+					before := time.Now().Add(-deleteUploadedAfter).UnixNano() / int64(time.Millisecond)
+					if _, err := s.DeleteUploaded(before); err != nil {
+						level.Warn(logger).Log("msg", "deleting uploaded blocks failed", "err", err)
+					}
+				}
+
 				minTime, _, err := s.Timestamps()
 				if err != nil {
 					level.Warn(logger).Log("msg", "reading timestamps failed", "err", err)
@@ -400,6 +482,28 @@ func runRule(
 	return nil
 }
 
+// dirSize returns the total size of all files within the given directory. Files that are removed
+// while walking the directory, e.g. by compactions of the TSDB, are skipped.
+func dirSize(logger log.Logger, dir string) int64 {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		level.Warn(logger).Log("msg", "computing directory size failed", "dir", dir, "err", err)
+	}
+	return size
+}
+
 func queryPrometheusInstant(ctx context.Context, logger log.Logger, addr, query string, t time.Time) (promql.Vector, error) {
 	u, err := url.Parse(fmt.Sprintf("http://%s/api/v1/query", addr))
 	if err != nil {
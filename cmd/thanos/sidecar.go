@@ -13,9 +13,12 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/alert"
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/reloader"
 	"github.com/improbable-eng/thanos/pkg/runutil"
@@ -26,6 +29,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/tsdb/labels"
 	"google.golang.org/grpc"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -36,6 +40,8 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 	cmd := app.Command(name, "sidecar for Prometheus server")
 
 	grpcBindAddr, httpBindAddr, newPeerFn := regCommonServerFlags(cmd)
+	grpcWindows := regGRPCWindowFlags(cmd)
+	grpcReflection := regGRPCReflectionFlag(cmd)
 
 	promURL := cmd.Flag("prometheus.url", "URL at which to reach Prometheus's API.").
 		Default("http://localhost:9090").URL()
@@ -48,6 +54,24 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 
 	s3Config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
+	verifyOnUpload := cmd.Flag("shipper.verify-on-upload", "Verify the index of each block before uploading it. Blocks failing verification are not uploaded and kept locally for inspection.").
+		Default("false").Bool()
+
+	compressIndex := cmd.Flag("shipper.compress-index", "Compress index files before uploading them. Reduces object storage usage for high-cardinality data at the cost of Store Gateways keeping a decompressed copy of each index on disk.").
+		Default("false").Bool()
+
+	shipperInterval := cmd.Flag("shipper.interval", "Interval at which the data directory is checked for new blocks to upload.").
+		Default("30s").Duration()
+
+	shipperShutdownTimeout := cmd.Flag("shipper.shutdown-timeout", "Time the sidecar waits on shutdown for pending complete blocks to be uploaded, so they are not delayed until it restarts. The head block is never uploaded. 0 skips the upload on shutdown.").
+		Default("1m").Duration()
+
+	relabelConfigFile := cmd.Flag("relabel-config-file", "Path to a YAML file with a list of relabel configs applied to the labels of all series served through the StoreAPI, including the external labels, e.g. to drop noisy series or rewrite labels. Shipped blocks are not relabeled. Uses the format of Prometheus' metric_relabel_configs.").
+		PlaceHolder("<path>").String()
+
 	reloaderCfgFile := cmd.Flag("reloader.config-file", "Config file watched by the reloader.").
 		Default("").String()
 
@@ -68,17 +92,33 @@ func registerSidecar(m map[string]setupFunc, app *kingpin.Application, name stri
 		if err != nil {
 			return errors.Wrap(err, "new cluster peer")
 		}
+		var relabelConfigs []*config.RelabelConfig
+		if *relabelConfigFile != "" {
+			relabelConfigs, err = alert.LoadRelabelConfigs(*relabelConfigFile)
+			if err != nil {
+				return errors.Wrap(err, "load relabel configs")
+			}
+		}
 		return runSidecar(
 			g,
 			logger,
 			reg,
 			tracer,
 			*grpcBindAddr,
+			grpcWindows,
+			*grpcReflection,
 			*httpBindAddr,
 			*promURL,
 			*dataDir,
 			*gcsBucket,
 			s3Config,
+			fsConfig,
+			rateLimits,
+			*verifyOnUpload,
+			*compressIndex,
+			*shipperInterval,
+			*shipperShutdownTimeout,
+			relabelConfigs,
 			peer,
 			rl,
 			name,
@@ -92,11 +132,20 @@ func runSidecar(
 	reg *prometheus.Registry,
 	tracer opentracing.Tracer,
 	grpcBindAddr string,
+	grpcWindows *grpcWindowSizes,
+	grpcReflection bool,
 	httpBindAddr string,
 	promURL *url.URL,
 	dataDir string,
 	gcsBucket string,
 	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
+	verifyOnUpload bool,
+	compressIndex bool,
+	shipperInterval time.Duration,
+	shipperShutdownTimeout time.Duration,
+	relabelConfigs []*config.RelabelConfig,
 	peer *cluster.Peer,
 	reloader *reloader.Reloader,
 	component string,
@@ -208,8 +257,19 @@ func runSidecar(
 			return errors.Wrap(err, "create Prometheus store")
 		}
 
-		s := grpc.NewServer(defaultGRPCServerOpts(logger, reg, tracer)...)
-		storepb.RegisterStoreServer(s, promStore)
+		opts, err := defaultGRPCServerOpts(logger, reg, tracer, grpcWindows)
+		if err != nil {
+			return errors.Wrap(err, "gRPC server options")
+		}
+		var storeSrv storepb.StoreServer = promStore
+		if len(relabelConfigs) > 0 {
+			if storeSrv, err = store.NewRelabelStore(reg, promStore, relabelConfigs); err != nil {
+				return errors.Wrap(err, "create relabel store")
+			}
+		}
+		s := grpc.NewServer(opts...)
+		storepb.RegisterStoreServer(s, storeSrv)
+		registerGRPCReflection(logger, s, grpcReflection)
 
 		g.Add(func() error {
 			level.Info(logger).Log("msg", "Listening for StoreAPI gRPC", "address", grpcBindAddr)
@@ -224,7 +284,7 @@ func runSidecar(
 
 	// The background shipper continuously scans the data directory and uploads
 	// new blocks to Google Cloud Storage or an S3-compatible storage service.
-	bkt, err := client.NewBucket(&gcsBucket, *s3Config, reg, component)
+	bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
 	if err != nil && err != client.ErrNotFound {
 		return err
 	}
@@ -232,6 +292,8 @@ func runSidecar(
 	if err == client.ErrNotFound {
 		level.Info(logger).Log("msg", "No GCS or S3 bucket was configured, uploads will be disabled")
 		uploads = false
+	} else {
+		bkt = objstore.NewRateLimiter(reg, *rateLimits).Bucket(bkt)
 	}
 
 	if uploads {
@@ -242,13 +304,13 @@ func runSidecar(
 			}
 		}()
 
-		s := shipper.New(logger, nil, dataDir, bkt, metadata.Labels, block.SidecarSource)
+		s := shipper.New(logger, reg, dataDir, bkt, metadata.Labels, block.SidecarSource, verifyOnUpload, compressIndex)
 		ctx, cancel := context.WithCancel(context.Background())
 
 		g.Add(func() error {
 			defer runutil.LogOnErr(logger, bkt, "bucket client")
 
-			return runutil.Repeat(30*time.Second, ctx.Done(), func() error {
+			err := runutil.Repeat(shipperInterval, ctx.Done(), func() error {
 				s.Sync(ctx)
 
 				minTime, _, err := s.Timestamps()
@@ -262,6 +324,19 @@ func runSidecar(
 				}
 				return nil
 			})
+			if shipperShutdownTimeout <= 0 {
+				return err
+			}
+			// Upload blocks Prometheus completed since the last sync before exiting. The shipper only picks up
+			// block directories with a meta.json, which Prometheus writes once a block is complete, so the head
+			// block is never uploaded. It runs here rather than in the interrupt function, as syncs must not
+			// run concurrently.
+			level.Info(logger).Log("msg", "uploading pending blocks before shutdown", "timeout", shipperShutdownTimeout)
+
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shipperShutdownTimeout)
+			s.Sync(shutdownCtx)
+			shutdownCancel()
+			return err
 		}, func(error) {
 			cancel()
 		})
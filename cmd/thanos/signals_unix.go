@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// profileSignals trigger a dump of goroutine and heap profiles.
+var profileSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// profileSignals trigger a dump of goroutine and heap profiles. Windows has no user defined signals.
+var profileSignals []os.Signal
@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"net"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/cluster"
+	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/objstore/client"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store"
@@ -27,6 +31,8 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application, name string
 	cmd := app.Command(name, "store node giving access to blocks in a GCS bucket")
 
 	grpcBindAddr, httpBindAddr, newPeerFn := regCommonServerFlags(cmd)
+	grpcWindows := regGRPCWindowFlags(cmd)
+	grpcReflection := regGRPCReflectionFlag(cmd)
 
 	dataDir := cmd.Flag("tsdb.path", "Data directory of TSDB.").
 		Default("./data").String()
@@ -36,12 +42,54 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application, name string
 
 	s3Config := s3.RegisterS3Params(cmd)
 
+	fsConfig := filesystem.RegisterFilesystemParams(cmd)
+	rateLimits := objstore.RegisterRateLimitParams(cmd)
+
+	s3AdditionalBuckets := cmd.Flag("s3.additional-bucket", "Additional S3 bucket to serve blocks from, using the same endpoint and credentials as --s3.bucket. Blocks are expected to be unique across buckets. Can be specified multiple times, each bucket only once.").
+		PlaceHolder("<bucket>").Strings()
+
+	readOnly := cmd.Flag("objstore.read-only", "Reject all uploads and deletes of the store to the bucket, so that it never mutates the bucket, even if its credentials allow writes.").
+		Default("false").Bool()
+
+	objstoreOpTimeout := cmd.Flag("store.objstore-op-timeout", "Maximum time of each individual range read of index and chunk data against the object storage in queries, independent of the query's timeout. A stuck request fails after it instead of holding the query, which proceeds without the store's data under partial response. Loading blocks while syncing is not bounded. 0 disables it.").
+		Default("0s").Duration()
+
 	indexCacheSize := cmd.Flag("index-cache-size", "Maximum size of items held in the index cache.").
 		Default("250MB").Bytes()
 
 	chunkPoolSize := cmd.Flag("chunk-pool-size", "Maximum size of concurrently allocatable bytes for chunks.").
 		Default("2GB").Bytes()
 
+	chunkPrefetchGap := cmd.Flag("store.chunk-prefetch-gap", "Maximum gap between chunks of a chunk file that are fetched with a single range read. Larger gaps reduce the number of requests against the object storage, but fetch more unused bytes.").
+		Default("512KB").Bytes()
+
+	labelRequestLimit := cmd.Flag("label-request-limit", "Maximum number of entries a single LabelNames or LabelValues response may hold. Larger requests are rejected and truncated by the querier. 0 means no limit.").
+		Default("0").Int()
+
+	labelRequestTimeout := cmd.Flag("label-request-timeout", "Maximum time to process a single LabelNames or LabelValues request. 0 means no timeout.").
+		Default("0s").Duration()
+
+	indexLoadStrategy := cmd.Flag("store.index-load-strategy", "How the lookup structures of block indexes are loaded. 'index-cache' downloads the full index of each block to build a JSON index cache. 'index-header' only fetches the symbols, label indices and postings offsets by range into a binary index header, which avoids downloading and processing the full index while loading. The index header is mmapped and lookups are served from it, so only the positions of its entries are kept in memory.").
+		Default(store.IndexCacheStrategy).Enum(store.IndexCacheStrategy, store.IndexHeaderStrategy)
+
+	advertiseMaxTime := cmd.Flag("store.advertise-max-time", "Negative offset from now up to which data is advertised and served, e.g. -24h, independent of the loaded blocks. Newer data is left to other stores like sidecars, so the querier does not fetch it twice. 0 advertises all loaded blocks.").
+		Default("0s").Duration()
+
+	lazyIndexHeader := cmd.Flag("store.index-header-lazy-reader", "Load the index lookup structures of a block only on the first query touching it instead of when the block is synced. Cuts startup time and memory for buckets of which most blocks are rarely queried.").
+		Default("false").Bool()
+
+	lazyIndexHeaderIdleTimeout := cmd.Flag("store.index-header-lazy-reader-idle-timeout", "Time after which the lazily loaded index lookup structures of a block that was not queried are unloaded from memory. They are reloaded from local disk on the next query. 0 never unloads them.").
+		Default("5m").Duration()
+
+	bufferPooling := cmd.Flag("store.enable-buffer-pooling", "Reuse the buffers of index ranges read by queries across queries instead of allocating them anew, like the buffers of chunks. Reduces allocations and GC pressure for large Series requests.").
+		Default("false").Bool()
+
+	blockSummaries := cmd.Flag("store.block-summaries", "Keep a summary of the label values of each block, the smallest and largest value and a bloom filter per label name, to skip blocks that cannot hold series of a request before touching their postings. Summaries are kept while lazily loaded index lookup structures are unloaded, so such blocks are not loaded either.").
+		Default("false").Bool()
+
+	chunkDecodeConcurrency := cmd.Flag("store.chunk-decode-concurrency", "Maximum number of chunk ranges a single Series request reads from the bucket concurrently across all its blocks. Bounds the concurrent bucket reads of wide requests at the cost of their latency. Ranges waiting for their turn still hold a goroutine each. 0 does not limit them.").
+		Default("0").Int()
+
 	m[name] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, debugLogging bool) error {
 		peer, err := newPeerFn(logger, reg, false, "", false)
 		if err != nil {
@@ -53,12 +101,31 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application, name string
 			tracer,
 			*gcsBucket,
 			s3Config,
+			fsConfig,
+			rateLimits,
+			*s3AdditionalBuckets,
+			*readOnly,
 			*dataDir,
 			*grpcBindAddr,
+			grpcWindows,
+			*grpcReflection,
 			*httpBindAddr,
 			peer,
 			uint64(*indexCacheSize),
 			uint64(*chunkPoolSize),
+			store.BucketStoreOptions{
+				ChunkPrefetchGap:       uint64(*chunkPrefetchGap),
+				LabelRequestLimit:      *labelRequestLimit,
+				LabelRequestTimeout:    *labelRequestTimeout,
+				IndexLoadStrategy:      *indexLoadStrategy,
+				AdvertiseMaxTime:       *advertiseMaxTime,
+				LazyIndex:              *lazyIndexHeader,
+				LazyIndexIdleTimeout:   *lazyIndexHeaderIdleTimeout,
+				BufferPooling:          *bufferPooling,
+				BlockSummaries:         *blockSummaries,
+				ChunkDecodeConcurrency: *chunkDecodeConcurrency,
+				ObjstoreOpTimeout:      *objstoreOpTimeout,
+			},
 			name,
 			debugLogging,
 		)
@@ -73,36 +140,89 @@ func runStore(
 	tracer opentracing.Tracer,
 	gcsBucket string,
 	s3Config *s3.Config,
+	fsConfig *filesystem.Config,
+	rateLimits *objstore.RateLimits,
+	s3AdditionalBuckets []string,
+	readOnly bool,
 	dataDir string,
 	grpcBindAddr string,
+	grpcWindows *grpcWindowSizes,
+	grpcReflection bool,
 	httpBindAddr string,
 	peer *cluster.Peer,
 	indexCacheSizeBytes uint64,
 	chunkPoolSizeBytes uint64,
+	storeOpts store.BucketStoreOptions,
 	component string,
 	verbose bool,
 ) error {
+	// Bucket clients register their metrics labeled with the bucket name, so every bucket may only be served once.
+	seen := map[string]struct{}{s3Config.Bucket: {}}
+	for _, name := range s3AdditionalBuckets {
+		if _, ok := seen[name]; ok {
+			return errors.Errorf("S3 bucket %q is specified more than once", name)
+		}
+		seen[name] = struct{}{}
+	}
+
+	var drain *store.DrainStore
 	{
-		bkt, err := client.NewBucket(&gcsBucket, *s3Config, reg, component)
+		// All buckets share the bandwidth of one limiter.
+		limiter := objstore.NewRateLimiter(reg, *rateLimits)
+
+		bkt, err := client.NewBucket(&gcsBucket, *s3Config, *fsConfig, reg, component)
 		if err != nil {
 			return err
 		}
+		bkt = limiter.Bucket(bkt)
+		if readOnly {
+			bkt = objstore.NewReadOnlyBucket(bkt)
+		}
+		bkts := []objstore.Bucket{bkt}
 
 		// Ensure we close up everything properly.
 		defer func() {
 			if err != nil {
-				runutil.LogOnErr(logger, bkt, "bucket client")
+				for _, b := range bkts {
+					runutil.LogOnErr(logger, b, "bucket client")
+				}
 			}
 		}()
 
+		var bktReader objstore.BucketReader = bkt
+		if len(s3AdditionalBuckets) > 0 {
+			names := []string{s3Config.Bucket}
+			readers := []objstore.BucketReader{bkt}
+
+			for _, name := range s3AdditionalBuckets {
+				cfg := *s3Config
+				cfg.Bucket = name
+
+				var b objstore.Bucket
+				b, err = client.NewBucket(&gcsBucket, cfg, filesystem.Config{}, reg, component)
+				if err != nil {
+					return errors.Wrapf(err, "create bucket client for %s", name)
+				}
+				b = limiter.Bucket(b)
+				if readOnly {
+					b = objstore.NewReadOnlyBucket(b)
+				}
+				bkts = append(bkts, b)
+				names = append(names, name)
+				readers = append(readers, b)
+			}
+			bktReader = objstore.NewMultiBucketReader(reg, names, readers)
+		}
+
 		bs, err := store.NewBucketStore(
 			logger,
 			reg,
-			bkt,
+			bktReader,
 			dataDir,
 			indexCacheSizeBytes,
 			chunkPoolSizeBytes,
 			verbose,
+			storeOpts,
 		)
 		if err != nil {
 			return errors.Wrap(err, "create object storage store")
@@ -117,7 +237,11 @@ func runStore(
 
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
-			defer runutil.LogOnErr(logger, bkt, "bucket client")
+			defer func() {
+				for _, b := range bkts {
+					runutil.LogOnErr(logger, b, "bucket client")
+				}
+			}()
 
 			err := runutil.Repeat(3*time.Minute, ctx.Done(), func() error {
 				if err := bs.SyncBlocks(ctx); err != nil {
@@ -133,13 +257,35 @@ func runStore(
 			cancel()
 		})
 
+		if storeOpts.LazyIndex && storeOpts.LazyIndexIdleTimeout > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			g.Add(func() error {
+				return runutil.Repeat(storeOpts.LazyIndexIdleTimeout/2, ctx.Done(), func() error {
+					if n := bs.UnloadIdleIndexes(); n > 0 {
+						level.Debug(logger).Log("msg", "unloaded idle block indexes", "blocks", n)
+					}
+					return nil
+				})
+			}, func(error) {
+				cancel()
+			})
+		}
+
 		l, err := net.Listen("tcp", grpcBindAddr)
 		if err != nil {
 			return errors.Wrap(err, "listen API address")
 		}
 
-		s := grpc.NewServer(defaultGRPCServerOpts(logger, reg, tracer)...)
-		storepb.RegisterStoreServer(s, bs)
+		opts, err := defaultGRPCServerOpts(logger, reg, tracer, grpcWindows)
+		if err != nil {
+			return errors.Wrap(err, "gRPC server options")
+		}
+		// Requests are drained through the drain endpoint before rolling restarts.
+		drain = store.NewDrainStore(reg, bs)
+
+		s := grpc.NewServer(opts...)
+		storepb.RegisterStoreServer(s, drain)
+		registerGRPCReflection(logger, s, grpcReflection)
 
 		g.Add(func() error {
 			level.Info(logger).Log("msg", "Listening for StoreAPI gRPC", "address", grpcBindAddr)
@@ -169,10 +315,37 @@ func runStore(
 			peer.Close(5 * time.Second)
 		})
 	}
-	if err := metricHTTPListenGroup(g, logger, reg, httpBindAddr); err != nil {
+	// Start HTTP server for metrics, profiling and draining.
+	if err := metricHTTPListenGroup(g, logger, reg, httpBindAddr, func(mux *http.ServeMux) {
+		mux.Handle("/-/drain", drainHandler(logger, drain))
+	}); err != nil {
 		return err
 	}
 
 	level.Info(logger).Log("msg", "starting store node")
 	return nil
 }
+
+// drainHandler drains the store on POST requests. Queriers stop sending requests to the store once their
+// next health check fails, requests in flight are finished. GET requests return 200 once the store is drained
+// and no requests are in flight and 503 otherwise, so that a pre-stop hook can wait for it.
+func drainHandler(logger log.Logger, drain *store.DrainStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			inflight := drain.Drain()
+			level.Info(logger).Log("msg", "draining store", "inflight", inflight)
+
+			fmt.Fprintf(w, "draining, %d requests in flight\n", inflight)
+		case http.MethodGet:
+			draining, inflight := drain.Status()
+			if !draining || inflight > 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			fmt.Fprintf(w, "draining: %t, %d requests in flight\n", draining, inflight)
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			http.Error(w, "only GET and POST requests are allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -6,21 +6,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
 	"sync"
 	"time"
 
-	"golang.org/x/sync/errgroup"
-
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/relabel"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const (
@@ -75,34 +77,60 @@ func (a *Alert) ResolvedAt(ts time.Time) bool {
 	return !a.EndsAt.After(ts)
 }
 
+// LoadRelabelConfigs parses and validates a YAML list of relabel configs from the given file.
+// The configs use the same format as Prometheus' alert_relabel_configs and metric_relabel_configs.
+func LoadRelabelConfigs(filename string) ([]*config.RelabelConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read relabel config file %s", filename)
+	}
+	var cfgs []*config.RelabelConfig
+	if err := yaml.UnmarshalStrict(b, &cfgs); err != nil {
+		return nil, errors.Wrapf(err, "parse relabel config file %s", filename)
+	}
+	for i, c := range cfgs {
+		if c == nil {
+			return nil, errors.Errorf("empty relabel config at position %d in %s", i, filename)
+		}
+	}
+	return cfgs, nil
+}
+
 // Queue is a queue of alert notifications waiting to be sent. The queue is consumed in batches
 // and entries are dropped at the front if it runs full.
 type Queue struct {
-	logger       log.Logger
-	maxBatchSize int
-	capacity     int
-	labels       labels.Labels
+	logger         log.Logger
+	maxBatchSize   int
+	capacity       int
+	labels         labels.Labels
+	relabelConfigs []*config.RelabelConfig
 
 	mtx   sync.Mutex
 	queue []*Alert
 	morec chan struct{}
 
-	pushed  prometheus.Counter
-	popped  prometheus.Counter
-	dropped prometheus.Counter
+	pushed   prometheus.Counter
+	popped   prometheus.Counter
+	requeued prometheus.Counter
+	dropped  prometheus.Counter
+
+	relabeled      prometheus.Counter
+	relabelDropped prometheus.Counter
 }
 
-// NewQueue returns a new queue. The given label set is attached to all alerts pushed to the queue.
-func NewQueue(logger log.Logger, reg prometheus.Registerer, capacity, maxBatchSize int, lset labels.Labels) *Queue {
+// NewQueue returns a new queue. The given label set is attached to all alerts pushed to the queue,
+// before the relabel configs are applied to them.
+func NewQueue(logger log.Logger, reg prometheus.Registerer, capacity, maxBatchSize int, lset labels.Labels, relabelConfigs []*config.RelabelConfig) *Queue {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	q := &Queue{
-		logger:       logger,
-		capacity:     capacity,
-		morec:        make(chan struct{}, 1),
-		maxBatchSize: maxBatchSize,
-		labels:       lset,
+		logger:         logger,
+		capacity:       capacity,
+		morec:          make(chan struct{}, 1),
+		maxBatchSize:   maxBatchSize,
+		labels:         lset,
+		relabelConfigs: relabelConfigs,
 
 		dropped: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "thanos_alert_queue_alerts_dropped_total",
@@ -116,6 +144,18 @@ func NewQueue(logger log.Logger, reg prometheus.Registerer, capacity, maxBatchSi
 			Name: "thanos_alert_queue_alerts_popped_total",
 			Help: "Total number of alerts popped from the queue.",
 		}),
+		requeued: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_queue_alerts_requeued_total",
+			Help: "Total number of popped alerts that were put back to the queue as they could not be delivered.",
+		}),
+		relabeled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_queue_alerts_relabeled_total",
+			Help: "Total number of alerts pushed to the queue of which the labels were changed by relabeling.",
+		}),
+		relabelDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_alert_queue_alerts_relabel_dropped_total",
+			Help: "Total number of alerts pushed to the queue that were dropped by relabeling.",
+		}),
 	}
 	capMetric := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "thanos_alert_queue_capacity",
@@ -130,7 +170,7 @@ func NewQueue(logger log.Logger, reg prometheus.Registerer, capacity, maxBatchSi
 		return float64(q.Len())
 	})
 	if reg != nil {
-		reg.MustRegister(q.pushed, q.popped, q.dropped, lenMetric, capMetric)
+		reg.MustRegister(q.pushed, q.popped, q.requeued, q.dropped, q.relabeled, q.relabelDropped, lenMetric, capMetric)
 	}
 	return q
 }
@@ -166,6 +206,13 @@ func (q *Queue) Pop(termc <-chan struct{}) []*Alert {
 
 	q.popped.Add(float64(n))
 
+	// Make sure the remaining alerts are popped without waiting for the next push.
+	if len(q.queue) > 0 {
+		select {
+		case q.morec <- struct{}{}:
+		default:
+		}
+	}
 	return as[:n]
 }
 
@@ -180,12 +227,33 @@ func (q *Queue) Push(alerts []*Alert) {
 	q.pushed.Add(float64(len(alerts)))
 
 	// Attach external labels before relabelling and sending.
+	relabeled := make([]*Alert, 0, len(alerts))
 	for _, a := range alerts {
 		lb := labels.NewBuilder(a.Labels)
 		for _, l := range q.labels {
 			lb.Set(l.Name, l.Value)
 		}
 		a.Labels = lb.Labels()
+
+		if len(q.relabelConfigs) == 0 {
+			relabeled = append(relabeled, a)
+			continue
+		}
+		lset := relabel.Process(a.Labels, q.relabelConfigs...)
+		if lset == nil {
+			q.relabelDropped.Inc()
+			continue
+		}
+		if !labels.Equal(lset, a.Labels) {
+			q.relabeled.Inc()
+		}
+		a.Labels = lset
+		relabeled = append(relabeled, a)
+	}
+	alerts = relabeled
+
+	if len(alerts) == 0 {
+		return
 	}
 
 	// Queue capacity should be significantly larger than a single alert
@@ -218,24 +286,57 @@ func (q *Queue) Push(alerts []*Alert) {
 	}
 }
 
+// Requeue puts alerts that could not be delivered back to the front of the queue so that they are
+// sent again with the next batch. If the queue runs full, the oldest alerts are dropped.
+func (q *Queue) Requeue(alerts []*Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	q.requeued.Add(float64(len(alerts)))
+	q.queue = append(alerts[:len(alerts):len(alerts)], q.queue...)
+
+	if d := len(q.queue) - q.capacity; d > 0 {
+		q.queue = q.queue[d:]
+
+		level.Warn(q.logger).Log(
+			"msg", "Alert notification queue full, dropping undelivered alerts",
+			"numDropped", d)
+		q.dropped.Add(float64(d))
+	}
+
+	select {
+	case q.morec <- struct{}{}:
+	default:
+	}
+}
+
 // Sender sends notifications to a dynamic set of alertmanagers.
 type Sender struct {
 	logger        log.Logger
 	alertmanagers func() []*url.URL
 	doReq         func(req *http.Request) (*http.Response, error)
+	retries       int
+	timeout       time.Duration
 
 	sent    *prometheus.CounterVec
+	retried *prometheus.CounterVec
 	dropped *prometheus.CounterVec
 	latency *prometheus.HistogramVec
 }
 
 // NewSender returns a new sender. On each call to Send the entire alert batch is sent
-// to each Alertmanager returned by the getter function.
+// to each Alertmanager returned by the getter function. Failed requests are retried the given
+// number of times with an exponential backoff. A timeout of 0 disables the per request timeout.
 func NewSender(
 	logger log.Logger,
 	reg prometheus.Registerer,
 	alertmanagers func() []*url.URL,
 	doReq func(req *http.Request) (*http.Response, error),
+	retries int,
+	timeout time.Duration,
 ) *Sender {
 	if doReq == nil {
 		doReq = http.DefaultClient.Do
@@ -247,12 +348,19 @@ func NewSender(
 		logger:        logger,
 		alertmanagers: alertmanagers,
 		doReq:         doReq,
+		retries:       retries,
+		timeout:       timeout,
 
 		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "thanos_alert_sender_alerts_sent_total",
 			Help: "Total number of alerts sent by alertmanager.",
 		}, []string{"alertmanager"}),
 
+		retried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_alert_sender_retries_total",
+			Help: "Total number of retried requests to send alerts to alertmanager.",
+		}, []string{"alertmanager"}),
+
 		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "thanos_alert_sender_alerts_dropped_total",
 			Help: "Total number of alerts dropped by alertmanager.",
@@ -264,12 +372,13 @@ func NewSender(
 		}, []string{"alertmanager"}),
 	}
 	if reg != nil {
-		reg.MustRegister(s.sent, s.dropped, s.latency)
+		reg.MustRegister(s.sent, s.retried, s.dropped, s.latency)
 	}
 	return s
 }
 
-// Send an alert batch to all given Alertmanager URLs.
+// Send an alert batch to all given Alertmanager URLs. It only returns an error if the batch could not
+// be delivered to any of them, as Alertmanagers of a cluster share received alerts among each other.
 func (s *Sender) Send(ctx context.Context, alerts []*Alert) error {
 	if len(alerts) == 0 {
 		return nil
@@ -279,38 +388,75 @@ func (s *Sender) Send(ctx context.Context, alerts []*Alert) error {
 		return errors.Wrap(err, "encode alerts")
 	}
 
-	var g errgroup.Group
-
+	var (
+		wg        sync.WaitGroup
+		mtx       sync.Mutex
+		delivered bool
+		lastErr   error
+	)
 	for _, u := range s.alertmanagers() {
 		amURL := *u
-		sendCtx, cancel := context.WithCancel(ctx)
+		amURL.Path = path.Join(amURL.Path, alertPushEndpoint)
 
-		g.Go(func() error {
-			defer cancel()
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
 
 			start := time.Now()
-			amURL.Path = path.Join(amURL.Path, alertPushEndpoint)
+			err := s.sendWithRetries(ctx, host, amURL.String(), b)
+
+			mtx.Lock()
+			defer mtx.Unlock()
 
-			if err := s.sendOne(sendCtx, amURL.String(), b); err != nil {
+			if err != nil {
 				level.Warn(s.logger).Log(
 					"msg", "sending alerts failed",
-					"alertmanager", u.Host,
+					"alertmanager", host,
 					"numDropped", len(alerts),
 					"err", err)
-				s.dropped.WithLabelValues(u.Host).Add(float64(len(alerts)))
-				return err
+				s.dropped.WithLabelValues(host).Add(float64(len(alerts)))
+				lastErr = err
+				return
 			}
-			s.sent.WithLabelValues(u.Host).Add(float64(len(alerts)))
-			s.latency.WithLabelValues(u.Host).Observe(time.Since(start).Seconds())
-			return nil
-		})
+			delivered = true
+			s.sent.WithLabelValues(host).Add(float64(len(alerts)))
+			s.latency.WithLabelValues(host).Observe(time.Since(start).Seconds())
+		}(u.Host)
 	}
-	if err := g.Wait(); err != nil {
-		return errors.Wrap(err, "send alerts")
+	wg.Wait()
+
+	if lastErr != nil && !delivered {
+		return errors.Wrap(lastErr, "send alerts")
 	}
 	return nil
 }
 
+// sendWithRetries sends the encoded alerts to a single Alertmanager and retries failed requests
+// with an exponential backoff starting at 100ms.
+func (s *Sender) sendWithRetries(ctx context.Context, host, url string, b []byte) (err error) {
+	backoff := 100 * time.Millisecond
+
+	for i := 0; ; i++ {
+		sendCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.timeout > 0 {
+			sendCtx, cancel = context.WithTimeout(ctx, s.timeout)
+		}
+		err = s.sendOne(sendCtx, url, b)
+		cancel()
+
+		if err == nil || i >= s.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		s.retried.WithLabelValues(host).Inc()
+	}
+}
+
 func (s *Sender) sendOne(ctx context.Context, url string, b []byte) error {
 	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
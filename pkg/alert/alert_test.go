@@ -0,0 +1,117 @@
+package alert
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestQueue_Requeue(t *testing.T) {
+	q := NewQueue(nil, nil, 3, 2, nil, nil)
+
+	q.Push([]*Alert{
+		{Labels: labels.FromStrings("alertname", "a")},
+		{Labels: labels.FromStrings("alertname", "b")},
+	})
+	batch := q.Pop(nil)
+	testutil.Equals(t, 2, len(batch))
+
+	q.Push([]*Alert{
+		{Labels: labels.FromStrings("alertname", "c")},
+		{Labels: labels.FromStrings("alertname", "d")},
+	})
+	// Undelivered alerts go to the front and the oldest are dropped once the queue runs full.
+	q.Requeue(batch)
+	testutil.Equals(t, 3, q.Len())
+
+	var names []string
+	for q.Len() > 0 {
+		for _, a := range q.Pop(nil) {
+			names = append(names, a.Name())
+		}
+	}
+	testutil.Equals(t, []string{"b", "c", "d"}, names)
+}
+
+func TestQueue_Push_Relabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alert-relabel")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "relabel.yaml")
+	testutil.Ok(t, ioutil.WriteFile(fn, []byte(`
+- source_labels: [severity]
+  regex: debug
+  action: drop
+- regex: replica
+  action: labeldrop
+`), 0666))
+
+	cfgs, err := LoadRelabelConfigs(fn)
+	testutil.Ok(t, err)
+
+	q := NewQueue(nil, nil, 10, 10, labels.FromStrings("cluster", "eu1", "replica", "a"), cfgs)
+	q.Push([]*Alert{
+		{Labels: labels.FromStrings("alertname", "a", "severity", "critical")},
+		{Labels: labels.FromStrings("alertname", "b", "severity", "debug")},
+	})
+	testutil.Equals(t, 1, q.Len())
+	testutil.Equals(t, labels.FromStrings("alertname", "a", "cluster", "eu1", "severity", "critical"), q.Pop(nil)[0].Labels)
+
+	// Invalid configs are rejected.
+	testutil.Ok(t, ioutil.WriteFile(fn, []byte(`
+- regex: "("
+  action: labeldrop
+`), 0666))
+	_, err = LoadRelabelConfigs(fn)
+	testutil.NotOk(t, err)
+
+	testutil.Ok(t, ioutil.WriteFile(fn, []byte(`
+- action: unknown
+`), 0666))
+	_, err = LoadRelabelConfigs(fn)
+	testutil.NotOk(t, err)
+}
+
+func TestSender_Send_Retries(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		requests = map[string]int{}
+	)
+	doReq := func(req *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		requests[req.URL.Host]++
+		// The first Alertmanager recovers after two failed requests, the second one never does.
+		if req.URL.Host == "am2:9093" || requests[req.URL.Host] <= 2 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+	ams := func() []*url.URL {
+		return []*url.URL{{Scheme: "http", Host: "am1:9093"}, {Scheme: "http", Host: "am2:9093"}}
+	}
+	alerts := []*Alert{{Labels: labels.FromStrings("alertname", "a")}}
+
+	// Delivering to a single Alertmanager is sufficient.
+	s := NewSender(nil, nil, ams, doReq, 2, 0)
+	testutil.Ok(t, s.Send(context.Background(), alerts))
+	testutil.Equals(t, map[string]int{"am1:9093": 3, "am2:9093": 3}, requests)
+
+	// Without retries the batch cannot be delivered to any Alertmanager.
+	requests = map[string]int{}
+	s = NewSender(nil, nil, ams, doReq, 0, 0)
+	testutil.NotOk(t, s.Send(context.Background(), alerts))
+	testutil.Equals(t, map[string]int{"am1:9093": 1, "am2:9093": 1}, requests)
+}
@@ -6,11 +6,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/ulid"
@@ -39,10 +42,20 @@ const (
 	CompactorSource       SourceType = "compactor"
 	CompactorRepairSource SourceType = "compactor.repair"
 	RulerSource           SourceType = "ruler"
+	ReceiveSource         SourceType = "receive"
 	BucketRepairSource    SourceType = "bucket.repair"
 	TestSource            SourceType = "test"
 )
 
+const (
+	// MetaVersion1 is the version of the TSDB meta.json format of all supported blocks.
+	MetaVersion1 = 1
+
+	// ThanosVersion1 is the current version of the Thanos section of meta.json. Blocks written before
+	// the section was versioned have version 0 and are upgraded in memory when read.
+	ThanosVersion1 = 1
+)
+
 // Meta describes the a block's meta. It wraps the known TSDB meta structure and
 // extends it by Thanos-specific fields.
 type Meta struct {
@@ -55,11 +68,17 @@ type Meta struct {
 
 // ThanosMeta holds block meta information specific to Thanos.
 type ThanosMeta struct {
+	// Version of the Thanos meta section. Older binaries ignore it, newer schema versions are refused.
+	Version int `json:"version,omitempty"`
+
 	Labels     map[string]string    `json:"labels"`
 	Downsample ThanosDownsampleMeta `json:"downsample"`
 
 	// Source is a real upload source of the block.
 	Source SourceType `json:"source"`
+
+	// IndexCompression is the compression of the index file in object storage. Empty if it is not compressed.
+	IndexCompression string `json:"index_compression,omitempty"`
 }
 
 type ThanosDownsampleMeta struct {
@@ -77,6 +96,9 @@ func WriteMetaFile(dir string, meta *Meta) error {
 		return err
 	}
 
+	if meta.Thanos.Version == 0 {
+		meta.Thanos.Version = ThanosVersion1
+	}
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "\t")
 
@@ -91,22 +113,55 @@ func WriteMetaFile(dir string, meta *Meta) error {
 }
 
 // ReadMetaFile reads the given meta from <dir>/meta.json.
+// Local blocks are written by the running binary, so upgrades of older schema versions are not logged.
 func ReadMetaFile(dir string) (*Meta, error) {
-	b, err := ioutil.ReadFile(filepath.Join(dir, MetaFilename))
+	f, err := os.Open(filepath.Join(dir, MetaFilename))
 	if err != nil {
 		return nil, err
 	}
+	defer runutil.LogOnErr(nil, f, "close meta")
+
+	return ReadMeta(nil, f)
+}
+
+// ReadMeta decodes a meta.json file and checks its schema version. Metas of older versions are upgraded
+// to the current version in memory. Metas of versions newer than supported are refused, as this binary
+// cannot know how to handle them correctly.
+func ReadMeta(logger log.Logger, r io.Reader) (*Meta, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
 	var m Meta
 
-	if err := json.Unmarshal(b, &m); err != nil {
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
 		return nil, err
 	}
-	if m.Version != 1 {
+	if m.Version > MetaVersion1 {
+		return nil, errors.Errorf("meta.json of block %s has version %d, but at most %d is supported; upgrade Thanos to handle this block", m.ULID, m.Version, MetaVersion1)
+	}
+	if m.Version != MetaVersion1 {
 		return nil, errors.Errorf("unexpected meta file version %d", m.Version)
 	}
+	if m.Thanos.Version > ThanosVersion1 {
+		return nil, errors.Errorf("thanos section of meta.json of block %s has version %d, but at most %d is supported; upgrade Thanos to handle this block", m.ULID, m.Thanos.Version, ThanosVersion1)
+	}
+	if m.Thanos.Version < ThanosVersion1 {
+		level.Debug(logger).Log("msg", "upgrading meta.json of block", "block", m.ULID, "from", m.Thanos.Version, "to", ThanosVersion1)
+		upgradeThanosMeta(&m.Thanos)
+	}
 	return &m, nil
 }
 
+// upgradeThanosMeta upgrades an unversioned Thanos meta section to the current version.
+// Such blocks may have been written before labels were required or before downsampling existed.
+// A missing downsample section already decodes to the raw resolution, which is correct for them.
+func upgradeThanosMeta(m *ThanosMeta) {
+	if m.Labels == nil {
+		m.Labels = map[string]string{}
+	}
+	m.Version = ThanosVersion1
+}
+
 func renameFile(from, to string) error {
 	if err := os.RemoveAll(to); err != nil {
 		return err
@@ -130,22 +185,34 @@ func renameFile(from, to string) error {
 
 // Download downloads directory that is mean to be block directory.
 func Download(ctx context.Context, bucket objstore.Bucket, id ulid.ULID, dst string) error {
-	if err := objstore.DownloadDir(ctx, bucket, id.String(), dst); err != nil {
-		return err
+	_, err := DownloadConcurrently(ctx, bucket, id, dst, 1, 0)
+	return err
+}
+
+// DownloadConcurrently downloads the block directory like Download, but downloads up to concurrency files in parallel,
+// each through a buffer of bufferSize bytes. It returns the number of bytes downloaded from the bucket.
+func DownloadConcurrently(ctx context.Context, bucket objstore.Bucket, id ulid.ULID, dst string, concurrency, bufferSize int) (int64, error) {
+	n, err := objstore.DownloadDirConcurrently(ctx, bucket, id.String(), dst, concurrency, bufferSize)
+	if err != nil {
+		return 0, err
 	}
 
 	chunksDir := filepath.Join(dst, ChunksDirname)
-	_, err := os.Stat(chunksDir)
+	_, err = os.Stat(chunksDir)
 	if os.IsNotExist(err) {
 		// This can happen if block is empty. We cannot easily upload empty directory, so create one here.
-		return os.Mkdir(chunksDir, os.ModePerm)
+		if err := os.Mkdir(chunksDir, os.ModePerm); err != nil {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, errors.Wrapf(err, "stat %s", chunksDir)
 	}
 
-	if err != nil {
-		return errors.Wrapf(err, "stat %s", chunksDir)
+	// Local blocks always have an uncompressed index so that they can be opened as TSDB blocks.
+	if err := DecompressIndex(dst); err != nil {
+		return 0, errors.Wrap(err, "decompress index")
 	}
-
-	return nil
+	return n, nil
 }
 
 // Upload uploads block from given block dir that ends with block id.
@@ -198,6 +265,84 @@ func Upload(ctx context.Context, bkt objstore.Bucket, bdir string) error {
 	return nil
 }
 
+// UploadResumable uploads a TSDB block like Upload, but skips block files that are already fully present
+// in the bucket, i.e. an object with the same name and size exists. It returns the number of skipped files.
+// Unlike Upload, it does not delete the uploaded files on failure, so an interrupted upload can be resumed
+// by calling it again. As meta.json is still uploaded last, the block is not considered complete before.
+func UploadResumable(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bdir string) (skipped int, err error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	df, err := os.Stat(bdir)
+	if err != nil {
+		return 0, errors.Wrap(err, "stat bdir")
+	}
+	if !df.IsDir() {
+		return 0, errors.Errorf("%s is not a directory", bdir)
+	}
+
+	// Verify dir.
+	id, err := ulid.Parse(df.Name())
+	if err != nil {
+		return 0, errors.Wrap(err, "not a block dir")
+	}
+
+	meta, err := ReadMetaFile(bdir)
+	if err != nil {
+		// No meta or broken meta file.
+		return 0, errors.Wrap(err, "read meta")
+	}
+
+	if meta.Thanos.Labels == nil || len(meta.Thanos.Labels) == 0 {
+		return 0, errors.Errorf("empty external labels are not allowed for Thanos block.")
+	}
+
+	if err := objstore.UploadFile(ctx, bkt, path.Join(bdir, MetaFilename), path.Join(DebugMetas, fmt.Sprintf("%s.json", id))); err != nil {
+		return 0, errors.Wrap(err, "upload meta file to debug dir")
+	}
+
+	upload := func(src, dst string) error {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return errors.Wrapf(err, "stat file %s", src)
+		}
+		size, err := bkt.ObjectSize(ctx, dst)
+		if err == nil && size == uint64(fi.Size()) {
+			level.Debug(logger).Log("msg", "file already uploaded, skipping", "block", id, "file", dst)
+			skipped++
+			return nil
+		}
+		if err != nil && !bkt.IsObjNotFoundErr(err) {
+			return errors.Wrapf(err, "get size of %s", dst)
+		}
+		return objstore.UploadFile(ctx, bkt, src, dst)
+	}
+
+	chunksDir := path.Join(bdir, ChunksDirname)
+	if err := filepath.Walk(chunksDir, func(src string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		return upload(src, path.Join(id.String(), ChunksDirname, strings.TrimPrefix(src, chunksDir)))
+	}); err != nil {
+		return skipped, errors.Wrap(err, "upload chunks")
+	}
+
+	if err := upload(path.Join(bdir, IndexFilename), path.Join(id.String(), IndexFilename)); err != nil {
+		return skipped, errors.Wrap(err, "upload index")
+	}
+
+	// Meta.json always need to be uploaded as a last item. This will allow to assume block directories without meta file
+	// to be pending uploads.
+	if err := objstore.UploadFile(ctx, bkt, path.Join(bdir, MetaFilename), path.Join(id.String(), MetaFilename)); err != nil {
+		return skipped, errors.Wrap(err, "upload meta file")
+	}
+	return skipped, nil
+}
+
 func cleanUp(bkt objstore.Bucket, id ulid.ULID, err error) error {
 	// Cleanup the dir with an uncancelable context.
 	cleanErr := Delete(context.Background(), bkt, id)
@@ -214,18 +359,18 @@ func Delete(ctx context.Context, bucket objstore.Bucket, id ulid.ULID) error {
 }
 
 // DownloadMeta downloads only meta file from bucket by block ID.
-func DownloadMeta(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) (Meta, error) {
+func DownloadMeta(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID) (Meta, error) {
 	rc, err := bkt.Get(ctx, path.Join(id.String(), MetaFilename))
 	if err != nil {
 		return Meta{}, errors.Wrapf(err, "meta.json bkt get for %s", id.String())
 	}
-	defer runutil.LogOnErr(nil, rc, "download meta bucket client")
+	defer runutil.LogOnErr(logger, rc, "download meta bucket client")
 
-	var m Meta
-	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+	m, err := ReadMeta(logger, rc)
+	if err != nil {
 		return Meta{}, errors.Wrapf(err, "decode meta.json for block %s", id.String())
 	}
-	return m, nil
+	return *m, nil
 }
 
 func IsBlockDir(path string) (id ulid.ULID, ok bool) {
@@ -1,6 +1,13 @@
 package block
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/oklog/ulid"
@@ -56,3 +63,178 @@ func TestIsBlockDir(t *testing.T) {
 		})
 	}
 }
+
+func TestReadMeta(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+
+		expErr  string
+		expMeta ThanosMeta
+	}{
+		{
+			name:   "missing version",
+			input:  `{"ulid": "00000000010000000000000000"}`,
+			expErr: "unexpected meta file version 0",
+		},
+		{
+			name: "unversioned thanos meta before labels",
+			input: `{
+				"version": 1,
+				"ulid": "00000000010000000000000000",
+				"thanos": {"source": "sidecar"}
+			}`,
+			expMeta: ThanosMeta{Version: ThanosVersion1, Labels: map[string]string{}, Source: SidecarSource},
+		},
+		{
+			name: "unversioned thanos meta",
+			input: `{
+				"version": 1,
+				"ulid": "00000000010000000000000000",
+				"thanos": {"labels": {"a": "b"}, "downsample": {"resolution": 300000}, "source": "compactor"}
+			}`,
+			expMeta: ThanosMeta{
+				Version:    ThanosVersion1,
+				Labels:     map[string]string{"a": "b"},
+				Downsample: ThanosDownsampleMeta{Resolution: 300000},
+				Source:     CompactorSource,
+			},
+		},
+		{
+			name: "thanos meta version 1",
+			input: `{
+				"version": 1,
+				"ulid": "00000000010000000000000000",
+				"thanos": {"version": 1, "labels": {"a": "b"}, "downsample": {"resolution": 0}, "source": "sidecar"}
+			}`,
+			expMeta: ThanosMeta{Version: ThanosVersion1, Labels: map[string]string{"a": "b"}, Source: SidecarSource},
+		},
+		{
+			name: "future thanos meta version",
+			input: `{
+				"version": 1,
+				"ulid": "00000000010000000000000000",
+				"thanos": {"version": 2, "labels": {"a": "b"}}
+			}`,
+			expErr: "thanos section of meta.json of block 00000000010000000000000000 has version 2, but at most 1 is supported",
+		},
+		{
+			name: "future meta version",
+			input: `{
+				"version": 2,
+				"ulid": "00000000010000000000000000"
+			}`,
+			expErr: "meta.json of block 00000000010000000000000000 has version 2, but at most 1 is supported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := ReadMeta(nil, strings.NewReader(tc.input))
+			if tc.expErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expErr) {
+					t.Errorf("expected error containing %q, got %v", tc.expErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if m.ULID != ulid.MustNew(1, nil) {
+				t.Errorf("unexpected ULID %s", m.ULID)
+			}
+			if !reflect.DeepEqual(tc.expMeta, m.Thanos) {
+				t.Errorf("expected %+v, got %+v", tc.expMeta, m.Thanos)
+			}
+		})
+	}
+}
+
+func TestCompressIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index := bytes.Repeat([]byte("__name__ up job prometheus "), 1000)
+	if err := ioutil.WriteFile(filepath.Join(dir, IndexFilename), index, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMetaFile(dir, &Meta{Version: MetaVersion1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CompressIndex(dir); err != nil {
+		t.Fatalf("compress: %s", err)
+	}
+	m, err := ReadMetaFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Thanos.IndexCompression != IndexCompressionSnappy {
+		t.Errorf("unexpected index compression %q", m.Thanos.IndexCompression)
+	}
+	compressed, err := ioutil.ReadFile(filepath.Join(dir, IndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(index) {
+		t.Errorf("compressed index of %d bytes is not smaller than %d bytes", len(compressed), len(index))
+	}
+
+	// Compressing twice must not change anything.
+	if err := CompressIndex(dir); err != nil {
+		t.Fatalf("compress again: %s", err)
+	}
+
+	if err := DecompressIndex(dir); err != nil {
+		t.Fatalf("decompress: %s", err)
+	}
+	m, err = ReadMetaFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Thanos.IndexCompression != "" {
+		t.Errorf("unexpected index compression %q after decompression", m.Thanos.IndexCompression)
+	}
+	decompressed, err := ioutil.ReadFile(filepath.Join(dir, IndexFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(index, decompressed) {
+		t.Errorf("decompressed index differs from the original")
+	}
+}
+
+func BenchmarkDecompressIndexFile(b *testing.B) {
+	dir, err := ioutil.TempDir("", "decompress-index")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Roughly resembles the mix of symbols and postings of a real index.
+	index := make([]byte, 0, 32<<20)
+	for i := 0; len(index) < cap(index); i++ {
+		index = append(index, fmt.Sprintf("instance-%d pod-%x", i%5000, i*7919)...)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, IndexFilename), index, 0666); err != nil {
+		b.Fatal(err)
+	}
+	if err := WriteMetaFile(dir, &Meta{Version: MetaVersion1}); err != nil {
+		b.Fatal(err)
+	}
+	if err := CompressIndex(dir); err != nil {
+		b.Fatal(err)
+	}
+	src := filepath.Join(dir, IndexFilename)
+	dst := filepath.Join(dir, "index.decompressed")
+
+	b.SetBytes(int64(len(index)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := DecompressIndexFile(IndexCompressionSnappy, src, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package block
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
+
+// OrphanedObject is an auxiliary object in a bucket that holds no block data and is no longer needed.
+type OrphanedObject struct {
+	Name   string
+	Size   uint64
+	Reason string
+}
+
+// FindOrphanedObjects returns the auxiliary objects of the bucket that are not tied to a live block:
+// index cache files in block directories, which stores only write to their local disk, markers of block
+// directories without any block files left, and debug metas of blocks older than minAge that have no
+// directory in the bucket anymore. The index, chunks and meta.json of blocks are never returned.
+func FindOrphanedObjects(ctx context.Context, bkt objstore.Bucket, minAge time.Duration, now time.Time) ([]OrphanedObject, error) {
+	// Debug metas are uploaded before the block files, so they are listed before the blocks to not
+	// mistake a debug meta of a block being uploaded for an orphaned one.
+	var debugMetas []string
+	if err := bkt.Iter(ctx, DebugMetas, func(name string) error {
+		debugMetas = append(debugMetas, name)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "iter debug metas")
+	}
+
+	var (
+		res []OrphanedObject
+		// blocks holds the blocks that have block files in the bucket.
+		blocks = map[ulid.ULID]struct{}{}
+	)
+	if err := bkt.Iter(ctx, "", func(name string) error {
+		id, ok := IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		var (
+			markers  []string
+			hasFiles bool
+		)
+		if err := bkt.Iter(ctx, id.String(), func(name string) error {
+			switch base := path.Base(name); {
+			case base == IndexCacheFilename:
+				res = append(res, OrphanedObject{Name: name, Reason: "index cache in bucket"})
+			case IsMarkerFilename(base):
+				markers = append(markers, name)
+			default:
+				hasFiles = true
+			}
+			return nil
+		}); err != nil {
+			return errors.Wrapf(err, "iter block %s", id)
+		}
+		if hasFiles {
+			blocks[id] = struct{}{}
+			return nil
+		}
+		for _, m := range markers {
+			res = append(res, OrphanedObject{Name: m, Reason: "marker of deleted block"})
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "iter blocks")
+	}
+
+	for _, name := range debugMetas {
+		id, err := ulid.Parse(strings.TrimSuffix(path.Base(name), ".json"))
+		if err != nil {
+			continue
+		}
+		if _, ok := blocks[id]; ok {
+			continue
+		}
+		if now.Sub(ulid.Time(id.Time())) < minAge {
+			continue
+		}
+		res = append(res, OrphanedObject{Name: name, Reason: "debug meta of deleted block"})
+	}
+
+	for i, o := range res {
+		size, err := bkt.ObjectSize(ctx, o.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get size of %s", o.Name)
+		}
+		res[i].Size = size
+	}
+	return res, nil
+}
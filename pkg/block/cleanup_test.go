@@ -0,0 +1,69 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/oklog/ulid"
+)
+
+func TestFindOrphanedObjects(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	now := time.Now()
+
+	entropy := rand.New(rand.NewSource(1))
+	newID := func(age time.Duration) ulid.ULID {
+		return ulid.MustNew(ulid.Timestamp(now.Add(-age)), entropy)
+	}
+	var (
+		live      = newID(72 * time.Hour)
+		uploading = newID(72 * time.Hour)
+		deleted   = newID(72 * time.Hour)
+		recent    = newID(time.Hour)
+	)
+	for _, name := range []string{
+		path.Join(live.String(), MetaFilename),
+		path.Join(live.String(), IndexFilename),
+		path.Join(live.String(), ChunksDirname, "000001"),
+		path.Join(live.String(), NoCompactMarkFilename),
+		path.Join(live.String(), IndexCacheFilename),
+		path.Join(DebugMetas, live.String()+".json"),
+		// Blocks being uploaded have no meta.json yet.
+		path.Join(uploading.String(), ChunksDirname, "000001"),
+		path.Join(DebugMetas, uploading.String()+".json"),
+		path.Join(deleted.String(), DeletionMarkFilename),
+		path.Join(DebugMetas, deleted.String()+".json"),
+		path.Join(DebugMetas, recent.String()+".json"),
+	} {
+		if err := bkt.Upload(ctx, name, bytes.NewBufferString("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphaned, err := FindOrphanedObjects(ctx, bkt, 48*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, o := range orphaned {
+		if o.Size != 4 {
+			t.Errorf("unexpected size %d of %s", o.Size, o.Name)
+		}
+		got[o.Name] = o.Reason
+	}
+	exp := map[string]string{
+		path.Join(live.String(), IndexCacheFilename):      "index cache in bucket",
+		path.Join(deleted.String(), DeletionMarkFilename): "marker of deleted block",
+		path.Join(DebugMetas, deleted.String()+".json"):   "debug meta of deleted block",
+	}
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("unexpected orphaned objects %v", got)
+	}
+}
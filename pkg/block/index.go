@@ -1,13 +1,17 @@
 package block
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"hash/crc32"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -334,6 +338,93 @@ func GatherIndexIssueStats(fn string, minTime int64, maxTime int64) (stats Stats
 	return stats, nil
 }
 
+// VerifyChunks reads all chunks referenced by the index of the block in the given directory and verifies their checksums.
+// Unlike VerifyIndex it reads the full block, so it detects corrupted chunk data that compactions would otherwise
+// carry over into the compacted block.
+func VerifyChunks(bdir string) (err error) {
+	r, err := index.NewFileReader(filepath.Join(bdir, IndexFilename))
+	if err != nil {
+		return errors.Wrap(err, "open index file")
+	}
+	defer runutil.BestEffortErr(nil, &err, r, "verify chunks index reader")
+
+	fis, err := ioutil.ReadDir(filepath.Join(bdir, ChunksDirname))
+	if err != nil {
+		return errors.Wrap(err, "read chunks dir")
+	}
+	// Chunk references address segment files by their position in the sorted list of segment files.
+	var (
+		segs  []*os.File
+		sizes []int64
+	)
+	defer func() {
+		for _, f := range segs {
+			runutil.BestEffortErr(nil, &err, f, "verify chunks segment file")
+		}
+	}()
+	for _, fi := range fis {
+		if _, err := strconv.ParseUint(fi.Name(), 10, 64); err != nil {
+			continue
+		}
+		f, err := os.Open(filepath.Join(bdir, ChunksDirname, fi.Name()))
+		if err != nil {
+			return errors.Wrap(err, "open chunks segment file")
+		}
+		segs = append(segs, f)
+		sizes = append(sizes, fi.Size())
+	}
+
+	p, err := r.Postings(index.AllPostingsKey())
+	if err != nil {
+		return errors.Wrap(err, "get all postings")
+	}
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		if err := r.Series(p.At(), &lset, &chks); err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		for _, c := range chks {
+			seq, off := int(c.Ref>>32), int64(uint32(c.Ref))
+			if seq >= len(segs) {
+				return errors.Errorf("chunk %d of series %s references missing segment %d", c.Ref, lset, seq)
+			}
+			if err := verifyChunk(segs[seq], sizes[seq], off); err != nil {
+				return errors.Wrapf(err, "chunk %d of series %s", c.Ref, lset)
+			}
+		}
+	}
+	return errors.Wrap(p.Err(), "walk postings")
+}
+
+// verifyChunk verifies the checksum of the chunk at the given offset of a segment file of the given size. A chunk
+// is stored as the uvarint length of its data, its encoding, its data and the CRC32 checksum of its encoding and data.
+func verifyChunk(seg *os.File, size int64, off int64) error {
+	var lbuf [binary.MaxVarintLen32]byte
+	n, err := seg.ReadAt(lbuf[:], off)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "read chunk length")
+	}
+	l, ln := binary.Uvarint(lbuf[:n])
+	if ln <= 0 {
+		return errors.New("invalid chunk length")
+	}
+	if off+int64(ln)+1+int64(l)+crc32.Size > size {
+		return errors.Errorf("chunk length %d exceeds the segment file", l)
+	}
+	b := make([]byte, 1+l+crc32.Size)
+	if _, err := seg.ReadAt(b, off+int64(ln)); err != nil {
+		return errors.Wrap(err, "read chunk")
+	}
+	data, sum := b[:1+l], binary.BigEndian.Uint32(b[1+l:])
+	if act := crc32.Checksum(data, castagnoli); act != sum {
+		return errors.Errorf("checksum mismatch, expected %x, got %x", sum, act)
+	}
+	return nil
+}
+
 type ignoreFnType func(mint, maxt int64, prev *chunks.Meta, curr *chunks.Meta) (bool, error)
 
 // Repair open the block with given id in dir and creates a new one with fixed data.
@@ -347,7 +438,17 @@ func Repair(dir string, id ulid.ULID, source SourceType, ignoreChkFns ...ignoreF
 	if len(ignoreChkFns) == 0 {
 		return resid, errors.New("no ignore chunk function specified")
 	}
+	return rewriteBlock(dir, id, source, func(meta *Meta, chks []chunks.Meta) ([]chunks.Meta, error) {
+		return sanitizeChunkSequence(chks, meta.MinTime, meta.MaxTime, ignoreChkFns)
+	})
+}
+
+// rewriteFnType returns the chunks to write for a series in place of the given ones.
+type rewriteFnType func(meta *Meta, chks []chunks.Meta) ([]chunks.Meta, error)
 
+// rewriteBlock writes a copy of the raw block with the given ID in dir with all chunks of each series
+// replaced by the ones returned by rewriteFn. It returns the ID of the new block.
+func rewriteBlock(dir string, id ulid.ULID, source SourceType, rewriteFn rewriteFnType) (resid ulid.ULID, err error) {
 	bdir := filepath.Join(dir, id.String())
 	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
 	resid = ulid.MustNew(ulid.Now(), entropy)
@@ -399,7 +500,7 @@ func Repair(dir string, id ulid.ULID, source SourceType, ignoreChkFns ...ignoreF
 	resmeta.Stats = tsdb.BlockStats{} // reset stats
 	resmeta.Thanos.Source = source    // update source
 
-	if err := rewrite(indexr, chunkr, indexw, chunkw, &resmeta, ignoreChkFns); err != nil {
+	if err := rewrite(indexr, chunkr, indexw, chunkw, &resmeta, rewriteFn); err != nil {
 		return resid, errors.Wrap(err, "rewrite block")
 	}
 	if err := WriteMetaFile(resdir, &resmeta); err != nil {
@@ -492,7 +593,7 @@ func rewrite(
 	indexr tsdb.IndexReader, chunkr tsdb.ChunkReader,
 	indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter,
 	meta *Meta,
-	ignoreChkFns []ignoreFnType,
+	rewriteFn rewriteFnType,
 ) error {
 	symbols, err := indexr.Symbols()
 	if err != nil {
@@ -531,7 +632,7 @@ func rewrite(
 			}
 		}
 
-		chks, err := sanitizeChunkSequence(chks, meta.MinTime, meta.MaxTime, ignoreChkFns)
+		chks, err := rewriteFn(meta, chks)
 		if err != nil {
 			return err
 		}
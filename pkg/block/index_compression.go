@@ -0,0 +1,111 @@
+package block
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/snappy"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/pkg/errors"
+)
+
+// IndexCompressionSnappy marks an index file that is compressed with the snappy framing format.
+const IndexCompressionSnappy = "snappy"
+
+// CompressIndex compresses the index file of the block in bdir and marks it as compressed in its meta.json.
+// The index file is replaced, so bdir must not share it with a live TSDB other than through hard links.
+// Blocks with an already compressed index are left untouched.
+func CompressIndex(bdir string) error {
+	meta, err := ReadMetaFile(bdir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	if meta.Thanos.IndexCompression != "" {
+		return nil
+	}
+	fn := filepath.Join(bdir, IndexFilename)
+	tmp := fn + ".tmp"
+
+	if err := transformFile(fn, tmp, func(w io.Writer, r io.Reader) error {
+		sw := snappy.NewBufferedWriter(w)
+		if _, err := io.Copy(sw, r); err != nil {
+			return err
+		}
+		return sw.Close()
+	}); err != nil {
+		return errors.Wrap(err, "compress index")
+	}
+	if err := renameFile(tmp, fn); err != nil {
+		return errors.Wrap(err, "replace index")
+	}
+	meta.Thanos.IndexCompression = IndexCompressionSnappy
+	return WriteMetaFile(bdir, meta)
+}
+
+// DecompressIndex reverts CompressIndex for the block in bdir. Blocks with an uncompressed index are left untouched.
+func DecompressIndex(bdir string) error {
+	meta, err := ReadMetaFile(bdir)
+	if err != nil {
+		return errors.Wrap(err, "read meta")
+	}
+	if meta.Thanos.IndexCompression == "" {
+		return nil
+	}
+	fn := filepath.Join(bdir, IndexFilename)
+	tmp := fn + ".tmp"
+
+	if err := DecompressIndexFile(meta.Thanos.IndexCompression, fn, tmp); err != nil {
+		return err
+	}
+	if err := renameFile(tmp, fn); err != nil {
+		return errors.Wrap(err, "replace index")
+	}
+	meta.Thanos.IndexCompression = ""
+	return WriteMetaFile(bdir, meta)
+}
+
+// DecompressIndexFile writes the decompressed content of the index file src, compressed with the given compression, to dst.
+func DecompressIndexFile(compression, src, dst string) error {
+	if compression != IndexCompressionSnappy {
+		return errors.Errorf("unknown index compression %q", compression)
+	}
+	err := transformFile(src, dst, func(w io.Writer, r io.Reader) error {
+		_, err := io.Copy(w, snappy.NewReader(r))
+		return err
+	})
+	return errors.Wrap(err, "decompress index")
+}
+
+// transformFile writes the output of fn for the content of src to dst. On error dst is removed.
+func transformFile(src, dst string, fn func(w io.Writer, r io.Reader) error) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer runutil.LogOnErr(nil, in, "close source")
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(dst)
+		}
+	}()
+
+	bw := bufio.NewWriter(out)
+	if err = fn(bw, bufio.NewReader(in)); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
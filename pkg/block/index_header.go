@@ -0,0 +1,573 @@
+package block
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"unsafe"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/fileutil"
+	"github.com/prometheus/tsdb/index"
+)
+
+// IndexHeaderFilename is the canonical name for index header files.
+const IndexHeaderFilename = "index-header"
+
+const (
+	indexMagic    = 0xBAAAD700
+	indexFormatV2 = 2
+	indexTOCLen   = 6*8 + 4
+
+	indexHeaderMagic    = 0xBAAAD7E1
+	indexHeaderVersion1 = 1
+	// Magic, header version, index version, index size and the six TOC offsets.
+	indexHeaderPreludeLen = 4 + 1 + 1 + 8 + 6*8
+)
+
+// Sections of the index that are copied into the index header, in the order they are stored in it.
+const (
+	headerSectionSymbols = iota
+	headerSectionLabelIndices
+	headerSectionLabelIndicesTable
+	headerSectionPostingsTable
+	numHeaderSections
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// indexHeaderPrelude identifies the index an index header was built from.
+type indexHeaderPrelude struct {
+	indexVersion int
+	indexSize    uint64
+
+	symbols, series, labelIndices, labelIndicesTable, postings, postingsTable uint64
+}
+
+func (p *indexHeaderPrelude) encode() []byte {
+	b := make([]byte, indexHeaderPreludeLen)
+	binary.BigEndian.PutUint32(b[0:], indexHeaderMagic)
+	b[4] = indexHeaderVersion1
+	b[5] = byte(p.indexVersion)
+	binary.BigEndian.PutUint64(b[6:], p.indexSize)
+	for i, off := range []uint64{p.symbols, p.series, p.labelIndices, p.labelIndicesTable, p.postings, p.postingsTable} {
+		binary.BigEndian.PutUint64(b[14+8*i:], off)
+	}
+	return b
+}
+
+func decodeIndexHeaderPrelude(b []byte) (*indexHeaderPrelude, error) {
+	if len(b) < indexHeaderPreludeLen {
+		return nil, errors.New("index header too short")
+	}
+	if m := binary.BigEndian.Uint32(b[0:]); m != indexHeaderMagic {
+		return nil, errors.Errorf("invalid index header magic number %x", m)
+	}
+	if v := b[4]; v != indexHeaderVersion1 {
+		return nil, errors.Errorf("unknown index header version %d", v)
+	}
+	p := &indexHeaderPrelude{
+		indexVersion: int(b[5]),
+		indexSize:    binary.BigEndian.Uint64(b[6:]),
+	}
+	for i, off := range []*uint64{&p.symbols, &p.series, &p.labelIndices, &p.labelIndicesTable, &p.postings, &p.postingsTable} {
+		*off = binary.BigEndian.Uint64(b[14+8*i:])
+	}
+	return p, nil
+}
+
+// sectionRange returns the byte range of the given section in the index.
+func (p *indexHeaderPrelude) sectionRange(section int) (start, end uint64) {
+	switch section {
+	case headerSectionSymbols:
+		return p.symbols, p.series
+	case headerSectionLabelIndices:
+		return p.labelIndices, p.postings
+	case headerSectionLabelIndicesTable:
+		return p.labelIndicesTable, p.postingsTable
+	default:
+		return p.postingsTable, p.indexSize - indexTOCLen
+	}
+}
+
+// WriteIndexHeader writes an index header file for the index of the given block. Unlike WriteIndexCache
+// it does not need the full index but only fetches the symbols, label indices and postings offsets
+// by range from the bucket. Sections fetched by an earlier, interrupted call are not fetched again.
+func WriteIndexHeader(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID, fn string) (err error) {
+	tmp := fn + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return errors.Wrap(err, "open file")
+	}
+	defer func() {
+		if f != nil {
+			runutil.LogOnErr(nil, f, "index header writer")
+		}
+	}()
+
+	p, sections, size := resumeIndexHeader(f)
+	if err := f.Truncate(size); err != nil {
+		return errors.Wrap(err, "truncate incomplete index header")
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek")
+	}
+
+	obj := path.Join(id.String(), IndexFilename)
+	if p == nil {
+		if p, err = fetchIndexHeaderPrelude(ctx, bkt, obj); err != nil {
+			return err
+		}
+		if _, err := f.Write(p.encode()); err != nil {
+			return errors.Wrap(err, "write prelude")
+		}
+	}
+	for s := sections; s < numHeaderSections; s++ {
+		if err := fetchIndexHeaderSection(ctx, bkt, obj, p, s, f); err != nil {
+			return errors.Wrapf(err, "fetch index section %d", s)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrap(err, "sync")
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return errors.Wrap(err, "close")
+	}
+	return renameFile(tmp, fn)
+}
+
+// resumeIndexHeader returns the prelude and number of complete sections of a partially written
+// index header as well as the size of its valid part.
+func resumeIndexHeader(f *os.File) (p *indexHeaderPrelude, sections int, size int64) {
+	r := bufio.NewReader(f)
+
+	b := make([]byte, indexHeaderPreludeLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, 0
+	}
+	p, err := decodeIndexHeaderPrelude(b)
+	if err != nil {
+		return nil, 0, 0
+	}
+	size = indexHeaderPreludeLen
+
+	for ; sections < numHeaderSections; sections++ {
+		var l uint64
+		if err := binary.Read(r, binary.BigEndian, &l); err != nil {
+			break
+		}
+		h := crc32.New(castagnoliTable)
+		if n, err := io.CopyN(h, r, int64(l)); err != nil || uint64(n) != l {
+			break
+		}
+		var sum uint32
+		if err := binary.Read(r, binary.BigEndian, &sum); err != nil || sum != h.Sum32() {
+			break
+		}
+		size += 8 + int64(l) + 4
+	}
+	return p, sections, size
+}
+
+func fetchIndexHeaderPrelude(ctx context.Context, bkt objstore.BucketReader, obj string) (*indexHeaderPrelude, error) {
+	size, err := bkt.ObjectSize(ctx, obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "get index size")
+	}
+	if size < 5+indexTOCLen {
+		return nil, errors.Errorf("index of %d bytes is too short", size)
+	}
+	b, err := getRange(ctx, bkt, obj, 0, 5)
+	if err != nil {
+		return nil, errors.Wrap(err, "read index magic")
+	}
+	if m := binary.BigEndian.Uint32(b); m != indexMagic {
+		return nil, errors.Errorf("invalid index magic number %x", m)
+	}
+	toc, err := getRange(ctx, bkt, obj, int64(size-indexTOCLen), indexTOCLen)
+	if err != nil {
+		return nil, errors.Wrap(err, "read index TOC")
+	}
+	if binary.BigEndian.Uint32(toc[indexTOCLen-4:]) != crc32.Checksum(toc[:indexTOCLen-4], castagnoliTable) {
+		return nil, errors.New("index TOC checksum mismatch")
+	}
+	p := &indexHeaderPrelude{
+		indexVersion: int(b[4]),
+		indexSize:    size,
+	}
+	for i, off := range []*uint64{&p.symbols, &p.series, &p.labelIndices, &p.labelIndicesTable, &p.postings, &p.postingsTable} {
+		*off = binary.BigEndian.Uint64(toc[8*i:])
+	}
+	return p, nil
+}
+
+func fetchIndexHeaderSection(ctx context.Context, bkt objstore.BucketReader, obj string, p *indexHeaderPrelude, section int, w io.Writer) error {
+	start, end := p.sectionRange(section)
+	if end < start {
+		return errors.Errorf("invalid range [%d, %d)", start, end)
+	}
+	rc, err := bkt.GetRange(ctx, obj, int64(start), int64(end-start))
+	if err != nil {
+		return errors.Wrap(err, "get range reader")
+	}
+	defer runutil.LogOnErr(nil, rc, "index section reader")
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.BigEndian, end-start); err != nil {
+		return err
+	}
+	h := crc32.New(castagnoliTable)
+	n, err := io.Copy(io.MultiWriter(bw, h), rc)
+	if err != nil {
+		return errors.Wrap(err, "read range")
+	}
+	if uint64(n) != end-start {
+		return errors.Errorf("expected %d bytes, got %d", end-start, n)
+	}
+	if err := binary.Write(bw, binary.BigEndian, h.Sum32()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func getRange(ctx context.Context, bkt objstore.BucketReader, obj string, off, length int64) ([]byte, error) {
+	rc, err := bkt.GetRange(ctx, obj, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer runutil.LogOnErr(nil, rc, "range reader")
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(rc, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// IndexHeader serves the lookups of the symbols, label values and postings offsets of a block index from an
+// mmapped index header file, so they are paged in from disk on demand instead of being held in memory. Only the
+// positions of symbols, label indices and postings lists are kept in memory. Returned strings are copies and stay
+// valid after the header is closed.
+type IndexHeader struct {
+	f        *fileutil.MmapFile
+	prelude  *indexHeaderPrelude
+	sections [numHeaderSections][]byte
+
+	// Positions of the symbols in the symbols section by their sequence number. Only used for index version 2,
+	// in which symbols are referenced by sequence number instead of their offset in the index.
+	symbols []uint32
+	// Positions of the label index of each label name in the label indices section.
+	labelIndices map[string]uint64
+	// Postings lists of each label name, sorted by value. The names refer to the mmapped file.
+	postings map[string][]headerPostings
+}
+
+type headerPostings struct {
+	// Value refers to the mmapped file and must not be used after it is closed.
+	value string
+	rng   index.Range
+}
+
+// OpenIndexHeader mmaps an index header file and reads the positions of its symbols, label indices and
+// postings lists. The header must be closed once it is no longer used.
+func OpenIndexHeader(fn string) (_ *IndexHeader, err error) {
+	f, err := fileutil.OpenMmapFile(fn)
+	if err != nil {
+		return nil, errors.Wrap(err, "mmap file")
+	}
+	defer func() {
+		if err != nil {
+			runutil.LogOnErr(nil, f, "index header")
+		}
+	}()
+
+	h := &IndexHeader{f: f, labelIndices: map[string]uint64{}, postings: map[string][]headerPostings{}}
+
+	b := f.Bytes()
+	if h.prelude, err = decodeIndexHeaderPrelude(b); err != nil {
+		return nil, err
+	}
+	b = b[indexHeaderPreludeLen:]
+	for s := range h.sections {
+		if len(b) < 8 {
+			return nil, errors.Errorf("index header section %d missing", s)
+		}
+		l := binary.BigEndian.Uint64(b)
+		if uint64(len(b)) < 8+l+4 {
+			return nil, errors.Errorf("index header section %d truncated", s)
+		}
+		h.sections[s] = b[8 : 8+l]
+		if binary.BigEndian.Uint32(b[8+l:]) != crc32.Checksum(h.sections[s], castagnoliTable) {
+			return nil, errors.Errorf("index header section %d checksum mismatch", s)
+		}
+		b = b[8+l+4:]
+	}
+
+	if h.prelude.indexVersion == indexFormatV2 {
+		if h.symbols, err = readHeaderSymbolPositions(h.sections[headerSectionSymbols]); err != nil {
+			return nil, errors.Wrap(err, "read symbols")
+		}
+	}
+
+	err = readHeaderOffsetTable(h.sections[headerSectionLabelIndicesTable], func(keys [][]byte, off uint64) error {
+		if len(keys) != 1 {
+			return nil
+		}
+		if off < h.prelude.labelIndices {
+			return errors.Errorf("label index offset %d outside of label indices", off)
+		}
+		h.labelIndices[string(keys[0])] = off - h.prelude.labelIndices
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "read label indices")
+	}
+
+	type postingsOffset struct {
+		name, value string
+		off         uint64
+	}
+	var offs []postingsOffset
+
+	err = readHeaderOffsetTable(h.sections[headerSectionPostingsTable], func(keys [][]byte, off uint64) error {
+		if len(keys) != 2 {
+			return errors.Errorf("unexpected key length %d", len(keys))
+		}
+		offs = append(offs, postingsOffset{name: yoloString(keys[0]), value: yoloString(keys[1]), off: off})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "read postings offsets")
+	}
+
+	// Postings lists are stored back to back and each is followed by a checksum. As the lists are aligned
+	// to 4 bytes and their length is a multiple of 4, each one ends 4 bytes before the next one starts.
+	// The last one is followed by the label indices table.
+	sort.Slice(offs, func(i, j int) bool { return offs[i].off < offs[j].off })
+
+	for i, o := range offs {
+		end := h.prelude.labelIndicesTable
+		if i+1 < len(offs) {
+			end = offs[i+1].off
+		}
+		h.postings[o.name] = append(h.postings[o.name], headerPostings{
+			value: o.value,
+			rng:   index.Range{Start: int64(o.off) + 4, End: int64(end) - 4},
+		})
+	}
+	for _, ps := range h.postings {
+		sort.Slice(ps, func(i, j int) bool { return ps[i].value < ps[j].value })
+	}
+	return h, nil
+}
+
+// Version returns the format version of the index the header was built from.
+func (h *IndexHeader) Version() int {
+	return h.prelude.indexVersion
+}
+
+// LookupSymbol returns the symbol with the given reference. Symbols are referenced by their offset in the index
+// in version 1 and by their sequence number in version 2.
+func (h *IndexHeader) LookupSymbol(ref uint32) (string, error) {
+	var pos uint64
+	if h.prelude.indexVersion == indexFormatV2 {
+		if uint64(ref) >= uint64(len(h.symbols)) {
+			return "", errors.Errorf("unknown symbol reference %d", ref)
+		}
+		pos = uint64(h.symbols[ref])
+	} else {
+		if uint64(ref) < h.prelude.symbols+8 {
+			return "", errors.Errorf("unknown symbol reference %d", ref)
+		}
+		pos = uint64(ref) - h.prelude.symbols
+	}
+	b := h.sections[headerSectionSymbols]
+	if pos >= uint64(len(b)) {
+		return "", errors.Errorf("unknown symbol reference %d", ref)
+	}
+	s, _, err := uvarintBytes(b[pos:])
+	if err != nil {
+		return "", errors.Wrapf(err, "symbol reference %d", ref)
+	}
+	return string(s), nil
+}
+
+// LabelNames returns the sorted names of all labels of the index.
+func (h *IndexHeader) LabelNames() []string {
+	names := make([]string, 0, len(h.labelIndices))
+	for n := range h.labelIndices {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LabelValues returns the sorted values of the label with the given name.
+func (h *IndexHeader) LabelValues(name string) ([]string, error) {
+	pos, ok := h.labelIndices[name]
+	if !ok {
+		return nil, nil
+	}
+	d, err := headerSectionAt(h.sections[headerSectionLabelIndices], pos)
+	if err != nil {
+		return nil, errors.Wrapf(err, "label index of %s", name)
+	}
+	if len(d) < 8 {
+		return nil, errors.Errorf("label index of %s too short", name)
+	}
+	if nc := binary.BigEndian.Uint32(d); nc != 1 {
+		return nil, errors.Errorf("unexpected tuple length %d", nc)
+	}
+	d = d[8:]
+
+	vals := make([]string, 0, len(d)/4)
+	for ; len(d) >= 4; d = d[4:] {
+		v, err := h.LookupSymbol(binary.BigEndian.Uint32(d))
+		if err != nil {
+			return nil, errors.Wrapf(err, "label index of %s", name)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// PostingsRange returns the range of the postings list of the given label pair in the index.
+func (h *IndexHeader) PostingsRange(name, value string) (index.Range, bool) {
+	ps := h.postings[name]
+
+	i := sort.Search(len(ps), func(i int) bool { return ps[i].value >= value })
+	if i == len(ps) || ps[i].value != value {
+		return index.Range{}, false
+	}
+	return ps[i].rng, true
+}
+
+// MemoryBytes estimates the memory held by the header, excluding the mmapped file.
+func (h *IndexHeader) MemoryBytes() (n int) {
+	const (
+		stringHeader = 16
+		sliceHeader  = 24
+		mapEntry     = 8
+	)
+	n += len(h.symbols) * 4
+	for name := range h.labelIndices {
+		n += stringHeader + len(name) + 8 + mapEntry
+	}
+	for _, ps := range h.postings {
+		n += stringHeader + sliceHeader + len(ps)*(stringHeader+16) + mapEntry
+	}
+	return n
+}
+
+// Close unmaps the index header file.
+func (h *IndexHeader) Close() error {
+	return h.f.Close()
+}
+
+// headerSectionAt returns the checksummed data at the given offset of a section, i.e. the bytes
+// after the length prefix.
+func headerSectionAt(b []byte, off uint64) ([]byte, error) {
+	if uint64(len(b)) < off+4 {
+		return nil, errors.Errorf("offset %d out of range", off)
+	}
+	l := uint64(binary.BigEndian.Uint32(b[off:]))
+	if uint64(len(b)) < off+4+l+4 {
+		return nil, errors.Errorf("length %d at offset %d out of range", l, off)
+	}
+	d := b[off+4 : off+4+l]
+	if binary.BigEndian.Uint32(b[off+4+l:]) != crc32.Checksum(d, castagnoliTable) {
+		return nil, errors.Errorf("checksum mismatch at offset %d", off)
+	}
+	return d, nil
+}
+
+// readHeaderSymbolPositions returns the positions of the symbols in the given symbols section by their
+// sequence number.
+func readHeaderSymbolPositions(b []byte) ([]uint32, error) {
+	d, err := headerSectionAt(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d) < 4 {
+		return nil, errors.New("symbol table too short")
+	}
+	cnt := binary.BigEndian.Uint32(d)
+
+	positions := make([]uint32, 0, cnt)
+	for pos := 4; uint32(len(positions)) < cnt; {
+		_, n, err := uvarintBytes(d[pos:])
+		if err != nil {
+			return nil, err
+		}
+		// The data of the section starts after the 4 bytes of its length.
+		positions = append(positions, uint32(4+pos))
+		pos += n
+	}
+	return positions, nil
+}
+
+// readHeaderOffsetTable calls f for each entry of the offset table in b.
+func readHeaderOffsetTable(b []byte, f func(keys [][]byte, off uint64) error) error {
+	d, err := headerSectionAt(b, 0)
+	if err != nil {
+		return err
+	}
+	if len(d) < 4 {
+		return errors.New("offset table too short")
+	}
+	cnt := binary.BigEndian.Uint32(d)
+	d = d[4:]
+
+	for ; cnt > 0; cnt-- {
+		keyCount, n := binary.Uvarint(d)
+		if n <= 0 {
+			return errors.New("invalid key count")
+		}
+		d = d[n:]
+
+		keys := make([][]byte, 0, keyCount)
+		for i := uint64(0); i < keyCount; i++ {
+			k, n, err := uvarintBytes(d)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+			d = d[n:]
+		}
+		off, n := binary.Uvarint(d)
+		if n <= 0 {
+			return errors.New("invalid offset")
+		}
+		d = d[n:]
+
+		if err := f(keys, off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uvarintBytes returns the length-prefixed bytes at the start of b and the number of bytes read.
+func uvarintBytes(b []byte) ([]byte, int, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, 0, errors.New("invalid length-prefixed string")
+	}
+	return b[n : n+int(l)], n + int(l), nil
+}
+
+// yoloString returns a string that refers to the given bytes without copying them.
+func yoloString(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
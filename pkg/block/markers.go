@@ -0,0 +1,113 @@
+package block
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DeletionMarkFilename is the known JSON filename of the marker that tells compactors to delete the block
+	// and stores to no longer serve it.
+	DeletionMarkFilename = "deletion-mark.json"
+	// NoCompactMarkFilename is the known JSON filename of the marker that tells compactors to never compact the block.
+	NoCompactMarkFilename = "no-compact-mark.json"
+
+	// MarkerVersion1 is the version of the marker format.
+	MarkerVersion1 = 1
+)
+
+// Marker is the content of a marker file that an operator put into the directory of a block.
+type Marker struct {
+	ID      ulid.ULID `json:"id"`
+	Version int       `json:"version"`
+	// Unix time in seconds at which the block was marked.
+	Time int64 `json:"time"`
+	// Why the block was marked, for auditing.
+	Details string `json:"details,omitempty"`
+}
+
+// IsMarkerFilename returns whether the name is the filename of a known marker.
+func IsMarkerFilename(name string) bool {
+	return name == DeletionMarkFilename || name == NoCompactMarkFilename
+}
+
+// MarkBlock puts a marker with the given filename and details into the directory of the block.
+// Blocks that are already marked are left untouched, so the original mark time is kept.
+func MarkBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, markerFilename, details string) error {
+	if !IsMarkerFilename(markerFilename) {
+		return errors.Errorf("unknown marker %s", markerFilename)
+	}
+	name := path.Join(id.String(), markerFilename)
+
+	ok, err := bkt.Exists(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "check %s", name)
+	}
+	if ok {
+		level.Warn(logger).Log("msg", "block is already marked, ignoring", "block", id, "marker", markerFilename)
+		return nil
+	}
+	m := Marker{
+		ID:      id,
+		Version: MarkerVersion1,
+		Time:    time.Now().Unix(),
+		Details: details,
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "\t")
+
+	if err := enc.Encode(&m); err != nil {
+		return errors.Wrap(err, "encode marker")
+	}
+	return errors.Wrapf(bkt.Upload(ctx, name, &buf), "upload %s", name)
+}
+
+// UnmarkBlock removes the marker with the given filename from the directory of the block.
+// Blocks that are not marked are left untouched.
+func UnmarkBlock(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, markerFilename string) error {
+	if !IsMarkerFilename(markerFilename) {
+		return errors.Errorf("unknown marker %s", markerFilename)
+	}
+	name := path.Join(id.String(), markerFilename)
+
+	ok, err := bkt.Exists(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "check %s", name)
+	}
+	if !ok {
+		level.Warn(logger).Log("msg", "block is not marked, ignoring", "block", id, "marker", markerFilename)
+		return nil
+	}
+	return errors.Wrapf(bkt.Delete(ctx, name), "delete %s", name)
+}
+
+// ReadMarker returns the marker with the given filename of the block or nil if the block is not marked.
+func ReadMarker(ctx context.Context, logger log.Logger, bkt objstore.BucketReader, id ulid.ULID, markerFilename string) (*Marker, error) {
+	name := path.Join(id.String(), markerFilename)
+
+	rc, err := bkt.Get(ctx, name)
+	if bkt.IsObjNotFoundErr(errors.Cause(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", name)
+	}
+	defer runutil.LogOnErr(logger, rc, "marker reader")
+
+	var m Marker
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "decode %s", name)
+	}
+	return &m, nil
+}
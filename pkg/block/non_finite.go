@@ -0,0 +1,179 @@
+package block
+
+import (
+	"math"
+	"path/filepath"
+
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// NonFiniteChunk is a chunk of a series that contains NaN or infinite sample values.
+type NonFiniteChunk struct {
+	Series   labels.Labels
+	ChunkRef uint64
+	// Samples is the number of non-finite samples in the chunk.
+	Samples int
+}
+
+// isNonFinite returns true for NaN and infinite values. Staleness markers are NaN values that
+// Prometheus writes on purpose, so they are not considered.
+func isNonFinite(v float64) bool {
+	if math.IsInf(v, 0) {
+		return true
+	}
+	return math.IsNaN(v) && !value.IsStaleNaN(v)
+}
+
+// FindNonFiniteChunks returns all chunks of the raw block in the given directory that contain NaN or
+// infinite sample values. Such values poison the aggregates of downsampling, e.g. counter resets.
+func FindNonFiniteChunks(bdir string) (res []NonFiniteChunk, err error) {
+	meta, err := ReadMetaFile(bdir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meta file")
+	}
+	if meta.Thanos.Downsample.Resolution > 0 {
+		return nil, errors.New("cannot check samples of downsampled block")
+	}
+
+	b, err := tsdb.OpenBlock(bdir, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer runutil.BestEffortErr(nil, &err, b, "block reader")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "open index")
+	}
+	defer runutil.BestEffortErr(nil, &err, indexr, "index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return nil, errors.Wrap(err, "open chunks")
+	}
+	defer runutil.BestEffortErr(nil, &err, chunkr, "chunk reader")
+
+	p, err := indexr.Postings(index.AllPostingsKey())
+	if err != nil {
+		return nil, errors.Wrap(err, "get all postings")
+	}
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		if err := indexr.Series(p.At(), &lset, &chks); err != nil {
+			return nil, errors.Wrap(err, "read series")
+		}
+		for _, c := range chks {
+			chk, err := chunkr.Chunk(c.Ref)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read chunk %d of series %s", c.Ref, lset)
+			}
+			n, err := countNonFinite(chk)
+			if err != nil {
+				return nil, errors.Wrapf(err, "decode chunk %d of series %s", c.Ref, lset)
+			}
+			if n > 0 {
+				res = append(res, NonFiniteChunk{
+					Series:   append(labels.Labels(nil), lset...),
+					ChunkRef: c.Ref,
+					Samples:  n,
+				})
+			}
+		}
+	}
+	if p.Err() != nil {
+		return nil, errors.Wrap(p.Err(), "walk postings")
+	}
+	return res, nil
+}
+
+func countNonFinite(chk chunkenc.Chunk) (int, error) {
+	var n int
+	it := chk.Iterator()
+	for it.Next() {
+		if _, v := it.At(); isNonFinite(v) {
+			n++
+		}
+	}
+	return n, it.Err()
+}
+
+// RepairNonFiniteSamples creates a copy of the raw block with given id in dir in which all NaN and infinite
+// sample values are dropped or, if zero is true, replaced by 0. Staleness markers are kept.
+// It returns the ID of the new block.
+func RepairNonFiniteSamples(dir string, id ulid.ULID, source SourceType, zero bool) (ulid.ULID, error) {
+	meta, err := ReadMetaFile(filepath.Join(dir, id.String()))
+	if err != nil {
+		return ulid.ULID{}, errors.Wrap(err, "read meta file")
+	}
+	if meta.Thanos.Downsample.Resolution > 0 {
+		return ulid.ULID{}, errors.New("cannot repair downsampled block")
+	}
+	return rewriteBlock(dir, id, source, func(_ *Meta, chks []chunks.Meta) ([]chunks.Meta, error) {
+		res := make([]chunks.Meta, 0, len(chks))
+		for _, c := range chks {
+			n, err := countNonFinite(c.Chunk)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				res = append(res, c)
+				continue
+			}
+			rc, ok, err := replaceNonFinite(c, zero)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				res = append(res, rc)
+			}
+		}
+		return res, nil
+	})
+}
+
+// replaceNonFinite re-encodes the chunk with non-finite samples dropped or set to 0. It returns false if
+// no samples are left.
+func replaceNonFinite(c chunks.Meta, zero bool) (chunks.Meta, bool, error) {
+	res := chunkenc.NewXORChunk()
+	app, err := res.Appender()
+	if err != nil {
+		return c, false, err
+	}
+	var (
+		mint int64 = math.MaxInt64
+		maxt int64 = math.MinInt64
+	)
+	it := c.Chunk.Iterator()
+	for it.Next() {
+		t, v := it.At()
+		if isNonFinite(v) {
+			if !zero {
+				continue
+			}
+			v = 0
+		}
+		app.Append(t, v)
+		if t < mint {
+			mint = t
+		}
+		maxt = t
+	}
+	if it.Err() != nil {
+		return c, false, it.Err()
+	}
+	if res.NumSamples() == 0 {
+		return c, false, nil
+	}
+	return chunks.Meta{MinTime: mint, MaxTime: maxt, Chunk: res}, true, nil
+}
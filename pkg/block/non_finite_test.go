@@ -0,0 +1,98 @@
+package block
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+)
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func TestReplaceNonFinite(t *testing.T) {
+	chk := chunkenc.NewXORChunk()
+	app, err := chk.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Append(1, math.NaN())
+	app.Append(2, 1)
+	app.Append(3, math.Float64frombits(value.StaleNaN))
+	app.Append(4, math.Inf(1))
+	app.Append(5, 2)
+	app.Append(6, math.Inf(-1))
+
+	n, err := countNonFinite(chk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 non-finite samples, got %d", n)
+	}
+
+	for _, tc := range []struct {
+		zero     bool
+		mint     int64
+		maxt     int64
+		expected []sample
+	}{
+		{
+			zero:     false,
+			mint:     2,
+			maxt:     5,
+			expected: []sample{{2, 1}, {3, 0}, {5, 2}},
+		},
+		{
+			zero:     true,
+			mint:     1,
+			maxt:     6,
+			expected: []sample{{1, 0}, {2, 1}, {3, 0}, {4, 0}, {5, 2}, {6, 0}},
+		},
+	} {
+		res, ok, err := replaceNonFinite(chunks.Meta{MinTime: 1, MaxTime: 6, Chunk: chk}, tc.zero)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("zero %v: expected samples to be left", tc.zero)
+		}
+		if res.MinTime != tc.mint || res.MaxTime != tc.maxt {
+			t.Fatalf("zero %v: expected time range %d-%d, got %d-%d", tc.zero, tc.mint, tc.maxt, res.MinTime, res.MaxTime)
+		}
+
+		var got []sample
+		it := res.Chunk.Iterator()
+		for it.Next() {
+			ts, v := it.At()
+			if value.IsStaleNaN(v) {
+				// Staleness markers are kept, but NaN is not comparable.
+				v = 0
+			}
+			got = append(got, sample{ts, v})
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if !reflect.DeepEqual(tc.expected, got) {
+			t.Fatalf("zero %v: expected %v, got %v", tc.zero, tc.expected, got)
+		}
+	}
+
+	// Chunks without finite samples are dropped.
+	nan := chunkenc.NewXORChunk()
+	app, err = nan.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Append(1, math.NaN())
+
+	if _, ok, err := replaceNonFinite(chunks.Meta{MinTime: 1, MaxTime: 1, Chunk: nan}, false); err != nil || ok {
+		t.Fatalf("expected chunk to be dropped, got %v, %v", ok, err)
+	}
+}
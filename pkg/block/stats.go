@@ -0,0 +1,234 @@
+package block
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
+	"github.com/prometheus/tsdb/index"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// gatherStats counts the series, chunks and samples of the block with the given ID from the index in the given file
+// and the chunk files in the bucket. Chunk files are read by range and only the referenced chunks are decoded,
+// nothing is written to disk. The pool must be able to read the chunk encodings of the block, e.g. the pool of the
+// downsample package for downsampled blocks. The number of tombstones is not counted.
+func gatherStats(
+	ctx context.Context,
+	bkt objstore.BucketReader,
+	id ulid.ULID,
+	indexFn string,
+	pool chunkenc.Pool,
+) (stats tsdb.BlockStats, err error) {
+	indexr, err := index.NewFileReader(indexFn)
+	if err != nil {
+		return stats, errors.Wrap(err, "open index")
+	}
+	defer runutil.BestEffortErr(nil, &err, indexr, "index reader")
+
+	p, err := indexr.Postings(index.AllPostingsKey())
+	if err != nil {
+		return stats, errors.Wrap(err, "get all postings")
+	}
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+		// Offsets of the chunks of the block by the sequence number of their chunk file.
+		offs = map[int][]uint32{}
+	)
+	for p.Next() {
+		if err := indexr.Series(p.At(), &lset, &chks); err != nil {
+			return stats, errors.Wrap(err, "read series")
+		}
+		stats.NumSeries++
+		stats.NumChunks += uint64(len(chks))
+
+		for _, c := range chks {
+			seq := int(c.Ref >> 32)
+			offs[seq] = append(offs[seq], uint32(c.Ref))
+		}
+	}
+	if p.Err() != nil {
+		return stats, errors.Wrap(p.Err(), "walk postings")
+	}
+
+	var objs []string
+	if err := bkt.Iter(ctx, path.Join(id.String(), ChunksDirname), func(n string) error {
+		objs = append(objs, n)
+		return nil
+	}); err != nil {
+		return stats, errors.Wrap(err, "list chunk files")
+	}
+	sort.Strings(objs)
+
+	for seq, o := range offs {
+		if seq >= len(objs) {
+			return stats, errors.Errorf("chunk file %d referenced by the index does not exist", seq)
+		}
+		sort.Slice(o, func(i, j int) bool { return o[i] < o[j] })
+
+		n, err := countSamples(ctx, bkt, objs[seq], o, pool)
+		if err != nil {
+			return stats, errors.Wrapf(err, "count samples of %s", objs[seq])
+		}
+		stats.NumSamples += n
+	}
+	return stats, nil
+}
+
+// countSamples counts the samples of the chunks at the given sorted offsets of a chunk file. The file is read with
+// a single range request from the first chunk on and chunks are decoded one at a time, skipping unreferenced ones.
+func countSamples(ctx context.Context, bkt objstore.BucketReader, name string, offs []uint32, pool chunkenc.Pool) (n uint64, err error) {
+	if len(offs) == 0 {
+		return 0, nil
+	}
+	size, err := bkt.ObjectSize(ctx, name)
+	if err != nil {
+		return 0, errors.Wrap(err, "get size")
+	}
+	start := int64(offs[0])
+	if start >= int64(size) {
+		return 0, errors.Errorf("chunk offset %d beyond file size %d", start, size)
+	}
+	rc, err := bkt.GetRange(ctx, name, start, int64(size)-start)
+	if err != nil {
+		return 0, errors.Wrap(err, "get range")
+	}
+	defer runutil.BestEffortErr(nil, &err, rc, "chunk file reader")
+
+	var (
+		r       = bufio.NewReader(rc)
+		pos     = start
+		buf     []byte
+		varint  [binary.MaxVarintLen64]byte
+		last    = int64(-1)
+		samples uint64
+	)
+	for _, o := range offs {
+		off := int64(o)
+		// The same chunk may be referenced more than once, e.g. by broken indexes.
+		if off == last {
+			n += samples
+			continue
+		}
+		if off < pos {
+			return n, errors.Errorf("chunk at offset %d overlaps previous chunk", off)
+		}
+		if _, err := r.Discard(int(off - pos)); err != nil {
+			return n, errors.Wrapf(err, "skip to chunk at offset %d", off)
+		}
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return n, errors.Wrapf(err, "read length of chunk at offset %d", off)
+		}
+		enc, err := r.ReadByte()
+		if err != nil {
+			return n, errors.Wrapf(err, "read encoding of chunk at offset %d", off)
+		}
+		if uint64(cap(buf)) < l {
+			buf = make([]byte, l)
+		}
+		buf = buf[:l]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return n, errors.Wrapf(err, "read chunk at offset %d", off)
+		}
+		chk, err := pool.Get(chunkenc.Encoding(enc), buf)
+		if err != nil {
+			return n, errors.Wrapf(err, "decode chunk at offset %d", off)
+		}
+		samples = uint64(chk.NumSamples())
+		n += samples
+
+		// Skip the checksum of the chunk.
+		if _, err := r.Discard(crc32.Size); err != nil {
+			return n, errors.Wrapf(err, "skip checksum of chunk at offset %d", off)
+		}
+		last = off
+		pos = off + int64(binary.PutUvarint(varint[:], l)) + 1 + int64(l) + crc32.Size
+	}
+	return n, nil
+}
+
+// RepairStats recomputes the stats of the block with the given ID from its index and chunks and replaces the
+// meta.json of the block in the bucket if they differ from the recorded ones, unless dryRun is set. Only the index
+// is downloaded into a temporary directory within dir, chunks are read from the bucket by range. Chunks and index
+// are not modified, so repeated repairs are no-ops. It returns the recorded and the recomputed stats.
+func RepairStats(
+	ctx context.Context,
+	logger log.Logger,
+	bkt objstore.Bucket,
+	id ulid.ULID,
+	dir string,
+	pool chunkenc.Pool,
+	dryRun bool,
+) (recorded, actual tsdb.BlockStats, err error) {
+	meta, err := DownloadMeta(ctx, logger, bkt, id)
+	if err != nil {
+		return recorded, actual, errors.Wrap(err, "download meta")
+	}
+	recorded = meta.Stats
+
+	tmpdir, err := ioutil.TempDir(dir, "repair-stats-")
+	if err != nil {
+		return recorded, actual, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	indexFn := filepath.Join(tmpdir, IndexFilename)
+	if meta.Thanos.IndexCompression == "" {
+		if err := objstore.DownloadFile(ctx, bkt, path.Join(id.String(), IndexFilename), indexFn); err != nil {
+			return recorded, actual, errors.Wrap(err, "download index")
+		}
+	} else {
+		compressed := indexFn + ".compressed"
+		if err := objstore.DownloadFile(ctx, bkt, path.Join(id.String(), IndexFilename), compressed); err != nil {
+			return recorded, actual, errors.Wrap(err, "download index")
+		}
+		if err := DecompressIndexFile(meta.Thanos.IndexCompression, compressed, indexFn); err != nil {
+			return recorded, actual, err
+		}
+		if err := os.Remove(compressed); err != nil {
+			return recorded, actual, err
+		}
+	}
+	actual, err = gatherStats(ctx, bkt, id, indexFn, pool)
+	if err != nil {
+		return recorded, actual, errors.Wrap(err, "gather stats")
+	}
+	// Tombstones are not counted, Thanos blocks never have any.
+	actual.NumTombstones = recorded.NumTombstones
+
+	if actual == recorded || dryRun {
+		return recorded, actual, nil
+	}
+
+	// The meta.json of the bucket is kept apart from the stats, e.g. the compression of the index.
+	meta.Stats = actual
+
+	mdir := filepath.Join(tmpdir, "meta")
+	if err := os.MkdirAll(mdir, os.ModePerm); err != nil {
+		return recorded, actual, err
+	}
+	if err := WriteMetaFile(mdir, &meta); err != nil {
+		return recorded, actual, errors.Wrap(err, "write meta")
+	}
+	if err := objstore.UploadFile(ctx, bkt, filepath.Join(mdir, MetaFilename), path.Join(id.String(), MetaFilename)); err != nil {
+		return recorded, actual, errors.Wrap(err, "upload meta")
+	}
+	return recorded, actual, nil
+}
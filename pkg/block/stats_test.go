@@ -0,0 +1,120 @@
+package block
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestRepairStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repair-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := tsdb.NewHead(nil, nil, tsdb.NopWAL(), 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	app := h.Appender()
+	for i := int64(0); i < 10; i++ {
+		for _, lset := range []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")} {
+			if _, err := app.Add(lset, i*100, float64(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{10000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := c.Write(dir, h, 0, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdir := filepath.Join(dir, id.String())
+
+	meta, err := InjectThanosMeta(bdir, ThanosMeta{Labels: map[string]string{"ext": "1"}, Source: BucketRepairSource}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := tsdb.BlockStats{NumSeries: 2, NumChunks: 2, NumSamples: 20}
+	if meta.Stats != expected {
+		t.Fatalf("unexpected stats written by TSDB %+v", meta.Stats)
+	}
+
+	// Break the stats of the uploaded block.
+	meta.Stats = tsdb.BlockStats{NumSeries: 1, NumChunks: 5, NumSamples: 7}
+	if err := WriteMetaFile(bdir, meta); err != nil {
+		t.Fatal(err)
+	}
+	// Compress the index of the uploaded block, it has to be decompressed to count its series.
+	if err := CompressIndex(bdir); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	if err := Upload(ctx, bkt, bdir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A dry run only reports the wrong stats.
+	recorded, actual, err := RepairStats(ctx, nil, bkt, id, dir, chunkenc.NewPool(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != meta.Stats || actual != expected {
+		t.Fatalf("unexpected stats %+v and %+v", recorded, actual)
+	}
+	m, err := DownloadMeta(ctx, nil, bkt, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Stats != meta.Stats {
+		t.Fatalf("stats changed by dry run to %+v", m.Stats)
+	}
+
+	recorded, actual, err = RepairStats(ctx, nil, bkt, id, dir, chunkenc.NewPool(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != meta.Stats || actual != expected {
+		t.Fatalf("unexpected stats %+v and %+v", recorded, actual)
+	}
+	m, err = DownloadMeta(ctx, nil, bkt, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Stats != expected {
+		t.Fatalf("stats not repaired, got %+v", m.Stats)
+	}
+	if m.Thanos.Labels["ext"] != "1" {
+		t.Fatalf("unexpected external labels %v", m.Thanos.Labels)
+	}
+	if m.Thanos.IndexCompression != IndexCompressionSnappy {
+		t.Fatalf("unexpected index compression %q", m.Thanos.IndexCompression)
+	}
+
+	// Repairing again is a no-op.
+	recorded, actual, err = RepairStats(ctx, nil, bkt, id, dir, chunkenc.NewPool(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != expected || actual != expected {
+		t.Fatalf("unexpected stats %+v and %+v", recorded, actual)
+	}
+}
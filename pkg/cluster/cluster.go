@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	gmetrics "github.com/armon/go-metrics"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/hashicorp/memberlist"
@@ -35,6 +36,9 @@ type Peer struct {
 	data                 *data
 	gossipMsgsReceived   prometheus.Counter
 	gossipClusterMembers prometheus.Gauge
+	gossipMemberEvents   *prometheus.CounterVec
+	// delegate broadcasts the state of the joined peer. It is guarded by mlistMtx.
+	delegate *delegate
 
 	// Own External gRPC StoreAPI host:port (if any) to propagate to other peers.
 	advertiseStoreAPIAddr string
@@ -75,6 +79,10 @@ type PeerState struct {
 
 	// Metadata holds metadata of the peer holding the state.
 	Metadata PeerMetadata
+
+	// Updated is the time in milliseconds at which the peer holding the state last propagated it. The peer refreshes it
+	// on every push/pull of its state, so the state ages only if it is no longer propagated by the peer.
+	Updated int64
 }
 
 // PeerMetadata are the information that can change in runtime of the peer.
@@ -101,6 +109,8 @@ func New(
 	pushPullInterval time.Duration,
 	gossipInterval time.Duration,
 	refreshInterval time.Duration,
+	secretKeys [][]byte,
+	primaryKey int,
 ) (*Peer, error) {
 	l = log.With(l, "component", "cluster")
 
@@ -148,6 +158,14 @@ func New(
 		cfg.AdvertiseAddr = advertiseHost
 		cfg.AdvertisePort = advertisePort
 	}
+	if len(secretKeys) > 0 {
+		keyring, err := newKeyring(secretKeys, primaryKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "create keyring")
+		}
+		cfg.Keyring = keyring
+		level.Info(l).Log("msg", "gossip encryption enabled", "keys", len(secretKeys), "primaryKey", primaryKey)
+	}
 
 	gossipMsgsReceived := prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "thanos_gossip_messages_received_total",
@@ -158,22 +176,123 @@ func New(
 		Help: "Number indicating current number of members in cluster.",
 	})
 
+	gossipMemberEvents := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_cluster_member_events_total",
+		Help: "Total number of membership events of peers by event. Peers failing probes are suspected, failed peers leave once they are declared dead.",
+	}, []string{"event"})
+
 	reg.MustRegister(gossipMsgsReceived)
 	reg.MustRegister(gossipClusterMembers)
+	reg.MustRegister(gossipMemberEvents)
 
-	return &Peer{
+	p := &Peer{
 		logger:                   l,
 		knownPeers:               knownPeers,
 		cfg:                      cfg,
 		refreshInterval:          refreshInterval,
 		gossipMsgsReceived:       gossipMsgsReceived,
 		gossipClusterMembers:     gossipClusterMembers,
+		gossipMemberEvents:       gossipMemberEvents,
 		stopc:                    make(chan struct{}),
 		data:                     &data{data: map[string]PeerState{}},
 		advertiseAddr:            advertiseAddr,
 		advertiseStoreAPIAddr:    advertiseStoreAPIAddr,
 		advertiseQueryAPIAddress: advertiseQueryAPIAddress,
-	}, nil
+	}
+
+	reg.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_cluster_gossip_queued_messages",
+			Help: "Number of state updates queued for broadcasting to other peers.",
+		}, func() float64 {
+			p.mlistMtx.RLock()
+			defer p.mlistMtx.RUnlock()
+
+			if p.delegate == nil {
+				return 0
+			}
+			return float64(p.delegate.NumQueued())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_cluster_dead_members",
+			Help: "Number of peers that failed or left the cluster and did not rejoin, as long as memberlist still gossips about them.",
+		}, func() float64 {
+			p.mlistMtx.RLock()
+			defer p.mlistMtx.RUnlock()
+
+			if p.delegate == nil {
+				return 0
+			}
+			return float64(p.delegate.NumDead())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "thanos_cluster_health_score",
+			Help: "Health score of the peer as computed by memberlist. 0 is healthy, higher values mean that the peer fails to answer probes of other peers in time, e.g. because it is overloaded.",
+		}, func() float64 {
+			p.mlistMtx.RLock()
+			defer p.mlistMtx.RUnlock()
+
+			if p.mlist == nil {
+				return 0
+			}
+			return float64(p.mlist.GetHealthScore())
+		}),
+	)
+	for _, t := range []PeerType{PeerTypeStore, PeerTypeSource, PeerTypeQuery} {
+		t := t
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "thanos_cluster_peers",
+			Help:        "Number of discovered peers with a known state by type, including this peer once it joined.",
+			ConstLabels: prometheus.Labels{"type": string(t)},
+		}, func() float64 {
+			return float64(len(p.PeerStates(t)))
+		}))
+	}
+	return p, nil
+}
+
+// MetricSink wraps the sink of the go-metrics of memberlist. Suspicions of peers reported by memberlist through it are
+// counted as suspect events in thanos_cluster_member_events_total.
+func (p *Peer) MetricSink(s gmetrics.MetricSink) gmetrics.MetricSink {
+	return &metricSink{MetricSink: s, memberEvents: p.gossipMemberEvents}
+}
+
+type metricSink struct {
+	gmetrics.MetricSink
+	memberEvents *prometheus.CounterVec
+}
+
+func (s *metricSink) IncrCounter(key []string, val float32) {
+	s.observe(key, val)
+	s.MetricSink.IncrCounter(key, val)
+}
+
+func (s *metricSink) IncrCounterWithLabels(key []string, val float32, labels []gmetrics.Label) {
+	s.observe(key, val)
+	s.MetricSink.IncrCounterWithLabels(key, val, labels)
+}
+
+func (s *metricSink) observe(key []string, val float32) {
+	// Keys are prefixed with the service name, memberlist counts each peer it starts to suspect once.
+	if n := len(key); n >= 3 && key[n-3] == "memberlist" && key[n-2] == "msg" && key[n-1] == "suspect" {
+		s.memberEvents.WithLabelValues("suspect").Add(float64(val))
+	}
+}
+
+// newKeyring returns a keyring that encrypts gossip messages with the primary key and decrypts them with any of the keys.
+// Keys are rotated by adding the new key on all peers first, making it the primary key next and removing the old key last.
+func newKeyring(keys [][]byte, primary int) (*memberlist.Keyring, error) {
+	if primary < 0 || primary >= len(keys) {
+		return nil, errors.Errorf("primary key index %d out of range of %d keys", primary, len(keys))
+	}
+	for i, k := range keys {
+		switch len(k) {
+		case 16, 24, 32:
+		default:
+			return nil, errors.Errorf("key %d has %d bytes, must have 16, 24 or 32 bytes", i, len(k))
+		}
+	}
+	return memberlist.NewKeyring(keys, keys[primary])
 }
 
 // Join joins to the memberlist gossip cluster using knownPeers and given peerType and initialMetadata.
@@ -183,7 +302,7 @@ func (p *Peer) Join(peerType PeerType, initialMetadata PeerMetadata) error {
 	}
 
 	var ml *memberlist.Memberlist
-	d := newDelegate(p.logger, ml.NumMembers, p.data, p.gossipMsgsReceived, p.gossipClusterMembers)
+	d := newDelegate(p.logger, p.cfg.Name, ml.NumMembers, p.data, p.gossipMsgsReceived, p.gossipClusterMembers, p.gossipMemberEvents, p.cfg.GossipToTheDeadTime)
 	p.cfg.Delegate = d
 	p.cfg.Events = d
 
@@ -201,6 +320,7 @@ func (p *Peer) Join(peerType PeerType, initialMetadata PeerMetadata) error {
 
 	p.mlistMtx.Lock()
 	p.mlist = ml
+	p.delegate = d
 	p.mlistMtx.Unlock()
 
 	// Initialize state with ourselves.
@@ -209,6 +329,7 @@ func (p *Peer) Join(peerType PeerType, initialMetadata PeerMetadata) error {
 		StoreAPIAddr: p.advertiseStoreAPIAddr,
 		QueryAPIAddr: p.advertiseQueryAPIAddress,
 		Metadata:     initialMetadata,
+		Updated:      nowMillis(),
 	})
 
 	if p.refreshInterval != 0 {
@@ -312,6 +433,7 @@ func (p *Peer) SetLabels(labels []storepb.Label) {
 
 	s, _ := p.data.Get(p.Name())
 	s.Metadata.Labels = labels
+	s.Updated = nowMillis()
 	p.data.Set(p.Name(), s)
 }
 
@@ -325,9 +447,14 @@ func (p *Peer) SetTimestamps(mint int64, maxt int64) {
 	s, _ := p.data.Get(p.Name())
 	s.Metadata.MinTime = mint
 	s.Metadata.MaxTime = maxt
+	s.Updated = nowMillis()
 	p.data.Set(p.Name(), s)
 }
 
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
 // Close leaves the cluster waiting up to timeout and shutdowns peer if cluster left.
 // TODO(bplotka): Add this method into run.Group closing logic for each command. This will improve graceful shutdown.
 func (p *Peer) Close(timeout time.Duration) {
@@ -342,7 +469,10 @@ func (p *Peer) Close(timeout time.Duration) {
 	if err := p.mlist.Shutdown(); err != nil {
 		level.Error(p.logger).Log("msg", "memberlist shutdown failed", "err", err)
 	}
+	p.mlistMtx.Lock()
 	p.mlist = nil
+	p.delegate = nil
+	p.mlistMtx.Unlock()
 }
 
 // Name returns the unique ID of this peer in the cluster.
@@ -11,6 +11,7 @@ import (
 	"github.com/fortytw2/leaktest"
 	"github.com/go-kit/kit/log"
 	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/memberlist"
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
@@ -39,6 +40,8 @@ func joinPeer(num int, knownPeers []string) (peerAddr string, peer *Peer, err er
 		100*time.Millisecond,
 		50*time.Millisecond,
 		30*time.Millisecond,
+		nil,
+		0,
 	)
 	if err != nil {
 		return "", nil, err
@@ -207,3 +210,63 @@ func TestCalculateAdvAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestData_Merge(t *testing.T) {
+	d := &data{data: map[string]PeerState{}}
+
+	d.Merge("a", PeerState{StoreAPIAddr: "1", Updated: 10})
+	// An older state gossiped by another peer must not override the state the peer propagated itself.
+	d.Merge("a", PeerState{StoreAPIAddr: "0", Updated: 5})
+	s, ok := d.Get("a")
+	testutil.Assert(t, ok, "state of a not found")
+	testutil.Equals(t, "1", s.StoreAPIAddr)
+
+	d.Merge("a", PeerState{StoreAPIAddr: "2", Updated: 20})
+	d.Refresh("a", 30)
+	d.Refresh("b", 30)
+	s, _ = d.Get("a")
+	testutil.Equals(t, PeerState{StoreAPIAddr: "2", Updated: 30}, s)
+	_, ok = d.Get("b")
+	testutil.Assert(t, !ok, "refresh created state of b")
+}
+
+func TestNewKeyring(t *testing.T) {
+	keys := [][]byte{[]byte("0123456789abcdef"), []byte("0123456789abcdef01234567")}
+
+	kr, err := newKeyring(keys, 1)
+	testutil.Ok(t, err)
+	testutil.Equals(t, keys[1], kr.GetPrimaryKey())
+	testutil.Equals(t, 2, len(kr.GetKeys()))
+
+	_, err = newKeyring(keys, 2)
+	testutil.NotOk(t, err)
+
+	_, err = newKeyring([][]byte{[]byte("short")}, 0)
+	testutil.NotOk(t, err)
+}
+
+func TestDelegate_NumDead(t *testing.T) {
+	d := newDelegate(
+		log.NewNopLogger(),
+		"self",
+		func() int { return 1 },
+		&data{data: map[string]PeerState{}},
+		prometheus.NewCounter(prometheus.CounterOpts{}),
+		prometheus.NewGauge(prometheus.GaugeOpts{}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{}, []string{"event"}),
+		time.Hour,
+	)
+	for _, n := range []string{"a", "b"} {
+		d.NotifyJoin(&memberlist.Node{Name: n})
+		d.NotifyLeave(&memberlist.Node{Name: n})
+	}
+	testutil.Equals(t, 2, d.NumDead())
+
+	// Peers rejoining are no longer dead.
+	d.NotifyJoin(&memberlist.Node{Name: "a"})
+	testutil.Equals(t, 1, d.NumDead())
+
+	// Peers memberlist no longer gossips about are forgotten.
+	d.deadRetention = 0
+	testutil.Equals(t, 0, d.NumDead())
+}
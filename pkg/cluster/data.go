@@ -14,6 +14,29 @@ func (d *data) Set(k string, v PeerState) {
 	d.data[k] = v
 }
 
+// Merge sets the state of k unless the known state was propagated more recently. Other peers may still
+// gossip an older state of a peer than the one it propagated itself.
+func (d *data) Merge(k string, v PeerState) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if cur, ok := d.data[k]; ok && cur.Updated > v.Updated {
+		return
+	}
+	d.data[k] = v
+}
+
+// Refresh sets the update time of the state of k, if it exists.
+func (d *data) Refresh(k string, t int64) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if cur, ok := d.data[k]; ok {
+		cur.Updated = t
+		d.data[k] = cur
+	}
+}
+
 func (d *data) Del(k string) {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -3,6 +3,8 @@ package cluster
 import (
 	"encoding/json"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -15,21 +17,41 @@ import (
 type delegate struct {
 	*memberlist.TransmitLimitedQueue
 	logger               log.Logger
+	name                 string
 	data                 *data
 	gossipMsgsReceived   prometheus.Counter
 	gossipClusterMembers prometheus.Gauge
+	gossipMemberEvents   *prometheus.CounterVec
+
+	deadMtx sync.Mutex
+	// dead holds the time at which peers left the cluster, until memberlist stops gossiping about them.
+	dead          map[string]time.Time
+	deadRetention time.Duration
 }
 
-func newDelegate(l log.Logger, numNodes func() int, data *data, gossipMsgsReceived prometheus.Counter, gossipClusterMembers prometheus.Gauge) *delegate {
+func newDelegate(
+	l log.Logger,
+	name string,
+	numNodes func() int,
+	data *data,
+	gossipMsgsReceived prometheus.Counter,
+	gossipClusterMembers prometheus.Gauge,
+	gossipMemberEvents *prometheus.CounterVec,
+	deadRetention time.Duration,
+) *delegate {
 	return &delegate{
 		TransmitLimitedQueue: &memberlist.TransmitLimitedQueue{
 			NumNodes:       numNodes,
 			RetransmitMult: 3,
 		},
 		logger:               l,
+		name:                 name,
 		data:                 data,
 		gossipMsgsReceived:   gossipMsgsReceived,
 		gossipClusterMembers: gossipClusterMembers,
+		gossipMemberEvents:   gossipMemberEvents,
+		dead:                 map[string]time.Time{},
+		deadRetention:        deadRetention,
 	}
 }
 
@@ -49,12 +71,15 @@ func (d *delegate) NotifyMsg(b []byte) {
 
 	for k, v := range data {
 		// Removing data is handled by NotifyLeave
-		d.data.Set(k, v)
+		d.data.Merge(k, v)
 	}
 }
 
-// LocalState is called when gossip fetches local state.
+// LocalState is called when gossip fetches local state. The own state is refreshed first, as the peer
+// propagates it itself.
 func (d *delegate) LocalState(_ bool) []byte {
+	d.data.Refresh(d.name, nowMillis())
+
 	b, err := json.Marshal(d.data.Data())
 	if err != nil {
 		panic(err)
@@ -70,24 +95,51 @@ func (d *delegate) MergeRemoteState(buf []byte, _ bool) {
 	}
 	for k, v := range data {
 		// Removing data is handled by NotifyLeave
-		d.data.Set(k, v)
+		d.data.Merge(k, v)
 	}
 }
 
 // NotifyJoin is called if a peer joins the cluster.
 func (d *delegate) NotifyJoin(n *memberlist.Node) {
 	d.gossipClusterMembers.Inc()
+	d.gossipMemberEvents.WithLabelValues("join").Inc()
+
+	d.deadMtx.Lock()
+	delete(d.dead, n.Name)
+	d.deadMtx.Unlock()
+
 	level.Debug(d.logger).Log("received", "NotifyJoin", "node", n.Name, "addr", n.Address())
 }
 
 // NotifyLeave is called if a peer leaves the cluster.
 func (d *delegate) NotifyLeave(n *memberlist.Node) {
 	d.gossipClusterMembers.Dec()
+	d.gossipMemberEvents.WithLabelValues("leave").Inc()
+
+	d.deadMtx.Lock()
+	d.dead[n.Name] = time.Now()
+	d.deadMtx.Unlock()
+
 	level.Debug(d.logger).Log("received", "NotifyLeave", "node", n.Name, "addr", n.Address())
 	d.data.Del(n.Name)
 }
 
 // NotifyUpdate is called if a cluster peer gets updated.
 func (d *delegate) NotifyUpdate(n *memberlist.Node) {
+	d.gossipMemberEvents.WithLabelValues("update").Inc()
 	level.Debug(d.logger).Log("received", "NotifyUpdate", "node", n.Name, "addr", n.Address())
 }
+
+// NumDead returns the number of peers that left the cluster and did not rejoin, as long as memberlist still gossips
+// about them.
+func (d *delegate) NumDead() int {
+	d.deadMtx.Lock()
+	defer d.deadMtx.Unlock()
+
+	for name, t := range d.dead {
+		if time.Since(t) > d.deadRetention {
+			delete(d.dead, name)
+		}
+	}
+	return len(d.dead)
+}
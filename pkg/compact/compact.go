@@ -2,10 +2,15 @@ package compact
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +21,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/block"
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,25 +32,49 @@ import (
 // Syncer syncronizes block metas from a bucket into a local directory.
 // It sorts them into compaction groups based on equal label sets.
 type Syncer struct {
-	logger    log.Logger
-	reg       prometheus.Registerer
-	bkt       objstore.Bucket
-	syncDelay time.Duration
-	mtx       sync.Mutex
-	blocks    map[ulid.ULID]*block.Meta
-	metrics   *syncerMetrics
+	logger            log.Logger
+	reg               prometheus.Registerer
+	bkt               objstore.Bucket
+	syncDelay         time.Duration
+	sourceGracePeriod time.Duration
+	// Blocks of a compaction are downloaded with downloadConcurrency files in parallel,
+	// each through a buffer of downloadBufferSize bytes.
+	downloadConcurrency int
+	downloadBufferSize  int
+	mtx                 sync.Mutex
+	blocks              map[ulid.ULID]*block.Meta
+	// Sizes of the blocks in bytes. Blocks are immutable, so each block is only listed once.
+	blockSizes map[ulid.ULID]uint64
+	// Blocks marked by operators. Blocks marked for deletion are not part of blocks.
+	deletionMarks  map[ulid.ULID]*block.Marker
+	noCompactMarks map[ulid.ULID]*block.Marker
+	// Blocks are compacted with the blocks of their canonical external labels.
+	equivalences *LabelEquivalences
+	// In strict mode the chunks of all blocks are verified before they are compacted.
+	strict  bool
+	metrics *syncerMetrics
 }
 
 type syncerMetrics struct {
-	syncMetas                 prometheus.Counter
-	syncMetaFailures          prometheus.Counter
-	syncMetaDuration          prometheus.Histogram
-	garbageCollectedBlocks    prometheus.Counter
-	garbageCollections        prometheus.Counter
-	garbageCollectionFailures prometheus.Counter
-	garbageCollectionDuration prometheus.Histogram
-	compactions               *prometheus.CounterVec
-	compactionFailures        *prometheus.CounterVec
+	syncMetas                   prometheus.Counter
+	syncMetaFailures            prometheus.Counter
+	syncMetaDuration            prometheus.Histogram
+	garbageCollectedBlocks      prometheus.Counter
+	garbageCollections          prometheus.Counter
+	garbageCollectionFailures   prometheus.Counter
+	garbageCollectionDuration   prometheus.Histogram
+	pendingSourceDeletions      prometheus.Gauge
+	partialUploadsDeleted       prometheus.Counter
+	markedBlocksDeleted         prometheus.Counter
+	blocksMarkedForNoCompaction prometheus.Gauge
+	compactions                 *prometheus.CounterVec
+	compactionFailures          *prometheus.CounterVec
+	blockDownloadDuration       prometheus.Histogram
+	blockDownloadedBytes        prometheus.Counter
+	bucketSize                  prometheus.Gauge
+	retentionDeletedBlocks      prometheus.Counter
+	retentionReclaimedBytes     prometheus.Counter
+	corruptedBlocks             prometheus.Counter
 }
 
 func newSyncerMetrics(reg prometheus.Registerer) *syncerMetrics {
@@ -87,6 +117,24 @@ func newSyncerMetrics(reg prometheus.Registerer) *syncerMetrics {
 		},
 	})
 
+	m.pendingSourceDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_downsample_pending_source_deletions",
+		Help: "Number of blocks whose deletion is delayed because their data was downsampled within the source grace period.",
+	})
+
+	m.partialUploadsDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_partial_uploads_deleted_total",
+		Help: "Total number of blocks without a valid meta.json deleted by compactor.",
+	})
+	m.markedBlocksDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_marked_blocks_deleted_total",
+		Help: "Total number of blocks marked for deletion that were deleted by compactor.",
+	})
+	m.blocksMarkedForNoCompaction = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_blocks_marked_for_no_compaction",
+		Help: "Number of blocks in the bucket that are marked for no compaction and skipped when planning compactions.",
+	})
+
 	m.compactions = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_compact_group_compactions_total",
 		Help: "Total number of group compactions attempts.",
@@ -96,6 +144,36 @@ func newSyncerMetrics(reg prometheus.Registerer) *syncerMetrics {
 		Help: "Total number of failed group compactions.",
 	}, []string{"group"})
 
+	m.blockDownloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "thanos_compact_block_download_duration_seconds",
+		Help: "Time it took to download a block for compaction.",
+		Buckets: []float64{
+			0.25, 0.6, 1, 2, 3.5, 5, 7.5, 10, 15, 30, 60, 100, 200, 500, 1000,
+		},
+	})
+	m.blockDownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_block_downloaded_bytes_total",
+		Help: "Total number of bytes of blocks downloaded for compaction.",
+	})
+
+	m.bucketSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_bucket_size_bytes",
+		Help: "Total size of all synced blocks in the bucket as of the last size based retention.",
+	})
+	m.retentionDeletedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_retention_size_deleted_blocks_total",
+		Help: "Total number of blocks deleted because the bucket exceeded the size based retention.",
+	})
+	m.retentionReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_retention_size_reclaimed_bytes_total",
+		Help: "Total number of bytes reclaimed by deleting blocks because the bucket exceeded the size based retention.",
+	})
+
+	m.corruptedBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_corrupted_blocks_total",
+		Help: "Total number of blocks that failed the integrity checks of the strict mode and were marked for no compaction.",
+	})
+
 	if reg != nil {
 		reg.MustRegister(
 			m.syncMetas,
@@ -105,8 +183,18 @@ func newSyncerMetrics(reg prometheus.Registerer) *syncerMetrics {
 			m.garbageCollections,
 			m.garbageCollectionFailures,
 			m.garbageCollectionDuration,
+			m.pendingSourceDeletions,
+			m.partialUploadsDeleted,
+			m.markedBlocksDeleted,
+			m.blocksMarkedForNoCompaction,
 			m.compactions,
 			m.compactionFailures,
+			m.blockDownloadDuration,
+			m.blockDownloadedBytes,
+			m.bucketSize,
+			m.retentionDeletedBlocks,
+			m.retentionReclaimedBytes,
+			m.corruptedBlocks,
 		)
 	}
 	return &m
@@ -114,17 +202,42 @@ func newSyncerMetrics(reg prometheus.Registerer) *syncerMetrics {
 
 // NewSyncer returns a new Syncer for the given Bucket and directory.
 // Blocks must be at least as old as the sync delay for being considered.
-func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, syncDelay time.Duration) (*Syncer, error) {
+// Blocks whose data was downsampled less than sourceGracePeriod ago are not deleted, so a broken
+// downsampled block can still be recreated from its source.
+// The blocks of a compaction are downloaded with downloadConcurrency files in parallel, each through a buffer
+// of downloadBufferSize bytes. A downloadBufferSize of 0 uses the default buffer size.
+// Blocks are grouped by the canonical labels the equivalences map their external labels onto.
+// In strict mode, the checksums of all chunks of blocks are verified before they are compacted. Blocks that
+// fail the verification are marked for no compaction and halt the compactor.
+func NewSyncer(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	bkt objstore.Bucket,
+	syncDelay time.Duration,
+	sourceGracePeriod time.Duration,
+	downloadConcurrency int,
+	downloadBufferSize int,
+	equivalences *LabelEquivalences,
+	strict bool,
+) (*Syncer, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	return &Syncer{
-		logger:    logger,
-		reg:       reg,
-		syncDelay: syncDelay,
-		blocks:    map[ulid.ULID]*block.Meta{},
-		bkt:       bkt,
-		metrics:   newSyncerMetrics(reg),
+		logger:              logger,
+		reg:                 reg,
+		syncDelay:           syncDelay,
+		sourceGracePeriod:   sourceGracePeriod,
+		downloadConcurrency: downloadConcurrency,
+		downloadBufferSize:  downloadBufferSize,
+		blocks:              map[ulid.ULID]*block.Meta{},
+		blockSizes:          map[ulid.ULID]uint64{},
+		deletionMarks:       map[ulid.ULID]*block.Marker{},
+		noCompactMarks:      map[ulid.ULID]*block.Marker{},
+		equivalences:        equivalences,
+		strict:              strict,
+		bkt:                 bkt,
+		metrics:             newSyncerMetrics(reg),
 	}, nil
 }
 
@@ -149,6 +262,8 @@ func (c *Syncer) SyncMetas(ctx context.Context) error {
 func (c *Syncer) syncMetas(ctx context.Context) error {
 	// Read back all block metas so we can detect deleted blocks.
 	remote := map[ulid.ULID]struct{}{}
+	deletionMarks := map[ulid.ULID]*block.Marker{}
+	noCompactMarks := map[ulid.ULID]*block.Marker{}
 
 	err := c.bkt.Iter(ctx, "", func(name string) error {
 		id, ok := block.IsBlockDir(name)
@@ -156,6 +271,24 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 			return nil
 		}
 
+		// Operators may mark and unmark blocks at any time, so marks are checked on every sync.
+		dm, err := block.ReadMarker(ctx, c.logger, c.bkt, id, block.DeletionMarkFilename)
+		if err != nil {
+			return err
+		}
+		if dm != nil {
+			// Blocks marked for deletion are ignored until they are deleted by DeleteMarkedBlocks.
+			deletionMarks[id] = dm
+			return nil
+		}
+		ncm, err := block.ReadMarker(ctx, c.logger, c.bkt, id, block.NoCompactMarkFilename)
+		if err != nil {
+			return err
+		}
+		if ncm != nil {
+			noCompactMarks[id] = ncm
+		}
+
 		remote[id] = struct{}{}
 
 		// Check if we already have this block cached locally.
@@ -165,7 +298,13 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 
 		level.Debug(c.logger).Log("msg", "download meta", "block", id)
 
-		meta, err := block.DownloadMeta(ctx, c.bkt, id)
+		meta, err := block.DownloadMeta(ctx, c.logger, c.bkt, id)
+		if c.bkt.IsObjNotFoundErr(errors.Cause(err)) {
+			// Most likely a block that is being uploaded or a leftover of an interrupted upload.
+			// The latter are removed by CleanPartialUploads.
+			level.Warn(c.logger).Log("msg", "block without meta.json, ignoring", "block", id)
+			return nil
+		}
 		if err != nil {
 			return errors.Wrapf(err, "downloading meta.json for %s", id)
 		}
@@ -193,6 +332,9 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 	if err != nil {
 		return retry(errors.Wrap(err, "retrieve bucket block metas"))
 	}
+	c.deletionMarks = deletionMarks
+	c.noCompactMarks = noCompactMarks
+	c.metrics.blocksMarkedForNoCompaction.Set(float64(len(noCompactMarks)))
 
 	// Delete all local block dirs that no longer exist in the bucket.
 	for id := range c.blocks {
@@ -200,10 +342,117 @@ func (c *Syncer) syncMetas(ctx context.Context) error {
 			delete(c.blocks, id)
 		}
 	}
+	for id := range c.blockSizes {
+		if _, ok := c.blocks[id]; !ok {
+			delete(c.blockSizes, id)
+		}
+	}
 
 	return nil
 }
 
+// CleanPartialUploads deletes blocks from the bucket that have no valid meta.json and were created
+// more than deleteDelay ago. Such blocks are left behind by interrupted uploads, as meta.json is
+// always uploaded last.
+func (c *Syncer) CleanPartialUploads(ctx context.Context, deleteDelay time.Duration) error {
+	var partial []ulid.ULID
+
+	err := c.bkt.Iter(ctx, "", func(name string) error {
+		id, ok := block.IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		// Uploads of recent blocks may be still in progress.
+		if ulid.Now()-id.Time() < uint64(deleteDelay/time.Millisecond) {
+			return nil
+		}
+		ok, err := c.hasValidMeta(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			partial = append(partial, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return retry(errors.Wrap(err, "find partially uploaded blocks"))
+	}
+
+	for _, id := range partial {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Spawn a new context so we always delete a block in full on shutdown.
+		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+
+		level.Info(c.logger).Log("msg", "deleting partially uploaded block", "block", id)
+
+		err := block.Delete(delCtx, c.bkt, id)
+		cancel()
+		if err != nil {
+			return retry(errors.Wrapf(err, "delete partially uploaded block %s from bucket", id))
+		}
+		c.metrics.partialUploadsDeleted.Inc()
+	}
+	return nil
+}
+
+// DeleteMarkedBlocks deletes the blocks that were marked for deletion more than delay ago. The delay
+// gives stores time to stop serving the blocks before they are gone.
+func (c *Syncer) DeleteMarkedBlocks(ctx context.Context, delay time.Duration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for id, m := range c.deletionMarks {
+		if time.Since(time.Unix(m.Time, 0)) < delay {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Spawn a new context so we always delete a block in full on shutdown.
+		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+
+		level.Info(c.logger).Log("msg", "deleting block marked for deletion", "block", id, "details", m.Details)
+
+		// Delete meta.json first, so that an interrupted deletion leaves a partial upload behind,
+		// which CleanPartialUploads removes, instead of a block that is half gone.
+		err := c.bkt.Delete(delCtx, path.Join(id.String(), block.MetaFilename))
+		if err == nil || c.bkt.IsObjNotFoundErr(err) {
+			err = block.Delete(delCtx, c.bkt, id)
+		}
+		cancel()
+		if err != nil {
+			return retry(errors.Wrapf(err, "delete marked block %s from bucket", id))
+		}
+		delete(c.deletionMarks, id)
+		c.metrics.markedBlocksDeleted.Inc()
+	}
+	return nil
+}
+
+// hasValidMeta returns whether the block has a meta.json file that can be decoded.
+func (c *Syncer) hasValidMeta(ctx context.Context, id ulid.ULID) (bool, error) {
+	rc, err := c.bkt.Get(ctx, path.Join(id.String(), block.MetaFilename))
+	if c.bkt.IsObjNotFoundErr(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "get meta.json for %s", id)
+	}
+	defer runutil.LogOnErr(c.logger, rc, "meta.json reader")
+
+	var m block.Meta
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		level.Warn(c.logger).Log("msg", "block with invalid meta.json", "block", id, "err", err)
+		return false, nil
+	}
+	return true, nil
+}
+
 // GroupKey returns a unique identifier for the group the block belongs to. It considers
 // the downsampling resolution and the block's labels.
 func GroupKey(meta block.Meta) string {
@@ -220,25 +469,65 @@ func (c *Syncer) Groups() (res []*Group, err error) {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	recent := c.recentlyDownsampled()
+
+	// Blocks that are only kept around as downsampling sources must not be compacted again.
+	pending := map[ulid.ULID]struct{}{}
+	for _, res := range []int64{
+		downsample.ResLevel0, downsample.ResLevel1, downsample.ResLevel2,
+	} {
+		garbage, err := c.garbageBlocks(res)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range garbage {
+			if _, ok := recent[id]; ok {
+				pending[id] = struct{}{}
+			}
+		}
+	}
+
 	groups := map[string]*Group{}
 	for _, m := range c.blocks {
-		g, ok := groups[GroupKey(*m)]
+		if _, ok := pending[m.ULID]; ok {
+			continue
+		}
+		lset := c.equivalences.Canonical(labels.FromMap(m.Thanos.Labels))
+		key := groupKey(m.Thanos.Downsample.Resolution, lset)
+
+		g, ok := groups[key]
 		if !ok {
 			g, err = newGroup(
-				log.With(c.logger, "compactionGroup", GroupKey(*m)),
+				log.With(c.logger, "compactionGroup", key),
 				c.bkt,
-				labels.FromMap(m.Thanos.Labels),
+				lset,
 				m.Thanos.Downsample.Resolution,
-				c.metrics.compactions.WithLabelValues(GroupKey(*m)),
-				c.metrics.compactionFailures.WithLabelValues(GroupKey(*m)),
+				c.metrics.compactions.WithLabelValues(key),
+				c.metrics.compactionFailures.WithLabelValues(key),
 				c.metrics.garbageCollectedBlocks,
+				recent,
+				c.downloadConcurrency,
+				c.downloadBufferSize,
+				c.metrics.blockDownloadDuration,
+				c.metrics.blockDownloadedBytes,
+				c.equivalences,
+				c.strict,
+				c.metrics.corruptedBlocks,
 			)
 			if err != nil {
 				return nil, errors.Wrap(err, "create compaction group")
 			}
-			groups[GroupKey(*m)] = g
+			groups[key] = g
 			res = append(res, g)
 		}
+		if ncm, ok := c.noCompactMarks[m.ULID]; ok {
+			level.Info(c.logger).Log("msg", "skipping block marked for no compaction", "block", m.ULID,
+				"group", g.Key(), "marked", time.Unix(ncm.Time, 0), "details", ncm.Details)
+			if err := g.exclude(m); err != nil {
+				return nil, errors.Wrap(err, "exclude block from compaction group")
+			}
+			continue
+		}
 		if err := g.Add(m); err != nil {
 			return nil, errors.Wrap(err, "add compaction group")
 		}
@@ -257,6 +546,8 @@ func (c *Syncer) GarbageCollect(ctx context.Context) error {
 
 	begin := time.Now()
 
+	c.metrics.pendingSourceDeletions.Set(0)
+
 	// Run a separate round of garbage collections for each valid resolution.
 	for _, res := range []int64{
 		downsample.ResLevel0, downsample.ResLevel1, downsample.ResLevel2,
@@ -275,7 +566,65 @@ func (c *Syncer) GarbageCollect(ctx context.Context) error {
 	return nil
 }
 
+// GarbageBlocks returns the blocks of the given resolution that can safely be deleted, excluding
+// those whose data was downsampled within the source grace period.
 func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
+	garbage, err := c.garbageBlocks(resolution)
+	if err != nil {
+		return nil, err
+	}
+	recent := c.recentlyDownsampled()
+
+	for _, id := range garbage {
+		if _, ok := recent[id]; ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// recentlyDownsampled returns all blocks whose data was downsampled less than the source
+// grace period ago. A downsampled block carries the compaction sources of the block it was
+// created from and its ULID tells when it was created.
+func (c *Syncer) recentlyDownsampled() map[ulid.ULID]struct{} {
+	res := map[ulid.ULID]struct{}{}
+	if c.sourceGracePeriod <= 0 {
+		return res
+	}
+	for _, d := range c.blocks {
+		if d.Thanos.Downsample.Resolution == downsample.ResLevel0 {
+			continue
+		}
+		if ulid.Now()-d.ULID.Time() >= uint64(c.sourceGracePeriod/time.Millisecond) {
+			continue
+		}
+		for id, m := range c.blocks {
+			if m.Thanos.Downsample.Resolution < d.Thanos.Downsample.Resolution && sameSources(m, d) {
+				res[id] = struct{}{}
+			}
+		}
+	}
+	return res
+}
+
+func sameSources(a, b *block.Meta) bool {
+	if len(a.Compaction.Sources) != len(b.Compaction.Sources) {
+		return false
+	}
+	srcs := make(map[ulid.ULID]struct{}, len(a.Compaction.Sources))
+	for _, id := range a.Compaction.Sources {
+		srcs[id] = struct{}{}
+	}
+	for _, id := range b.Compaction.Sources {
+		if _, ok := srcs[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Syncer) garbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 	// Map each block to its highest priority parent. Initial blocks have themselves
 	// in their source section, i.e. are their own parent.
 	parents := map[ulid.ULID]ulid.ULID{}
@@ -336,16 +685,23 @@ func (c *Syncer) GarbageBlocks(resolution int64) (ids []ulid.ULID, err error) {
 }
 
 func (c *Syncer) garbageCollect(ctx context.Context, resolution int64) error {
-	garbageIds, err := c.GarbageBlocks(resolution)
+	garbageIds, err := c.garbageBlocks(resolution)
 	if err != nil {
 		return err
 	}
+	recent := c.recentlyDownsampled()
 
 	for _, id := range garbageIds {
 		if ctx.Err() != nil {
 			return ctx.Err()
 		}
 
+		if _, ok := recent[id]; ok {
+			level.Debug(c.logger).Log("msg", "delaying deletion of recently downsampled block", "block", id)
+			c.metrics.pendingSourceDeletions.Inc()
+			continue
+		}
+
 		// Spawn a new context so we always delete a block in full on shutdown.
 		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 
@@ -377,6 +733,20 @@ type Group struct {
 	compactions                 prometheus.Counter
 	compactionFailures          prometheus.Counter
 	groupGarbageCollectedBlocks prometheus.Counter
+	// Blocks that must not be deleted after being compacted. The syncer's garbage collection
+	// deletes them once they are no longer needed.
+	keepBlocks map[ulid.ULID]struct{}
+	// Blocks marked to never be compacted. No compaction may cover their time range.
+	excluded              []*block.Meta
+	downloadConcurrency   int
+	downloadBufferSize    int
+	blockDownloadDuration prometheus.Histogram
+	blockDownloadedBytes  prometheus.Counter
+	// Blocks with external labels equivalent to the group labels are part of the group as well.
+	equivalences *LabelEquivalences
+	// strict enables the verification of all chunks of the blocks of a compaction.
+	strict          bool
+	corruptedBlocks prometheus.Counter
 }
 
 // newGroup returns a new compaction group.
@@ -388,6 +758,14 @@ func newGroup(
 	compactions prometheus.Counter,
 	compactionFailures prometheus.Counter,
 	groupGarbageCollectedBlocks prometheus.Counter,
+	keepBlocks map[ulid.ULID]struct{},
+	downloadConcurrency int,
+	downloadBufferSize int,
+	blockDownloadDuration prometheus.Histogram,
+	blockDownloadedBytes prometheus.Counter,
+	equivalences *LabelEquivalences,
+	strict bool,
+	corruptedBlocks prometheus.Counter,
 ) (*Group, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -401,6 +779,14 @@ func newGroup(
 		compactions:                 compactions,
 		compactionFailures:          compactionFailures,
 		groupGarbageCollectedBlocks: groupGarbageCollectedBlocks,
+		keepBlocks:                  keepBlocks,
+		downloadConcurrency:         downloadConcurrency,
+		downloadBufferSize:          downloadBufferSize,
+		blockDownloadDuration:       blockDownloadDuration,
+		blockDownloadedBytes:        blockDownloadedBytes,
+		equivalences:                equivalences,
+		strict:                      strict,
+		corruptedBlocks:             corruptedBlocks,
 	}
 	return g, nil
 }
@@ -410,12 +796,22 @@ func (cg *Group) Key() string {
 	return groupKey(cg.resolution, cg.labels)
 }
 
+// canonicalKey returns the key of the group the block belongs to.
+func (cg *Group) canonicalKey(meta *block.Meta) string {
+	return groupKey(meta.Thanos.Downsample.Resolution, cg.equivalences.Canonical(labels.FromMap(meta.Thanos.Labels)))
+}
+
+// isEquivalent returns true if the block has external labels that differ from the group labels.
+func (cg *Group) isEquivalent(meta *block.Meta) bool {
+	return !cg.labels.Equals(labels.FromMap(meta.Thanos.Labels))
+}
+
 // Add the block with the given meta to the group.
 func (cg *Group) Add(meta *block.Meta) error {
 	cg.mtx.Lock()
 	defer cg.mtx.Unlock()
 
-	if !cg.labels.Equals(labels.FromMap(meta.Thanos.Labels)) {
+	if !cg.labels.Equals(cg.equivalences.Canonical(labels.FromMap(meta.Thanos.Labels))) {
 		return errors.New("block and group labels do not match")
 	}
 	if cg.resolution != meta.Thanos.Downsample.Resolution {
@@ -425,6 +821,85 @@ func (cg *Group) Add(meta *block.Meta) error {
 	return nil
 }
 
+// exclude the block with the given meta from the compactions of the group.
+func (cg *Group) exclude(meta *block.Meta) error {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	if !cg.labels.Equals(cg.equivalences.Canonical(labels.FromMap(meta.Thanos.Labels))) {
+		return errors.New("block and group labels do not match")
+	}
+	if cg.resolution != meta.Thanos.Downsample.Resolution {
+		return errors.New("block and group resolution do not match")
+	}
+	cg.excluded = append(cg.excluded, meta)
+	return nil
+}
+
+// coversExcluded returns the first excluded block that is part of the plan or overlaps with the time range of
+// the planned blocks.
+func (cg *Group) coversExcluded(plan []string) (*block.Meta, error) {
+	if len(cg.excluded) == 0 {
+		return nil, nil
+	}
+	var mint, maxt int64 = math.MaxInt64, math.MinInt64
+
+	for _, pdir := range plan {
+		id, err := ulid.Parse(filepath.Base(pdir))
+		if err != nil {
+			return nil, errors.Wrapf(err, "plan dir %s", pdir)
+		}
+		for _, m := range cg.excluded {
+			if m.ULID == id {
+				return m, nil
+			}
+		}
+		m, ok := cg.blocks[id]
+		if !ok {
+			return nil, errors.Errorf("planned block %s is not part of the group", id)
+		}
+		if m.MinTime < mint {
+			mint = m.MinTime
+		}
+		if m.MaxTime > maxt {
+			maxt = m.MaxTime
+		}
+	}
+	for _, m := range cg.excluded {
+		if m.MinTime < maxt && mint < m.MaxTime {
+			return m, nil
+		}
+	}
+	return nil, nil
+}
+
+// planSegment are the blocks of the group between two blocks marked for no compaction.
+type planSegment struct {
+	blocks []*block.Meta
+	// next is the block marked for no compaction after the blocks. It is nil for the last segment.
+	next *block.Meta
+}
+
+// planSegments splits the blocks of the group at the blocks marked for no compaction, oldest first.
+func (cg *Group) planSegments() []planSegment {
+	excluded := append([]*block.Meta(nil), cg.excluded...)
+	sort.Slice(excluded, func(i, j int) bool {
+		return excluded[i].MaxTime < excluded[j].MaxTime
+	})
+	segs := make([]planSegment, len(excluded)+1)
+	for i, m := range excluded {
+		segs[i].next = m
+	}
+	for _, m := range cg.blocks {
+		// A block belongs to the segment after all marked blocks that end before it starts.
+		i := sort.Search(len(excluded), func(i int) bool {
+			return excluded[i].MaxTime > m.MinTime
+		})
+		segs[i].blocks = append(segs[i].blocks, m)
+	}
+	return segs
+}
+
 // IDs returns all sorted IDs of blocks in the group.
 func (cg *Group) IDs() (ids []ulid.ULID) {
 	cg.mtx.Lock()
@@ -470,6 +945,19 @@ func (cg *Group) Compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 	return compID, err
 }
 
+// markCorrupted marks a block that failed an integrity check for no compaction, so it is not compacted again
+// once the halted compactor is restarted. Failing to mark it is only logged, the caller halts anyway.
+func (cg *Group) markCorrupted(ctx context.Context, id ulid.ULID, err error) {
+	cg.corruptedBlocks.Inc()
+
+	details := fmt.Sprintf("failed integrity check of strict compaction: %s", err)
+	if err := block.MarkBlock(ctx, cg.logger, cg.bkt, id, block.NoCompactMarkFilename, details); err != nil {
+		level.Error(cg.logger).Log("msg", "failed to mark corrupted block for no compaction", "block", id, "err", err)
+		return
+	}
+	level.Error(cg.logger).Log("msg", "marked corrupted block for no compaction", "block", id, "details", details)
+}
+
 // Issue347Error is a type wrapper for errors that should invoke repair process for broken block.
 type Issue347Error struct {
 	err error
@@ -510,6 +998,43 @@ func IsHaltError(err error) bool {
 	return ok
 }
 
+// OverlapError is returned when blocks of a group overlap in time, typically because multiple Prometheus
+// servers, e.g. the replicas of an HA pair, upload blocks with the same external labels. The compactor cannot
+// merge overlapping series of different blocks, so the group cannot be compacted until the blocks are fixed.
+type OverlapError struct {
+	group  string
+	blocks []*block.Meta
+}
+
+// Blocks returns the IDs of the overlapping blocks.
+func (e OverlapError) Blocks() []ulid.ULID {
+	ids := make([]ulid.ULID, 0, len(e.blocks))
+	for _, m := range e.blocks {
+		ids = append(ids, m.ULID)
+	}
+	return ids
+}
+
+func (e OverlapError) Error() string {
+	parts := make([]string, 0, len(e.blocks))
+	for _, m := range e.blocks {
+		parts = append(parts, fmt.Sprintf("%s (labels %s, mint %d, maxt %d)",
+			m.ULID, labels.FromMap(m.Thanos.Labels), m.MinTime, m.MaxTime))
+	}
+	return fmt.Sprintf("blocks of group %s overlap: %s; this usually means that multiple Prometheus servers upload blocks "+
+		"with the same external labels, make the external labels of each of them unique and mark the duplicated blocks "+
+		"for deletion or no compaction", e.group, strings.Join(parts, ", "))
+}
+
+// IsOverlapError returns true if the base error is an OverlapError, also if it halts the compactor.
+func IsOverlapError(err error) bool {
+	if h, ok := errors.Cause(err).(HaltError); ok {
+		err = h.err
+	}
+	_, ok := errors.Cause(err).(OverlapError)
+	return ok
+}
+
 // RetryError is a type wrapper for errors that should trigger warning log and retry whole compaction loop, but aborting
 // current compaction further progress.
 type RetryError struct {
@@ -564,6 +1089,34 @@ func (cg *Group) areBlocksOverlapping(include *block.Meta, excludeDirs ...string
 	return nil
 }
 
+// overlapError returns an OverlapError with all blocks of the group that overlap, or nil if none do.
+// The group lock must be held.
+func (cg *Group) overlapError() error {
+	metas := make([]tsdb.BlockMeta, 0, len(cg.blocks))
+	for _, m := range cg.blocks {
+		metas = append(metas, m.BlockMeta)
+	}
+	overlaps := tsdb.OverlappingBlocks(metas)
+	if len(overlaps) == 0 {
+		return nil
+	}
+	ids := map[ulid.ULID]struct{}{}
+	for _, ms := range overlaps {
+		for _, m := range ms {
+			ids[m.ULID] = struct{}{}
+		}
+	}
+	e := OverlapError{group: cg.Key()}
+	for id := range ids {
+		e.blocks = append(e.blocks, cg.blocks[id])
+	}
+	sort.Slice(e.blocks, func(i, j int) bool {
+		return e.blocks[i].MinTime < e.blocks[j].MinTime ||
+			(e.blocks[i].MinTime == e.blocks[j].MinTime && e.blocks[i].ULID.Compare(e.blocks[j].ULID) < 0)
+	})
+	return e
+}
+
 // RepairIssue347 repairs the https://github.com/prometheus/tsdb/issues/347 issue when having issue347Error.
 func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket, issue347Err error) error {
 	ie, ok := errors.Cause(issue347Err).(Issue347Error)
@@ -623,31 +1176,176 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 	return nil
 }
 
-func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (compID ulid.ULID, err error) {
+// Planned returns the IDs of the blocks of every compaction the group needs until nothing is left to compact,
+// without downloading or compacting them. Compactions are simulated in the order Compact runs them, assuming each
+// one succeeds, so later compactions may compact the results of earlier ones. Their IDs are those of the blocks in
+// the bucket the results are made of. The metas of the blocks are written to dir for planning, which is removed afterwards.
+func (cg *Group) Planned(dir string, comp tsdb.Compactor) (plans [][]ulid.ULID, err error) {
 	cg.mtx.Lock()
 	defer cg.mtx.Unlock()
 
-	// Check for overlapped blocks.
-	if err := cg.areBlocksOverlapping(nil); err != nil {
-		return compID, halt(errors.Wrap(err, "pre compaction overlap check"))
+	subDir := filepath.Join(dir, cg.Key())
+	if err := os.RemoveAll(subDir); err != nil {
+		return nil, errors.Wrap(err, "clean planning dir")
+	}
+	if err := os.MkdirAll(subDir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create planning dir")
+	}
+	defer os.RemoveAll(subDir)
+
+	// The blocks of each plan are replaced by a block covering them in a copy of the blocks of the group.
+	blocks := cg.blocks
+	defer func() { cg.blocks = blocks }()
+
+	cg.blocks = make(map[ulid.ULID]*block.Meta, len(blocks))
+	for id, m := range blocks {
+		cg.blocks[id] = m
+	}
+	var (
+		entropy = rand.New(rand.NewSource(time.Now().UnixNano()))
+		// Blocks in the bucket the simulated blocks are made of.
+		sources = map[ulid.ULID][]ulid.ULID{}
+	)
+	for {
+		plan, err := cg.plan(subDir, comp)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan) == 0 {
+			return plans, nil
+		}
+		var (
+			ids    []ulid.ULID
+			result block.Meta
+		)
+		for i, pdir := range plan {
+			id, err := ulid.Parse(filepath.Base(pdir))
+			if err != nil {
+				return nil, errors.Wrapf(err, "plan dir %s", pdir)
+			}
+			m, ok := cg.blocks[id]
+			if !ok {
+				return nil, errors.Errorf("planned block %s is not part of the group", id)
+			}
+			if i == 0 {
+				result = *m
+			}
+			if m.MinTime < result.MinTime {
+				result.MinTime = m.MinTime
+			}
+			if m.MaxTime > result.MaxTime {
+				result.MaxTime = m.MaxTime
+			}
+			if m.Compaction.Level > result.Compaction.Level {
+				result.Compaction.Level = m.Compaction.Level
+			}
+			if s, ok := sources[id]; ok {
+				ids = append(ids, s...)
+			} else {
+				ids = append(ids, id)
+			}
+			delete(cg.blocks, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i].Compare(ids[j]) < 0
+		})
+		result.ULID = ulid.MustNew(ulid.Now(), entropy)
+		result.Compaction.Level++
+		result.Compaction.Sources = nil
+		result.Compaction.Parents = nil
+		result.Stats = tsdb.BlockStats{}
+
+		cg.blocks[result.ULID] = &result
+		sources[result.ULID] = ids
+		plans = append(plans, ids)
+	}
+}
+
+// plan returns the directories of the blocks to compact next. A compaction covering a block marked for no
+// compaction would create a block that overlaps with it, so the blocks between marked blocks are planned separately,
+// oldest first. The group lock must be held.
+func (cg *Group) plan(dir string, comp tsdb.Compactor) ([]string, error) {
+	for _, seg := range cg.planSegments() {
+		if len(seg.blocks) == 0 {
+			continue
+		}
+		plan, err := cg.planSegment(dir, comp, seg)
+		if err != nil {
+			return nil, err
+		}
+		if len(plan) > 0 {
+			return plan, nil
+		}
 	}
+	return nil, nil
+}
 
+// planSegment plans a compaction of the blocks of the segment.
+func (cg *Group) planSegment(dir string, comp tsdb.Compactor, seg planSegment) ([]string, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, errors.Wrap(err, "clean planning dir")
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create planning dir")
+	}
+	metas := seg.blocks
+	// The planner never compacts the newest block it is given, which is still being written to in a TSDB. The blocks
+	// of a segment before a marked block are complete, so the marked block is passed as the newest block instead.
+	if seg.next != nil {
+		metas = append(metas[:len(metas):len(metas)], seg.next)
+	}
 	// Planning a compaction works purely based on the meta.json files in our future group's dir.
 	// So we first dump all our memory block metas into the directory.
-	for _, meta := range cg.blocks {
+	for _, meta := range metas {
 		bdir := filepath.Join(dir, meta.ULID.String())
 		if err := os.MkdirAll(bdir, 0777); err != nil {
-			return compID, errors.Wrap(err, "create planning block dir")
+			return nil, errors.Wrap(err, "create planning block dir")
 		}
 		if err := block.WriteMetaFile(bdir, meta); err != nil {
-			return compID, errors.Wrap(err, "write planning meta file")
+			return nil, errors.Wrap(err, "write planning meta file")
 		}
 	}
 
 	// Plan against the written meta.json files.
 	plan, err := comp.Plan(dir)
 	if err != nil {
-		return compID, errors.Wrap(err, "plan compaction")
+		return nil, errors.Wrap(err, "plan compaction")
+	}
+	if len(plan) == 0 {
+		return nil, nil
+	}
+	// Blocks overlapping with a marked block may still end up in a plan covering it.
+	excluded, err := cg.coversExcluded(plan)
+	if err != nil {
+		return nil, errors.Wrap(err, "check blocks marked for no compaction")
+	}
+	if excluded != nil {
+		level.Info(cg.logger).Log("msg", "skipping compaction that would cover a block marked for no compaction",
+			"blocks", fmt.Sprintf("%v", plan), "excluded", excluded.ULID)
+		return nil, nil
+	}
+	return plan, nil
+}
+
+func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (compID ulid.ULID, err error) {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	// Check for overlapped blocks. Blocks with equivalent external labels are only merged if they do not overlap,
+	// the compactor cannot merge overlapping series of different blocks.
+	if err := cg.overlapError(); err != nil {
+		for _, meta := range cg.blocks {
+			if cg.isEquivalent(meta) {
+				err = errors.Wrap(err, "group contains blocks with equivalent external labels, which must not overlap")
+				break
+			}
+		}
+		return compID, halt(errors.Wrap(err, "pre compaction overlap check"))
+	}
+
+	plan, err := cg.plan(dir, comp)
+	if err != nil {
+		return compID, err
 	}
 	if len(plan) == 0 {
 		// Nothing to do.
@@ -667,8 +1365,12 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 			return compID, errors.Wrapf(err, "read meta from %s", pdir)
 		}
 
-		if cg.Key() != GroupKey(*meta) {
-			return compID, halt(errors.Wrapf(err, "compact planned compaction for mixed groups. group: %s, planned block's group: %s", cg.Key(), GroupKey(*meta)))
+		if cg.Key() != cg.canonicalKey(meta) {
+			return compID, halt(errors.Errorf("compact planned compaction for mixed groups. group: %s, planned block's group: %s", cg.Key(), cg.canonicalKey(meta)))
+		}
+		if cg.isEquivalent(meta) {
+			level.Warn(cg.logger).Log("msg", "compacting block with equivalent external labels into the canonical labels of the group, its series are merged irreversibly",
+				"block", meta.ULID, "labels", labels.FromMap(meta.Thanos.Labels), "canonical", cg.labels)
 		}
 
 		for _, s := range meta.Compaction.Sources {
@@ -687,9 +1389,15 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 			return compID, errors.Errorf("mismatch between meta %s and dir %s", meta.ULID, id)
 		}
 
-		if err := block.Download(ctx, cg.bkt, id, pdir); err != nil {
+		// A failed download removes the block directory, so the retry starts from scratch.
+		downloadBegin := time.Now()
+		n, err := block.DownloadConcurrently(ctx, cg.bkt, id, pdir, cg.downloadConcurrency, cg.downloadBufferSize)
+		if err != nil {
 			return compID, retry(errors.Wrapf(err, "download block %s", id))
 		}
+		cg.blockDownloadDuration.Observe(time.Since(downloadBegin).Seconds())
+		cg.blockDownloadedBytes.Add(float64(n))
+		level.Debug(cg.logger).Log("msg", "downloaded block", "block", id, "bytes", n, "duration", time.Since(downloadBegin))
 
 		// Ensure all input blocks are valid.
 		stats, err := block.GatherIndexIssueStats(filepath.Join(pdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
@@ -698,12 +1406,22 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		}
 
 		if err := stats.CriticalErr(); err != nil {
+			if cg.strict {
+				cg.markCorrupted(ctx, id, err)
+			}
 			return compID, halt(errors.Wrapf(err, "invalid plan id %s", pdir))
 		}
 
 		if err := stats.Issue347OutsideChunksErr(); err != nil {
 			return compID, issue347Error(errors.Wrapf(err, "invalid, but reparable block %s", pdir), meta.ULID)
 		}
+
+		if cg.strict {
+			if err := block.VerifyChunks(pdir); err != nil {
+				cg.markCorrupted(ctx, id, err)
+				return compID, halt(errors.Wrapf(err, "verify chunks of block %s", pdir))
+			}
+		}
 	}
 	level.Debug(cg.logger).Log("msg", "downloaded and verified blocks",
 		"blocks", fmt.Sprintf("%v", plan), "duration", time.Since(begin))
@@ -737,6 +1455,13 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		return compID, halt(errors.Wrapf(err, "invalid result block %s", bdir))
 	}
 
+	// Ensure the output block is not corrupted, which would be discovered only after the source blocks are gone.
+	if cg.strict {
+		if err := block.VerifyChunks(bdir); err != nil {
+			return compID, halt(errors.Wrapf(err, "verify chunks of result block %s", bdir))
+		}
+	}
+
 	// Ensure the output block is not overlapping with anything else.
 	if err := cg.areBlocksOverlapping(newMeta, plan...); err != nil {
 		return compID, halt(errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
@@ -762,6 +1487,11 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 			return compID, errors.Wrapf(err, "remove old block dir %s", id)
 		}
 
+		if _, ok := cg.keepBlocks[id]; ok {
+			level.Info(cg.logger).Log("msg", "keeping compacted block as it was downsampled recently", "old_block", id, "result_block", compID)
+			continue
+		}
+
 		// Spawn a new context so we always delete a block in full on shutdown.
 		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 		level.Info(cg.logger).Log("msg", "deleting compacted block", "old_block", id, "result_block", compID)
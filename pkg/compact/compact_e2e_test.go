@@ -33,7 +33,7 @@ func TestSyncer_SyncMetas_e2e(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 		defer cancel()
 
-		sy, err := NewSyncer(nil, nil, bkt, 0)
+		sy, err := NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
 		testutil.Ok(t, err)
 
 		// Generate 15 blocks. Initially the first 10 are synced into memory and only the last
@@ -124,7 +124,7 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 		m3.Thanos.Downsample.Resolution = 0
 
 		var m4 block.Meta
-		m4.Version = 14
+		m4.Version = 1
 		m4.ULID = ulid.MustNew(400, nil)
 		m4.Compaction.Level = 2
 		m4.Compaction.Sources = ids[9:] // covers the last block but is a different resolution. Must not trigger deletion.
@@ -139,7 +139,7 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 		}
 
 		// Do one initial synchronization with the bucket.
-		sy, err := NewSyncer(nil, nil, bkt, 0)
+		sy, err := NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
 		testutil.Ok(t, err)
 		testutil.Ok(t, sy.SyncMetas(ctx))
 
@@ -172,6 +172,121 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 	})
 }
 
+func TestSyncer_CleanPartialUploads_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		var (
+			complete   = ulid.MustNew(1, nil)
+			partial    = ulid.MustNew(2, nil)
+			invalid    = ulid.MustNew(3, nil)
+			uploading  = ulid.MustNew(ulid.Now(), nil)
+			chunksFile = path.Join(block.ChunksDirname, "000001")
+		)
+		var meta block.Meta
+		meta.Version = 1
+		meta.ULID = complete
+
+		var buf bytes.Buffer
+		testutil.Ok(t, json.NewEncoder(&buf).Encode(&meta))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(complete.String(), block.MetaFilename), &buf))
+
+		for _, id := range []ulid.ULID{complete, partial, invalid, uploading} {
+			testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), chunksFile), bytes.NewBufferString("chunks")))
+		}
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(invalid.String(), block.MetaFilename), bytes.NewBufferString("{")))
+
+		sy, err := NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
+		testutil.Ok(t, err)
+
+		// Blocks without meta.json must not break the sync.
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		testutil.Ok(t, sy.CleanPartialUploads(ctx, time.Hour))
+
+		var rem []ulid.ULID
+		err = bkt.Iter(ctx, "", func(n string) error {
+			rem = append(rem, ulid.MustParse(n[:len(n)-1]))
+			return nil
+		})
+		testutil.Ok(t, err)
+
+		sort.Slice(rem, func(i, j int) bool {
+			return rem[i].Compare(rem[j]) < 0
+		})
+		// The complete block and the block that may still be uploading are left.
+		testutil.Equals(t, []ulid.ULID{complete, uploading}, rem)
+	})
+}
+
+func TestSyncer_Marks_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		var (
+			plain     = ulid.MustNew(1, nil)
+			noCompact = ulid.MustNew(2, nil)
+			deletion  = ulid.MustNew(3, nil)
+		)
+		for _, id := range []ulid.ULID{plain, noCompact, deletion} {
+			var meta block.Meta
+			meta.Version = 1
+			meta.ULID = id
+
+			var buf bytes.Buffer
+			testutil.Ok(t, json.NewEncoder(&buf).Encode(&meta))
+			testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.MetaFilename), &buf))
+			testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.ChunksDirname, "000001"), bytes.NewBufferString("chunks")))
+		}
+		testutil.Ok(t, block.MarkBlock(ctx, log.NewNopLogger(), bkt, noCompact, block.NoCompactMarkFilename, "test"))
+		testutil.Ok(t, block.MarkBlock(ctx, log.NewNopLogger(), bkt, deletion, block.DeletionMarkFilename, "test"))
+
+		sy, err := NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
+		testutil.Ok(t, err)
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		// Blocks marked for no compaction are not compacted, blocks marked for deletion are ignored.
+		groups, err := sy.Groups()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(groups))
+		testutil.Equals(t, []ulid.ULID{plain}, groups[0].IDs())
+		testutil.Equals(t, 1, len(groups[0].excluded))
+		testutil.Equals(t, noCompact, groups[0].excluded[0].ULID)
+		testutil.Equals(t, "test", sy.noCompactMarks[noCompact].Details)
+
+		// Marked blocks are only deleted after the delay.
+		testutil.Ok(t, sy.DeleteMarkedBlocks(ctx, time.Hour))
+		ok, err := bkt.Exists(ctx, path.Join(deletion.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "block marked for deletion was deleted before the delay")
+
+		testutil.Ok(t, sy.DeleteMarkedBlocks(ctx, 0))
+
+		var rem []ulid.ULID
+		err = bkt.Iter(ctx, "", func(n string) error {
+			rem = append(rem, ulid.MustParse(n[:len(n)-1]))
+			return nil
+		})
+		testutil.Ok(t, err)
+
+		sort.Slice(rem, func(i, j int) bool {
+			return rem[i].Compare(rem[j]) < 0
+		})
+		testutil.Equals(t, []ulid.ULID{plain, noCompact}, rem)
+
+		// Unmarked blocks are compacted again.
+		testutil.Ok(t, block.UnmarkBlock(ctx, log.NewNopLogger(), bkt, noCompact, block.NoCompactMarkFilename))
+		testutil.Ok(t, sy.SyncMetas(ctx))
+
+		groups, err = sy.Groups()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(groups))
+		testutil.Equals(t, []ulid.ULID{plain, noCompact}, groups[0].IDs())
+	})
+}
+
 func TestGroup_Compact_e2e(t *testing.T) {
 	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
 		prepareDir, err := ioutil.TempDir("", "test-compact-prepare")
@@ -249,6 +364,14 @@ func TestGroup_Compact_e2e(t *testing.T) {
 			metrics.compactions.WithLabelValues(""),
 			metrics.compactionFailures.WithLabelValues(""),
 			metrics.garbageCollectedBlocks,
+			nil,
+			1,
+			0,
+			metrics.blockDownloadDuration,
+			metrics.blockDownloadedBytes,
+			nil,
+			false,
+			metrics.corruptedBlocks,
 		)
 		testutil.Ok(t, err)
 
@@ -302,3 +425,112 @@ func TestGroup_Compact_e2e(t *testing.T) {
 		testutil.Ok(t, err)
 	})
 }
+
+func TestGroup_Compact_Strict_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		prepareDir, err := ioutil.TempDir("", "test-compact-strict-prepare")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(prepareDir)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		extLset := labels.Labels{{Name: "e1", Value: "1"}}
+		series := []labels.Labels{{{Name: "a", Value: "1"}}, {{Name: "a", Value: "2"}}}
+
+		var (
+			ids   []ulid.ULID
+			metas []*block.Meta
+		)
+		for _, r := range [][2]int64{{0, 1000}, {1001, 2000}, {2001, 3000}, {3001, 4000}, {4001, 5000}, {5001, 6000}, {6001, 7000}, {7001, 8000}} {
+			id, err := testutil.CreateBlock(prepareDir, series, 100, r[0], r[1], extLset, 124)
+			testutil.Ok(t, err)
+
+			meta, err := block.ReadMetaFile(filepath.Join(prepareDir, id.String()))
+			testutil.Ok(t, err)
+			ids, metas = append(ids, id), append(metas, meta)
+		}
+		corrupted := ids[1]
+
+		// Flip the last byte of the chunks, which is part of the checksum of the last chunk.
+		fn := filepath.Join(prepareDir, corrupted.String(), block.ChunksDirname, "000001")
+		b, err := ioutil.ReadFile(fn)
+		testutil.Ok(t, err)
+		b[len(b)-1]++
+		testutil.Ok(t, ioutil.WriteFile(fn, b, 0666))
+
+		for _, id := range ids {
+			testutil.Ok(t, block.Upload(ctx, bkt, filepath.Join(prepareDir, id.String())))
+		}
+
+		dir, err := ioutil.TempDir("", "test-compact-strict")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(dir)
+
+		metrics := newSyncerMetrics(nil)
+		newStrictGroup := func() *Group {
+			g, err := newGroup(
+				log.NewNopLogger(),
+				bkt,
+				extLset,
+				124,
+				metrics.compactions.WithLabelValues(""),
+				metrics.compactionFailures.WithLabelValues(""),
+				metrics.garbageCollectedBlocks,
+				nil,
+				1,
+				0,
+				metrics.blockDownloadDuration,
+				metrics.blockDownloadedBytes,
+				nil,
+				true,
+				metrics.corruptedBlocks,
+			)
+			testutil.Ok(t, err)
+			return g
+		}
+		g := newStrictGroup()
+		for _, m := range metas {
+			testutil.Ok(t, g.Add(m))
+		}
+
+		comp, err := tsdb.NewLeveledCompactor(nil, log.NewLogfmtLogger(os.Stderr), []int64{1000, 3000}, nil)
+		testutil.Ok(t, err)
+
+		_, err = g.Compact(ctx, dir, comp)
+		testutil.NotOk(t, err)
+		testutil.Assert(t, IsHaltError(err), "expected halt error, got %s", err)
+
+		// The corrupted block is marked, so it is not compacted again, and no source block was deleted.
+		ok, err := bkt.Exists(ctx, path.Join(corrupted.String(), block.NoCompactMarkFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "corrupted block not marked for no compaction")
+
+		for _, id := range ids {
+			ok, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+			testutil.Ok(t, err)
+			testutil.Assert(t, ok, "block %s deleted", id)
+		}
+
+		// After a restart the marked block is excluded and later ranges of the group are still compacted.
+		g = newStrictGroup()
+		for _, m := range metas {
+			if m.ULID == corrupted {
+				testutil.Ok(t, g.exclude(m))
+				continue
+			}
+			testutil.Ok(t, g.Add(m))
+		}
+		id, err := g.Compact(ctx, dir, comp)
+		testutil.Ok(t, err)
+		testutil.Assert(t, id != ulid.ULID{}, "no compaction took place")
+
+		meta, err := block.DownloadMeta(ctx, log.NewNopLogger(), bkt, id)
+		testutil.Ok(t, err)
+		exp := []ulid.ULID{ids[3], ids[4], ids[5]}
+		sort.Slice(exp, func(i, j int) bool {
+			return exp[i].Compare(exp[j]) < 0
+		})
+		testutil.Equals(t, exp, meta.Compaction.Sources)
+	})
+}
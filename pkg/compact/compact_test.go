@@ -1,10 +1,19 @@
 package compact
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
 )
 
 func TestHaltError(t *testing.T) {
@@ -37,3 +46,165 @@ func TestRetryError(t *testing.T) {
 	err = errors.Wrap(retry(errors.Wrap(halt(errors.New("test")), "something")), "something2")
 	testutil.Assert(t, IsHaltError(err), "not a halt error. Retry should not hide halt error")
 }
+
+func TestGroup_overlapError(t *testing.T) {
+	newMeta := func(id uint64, mint, maxt int64) *block.Meta {
+		var m block.Meta
+		m.ULID = ulid.MustNew(id, nil)
+		m.MinTime = mint
+		m.MaxTime = maxt
+		m.Thanos.Labels = map[string]string{"cluster": "a"}
+		return &m
+	}
+	var (
+		m1 = newMeta(1, 0, 100)
+		m2 = newMeta(2, 100, 200)
+		m3 = newMeta(3, 150, 250)
+	)
+	cg := &Group{
+		labels: labels.FromStrings("cluster", "a"),
+		blocks: map[ulid.ULID]*block.Meta{m1.ULID: m1, m2.ULID: m2},
+	}
+	testutil.Ok(t, cg.overlapError())
+
+	cg.blocks[m3.ULID] = m3
+	err := cg.overlapError()
+	testutil.NotOk(t, err)
+	testutil.Equals(t, []ulid.ULID{m2.ULID, m3.ULID}, err.(OverlapError).Blocks())
+
+	testutil.Assert(t, IsOverlapError(err), "not an overlap error")
+	testutil.Assert(t, IsOverlapError(errors.Wrap(halt(errors.Wrap(err, "something")), "something2")), "halting hides overlap error")
+	testutil.Assert(t, !IsOverlapError(halt(errors.New("test"))), "overlap error")
+}
+
+func TestGroup_Planned_Excluded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-group-planned")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	comp, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	newMeta := func(id uint64, mint, maxt int64) *block.Meta {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = ulid.MustNew(id, nil)
+		m.MinTime = mint
+		m.MaxTime = maxt
+		m.Compaction.Level = 1
+		return &m
+	}
+	newGroup := func(excluded *block.Meta, metas ...*block.Meta) *Group {
+		cg := &Group{
+			logger:   log.NewNopLogger(),
+			blocks:   map[ulid.ULID]*block.Meta{},
+			excluded: []*block.Meta{excluded},
+		}
+		for _, m := range metas {
+			cg.blocks[m.ULID] = m
+		}
+		return cg
+	}
+
+	// A marked block in the first range must not stall the compaction of later ranges.
+	var (
+		b1 = newMeta(1, 0, 1000)
+		b2 = newMeta(2, 2000, 3000)
+		b3 = newMeta(3, 3000, 4000)
+		b4 = newMeta(4, 4000, 5000)
+		b5 = newMeta(5, 5000, 6000)
+		b6 = newMeta(6, 6000, 7000)
+	)
+	plans, err := newGroup(newMeta(10, 1000, 2000), b1, b2, b3, b4, b5, b6).Planned(dir, comp)
+	testutil.Ok(t, err)
+	testutil.Equals(t, [][]ulid.ULID{{b3.ULID, b4.ULID, b5.ULID}}, plans)
+
+	// Blocks before a marked block are complete, so all of them are compacted.
+	var (
+		c1 = newMeta(1, 0, 1000)
+		c2 = newMeta(2, 1000, 2000)
+		c3 = newMeta(3, 2000, 3000)
+	)
+	plans, err = newGroup(newMeta(10, 3000, 4000), c1, c2, c3).Planned(dir, comp)
+	testutil.Ok(t, err)
+	testutil.Equals(t, [][]ulid.ULID{{c1.ULID, c2.ULID, c3.ULID}}, plans)
+}
+
+func TestGroup_Planned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-group-planned")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	comp, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{500, 1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	cg := &Group{
+		logger: log.NewNopLogger(),
+		blocks: map[ulid.ULID]*block.Meta{},
+	}
+	var ids []ulid.ULID
+	for i := int64(0); i < 7; i++ {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = ulid.MustNew(uint64(i+1), nil)
+		m.MinTime = i * 500
+		m.MaxTime = (i + 1) * 500
+		m.Compaction.Level = 1
+		cg.blocks[m.ULID] = &m
+		ids = append(ids, m.ULID)
+	}
+
+	// The results of the first compactions are compacted again, the newest block is never compacted.
+	plans, err := cg.Planned(dir, comp)
+	testutil.Ok(t, err)
+	testutil.Equals(t, [][]ulid.ULID{ids[0:2], ids[2:4], ids[4:6], ids[0:6]}, plans)
+	testutil.Equals(t, 7, len(cg.blocks))
+}
+
+func TestSyncer_GarbageBlocks_SourceGracePeriod(t *testing.T) {
+	sy, err := NewSyncer(nil, nil, nil, 0, time.Hour, 1, 0, nil, false)
+	testutil.Ok(t, err)
+
+	newMeta := func(id ulid.ULID, level int, res int64, sources ...ulid.ULID) *block.Meta {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = id
+		m.Compaction.Level = level
+		m.Compaction.Sources = sources
+		m.Thanos.Downsample.Resolution = res
+		return &m
+	}
+	var (
+		old    = ulid.Now() - uint64(2*time.Hour/time.Millisecond)
+		src1   = ulid.MustNew(old, nil)
+		src2   = ulid.MustNew(old+1, nil)
+		src3   = ulid.MustNew(old+2, nil)
+		src4   = ulid.MustNew(old+3, nil)
+		raw1   = newMeta(ulid.MustNew(old+10, nil), 2, downsample.ResLevel0, src1, src2)
+		raw2   = newMeta(ulid.MustNew(old+11, nil), 2, downsample.ResLevel0, src3, src4)
+		raw3   = newMeta(ulid.MustNew(old+20, nil), 3, downsample.ResLevel0, src1, src2, src3, src4)
+		recent = newMeta(ulid.MustNew(ulid.Now(), nil), 2, downsample.ResLevel1, src1, src2)
+		past   = newMeta(ulid.MustNew(old+12, nil), 2, downsample.ResLevel1, src3, src4)
+	)
+	for _, m := range []*block.Meta{raw1, raw2, raw3, recent, past} {
+		sy.blocks[m.ULID] = m
+	}
+
+	// Both raw blocks are compacted into raw3, but raw1 was downsampled just now and must be kept.
+	ids, err := sy.GarbageBlocks(downsample.ResLevel0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{raw2.ULID}, ids)
+
+	// The kept block must not be compacted again.
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(groups))
+	testutil.Equals(t, []ulid.ULID{raw2.ULID, raw3.ULID}, groups[0].IDs())
+
+	// Without a grace period everything is garbage right away.
+	sy.sourceGracePeriod = 0
+
+	ids, err = sy.GarbageBlocks(downsample.ResLevel0)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(ids))
+}
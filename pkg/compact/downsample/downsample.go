@@ -28,11 +28,13 @@ const (
 )
 
 // Downsample downsamples the given block. It writes a new block into dir and returns its ID.
+// The given overrides decide which aggregates are computed for each series and may be nil.
 func Downsample(
 	origMeta *block.Meta,
 	b tsdb.BlockReader,
 	dir string,
 	resolution int64,
+	overrides *Overrides,
 ) (id ulid.ULID, err error) {
 	if origMeta.Thanos.Downsample.Resolution >= resolution {
 		return id, errors.New("target resolution not lower than existing one")
@@ -87,6 +89,7 @@ func Downsample(
 			}
 			chks[i].Chunk = chk
 		}
+		kind := overrides.Kind(lset)
 
 		// Raw and already downsampled data need different processing.
 		if origMeta.Thanos.Downsample.Resolution == 0 {
@@ -95,7 +98,7 @@ func Downsample(
 					return id, errors.Wrapf(err, "expand chunk %d", c.Ref)
 				}
 			}
-			newb.addSeries(&series{lset: lset, chunks: downsampleRaw(all, resolution)})
+			newb.addSeries(&series{lset: lset, chunks: downsampleRaw(all, resolution, kind)})
 			continue
 		}
 
@@ -110,6 +113,7 @@ func Downsample(
 			chks[len(chks)-1].MaxTime,
 			origMeta.Thanos.Downsample.Resolution,
 			resolution,
+			kind,
 		)
 		if err != nil {
 			return id, errors.Wrap(err, "downsample aggregate block")
@@ -326,7 +330,7 @@ type aggrChunkBuilder struct {
 	apps   [5]chunkenc.Appender
 }
 
-func newAggrChunkBuilder() *aggrChunkBuilder {
+func newAggrChunkBuilder(kind SeriesKind) *aggrChunkBuilder {
 	b := &aggrChunkBuilder{
 		mint: math.MaxInt64,
 		maxt: math.MinInt64,
@@ -335,7 +339,9 @@ func newAggrChunkBuilder() *aggrChunkBuilder {
 	b.chunks[AggrSum] = chunkenc.NewXORChunk()
 	b.chunks[AggrMin] = chunkenc.NewXORChunk()
 	b.chunks[AggrMax] = chunkenc.NewXORChunk()
-	b.chunks[AggrCounter] = chunkenc.NewXORChunk()
+	if kind != KindGauge {
+		b.chunks[AggrCounter] = chunkenc.NewXORChunk()
+	}
 
 	for i, c := range b.chunks {
 		if c != nil {
@@ -356,13 +362,17 @@ func (b *aggrChunkBuilder) add(t int64, aggr *aggregator) {
 	b.apps[AggrMin].Append(t, aggr.min)
 	b.apps[AggrMax].Append(t, aggr.max)
 	b.apps[AggrCount].Append(t, float64(aggr.count))
-	b.apps[AggrCounter].Append(t, aggr.counter)
+	if b.apps[AggrCounter] != nil {
+		b.apps[AggrCounter].Append(t, aggr.counter)
+	}
 
 	b.added++
 }
 
 func (b *aggrChunkBuilder) finalizeChunk(lastT int64, trueSample float64) {
-	b.apps[AggrCounter].Append(lastT, trueSample)
+	if b.apps[AggrCounter] != nil {
+		b.apps[AggrCounter].Append(lastT, trueSample)
+	}
 }
 
 func (b *aggrChunkBuilder) encode() chunks.Meta {
@@ -374,7 +384,7 @@ func (b *aggrChunkBuilder) encode() chunks.Meta {
 }
 
 // downsampleRaw create a series of aggregation chunks for the given sample data.
-func downsampleRaw(data []sample, resolution int64) []chunks.Meta {
+func downsampleRaw(data []sample, resolution int64, kind SeriesKind) []chunks.Meta {
 	if len(data) == 0 {
 		return nil
 	}
@@ -399,7 +409,7 @@ func downsampleRaw(data []sample, resolution int64) []chunks.Meta {
 		for ; j < len(data) && data[j].t <= curW; j++ {
 		}
 
-		ab := newAggrChunkBuilder()
+		ab := newAggrChunkBuilder(kind)
 		batch := data[:j]
 		data = data[j:]
 
@@ -449,7 +459,7 @@ func downsampleBatch(data []sample, resolution int64, add func(int64, *aggregato
 }
 
 // downsampleAggr downsamples a sequence of aggregation chunks to the given resolution.
-func downsampleAggr(chks []*AggrChunk, buf *[]sample, mint, maxt, inRes, outRes int64) ([]chunks.Meta, error) {
+func downsampleAggr(chks []*AggrChunk, buf *[]sample, mint, maxt, inRes, outRes int64, kind SeriesKind) ([]chunks.Meta, error) {
 	// We downsample aggregates only along chunk boundaries. This is required for counters
 	// to be downsampled correctly since a chunks' last counter value is the true last value
 	// of the original series. We need to preserve it even across multiple aggregation iterations.
@@ -471,7 +481,7 @@ func downsampleAggr(chks []*AggrChunk, buf *[]sample, mint, maxt, inRes, outRes
 		part := chks[:j]
 		chks = chks[j:]
 
-		chk, err := downsampleAggrBatch(part, buf, outRes)
+		chk, err := downsampleAggrBatch(part, buf, outRes, kind)
 		if err != nil {
 			return nil, err
 		}
@@ -500,7 +510,7 @@ func expandChunkIterator(it chunkenc.Iterator, buf *[]sample) error {
 	return it.Err()
 }
 
-func downsampleAggrBatch(chks []*AggrChunk, buf *[]sample, resolution int64) (chk chunks.Meta, err error) {
+func downsampleAggrBatch(chks []*AggrChunk, buf *[]sample, resolution int64, kind SeriesKind) (chk chunks.Meta, err error) {
 	ab := &aggrChunkBuilder{}
 	mint, maxt := int64(math.MaxInt64), int64(math.MinInt64)
 
@@ -557,6 +567,13 @@ func downsampleAggrBatch(chks []*AggrChunk, buf *[]sample, resolution int64) (ch
 		return chk, err
 	}
 
+	// Gauges carry no counter aggregate, even if the input had one.
+	if kind == KindGauge {
+		ab.mint = mint
+		ab.maxt = maxt
+		return ab.encode(), nil
+	}
+
 	// Handle counters by reading them properly.
 	acs := make([]chunkenc.Iterator, 0, len(chks))
 	for _, achk := range chks {
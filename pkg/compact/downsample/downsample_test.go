@@ -54,7 +54,63 @@ func TestDownsampleRaw(t *testing.T) {
 			},
 		},
 	}
-	testDownsample(t, input, &block.Meta{}, 100)
+	testDownsample(t, input, &block.Meta{}, 100, nil)
+}
+
+func TestDownsampleRaw_Overrides(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	overrides, err := NewOverrides(nil, []string{"node_memory_.*"})
+	testutil.Ok(t, err)
+
+	in := []sample{{20, 1}, {40, 2}, {60, 3}, {80, 1}, {100, 2}, {120, 5}, {180, 10}, {250, 1}}
+
+	input := []*downsampleTestSet{
+		{
+			// Matches the gauge pattern, no counter aggregate must be written.
+			lset:  labels.FromStrings("__name__", "node_memory_free"),
+			inRaw: in,
+			output: map[AggrType][]sample{
+				AggrCount: {{99, 4}, {199, 3}, {250, 1}},
+				AggrSum:   {{99, 7}, {199, 17}, {250, 1}},
+				AggrMin:   {{99, 1}, {199, 2}, {250, 1}},
+				AggrMax:   {{99, 3}, {199, 10}, {250, 1}},
+			},
+		},
+		{
+			// Matches no pattern, all aggregates must be written.
+			lset:  labels.FromStrings("__name__", "node_cpu_seconds"),
+			inRaw: in,
+			output: map[AggrType][]sample{
+				AggrCount:   {{99, 4}, {199, 3}, {250, 1}},
+				AggrSum:     {{99, 7}, {199, 17}, {250, 1}},
+				AggrMin:     {{99, 1}, {199, 2}, {250, 1}},
+				AggrMax:     {{99, 3}, {199, 10}, {250, 1}},
+				AggrCounter: {{99, 4}, {199, 13}, {250, 14}, {250, 1}},
+			},
+		},
+	}
+	testDownsample(t, input, &block.Meta{}, 100, overrides)
+}
+
+func TestNewOverrides(t *testing.T) {
+	_, err := NewOverrides(nil, []string{"a_.*", "b_.*"})
+	testutil.Ok(t, err)
+
+	_, err = NewOverrides(nil, []string{""})
+	testutil.NotOk(t, err)
+
+	_, err = NewOverrides(nil, []string{"a_("})
+	testutil.NotOk(t, err)
+
+	_, err = NewOverrides(nil, []string{"a_.*", "a_.*"})
+	testutil.NotOk(t, err)
+
+	o, err := NewOverrides(nil, []string{"a"})
+	testutil.Ok(t, err)
+	// Patterns are fully anchored.
+	testutil.Equals(t, KindGauge, o.Kind(labels.FromStrings("__name__", "a")))
+	testutil.Equals(t, KindUnknown, o.Kind(labels.FromStrings("__name__", "ab")))
 }
 
 func TestDownsampleAggr(t *testing.T) {
@@ -94,11 +150,11 @@ func TestDownsampleAggr(t *testing.T) {
 	var meta block.Meta
 	meta.Thanos.Downsample.Resolution = 10
 
-	testDownsample(t, input, &meta, 500)
+	testDownsample(t, input, &meta, 500, nil)
 }
 
 func encodeTestAggrSeries(v map[AggrType][]sample) chunks.Meta {
-	b := newAggrChunkBuilder()
+	b := newAggrChunkBuilder(KindUnknown)
 
 	for at, d := range v {
 		for _, s := range d {
@@ -118,7 +174,7 @@ type downsampleTestSet struct {
 
 // testDownsample inserts the input into a block and invokes the downsampler with the given resolution.
 // The chunk ranges within the input block are aligned at 500 time units.
-func testDownsample(t *testing.T, data []*downsampleTestSet, meta *block.Meta, resolution int64) {
+func testDownsample(t *testing.T, data []*downsampleTestSet, meta *block.Meta, resolution int64, overrides *Overrides) {
 	t.Helper()
 
 	dir, err := ioutil.TempDir("", "downsample-raw")
@@ -153,7 +209,7 @@ func testDownsample(t *testing.T, data []*downsampleTestSet, meta *block.Meta, r
 		mb.addSeries(ser)
 	}
 
-	id, err := Downsample(meta, mb, dir, resolution)
+	id, err := Downsample(meta, mb, dir, resolution, overrides)
 	testutil.Ok(t, err)
 
 	exp := map[uint64]map[AggrType][]sample{}
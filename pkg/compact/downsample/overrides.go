@@ -0,0 +1,95 @@
+package downsample
+
+import (
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// SeriesKind decides which aggregates are computed for a series during downsampling.
+type SeriesKind int
+
+const (
+	// KindUnknown series get all aggregates computed.
+	KindUnknown SeriesKind = iota
+	// KindGauge series get no counter aggregate. Counter reset handling turns every decrease
+	// of a gauge into a reset, so the aggregate would only yield misleading rate() results.
+	// Stores serve the max aggregate in place of it.
+	KindGauge
+)
+
+func (k SeriesKind) String() string {
+	if k == KindGauge {
+		return "gauge"
+	}
+	return "unknown"
+}
+
+// Overrides classifies series by patterns over their metric name. All series are downsampled
+// with the counter aggregate by default, so only gauges need to be configured.
+// A nil Overrides classifies all series as KindUnknown.
+type Overrides struct {
+	gauges   []*regexp.Regexp
+	patterns []string
+
+	matched *prometheus.CounterVec
+}
+
+// NewOverrides validates the given gauge metric name patterns and returns Overrides for them.
+// Patterns are fully anchored regular expressions.
+func NewOverrides(reg prometheus.Registerer, gaugePatterns []string) (*Overrides, error) {
+	o := &Overrides{
+		matched: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compact_downsample_override_series_total",
+			Help: "Total number of downsampled series that matched a gauge override pattern.",
+		}, []string{"pattern"}),
+	}
+	seen := map[string]struct{}{}
+
+	for _, p := range gaugePatterns {
+		if _, ok := seen[p]; ok {
+			return nil, errors.Errorf("gauge pattern %q configured twice", p)
+		}
+		re, err := compileOverridePattern(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "gauge pattern")
+		}
+		seen[p] = struct{}{}
+		o.gauges = append(o.gauges, re)
+		o.patterns = append(o.patterns, p)
+	}
+	if reg != nil {
+		reg.MustRegister(o.matched)
+	}
+	return o, nil
+}
+
+func compileOverridePattern(p string) (*regexp.Regexp, error) {
+	if p == "" {
+		return nil, errors.New("empty pattern")
+	}
+	re, err := regexp.Compile("^(?:" + p + ")$")
+	if err != nil {
+		return nil, errors.Wrapf(err, "compile %q", p)
+	}
+	return re, nil
+}
+
+// Kind returns the kind of the series with the given labels. The series is counted for the first
+// pattern it matches.
+func (o *Overrides) Kind(lset labels.Labels) SeriesKind {
+	if o == nil {
+		return KindUnknown
+	}
+	name := lset.Get("__name__")
+
+	for i, re := range o.gauges {
+		if re.MatchString(name) {
+			o.matched.WithLabelValues(o.patterns[i]).Inc()
+			return KindGauge
+		}
+	}
+	return KindUnknown
+}
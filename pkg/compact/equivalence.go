@@ -0,0 +1,104 @@
+package compact
+
+import (
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/labels"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LabelEquivalence configures external label sets whose blocks are compacted together with the blocks
+// of the canonical label set, e.g. after a relabel change split a stream of blocks in two.
+type LabelEquivalence struct {
+	Canonical  map[string]string   `yaml:"canonical"`
+	Equivalent []map[string]string `yaml:"equivalent"`
+}
+
+// LabelEquivalences maps the external labels of blocks onto the canonical labels they are compacted into.
+// A nil LabelEquivalences maps every label set onto itself.
+type LabelEquivalences struct {
+	canonical map[string]labels.Labels
+}
+
+// NewLabelEquivalences validates the given equivalences. Every label set may only be equivalent to a single
+// canonical label set and canonical label sets must not be equivalent to another one.
+func NewLabelEquivalences(cfgs []LabelEquivalence) (*LabelEquivalences, error) {
+	e := &LabelEquivalences{canonical: map[string]labels.Labels{}}
+	canonicals := map[string]struct{}{}
+
+	for i, cfg := range cfgs {
+		if len(cfg.Canonical) == 0 {
+			return nil, errors.Errorf("empty canonical labels in equivalence %d", i)
+		}
+		if len(cfg.Equivalent) == 0 {
+			return nil, errors.Errorf("no equivalent labels in equivalence %d", i)
+		}
+		canonical := labels.FromMap(cfg.Canonical)
+		canonicals[canonical.String()] = struct{}{}
+
+		for _, m := range cfg.Equivalent {
+			if len(m) == 0 {
+				return nil, errors.Errorf("empty equivalent labels in equivalence %d", i)
+			}
+			lset := labels.FromMap(m)
+			if lset.Equals(canonical) {
+				return nil, errors.Errorf("labels %s are equivalent to themselves", lset)
+			}
+			if _, ok := e.canonical[lset.String()]; ok {
+				return nil, errors.Errorf("labels %s are equivalent to more than one canonical label set", lset)
+			}
+			e.canonical[lset.String()] = canonical
+		}
+	}
+	for k := range e.canonical {
+		if _, ok := canonicals[k]; ok {
+			return nil, errors.Errorf("canonical labels %s are equivalent to another canonical label set", k)
+		}
+	}
+	return e, nil
+}
+
+// LoadLabelEquivalences parses and validates a YAML list of label equivalences from the given file.
+func LoadLabelEquivalences(filename string) (*LabelEquivalences, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read label equivalences file %s", filename)
+	}
+	var cfgs []LabelEquivalence
+	if err := yaml.UnmarshalStrict(b, &cfgs); err != nil {
+		return nil, errors.Wrapf(err, "parse label equivalences file %s", filename)
+	}
+	e, err := NewLabelEquivalences(cfgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid label equivalences file %s", filename)
+	}
+	return e, nil
+}
+
+// Canonical returns the canonical labels of blocks with the given external labels.
+func (e *LabelEquivalences) Canonical(lset labels.Labels) labels.Labels {
+	if e == nil {
+		return lset
+	}
+	if c, ok := e.canonical[lset.String()]; ok {
+		return c
+	}
+	return lset
+}
+
+// Mappings returns the sorted equivalent label sets by their canonical label set.
+func (e *LabelEquivalences) Mappings() map[string][]string {
+	if e == nil {
+		return nil
+	}
+	m := map[string][]string{}
+	for lset, c := range e.canonical {
+		m[c.String()] = append(m[c.String()], lset)
+	}
+	for _, lsets := range m {
+		sort.Strings(lsets)
+	}
+	return m
+}
@@ -0,0 +1,109 @@
+package compact
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestLoadLabelEquivalences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "label-equivalences")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "equivalences.yaml")
+	testutil.Ok(t, ioutil.WriteFile(fn, []byte(`
+- canonical:
+    cluster: eu-1
+  equivalent:
+  - cluster: eu1
+  - cluster: europe-1
+    env: prod
+`), 0666))
+
+	e, err := LoadLabelEquivalences(fn)
+	testutil.Ok(t, err)
+	testutil.Equals(t, labels.FromStrings("cluster", "eu-1"), e.Canonical(labels.FromStrings("cluster", "eu1")))
+	testutil.Equals(t, labels.FromStrings("cluster", "eu-1"), e.Canonical(labels.FromStrings("cluster", "europe-1", "env", "prod")))
+	testutil.Equals(t, labels.FromStrings("cluster", "europe-1"), e.Canonical(labels.FromStrings("cluster", "europe-1")))
+	testutil.Equals(t, map[string][]string{
+		`{cluster="eu-1"}`: {`{cluster="eu1"}`, `{cluster="europe-1",env="prod"}`},
+	}, e.Mappings())
+
+	// Unknown fields are rejected.
+	testutil.Ok(t, ioutil.WriteFile(fn, []byte(`
+- canonical:
+    cluster: eu-1
+  equivalents:
+  - cluster: eu1
+`), 0666))
+	_, err = LoadLabelEquivalences(fn)
+	testutil.NotOk(t, err)
+
+	// Without equivalences every label set is canonical.
+	var none *LabelEquivalences
+	testutil.Equals(t, labels.FromStrings("cluster", "eu1"), none.Canonical(labels.FromStrings("cluster", "eu1")))
+}
+
+func TestNewLabelEquivalences_Invalid(t *testing.T) {
+	for _, cfgs := range [][]LabelEquivalence{
+		{{Canonical: map[string]string{"a": "1"}}},
+		{{Equivalent: []map[string]string{{"a": "2"}}}},
+		{{Canonical: map[string]string{"a": "1"}, Equivalent: []map[string]string{{"a": "1"}}}},
+		{{Canonical: map[string]string{"a": "1"}, Equivalent: []map[string]string{{}}}},
+		{
+			{Canonical: map[string]string{"a": "1"}, Equivalent: []map[string]string{{"a": "2"}}},
+			{Canonical: map[string]string{"a": "3"}, Equivalent: []map[string]string{{"a": "2"}}},
+		},
+		{
+			{Canonical: map[string]string{"a": "1"}, Equivalent: []map[string]string{{"a": "2"}}},
+			{Canonical: map[string]string{"a": "2"}, Equivalent: []map[string]string{{"a": "3"}}},
+		},
+	} {
+		_, err := NewLabelEquivalences(cfgs)
+		testutil.NotOk(t, err)
+	}
+}
+
+func TestSyncer_Groups_LabelEquivalences(t *testing.T) {
+	e, err := NewLabelEquivalences([]LabelEquivalence{
+		{Canonical: map[string]string{"cluster": "eu-1"}, Equivalent: []map[string]string{{"cluster": "eu1"}}},
+	})
+	testutil.Ok(t, err)
+
+	sy, err := NewSyncer(nil, nil, nil, 0, 0, 1, 0, e, false)
+	testutil.Ok(t, err)
+
+	newMeta := func(id ulid.ULID, lset map[string]string) *block.Meta {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = id
+		m.Compaction.Level = 1
+		m.Compaction.Sources = []ulid.ULID{id}
+		m.Thanos.Labels = lset
+		return &m
+	}
+	now := ulid.Now() - uint64(time.Hour/time.Millisecond)
+	var (
+		old   = newMeta(ulid.MustNew(now, nil), map[string]string{"cluster": "eu1"})
+		cur   = newMeta(ulid.MustNew(now+1, nil), map[string]string{"cluster": "eu-1"})
+		other = newMeta(ulid.MustNew(now+2, nil), map[string]string{"cluster": "us-1"})
+	)
+	for _, m := range []*block.Meta{old, cur, other} {
+		sy.blocks[m.ULID] = m
+	}
+
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(groups))
+	testutil.Equals(t, labels.FromStrings("cluster", "eu-1"), groups[0].Labels())
+	testutil.Equals(t, []ulid.ULID{old.ULID, cur.ULID}, groups[0].IDs())
+	testutil.Equals(t, []ulid.ULID{other.ULID}, groups[1].IDs())
+}
@@ -0,0 +1,163 @@
+package compact
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/tsdb"
+)
+
+// throughputWeight is the weight of the throughput of the latest compaction in the moving average.
+const throughputWeight = 0.3
+
+// Progress estimates the remaining work of the planned compactions and the time it takes to finish them.
+// The work is estimated by the size of the blocks planned to be compacted, the time by the moving average
+// of the throughput of finished compactions. Compactions of results of earlier compactions are estimated by
+// the size of the blocks the results are made of.
+type Progress struct {
+	mtx sync.Mutex
+	// planned holds the sizes of the planned compactions of each group in the order they run.
+	planned     map[string][]uint64
+	compactions int
+	bytes       uint64
+	throughput  float64
+
+	plannedGroups      prometheus.Gauge
+	plannedCompactions prometheus.Gauge
+	plannedBytes       prometheus.Gauge
+	throughputBps      prometheus.Gauge
+	eta                prometheus.Gauge
+}
+
+// NewProgress returns a new Progress without any planned compactions.
+func NewProgress(reg prometheus.Registerer) *Progress {
+	p := &Progress{planned: map[string][]uint64{}}
+
+	p.plannedGroups = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_progress_planned_groups",
+		Help: "Number of groups with a planned compaction that did not finish yet.",
+	})
+	p.plannedCompactions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_progress_planned_compactions",
+		Help: "Number of planned compactions that did not finish yet.",
+	})
+	p.plannedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_progress_planned_bytes",
+		Help: "Total size of the blocks of the planned compactions that did not finish yet.",
+	})
+	p.throughputBps = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_progress_throughput_bytes_per_second",
+		Help: "Moving average of the size of compacted blocks per second of compaction, including their download and upload.",
+	})
+	p.eta = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_compact_progress_eta_seconds",
+		Help: "Estimated time until the planned compactions finish, based on the moving average of the throughput.",
+	})
+
+	if reg != nil {
+		reg.MustRegister(p.plannedGroups, p.plannedCompactions, p.plannedBytes, p.throughputBps, p.eta)
+	}
+	return p
+}
+
+// Plan estimates the work of all compactions of each of the given groups and replaces all previously
+// planned work. The metas of the blocks are written to dir for planning.
+func (p *Progress) Plan(ctx context.Context, sy *Syncer, groups []*Group, dir string, comp tsdb.Compactor) error {
+	var (
+		planned = make(map[string][]uint64, len(groups))
+		sizes   = map[ulid.ULID]uint64{}
+	)
+	for _, g := range groups {
+		plans, err := g.Planned(dir, comp)
+		if err != nil {
+			return errors.Wrapf(err, "plan compactions of group %s", g.Key())
+		}
+		for _, ids := range plans {
+			var size uint64
+			for _, id := range ids {
+				s, ok := sizes[id]
+				if !ok {
+					if s, err = sy.BlockSize(ctx, id); err != nil {
+						return err
+					}
+					sizes[id] = s
+				}
+				size += s
+			}
+			planned[g.Key()] = append(planned[g.Key()], size)
+		}
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.planned = planned
+	p.compactions = 0
+	p.bytes = 0
+	for _, sizes := range planned {
+		p.compactions += len(sizes)
+		for _, size := range sizes {
+			p.bytes += size
+		}
+	}
+	p.update()
+	return nil
+}
+
+// Done records that the next planned compaction of the group finished after the given duration.
+func (p *Progress) Done(group string, d time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	sizes, ok := p.planned[group]
+	if !ok {
+		return
+	}
+	size := sizes[0]
+	if len(sizes) == 1 {
+		delete(p.planned, group)
+	} else {
+		p.planned[group] = sizes[1:]
+	}
+	p.compactions--
+	p.bytes -= size
+
+	if size > 0 && d > 0 {
+		tp := float64(size) / d.Seconds()
+		if p.throughput == 0 {
+			p.throughput = tp
+		} else {
+			p.throughput = throughputWeight*tp + (1-throughputWeight)*p.throughput
+		}
+	}
+	p.update()
+}
+
+// Remaining returns the number of groups, compactions and bytes of the planned compactions that did not finish
+// yet and the estimated time to finish them. The time is 0 until the first compaction finished.
+func (p *Progress) Remaining() (groups, compactions int, bytes uint64, eta time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return len(p.planned), p.compactions, p.bytes, p.estimate()
+}
+
+func (p *Progress) estimate() time.Duration {
+	if p.throughput == 0 {
+		return 0
+	}
+	return time.Duration(float64(p.bytes) / p.throughput * float64(time.Second))
+}
+
+// update sets the metrics to the current estimates. The lock must be held.
+func (p *Progress) update() {
+	p.plannedGroups.Set(float64(len(p.planned)))
+	p.plannedCompactions.Set(float64(p.compactions))
+	p.plannedBytes.Set(float64(p.bytes))
+	p.throughputBps.Set(p.throughput)
+	p.eta.Set(p.estimate().Seconds())
+}
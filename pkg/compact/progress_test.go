@@ -0,0 +1,51 @@
+package compact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestProgress(t *testing.T) {
+	p := NewProgress(nil)
+	p.planned = map[string][]uint64{"a": {1000}, "b": {3000}, "c": {500, 1500}}
+	p.compactions = 4
+	p.bytes = 6000
+
+	// Without a finished compaction there is no throughput to estimate the time with.
+	groups, compactions, bytes, eta := p.Remaining()
+	testutil.Equals(t, 3, groups)
+	testutil.Equals(t, 4, compactions)
+	testutil.Equals(t, uint64(6000), bytes)
+	testutil.Equals(t, time.Duration(0), eta)
+
+	p.Done("a", time.Second)
+	groups, compactions, bytes, eta = p.Remaining()
+	testutil.Equals(t, 2, groups)
+	testutil.Equals(t, 3, compactions)
+	testutil.Equals(t, uint64(5000), bytes)
+	testutil.Equals(t, 5*time.Second, eta)
+
+	p.Done("b", time.Second)
+	throughput := throughputWeight*3000 + (1-throughputWeight)*1000
+	groups, compactions, bytes, eta = p.Remaining()
+	testutil.Equals(t, 1, groups)
+	testutil.Equals(t, 2, compactions)
+	testutil.Equals(t, uint64(2000), bytes)
+	testutil.Equals(t, time.Duration(2000/throughput*float64(time.Second)), eta)
+
+	// Compactions of a group finish in the planned order.
+	p.Done("c", time.Second)
+	groups, compactions, bytes, _ = p.Remaining()
+	testutil.Equals(t, 1, groups)
+	testutil.Equals(t, 1, compactions)
+	testutil.Equals(t, uint64(1500), bytes)
+
+	// Groups without a planned compaction do not change the estimate.
+	p.Done("d", time.Second)
+	groups, compactions, bytes, _ = p.Remaining()
+	testutil.Equals(t, 1, groups)
+	testutil.Equals(t, 1, compactions)
+	testutil.Equals(t, uint64(1500), bytes)
+}
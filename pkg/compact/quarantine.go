@@ -0,0 +1,123 @@
+package compact
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Quarantine tracks consecutive compaction failures per group. A group that failed too many times in a row
+// is quarantined: it is skipped, so that the other groups keep compacting, and only retried after a longer interval.
+// Failures are tracked in memory only. They are lost on restart and a single pass, as run without --wait, tries each
+// group once, so it stops on the first failure unless groups are quarantined after a single failure.
+type Quarantine struct {
+	logger        log.Logger
+	maxFailures   int
+	retryInterval time.Duration
+	now           func() time.Time
+
+	mtx    sync.Mutex
+	groups map[string]*quarantineState
+
+	quarantined *prometheus.GaugeVec
+	quarantines prometheus.Counter
+}
+
+type quarantineState struct {
+	failures  int
+	lastError error
+	since     time.Time
+	lastTry   time.Time
+}
+
+// NewQuarantine returns a quarantine for groups that failed maxFailures times in a row, which are retried
+// every retryInterval. A maxFailures of 0 disables the quarantine.
+func NewQuarantine(logger log.Logger, reg prometheus.Registerer, maxFailures int, retryInterval time.Duration) *Quarantine {
+	q := &Quarantine{
+		logger:        logger,
+		maxFailures:   maxFailures,
+		retryInterval: retryInterval,
+		now:           time.Now,
+		groups:        map[string]*quarantineState{},
+		quarantined: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_compact_group_quarantined",
+			Help: "Set to 1 for each compaction group that is quarantined after repeated failures.",
+		}, []string{"group"}),
+		quarantines: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compact_group_quarantines_total",
+			Help: "Total number of times a compaction group was quarantined after repeated failures.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(q.quarantined, q.quarantines)
+	}
+	return q
+}
+
+// Skip returns true if the group is quarantined and not due for a retry.
+func (q *Quarantine) Skip(group string) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	s, ok := q.groups[group]
+	if !ok || s.since.IsZero() {
+		return false
+	}
+	return q.now().Sub(s.lastTry) < q.retryInterval
+}
+
+// Failed records a failed compaction of the group. It returns true if the group is quarantined,
+// in which case the error should not stop the compaction of other groups.
+func (q *Quarantine) Failed(group string, err error) bool {
+	if q.maxFailures <= 0 {
+		return false
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	s, ok := q.groups[group]
+	if !ok {
+		s = &quarantineState{}
+		q.groups[group] = s
+	}
+	now := q.now()
+
+	s.failures++
+	s.lastError = err
+	s.lastTry = now
+
+	if s.failures < q.maxFailures {
+		return false
+	}
+	if s.since.IsZero() {
+		s.since = now
+		q.quarantines.Inc()
+		q.quarantined.WithLabelValues(group).Set(1)
+
+		level.Error(q.logger).Log("msg", "quarantined compaction group after repeated failures",
+			"group", group, "failures", s.failures, "retry_interval", q.retryInterval, "err", err)
+		return true
+	}
+	level.Warn(q.logger).Log("msg", "retry of quarantined compaction group failed",
+		"group", group, "failures", s.failures, "quarantined_since", s.since, "err", err)
+	return true
+}
+
+// Succeeded records a successful compaction of the group, which lifts its quarantine.
+func (q *Quarantine) Succeeded(group string) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	s, ok := q.groups[group]
+	if !ok {
+		return
+	}
+	if !s.since.IsZero() {
+		q.quarantined.DeleteLabelValues(group)
+		level.Info(q.logger).Log("msg", "lifted quarantine of compaction group", "group", group, "quarantined_since", s.since)
+	}
+	delete(q.groups, group)
+}
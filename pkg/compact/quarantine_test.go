@@ -0,0 +1,47 @@
+package compact
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestQuarantine(t *testing.T) {
+	now := time.Unix(0, 0)
+	q := NewQuarantine(log.NewNopLogger(), nil, 2, time.Hour)
+	q.now = func() time.Time { return now }
+
+	err := errors.New("compaction failed")
+
+	testutil.Assert(t, !q.Skip("a"), "unknown group skipped")
+	testutil.Assert(t, !q.Failed("a", err), "group quarantined after first failure")
+	testutil.Assert(t, !q.Skip("a"), "group skipped after first failure")
+
+	// A success resets the consecutive failures.
+	q.Succeeded("a")
+	testutil.Assert(t, !q.Failed("a", err), "group quarantined after first failure")
+	testutil.Assert(t, q.Failed("a", err), "group not quarantined after second failure")
+	testutil.Assert(t, q.Skip("a"), "quarantined group not skipped")
+	testutil.Assert(t, !q.Skip("b"), "other group skipped")
+
+	// The group is retried after the retry interval and quarantined again right away if it still fails.
+	now = now.Add(time.Hour)
+	testutil.Assert(t, !q.Skip("a"), "quarantined group not retried")
+	testutil.Assert(t, q.Failed("a", err), "group not quarantined after failed retry")
+	testutil.Assert(t, q.Skip("a"), "quarantined group not skipped")
+
+	now = now.Add(time.Hour)
+	testutil.Assert(t, !q.Skip("a"), "quarantined group not retried")
+	q.Succeeded("a")
+	testutil.Assert(t, !q.Skip("a"), "group skipped after successful retry")
+
+	// A quarantine after 0 failures disables it.
+	q = NewQuarantine(log.NewNopLogger(), nil, 0, time.Hour)
+	for i := 0; i < 5; i++ {
+		testutil.Assert(t, !q.Failed("a", err), "group quarantined although quarantine is disabled")
+	}
+	testutil.Assert(t, !q.Skip("a"), "group skipped although quarantine is disabled")
+}
@@ -0,0 +1,262 @@
+package compact
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// RetentionPlan lists all blocks that exceed the retention of their resolution, grouped by their external labels.
+type RetentionPlan struct {
+	Groups  []RetentionGroup `json:"groups"`
+	Size    uint64           `json:"size_bytes"`
+	Samples uint64           `json:"samples"`
+}
+
+// RetentionGroup holds the blocks with the same external labels that exceed their retention.
+type RetentionGroup struct {
+	Labels  map[string]string `json:"labels"`
+	Blocks  []RetentionBlock  `json:"blocks"`
+	Size    uint64            `json:"size_bytes"`
+	Samples uint64            `json:"samples"`
+}
+
+// RetentionBlock is a block that exceeds its retention.
+type RetentionBlock struct {
+	ID         ulid.ULID `json:"id"`
+	Resolution int64     `json:"resolution"`
+	MinTime    int64     `json:"min_time"`
+	MaxTime    int64     `json:"max_time"`
+	Size       uint64    `json:"size_bytes"`
+	Samples    uint64    `json:"samples"`
+	Reason     string    `json:"reason"`
+}
+
+// exceedsRetention returns the reason if the block exceeds the retention of its resolution at the given time.
+// Resolutions without retention or with a retention of 0 are kept forever.
+func exceedsRetention(m *block.Meta, retentionByResolution map[int64]time.Duration, now time.Time) (string, bool) {
+	retention, ok := retentionByResolution[m.Thanos.Downsample.Resolution]
+	if !ok || retention <= 0 {
+		return "", false
+	}
+	maxTime := timestamp.Time(m.MaxTime)
+	if now.Sub(maxTime) <= retention {
+		return "", false
+	}
+	return fmt.Sprintf("resolution %s: data up to %s is older than the retention of %s",
+		time.Duration(m.Thanos.Downsample.Resolution)*time.Millisecond, maxTime.UTC().Format(time.RFC3339), retention), true
+}
+
+// PlanRetention returns all blocks in the bucket that exceed the retention of their resolution at the given time.
+// It does not delete anything. It is only a planner: the compactor has no age based retention and only applies the
+// size based retention of ApplySizeRetention.
+func PlanRetention(
+	ctx context.Context,
+	logger log.Logger,
+	bkt objstore.Bucket,
+	retentionByResolution map[int64]time.Duration,
+	now time.Time,
+) (*RetentionPlan, error) {
+	groups := map[string]*RetentionGroup{}
+
+	err := bkt.Iter(ctx, "", func(name string) error {
+		id, ok := block.IsBlockDir(name)
+		if !ok {
+			return nil
+		}
+		m, err := block.DownloadMeta(ctx, logger, bkt, id)
+		if bkt.IsObjNotFoundErr(errors.Cause(err)) {
+			// Partially uploaded blocks are not considered by the retention.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		reason, ok := exceedsRetention(&m, retentionByResolution, now)
+		if !ok {
+			return nil
+		}
+		size, err := dirSize(ctx, bkt, id.String())
+		if err != nil {
+			return errors.Wrapf(err, "get size of block %s", id)
+		}
+
+		key := labels.FromMap(m.Thanos.Labels).String()
+		g, ok := groups[key]
+		if !ok {
+			g = &RetentionGroup{Labels: m.Thanos.Labels}
+			groups[key] = g
+		}
+		g.Blocks = append(g.Blocks, RetentionBlock{
+			ID:         id,
+			Resolution: m.Thanos.Downsample.Resolution,
+			MinTime:    m.MinTime,
+			MaxTime:    m.MaxTime,
+			Size:       size,
+			Samples:    m.Stats.NumSamples,
+			Reason:     reason,
+		})
+		g.Size += size
+		g.Samples += m.Stats.NumSamples
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "iterate blocks")
+	}
+
+	var (
+		plan RetentionPlan
+		keys []string
+	)
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		g := groups[k]
+		sort.Slice(g.Blocks, func(i, j int) bool {
+			return g.Blocks[i].ID.Compare(g.Blocks[j].ID) < 0
+		})
+		plan.Groups = append(plan.Groups, *g)
+		plan.Size += g.Size
+		plan.Samples += g.Samples
+	}
+	return &plan, nil
+}
+
+// dirSize returns the total size of all objects in the directory.
+func dirSize(ctx context.Context, bkt objstore.Bucket, dir string) (size uint64, err error) {
+	err = bkt.Iter(ctx, dir, func(name string) error {
+		if strings.HasSuffix(name, objstore.DirDelim) {
+			s, err := dirSize(ctx, bkt, name)
+			size += s
+			return err
+		}
+		s, err := bkt.ObjectSize(ctx, name)
+		size += s
+		return err
+	})
+	return size, err
+}
+
+// BlockSize returns the total size of all files of the block in the bucket.
+func (c *Syncer) BlockSize(ctx context.Context, id ulid.ULID) (uint64, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.blockSize(ctx, id)
+}
+
+// blockSize returns the size of the block. Sizes are listed once per block and cached, as blocks are immutable.
+// The syncer lock must be held.
+func (c *Syncer) blockSize(ctx context.Context, id ulid.ULID) (uint64, error) {
+	if size, ok := c.blockSizes[id]; ok {
+		return size, nil
+	}
+	size, err := dirSize(ctx, c.bkt, id.String())
+	if err != nil {
+		return 0, errors.Wrapf(err, "get size of block %s", id)
+	}
+	c.blockSizes[id] = size
+	return size, nil
+}
+
+// ApplySizeRetention deletes blocks from the bucket until the total size of all synced blocks is at most maxBytes.
+// Blocks are deleted per group, i.e. per set of external labels: each deletion takes the next block of the group
+// currently holding the most data, so groups shrink towards the same size instead of one group losing all its
+// data first. Within a group the oldest data is deleted first and of blocks ending at the same time raw blocks are
+// deleted first and the coarsest resolution last, so that downsampled data is kept the longest. Block sizes are
+// listed once per block and cached, as blocks are immutable.
+func (c *Syncer) ApplySizeRetention(ctx context.Context, maxBytes uint64) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var (
+		total  uint64
+		groups = map[string][]*block.Meta{}
+		sizes  = map[string]uint64{}
+		keys   []string
+	)
+	for id, m := range c.blocks {
+		size, err := c.blockSize(ctx, id)
+		if err != nil {
+			return retry(err)
+		}
+		total += size
+
+		key := labels.FromMap(m.Thanos.Labels).String()
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], m)
+		sizes[key] += size
+	}
+	c.metrics.bucketSize.Set(float64(total))
+
+	if total <= maxBytes {
+		return nil
+	}
+	sort.Strings(keys)
+
+	for _, metas := range groups {
+		sort.Slice(metas, func(i, j int) bool {
+			if metas[i].MaxTime != metas[j].MaxTime {
+				return metas[i].MaxTime < metas[j].MaxTime
+			}
+			return metas[i].Thanos.Downsample.Resolution < metas[j].Thanos.Downsample.Resolution
+		})
+	}
+
+	for total > maxBytes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Keys are sorted, so groups of the same size are picked in a stable order.
+		key := ""
+		for _, k := range keys {
+			if len(groups[k]) > 0 && (key == "" || sizes[k] > sizes[key]) {
+				key = k
+			}
+		}
+		if key == "" {
+			break
+		}
+		m := groups[key][0]
+		size := c.blockSizes[m.ULID]
+
+		// Spawn a new context so we always delete a block in full on shutdown.
+		delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+
+		level.Info(c.logger).Log("msg", "deleting block exceeding the size based retention", "block", m.ULID,
+			"labels", key, "resolution", m.Thanos.Downsample.Resolution, "size", size, "group_size", sizes[key],
+			"bucket_size", total, "max_size", maxBytes)
+
+		err := block.Delete(delCtx, c.bkt, m.ULID)
+		cancel()
+		if err != nil {
+			return retry(errors.Wrapf(err, "delete block %s from bucket", m.ULID))
+		}
+		delete(c.blocks, m.ULID)
+		delete(c.blockSizes, m.ULID)
+
+		groups[key] = groups[key][1:]
+		sizes[key] -= size
+		total -= size
+		c.metrics.bucketSize.Set(float64(total))
+		c.metrics.retentionDeletedBlocks.Inc()
+		c.metrics.retentionReclaimedBytes.Add(float64(size))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+func TestPlanRetention(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	sizes := map[ulid.ULID]uint64{}
+
+	upload := func(id ulid.ULID, lset map[string]string, res int64, maxTime time.Time, samples uint64) {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = id
+		m.MinTime = timestamp.FromTime(maxTime.Add(-2 * time.Hour))
+		m.MaxTime = timestamp.FromTime(maxTime)
+		m.Stats.NumSamples = samples
+		m.Thanos.Labels = lset
+		m.Thanos.Downsample.Resolution = res
+
+		var buf bytes.Buffer
+		testutil.Ok(t, json.NewEncoder(&buf).Encode(&m))
+		size := uint64(buf.Len())
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.MetaFilename), &buf))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.IndexFilename), bytes.NewReader(make([]byte, 100))))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.ChunksDirname, "000001"), bytes.NewReader(make([]byte, 1000))))
+		sizes[id] = size + 1100
+	}
+	var (
+		a = map[string]string{"cluster": "a"}
+		b = map[string]string{"cluster": "b"}
+
+		oldRawA = ulid.MustNew(1, nil)
+		newRawA = ulid.MustNew(2, nil)
+		old5mA  = ulid.MustNew(3, nil)
+		oldRawB = ulid.MustNew(4, nil)
+		old1hB  = ulid.MustNew(5, nil)
+		partial = ulid.MustNew(6, nil)
+	)
+	upload(oldRawA, a, downsample.ResLevel0, now.Add(-15*24*time.Hour), 100)
+	upload(newRawA, a, downsample.ResLevel0, now.Add(-13*24*time.Hour), 200)
+	upload(old5mA, a, downsample.ResLevel1, now.Add(-100*24*time.Hour), 300)
+	upload(oldRawB, b, downsample.ResLevel0, now.Add(-30*24*time.Hour), 400)
+	upload(old1hB, b, downsample.ResLevel2, now.Add(-1000*24*time.Hour), 500)
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(partial.String(), block.IndexFilename), bytes.NewReader(make([]byte, 100))))
+
+	plan, err := PlanRetention(ctx, nil, bkt, map[int64]time.Duration{
+		downsample.ResLevel0: 14 * 24 * time.Hour,
+		downsample.ResLevel1: 90 * 24 * time.Hour,
+		downsample.ResLevel2: 0,
+	}, now)
+	testutil.Ok(t, err)
+
+	testutil.Equals(t, 2, len(plan.Groups))
+
+	testutil.Equals(t, a, plan.Groups[0].Labels)
+	testutil.Equals(t, 2, len(plan.Groups[0].Blocks))
+	testutil.Equals(t, oldRawA, plan.Groups[0].Blocks[0].ID)
+	testutil.Equals(t, "resolution 0s: data up to 2018-05-17T00:00:00Z is older than the retention of 336h0m0s", plan.Groups[0].Blocks[0].Reason)
+	testutil.Equals(t, sizes[oldRawA], plan.Groups[0].Blocks[0].Size)
+	testutil.Equals(t, old5mA, plan.Groups[0].Blocks[1].ID)
+	testutil.Equals(t, downsample.ResLevel1, plan.Groups[0].Blocks[1].Resolution)
+	testutil.Equals(t, uint64(400), plan.Groups[0].Samples)
+	testutil.Equals(t, sizes[oldRawA]+sizes[old5mA], plan.Groups[0].Size)
+
+	// Blocks of resolutions without retention are kept.
+	testutil.Equals(t, b, plan.Groups[1].Labels)
+	testutil.Equals(t, 1, len(plan.Groups[1].Blocks))
+	testutil.Equals(t, oldRawB, plan.Groups[1].Blocks[0].ID)
+
+	testutil.Equals(t, uint64(800), plan.Samples)
+	testutil.Equals(t, sizes[oldRawA]+sizes[old5mA]+sizes[oldRawB], plan.Size)
+}
+
+func TestSyncer_ApplySizeRetention(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	sizes := map[ulid.ULID]uint64{}
+
+	upload := func(id ulid.ULID, cluster string, res int64, maxTime time.Time) {
+		var m block.Meta
+		m.Version = 1
+		m.ULID = id
+		m.MinTime = timestamp.FromTime(maxTime.Add(-2 * time.Hour))
+		m.MaxTime = timestamp.FromTime(maxTime)
+		m.Thanos.Labels = map[string]string{"cluster": cluster}
+		m.Thanos.Downsample.Resolution = res
+
+		var buf bytes.Buffer
+		testutil.Ok(t, json.NewEncoder(&buf).Encode(&m))
+		size := uint64(buf.Len())
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.MetaFilename), &buf))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), block.ChunksDirname, "000001"), bytes.NewReader(make([]byte, 1000))))
+		sizes[id] = size + 1000
+	}
+	var (
+		oldRaw = ulid.MustNew(1, nil)
+		old5m  = ulid.MustNew(2, nil)
+		newRaw = ulid.MustNew(3, nil)
+		// The oldest block of the bucket, but of a smaller group.
+		oldRawB = ulid.MustNew(4, nil)
+	)
+	upload(old5m, "a", downsample.ResLevel1, now.Add(-10*24*time.Hour))
+	upload(oldRaw, "a", downsample.ResLevel0, now.Add(-10*24*time.Hour))
+	upload(newRaw, "a", downsample.ResLevel0, now.Add(-24*time.Hour))
+	upload(oldRawB, "b", downsample.ResLevel0, now.Add(-20*24*time.Hour))
+
+	sy, err := NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+
+	exists := func(id ulid.ULID) bool {
+		ok, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		return ok
+	}
+	total := sizes[oldRaw] + sizes[old5m] + sizes[newRaw] + sizes[oldRawB]
+
+	// Nothing is deleted within the limit of the bucket.
+	testutil.Ok(t, sy.ApplySizeRetention(ctx, total))
+	testutil.Assert(t, exists(oldRaw) && exists(old5m) && exists(newRaw) && exists(oldRawB), "block deleted within the limit")
+
+	// The largest group loses its oldest block first, raw data before downsampled data of the same time range.
+	testutil.Ok(t, sy.ApplySizeRetention(ctx, total-1))
+	testutil.Assert(t, !exists(oldRaw), "old raw block not deleted")
+	testutil.Assert(t, exists(old5m) && exists(newRaw) && exists(oldRawB), "block deleted below the limit")
+
+	// Older data of the largest group is deleted before newer data of any resolution, while the older block of the
+	// smaller group is kept.
+	testutil.Ok(t, sy.ApplySizeRetention(ctx, sizes[newRaw]+sizes[oldRawB]))
+	testutil.Assert(t, !exists(old5m), "old downsampled block not deleted")
+	testutil.Assert(t, exists(newRaw) && exists(oldRawB), "block deleted below the limit")
+
+	// All blocks are deleted until the bucket is within the limit.
+	testutil.Ok(t, sy.ApplySizeRetention(ctx, 0))
+	testutil.Assert(t, !exists(newRaw) && !exists(oldRawB), "blocks exceeding the limit not deleted")
+}
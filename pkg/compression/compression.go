@@ -0,0 +1,132 @@
+// Package compression provides the compressors of gRPC messages exchanged with StoreAPI servers.
+// Compression trades CPU for bandwidth, which pays off for stores reached over slow or expensive links.
+package compression
+
+import (
+	"io"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// Supported compressions.
+const (
+	None   = "none"
+	Snappy = "snappy"
+	Gzip   = "gzip"
+)
+
+// Names are the names of all supported compressions.
+var Names = []string{None, Snappy, Gzip}
+
+// Register registers the snappy and gzip compressors with gRPC. Servers decompress requests and compress responses
+// with the compressor the client used, so it must be called by clients and servers before any call is made.
+// The compressors count the bytes they compress in the given registry. It must only be called once.
+func Register(reg prometheus.Registerer) {
+	uncompressed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_grpc_compression_uncompressed_bytes_total",
+		Help: "Total number of bytes of sent gRPC messages before compression.",
+	}, []string{"compression"})
+	compressed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_grpc_compression_compressed_bytes_total",
+		Help: "Total number of bytes of sent gRPC messages after compression.",
+	}, []string{"compression"})
+
+	if reg != nil {
+		reg.MustRegister(uncompressed, compressed)
+	}
+
+	for _, c := range []encoding.Compressor{snappyCompressor{}, encoding.GetCompressor(gzip.Name)} {
+		encoding.RegisterCompressor(&countingCompressor{
+			Compressor:   c,
+			uncompressed: uncompressed.WithLabelValues(c.Name()),
+			compressed:   compressed.WithLabelValues(c.Name()),
+		})
+	}
+}
+
+// SplitAddr splits a store address of the form [<compression>://]<host>:<port> into the compression and the
+// address. The compression is empty if the address has none.
+func SplitAddr(addr string) (compression string, hostport string, err error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "", addr, nil
+	}
+	compression, hostport = addr[:i], addr[i+len("://"):]
+	if err := Validate(compression); err != nil {
+		return "", "", errors.Wrapf(err, "address %s", addr)
+	}
+	return compression, hostport, nil
+}
+
+// Validate returns an error if the compression is not supported.
+func Validate(compression string) error {
+	for _, n := range Names {
+		if compression == n {
+			return nil
+		}
+	}
+	return errors.Errorf("unsupported compression %q, must be one of %s", compression, strings.Join(Names, ", "))
+}
+
+// DialOption returns a dial option that makes all calls of a connection use the given compression.
+func DialOption(compression string) grpc.DialOption {
+	if compression == None {
+		compression = encoding.Identity
+	}
+	return grpc.WithDefaultCallOptions(grpc.UseCompressor(compression))
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string { return Snappy }
+
+func (snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
+
+// countingCompressor counts the bytes written to and by a compressor.
+type countingCompressor struct {
+	encoding.Compressor
+
+	uncompressed, compressed prometheus.Counter
+}
+
+func (c *countingCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	wc, err := c.Compressor.Compress(&countingWriter{Writer: w, c: c.compressed})
+	if err != nil {
+		return nil, err
+	}
+	return &countingWriteCloser{WriteCloser: wc, c: c.uncompressed}, nil
+}
+
+type countingWriter struct {
+	io.Writer
+	c prometheus.Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.c.Add(float64(n))
+	return n, err
+}
+
+type countingWriteCloser struct {
+	io.WriteCloser
+	c prometheus.Counter
+}
+
+func (w *countingWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.c.Add(float64(n))
+	return n, err
+}
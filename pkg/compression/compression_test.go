@@ -0,0 +1,49 @@
+package compression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestSnappyCompressor(t *testing.T) {
+	msg := bytes.Repeat([]byte("chunk data "), 1000)
+
+	var buf bytes.Buffer
+	w, err := snappyCompressor{}.Compress(&buf)
+	testutil.Ok(t, err)
+	_, err = w.Write(msg)
+	testutil.Ok(t, err)
+	testutil.Ok(t, w.Close())
+	testutil.Assert(t, buf.Len() < len(msg), "message not compressed, %d bytes", buf.Len())
+
+	r, err := snappyCompressor{}.Decompress(&buf)
+	testutil.Ok(t, err)
+	b, err := ioutil.ReadAll(r)
+	testutil.Ok(t, err)
+	testutil.Equals(t, msg, b)
+}
+
+func TestSplitAddr(t *testing.T) {
+	for _, tcase := range []struct {
+		addr, compression, hostport string
+		err                         bool
+	}{
+		{addr: "localhost:10901", hostport: "localhost:10901"},
+		{addr: "gzip://store.eu-west:10901", compression: Gzip, hostport: "store.eu-west:10901"},
+		{addr: "snappy://1.2.3.4:10901", compression: Snappy, hostport: "1.2.3.4:10901"},
+		{addr: "none://localhost:10901", compression: None, hostport: "localhost:10901"},
+		{addr: "zstd://localhost:10901", err: true},
+	} {
+		c, hostport, err := SplitAddr(tcase.addr)
+		if tcase.err {
+			testutil.NotOk(t, err)
+			continue
+		}
+		testutil.Ok(t, err)
+		testutil.Equals(t, tcase.compression, c)
+		testutil.Equals(t, tcase.hostport, hostport)
+	}
+}
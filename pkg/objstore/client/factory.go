@@ -2,6 +2,7 @@ package client
 
 import (
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	//"github.com/improbable-eng/thanos/pkg/objstore/gcs"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/pkg/errors"
@@ -10,10 +11,18 @@ import (
 	//"google.golang.org/api/option"
 )
 
-var ErrNotFound = errors.New("no valid GCS or S3 configuration supplied")
+var ErrNotFound = errors.New("no valid GCS, S3 or filesystem configuration supplied")
 
-// NewBucket initializes and returns new object storage clients.
-func NewBucket(gcsBucket *string, s3Config s3.Config, reg *prometheus.Registry, component string) (objstore.Bucket, error) {
+// NewBucket initializes and returns new object storage clients. The prefix configured for the backend is
+// applied to all of them.
+func NewBucket(gcsBucket *string, s3Config s3.Config, fsConfig filesystem.Config, reg *prometheus.Registry, component string) (objstore.Bucket, error) {
+	if fsConfig.Validate() == nil {
+		b, err := filesystem.NewBucket(fsConfig.Directory)
+		if err != nil {
+			return nil, errors.Wrap(err, "create filesystem bucket")
+		}
+		return objstore.NewPrefixedBucket(objstore.BucketWithMetrics(fsConfig.Directory, b, reg), fsConfig.Prefix), nil
+	}
 
 	//if *gcsBucket != "" {
 	//	gcsOptions := option.WithUserAgent(fmt.Sprintf("thanos-%s/%s (%s)", component, version.Version, runtime.Version()))
@@ -29,7 +38,7 @@ func NewBucket(gcsBucket *string, s3Config s3.Config, reg *prometheus.Registry,
 		if err != nil {
 			return nil, errors.Wrap(err, "create s3 client")
 		}
-		return objstore.BucketWithMetrics(s3Config.Bucket, b, reg), nil
+		return objstore.NewPrefixedBucket(objstore.BucketWithMetrics(s3Config.Bucket, b, reg), s3Config.Prefix), nil
 	}
 
 	return nil, ErrNotFound
@@ -0,0 +1,220 @@
+// Package filesystem implements common object storage abstractions against a local directory.
+package filesystem
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Config encapsulates the necessary config values to instantiate a filesystem bucket.
+type Config struct {
+	Directory string
+	// Prefix under which all objects are stored, so multiple setups can share a directory.
+	Prefix string
+}
+
+// RegisterFilesystemParams registers the filesystem flags and returns an initialized Config struct.
+func RegisterFilesystemParams(cmd *kingpin.CmdClause) *Config {
+	var c Config
+
+	cmd.Flag("filesystem.dir", "Local directory to use as object storage for blocks instead of a bucket, e.g. for tests, air-gapped or NFS-backed setups. Takes precedence over the bucket flags.").
+		PlaceHolder("<dir>").Envar("FILESYSTEM_DIR").StringVar(&c.Directory)
+	cmd.Flag("filesystem.prefix", "Prefix (directory) in the filesystem directory under which all objects are read and written. Allows multiple setups to share one directory.").
+		PlaceHolder("<prefix>").Envar("FILESYSTEM_PREFIX").StringVar(&c.Prefix)
+
+	return &c
+}
+
+// Validate checks to see if mandatory filesystem config options are set.
+func (conf *Config) Validate() error {
+	if conf.Directory == "" {
+		return errors.New("no filesystem directory specified")
+	}
+	return nil
+}
+
+// Bucket implements the store.Bucket interface against a local directory. Objects are regular files
+// and directories are created and removed as needed.
+type Bucket struct {
+	rootDir string
+}
+
+// NewBucket returns a new filesystem Bucket rooted at the given directory, which is created if it does not exist.
+func NewBucket(rootDir string) (*Bucket, error) {
+	absDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve directory %s", rootDir)
+	}
+	if err := os.MkdirAll(absDir, 0777); err != nil {
+		return nil, errors.Wrapf(err, "create directory %s", absDir)
+	}
+	return &Bucket{rootDir: absDir}, nil
+}
+
+func (b *Bucket) path(name string) string {
+	return filepath.Join(b.rootDir, filepath.FromSlash(name))
+}
+
+// Iter calls f for each entry in the given directory. The argument to f is the full
+// object name including the prefix of the inspected directory.
+func (b *Bucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" {
+		dir = strings.TrimSuffix(dir, objstore.DirDelim) + objstore.DirDelim
+	}
+	infos, err := ioutil.ReadDir(b.path(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read directory %s", dir)
+	}
+	// Like other implementations, list objects before directories.
+	var objects, dirs []string
+	for _, fi := range infos {
+		if fi.IsDir() {
+			dirs = append(dirs, dir+fi.Name()+objstore.DirDelim)
+			continue
+		}
+		// Skip objects that are being uploaded.
+		if strings.Contains(fi.Name(), tmpInfix) {
+			continue
+		}
+		objects = append(objects, dir+fi.Name())
+	}
+	sort.Strings(objects)
+	sort.Strings(dirs)
+
+	for _, name := range append(objects, dirs...) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
+// Get returns a reader for the given object name.
+func (b *Bucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.GetRange(ctx, name, 0, -1)
+}
+
+// GetRange returns a new range reader for the given object name and range. A length of -1 reads until the end of the object.
+func (b *Bucket) GetRange(_ context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, errors.New("object name is empty")
+	}
+	f, err := os.Open(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		if err == nil {
+			err = errors.Errorf("object %s is a directory", name)
+		}
+		return nil, err
+	}
+	if off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "seek %d in %s", off, name)
+		}
+	}
+	if length == -1 {
+		return f, nil
+	}
+	return rangeReader{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// Exists checks if the given object exists.
+func (b *Bucket) Exists(_ context.Context, name string) (bool, error) {
+	fi, err := os.Stat(b.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "stat %s", name)
+	}
+	return !fi.IsDir(), nil
+}
+
+// ObjectSize returns the size of the given object in bytes.
+func (b *Bucket) ObjectSize(_ context.Context, name string) (uint64, error) {
+	fi, err := os.Stat(b.path(name))
+	if err != nil {
+		// Not wrapped, so the error can still be checked with IsObjNotFoundErr.
+		return 0, err
+	}
+	return uint64(fi.Size()), nil
+}
+
+// tmpInfix is part of the names of temporary files of objects that are being uploaded.
+const tmpInfix = ".tmp-upload-"
+
+// Upload the contents of the reader as an object into the bucket. The object is written to a temporary
+// file first and renamed once complete, so concurrent readers never see partially written objects.
+func (b *Bucket) Upload(_ context.Context, name string, r io.Reader) (err error) {
+	fn := b.path(name)
+	if err := os.MkdirAll(filepath.Dir(fn), 0777); err != nil {
+		return errors.Wrapf(err, "create directory for %s", name)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(fn), filepath.Base(fn)+tmpInfix)
+	if err != nil {
+		return errors.Wrapf(err, "create temporary file for %s", name)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "write %s", name)
+	}
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "sync %s", name)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", name)
+	}
+	return errors.Wrapf(os.Rename(f.Name(), fn), "rename %s", name)
+}
+
+// Delete removes the object with the given name. Directories left empty are removed as well.
+func (b *Bucket) Delete(_ context.Context, name string) error {
+	fn := b.path(name)
+	if err := os.Remove(fn); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(fn); dir != b.rootDir && strings.HasPrefix(dir, b.rootDir); dir = filepath.Dir(dir) {
+		// Fails for directories that are not empty, which is fine.
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
+func (b *Bucket) IsObjNotFoundErr(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
+
+func (b *Bucket) Close() error { return nil }
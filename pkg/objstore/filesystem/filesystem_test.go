@@ -0,0 +1,75 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestBucket_ConcurrentUploadAndGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesystem-bucket")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt, err := NewBucket(dir)
+	testutil.Ok(t, err)
+
+	ctx := context.Background()
+	a, b := bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<20)
+	testutil.Ok(t, bkt.Upload(ctx, "dir/obj", bytes.NewReader(a)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+
+			content := a
+			if i%2 == 1 {
+				content = b
+			}
+			testutil.Ok(t, bkt.Upload(ctx, "dir/obj", bytes.NewReader(content)))
+		}(i)
+		go func() {
+			defer wg.Done()
+
+			// Readers must always see a complete object.
+			rc, err := bkt.Get(ctx, "dir/obj")
+			testutil.Ok(t, err)
+			defer rc.Close()
+
+			content, err := ioutil.ReadAll(rc)
+			testutil.Ok(t, err)
+			testutil.Assert(t, bytes.Equal(a, content) || bytes.Equal(b, content), "partially written object read")
+		}()
+	}
+	wg.Wait()
+
+	var seen []string
+	testutil.Ok(t, bkt.Iter(ctx, "dir", func(name string) error {
+		seen = append(seen, name)
+		return nil
+	}))
+	testutil.Equals(t, []string{"dir/obj"}, seen)
+
+	// Ranges exceeding the object are cut off at its end.
+	rc, err := bkt.GetRange(ctx, "dir/obj", 1<<20-2, 10)
+	testutil.Ok(t, err)
+	content, err := ioutil.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, 2, len(content))
+
+	// Deleting the last object removes its directory.
+	testutil.Ok(t, bkt.Delete(ctx, "dir/obj"))
+	_, err = os.Stat(filepath.Join(dir, "dir"))
+	testutil.Assert(t, os.IsNotExist(err), "expected directory to be removed, got %v", err)
+	_, err = os.Stat(dir)
+	testutil.Ok(t, err)
+}
@@ -120,6 +120,17 @@ package gcs
 //	return false, nil
 //}
 //
+//// ObjectSize returns the size of the given object in bytes.
+//func (b *Bucket) ObjectSize(ctx context.Context, name string) (uint64, error) {
+//	b.opsTotal.WithLabelValues(opObjectGet).Inc()
+//
+//	attrs, err := b.bkt.Object(name).Attrs(ctx)
+//	if err != nil {
+//		return 0, err
+//	}
+//	return uint64(attrs.Size), nil
+//}
+//
 //// Upload writes the file specified in src to remote GCS location specified as target.
 //func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
 //	b.opsTotal.WithLabelValues(opObjectInsert).Inc()
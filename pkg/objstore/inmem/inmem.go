@@ -123,6 +123,15 @@ func (b *Bucket) Exists(_ context.Context, name string) (bool, error) {
 	return ok, nil
 }
 
+// ObjectSize returns the size of the given object in bytes.
+func (b *Bucket) ObjectSize(_ context.Context, name string) (uint64, error) {
+	file, ok := b.objects[name]
+	if !ok {
+		return 0, errNotFound
+	}
+	return uint64(len(file)), nil
+}
+
 // Upload writes the file specified in src to into the memory.
 func (b *Bucket) Upload(_ context.Context, name string, r io.Reader) error {
 	body, err := ioutil.ReadAll(r)
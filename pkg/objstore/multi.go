@@ -0,0 +1,165 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MultiBucketReader reads from multiple buckets as if their objects were stored in a single bucket.
+// Top-level directories, like the directories of blocks, are expected to be unique across the buckets.
+// If a directory exists in multiple buckets, the one of the first bucket is used.
+type MultiBucketReader struct {
+	names []string
+	bkts  []BucketReader
+
+	mtx sync.RWMutex
+	// Index of the bucket of each top-level directory, learned while iterating the root.
+	origins map[string]int
+
+	dirs *prometheus.GaugeVec
+}
+
+// NewMultiBucketReader returns a reader for the given buckets. The names identify the buckets in metrics.
+func NewMultiBucketReader(reg prometheus.Registerer, names []string, bkts []BucketReader) *MultiBucketReader {
+	b := &MultiBucketReader{
+		names:   names,
+		bkts:    bkts,
+		origins: map[string]int{},
+		dirs: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "thanos_objstore_multi_bucket_directories",
+			Help: "Number of top-level directories, e.g. blocks, served from each bucket as of the last iteration.",
+		}, []string{"bucket"}),
+	}
+	if reg != nil {
+		reg.MustRegister(b.dirs)
+	}
+	return b
+}
+
+func topLevelDir(name string) string {
+	return strings.SplitN(name, DirDelim, 2)[0]
+}
+
+// Origin returns the name of the bucket the object with the given name is read from.
+// It is only known for objects in directories that were seen by iterating the root.
+func (b *MultiBucketReader) Origin(name string) (string, bool) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	i, ok := b.origins[topLevelDir(name)]
+	if !ok {
+		return "", false
+	}
+	return b.names[i], true
+}
+
+// buckets returns the buckets that may hold the object with the given name. If its origin is
+// unknown, all buckets are returned in order.
+func (b *MultiBucketReader) buckets(name string) []BucketReader {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	if i, ok := b.origins[topLevelDir(name)]; ok {
+		return b.bkts[i : i+1]
+	}
+	return b.bkts
+}
+
+// Iter calls f for each entry in the given directory. Iterating the root lists the directories of all buckets.
+func (b *MultiBucketReader) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" {
+		for _, bkt := range b.buckets(dir) {
+			found := false
+			if err := bkt.Iter(ctx, dir, func(name string) error {
+				found = true
+				return f(name)
+			}); err != nil {
+				return err
+			}
+			if found {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	origins := map[string]int{}
+	counts := make([]int, len(b.bkts))
+
+	for i, bkt := range b.bkts {
+		if err := bkt.Iter(ctx, "", func(name string) error {
+			d := topLevelDir(name)
+			if _, ok := origins[d]; ok {
+				return nil
+			}
+			origins[d] = i
+			counts[i]++
+			return f(name)
+		}); err != nil {
+			return err
+		}
+	}
+	b.mtx.Lock()
+	b.origins = origins
+	b.mtx.Unlock()
+
+	for i, n := range b.names {
+		b.dirs.WithLabelValues(n).Set(float64(counts[i]))
+	}
+	return nil
+}
+
+// Get returns a reader for the given object name.
+func (b *MultiBucketReader) Get(ctx context.Context, name string) (rc io.ReadCloser, err error) {
+	for _, bkt := range b.buckets(name) {
+		if rc, err = bkt.Get(ctx, name); err == nil || !bkt.IsObjNotFoundErr(err) {
+			return rc, err
+		}
+	}
+	return nil, err
+}
+
+// GetRange returns a new range reader for the given object name and range.
+func (b *MultiBucketReader) GetRange(ctx context.Context, name string, off, length int64) (rc io.ReadCloser, err error) {
+	for _, bkt := range b.buckets(name) {
+		if rc, err = bkt.GetRange(ctx, name, off, length); err == nil || !bkt.IsObjNotFoundErr(err) {
+			return rc, err
+		}
+	}
+	return nil, err
+}
+
+// Exists checks if the given object exists in any of the buckets.
+func (b *MultiBucketReader) Exists(ctx context.Context, name string) (bool, error) {
+	for _, bkt := range b.buckets(name) {
+		ok, err := bkt.Exists(ctx, name)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// ObjectSize returns the size of the given object in bytes.
+func (b *MultiBucketReader) ObjectSize(ctx context.Context, name string) (size uint64, err error) {
+	for _, bkt := range b.buckets(name) {
+		if size, err = bkt.ObjectSize(ctx, name); err == nil || !bkt.IsObjNotFoundErr(err) {
+			return size, err
+		}
+	}
+	return 0, err
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found in any of the buckets.
+func (b *MultiBucketReader) IsObjNotFoundErr(err error) bool {
+	for _, bkt := range b.bkts {
+		if bkt.IsObjNotFoundErr(err) {
+			return true
+		}
+	}
+	return false
+}
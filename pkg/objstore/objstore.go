@@ -6,11 +6,13 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 )
 
 // Bucket provides read and write access to an object storage bucket.
@@ -42,6 +44,10 @@ type BucketReader interface {
 	// TODO(bplotka): Consider removing Exists in favor of helper that do Get & IsObjNotFoundErr (less code to maintain).
 	Exists(ctx context.Context, name string) (bool, error)
 
+	// ObjectSize returns the size of the given object in bytes. If the object does not exist,
+	// the returned error satisfies IsObjNotFoundErr.
+	ObjectSize(ctx context.Context, name string) (uint64, error)
+
 	// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
 	IsObjNotFoundErr(err error) bool
 }
@@ -101,23 +107,30 @@ func DeleteDir(ctx context.Context, bkt Bucket, dir string) error {
 // DownloadFile downloads the src file from the bucket to dst. If dst is an existing
 // directory, a file with the same name as the source is created in dst.
 func DownloadFile(ctx context.Context, bkt BucketReader, src, dst string) error {
+	_, err := downloadFile(ctx, bkt, src, dst, nil)
+	return err
+}
+
+// downloadFile downloads the src file to dst through the given buffer and returns the number of written bytes.
+// A nil buffer uses the default buffer of io.Copy.
+func downloadFile(ctx context.Context, bkt BucketReader, src, dst string, buf []byte) (n int64, err error) {
 	if fi, err := os.Stat(dst); err == nil {
 		if fi.IsDir() {
 			dst = filepath.Join(dst, filepath.Base(src))
 		}
 	} else if !os.IsNotExist(err) {
-		return err
+		return 0, err
 	}
 
 	rc, err := bkt.Get(ctx, src)
 	if err != nil {
-		return errors.Wrap(err, "get file")
+		return 0, errors.Wrap(err, "get file")
 	}
 	defer rc.Close()
 
 	f, err := os.Create(dst)
 	if err != nil {
-		return errors.Wrap(err, "create file")
+		return 0, errors.Wrap(err, "create file")
 	}
 	defer func() {
 		f.Close()
@@ -126,28 +139,87 @@ func DownloadFile(ctx context.Context, bkt BucketReader, src, dst string) error
 			os.Remove(dst)
 		}
 	}()
-	if _, err = io.Copy(f, rc); err != nil {
-		return errors.Wrap(err, "copy object to file")
+	if n, err = io.CopyBuffer(f, rc, buf); err != nil {
+		return n, errors.Wrap(err, "copy object to file")
 	}
-	return nil
+	return n, nil
 }
 
 // DownloadDir downloads all object found in the directory into the local directory.
 func DownloadDir(ctx context.Context, bkt BucketReader, src, dst string) error {
-	if err := os.MkdirAll(dst, 0777); err != nil {
-		return errors.Wrap(err, "create dir")
+	_, err := DownloadDirConcurrently(ctx, bkt, src, dst, 1, 0)
+	return err
+}
+
+// DownloadDirConcurrently downloads all objects found in the directory into the local directory and returns
+// the number of downloaded bytes. Up to concurrency objects are downloaded in parallel, each through a buffer
+// of bufferSize bytes. A bufferSize of 0 uses the default buffer size. The local directory is removed if the
+// download fails, so that no partial download is left behind for a retry.
+func DownloadDirConcurrently(ctx context.Context, bkt BucketReader, src, dst string, concurrency, bufferSize int) (n int64, err error) {
+	if concurrency < 1 {
+		concurrency = 1
 	}
-	err := bkt.Iter(ctx, src, func(name string) error {
-		if strings.HasSuffix(name, DirDelim) {
-			return DownloadDir(ctx, bkt, name, filepath.Join(dst, filepath.Base(name)))
+	defer func() {
+		// Best-effort cleanup if the download failed.
+		if err != nil {
+			os.RemoveAll(dst)
+		}
+	}()
+
+	// List all objects first, so that the objects of nested directories are downloaded in parallel as well.
+	files := map[string]string{}
+	var listDir func(src, dst string) error
+	listDir = func(src, dst string) error {
+		if err := os.MkdirAll(dst, 0777); err != nil {
+			return errors.Wrap(err, "create dir")
+		}
+		return bkt.Iter(ctx, src, func(name string) error {
+			if strings.HasSuffix(name, DirDelim) {
+				return listDir(name, filepath.Join(dst, filepath.Base(name)))
+			}
+			files[name] = filepath.Join(dst, filepath.Base(name))
+			return nil
+		})
+	}
+	if err := listDir(src, dst); err != nil {
+		return 0, err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	srcCh := make(chan string)
+
+	for i := 0; i < concurrency; i++ {
+		var buf []byte
+		if bufferSize > 0 {
+			buf = make([]byte, bufferSize)
 		}
-		return DownloadFile(ctx, bkt, name, dst)
+		g.Go(func() error {
+			for name := range srcCh {
+				written, err := downloadFile(gctx, bkt, name, files[name], buf)
+				if err != nil {
+					return errors.Wrapf(err, "download %s", name)
+				}
+				atomic.AddInt64(&n, written)
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		defer close(srcCh)
+
+		for name := range files {
+			select {
+			case srcCh <- name:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
+		return nil
 	})
-	// Best-effort cleanup if the download failed.
-	if err != nil {
-		os.RemoveAll(dst)
+	if err := g.Wait(); err != nil {
+		return 0, err
 	}
-	return err
+	return n, nil
 }
 
 // BucketWithMetrics takes a bucket and registers metrics with the given registry for
@@ -250,6 +322,20 @@ func (b *metricBucket) Exists(ctx context.Context, name string) (bool, error) {
 	return ok, err
 }
 
+func (b *metricBucket) ObjectSize(ctx context.Context, name string) (uint64, error) {
+	const op = "objectsize"
+	start := time.Now()
+
+	size, err := b.bkt.ObjectSize(ctx, name)
+	if err != nil && !b.bkt.IsObjNotFoundErr(err) {
+		b.opsFailures.WithLabelValues(op).Inc()
+	}
+	b.ops.WithLabelValues(op).Inc()
+	b.opsDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+
+	return size, err
+}
+
 func (b *metricBucket) Upload(ctx context.Context, name string, r io.Reader) error {
 	const op = "upload"
 	start := time.Now()
@@ -28,6 +28,10 @@ func TestObjStore_AcceptanceTest_e2e(t *testing.T) {
 		testutil.Ok(t, err)
 		testutil.Assert(t, !ok, "expected not exits")
 
+		_, err = bkt.ObjectSize(context.Background(), "id1/obj_1.some")
+		testutil.NotOk(t, err)
+		testutil.Assert(t, bkt.IsObjNotFoundErr(err), "expected not found error got %s", err)
+
 		// Upload first object.
 		testutil.Ok(t, bkt.Upload(context.Background(), "id1/obj_1.some", strings.NewReader("@test-data@")))
 
@@ -50,6 +54,10 @@ func TestObjStore_AcceptanceTest_e2e(t *testing.T) {
 		testutil.Ok(t, err)
 		testutil.Assert(t, ok, "expected exits")
 
+		size, err := bkt.ObjectSize(context.Background(), "id1/obj_1.some")
+		testutil.Ok(t, err)
+		testutil.Equals(t, uint64(len("@test-data@")), size)
+
 		// Upload other objects.
 		testutil.Ok(t, bkt.Upload(context.Background(), "id1/obj_2.some", strings.NewReader("@test-data2@")))
 		testutil.Ok(t, bkt.Upload(context.Background(), "id1/obj_3.some", strings.NewReader("@test-data3@")))
@@ -0,0 +1,70 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestDownloadDirConcurrently(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+
+	var size int64
+	for i := 0; i < 10; i++ {
+		content := strings.Repeat(fmt.Sprintf("%d", i), 100*(i+1))
+		size += int64(len(content))
+		testutil.Ok(t, bkt.Upload(ctx, fmt.Sprintf("dir/chunks/%06d", i), bytes.NewBufferString(content)))
+	}
+	testutil.Ok(t, bkt.Upload(ctx, "dir/meta.json", bytes.NewBufferString("{}")))
+	size += 2
+
+	tmpDir, err := ioutil.TempDir("", "download-dir")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	dst := filepath.Join(tmpDir, "dir")
+	n, err := objstore.DownloadDirConcurrently(ctx, bkt, "dir", dst, 4, 16)
+	testutil.Ok(t, err)
+	testutil.Equals(t, size, n)
+
+	b, err := ioutil.ReadFile(filepath.Join(dst, "meta.json"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, "{}", string(b))
+
+	b, err = ioutil.ReadFile(filepath.Join(dst, "chunks", "000009"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, strings.Repeat("9", 1000), string(b))
+
+	// A failed download leaves nothing behind.
+	failDst := filepath.Join(tmpDir, "failed")
+	_, err = objstore.DownloadDirConcurrently(ctx, &failingBucket{Bucket: bkt, fail: "dir/chunks/000005"}, "dir", failDst, 4, 0)
+	testutil.NotOk(t, err)
+
+	_, err = os.Stat(failDst)
+	testutil.Assert(t, os.IsNotExist(err), "partial download was not removed")
+}
+
+// failingBucket fails to get a single object.
+type failingBucket struct {
+	objstore.Bucket
+	fail string
+}
+
+func (b *failingBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if name == b.fail {
+		return nil, errors.New("injected failure")
+	}
+	return b.Bucket.Get(ctx, name)
+}
@@ -1,12 +1,14 @@
 package objtesting
 
 import (
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
 	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/filesystem"
 	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
 	"github.com/improbable-eng/thanos/pkg/objstore/s3"
 	"github.com/improbable-eng/thanos/pkg/testutil"
@@ -27,6 +29,22 @@ func ForeachStore(t *testing.T, testFn func(t testing.TB, bkt objstore.Bucket))
 		return
 	}
 
+	// Mandatory filesystem.
+	if ok := t.Run("filesystem", func(t *testing.T) {
+		defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+		dir, err := ioutil.TempDir("", "filesystem-bucket")
+		testutil.Ok(t, err)
+		defer os.RemoveAll(dir)
+
+		bkt, err := filesystem.NewBucket(dir)
+		testutil.Ok(t, err)
+
+		testFn(t, bkt)
+	}); !ok {
+		return
+	}
+
 	// Optional S3 AWS.
 	// TODO(bplotka): Prepare environment & CI to run it automatically.
 	// TODO(bplotka): Find a user with S3 AWS project ready to run this test.
@@ -0,0 +1,84 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+)
+
+func TestMultiBucketReader(t *testing.T) {
+	ctx := context.Background()
+	bkt1, bkt2 := inmem.NewBucket(), inmem.NewBucket()
+
+	id1, id2, id3 := ulid.MustNew(1, nil), ulid.MustNew(2, nil), ulid.MustNew(3, nil)
+	testutil.Ok(t, bkt1.Upload(ctx, path.Join(id1.String(), block.MetaFilename), bytes.NewBufferString("meta1")))
+	testutil.Ok(t, bkt2.Upload(ctx, path.Join(id2.String(), block.MetaFilename), bytes.NewBufferString("meta2")))
+	// Duplicated block is served from the first bucket.
+	testutil.Ok(t, bkt1.Upload(ctx, path.Join(id3.String(), block.MetaFilename), bytes.NewBufferString("meta3-1")))
+	testutil.Ok(t, bkt2.Upload(ctx, path.Join(id3.String(), block.MetaFilename), bytes.NewBufferString("meta3-2")))
+
+	bkt := objstore.NewMultiBucketReader(nil, []string{"bkt1", "bkt2"}, []objstore.BucketReader{bkt1, bkt2})
+
+	var ids []ulid.ULID
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		id, ok := block.IsBlockDir(name)
+		testutil.Assert(t, ok, "unexpected object %s", name)
+		ids = append(ids, id)
+		return nil
+	}))
+	testutil.Equals(t, []ulid.ULID{id1, id3, id2}, ids)
+
+	for _, tc := range []struct {
+		id      ulid.ULID
+		origin  string
+		content string
+	}{
+		{id: id1, origin: "bkt1", content: "meta1"},
+		{id: id2, origin: "bkt2", content: "meta2"},
+		{id: id3, origin: "bkt1", content: "meta3-1"},
+	} {
+		origin, ok := bkt.Origin(tc.id.String())
+		testutil.Assert(t, ok, "origin of %s unknown", tc.id)
+		testutil.Equals(t, tc.origin, origin)
+
+		var names []string
+		testutil.Ok(t, bkt.Iter(ctx, tc.id.String(), func(name string) error {
+			names = append(names, name)
+			return nil
+		}))
+		testutil.Equals(t, []string{path.Join(tc.id.String(), block.MetaFilename)}, names)
+
+		rc, err := bkt.Get(ctx, path.Join(tc.id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, tc.content, string(b))
+
+		rc, err = bkt.GetRange(ctx, path.Join(tc.id.String(), block.MetaFilename), 0, 4)
+		testutil.Ok(t, err)
+		b, err = ioutil.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, "meta", string(b))
+
+		ok, err = bkt.Exists(ctx, path.Join(tc.id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "block %s not found", tc.id)
+	}
+
+	ok, err := bkt.Exists(ctx, path.Join(ulid.MustNew(4, nil).String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "unknown block found")
+
+	_, err = bkt.Get(ctx, path.Join(ulid.MustNew(4, nil).String(), block.MetaFilename))
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err), "expected not found error got %s", err)
+}
@@ -0,0 +1,81 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+)
+
+func TestOverlayBucket(t *testing.T) {
+	ctx := context.Background()
+	source, output := inmem.NewBucket(), inmem.NewBucket()
+
+	id1, id2 := ulid.MustNew(1, nil), ulid.MustNew(2, nil)
+	testutil.Ok(t, source.Upload(ctx, path.Join(id1.String(), block.MetaFilename), bytes.NewBufferString("meta1")))
+	testutil.Ok(t, source.Upload(ctx, path.Join(id1.String(), "index"), bytes.NewBufferString("index1")))
+
+	bkt, err := objstore.NewOverlayBucket(ctx, source, output)
+	testutil.Ok(t, err)
+
+	// Writes only go to the output bucket, but are visible through the overlay.
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id2.String(), block.MetaFilename), bytes.NewBufferString("meta2")))
+	testutil.Equals(t, []byte("meta2"), output.Objects()[path.Join(id2.String(), block.MetaFilename)])
+	testutil.Equals(t, 2, len(source.Objects()))
+
+	iterIDs := func() (ids []ulid.ULID) {
+		testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+			id, ok := block.IsBlockDir(name)
+			testutil.Assert(t, ok, "unexpected object %s", name)
+			ids = append(ids, id)
+			return nil
+		}))
+		return ids
+	}
+	testutil.Equals(t, []ulid.ULID{id2, id1}, iterIDs())
+
+	for id, content := range map[ulid.ULID]string{id1: "meta1", id2: "meta2"} {
+		rc, err := bkt.Get(ctx, path.Join(id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, content, string(b))
+	}
+
+	// Deleting a block of the source bucket only hides it.
+	testutil.Ok(t, block.Delete(ctx, bkt, id1))
+	testutil.Equals(t, 2, len(source.Objects()))
+	testutil.Equals(t, []ulid.ULID{id2}, iterIDs())
+
+	ok, err := bkt.Exists(ctx, path.Join(id1.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, !ok, "deleted object exists")
+
+	_, err = bkt.Get(ctx, path.Join(id1.String(), block.MetaFilename))
+	testutil.Assert(t, bkt.IsObjNotFoundErr(err), "expected not found error got %s", err)
+
+	// Deleting a block of the output bucket removes it.
+	testutil.Ok(t, block.Delete(ctx, bkt, id2))
+	testutil.Equals(t, 0, len(iterIDs()))
+
+	// Only the tombstones of the source objects are left in the output bucket.
+	testutil.Equals(t, 2, len(output.Objects()))
+
+	// Deletions are kept by a new overlay of the same buckets.
+	bkt, err = objstore.NewOverlayBucket(ctx, source, output)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(iterIDs()))
+
+	// Uploading a deleted object again removes its tombstone.
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id1.String(), block.MetaFilename), bytes.NewBufferString("meta1")))
+	testutil.Equals(t, 2, len(output.Objects()))
+	testutil.Equals(t, []ulid.ULID{id1}, iterIDs())
+}
@@ -0,0 +1,73 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
+)
+
+func TestPrefixedBucket(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+
+	bkt1 := objstore.NewPrefixedBucket(bkt, "team1")
+	bkt2 := objstore.NewPrefixedBucket(bkt, "/team2/")
+
+	id1, id2 := ulid.MustNew(1, nil), ulid.MustNew(2, nil)
+	testutil.Ok(t, bkt1.Upload(ctx, path.Join(id1.String(), block.MetaFilename), bytes.NewBufferString("meta1")))
+	testutil.Ok(t, bkt2.Upload(ctx, path.Join(id2.String(), block.MetaFilename), bytes.NewBufferString("meta2")))
+
+	testutil.Equals(t, []byte("meta1"), bkt.Objects()["team1/"+id1.String()+"/meta.json"])
+	testutil.Equals(t, []byte("meta2"), bkt.Objects()["team2/"+id2.String()+"/meta.json"])
+
+	for _, tc := range []struct {
+		bkt       objstore.Bucket
+		id, other ulid.ULID
+		content   string
+	}{
+		{bkt: bkt1, id: id1, other: id2, content: "meta1"},
+		{bkt: bkt2, id: id2, other: id1, content: "meta2"},
+	} {
+		// Block discovery must only see the blocks under the bucket's own prefix.
+		var ids []ulid.ULID
+		testutil.Ok(t, tc.bkt.Iter(ctx, "", func(name string) error {
+			id, ok := block.IsBlockDir(name)
+			testutil.Assert(t, ok, "unexpected object %s", name)
+			ids = append(ids, id)
+			return nil
+		}))
+		testutil.Equals(t, []ulid.ULID{tc.id}, ids)
+
+		var names []string
+		testutil.Ok(t, tc.bkt.Iter(ctx, tc.id.String(), func(name string) error {
+			names = append(names, name)
+			return nil
+		}))
+		testutil.Equals(t, []string{path.Join(tc.id.String(), block.MetaFilename)}, names)
+
+		rc, err := tc.bkt.Get(ctx, path.Join(tc.id.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		b, err := ioutil.ReadAll(rc)
+		testutil.Ok(t, err)
+		testutil.Ok(t, rc.Close())
+		testutil.Equals(t, tc.content, string(b))
+
+		ok, err := tc.bkt.Exists(ctx, path.Join(tc.other.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok, "block %s of other prefix visible", tc.other)
+
+		_, err = tc.bkt.Get(ctx, path.Join(tc.other.String(), block.MetaFilename))
+		testutil.Assert(t, tc.bkt.IsObjNotFoundErr(err), "expected not found error got %s", err)
+	}
+
+	// An empty prefix does not wrap the bucket.
+	testutil.Equals(t, objstore.Bucket(bkt), objstore.NewPrefixedBucket(bkt, ""))
+}
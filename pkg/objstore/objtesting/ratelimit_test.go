@@ -0,0 +1,49 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+
+	// Without limits, the bucket is not wrapped.
+	testutil.Equals(t, objstore.Bucket(bkt), objstore.NewRateLimiter(nil, objstore.RateLimits{}).Bucket(bkt))
+
+	l := objstore.NewRateLimiter(nil, objstore.RateLimits{Upload: 4096, Download: 4096})
+	b := l.Bucket(bkt)
+
+	// The first second worth of bytes is allowed as a burst, the second one has to wait.
+	content := bytes.Repeat([]byte("a"), 2*4096)
+	begin := time.Now()
+	testutil.Ok(t, b.Upload(ctx, "obj", bytes.NewReader(content)))
+	testutil.Assert(t, time.Since(begin) >= 900*time.Millisecond, "upload not throttled, took %s", time.Since(begin))
+
+	// Uploads and downloads are limited independently.
+	begin = time.Now()
+	rc, err := b.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	data, err := ioutil.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, content, data)
+	testutil.Assert(t, time.Since(begin) >= 900*time.Millisecond, "download not throttled, took %s", time.Since(begin))
+
+	// Canceled transfers stop waiting.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	rc, err = b.GetRange(cctx, "obj", 0, 4096)
+	testutil.Ok(t, err)
+	_, err = ioutil.ReadAll(rc)
+	testutil.Equals(t, context.Canceled, err)
+	testutil.Ok(t, rc.Close())
+}
@@ -0,0 +1,38 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+)
+
+func TestReadOnlyBucket(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewBufferString("content")))
+
+	ro := objstore.NewReadOnlyBucket(bkt)
+
+	rc, err := ro.Get(ctx, "obj")
+	testutil.Ok(t, err)
+	b, err := ioutil.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "content", string(b))
+
+	err = ro.Upload(ctx, "other", bytes.NewBufferString("content"))
+	testutil.Equals(t, objstore.ErrReadOnly, errors.Cause(err))
+	err = ro.Upload(ctx, "obj", bytes.NewBufferString("changed"))
+	testutil.Equals(t, objstore.ErrReadOnly, errors.Cause(err))
+	err = ro.Delete(ctx, "obj")
+	testutil.Equals(t, objstore.ErrReadOnly, errors.Cause(err))
+
+	// The underlying bucket is unchanged.
+	testutil.Equals(t, map[string][]byte{"obj": []byte("content")}, bkt.Objects())
+}
@@ -0,0 +1,54 @@
+package objtesting
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+// stuckBucket blocks range reads of the stuck object until their context is done.
+type stuckBucket struct {
+	objstore.Bucket
+	stuck string
+}
+
+func (b *stuckBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	if name == b.stuck {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return b.Bucket.GetRange(ctx, name, off, length)
+}
+
+func TestTimeoutBucketReader(t *testing.T) {
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	testutil.Ok(t, bkt.Upload(ctx, "obj", bytes.NewBufferString("data")))
+	testutil.Ok(t, bkt.Upload(ctx, "stuck", bytes.NewBufferString("data")))
+
+	b := objstore.NewTimeoutBucketReader(nil, &stuckBucket{Bucket: bkt, stuck: "stuck"}, 100*time.Millisecond)
+
+	rc, err := b.GetRange(ctx, "obj", 1, 2)
+	testutil.Ok(t, err)
+	data, err := ioutil.ReadAll(rc)
+	testutil.Ok(t, err)
+	testutil.Ok(t, rc.Close())
+	testutil.Equals(t, "at", string(data))
+
+	// Stuck operations fail after the timeout, even if the request has no deadline.
+	begin := time.Now()
+	_, err = b.GetRange(ctx, "stuck", 0, 4)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, time.Since(begin) < 5*time.Second, "stuck operation not aborted, took %s", time.Since(begin))
+
+	ok, err := b.Exists(ctx, "obj")
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "object does not exist")
+}
@@ -0,0 +1,232 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var errOverlayDeleted = errors.New("overlay: object deleted")
+
+// OverlayDeletedDir is the directory of the output bucket that holds an empty tombstone object for each object of the
+// source bucket that was deleted through an overlay.
+const OverlayDeletedDir = "overlay-deleted"
+
+// OverlayBucket reads objects from a source bucket that is never modified and directs all writes to an
+// output bucket. Objects in the output bucket take precedence over the source. Deleting an object that only
+// exists in the source hides it. Deletions are persisted as tombstones in the output bucket, so they are kept
+// by later overlays of the same buckets. This allows to run components that modify a bucket, like the compactor,
+// against real data without any risk to it.
+type OverlayBucket struct {
+	source BucketReader
+	output Bucket
+
+	mtx sync.RWMutex
+	// Objects of the source bucket that were deleted through the overlay.
+	deleted map[string]struct{}
+}
+
+// NewOverlayBucket returns a bucket that reads from the source and output bucket, but only writes to the output bucket.
+// It loads the tombstones of objects deleted by earlier overlays from the output bucket.
+func NewOverlayBucket(ctx context.Context, source BucketReader, output Bucket) (*OverlayBucket, error) {
+	b := &OverlayBucket{
+		source:  source,
+		output:  output,
+		deleted: map[string]struct{}{},
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		return output.Iter(ctx, dir, func(name string) error {
+			if strings.HasSuffix(name, DirDelim) {
+				return walk(name)
+			}
+			b.deleted[strings.TrimPrefix(name, OverlayDeletedDir+DirDelim)] = struct{}{}
+			return nil
+		})
+	}
+	if err := walk(OverlayDeletedDir + DirDelim); err != nil {
+		return nil, errors.Wrap(err, "load tombstones")
+	}
+	return b, nil
+}
+
+func tombstoneName(name string) string {
+	return path.Join(OverlayDeletedDir, name)
+}
+
+func (b *OverlayBucket) isDeleted(name string) bool {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	_, ok := b.deleted[name]
+	return ok
+}
+
+// hasDeletedUnder returns true if any object in the given directory of the source bucket was deleted.
+func (b *OverlayBucket) hasDeletedUnder(dir string) bool {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+
+	for name := range b.deleted {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Iter calls f for each entry in the given directory of the output bucket and each not deleted entry of the source bucket.
+func (b *OverlayBucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	seen := map[string]struct{}{}
+
+	if err := b.output.Iter(ctx, dir, func(name string) error {
+		if name == OverlayDeletedDir+DirDelim {
+			return nil
+		}
+		seen[name] = struct{}{}
+		return f(name)
+	}); err != nil {
+		return err
+	}
+	return b.source.Iter(ctx, dir, func(name string) error {
+		if _, ok := seen[name]; ok {
+			return nil
+		}
+		if !strings.HasSuffix(name, DirDelim) {
+			if b.isDeleted(name) {
+				return nil
+			}
+			return f(name)
+		}
+		if !b.hasDeletedUnder(name) {
+			return f(name)
+		}
+		// Hide directories of which all objects were deleted.
+		empty := true
+		if err := b.Iter(ctx, name, func(string) error {
+			empty = false
+			return nil
+		}); err != nil {
+			return err
+		}
+		if empty {
+			return nil
+		}
+		return f(name)
+	})
+}
+
+// Get returns a reader for the given object name.
+func (b *OverlayBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.output.Get(ctx, name)
+	if err == nil || !b.output.IsObjNotFoundErr(err) {
+		return rc, err
+	}
+	if b.isDeleted(name) {
+		return nil, errOverlayDeleted
+	}
+	return b.source.Get(ctx, name)
+}
+
+// GetRange returns a new range reader for the given object name and range.
+func (b *OverlayBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, err := b.output.GetRange(ctx, name, off, length)
+	if err == nil || !b.output.IsObjNotFoundErr(err) {
+		return rc, err
+	}
+	if b.isDeleted(name) {
+		return nil, errOverlayDeleted
+	}
+	return b.source.GetRange(ctx, name, off, length)
+}
+
+// Exists checks if the given object exists in the output bucket or was not deleted from the source bucket.
+func (b *OverlayBucket) Exists(ctx context.Context, name string) (bool, error) {
+	ok, err := b.output.Exists(ctx, name)
+	if err != nil || ok {
+		return ok, err
+	}
+	if b.isDeleted(name) {
+		return false, nil
+	}
+	return b.source.Exists(ctx, name)
+}
+
+// ObjectSize returns the size of the given object in bytes.
+func (b *OverlayBucket) ObjectSize(ctx context.Context, name string) (uint64, error) {
+	size, err := b.output.ObjectSize(ctx, name)
+	if err == nil || !b.output.IsObjNotFoundErr(err) {
+		return size, err
+	}
+	if b.isDeleted(name) {
+		return 0, errOverlayDeleted
+	}
+	return b.source.ObjectSize(ctx, name)
+}
+
+// Upload the contents of the reader as an object into the output bucket.
+func (b *OverlayBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if err := b.output.Upload(ctx, name, r); err != nil {
+		return err
+	}
+	if !b.isDeleted(name) {
+		return nil
+	}
+	if err := b.output.Delete(ctx, tombstoneName(name)); err != nil {
+		return errors.Wrapf(err, "delete tombstone of %s", name)
+	}
+	b.mtx.Lock()
+	delete(b.deleted, name)
+	b.mtx.Unlock()
+	return nil
+}
+
+// Delete removes the object with the given name from the output bucket. Objects of the source bucket are only hidden
+// by a tombstone in the output bucket.
+func (b *OverlayBucket) Delete(ctx context.Context, name string) error {
+	ok, err := b.output.Exists(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "check %s in output bucket", name)
+	}
+	if ok {
+		if err := b.output.Delete(ctx, name); err != nil {
+			return err
+		}
+	}
+	if b.isDeleted(name) {
+		return nil
+	}
+	if ok, err := b.source.Exists(ctx, name); err != nil {
+		return errors.Wrapf(err, "check %s in source bucket", name)
+	} else if !ok {
+		return nil
+	}
+	if err := b.output.Upload(ctx, tombstoneName(name), bytes.NewReader(nil)); err != nil {
+		return errors.Wrapf(err, "upload tombstone of %s", name)
+	}
+	b.mtx.Lock()
+	b.deleted[name] = struct{}{}
+	b.mtx.Unlock()
+	return nil
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
+func (b *OverlayBucket) IsObjNotFoundErr(err error) bool {
+	return errors.Cause(err) == errOverlayDeleted || b.output.IsObjNotFoundErr(err) || b.source.IsObjNotFoundErr(err)
+}
+
+// Close closes the output bucket and the source bucket, if it can be closed.
+func (b *OverlayBucket) Close() error {
+	err := b.output.Close()
+	if c, ok := b.source.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
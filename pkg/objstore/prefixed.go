@@ -0,0 +1,77 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// PrefixedBucket scopes all operations on a bucket to objects under a prefix, as if the prefix
+// was the root of the bucket. This allows multiple setups to share a single bucket.
+type PrefixedBucket struct {
+	bkt    Bucket
+	prefix string
+}
+
+// NewPrefixedBucket returns a bucket that reads and writes all objects under the given prefix
+// of the given bucket. If the prefix is empty, the bucket is returned unchanged.
+func NewPrefixedBucket(bkt Bucket, prefix string) Bucket {
+	prefix = strings.Trim(prefix, DirDelim)
+	if prefix == "" {
+		return bkt
+	}
+	return &PrefixedBucket{bkt: bkt, prefix: prefix}
+}
+
+func (b *PrefixedBucket) name(name string) string {
+	return b.prefix + DirDelim + name
+}
+
+// Iter calls f for each entry in the given directory. The names passed to f do not include the prefix.
+func (b *PrefixedBucket) Iter(ctx context.Context, dir string, f func(string) error) error {
+	if dir != "" {
+		dir = strings.TrimSuffix(dir, DirDelim) + DirDelim
+	}
+	return b.bkt.Iter(ctx, b.name(dir), func(name string) error {
+		return f(strings.TrimPrefix(name, b.prefix+DirDelim))
+	})
+}
+
+// Get returns a reader for the given object name.
+func (b *PrefixedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return b.bkt.Get(ctx, b.name(name))
+}
+
+// GetRange returns a new range reader for the given object name and range.
+func (b *PrefixedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	return b.bkt.GetRange(ctx, b.name(name), off, length)
+}
+
+// Exists checks if the given object exists in the bucket.
+func (b *PrefixedBucket) Exists(ctx context.Context, name string) (bool, error) {
+	return b.bkt.Exists(ctx, b.name(name))
+}
+
+// ObjectSize returns the size of the given object in bytes.
+func (b *PrefixedBucket) ObjectSize(ctx context.Context, name string) (uint64, error) {
+	return b.bkt.ObjectSize(ctx, b.name(name))
+}
+
+// Upload the contents of the reader as an object into the bucket.
+func (b *PrefixedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	return b.bkt.Upload(ctx, b.name(name), r)
+}
+
+// Delete removes the object with the given name.
+func (b *PrefixedBucket) Delete(ctx context.Context, name string) error {
+	return b.bkt.Delete(ctx, b.name(name))
+}
+
+// IsObjNotFoundErr returns true if error means that object is not found. Relevant to Get operations.
+func (b *PrefixedBucket) IsObjNotFoundErr(err error) bool {
+	return b.bkt.IsObjNotFoundErr(err)
+}
+
+func (b *PrefixedBucket) Close() error {
+	return b.bkt.Close()
+}
@@ -0,0 +1,201 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// RateLimits limits the bandwidth of object storage transfers in bytes per second. Zero values disable a limit.
+type RateLimits struct {
+	Upload   units.Base2Bytes
+	Download units.Base2Bytes
+}
+
+// RegisterRateLimitParams registers the object storage bandwidth flags and returns an initialized RateLimits struct.
+func RegisterRateLimitParams(cmd *kingpin.CmdClause) *RateLimits {
+	var limits RateLimits
+
+	cmd.Flag("objstore.max-upload-bytes-per-second", "Maximum bandwidth of all uploads to object storage, e.g. 50MB. Allows to catch up on a backlog without saturating a shared network link. 0 disables the limit.").
+		Default("0").BytesVar(&limits.Upload)
+
+	cmd.Flag("objstore.max-download-bytes-per-second", "Maximum bandwidth of all downloads from object storage, e.g. 50MB. Allows to catch up on a backlog without saturating a shared network link. 0 disables the limit.").
+		Default("0").BytesVar(&limits.Download)
+
+	return &limits
+}
+
+// RateLimiter throttles the uploads and downloads of buckets to the configured bandwidth. All buckets
+// wrapped by the same limiter share its bandwidth.
+type RateLimiter struct {
+	upload   *tokenBucket
+	download *tokenBucket
+
+	throttled *prometheus.CounterVec
+	bytes     *prometheus.CounterVec
+}
+
+// NewRateLimiter returns a limiter for the given limits.
+func NewRateLimiter(reg prometheus.Registerer, limits RateLimits) *RateLimiter {
+	l := &RateLimiter{
+		upload:   newTokenBucket(float64(limits.Upload)),
+		download: newTokenBucket(float64(limits.Download)),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_objstore_throttled_seconds_total",
+			Help: "Total time transfers from and to object storage were delayed to stay within the bandwidth limit by operation.",
+		}, []string{"operation"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_objstore_rate_limited_bytes_total",
+			Help: "Total number of bytes transferred from and to object storage subject to a bandwidth limit by operation.",
+		}, []string{"operation"}),
+	}
+	if reg != nil {
+		reg.MustRegister(l.throttled, l.bytes)
+	}
+	return l
+}
+
+// Bucket returns a bucket whose uploads and downloads are throttled by the limiter.
+// The bucket is returned as is if no limit is set.
+func (l *RateLimiter) Bucket(b Bucket) Bucket {
+	if l.upload == nil && l.download == nil {
+		return b
+	}
+	return &rateLimitedBucket{Bucket: b, l: l}
+}
+
+type rateLimitedBucket struct {
+	Bucket
+	l *RateLimiter
+}
+
+func (b *rateLimitedBucket) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	rc, err := b.Bucket.Get(ctx, name)
+	if err != nil || b.l.download == nil {
+		return rc, err
+	}
+	return &rateLimitedReadCloser{
+		rateLimitedReader: b.l.reader(ctx, rc, b.l.download, "download"),
+		closer:            rc,
+	}, nil
+}
+
+func (b *rateLimitedBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil || b.l.download == nil {
+		return rc, err
+	}
+	return &rateLimitedReadCloser{
+		rateLimitedReader: b.l.reader(ctx, rc, b.l.download, "download"),
+		closer:            rc,
+	}, nil
+}
+
+func (b *rateLimitedBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if b.l.upload == nil {
+		return b.Bucket.Upload(ctx, name, r)
+	}
+	return b.Bucket.Upload(ctx, name, b.l.reader(ctx, r, b.l.upload, "upload"))
+}
+
+func (l *RateLimiter) reader(ctx context.Context, r io.Reader, tb *tokenBucket, op string) *rateLimitedReader {
+	return &rateLimitedReader{
+		r:         r,
+		ctx:       ctx,
+		tb:        tb,
+		throttled: l.throttled.WithLabelValues(op),
+		bytes:     l.bytes.WithLabelValues(op),
+	}
+}
+
+// rateLimitedReader delays reads until the bytes read fit into the bandwidth of its token bucket.
+type rateLimitedReader struct {
+	r   io.Reader
+	ctx context.Context
+	tb  *tokenBucket
+
+	throttled prometheus.Counter
+	bytes     prometheus.Counter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Large reads would otherwise be delayed for a long time after reading all of their data at once.
+	if max := r.tb.burst(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.r.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	r.bytes.Add(float64(n))
+
+	wait := r.tb.take(time.Now(), n)
+	if wait <= 0 {
+		return n, err
+	}
+	r.throttled.Add(wait.Seconds())
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return n, r.ctx.Err()
+	case <-t.C:
+	}
+	return n, err
+}
+
+type rateLimitedReadCloser struct {
+	*rateLimitedReader
+	closer io.Closer
+}
+
+func (rc *rateLimitedReadCloser) Close() error {
+	return rc.closer.Close()
+}
+
+// tokenBucket tracks the bandwidth available to transfers. It allows bursts of up to one second worth of bytes.
+type tokenBucket struct {
+	rate float64
+
+	mtx     sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// newTokenBucket returns a full token bucket for the given bytes per second, or nil if the rate is not positive.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, updated: time.Now()}
+}
+
+func (tb *tokenBucket) burst() int {
+	return int(math.Max(1, math.Min(tb.rate, math.MaxInt32)))
+}
+
+// take takes n tokens and returns how long to wait until they are available. The tokens are taken
+// even if they are not available yet, so that concurrent transfers queue up behind each other.
+func (tb *tokenBucket) take(now time.Time, n int) time.Duration {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	if now.After(tb.updated) {
+		tb.tokens = math.Min(tb.rate, tb.tokens+now.Sub(tb.updated).Seconds()*tb.rate)
+		tb.updated = now
+	}
+	tb.tokens -= float64(n)
+
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
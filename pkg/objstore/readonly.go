@@ -0,0 +1,32 @@
+package objstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrReadOnly is returned by writes to a read-only bucket.
+var ErrReadOnly = errors.New("bucket is read-only")
+
+// ReadOnlyBucket rejects all writes to a bucket. It guarantees that a component does not mutate the
+// bucket, even if its credentials allow writes.
+type ReadOnlyBucket struct {
+	Bucket
+}
+
+// NewReadOnlyBucket returns a bucket that reads from the given bucket and rejects all uploads and deletes.
+func NewReadOnlyBucket(bkt Bucket) Bucket {
+	return &ReadOnlyBucket{Bucket: bkt}
+}
+
+// Upload returns ErrReadOnly.
+func (b *ReadOnlyBucket) Upload(_ context.Context, name string, _ io.Reader) error {
+	return errors.Wrapf(ErrReadOnly, "upload %s", name)
+}
+
+// Delete returns ErrReadOnly.
+func (b *ReadOnlyBucket) Delete(_ context.Context, name string) error {
+	return errors.Wrapf(ErrReadOnly, "delete %s", name)
+}
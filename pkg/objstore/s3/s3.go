@@ -2,12 +2,17 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
@@ -15,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/minio/minio-go"
 	"github.com/minio/minio-go/pkg/encrypt"
@@ -32,6 +38,20 @@ const (
 	opObjectDelete = "DeleteObject"
 )
 
+const (
+	uploadSingle    = "single"
+	uploadMultipart = "multipart"
+)
+
+const (
+	// minPartSize and maxPartSize are the limits of the size of a part of a multipart upload in S3.
+	minPartSize = 5 * units.MiB
+	maxPartSize = 5 * units.GiB
+	// maxMultipartThreshold bounds the memory buffered to decide on the type of upload of objects
+	// of unknown size.
+	maxMultipartThreshold = 256 * units.MiB
+)
+
 // DirDelim is the delimiter used to model a directory structure in an object store bucket.
 const DirDelim = "/"
 
@@ -41,6 +61,10 @@ type Bucket struct {
 	client   *minio.Client
 	sse      encrypt.ServerSide
 	opsTotal *prometheus.CounterVec
+
+	partSize           int64
+	multipartThreshold int64
+	uploadsTotal       *prometheus.CounterVec
 }
 
 // Config encapsulates the necessary config values to instantiate an s3 client.
@@ -52,6 +76,25 @@ type Config struct {
 	Insecure     bool
 	SignatureV2  bool
 	SSEEnprytion bool
+	// Prefix under which all objects are stored, so multiple setups can share a bucket.
+	Prefix string
+	HTTP   HTTPConfig
+	// PartSize is the size of the parts of multipart uploads.
+	PartSize units.Base2Bytes
+	// MultipartThreshold is the size from which objects are uploaded with a multipart upload
+	// instead of a single request.
+	MultipartThreshold units.Base2Bytes
+}
+
+// HTTPConfig configures the HTTP client used for all requests against the s3 API, e.g. for gateways
+// that require custom headers, internal CAs or a proxy.
+type HTTPConfig struct {
+	// Headers are set on every request, including each part of multipart uploads. A Host header
+	// replaces the endpoint in requests, headers set and signed by the client are rejected.
+	Headers            map[string]string
+	CAFile             string
+	InsecureSkipVerify bool
+	ProxyURL           string
 }
 
 // RegisterS3Params registers the s3 flags and returns an initialized Config struct.
@@ -78,6 +121,28 @@ func RegisterS3Params(cmd *kingpin.CmdClause) *Config {
 	cmd.Flag("s3.encrypt-sse", "Whether to use Server Side Encryption").
 		Default("false").Envar("S3_SSE_ENCRYPTION").BoolVar(&s3config.SSEEnprytion)
 
+	cmd.Flag("s3.prefix", "Prefix (directory) in the bucket under which all objects are read and written. Allows multiple setups to share one bucket.").
+		PlaceHolder("<prefix>").Envar("S3_PREFIX").StringVar(&s3config.Prefix)
+
+	s3config.HTTP.Headers = map[string]string{}
+	cmd.Flag("s3.http-header", "Header to set on every request against an S3-Compatible API. A Host header is signed and sent instead of the endpoint, connections are still made to the endpoint. Authorization, Date, Content-* and X-Amz-* headers cannot be set, as they are set and signed by the client. Repeat for multiple headers.").
+		PlaceHolder("<name>=<value>").StringMapVar(&s3config.HTTP.Headers)
+
+	cmd.Flag("s3.http-ca-file", "CA certificates to verify the certificate of an S3-Compatible API with, instead of the system certificates.").
+		PlaceHolder("<path>").Envar("S3_HTTP_CA_FILE").StringVar(&s3config.HTTP.CAFile)
+
+	cmd.Flag("s3.http-insecure-skip-verify", "Whether to skip the verification of the certificate of an S3-Compatible API.").
+		Default("false").Envar("S3_HTTP_INSECURE_SKIP_VERIFY").BoolVar(&s3config.HTTP.InsecureSkipVerify)
+
+	cmd.Flag("s3.http-proxy-url", "Proxy to send requests against an S3-Compatible API through. If not set, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.").
+		PlaceHolder("<url>").Envar("S3_HTTP_PROXY_URL").StringVar(&s3config.HTTP.ProxyURL)
+
+	cmd.Flag("s3.part-size", "Size of the parts of multipart uploads. Must be between 5MB and 5GB. Some S3-Compatible APIs require larger parts than AWS S3.").
+		Default("64MB").Envar("S3_PART_SIZE").BytesVar(&s3config.PartSize)
+
+	cmd.Flag("s3.multipart-threshold", "Size from which objects are uploaded with a multipart upload instead of a single request. Objects of unknown size are buffered in memory up to it, files are uploaded with a single request below the part size. Must be at most 256MB and at most the part size.").
+		Default("64MB").Envar("S3_MULTIPART_THRESHOLD").BytesVar(&s3config.MultipartThreshold)
+
 	return &s3config
 }
 
@@ -104,6 +169,15 @@ func (conf *Config) ValidateForTests() error {
 
 // NewBucket returns a new Bucket using the provided s3 config values.
 func NewBucket(conf *Config, reg prometheus.Registerer, component string) (*Bucket, error) {
+	if conf.PartSize < minPartSize || conf.PartSize > maxPartSize {
+		return nil, errors.Errorf("s3 part size %s must be between %s and %s", conf.PartSize, minPartSize, maxPartSize)
+	}
+	// Objects below the threshold are buffered in memory. minio uploads objects smaller than the part
+	// size with a single request, so the threshold must not exceed it.
+	if conf.MultipartThreshold > maxMultipartThreshold || conf.MultipartThreshold > conf.PartSize {
+		return nil, errors.Errorf("s3 multipart threshold %s must be at most %s and at most the part size %s", conf.MultipartThreshold, maxMultipartThreshold, conf.PartSize)
+	}
+
 	var f func(string, string, string, bool) (*minio.Client, error)
 	if conf.SignatureV2 {
 		f = minio.NewV2
@@ -111,21 +185,121 @@ func NewBucket(conf *Config, reg prometheus.Registerer, component string) (*Buck
 		f = minio.NewV4
 	}
 
-	client, err := f(conf.Endpoint, conf.AccessKey, conf.SecretKey, !conf.Insecure)
+	// Requests are signed before they reach the transport. A custom Host is therefore used as the
+	// endpoint of the client, so requests are built and signed for it, and the transport connects
+	// to the actual endpoint.
+	endpoint := conf.Endpoint
+	if host, ok := conf.HTTP.host(); ok {
+		endpoint = host
+	}
+	client, err := f(endpoint, conf.AccessKey, conf.SecretKey, !conf.Insecure)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize s3 client")
 	}
 	client.SetAppInfo(fmt.Sprintf("thanos-%s", component), fmt.Sprintf("%s (%s)", version.Version, runtime.Version()))
-	client.SetCustomTransport(&http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+	rt, err := newTransport(conf.HTTP, conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client.SetCustomTransport(rt)
+
+	var sse encrypt.ServerSide
+	if conf.SSEEnprytion {
+		sse = encrypt.NewSSE()
+	}
+
+	bkt := &Bucket{
+		bucket: conf.Bucket,
+		client: client,
+		sse:    sse,
+		opsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_s3_bucket_operations_total",
+			Help:        "Total number of operations that were executed against an s3 bucket.",
+			ConstLabels: prometheus.Labels{"bucket": conf.Bucket},
+		}, []string{"operation"}),
+		partSize:           int64(conf.PartSize),
+		multipartThreshold: int64(conf.MultipartThreshold),
+		uploadsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "thanos_objstore_s3_bucket_uploads_total",
+			Help:        "Total number of uploads to an s3 bucket by type of upload, either single or multipart.",
+			ConstLabels: prometheus.Labels{"bucket": conf.Bucket},
+		}, []string{"type"}),
+	}
+	if reg != nil {
+		reg.MustRegister(bkt.opsTotal, bkt.uploadsTotal)
+	}
+	return bkt, nil
+}
+
+// newTransport returns the round tripper of the s3 client configured by the given config. Connections
+// for requests against a custom Host are made to the given endpoint.
+func newTransport(conf HTTPConfig, endpoint string) (http.RoundTripper, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	if conf.CAFile != "" {
+		b, err := ioutil.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read s3 CA file %s", conf.CAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in s3 CA file %s", conf.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if conf.ProxyURL != "" {
+		u, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse s3 proxy URL %s", conf.ProxyURL)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	headers := map[string]string{}
+	for name, v := range conf.Headers {
+		if err := validateHeader(name); err != nil {
+			return nil, err
+		}
+		if http.CanonicalHeaderKey(name) == "Host" {
+			// The Host is set by the client when building the request, see NewBucket.
+			continue
+		}
+		headers[name] = v
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
+	dial := dialer.DialContext
+	if host, ok := conf.host(); ok {
+		// Requests are addressed to the custom Host so it is part of the signature, but connections
+		// are still made to the endpoint. Connections to a proxy are left alone.
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			h, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if h != hostname(host) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			ep := endpoint
+			if _, _, err := net.SplitHostPort(ep); err != nil {
+				ep = net.JoinHostPort(ep, port)
+			}
+			return dialer.DialContext(ctx, network, ep)
+		}
+	}
+
+	var rt http.RoundTripper = &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
+		TLSClientConfig:       tlsConfig,
 		ExpectContinueTimeout: 1 * time.Second,
 		// The ResponseHeaderTimeout here is the only change from the
 		// default minio transport, it was introduced to cover cases
@@ -138,27 +312,64 @@ func NewBucket(conf *Config, reg prometheus.Registerer, component string) (*Buck
 		// Refer:
 		//    https://golang.org/src/net/http/transport.go?h=roundTrip#L1843
 		DisableCompression: true,
-	})
+	}
+	if len(headers) > 0 {
+		rt = &headerRoundTripper{headers: headers, rt: rt}
+	}
+	return rt, nil
+}
 
-	var sse encrypt.ServerSide
-	if conf.SSEEnprytion {
-		sse = encrypt.NewSSE()
+// validateHeader returns an error for headers that are part of the request signature or the request
+// body. The custom headers are set after the request was signed, so overriding them would either break
+// the signature or bypass it.
+func validateHeader(name string) error {
+	name = http.CanonicalHeaderKey(name)
+	switch {
+	case name == "Authorization", name == "Date", strings.HasPrefix(name, "Content-"):
+		return errors.Errorf("s3 %s header cannot be set, it is set by the s3 client", name)
+	case strings.HasPrefix(name, "X-Amz-"):
+		return errors.Errorf("s3 %s header cannot be set, X-Amz-* headers are signed by the s3 client", name)
 	}
+	return nil
+}
 
-	bkt := &Bucket{
-		bucket: conf.Bucket,
-		client: client,
-		sse:    sse,
-		opsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
-			Name:        "thanos_objstore_s3_bucket_operations_total",
-			Help:        "Total number of operations that were executed against an s3 bucket.",
-			ConstLabels: prometheus.Labels{"bucket": conf.Bucket},
-		}, []string{"operation"}),
+// host returns the custom Host header, if any.
+func (conf HTTPConfig) host() (string, bool) {
+	for name, v := range conf.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			return v, true
+		}
 	}
-	if reg != nil {
-		reg.MustRegister(bkt.opsTotal)
+	return "", false
+}
+
+// hostname returns the host of the given host and optional port.
+func hostname(hostport string) string {
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		return h
 	}
-	return bkt, nil
+	return hostport
+}
+
+// headerRoundTripper sets custom headers on all requests. The headers are set after minio signed the
+// request, so they are not part of the signature.
+type headerRoundTripper struct {
+	headers map[string]string
+	rt      http.RoundTripper
+}
+
+func (t *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request, so the headers are set on a copy.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+len(t.headers))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	for k, v := range t.headers {
+		r.Header.Set(k, v)
+	}
+	return t.rt.RoundTrip(r)
 }
 
 // Iter calls f for each entry in the given directory. The argument to f is the full
@@ -232,17 +443,72 @@ func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
-// Upload the contents of the reader as an object into the bucket.
+// ObjectSize returns the size of the given object in bytes.
+func (b *Bucket) ObjectSize(ctx context.Context, name string) (uint64, error) {
+	b.opsTotal.WithLabelValues(opObjectHead).Inc()
+	objInfo, err := b.client.StatObject(b.bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		// Not wrapped, so the error can still be checked with IsObjNotFoundErr.
+		return 0, err
+	}
+	return uint64(objInfo.Size), nil
+}
+
+// Upload the contents of the reader as an object into the bucket. Objects of unknown size smaller than
+// the multipart threshold are uploaded with a single request, larger ones with a multipart upload.
+// Readers of known size, like files, are not buffered and minio decides on the upload by the part size.
 func (b *Bucket) Upload(ctx context.Context, name string, r io.Reader) error {
 	b.opsTotal.WithLabelValues(opObjectInsert).Inc()
 
-	_, err := b.client.PutObjectWithContext(ctx, b.bucket, name, r, -1,
-		minio.PutObjectOptions{ServerSideEncryption: b.sse},
-	)
+	opts := minio.PutObjectOptions{ServerSideEncryption: b.sse, PartSize: uint64(b.partSize)}
+
+	// Files are uploaded with their known size, so they are not buffered in memory.
+	if size, ok := readerSize(r); ok {
+		if size < b.partSize {
+			b.uploadsTotal.WithLabelValues(uploadSingle).Inc()
+		} else {
+			b.uploadsTotal.WithLabelValues(uploadMultipart).Inc()
+		}
+		_, err := b.client.PutObjectWithContext(ctx, b.bucket, name, r, size, opts)
+		return errors.Wrap(err, "upload s3 object")
+	}
 
+	// The size of the object is unknown, so up to the threshold is read to decide on the type of upload.
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, b.multipartThreshold))
+	if err != nil {
+		return errors.Wrap(err, "read object")
+	}
+	if n < b.multipartThreshold {
+		b.uploadsTotal.WithLabelValues(uploadSingle).Inc()
+		_, err = b.client.PutObjectWithContext(ctx, b.bucket, name, &buf, n, opts)
+		return errors.Wrap(err, "upload s3 object")
+	}
+
+	b.uploadsTotal.WithLabelValues(uploadMultipart).Inc()
+	_, err = b.client.PutObjectWithContext(ctx, b.bucket, name, io.MultiReader(&buf, r), -1, opts)
 	return errors.Wrap(err, "upload s3 object")
 }
 
+// readerSize returns the number of bytes left in r if it is known without reading it.
+func readerSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case *os.File:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		off, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		return fi.Size() - off, true
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	}
+	return 0, false
+}
+
 // Delete removes the object with the given name.
 func (b *Bucket) Delete(ctx context.Context, name string) error {
 	b.opsTotal.WithLabelValues(opObjectDelete).Inc()
@@ -262,6 +528,9 @@ func configFromEnv() *Config {
 		Endpoint:  os.Getenv("S3_ENDPOINT"),
 		AccessKey: os.Getenv("S3_ACCESS_KEY"),
 		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		// Match the flag defaults.
+		PartSize:           64 * units.MiB,
+		MultipartThreshold: 64 * units.MiB,
 	}
 
 	insecure, err := strconv.ParseBool(os.Getenv("S3_INSECURE"))
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/units"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestNewTransport_Headers(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer srv.Close()
+
+	rt, err := newTransport(HTTPConfig{Headers: map[string]string{"x-tenant": "team-a"}}, "")
+	testutil.Ok(t, err)
+
+	req, err := http.NewRequest("PUT", srv.URL+"/bucket/object?partNumber=1&uploadId=1", nil)
+	testutil.Ok(t, err)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 signature")
+
+	resp, err := rt.RoundTrip(req)
+	testutil.Ok(t, err)
+	resp.Body.Close()
+
+	testutil.Equals(t, "team-a", got.Get("X-Tenant"))
+	testutil.Equals(t, "AWS4-HMAC-SHA256 signature", got.Get("Authorization"))
+	// The request of the caller must not be modified.
+	testutil.Equals(t, "", req.Header.Get("X-Tenant"))
+
+	// Headers that are set and signed by the client cannot be overridden.
+	for _, name := range []string{"Authorization", "x-amz-date", "X-Amz-Content-Sha256", "Content-MD5", "date"} {
+		_, err = newTransport(HTTPConfig{Headers: map[string]string{name: "value"}}, "")
+		testutil.NotOk(t, err)
+	}
+	_, err = newTransport(HTTPConfig{CAFile: "/non-existent"}, "")
+	testutil.NotOk(t, err)
+}
+
+func TestNewTransport_Host(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	rt, err := newTransport(HTTPConfig{Headers: map[string]string{"host": "s3.example.com"}}, u.Host)
+	testutil.Ok(t, err)
+
+	// The client addresses requests to the custom Host, the transport connects to the endpoint.
+	req, err := http.NewRequest("GET", "http://s3.example.com/bucket/object", nil)
+	testutil.Ok(t, err)
+
+	resp, err := rt.RoundTrip(req)
+	testutil.Ok(t, err)
+	resp.Body.Close()
+
+	testutil.Equals(t, "s3.example.com", host)
+}
+
+func TestNewBucket_PartSize(t *testing.T) {
+	conf := &Config{Bucket: "test", Endpoint: "localhost:9000", AccessKey: "key", SecretKey: "secret"}
+
+	for _, tcase := range []struct {
+		partSize, threshold units.Base2Bytes
+		ok                  bool
+	}{
+		{partSize: 64 * units.MiB, threshold: 64 * units.MiB, ok: true},
+		{partSize: 5 * units.MiB, threshold: 0, ok: true},
+		{partSize: 5 * units.GiB, threshold: 256 * units.MiB, ok: true},
+		{partSize: 5*units.MiB - 1, threshold: 64 * units.MiB},
+		{partSize: 5*units.GiB + 1, threshold: 64 * units.MiB},
+		{partSize: 5 * units.GiB, threshold: 256*units.MiB + 1},
+		{partSize: 64 * units.MiB, threshold: 64*units.MiB + 1},
+	} {
+		conf.PartSize, conf.MultipartThreshold = tcase.partSize, tcase.threshold
+
+		_, err := NewBucket(conf, nil, "test")
+		if tcase.ok {
+			testutil.Ok(t, err)
+		} else {
+			testutil.NotOk(t, err)
+		}
+	}
+}
+
+func TestReaderSize(t *testing.T) {
+	f, err := ioutil.TempFile("", "test_reader_size")
+	testutil.Ok(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.WriteString("0123456789")
+	testutil.Ok(t, err)
+	_, err = f.Seek(4, 0)
+	testutil.Ok(t, err)
+
+	size, ok := readerSize(f)
+	testutil.Assert(t, ok, "file size not known")
+	testutil.Equals(t, int64(6), size)
+
+	size, ok = readerSize(bytes.NewReader([]byte("abc")))
+	testutil.Assert(t, ok, "bytes reader size not known")
+	testutil.Equals(t, int64(3), size)
+
+	_, ok = readerSize(ioutil.NopCloser(strings.NewReader("abc")))
+	testutil.Assert(t, !ok, "wrapped reader size known")
+}
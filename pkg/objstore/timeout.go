@@ -0,0 +1,107 @@
+package objstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimeoutBucketReader bounds the time of each individual range read, existence check and size lookup, independent
+// of the deadline of the request it is part of. A stuck request to the object storage fails after the timeout instead
+// of holding the request for its whole deadline. Full object downloads and listings are not bounded, as they are used
+// while syncing blocks, where they may take arbitrarily long.
+type TimeoutBucketReader struct {
+	BucketReader
+	timeout time.Duration
+
+	timeouts *prometheus.CounterVec
+}
+
+// NewTimeoutBucketReader returns a reader that bounds operations of the given reader by timeout.
+func NewTimeoutBucketReader(reg prometheus.Registerer, r BucketReader, timeout time.Duration) *TimeoutBucketReader {
+	b := &TimeoutBucketReader{
+		BucketReader: r,
+		timeout:      timeout,
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_objstore_operation_timeouts_total",
+			Help: "Total number of object storage operations that were aborted as they exceeded the operation timeout.",
+		}, []string{"operation"}),
+	}
+	if reg != nil {
+		reg.MustRegister(b.timeouts)
+	}
+	return b
+}
+
+// timedOut returns true and counts the timeout if the operation's context exceeded the operation timeout rather
+// than the deadline of its parent.
+func (b *TimeoutBucketReader) timedOut(parent, ctx context.Context, op string) bool {
+	if ctx.Err() != context.DeadlineExceeded || parent.Err() != nil {
+		return false
+	}
+	b.timeouts.WithLabelValues(op).Inc()
+	return true
+}
+
+func (b *TimeoutBucketReader) wrapErr(parent, ctx context.Context, op, name string, err error) error {
+	if err != nil && b.timedOut(parent, ctx, op) {
+		return errors.Wrapf(err, "%s %s exceeded operation timeout of %s", op, name, b.timeout)
+	}
+	return err
+}
+
+// GetRange returns a new range reader for the given object name and range. The timeout covers reading the range.
+func (b *TimeoutBucketReader) GetRange(parent context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	const op = "get_range"
+
+	ctx, cancel := context.WithTimeout(parent, b.timeout)
+	rc, err := b.BucketReader.GetRange(ctx, name, off, length)
+	if err != nil {
+		err = b.wrapErr(parent, ctx, op, name, err)
+		cancel()
+		return nil, err
+	}
+	return &timeoutReadCloser{ReadCloser: rc, b: b, parent: parent, ctx: ctx, cancel: cancel, name: name}, nil
+}
+
+// Exists checks if the given object exists in the bucket.
+func (b *TimeoutBucketReader) Exists(parent context.Context, name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(parent, b.timeout)
+	defer cancel()
+
+	ok, err := b.BucketReader.Exists(ctx, name)
+	return ok, b.wrapErr(parent, ctx, "exists", name, err)
+}
+
+// ObjectSize returns the size of the given object in bytes.
+func (b *TimeoutBucketReader) ObjectSize(parent context.Context, name string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(parent, b.timeout)
+	defer cancel()
+
+	size, err := b.BucketReader.ObjectSize(ctx, name)
+	return size, b.wrapErr(parent, ctx, "object_size", name, err)
+}
+
+type timeoutReadCloser struct {
+	io.ReadCloser
+	b           *TimeoutBucketReader
+	parent, ctx context.Context
+	cancel      context.CancelFunc
+	name        string
+}
+
+func (rc *timeoutReadCloser) Read(p []byte) (int, error) {
+	n, err := rc.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = rc.b.wrapErr(rc.parent, rc.ctx, "get_range", rc.name, err)
+	}
+	return n, err
+}
+
+func (rc *timeoutReadCloser) Close() error {
+	defer rc.cancel()
+	return rc.ReadCloser.Close()
+}
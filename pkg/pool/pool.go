@@ -11,6 +11,10 @@ import (
 // more than a maximum number of bytes being used at a given time.
 // Every byte slice obtained from the pool must be returned.
 type BytesPool struct {
+	// Accessed atomically, must be first for alignment on 32 bit platforms.
+	hits   uint64
+	allocs uint64
+
 	buckets   []sync.Pool
 	sizes     []int
 	maxTotal  uint64
@@ -59,7 +63,10 @@ func (p *BytesPool) Get(sz int) ([]byte, error) {
 			continue
 		}
 		b, ok := p.buckets[i].Get().([]byte)
-		if !ok {
+		if ok {
+			atomic.AddUint64(&p.hits, 1)
+		} else {
+			atomic.AddUint64(&p.allocs, 1)
 			b = make([]byte, 0, bktSize)
 		}
 		atomic.AddUint64(&p.usedTotal, uint64(cap(b)))
@@ -67,6 +74,7 @@ func (p *BytesPool) Get(sz int) ([]byte, error) {
 	}
 
 	// The requested size exceeds that of our highest bucket, allocate it directly.
+	atomic.AddUint64(&p.allocs, 1)
 	atomic.AddUint64(&p.usedTotal, uint64(sz))
 	return make([]byte, 0, sz), nil
 }
@@ -80,5 +88,10 @@ func (p *BytesPool) Put(b []byte) {
 		p.buckets[i].Put(b[:0])
 		break
 	}
-	atomic.AddUint64(&p.usedTotal, ^uint64(p.usedTotal-1))
+	atomic.AddUint64(&p.usedTotal, ^uint64(cap(b)-1))
+}
+
+// Stats returns how many slices were reused from the pool and how many had to be allocated so far.
+func (p *BytesPool) Stats() (hits, allocs uint64) {
+	return atomic.LoadUint64(&p.hits), atomic.LoadUint64(&p.allocs)
 }
@@ -0,0 +1,34 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestBytesPool(t *testing.T) {
+	p, err := NewBytesPool(10, 100, 2, 1000)
+	testutil.Ok(t, err)
+
+	b, err := p.Get(15)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 20, cap(b))
+	testutil.Equals(t, uint64(20), p.usedTotal)
+
+	big, err := p.Get(500)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(520), p.usedTotal)
+
+	_, err = p.Get(500)
+	testutil.Equals(t, ErrPoolExhausted, err)
+
+	// Returned bytes are no longer accounted as used.
+	p.Put(big)
+	testutil.Equals(t, uint64(20), p.usedTotal)
+	p.Put(b)
+	testutil.Equals(t, uint64(0), p.usedTotal)
+
+	hits, allocs := p.Stats()
+	testutil.Equals(t, uint64(0), hits)
+	testutil.Equals(t, uint64(2), allocs)
+}
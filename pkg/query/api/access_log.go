@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"net/http"
+)
+
+// accessLogParams are the request parameters that are logged if set.
+var accessLogParams = []string{"query", "time", "start", "end", "step", "match[]", "dedup", "max_source_resolution"}
+
+// withAccessLog logs every request handled by h with its parameters, status and duration to the access logger.
+func (api *API) withAccessLog(h http.HandlerFunc) http.HandlerFunc {
+	if api.accessLogger == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		begin := api.now()
+
+		// Parse the form up front, so that the parameters of POST requests are still available once
+		// the handler consumed the body. Handlers report invalid parameters themselves.
+		_ = r.ParseForm()
+
+		aw := &accessLogWriter{ResponseWriter: w}
+		h(aw, r)
+
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		kvs := []interface{}{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration_seconds", api.now().Sub(begin).Seconds(),
+			"remote_addr", r.RemoteAddr,
+		}
+		for _, p := range accessLogParams {
+			if v, ok := r.Form[p]; ok {
+				if len(v) == 1 {
+					kvs = append(kvs, p, v[0])
+				} else {
+					kvs = append(kvs, p, v)
+				}
+			}
+		}
+		api.accessLogger.Log(kvs...)
+	}
+}
+
+// accessLogWriter records the status code of a response for the access log.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
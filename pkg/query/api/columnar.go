@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"bufio"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// columnarContentType is the media type of range query results encoded as a stream of
+// ColumnarSeries messages as defined in columnar.proto.
+const columnarContentType = "application/vnd.thanos.columnar+protobuf"
+
+// acceptsColumnar returns true if the request accepts the columnar encoding.
+func acceptsColumnar(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mt == columnarContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// respondColumnar writes the matrix as a stream of length-prefixed ColumnarSeries messages.
+// Series are encoded one by one, so the encoded response is never buffered as a whole.
+func respondColumnar(w http.ResponseWriter, m promql.Matrix, warnings []error) error {
+	w.Header().Set("Content-Type", columnarContentType)
+	for _, warn := range warnings {
+		w.Header().Add("X-Thanos-Warning", warn.Error())
+	}
+	w.WriteHeader(http.StatusOK)
+
+	var (
+		bw  = bufio.NewWriter(w)
+		msg = proto.NewBuffer(nil)
+		col = proto.NewBuffer(nil)
+		hdr = proto.NewBuffer(nil)
+	)
+	for _, s := range m {
+		msg.Reset()
+		encodeColumnarSeries(msg, col, s)
+
+		hdr.Reset()
+		hdr.EncodeVarint(uint64(len(msg.Bytes())))
+		if _, err := bw.Write(hdr.Bytes()); err != nil {
+			return err
+		}
+		if _, err := bw.Write(msg.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// encodeColumnarSeries appends the series as ColumnarSeries message to buf.
+// The column buffer is used to encode the packed columns.
+func encodeColumnarSeries(buf, col *proto.Buffer, s promql.Series) {
+	for _, l := range s.Metric {
+		col.Reset()
+		encodeTag(col, 1, proto.WireBytes)
+		col.EncodeStringBytes(l.Name)
+		encodeTag(col, 2, proto.WireBytes)
+		col.EncodeStringBytes(l.Value)
+
+		encodeTag(buf, 1, proto.WireBytes)
+		buf.EncodeRawBytes(col.Bytes())
+	}
+	if len(s.Points) == 0 {
+		return
+	}
+
+	col.Reset()
+	for _, p := range s.Points {
+		col.EncodeVarint(uint64(p.T))
+	}
+	encodeTag(buf, 2, proto.WireBytes)
+	buf.EncodeRawBytes(col.Bytes())
+
+	col.Reset()
+	for _, p := range s.Points {
+		col.EncodeFixed64(math.Float64bits(p.V))
+	}
+	encodeTag(buf, 3, proto.WireBytes)
+	buf.EncodeRawBytes(col.Bytes())
+}
+
+func encodeTag(buf *proto.Buffer, field int, wireType int) {
+	buf.EncodeVarint(uint64(field)<<3 | uint64(wireType))
+}
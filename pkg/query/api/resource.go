@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+)
+
+// Headers reporting the resources used by a query.
+const (
+	headerSamplesScanned = "X-Thanos-Samples-Scanned"
+	headerSeriesTouched  = "X-Thanos-Series-Touched"
+	headerFetchedBytes   = "X-Thanos-Fetched-Bytes"
+	headerWallTime       = "X-Thanos-Wall-Time-Seconds"
+)
+
+// withResourceHeaders accounts the resources used by the queries of h and reports them in response headers.
+func (api *API) withResourceHeaders(h http.HandlerFunc) http.HandlerFunc {
+	if !api.resourceHeaders {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := &store.ResourceStats{}
+		rw := &resourceHeaderWriter{
+			ResponseWriter: w,
+			stats:          stats,
+			begin:          api.now(),
+			now:            api.now,
+		}
+		h(rw, r.WithContext(store.WithResourceStats(r.Context(), stats)))
+	}
+}
+
+// resourceHeaderWriter sets the resource headers right before the response header is written,
+// at which point the query has finished.
+type resourceHeaderWriter struct {
+	http.ResponseWriter
+
+	stats       *store.ResourceStats
+	begin       time.Time
+	now         func() time.Time
+	wroteHeader bool
+}
+
+func (w *resourceHeaderWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+
+		h := w.Header()
+		h.Set(headerSamplesScanned, strconv.FormatInt(w.stats.SamplesScanned(), 10))
+		h.Set(headerSeriesTouched, strconv.FormatInt(w.stats.SeriesTouched(), 10))
+		h.Set(headerFetchedBytes, strconv.FormatInt(w.stats.FetchedBytes(), 10))
+		h.Set(headerWallTime, strconv.FormatFloat(w.now().Sub(w.begin).Seconds(), 'f', -1, 64))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *resourceHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
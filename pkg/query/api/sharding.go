@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/prometheus/prometheus/promql"
+)
+
+type queryClosersKey struct{}
+
+// queryClosers closes queries once their results have been rendered. Closing a query recycles the points of its
+// result, so the queries of a merged result must stay open until the response is written.
+type queryClosers struct {
+	queries []promql.Query
+}
+
+func withQueryClosers(ctx context.Context, c *queryClosers) context.Context {
+	return context.WithValue(ctx, queryClosersKey{}, c)
+}
+
+// closeAfterResponse closes the query once the response of the request of the context is written. Without
+// closers in the context the query is never closed and its memory left to the garbage collector.
+func closeAfterResponse(ctx context.Context, q promql.Query) {
+	if c, ok := ctx.Value(queryClosersKey{}).(*queryClosers); ok {
+		c.queries = append(c.queries, q)
+	}
+}
+
+func (c *queryClosers) close() {
+	for _, q := range c.queries {
+		q.Close()
+	}
+}
+
+// exec evaluates the query. If query sharding is enabled and the query is an aggregation that can be sharded, it
+// is evaluated in shards concurrently instead, each created by newQuery. The groups of the aggregation are disjoint
+// between shards, so the results of the shards are merged by concatenating them. Stores without sharding support
+// return all series to every shard and the querier drops the series of other shards, as a group must be computed
+// from the series of all stores within a single shard.
+func (api *API) exec(ctx context.Context, qry promql.Query, qs string, newQuery func() (promql.Query, error)) *promql.Result {
+	if !api.features.QuerySharding || api.queryShards <= 1 {
+		return qry.Exec(ctx)
+	}
+	expr, err := promql.ParseExpr(qs)
+	if err != nil {
+		return qry.Exec(ctx)
+	}
+	by, ok := query.ShardingLabels(expr)
+	if !ok {
+		api.shardedQueries.WithLabelValues("false").Inc()
+		return qry.Exec(ctx)
+	}
+	api.shardedQueries.WithLabelValues("true").Inc()
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]*promql.Result, api.queryShards)
+	)
+	for i := 0; i < api.queryShards; i++ {
+		q := qry
+		if i > 0 {
+			if q, err = newQuery(); err != nil {
+				results[i] = &promql.Result{Err: err}
+				continue
+			}
+		}
+		// The merged result refers to the points of all shards.
+		closeAfterResponse(ctx, q)
+
+		wg.Add(1)
+		go func(i int, q promql.Query) {
+			defer wg.Done()
+
+			results[i] = q.Exec(query.WithShard(ctx, int64(i), int64(api.queryShards), by))
+		}(i, q)
+	}
+	wg.Wait()
+
+	return mergeShardResults(results)
+}
+
+// mergeShardResults merges the results of all shards of a query. It returns the first error of any shard.
+func mergeShardResults(results []*promql.Result) *promql.Result {
+	for _, r := range results {
+		if r.Err != nil {
+			return r
+		}
+	}
+	switch v := results[0].Value.(type) {
+	case promql.Vector:
+		for _, r := range results[1:] {
+			v = append(v, r.Value.(promql.Vector)...)
+		}
+		return &promql.Result{Value: v}
+	case promql.Matrix:
+		for _, r := range results[1:] {
+			v = append(v, r.Value.(promql.Matrix)...)
+		}
+		sort.Sort(v)
+		return &promql.Result{Value: v}
+	}
+	// Aggregations always return vectors or matrices.
+	return results[0]
+}
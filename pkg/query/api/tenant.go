@@ -0,0 +1,194 @@
+package v1
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TenantLimits configures the limits every tenant's queries are subject to. A zero value disables a limit.
+type TenantLimits struct {
+	// Header is the HTTP header determining the tenant of a query.
+	Header string
+	// DefaultTenant is the tenant of queries without a tenant header.
+	DefaultTenant string
+	// MaxConcurrent is the maximum number of queries of a tenant processed concurrently.
+	MaxConcurrent int
+	// QueriesPerSecond is the rate of queries a tenant may start. Bursts of up to one second worth of queries are allowed.
+	QueriesPerSecond float64
+	// MaxSamples is the maximum number of samples a single query may fetch from the store APIs.
+	MaxSamples int64
+	// MaxTenants is the maximum number of tenants tracked at once. Queries of further tenants are rejected
+	// until tenants that were idle for tenantIdleTimeout are evicted.
+	MaxTenants int
+}
+
+const (
+	// tenantIdleTimeout is the time without queries after which a tenant is evicted along with its metrics.
+	// By then its rate limit allows a full burst again, so evicting it does not loosen its limits.
+	tenantIdleTimeout = 10 * time.Minute
+	// tenantEvictInterval is the minimum time between two scans for idle tenants.
+	tenantEvictInterval = time.Minute
+)
+
+type tenantState struct {
+	inflight int
+	tokens   float64
+	updated  time.Time
+	// seen is the time of the last query of the tenant.
+	seen time.Time
+}
+
+// tenantLimiter enforces TenantLimits so that the heavy queries of one tenant do not starve the others.
+type tenantLimiter struct {
+	limits TenantLimits
+	now    func() time.Time
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantState
+	evicted time.Time
+
+	queries  *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+}
+
+// tenant returns the tenant of the request.
+func (l TenantLimits) tenant(r *http.Request) string {
+	if t := r.Header.Get(l.Header); t != "" {
+		return t
+	}
+	return l.DefaultTenant
+}
+
+// enabled returns true if any limit is set. The maximum number of tenants only bounds the tenants tracked
+// for the other limits.
+func (l TenantLimits) enabled() bool {
+	return l.MaxConcurrent > 0 || l.QueriesPerSecond > 0 || l.MaxSamples > 0
+}
+
+// newTenantLimiter returns a limiter enforcing the given limits, or nil if no limit is set.
+func newTenantLimiter(reg prometheus.Registerer, limits TenantLimits) *tenantLimiter {
+	if !limits.enabled() {
+		return nil
+	}
+	l := &tenantLimiter{
+		limits:  limits,
+		now:     time.Now,
+		tenants: map[string]*tenantState{},
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_tenant_queries_total",
+			Help: "Total number of queries per tenant.",
+		}, []string{"tenant"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_query_tenant_rejected_queries_total",
+			Help: "Total number of queries per tenant rejected because they exceeded a limit.",
+		}, []string{"tenant", "reason"}),
+	}
+	if reg != nil {
+		reg.MustRegister(l.queries, l.rejected)
+	}
+	return l
+}
+
+// acquire admits a query of the tenant. On success the returned function must be called once the query is done.
+// Otherwise the reason of the rejection is returned.
+func (l *tenantLimiter) acquire(tenant string) (release func(), reason string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	l.evictIdle(now)
+
+	s, ok := l.tenants[tenant]
+	if !ok {
+		if l.limits.MaxTenants > 0 && len(l.tenants) >= l.limits.MaxTenants {
+			// The tenant is not tracked, so it is not used as a label either.
+			l.rejected.WithLabelValues("", "tenants").Inc()
+			return nil, "tenants"
+		}
+		s = &tenantState{tokens: l.burst(), updated: now}
+		l.tenants[tenant] = s
+	}
+	s.seen = now
+	l.queries.WithLabelValues(tenant).Inc()
+
+	if l.limits.MaxConcurrent > 0 && s.inflight >= l.limits.MaxConcurrent {
+		l.rejected.WithLabelValues(tenant, "concurrency").Inc()
+		return nil, "concurrency"
+	}
+	if l.limits.QueriesPerSecond > 0 {
+		s.tokens = math.Min(l.burst(), s.tokens+now.Sub(s.updated).Seconds()*l.limits.QueriesPerSecond)
+		s.updated = now
+
+		if s.tokens < 1 {
+			l.rejected.WithLabelValues(tenant, "rate").Inc()
+			return nil, "rate"
+		}
+		s.tokens--
+	}
+	s.inflight++
+
+	return func() {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		s.inflight--
+	}, ""
+}
+
+// evictIdle forgets the tenants without queries in flight that were idle for the tenant idle timeout, along with
+// their metrics, so that the tenants of an untrusted header do not accumulate. The lock must be held.
+func (l *tenantLimiter) evictIdle(now time.Time) {
+	if now.Sub(l.evicted) < tenantEvictInterval {
+		return
+	}
+	l.evicted = now
+
+	for tenant, s := range l.tenants {
+		if s.inflight > 0 || now.Sub(s.seen) < tenantIdleTimeout {
+			continue
+		}
+		delete(l.tenants, tenant)
+		l.queries.DeleteLabelValues(tenant)
+		for _, reason := range []string{"concurrency", "rate", "samples"} {
+			l.rejected.DeleteLabelValues(tenant, reason)
+		}
+	}
+}
+
+func (l *tenantLimiter) burst() float64 {
+	return math.Max(1, l.limits.QueriesPerSecond)
+}
+
+// limit wraps f so that it is only executed within the limits of the request's tenant.
+func (l *tenantLimiter) limit(f apiFunc) apiFunc {
+	return func(r *http.Request) (interface{}, []error, *apiError) {
+		tenant := l.limits.tenant(r)
+
+		release, reason := l.acquire(tenant)
+		if release == nil && reason == "tenants" {
+			return nil, nil, &apiError{errorTooManyRequests, errors.Errorf("tenant %q rejected, queries of %d other tenants are tracked", tenant, l.limits.MaxTenants)}
+		}
+		if release == nil {
+			return nil, nil, &apiError{errorTooManyRequests, errors.Errorf("tenant %q exceeded its %s limit", tenant, reason)}
+		}
+		defer release()
+
+		if l.limits.MaxSamples <= 0 {
+			return f(r)
+		}
+		sl := query.NewSampleLimiter(l.limits.MaxSamples)
+
+		data, warnings, apiErr := f(r.WithContext(query.WithSampleLimiter(r.Context(), sl)))
+		if apiErr != nil && sl.Exceeded() {
+			l.rejected.WithLabelValues(tenant, "samples").Inc()
+			return nil, nil, &apiError{errorTooManyRequests, fmt.Errorf("tenant %q exceeded its samples limit: %s", tenant, apiErr.err)}
+		}
+		return data, warnings, apiErr
+	}
+}
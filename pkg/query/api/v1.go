@@ -29,7 +29,11 @@ import (
 	"github.com/NYTimes/gziphandler"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -52,17 +56,18 @@ const (
 type errorType string
 
 const (
-	errorNone     errorType = ""
-	errorTimeout            = "timeout"
-	errorCanceled           = "canceled"
-	errorExec               = "execution"
-	errorBadData            = "bad_data"
-	errorInternal           = "internal"
+	errorNone            errorType = ""
+	errorTimeout                   = "timeout"
+	errorCanceled                  = "canceled"
+	errorExec                      = "execution"
+	errorBadData                   = "bad_data"
+	errorInternal                  = "internal"
+	errorTooManyRequests           = "too_many_requests"
 )
 
 var corsHeaders = map[string]string{
 	"Access-Control-Allow-Headers":  "Accept, Accept-Encoding, Authorization, Content-Type, Origin",
-	"Access-Control-Allow-Methods":  "GET, OPTIONS",
+	"Access-Control-Allow-Methods":  "GET, POST, OPTIONS",
 	"Access-Control-Allow-Origin":   "*",
 	"Access-Control-Expose-Headers": "Date",
 }
@@ -82,6 +87,24 @@ type response struct {
 	ErrorType errorType   `json:"errorType,omitempty"`
 	Error     string      `json:"error,omitempty"`
 	Warnings  []string    `json:"warnings,omitempty"`
+	// StoreWarnings are the warnings caused by a failing store. They are part of Warnings as well.
+	StoreWarnings []storeWarning `json:"storeWarnings,omitempty"`
+}
+
+// storeWarning is a partial response warning caused by a failing store. It identifies the store and
+// the category of its error, so clients can tell which part of the result is missing.
+type storeWarning struct {
+	Message  string `json:"message"`
+	Store    string `json:"store"`
+	Category string `json:"category,omitempty"`
+}
+
+func (r *response) addWarning(err error) {
+	msg := err.Error()
+	r.Warnings = append(r.Warnings, msg)
+	if sw, ok := storepb.ParseStoreWarning(msg); ok {
+		r.StoreWarnings = append(r.StoreWarnings, storeWarning{Message: msg, Store: sw.Store, Category: sw.Category})
+	}
 }
 
 // Enables cross-site script calls.
@@ -98,10 +121,39 @@ type apiFunc func(r *http.Request) (interface{}, []error, *apiError)
 type API struct {
 	queryableCreate query.QueryableCreator
 	queryEngine     *promql.Engine
+	// store serves requests that are not answered through PromQL, like metadata.
+	store storepb.StoreServer
+	// defaultDedup decides whether queries are deduplicated if they have no 'dedup' parameter.
+	defaultDedup bool
 
 	instantQueryDuration prometheus.Histogram
 	rangeQueryDuration   prometheus.Histogram
 
+	// tenantLimits determine the tenant of queries.
+	tenantLimits TenantLimits
+	// tenants limits the queries of each tenant. Queries are not limited if it is nil.
+	tenants *tenantLimiter
+	// resourceHeaders enables headers reporting the resources used by each query.
+	resourceHeaders bool
+	// accessLogger logs every request. Requests are not logged if it is nil.
+	accessLogger log.Logger
+	// features are the enabled experimental features.
+	features query.Features
+	// queryShards is the number of shards aggregations are evaluated in if query sharding is enabled.
+	queryShards    int
+	shardedQueries *prometheus.CounterVec
+	// clientCanceledQueries counts queries abandoned because the client disconnected.
+	clientCanceledQueries prometheus.Counter
+	// defaultStep is the step of range queries without a step parameter. The parameter is required if it is 0.
+	defaultStep time.Duration
+	// maxPoints is the number of points per series above which the step of range queries is increased.
+	// Steps are not adjusted if it is 0.
+	maxPoints     int
+	adjustedSteps prometheus.Counter
+	// estimator rejects queries estimated to select too many series before executing them. Queries are not
+	// estimated if it is nil.
+	estimator *query.SeriesEstimator
+
 	now func() time.Time
 }
 
@@ -110,6 +162,16 @@ func NewAPI(
 	reg *prometheus.Registry,
 	qe *promql.Engine,
 	c query.QueryableCreator,
+	store storepb.StoreServer,
+	defaultDedup bool,
+	tenantLimits TenantLimits,
+	resourceHeaders bool,
+	accessLogger log.Logger,
+	features query.Features,
+	queryShards int,
+	defaultStep time.Duration,
+	maxPoints int,
+	estimator *query.SeriesEstimator,
 ) *API {
 	instantQueryDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name: "thanos_query_api_instant_query_duration_seconds",
@@ -126,16 +188,48 @@ func NewAPI(
 		},
 	})
 
+	shardedQueries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_query_api_sharding_queries_total",
+		Help: "Total number of queries checked for query sharding, partitioned by whether they were evaluated in shards.",
+	}, []string{"sharded"})
+
+	clientCanceledQueries := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_api_client_canceled_queries_total",
+		Help: "Total number of queries canceled because the client disconnected before they finished.",
+	})
+
+	adjustedSteps := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_api_range_query_step_adjusted_total",
+		Help: "Total number of range queries whose step was increased to not exceed the maximum number of points per series.",
+	})
+
 	reg.MustRegister(
 		instantQueryDuration,
 		rangeQueryDuration,
+		shardedQueries,
+		clientCanceledQueries,
+		adjustedSteps,
 	)
 	return &API{
-		queryEngine:          qe,
-		queryableCreate:      c,
-		instantQueryDuration: instantQueryDuration,
-		rangeQueryDuration:   rangeQueryDuration,
-		now:                  time.Now,
+		queryEngine:           qe,
+		queryableCreate:       c,
+		store:                 store,
+		defaultDedup:          defaultDedup,
+		instantQueryDuration:  instantQueryDuration,
+		rangeQueryDuration:    rangeQueryDuration,
+		tenantLimits:          tenantLimits,
+		tenants:               newTenantLimiter(reg, tenantLimits),
+		resourceHeaders:       resourceHeaders,
+		accessLogger:          accessLogger,
+		features:              features,
+		queryShards:           queryShards,
+		shardedQueries:        shardedQueries,
+		clientCanceledQueries: clientCanceledQueries,
+		defaultStep:           defaultStep,
+		maxPoints:             maxPoints,
+		adjustedSteps:         adjustedSteps,
+		estimator:             estimator,
+		now:                   time.Now,
 	}
 }
 
@@ -144,31 +238,79 @@ func (api *API) Register(r *route.Router, tracer opentracing.Tracer, logger log.
 	instr := func(name string, f apiFunc) http.HandlerFunc {
 		hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			setCORS(w)
+
+			var closers queryClosers
+			defer closers.close()
+			r = r.WithContext(withQueryClosers(r.Context(), &closers))
+
 			if data, warnings, err := f(r); err != nil {
 				respondError(w, err, data)
+			} else if m, ok := matrixResult(data); ok && acceptsColumnar(r) {
+				if err := respondColumnar(w, m, warnings); err != nil {
+					level.Warn(logger).Log("msg", "writing columnar response failed", "err", err)
+				}
 			} else if data != nil {
 				respond(w, data, warnings)
 			} else {
 				w.WriteHeader(http.StatusNoContent)
 			}
 		})
-		return prometheus.InstrumentHandler(name, tracing.HTTPMiddleware(tracer, name, logger, gziphandler.GzipHandler(hf)))
+		return api.withAccessLog(prometheus.InstrumentHandler(name, tracing.HTTPMiddleware(tracer, name, logger, gziphandler.GzipHandler(hf))))
 	}
 
 	r.Options("/*path", instr("options", api.options))
 
-	r.Get("/query", instr("query", api.query))
-	r.Get("/query_range", instr("query_range", api.queryRange))
+	queryHandler := api.withResourceHeaders(instr("query", api.withTenant(api.limitTenant(api.query))))
+	r.Get("/query", queryHandler)
+	r.Post("/query", queryHandler)
+
+	queryRangeHandler := api.withResourceHeaders(instr("query_range", api.withTenant(api.limitTenant(api.queryRange))))
+	r.Get("/query_range", queryRangeHandler)
+	r.Post("/query_range", queryRangeHandler)
+
+	r.Get("/label/:name/values", instr("label_values", api.withTenant(api.labelValues)))
 
-	r.Get("/label/:name/values", instr("label_values", api.labelValues))
+	r.Get("/series", instr("series", api.withTenant(api.series)))
 
-	r.Get("/series", instr("series", api.series))
+	r.Get("/metadata", instr("metadata", api.withTenant(api.metadata)))
+
+	if api.features.Exemplars {
+		r.Get("/query_exemplars", instr("exemplars", api.withTenant(api.queryExemplars)))
+	}
 }
 
 type queryData struct {
 	ResultType promql.ValueType `json:"resultType"`
 	Result     promql.Value     `json:"result"`
 	Warnings   []error          `json:"warnings,omitempty"`
+	// SourceResolutions are the resolutions of the data each store answered from, if requested.
+	SourceResolutions map[string][]string `json:"sourceResolutions,omitempty"`
+}
+
+// matrixResult returns the result of a query if it is a matrix.
+func matrixResult(data interface{}) (promql.Matrix, bool) {
+	qd, ok := data.(*queryData)
+	if !ok {
+		return nil, false
+	}
+	m, ok := qd.Result.(promql.Matrix)
+	return m, ok
+}
+
+// limitTenant applies the tenant limits to f, if any are configured.
+func (api *API) limitTenant(f apiFunc) apiFunc {
+	if api.tenants == nil {
+		return f
+	}
+	return api.tenants.limit(f)
+}
+
+// withTenant sets the tenant of the request in its context, so that only the stores of the tenant are queried
+// if the stores are selected with store.TenantStores.
+func (api *API) withTenant(f apiFunc) apiFunc {
+	return func(r *http.Request) (interface{}, []error, *apiError) {
+		return f(r.WithContext(store.WithTenant(r.Context(), api.tenantLimits.tenant(r))))
+	}
 }
 
 func (api *API) options(r *http.Request) (interface{}, []error, *apiError) {
@@ -187,22 +329,16 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 		ts = api.now()
 	}
 
-	ctx := r.Context()
-	if to := r.FormValue("timeout"); to != "" {
-		var cancel context.CancelFunc
-		timeout, err := parseDuration(to)
-		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
-		}
-
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		defer cancel()
+	ctx, cancel, timeout, apiErr := requestContext(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
 	}
+	defer cancel()
 
 	var (
 		warnmtx             sync.Mutex
 		warnings            []error
-		enableDeduplication = true
+		enableDeduplication = api.defaultDedup
 	)
 	partialErrReporter := func(err error) {
 		warnmtx.Lock()
@@ -210,7 +346,7 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 		warnmtx.Unlock()
 	}
 
-	// Allow disabling deduplication on demand.
+	// Allow enabling or disabling deduplication on demand.
 	if dedup := r.FormValue("dedup"); dedup != "" {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
@@ -218,19 +354,40 @@ func (api *API) query(r *http.Request) (interface{}, []error, *apiError) {
 			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
 		}
 	}
+	// Raw data is never deduplicated.
+	raw, apiErr := rawParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if raw {
+		enableDeduplication = false
+	}
 
 	// We are starting promQL tracing span here, because we have no control over promQL code.
-	span, ctx := tracing.StartSpan(r.Context(), "promql_instant_query")
+	span, ctx := tracing.StartSpan(ctx, "promql_instant_query")
 	defer span.Finish()
 
+	if err := api.estimator.Check(ctx, r.FormValue("query"), ts, ts, 0); err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+
 	begin := api.now()
-	qry, err := api.queryEngine.NewInstantQuery(api.queryableCreate(enableDeduplication, 0, partialErrReporter), r.FormValue("query"), ts)
+	queryable := api.queryableCreate(enableDeduplication, 0, partialErrReporter)
+	qry, err := api.queryEngine.NewInstantQuery(queryable, r.FormValue("query"), ts)
 	if err != nil {
 		return nil, nil, &apiError{errorBadData, err}
 	}
 
-	res := qry.Exec(ctx)
+	res := api.exec(ctx, qry, r.FormValue("query"), func() (promql.Query, error) {
+		return api.queryEngine.NewInstantQuery(queryable, r.FormValue("query"), ts)
+	})
 	if res.Err != nil {
+		if api.canceledByClient(r) {
+			return nil, nil, &apiError{errorCanceled, res.Err}
+		}
+		if timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+			return nil, nil, &apiError{errorTimeout, errors.Wrapf(res.Err, "query exceeded its timeout of %s", timeout)}
+		}
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
 			return nil, nil, &apiError{errorCanceled, res.Err}
@@ -263,9 +420,12 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 		return nil, nil, &apiError{errorBadData, err}
 	}
 
-	step, err := parseDuration(r.FormValue("step"))
-	if err != nil {
-		return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param step")}
+	step := api.defaultStep
+	if val := r.FormValue("step"); val != "" || step == 0 {
+		step, err = parseDuration(val)
+		if err != nil {
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param step")}
+		}
 	}
 
 	if step <= 0 {
@@ -273,12 +433,38 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 		return nil, nil, &apiError{errorBadData, err}
 	}
 
-	maxSourceResolution := step / 5 // By default fit at least 5 samples between steps.
+	// Increase steps that would return more points than allowed, instead of rejecting the query.
+	// The adjustment is reported as a warning, so that clients can detect it.
+	var stepWarning error
+	if adjusted, ok := adjustStep(end.Sub(start), step, api.maxPoints); ok {
+		stepWarning = errors.Errorf("query resolution step increased from %s to %s to not exceed %d points per series", step, adjusted, api.maxPoints)
+		step = adjusted
+		api.adjustedSteps.Inc()
+	}
+
+	raw, apiErr := rawParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	maxSourceResolution := defaultMaxSourceResolution(r.FormValue("query"), step)
 	if val := r.FormValue("max_source_resolution"); val != "" {
 		maxSourceResolution, err = parseDuration(val)
 		if err != nil {
 			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "param max_source_resolution")}
 		}
+		if raw && maxSourceResolution != 0 {
+			return nil, nil, &apiError{errorBadData, errors.New("'raw' parameter cannot be combined with a non-zero max_source_resolution")}
+		}
+	}
+
+	// Raw queries over ranges that would be answered from downsampled data are likely accidental and expensive.
+	var rawWarning error
+	if raw {
+		if maxSourceResolution >= time.Duration(downsample.ResLevel1)*time.Millisecond {
+			rawWarning = errors.Errorf("raw data is read although the step of %s allows to read downsampled data of up to %s resolution, the query may fetch many samples", step, maxSourceResolution)
+		}
+		maxSourceResolution = 0
 	}
 
 	if maxSourceResolution < 0 {
@@ -292,30 +478,30 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 		err := errors.New("exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)")
 		return nil, nil, &apiError{errorBadData, err}
 	}
-	ctx := r.Context()
-	if to := r.FormValue("timeout"); to != "" {
-		var cancel context.CancelFunc
-		timeout, err := parseDuration(to)
-		if err != nil {
-			return nil, nil, &apiError{errorBadData, err}
-		}
-
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		defer cancel()
+	ctx, cancel, timeout, apiErr := requestContext(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
 	}
+	defer cancel()
 
 	var (
 		warnmtx             sync.Mutex
 		warnings            []error
-		enableDeduplication = true
+		enableDeduplication = api.defaultDedup
 	)
 	partialErrReporter := func(err error) {
 		warnmtx.Lock()
 		warnings = append(warnings, err)
 		warnmtx.Unlock()
 	}
+	if stepWarning != nil {
+		warnings = append(warnings, stepWarning)
+	}
+	if rawWarning != nil {
+		warnings = append(warnings, rawWarning)
+	}
 
-	// Allow disabling deduplication on demand.
+	// Allow enabling or disabling deduplication on demand.
 	if dedup := r.FormValue("dedup"); dedup != "" {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
@@ -323,25 +509,49 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
 		}
 	}
+	// Raw data is never deduplicated.
+	if raw {
+		enableDeduplication = false
+	}
+
+	// Optionally record the resolutions of the data the stores answer from, to debug downsampling.
+	var sourceResolutions *store.SourceResolutions
+	if debug := r.FormValue("debug_source_resolution"); debug != "" {
+		enabled, err := strconv.ParseBool(debug)
+		if err != nil {
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'debug_source_resolution' parameter")}
+		}
+		if enabled {
+			sourceResolutions = &store.SourceResolutions{}
+			ctx = store.WithSourceResolutions(ctx, sourceResolutions)
+		}
+	}
 
 	// We are starting promQL tracing span here, because we have no control over promQL code.
-	span, ctx := tracing.StartSpan(r.Context(), "promql_range_query")
+	span, ctx := tracing.StartSpan(ctx, "promql_range_query")
 	defer span.Finish()
 
+	if err := api.estimator.Check(ctx, r.FormValue("query"), start, end, maxSourceResolution); err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+
 	begin := api.now()
-	qry, err := api.queryEngine.NewRangeQuery(
-		api.queryableCreate(enableDeduplication, maxSourceResolution, partialErrReporter),
-		r.FormValue("query"),
-		start,
-		end,
-		step,
-	)
+	queryable := api.queryableCreate(enableDeduplication, maxSourceResolution, partialErrReporter)
+	qry, err := api.queryEngine.NewRangeQuery(queryable, r.FormValue("query"), start, end, step)
 	if err != nil {
 		return nil, nil, &apiError{errorBadData, err}
 	}
 
-	res := qry.Exec(ctx)
+	res := api.exec(ctx, qry, r.FormValue("query"), func() (promql.Query, error) {
+		return api.queryEngine.NewRangeQuery(queryable, r.FormValue("query"), start, end, step)
+	})
 	if res.Err != nil {
+		if api.canceledByClient(r) {
+			return nil, nil, &apiError{errorCanceled, res.Err}
+		}
+		if timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+			return nil, nil, &apiError{errorTimeout, errors.Wrapf(res.Err, "query exceeded its timeout of %s", timeout)}
+		}
 		switch res.Err.(type) {
 		case promql.ErrQueryCanceled:
 			return nil, nil, &apiError{errorCanceled, res.Err}
@@ -352,10 +562,70 @@ func (api *API) queryRange(r *http.Request) (interface{}, []error, *apiError) {
 	}
 	api.rangeQueryDuration.Observe(time.Since(begin).Seconds())
 
-	return &queryData{
+	data := &queryData{
 		ResultType: res.Value.Type(),
 		Result:     res.Value,
-	}, warnings, nil
+	}
+	if sourceResolutions != nil {
+		data.SourceResolutions = formatSourceResolutions(sourceResolutions.ByStore())
+	}
+	return data, warnings, nil
+}
+
+// formatSourceResolutions formats the resolutions of each store as durations, e.g. "0s" for raw data.
+func formatSourceResolutions(byStore map[string][]int64) map[string][]string {
+	res := make(map[string][]string, len(byStore))
+	for st, rs := range byStore {
+		for _, r := range rs {
+			res[st] = append(res[st], (time.Duration(r) * time.Millisecond).String())
+		}
+	}
+	return res
+}
+
+// canceledByClient returns true and counts the query if the client disconnected before the query finished.
+// The canceled request context stops the evaluation and the Series requests to all stores.
+func (api *API) canceledByClient(r *http.Request) bool {
+	if r.Context().Err() != context.Canceled {
+		return false
+	}
+	api.clientCanceledQueries.Inc()
+	return true
+}
+
+// adjustStep returns the smallest step that results in at most maxPoints points per series for
+// the given range, if the given step results in more. Adjusted steps are rounded up to full seconds.
+// Steps are never adjusted if maxPoints is 0.
+func adjustStep(rng, step time.Duration, maxPoints int) (time.Duration, bool) {
+	if maxPoints <= 0 || rng/step <= time.Duration(maxPoints) {
+		return step, false
+	}
+	adjusted := (rng + time.Duration(maxPoints) - 1) / time.Duration(maxPoints)
+	adjusted = (adjusted + time.Second - 1) / time.Second * time.Second
+	return adjusted, true
+}
+
+// defaultMaxSourceResolution returns the maximum downsampling resolution to use for a range
+// query with the given step if none was requested explicitly. By default we fit at least 5
+// samples between steps and into every range selector of the query, so functions like rate()
+// do not end up with too few samples per window.
+// Offsets only shift the evaluated window and do not change the needed resolution. The range
+// passed to the querier already accounts for them.
+func defaultMaxSourceResolution(query string, step time.Duration) time.Duration {
+	res := step / 5
+
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		// Let the engine report the parsing error.
+		return res
+	}
+	promql.Inspect(expr, func(node promql.Node) bool {
+		if ms, ok := node.(*promql.MatrixSelector); ok && ms.Range/5 < res {
+			res = ms.Range / 5
+		}
+		return true
+	})
+	return res
 }
 
 func (api *API) labelValues(r *http.Request) (interface{}, []error, *apiError) {
@@ -437,7 +707,7 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 	var (
 		warnmtx             sync.Mutex
 		warnings            []error
-		enableDeduplication = true
+		enableDeduplication = api.defaultDedup
 	)
 	partialErrReporter := func(err error) {
 		warnmtx.Lock()
@@ -445,7 +715,7 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 		warnmtx.Unlock()
 	}
 
-	// Allow disabling deduplication on demand.
+	// Allow enabling or disabling deduplication on demand.
 	if dedup := r.FormValue("dedup"); dedup != "" {
 		var err error
 		enableDeduplication, err = strconv.ParseBool(dedup)
@@ -453,6 +723,14 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'dedup' parameter")}
 		}
 	}
+	// Raw data is never deduplicated.
+	raw, apiErr := rawParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if raw {
+		enableDeduplication = false
+	}
 
 	q, err := api.queryableCreate(enableDeduplication, 0, partialErrReporter).Querier(r.Context(), timestamp.FromTime(start), timestamp.FromTime(end))
 	if err != nil {
@@ -481,6 +759,114 @@ func (api *API) series(r *http.Request) (interface{}, []error, *apiError) {
 	return metrics, warnings, nil
 }
 
+type metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// metadata returns the metric metadata of all stores by metric name. If stores disagree
+// on the metadata of a metric, all variants are returned.
+func (api *API) metadata(r *http.Request) (interface{}, []error, *apiError) {
+	limit := -1
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			return nil, nil, &apiError{errorBadData, errors.Wrap(err, "'limit' parameter")}
+		}
+	}
+
+	resp, err := api.store.MetricMetadata(r.Context(), &storepb.MetricMetadataRequest{
+		Metric: r.FormValue("metric"),
+	})
+	if err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+	var warnings []error
+	for _, w := range resp.Warnings {
+		warnings = append(warnings, errors.New(w))
+	}
+
+	res := map[string][]metadata{}
+	for _, m := range resp.Metadata {
+		if _, ok := res[m.Metric]; !ok && limit >= 0 && len(res) >= limit {
+			continue
+		}
+		res[m.Metric] = append(res[m.Metric], metadata{Type: m.Type, Help: m.Help, Unit: m.Unit})
+	}
+	return res, warnings, nil
+}
+
+type exemplarData struct {
+	SeriesLabels labels.Labels `json:"seriesLabels"`
+	Exemplars    []exemplar    `json:"exemplars"`
+}
+
+type exemplar struct {
+	Labels    labels.Labels     `json:"labels"`
+	Value     model.SampleValue `json:"value"`
+	Timestamp model.Time        `json:"timestamp"`
+}
+
+// queryExemplars returns the exemplars of the series selected by the query from all stores.
+func (api *API) queryExemplars(r *http.Request) (interface{}, []error, *apiError) {
+	start, end := minTime, maxTime
+	if t := r.FormValue("start"); t != "" {
+		var err error
+		if start, err = parseTime(t); err != nil {
+			return nil, nil, &apiError{errorBadData, err}
+		}
+	}
+	if t := r.FormValue("end"); t != "" {
+		var err error
+		if end, err = parseTime(t); err != nil {
+			return nil, nil, &apiError{errorBadData, err}
+		}
+	}
+	if end.Before(start) {
+		return nil, nil, &apiError{errorBadData, errors.New("end timestamp must not be before start timestamp")}
+	}
+	query := r.FormValue("query")
+	if _, err := promql.ParseExpr(query); err != nil {
+		return nil, nil, &apiError{errorBadData, err}
+	}
+
+	resp, err := api.store.Exemplars(r.Context(), &storepb.ExemplarsRequest{
+		Query:   query,
+		MinTime: timestamp.FromTime(start),
+		MaxTime: timestamp.FromTime(end),
+	})
+	if err != nil {
+		return nil, nil, &apiError{errorExec, err}
+	}
+	var warnings []error
+	for _, w := range resp.Warnings {
+		warnings = append(warnings, errors.New(w))
+	}
+
+	res := make([]exemplarData, 0, len(resp.Data))
+	for _, d := range resp.Data {
+		ed := exemplarData{SeriesLabels: translateLabels(d.SeriesLabels)}
+		for _, e := range d.Exemplars {
+			ed.Exemplars = append(ed.Exemplars, exemplar{
+				Labels:    translateLabels(e.Labels),
+				Value:     model.SampleValue(e.Value),
+				Timestamp: model.Time(e.Ts),
+			})
+		}
+		res = append(res, ed)
+	}
+	return res, warnings, nil
+}
+
+func translateLabels(lset []storepb.Label) labels.Labels {
+	res := make(labels.Labels, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
 func respond(w http.ResponseWriter, data interface{}, warnings []error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -490,7 +876,7 @@ func respond(w http.ResponseWriter, data interface{}, warnings []error) {
 		Data:   data,
 	}
 	for _, warn := range warnings {
-		resp.Warnings = append(resp.Warnings, warn.Error())
+		resp.addWarning(warn)
 	}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -508,6 +894,8 @@ func respondError(w http.ResponseWriter, apiErr *apiError, data interface{}) {
 		code = http.StatusServiceUnavailable
 	case errorInternal:
 		code = http.StatusInternalServerError
+	case errorTooManyRequests:
+		code = http.StatusTooManyRequests
 	default:
 		code = http.StatusInternalServerError
 	}
@@ -532,6 +920,41 @@ func parseTime(s string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
 }
 
+// rawParam parses the 'raw' parameter. Raw queries return the data as the stores hold it, neither deduplicated
+// nor read from downsampled data, e.g. to compare it with the source Prometheus server.
+func rawParam(r *http.Request) (bool, *apiError) {
+	val := r.FormValue("raw")
+	if val == "" {
+		return false, nil
+	}
+	raw, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, &apiError{errorBadData, errors.Wrap(err, "'raw' parameter")}
+	}
+	if dedup, err := strconv.ParseBool(r.FormValue("dedup")); raw && err == nil && dedup {
+		return false, &apiError{errorBadData, errors.New("'raw' parameter cannot be combined with dedup=true")}
+	}
+	return raw, nil
+}
+
+// requestContext returns the context of the request with the deadline set by its timeout parameter, if any.
+// Timeouts beyond the query timeout of the engine have no effect, as the engine aborts the query before.
+func requestContext(r *http.Request) (context.Context, context.CancelFunc, time.Duration, *apiError) {
+	to := r.FormValue("timeout")
+	if to == "" {
+		return r.Context(), func() {}, 0, nil
+	}
+	timeout, err := parseDuration(to)
+	if err != nil {
+		return nil, nil, 0, &apiError{errorBadData, errors.Wrap(err, "'timeout' parameter")}
+	}
+	if timeout <= 0 {
+		return nil, nil, 0, &apiError{errorBadData, errors.New("'timeout' parameter must be positive")}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, timeout, nil
+}
+
 func parseDuration(s string) (time.Duration, error) {
 	if d, err := strconv.ParseFloat(s, 64); err == nil {
 		ts := d * float64(time.Second)
@@ -14,23 +14,30 @@
 package v1
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/common/route"
 
 	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
 	"github.com/improbable-eng/thanos/pkg/query"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/opentracing/opentracing-go"
 	"github.com/prometheus/client_golang/prometheus"
@@ -216,6 +223,56 @@ func TestEndpoints(t *testing.T) {
 			},
 			errType: errorBadData,
 		},
+		// Raw data cannot be deduplicated or downsampled.
+		{
+			endpoint: api.query,
+			query: url.Values{
+				"query": []string{"2"},
+				"raw":   []string{"true"},
+				"dedup": []string{"true"},
+			},
+			errType: errorBadData,
+		},
+		{
+			endpoint: api.queryRange,
+			query: url.Values{
+				"query":                 []string{"time()"},
+				"start":                 []string{"0"},
+				"end":                   []string{"2"},
+				"step":                  []string{"1"},
+				"raw":                   []string{"true"},
+				"max_source_resolution": []string{"1h"},
+			},
+			errType: errorBadData,
+		},
+		{
+			endpoint: api.query,
+			query: url.Values{
+				"query": []string{"2"},
+				"raw":   []string{"sdfsf"},
+			},
+			errType: errorBadData,
+		},
+		// Invalid timeouts.
+		{
+			endpoint: api.query,
+			query: url.Values{
+				"query":   []string{"2"},
+				"timeout": []string{"sdfsf"},
+			},
+			errType: errorBadData,
+		},
+		{
+			endpoint: api.queryRange,
+			query: url.Values{
+				"query":   []string{"time()"},
+				"start":   []string{"0"},
+				"end":     []string{"2"},
+				"step":    []string{"1"},
+				"timeout": []string{"0"},
+			},
+			errType: errorBadData,
+		},
 		// Invalid step.
 		{
 			endpoint: api.queryRange,
@@ -261,6 +318,18 @@ func TestEndpoints(t *testing.T) {
 			},
 			errType: errorBadData,
 		},
+		// Bad debug_source_resolution parameter.
+		{
+			endpoint: api.queryRange,
+			query: url.Values{
+				"query":                   []string{"time()"},
+				"start":                   []string{"0"},
+				"end":                     []string{"2"},
+				"step":                    []string{"1"},
+				"debug_source_resolution": []string{"yes please"},
+			},
+			errType: errorBadData,
+		},
 		{
 			endpoint: api.labelValues,
 			params: map[string]string{
@@ -439,6 +508,129 @@ func TestEndpoints(t *testing.T) {
 	}
 }
 
+type metadataStore struct {
+	storepb.StoreServer
+	resp *storepb.MetricMetadataResponse
+}
+
+func (s *metadataStore) MetricMetadata(_ context.Context, r *storepb.MetricMetadataRequest) (*storepb.MetricMetadataResponse, error) {
+	resp := &storepb.MetricMetadataResponse{Warnings: s.resp.Warnings}
+	for _, m := range s.resp.Metadata {
+		if r.Metric == "" || r.Metric == m.Metric {
+			resp.Metadata = append(resp.Metadata, m)
+		}
+	}
+	return resp, nil
+}
+
+func TestMetadata(t *testing.T) {
+	api := &API{
+		store: &metadataStore{resp: &storepb.MetricMetadataResponse{
+			Metadata: []storepb.MetricMetadata{
+				{Metric: "http_requests_total", Type: "counter", Help: "Number of requests."},
+				{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests."},
+				{Metric: "up", Type: "gauge", Help: "Target is up."},
+			},
+			Warnings: []string{"store a [unavailable]: connection refused"},
+		}},
+	}
+
+	for _, c := range []struct {
+		query    url.Values
+		response map[string][]metadata
+	}{
+		{
+			query: url.Values{},
+			response: map[string][]metadata{
+				"http_requests_total": {
+					{Type: "counter", Help: "Number of requests."},
+					{Type: "counter", Help: "Total HTTP requests."},
+				},
+				"up": {{Type: "gauge", Help: "Target is up."}},
+			},
+		},
+		{
+			query: url.Values{"metric": []string{"up"}},
+			response: map[string][]metadata{
+				"up": {{Type: "gauge", Help: "Target is up."}},
+			},
+		},
+		{
+			query: url.Values{"limit": []string{"1"}},
+			response: map[string][]metadata{
+				"http_requests_total": {
+					{Type: "counter", Help: "Number of requests."},
+					{Type: "counter", Help: "Total HTTP requests."},
+				},
+			},
+		},
+		{
+			query:    url.Values{"metric": []string{"unknown"}},
+			response: map[string][]metadata{},
+		},
+	} {
+		req, err := http.NewRequest("GET", "http://example.com?"+c.query.Encode(), nil)
+		testutil.Ok(t, err)
+
+		resp, warnings, apiErr := api.metadata(req)
+		testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+		testutil.Equals(t, c.response, resp)
+		testutil.Equals(t, 1, len(warnings))
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com?limit=a", nil)
+	testutil.Ok(t, err)
+	_, _, apiErr := api.metadata(req)
+	testutil.Equals(t, errorBadData, apiErr.typ)
+}
+
+type exemplarStore struct {
+	storepb.StoreServer
+	req  *storepb.ExemplarsRequest
+	resp *storepb.ExemplarsResponse
+}
+
+func (s *exemplarStore) Exemplars(_ context.Context, r *storepb.ExemplarsRequest) (*storepb.ExemplarsResponse, error) {
+	s.req = r
+	return s.resp, nil
+}
+
+func TestQueryExemplars(t *testing.T) {
+	st := &exemplarStore{resp: &storepb.ExemplarsResponse{
+		Data: []storepb.ExemplarData{{
+			SeriesLabels: []storepb.Label{{Name: "__name__", Value: "up"}},
+			Exemplars: []storepb.Exemplar{
+				{Labels: []storepb.Label{{Name: "trace_id", Value: "a"}}, Value: 1.5, Ts: 1500},
+			},
+		}},
+	}}
+	api := &API{store: st}
+
+	req, err := http.NewRequest("GET", "http://example.com?query=up&start=1&end=2.5", nil)
+	testutil.Ok(t, err)
+
+	resp, _, apiErr := api.queryExemplars(req)
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, &storepb.ExemplarsRequest{Query: "up", MinTime: 1000, MaxTime: 2500}, st.req)
+	testutil.Equals(t, []exemplarData{{
+		SeriesLabels: labels.FromStrings("__name__", "up"),
+		Exemplars: []exemplar{
+			{Labels: labels.FromStrings("trace_id", "a"), Value: 1.5, Timestamp: 1500},
+		},
+	}}, resp)
+
+	b, err := json.Marshal(resp)
+	testutil.Ok(t, err)
+	testutil.Equals(t, `[{"seriesLabels":{"__name__":"up"},"exemplars":[{"labels":{"trace_id":"a"},"value":"1.5","timestamp":1.5}]}]`, string(b))
+
+	for _, q := range []string{"query=up%7B", "query=up&start=a", "query=up&start=2&end=1"} {
+		req, err := http.NewRequest("GET", "http://example.com?"+q, nil)
+		testutil.Ok(t, err)
+		_, _, apiErr := api.queryExemplars(req)
+		testutil.Equals(t, errorBadData, apiErr.typ)
+	}
+}
+
 func TestRespondSuccess(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		respond(w, "test", nil)
@@ -476,6 +668,132 @@ func TestRespondSuccess(t *testing.T) {
 	}
 }
 
+func TestRespondWarnings(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, "test", []error{
+			storepb.NewStoreWarning("store-1:10901", context.DeadlineExceeded),
+			errors.New("No store matched for this query"),
+		})
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	testutil.Ok(t, err)
+	defer resp.Body.Close()
+
+	var res response
+	testutil.Ok(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	// Warnings stay plain strings, as Prometheus API clients expect.
+	testutil.Equals(t, []string{
+		"store store-1:10901 [timeout]: context deadline exceeded",
+		"No store matched for this query",
+	}, res.Warnings)
+	testutil.Equals(t, []storeWarning{
+		{
+			Message:  "store store-1:10901 [timeout]: context deadline exceeded",
+			Store:    "store-1:10901",
+			Category: storepb.WarningCategoryTimeout,
+		},
+	}, res.StoreWarnings)
+}
+
+func TestRespondColumnar(t *testing.T) {
+	m := promql.Matrix{
+		{
+			Metric: labels.FromStrings("__name__", "up", "job", "a"),
+			Points: []promql.Point{{T: 1000, V: 1}, {T: 2000, V: 0.5}, {T: 3000, V: math.Inf(1)}},
+		},
+		{
+			Metric: labels.FromStrings("__name__", "up", "job", "b"),
+		},
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Ok(t, respondColumnar(w, m, []error{errors.New("No store matched for this query")}))
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	testutil.Ok(t, err)
+	defer resp.Body.Close()
+
+	testutil.Equals(t, columnarContentType, resp.Header.Get("Content-Type"))
+	testutil.Equals(t, []string{"No store matched for this query"}, resp.Header["X-Thanos-Warning"])
+
+	body, err := ioutil.ReadAll(resp.Body)
+	testutil.Ok(t, err)
+
+	var res promql.Matrix
+	for len(body) > 0 {
+		l, n := proto.DecodeVarint(body)
+		testutil.Assert(t, n > 0 && n+int(l) <= len(body), "invalid message length")
+
+		var s promql.Series
+		for _, f := range decodeBytesFields(t, body[n:n+int(l)]) {
+			switch f.num {
+			case 1:
+				var lbl labels.Label
+				for _, lf := range decodeBytesFields(t, f.b) {
+					if lf.num == 1 {
+						lbl.Name = string(lf.b)
+					} else {
+						lbl.Value = string(lf.b)
+					}
+				}
+				s.Metric = append(s.Metric, lbl)
+			case 2:
+				for b := f.b; len(b) > 0; {
+					v, n := proto.DecodeVarint(b)
+					s.Points = append(s.Points, promql.Point{T: int64(v)})
+					b = b[n:]
+				}
+			case 3:
+				for i := 0; i*8 < len(f.b); i++ {
+					s.Points[i].V = math.Float64frombits(binary.LittleEndian.Uint64(f.b[i*8:]))
+				}
+			}
+		}
+		res = append(res, s)
+		body = body[n+int(l):]
+	}
+	testutil.Equals(t, m, res)
+}
+
+type bytesField struct {
+	num int
+	b   []byte
+}
+
+// decodeBytesFields decodes a protobuf message that only has length-delimited fields.
+func decodeBytesFields(t *testing.T, b []byte) (res []bytesField) {
+	for len(b) > 0 {
+		tag, n := proto.DecodeVarint(b)
+		testutil.Equals(t, uint64(proto.WireBytes), tag&7)
+		b = b[n:]
+
+		l, n := proto.DecodeVarint(b)
+		res = append(res, bytesField{num: int(tag >> 3), b: b[n : n+int(l)]})
+		b = b[n+int(l):]
+	}
+	return res
+}
+
+func TestAcceptsColumnar(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		exp    bool
+	}{
+		{accept: "", exp: false},
+		{accept: "application/json", exp: false},
+		{accept: columnarContentType, exp: true},
+		{accept: "application/json;q=0.5, " + columnarContentType + ";q=0.9", exp: true},
+	} {
+		r := httptest.NewRequest("GET", "/api/v1/query_range", nil)
+		r.Header.Set("Accept", tc.accept)
+		testutil.Equals(t, tc.exp, acceptsColumnar(r))
+	}
+}
+
 func TestRespondError(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		respondError(w, &apiError{errorTimeout, errors.New("message")}, "test")
@@ -620,6 +938,52 @@ func TestParseDuration(t *testing.T) {
 	}
 }
 
+func TestDefaultMaxSourceResolution(t *testing.T) {
+	for _, c := range []struct {
+		query    string
+		step     time.Duration
+		expected time.Duration
+	}{
+		{query: "x", step: time.Hour, expected: 12 * time.Minute},
+		{query: "x offset 1d", step: 6 * time.Hour, expected: 72 * time.Minute},
+		// Range selectors cap the resolution so every window still holds enough samples.
+		{query: "rate(x[1h])", step: 6 * time.Hour, expected: 12 * time.Minute},
+		// Offsets only shift the window and must not change the resolution.
+		{query: "rate(x[1h] offset 1d)", step: 6 * time.Hour, expected: 12 * time.Minute},
+		{query: "rate(x[1h] offset 1d)", step: 30 * time.Minute, expected: 6 * time.Minute},
+		{query: "sum(rate(x[1d] offset 1w)) / sum(rate(y[5h]))", step: 12 * time.Hour, expected: time.Hour},
+		// Invalid queries fall back to the step based default.
+		{query: "rate(x[1h]", step: time.Hour, expected: 12 * time.Minute},
+	} {
+		res := defaultMaxSourceResolution(c.query, c.step)
+		if res != c.expected {
+			t.Errorf("Expected max source resolution %v for query %q with step %v but got %v", c.expected, c.query, c.step, res)
+		}
+	}
+}
+
+func TestAdjustStep(t *testing.T) {
+	for _, c := range []struct {
+		rng, step time.Duration
+		maxPoints int
+		expected  time.Duration
+		adjusted  bool
+	}{
+		{rng: 30 * 24 * time.Hour, step: time.Second, maxPoints: 0, expected: time.Second},
+		{rng: time.Hour, step: 15 * time.Second, maxPoints: 1000, expected: 15 * time.Second},
+		{rng: time.Hour, step: 3600 * time.Millisecond, maxPoints: 1000, expected: 3600 * time.Millisecond},
+		{rng: 30 * 24 * time.Hour, step: time.Second, maxPoints: 11000, expected: 236 * time.Second, adjusted: true},
+		// Adjusted steps are rounded up to full seconds.
+		{rng: 100 * time.Second, step: 10 * time.Millisecond, maxPoints: 1000, expected: time.Second, adjusted: true},
+	} {
+		step, adjusted := adjustStep(c.rng, c.step, c.maxPoints)
+		if step != c.expected || adjusted != c.adjusted {
+			t.Errorf("Expected step %v (adjusted %v) for range %v with step %v and %d max points but got %v (adjusted %v)",
+				c.expected, c.adjusted, c.rng, c.step, c.maxPoints, step, adjusted)
+		}
+	}
+}
+
 func TestOptionsMethod(t *testing.T) {
 	r := route.New()
 	api := &API{}
@@ -683,3 +1047,177 @@ func BenchmarkQueryResultEncoding(b *testing.B) {
 	testutil.Ok(b, err)
 	fmt.Println(len(c))
 }
+
+func TestTenantLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	l := newTenantLimiter(nil, TenantLimits{
+		Header:           "THANOS-TENANT",
+		DefaultTenant:    "default-tenant",
+		MaxConcurrent:    2,
+		QueriesPerSecond: 3,
+	})
+	l.now = func() time.Time { return now }
+
+	req := func(tenant string) *http.Request {
+		r := httptest.NewRequest("GET", "/api/v1/query", nil)
+		if tenant != "" {
+			r.Header.Set("THANOS-TENANT", tenant)
+		}
+		return r
+	}
+	testutil.Equals(t, "default-tenant", l.limits.tenant(req("")))
+	testutil.Equals(t, "a", l.limits.tenant(req("a")))
+
+	// The concurrency limit of a tenant does not affect other tenants.
+	releaseA1, _ := l.acquire("a")
+	testutil.Assert(t, releaseA1 != nil, "first query rejected")
+	releaseA2, _ := l.acquire("a")
+	testutil.Assert(t, releaseA2 != nil, "second query rejected")
+	release, reason := l.acquire("a")
+	testutil.Assert(t, release == nil, "third concurrent query admitted")
+	testutil.Equals(t, "concurrency", reason)
+
+	releaseB, _ := l.acquire("b")
+	testutil.Assert(t, releaseB != nil, "query of other tenant rejected")
+	releaseB()
+
+	// After a query finished, the rate limit allows one more query within the burst.
+	releaseA1()
+	release, _ = l.acquire("a")
+	testutil.Assert(t, release != nil, "query rejected after release")
+	release()
+	release, reason = l.acquire("a")
+	testutil.Assert(t, release == nil, "query exceeding the rate admitted")
+	testutil.Equals(t, "rate", reason)
+
+	// Tokens are refilled over time.
+	now = now.Add(time.Second)
+	release, _ = l.acquire("a")
+	testutil.Assert(t, release != nil, "query rejected after refill")
+	release()
+	releaseA2()
+
+	// Rejected queries are answered with 429.
+	l.limits.QueriesPerSecond = 0
+	l.limits.MaxConcurrent = 1
+
+	var inner apiFunc
+	f := l.limit(func(r *http.Request) (interface{}, []error, *apiError) {
+		return inner(r)
+	})
+	inner = func(r *http.Request) (interface{}, []error, *apiError) {
+		_, _, apiErr := f(req("c"))
+		testutil.Assert(t, apiErr != nil, "nested query admitted")
+		testutil.Equals(t, errorType(errorTooManyRequests), apiErr.typ)
+		return "ok", nil, nil
+	}
+	data, _, apiErr := f(req("c"))
+	testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+	testutil.Equals(t, "ok", data)
+}
+
+func TestTenantLimiter_MaxTenants(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	// Without limits no tenants are tracked.
+	testutil.Assert(t, newTenantLimiter(nil, TenantLimits{MaxTenants: 2}) == nil, "limiter without limits created")
+
+	l := newTenantLimiter(nil, TenantLimits{MaxTenants: 2, MaxConcurrent: 10})
+	l.now = func() time.Time { return now }
+
+	releaseA, _ := l.acquire("a")
+	testutil.Assert(t, releaseA != nil, "query of first tenant rejected")
+	release, _ := l.acquire("b")
+	testutil.Assert(t, release != nil, "query of second tenant rejected")
+	release()
+
+	// Further tenants are rejected, tracked ones are not.
+	release, reason := l.acquire("c")
+	testutil.Assert(t, release == nil, "query of third tenant admitted")
+	testutil.Equals(t, "tenants", reason)
+	release, _ = l.acquire("b")
+	testutil.Assert(t, release != nil, "query of tracked tenant rejected")
+	release()
+
+	// Idle tenants are evicted, tenants with queries in flight are kept.
+	now = now.Add(tenantIdleTimeout)
+	release, _ = l.acquire("c")
+	testutil.Assert(t, release != nil, "query of tenant rejected after eviction")
+	release()
+	testutil.Equals(t, 2, len(l.tenants))
+	_, ok := l.tenants["a"]
+	testutil.Assert(t, ok, "tenant with query in flight evicted")
+	releaseA()
+}
+
+func TestResourceHeaders(t *testing.T) {
+	now := time.Unix(0, 0)
+	api := &API{resourceHeaders: true, now: func() time.Time { return now }}
+
+	h := api.withResourceHeaders(func(w http.ResponseWriter, r *http.Request) {
+		rs := store.ResourceStatsFromContext(r.Context())
+		testutil.Assert(t, rs != nil, "no resource stats in request context")
+
+		rs.AddSeriesTouched(2)
+		rs.AddSamplesScanned(120)
+		rs.AddFetchedBytes(4096)
+		now = now.Add(1500 * time.Millisecond)
+
+		respond(w, "ok", nil)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/api/v1/query", nil))
+
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Equals(t, "120", rec.Header().Get("X-Thanos-Samples-Scanned"))
+	testutil.Equals(t, "2", rec.Header().Get("X-Thanos-Series-Touched"))
+	testutil.Equals(t, "4096", rec.Header().Get("X-Thanos-Fetched-Bytes"))
+	testutil.Equals(t, "1.5", rec.Header().Get("X-Thanos-Wall-Time-Seconds"))
+
+	// Without the option, no headers are set.
+	api.resourceHeaders = false
+	rec = httptest.NewRecorder()
+	api.withResourceHeaders(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Assert(t, store.ResourceStatsFromContext(r.Context()) == nil, "unexpected resource stats in request context")
+		respond(w, "ok", nil)
+	})(rec, httptest.NewRequest("GET", "/api/v1/query", nil))
+	testutil.Equals(t, "", rec.Header().Get("X-Thanos-Samples-Scanned"))
+}
+
+func TestAccessLog(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	var buf bytes.Buffer
+	api := &API{accessLogger: log.NewJSONLogger(&buf), now: func() time.Time { return now }}
+
+	h := api.withAccessLog(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "up", r.FormValue("query"))
+		now = now.Add(250 * time.Millisecond)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+	// Parameters are logged from both the URL and the body of POST requests.
+	req := httptest.NewRequest("POST", "/api/v1/query_range?step=15", strings.NewReader("query=up&start=0&end=60"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	testutil.Ok(t, json.Unmarshal(buf.Bytes(), &entry))
+	testutil.Equals(t, map[string]interface{}{
+		"method":           "POST",
+		"path":             "/api/v1/query_range",
+		"status":           float64(http.StatusUnprocessableEntity),
+		"duration_seconds": 0.25,
+		"remote_addr":      "192.0.2.1:1234",
+		"query":            "up",
+		"start":            "0",
+		"end":              "60",
+		"step":             "15",
+	}, entry)
+
+	// Without an access logger, requests are not logged.
+	buf.Reset()
+	api.accessLogger = nil
+	api.withAccessLog(func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/query?query=up", nil))
+	testutil.Equals(t, 0, buf.Len())
+}
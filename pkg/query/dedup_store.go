@@ -0,0 +1,137 @@
+package query
+
+import (
+	"sort"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/tsdb/chunkenc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSamplesPerChunk is the number of samples after which a new chunk is cut when
+// re-encoding deduplicated series. It matches the TSDB default.
+const maxSamplesPerChunk = 120
+
+// DedupStore implements the store API on top of another store and deduplicates the returned series
+// along the replica label if a request asks for it. All other requests are passed through unchanged.
+type DedupStore struct {
+	storepb.StoreServer
+	replicaLabel string
+}
+
+// NewDedupStore returns a new DedupStore for the given store.
+func NewDedupStore(s storepb.StoreServer, replicaLabel string) *DedupStore {
+	return &DedupStore{StoreServer: s, replicaLabel: replicaLabel}
+}
+
+// Series returns all series for a requested time range and label matcher. If deduplication is
+// requested, series are merged the same way as for PromQL queries and re-encoded into new chunks.
+// Only raw data can be deduplicated.
+func (s *DedupStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	if !r.Deduplicate || s.replicaLabel == "" {
+		return s.StoreServer.Series(r, srv)
+	}
+
+	resp := &seriesServer{ctx: srv.Context()}
+	if err := s.StoreServer.Series(r, resp); err != nil {
+		return err
+	}
+	for _, w := range resp.warnings {
+		if err := srv.Send(storepb.NewWarnSeriesResponse(errors.New(w))); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+		}
+	}
+	for _, series := range resp.seriesSet {
+		for _, c := range series.Chunks {
+			if c.Raw == nil {
+				return status.Error(codes.InvalidArgument, "deduplication of downsampled data is not supported, request raw data instead")
+			}
+		}
+	}
+	sortDedupLabels(resp.seriesSet, s.replicaLabel)
+
+	set := newDedupSeriesSet(promSeriesSet{
+		mint: r.MinTime,
+		maxt: r.MaxTime,
+		set:  newStoreSeriesSet(resp.seriesSet),
+		aggr: resAggrAvg,
+	}, s.replicaLabel, nil)
+
+	var res []storepb.Series
+	for set.Next() {
+		series, err := encodeSeries(set.At())
+		if err != nil {
+			return status.Error(codes.Internal, errors.Wrap(err, "encode series").Error())
+		}
+		res = append(res, series)
+	}
+	if err := set.Err(); err != nil {
+		return status.Error(codes.Internal, errors.Wrap(err, "deduplicate series").Error())
+	}
+
+	// Stripping the replica label may change the order of series, but clients merge the
+	// responses of multiple stores and require them to be sorted.
+	sort.Slice(res, func(i, j int) bool {
+		return storepb.CompareLabels(res[i].Labels, res[j].Labels) < 0
+	})
+	for i := range res {
+		if err := srv.Send(storepb.NewSeriesResponse(&res[i])); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send series response").Error())
+		}
+	}
+	return nil
+}
+
+// encodeSeries encodes all samples of the series into XOR chunks.
+func encodeSeries(s storage.Series) (storepb.Series, error) {
+	var res storepb.Series
+
+	for _, l := range s.Labels() {
+		res.Labels = append(res.Labels, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+
+	var (
+		chk *chunkenc.XORChunk
+		app chunkenc.Appender
+		err error
+
+		mint, maxt int64
+	)
+	cut := func() {
+		if chk == nil {
+			return
+		}
+		res.Chunks = append(res.Chunks, storepb.AggrChunk{
+			MinTime: mint,
+			MaxTime: maxt,
+			Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: chk.Bytes()},
+		})
+		chk = nil
+	}
+
+	it := s.Iterator()
+	for it.Next() {
+		t, v := it.At()
+
+		if chk == nil || chk.NumSamples() >= maxSamplesPerChunk {
+			cut()
+
+			chk = chunkenc.NewXORChunk()
+			if app, err = chk.Appender(); err != nil {
+				return res, err
+			}
+			mint = t
+		}
+		app.Append(t, v)
+		maxt = t
+	}
+	if err := it.Err(); err != nil {
+		return res, err
+	}
+	cut()
+
+	return res, nil
+}
@@ -0,0 +1,79 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// testSeriesServer collects all responses of a Series call.
+type testSeriesServer struct {
+	storepb.Store_SeriesServer
+	ctx context.Context
+
+	series   []storepb.Series
+	warnings []string
+}
+
+func (s *testSeriesServer) Send(r *storepb.SeriesResponse) error {
+	if w := r.GetWarning(); w != "" {
+		s.warnings = append(s.warnings, w)
+		return nil
+	}
+	s.series = append(s.series, *r.GetSeries())
+	return nil
+}
+
+func (s *testSeriesServer) Context() context.Context {
+	return s.ctx
+}
+
+func TestDedupStore_Series(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st := NewDedupStore(&storeServer{
+		resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "r1"), []sample{{10000, 1}, {20000, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "1", "replica", "r2"), []sample{{10000, 1}, {20000, 2}, {30000, 3}}),
+			storepb.NewWarnSeriesResponse(errors.New("partial error")),
+			storeSeriesResponse(t, labels.FromStrings("a", "2", "replica", "r1"), []sample{{10000, 4}}),
+		},
+	}, "replica")
+
+	// Without deduplication requested, all replicas are returned as they are.
+	srv := &testSeriesServer{ctx: context.Background()}
+	testutil.Ok(t, st.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: 100000}, srv))
+	testutil.Equals(t, 3, len(srv.series))
+	testutil.Equals(t, []string{"partial error"}, srv.warnings)
+
+	srv = &testSeriesServer{ctx: context.Background()}
+	testutil.Ok(t, st.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: 100000, Deduplicate: true}, srv))
+	testutil.Equals(t, []string{"partial error"}, srv.warnings)
+	testutil.Equals(t, 2, len(srv.series))
+
+	exp := []struct {
+		lset    []storepb.Label
+		samples []sample
+	}{
+		{
+			lset:    []storepb.Label{{Name: "a", Value: "1"}},
+			samples: []sample{{10000, 1}, {20000, 2}, {30000, 3}},
+		},
+		{
+			lset:    []storepb.Label{{Name: "a", Value: "2"}},
+			samples: []sample{{10000, 4}},
+		},
+	}
+	for i, s := range srv.series {
+		testutil.Equals(t, exp[i].lset, s.Labels)
+
+		res := expandSeries(t, newChunkSeries(s.Labels, s.Chunks, 0, 100000, resAggrAvg).Iterator())
+		testutil.Equals(t, exp[i].samples, res)
+	}
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// lookbackDelta is the time the engine looks back for samples of instant vector selectors.
+const lookbackDelta = 5 * time.Minute
+
+// SeriesEstimator estimates the number of series a query selects before it is executed and rejects queries
+// exceeding a budget. The series of each selector are estimated from the series hints of the stores, which
+// stores answer from their index without fetching any series. The estimate is the number of series selected
+// at the same time, so it does not grow with the time range of a query unless series churn. Stores that cannot
+// estimate their series do not count towards the estimate and replicas are counted separately.
+type SeriesEstimator struct {
+	logger    log.Logger
+	proxy     storepb.StoreServer
+	maxSeries int64
+
+	estimated prometheus.Histogram
+	rejected  prometheus.Counter
+}
+
+// NewSeriesEstimator returns an estimator that rejects queries estimated to select more than maxSeries series.
+func NewSeriesEstimator(logger log.Logger, reg prometheus.Registerer, proxy storepb.StoreServer, maxSeries int64) *SeriesEstimator {
+	e := &SeriesEstimator{
+		logger:    logger,
+		proxy:     proxy,
+		maxSeries: maxSeries,
+		estimated: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "thanos_query_estimated_series",
+			Help:    "Number of series queries were estimated to select before their execution.",
+			Buckets: prometheus.ExponentialBuckets(10, 4, 10),
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_query_estimate_rejected_queries_total",
+			Help: "Total number of queries rejected because they were estimated to select too many series.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(e.estimated, e.rejected)
+	}
+	return e
+}
+
+type estimatedSelector struct {
+	matchers []*labels.Matcher
+	// Time the selector reaches back before each evaluation, and the offset of the selector.
+	lookback, offset time.Duration
+}
+
+// Check estimates the series the query selects when evaluated between start and end on data of up to the given
+// resolution and returns an error if they exceed the budget. Queries that fail to parse or to be estimated are not
+// rejected, the engine reports their errors.
+func (e *SeriesEstimator) Check(ctx context.Context, qs string, start, end time.Time, maxSourceResolution time.Duration) error {
+	if e == nil || e.maxSeries <= 0 {
+		return nil
+	}
+	expr, err := promql.ParseExpr(qs)
+	if err != nil {
+		return nil
+	}
+	var sels []estimatedSelector
+	promql.Inspect(expr, func(node promql.Node) bool {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			sels = append(sels, estimatedSelector{matchers: n.LabelMatchers, lookback: lookbackDelta, offset: n.Offset})
+		case *promql.MatrixSelector:
+			sels = append(sels, estimatedSelector{matchers: n.LabelMatchers, lookback: n.Range, offset: n.Offset})
+		}
+		return true
+	})
+
+	var series int64
+	for _, sel := range sels {
+		n, err := e.selectorSeries(ctx, sel, start, end, maxSourceResolution)
+		if err != nil {
+			level.Warn(e.logger).Log("msg", "estimating series of query failed", "query", qs, "err", err)
+			return nil
+		}
+		// Stop probing once the budget is exceeded.
+		if series += n; series > e.maxSeries {
+			break
+		}
+	}
+	e.estimated.Observe(float64(series))
+
+	if series > e.maxSeries {
+		e.rejected.Inc()
+		return errors.Errorf("query is estimated to select at least %d series, which exceeds the limit of %d", series, e.maxSeries)
+	}
+	return nil
+}
+
+func (e *SeriesEstimator) selectorSeries(ctx context.Context, sel estimatedSelector, start, end time.Time, maxSourceResolution time.Duration) (int64, error) {
+	ms, err := translateMatchers(sel.matchers...)
+	if err != nil {
+		return 0, errors.Wrap(err, "convert matchers")
+	}
+	srv := &hintsServer{ctx: ctx}
+	if err := e.proxy.Series(&storepb.SeriesRequest{
+		MinTime:             timestamp(start.Add(-sel.offset - sel.lookback)),
+		MaxTime:             timestamp(end.Add(-sel.offset)),
+		Matchers:            ms,
+		MaxResolutionWindow: int64(maxSourceResolution / time.Millisecond),
+		Hints:               true,
+		HintsOnly:           true,
+	}, srv); err != nil {
+		return 0, errors.Wrap(err, "proxy Series()")
+	}
+	return srv.series, nil
+}
+
+func timestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// hintsServer sums up the series hints of a series response.
+type hintsServer struct {
+	storepb.Store_SeriesServer
+	ctx context.Context
+
+	series int64
+}
+
+func (s *hintsServer) Send(r *storepb.SeriesResponse) error {
+	if h := r.GetHints(); h != nil {
+		s.series += h.Series
+	}
+	return nil
+}
+
+func (s *hintsServer) Context() context.Context {
+	return s.ctx
+}
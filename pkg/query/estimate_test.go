@@ -0,0 +1,60 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+type hintsStore struct {
+	storepb.StoreServer
+	series int64
+
+	reqs []*storepb.SeriesRequest
+}
+
+func (s *hintsStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.reqs = append(s.reqs, r)
+	return srv.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: s.series}))
+}
+
+func TestSeriesEstimator_Check(t *testing.T) {
+	ctx := context.Background()
+	start := time.Unix(1000, 0)
+	end := start.Add(time.Hour)
+
+	s := &hintsStore{series: 10}
+	e := NewSeriesEstimator(log.NewNopLogger(), nil, s, 25)
+
+	testutil.Ok(t, e.Check(ctx, `up + rate(http_requests_total[10m] offset 1h)`, start, end, 0))
+	testutil.Equals(t, 2, len(s.reqs))
+	for _, r := range s.reqs {
+		testutil.Assert(t, r.HintsOnly, "expected hints only request")
+	}
+	testutil.Equals(t, timestamp(start.Add(-lookbackDelta)), s.reqs[0].MinTime)
+	testutil.Equals(t, timestamp(end), s.reqs[0].MaxTime)
+	testutil.Equals(t, timestamp(start.Add(-70*time.Minute)), s.reqs[1].MinTime)
+	testutil.Equals(t, timestamp(end.Add(-time.Hour)), s.reqs[1].MaxTime)
+
+	// Downsampled data is estimated if the query reads it.
+	s.reqs = nil
+	testutil.Ok(t, e.Check(ctx, `up`, start, end, time.Hour))
+	testutil.Equals(t, int64(time.Hour/time.Millisecond), s.reqs[0].MaxResolutionWindow)
+
+	// Three selectors of 10 series each exceed the budget.
+	testutil.NotOk(t, e.Check(ctx, `up + up + up`, start, end, 0))
+
+	// Queries failing to parse are left to the engine.
+	testutil.Ok(t, e.Check(ctx, `up +`, start, end, 0))
+
+	// Without a budget no queries are estimated.
+	s.reqs = nil
+	var nilEstimator *SeriesEstimator
+	testutil.Ok(t, nilEstimator.Check(ctx, `up + up + up`, start, end, 0))
+	testutil.Ok(t, NewSeriesEstimator(log.NewNopLogger(), nil, s, 0).Check(ctx, `up + up + up`, start, end, 0))
+	testutil.Equals(t, 0, len(s.reqs))
+}
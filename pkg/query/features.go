@@ -0,0 +1,42 @@
+package query
+
+import "strings"
+
+// Names of the experimental querier features that are enabled with --enable-feature.
+const (
+	// FeatureExemplars enables the /api/v1/query_exemplars endpoint.
+	FeatureExemplars = "exemplars"
+	// FeatureQuerySharding enables the evaluation of aggregations in shards, see ShardingLabels.
+	FeatureQuerySharding = "query-sharding"
+	// FeatureFederateStore enables reading recent data of Prometheus servers through their /federate endpoint.
+	FeatureFederateStore = "federate-store"
+)
+
+// Features are experimental querier features that are enabled individually, so that they can be
+// rolled out to a subset of queriers. The zero value enables no feature.
+type Features struct {
+	Exemplars     bool
+	QuerySharding bool
+	FederateStore bool
+}
+
+// ParseFeatures returns the features enabled by the given names. Each name may be a comma separated list.
+// Unknown names are returned rather than failing, so that callers can warn about them.
+func ParseFeatures(names []string) (f Features, unknown []string) {
+	for _, n := range names {
+		for _, name := range strings.Split(n, ",") {
+			switch name = strings.TrimSpace(name); name {
+			case "":
+			case FeatureExemplars:
+				f.Exemplars = true
+			case FeatureQuerySharding:
+				f.QuerySharding = true
+			case FeatureFederateStore:
+				f.FederateStore = true
+			default:
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	return f, unknown
+}
@@ -0,0 +1,30 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestParseFeatures(t *testing.T) {
+	f, unknown := ParseFeatures(nil)
+	testutil.Equals(t, Features{}, f)
+	testutil.Equals(t, 0, len(unknown))
+
+	f, unknown = ParseFeatures([]string{"exemplars"})
+	testutil.Equals(t, Features{Exemplars: true}, f)
+	testutil.Equals(t, 0, len(unknown))
+
+	f, unknown = ParseFeatures([]string{"exemplars,query-sharding"})
+	testutil.Equals(t, Features{Exemplars: true, QuerySharding: true}, f)
+	testutil.Equals(t, 0, len(unknown))
+
+	f, unknown = ParseFeatures([]string{"federate-store"})
+	testutil.Equals(t, Features{FederateStore: true}, f)
+	testutil.Equals(t, 0, len(unknown))
+
+	// Unknown features do not prevent others from being enabled.
+	f, unknown = ParseFeatures([]string{"foo, exemplars", "bar,"})
+	testutil.Equals(t, Features{Exemplars: true}, f)
+	testutil.Equals(t, []string{"foo", "bar"}, unknown)
+}
@@ -239,6 +239,9 @@ func (it *boundedSeriesIterator) Err() error {
 type chunkSeriesIterator struct {
 	chunks []chunkenc.Iterator
 	i      int
+	// lastT is the timestamp of the current sample. It is tracked separately as
+	// chunks sent by different stores for the same series may be empty.
+	lastT int64
 }
 
 func newChunkSeriesIterator(cs []chunkenc.Iterator) storage.SeriesIterator {
@@ -247,22 +250,22 @@ func newChunkSeriesIterator(cs []chunkenc.Iterator) storage.SeriesIterator {
 		// NOTE(bplotka): Metric, err log here?
 		return errSeriesIterator{}
 	}
-	return &chunkSeriesIterator{chunks: cs}
+	return &chunkSeriesIterator{chunks: cs, lastT: math.MinInt64}
 }
 
 func (it *chunkSeriesIterator) Seek(t int64) (ok bool) {
 	// We generally expect the chunks already to be cut down
 	// to the range we are interested in. There's not much to be gained from
 	// hopping across chunks so we just call next until we reach t.
-	for {
-		ct, _ := it.At()
-		if ct >= t {
+	if it.lastT != math.MinInt64 && it.lastT >= t {
+		return true
+	}
+	for it.Next() {
+		if it.lastT >= t {
 			return true
 		}
-		if !it.Next() {
-			return false
-		}
 	}
+	return false
 }
 
 func (it *chunkSeriesIterator) At() (t int64, v float64) {
@@ -270,21 +273,24 @@ func (it *chunkSeriesIterator) At() (t int64, v float64) {
 }
 
 func (it *chunkSeriesIterator) Next() bool {
-	lastT, _ := it.At()
-
-	if it.chunks[it.i].Next() {
-		return true
-	}
-	if it.Err() != nil {
-		return false
-	}
-	if it.i >= len(it.chunks)-1 {
-		return false
+	for {
+		if it.chunks[it.i].Next() {
+			// Chunks are guaranteed to be ordered but not generally guaranteed to not overlap.
+			// We must ensure to skip any overlapping range between adjacent chunks.
+			if t, _ := it.chunks[it.i].At(); t > it.lastT {
+				it.lastT = t
+				return true
+			}
+			continue
+		}
+		if it.Err() != nil {
+			return false
+		}
+		if it.i >= len(it.chunks)-1 {
+			return false
+		}
+		it.i++
 	}
-	// Chunks are guaranteed to be ordered but not generally guaranteed to not overlap.
-	// We must ensure to skip any overlapping range between adjacent chunks.
-	it.i++
-	return it.Seek(lastT + 1)
 }
 
 func (it *chunkSeriesIterator) Err() error {
@@ -294,6 +300,8 @@ func (it *chunkSeriesIterator) Err() error {
 type dedupSeriesSet struct {
 	set          storage.SeriesSet
 	replicaLabel string
+	// onMerge is called for every series that was merged from more than one replica.
+	onMerge func(replicas int)
 
 	replicas []storage.Series
 	lset     labels.Labels
@@ -301,8 +309,11 @@ type dedupSeriesSet struct {
 	ok       bool
 }
 
-func newDedupSeriesSet(set storage.SeriesSet, replicaLabel string) storage.SeriesSet {
-	s := &dedupSeriesSet{set: set, replicaLabel: replicaLabel}
+func newDedupSeriesSet(set storage.SeriesSet, replicaLabel string, onMerge func(replicas int)) storage.SeriesSet {
+	if onMerge == nil {
+		onMerge = func(int) {}
+	}
+	s := &dedupSeriesSet{set: set, replicaLabel: replicaLabel, onMerge: onMerge}
 	s.ok = s.set.Next()
 	if s.ok {
 		s.peek = s.set.At()
@@ -318,7 +329,14 @@ func (s *dedupSeriesSet) Next() bool {
 	// without the replica label if it exists.
 	s.lset = s.peekLset()
 	s.replicas = append(s.replicas[:0], s.peek)
-	return s.next()
+
+	if !s.next() {
+		return false
+	}
+	if len(s.replicas) > 1 {
+		s.onMerge(len(s.replicas))
+	}
+	return true
 }
 
 // peekLset returns the label set of the current peek element stripped from the
@@ -354,6 +372,7 @@ func (s *dedupSeriesSet) At() storage.Series {
 	if len(s.replicas) == 1 {
 		return seriesWithLabels{Series: s.replicas[0], lset: s.lset}
 	}
+
 	// Clients may store the series, so we must make a copy of the slice
 	// before advancing.
 	repl := make([]storage.Series, len(s.replicas))
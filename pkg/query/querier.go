@@ -4,12 +4,16 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/tracing"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
 )
@@ -25,7 +29,12 @@ type PartialErrReporter func(error)
 type QueryableCreator func(deduplicate bool, maxSourceResolution time.Duration, p PartialErrReporter) storage.Queryable
 
 // NewQueryableCreator creates QueryableCreator.
-func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLabel string) QueryableCreator {
+// If seriesHints is enabled, stores are asked to estimate the size of their series responses up front.
+// A positive maxSamples aborts queries that hold more samples fetched from the store APIs in memory.
+func NewQueryableCreator(logger log.Logger, reg prometheus.Registerer, proxy storepb.StoreServer, replicaLabel string, seriesHints bool, maxSamples int64) QueryableCreator {
+	metrics := newDedupMetrics(reg)
+	shardMetrics := newShardMetrics(reg)
+
 	return func(deduplicate bool, maxSourceResolution time.Duration, p PartialErrReporter) storage.Queryable {
 		return &queryable{
 			logger:              logger,
@@ -34,10 +43,44 @@ func NewQueryableCreator(logger log.Logger, proxy storepb.StoreServer, replicaLa
 			deduplicate:         deduplicate,
 			maxSourceResolution: maxSourceResolution,
 			partialErrReport:    p,
+			metrics:             metrics,
+			seriesHints:         seriesHints,
+			maxSamples:          maxSamples,
+			shardMetrics:        shardMetrics,
 		}
 	}
 }
 
+// dedupMetrics show whether deduplication actually collapses replicas. A replica label that is
+// missing or named differently in some stores shows as duplicates that are never removed.
+type dedupMetrics struct {
+	selects       *prometheus.CounterVec
+	mergedSeries  prometheus.Counter
+	removedSeries prometheus.Counter
+}
+
+func newDedupMetrics(reg prometheus.Registerer) *dedupMetrics {
+	var m dedupMetrics
+
+	m.selects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_query_selects_total",
+		Help: "Total number of series selects against the store API, partitioned by whether deduplication was enabled.",
+	}, []string{"dedup"})
+	m.mergedSeries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_dedup_merged_series_total",
+		Help: "Total number of series that were merged from more than one replica.",
+	})
+	m.removedSeries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_dedup_removed_series_total",
+		Help: "Total number of replica series removed as duplicates.",
+	})
+
+	if reg != nil {
+		reg.MustRegister(m.selects, m.mergedSeries, m.removedSeries)
+	}
+	return &m
+}
+
 type queryable struct {
 	logger              log.Logger
 	replicaLabel        string
@@ -45,11 +88,21 @@ type queryable struct {
 	deduplicate         bool
 	partialErrReport    PartialErrReporter
 	maxSourceResolution time.Duration
+	metrics             *dedupMetrics
+	seriesHints         bool
+	maxSamples          int64
+	shardMetrics        *shardMetrics
 }
 
 // Querier returns a new storage querier against the underlying proxy store API.
+// The engine creates one querier per query, so the sample limit applies to each query separately.
 func (q *queryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
-	return newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.partialErrReport), nil
+	qr := newQuerier(ctx, q.logger, mint, maxt, q.replicaLabel, q.proxy, q.deduplicate, int64(q.maxSourceResolution/time.Millisecond), q.partialErrReport, q.metrics, q.seriesHints)
+	if q.maxSamples > 0 {
+		qr.sampleLimiter = NewSampleLimiter(q.maxSamples)
+	}
+	qr.shardMetrics = q.shardMetrics
+	return qr, nil
 }
 
 type querier struct {
@@ -62,6 +115,17 @@ type querier struct {
 	deduplicate         bool
 	partialErrReport    PartialErrReporter
 	maxSourceResolution int64
+	metrics             *dedupMetrics
+	seriesHints         bool
+	// Limits the samples of all series selected by the query. The engine selects all series before
+	// it evaluates the query and keeps them until it is done, so they are held in memory at once.
+	// Nil if unlimited.
+	sampleLimiter *SampleLimiter
+	// Metrics of selects of sharded queries. Nil if they are not recorded.
+	shardMetrics *shardMetrics
+
+	// Per query deduplication stats, logged when the querier is closed.
+	mergedSeries, removedSeries int64
 }
 
 // newQuerier creates implementation of storage.Querier that fetches data from the proxy
@@ -75,10 +139,15 @@ func newQuerier(
 	deduplicate bool,
 	maxSourceResolution int64,
 	partialErrReport PartialErrReporter,
+	metrics *dedupMetrics,
+	seriesHints bool,
 ) *querier {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	if metrics == nil {
+		metrics = newDedupMetrics(nil)
+	}
 	if partialErrReport == nil {
 		partialErrReport = func(error) {}
 	}
@@ -94,6 +163,8 @@ func newQuerier(
 		deduplicate:         deduplicate,
 		maxSourceResolution: maxSourceResolution,
 		partialErrReport:    partialErrReport,
+		metrics:             metrics,
+		seriesHints:         seriesHints,
 	}
 }
 
@@ -101,6 +172,9 @@ func (q *querier) isDedupEnabled() bool {
 	return q.deduplicate && q.replicaLabel != ""
 }
 
+// maxHintedSeries is the maximum number of series space is reserved for up front based on series hints.
+const maxHintedSeries = 100000
+
 type seriesServer struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
 	storepb.Store_SeriesServer
@@ -108,6 +182,8 @@ type seriesServer struct {
 
 	seriesSet []storepb.Series
 	warnings  []string
+	// Sum of the series and chunks hinted by the stores.
+	hintedSeries, hintedChunks int64
 }
 
 func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
@@ -116,6 +192,19 @@ func (s *seriesServer) Send(r *storepb.SeriesResponse) error {
 		return nil
 	}
 
+	if h := r.GetHints(); h != nil {
+		s.hintedSeries += h.Series
+		s.hintedChunks += h.Chunks
+
+		// Reserve space for the hinted series, so the result does not have to grow repeatedly.
+		if n := int(s.hintedSeries); n > cap(s.seriesSet) && n <= maxHintedSeries {
+			set := make([]storepb.Series, len(s.seriesSet), n)
+			copy(set, s.seriesSet)
+			s.seriesSet = set
+		}
+		return nil
+	}
+
 	if r.GetSeries() == nil {
 		return errors.New("no seriesSet")
 	}
@@ -171,22 +260,61 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 
 	queryAggrs, resAggr := aggrsFromFunc(params.Func)
 
-	resp := &seriesServer{ctx: ctx}
-	if err := q.proxy.Series(&storepb.SeriesRequest{
+	req := &storepb.SeriesRequest{
 		MinTime:             q.mint,
 		MaxTime:             q.maxt,
 		Matchers:            sms,
 		MaxResolutionWindow: q.maxSourceResolution,
 		Aggregates:          queryAggrs,
-	}, resp); err != nil {
+		Hints:               q.seriesHints,
+	}
+	if s := shardFromContext(q.ctx); s != nil {
+		req.ShardIndex, req.TotalShards, req.ShardByLabels = s.index, s.total, q.shardByLabels(s.by)
+	}
+
+	resp := &seriesServer{ctx: ctx}
+	if err := q.proxy.Series(req, resp); err != nil {
 		return nil, errors.Wrap(err, "proxy Series()")
 	}
+	if req.TotalShards > 1 {
+		var filtered int
+		resp.seriesSet, filtered = filterShard(req, resp.seriesSet)
+		span.SetTag("shard_filtered_series", filtered)
+
+		if q.shardMetrics != nil {
+			q.shardMetrics.shardedSelects.Inc()
+			q.shardMetrics.shardFilteredSeries.Add(float64(filtered))
+		}
+	}
+	if q.seriesHints {
+		span.SetTag("hinted_series", resp.hintedSeries)
+		span.SetTag("hinted_chunks", resp.hintedChunks)
+	}
 
 	for _, w := range resp.warnings {
 		q.partialErrReport(errors.New(w))
 	}
 
+	samples := countSamples(resp.seriesSet)
+	if l := sampleLimiterFromContext(q.ctx); l != nil {
+		if err := l.Add(samples); err != nil {
+			return nil, err
+		}
+	}
+	if q.sampleLimiter != nil {
+		if err := q.sampleLimiter.Add(samples); err != nil {
+			return nil, errors.Wrap(err, "query sample limit")
+		}
+	}
+
+	if rs := store.ResourceStatsFromContext(q.ctx); rs != nil {
+		rs.AddSeriesTouched(int64(len(resp.seriesSet)))
+		rs.AddSamplesScanned(samples)
+	}
+
 	if !q.isDedupEnabled() {
+		q.metrics.selects.WithLabelValues("false").Inc()
+
 		// Return data without any deduplication.
 		return promSeriesSet{
 			mint: q.mint,
@@ -210,7 +338,31 @@ func (q *querier) Select(params *storage.SelectParams, ms ...*labels.Matcher) (s
 	// The merged series set assembles all potentially-overlapping time ranges
 	// of the same series into a single one. The series are ordered so that equal series
 	// from different replicas are sequential. We can now deduplicate those.
-	return newDedupSeriesSet(set, q.replicaLabel), nil
+	q.metrics.selects.WithLabelValues("true").Inc()
+	return newDedupSeriesSet(set, q.replicaLabel, q.recordMerge), nil
+}
+
+// shardByLabels returns the labels to shard by. Replicas of a series must end up in the same shard to be
+// deduplicated, so the replica label is not considered. It is removed by the deduplication anyway.
+func (q *querier) shardByLabels(by []string) []string {
+	if !q.isDedupEnabled() {
+		return by
+	}
+	res := make([]string, 0, len(by))
+	for _, l := range by {
+		if l != q.replicaLabel {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+func (q *querier) recordMerge(replicas int) {
+	q.metrics.mergedSeries.Inc()
+	q.metrics.removedSeries.Add(float64(replicas - 1))
+
+	atomic.AddInt64(&q.mergedSeries, 1)
+	atomic.AddInt64(&q.removedSeries, int64(replicas-1))
 }
 
 // sortDedupLabels resorts the set so that the same series with different replica
@@ -252,6 +404,15 @@ func (q *querier) LabelValues(name string) ([]string, error) {
 }
 
 func (q *querier) Close() error {
+	if q.isDedupEnabled() {
+		level.Debug(q.logger).Log(
+			"msg", "deduplication stats",
+			"mint", q.mint,
+			"maxt", q.maxt,
+			"merged_series", atomic.LoadInt64(&q.mergedSeries),
+			"removed_series", atomic.LoadInt64(&q.removedSeries),
+		)
+	}
 	q.cancel()
 	return nil
 }
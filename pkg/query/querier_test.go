@@ -6,17 +6,29 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/compact"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/prometheus/prometheus/storage"
+	promtsdb "github.com/prometheus/prometheus/storage/tsdb"
+	"github.com/prometheus/tsdb"
 	"github.com/prometheus/tsdb/chunkenc"
+	tsdblabels "github.com/prometheus/tsdb/labels"
 )
 
 func TestQuerier_Series(t *testing.T) {
@@ -33,7 +45,7 @@ func TestQuerier_Series(t *testing.T) {
 
 	// Querier clamps the range to [1,300], which should drop some samples of the result above.
 	// The store API allows endpoints to send more data then initially requested.
-	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, nil)
+	q := newQuerier(context.Background(), nil, 1, 300, "", testProxy, false, 0, nil, nil, false)
 	defer q.Close()
 
 	res, err := q.Select(&storage.SelectParams{})
@@ -74,6 +86,108 @@ func TestQuerier_Series(t *testing.T) {
 	testutil.Equals(t, len(expected), i)
 }
 
+type testStoreClient struct {
+	storepb.StoreClient
+}
+
+func (c *testStoreClient) Labels() []storepb.Label {
+	return nil
+}
+
+func (c *testStoreClient) TimeRange() (int64, int64) {
+	return math.MinInt64, math.MaxInt64
+}
+
+func (c *testStoreClient) String() string {
+	return "test"
+}
+
+// TestQuerier_PromQLSplitAcrossStores checks that functions sensitive to empty inputs return
+// the same results whether the data is in a single TSDB or split across stores.
+func TestQuerier_PromQLSplitAcrossStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var dbs []*tsdb.DB
+	for i := 0; i < 3; i++ {
+		db, err := testutil.NewTSDB()
+		testutil.Ok(t, err)
+		defer func() { testutil.Ok(t, db.Close()) }()
+		defer os.RemoveAll(db.Dir())
+
+		dbs = append(dbs, db)
+	}
+	// The first TSDB has all data. The others split it, partially by time and partially by series.
+	monolith, older, newer := dbs[0], dbs[1], dbs[2]
+
+	apps := []tsdb.Appender{monolith.Appender(), older.Appender(), newer.Appender()}
+	add := func(app tsdb.Appender, lset tsdblabels.Labels, ts int64, v float64) {
+		_, err := app.Add(lset, ts, v)
+		testutil.Ok(t, err)
+	}
+	for ts := int64(0); ts <= 600000; ts += 15000 {
+		app := apps[1]
+		if ts > 300000 {
+			app = apps[2]
+		}
+		v := float64(ts / 15000)
+
+		for _, lset := range []tsdblabels.Labels{
+			tsdblabels.FromStrings("__name__", "up", "job", "a", "instance", "1"),
+			tsdblabels.FromStrings("__name__", "http_request_duration_seconds_bucket", "job", "a", "le", "0.1"),
+			tsdblabels.FromStrings("__name__", "http_request_duration_seconds_bucket", "job", "a", "le", "1"),
+			tsdblabels.FromStrings("__name__", "http_request_duration_seconds_bucket", "job", "a", "le", "+Inf"),
+		} {
+			add(apps[0], lset, ts, v*float64(len(lset.Get("le"))))
+			add(app, lset, ts, v*float64(len(lset.Get("le"))))
+		}
+		// Series only present in one of the stores.
+		if ts <= 450000 {
+			lset := tsdblabels.FromStrings("__name__", "up", "job", "b", "instance", "1")
+			add(apps[0], lset, ts, 1)
+			add(apps[2], lset, ts, 1)
+		}
+	}
+	for _, app := range apps {
+		testutil.Ok(t, app.Commit())
+	}
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return []store.Client{
+			&testStoreClient{StoreClient: storepb.ServerAsClient(store.NewTSDBStore(nil, nil, older, nil))},
+			&testStoreClient{StoreClient: storepb.ServerAsClient(store.NewTSDBStore(nil, nil, newer, nil))},
+		}, nil
+	}, nil, store.ProxyStoreOptions{})
+	federated := NewQueryableCreator(nil, nil, proxy, "", false, 0)(false, 0, nil)
+
+	engine := promql.NewEngine(log.NewNopLogger(), nil, 10, 10*time.Second)
+
+	for _, q := range []string{
+		`absent(up{job="a"})`,
+		`absent(up{job="b"})`,
+		`absent(up{job="c"})`,
+		`absent(nonexistent)`,
+		`scalar(up{job="a"})`,
+		`scalar(up{job="b"})`,
+		`scalar(up)`,
+		`histogram_quantile(0.9, rate(http_request_duration_seconds_bucket[1m]))`,
+		`histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_bucket[1m])))`,
+		`absent(rate(up{job="b"}[1m]))`,
+	} {
+		t.Run(q, func(t *testing.T) {
+			exec := func(qable storage.Queryable) promql.Value {
+				qry, err := engine.NewRangeQuery(qable, q, time.Unix(0, 0), time.Unix(600, 0), 15*time.Second)
+				testutil.Ok(t, err)
+				defer qry.Close()
+
+				res := qry.Exec(context.Background())
+				testutil.Ok(t, res.Err)
+				return res.Value
+			}
+			testutil.Equals(t, exec(promtsdb.Adapter(monolith, 0)), exec(federated))
+		})
+	}
+}
+
 func TestSortReplicaLabel(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -215,7 +329,10 @@ func TestDedupSeriesSet(t *testing.T) {
 		maxt: math.MaxInt64,
 		set:  newStoreSeriesSet(series),
 	}
-	dedupSet := newDedupSeriesSet(set, "replica")
+	var merged []int
+	dedupSet := newDedupSeriesSet(set, "replica", func(replicas int) {
+		merged = append(merged, replicas)
+	})
 
 	i := 0
 	for dedupSet.Next() {
@@ -226,6 +343,44 @@ func TestDedupSeriesSet(t *testing.T) {
 		i++
 	}
 	testutil.Ok(t, dedupSet.Err())
+	testutil.Equals(t, []int{3, 2}, merged)
+}
+
+func TestChunkSeriesIterator(t *testing.T) {
+	chunk := func(smpls ...sample) chunkenc.Iterator {
+		c := chunkenc.NewXORChunk()
+		a, err := c.Appender()
+		testutil.Ok(t, err)
+
+		for _, s := range smpls {
+			a.Append(s.t, s.v)
+		}
+		return c.Iterator()
+	}
+
+	// An empty chunk sent by one store must not cause samples of other stores to be skipped.
+	it := newChunkSeriesIterator([]chunkenc.Iterator{
+		chunk(),
+		chunk(sample{0, 0}, sample{10, 1}, sample{20, 2}),
+		chunk(sample{10, 1}, sample{20, 2}, sample{30, 3}),
+		chunk(),
+		chunk(sample{40, 4}),
+	})
+	testutil.Equals(t, []sample{{0, 0}, {10, 1}, {20, 2}, {30, 3}, {40, 4}}, expandSeries(t, it))
+
+	it = newChunkSeriesIterator([]chunkenc.Iterator{
+		chunk(sample{0, 0}, sample{10, 1}),
+		chunk(),
+		chunk(sample{5, 5}, sample{20, 2}),
+	})
+	testutil.Assert(t, it.Seek(10), "seek failed")
+	testutil.Assert(t, it.Seek(5), "seek backwards failed")
+	ts, _ := it.At()
+	testutil.Equals(t, int64(10), ts)
+	testutil.Assert(t, it.Seek(11), "seek failed")
+	ts, _ = it.At()
+	testutil.Equals(t, int64(20), ts)
+	testutil.Assert(t, !it.Seek(21), "seek beyond end succeeded")
 }
 
 func TestDedupSeriesIterator(t *testing.T) {
@@ -365,6 +520,86 @@ func (s *SampleIterator) Seek(t int64) bool {
 	}
 }
 
+func TestQuerier_SampleLimit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	testProxy := &storeServer{
+		resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {2, 1}, {3, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{2, 2}, {3, 3}, {4, 4}}, []sample{{1, 1}, {2, 2}}),
+		},
+	}
+
+	// The samples of all chunks are accounted, even if they are outside of the queried range.
+	l := NewSampleLimiter(8)
+	q := newQuerier(WithSampleLimiter(context.Background(), l), nil, 1, 300, "", testProxy, false, 0, nil, nil, false)
+
+	_, err := q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, !l.Exceeded(), "limit exceeded too early")
+	testutil.Ok(t, q.Close())
+
+	// The limit applies to the sum over all selects of a query.
+	q = newQuerier(WithSampleLimiter(context.Background(), l), nil, 1, 300, "", testProxy, false, 0, nil, nil, false)
+	defer q.Close()
+
+	_, err = q.Select(&storage.SelectParams{})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, l.Exceeded(), "limit not exceeded")
+}
+
+func TestQuerier_MaxSamples(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	testProxy := &storeServer{
+		resps: []*storepb.SeriesResponse{
+			storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{0, 0}, {2, 1}, {3, 2}}),
+			storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{2, 2}, {3, 3}, {4, 4}}, []sample{{1, 1}, {2, 2}}),
+		},
+	}
+	queryable := NewQueryableCreator(nil, nil, testProxy, "", false, 12)(false, 0, nil)
+
+	q, err := queryable.Querier(context.Background(), 1, 300)
+	testutil.Ok(t, err)
+
+	_, err = q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+
+	// The series of all selects of a query are held at once.
+	_, err = q.Select(&storage.SelectParams{})
+	testutil.NotOk(t, err)
+	testutil.Ok(t, q.Close())
+
+	// Each query has its own limit.
+	q, err = queryable.Querier(context.Background(), 1, 300)
+	testutil.Ok(t, err)
+	defer q.Close()
+
+	_, err = q.Select(&storage.SelectParams{})
+	testutil.Ok(t, err)
+}
+
+func TestSeriesServer_Hints(t *testing.T) {
+	s := &seriesServer{ctx: context.Background()}
+
+	testutil.Ok(t, s.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: 3, Chunks: 10})))
+	testutil.Ok(t, s.Send(storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}})))
+	// Hints of multiple stores are summed up.
+	testutil.Ok(t, s.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: 2, Chunks: 4})))
+	testutil.Ok(t, s.Send(storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}})))
+
+	testutil.Equals(t, int64(5), s.hintedSeries)
+	testutil.Equals(t, int64(14), s.hintedChunks)
+	testutil.Equals(t, 2, len(s.seriesSet))
+	testutil.Equals(t, 5, cap(s.seriesSet))
+	testutil.Equals(t, 0, len(s.warnings))
+
+	// Implausibly high hints do not reserve space.
+	s = &seriesServer{ctx: context.Background()}
+	testutil.Ok(t, s.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: maxHintedSeries + 1})))
+	testutil.Equals(t, 0, cap(s.seriesSet))
+}
+
 type storeServer struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
 	storepb.StoreServer
@@ -405,3 +640,97 @@ func storeSeriesResponse(t testing.TB, lset labels.Labels, smplChunks ...[]sampl
 	}
 	return storepb.NewSeriesResponse(&s)
 }
+
+// TestQuerier_DedupRulerReplicas checks that the recording rule results of a HA pair of rulers are deduplicated
+// into single series across the data still held by the rulers and the blocks they uploaded and that were compacted.
+func TestQuerier_DedupRulerReplicas(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "test-dedup-ruler-replicas")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	bkt := inmem.NewBucket()
+	series := []tsdblabels.Labels{tsdblabels.FromStrings("__name__", "job:up:sum", "job", "a")}
+
+	var clients []store.Client
+	for _, replica := range []string{"1", "2"} {
+		extLset := tsdblabels.FromStrings("replica", replica)
+
+		// Blocks the ruler uploaded. The newest one is still held in its local TSDB as well.
+		for _, r := range [][2]int64{{0, 1000}, {1001, 2000}, {2001, 3000}, {3001, 4000}} {
+			id, err := testutil.CreateBlock(dir, series, 9, r[0], r[1], extLset, 0)
+			testutil.Ok(t, err)
+			testutil.Ok(t, block.Upload(ctx, bkt, filepath.Join(dir, id.String())))
+		}
+
+		db, err := testutil.NewTSDB()
+		testutil.Ok(t, err)
+		defer os.RemoveAll(db.Dir())
+		defer func() { testutil.Ok(t, db.Close()) }()
+
+		app := db.Appender()
+		for ts := int64(3500); ts <= 5000; ts += 100 {
+			_, err := app.Add(series[0], ts, float64(ts))
+			testutil.Ok(t, err)
+		}
+		testutil.Ok(t, app.Commit())
+
+		clients = append(clients, &testStoreClient{StoreClient: storepb.ServerAsClient(store.NewTSDBStore(nil, nil, db, extLset))})
+	}
+
+	// The compactor compacts the uploaded blocks of each ruler separately.
+	sy, err := compact.NewSyncer(nil, nil, bkt, 0, 0, 1, 0, nil, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(groups))
+
+	comp, err := tsdb.NewLeveledCompactor(nil, log.NewNopLogger(), []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	for _, g := range groups {
+		id, err := g.Compact(ctx, filepath.Join(dir, "compact"), comp)
+		testutil.Ok(t, err)
+		testutil.Assert(t, id != ulid.ULID{}, "no compaction took place")
+	}
+
+	bs, err := store.NewBucketStore(nil, nil, bkt, filepath.Join(dir, "store"), 100, 0, false, store.BucketStoreOptions{})
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bs.Close()) }()
+	testutil.Ok(t, bs.SyncBlocks(ctx))
+
+	clients = append(clients, &testStoreClient{StoreClient: storepb.ServerAsClient(bs)})
+
+	proxy := store.NewProxyStore(nil, nil, func(context.Context) ([]store.Client, error) {
+		return clients, nil
+	}, nil, store.ProxyStoreOptions{})
+
+	q, err := NewQueryableCreator(nil, nil, proxy, "replica", false, 0)(true, 0, nil).Querier(ctx, 0, 5000)
+	testutil.Ok(t, err)
+	defer q.Close()
+
+	m, err := labels.NewMatcher(labels.MatchEqual, "__name__", "job:up:sum")
+	testutil.Ok(t, err)
+
+	res, err := q.Select(&storage.SelectParams{}, m)
+	testutil.Ok(t, err)
+
+	testutil.Assert(t, res.Next(), "no series returned")
+	testutil.Equals(t, labels.FromStrings("__name__", "job:up:sum", "job", "a"), res.At().Labels())
+
+	samples := expandSeries(t, res.At().Iterator())
+	for i := 1; i < len(samples); i++ {
+		testutil.Assert(t, samples[i].t > samples[i-1].t, "duplicated or unordered sample at %d", samples[i].t)
+	}
+	// 9 samples from each of the four uploaded blocks and the samples of the rulers after the newest uploaded one.
+	testutil.Equals(t, 4*9+13, len(samples))
+	testutil.Equals(t, int64(5000), samples[len(samples)-1].t)
+
+	testutil.Assert(t, !res.Next(), "replicas were not deduplicated")
+	testutil.Ok(t, res.Err())
+}
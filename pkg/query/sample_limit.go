@@ -0,0 +1,67 @@
+package query
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+type sampleLimiterKey struct{}
+
+// SampleLimiter limits the number of samples a query may fetch from the store APIs. It is safe for concurrent use.
+type SampleLimiter struct {
+	limit    int64
+	samples  int64
+	exceeded int32
+}
+
+// NewSampleLimiter returns a limiter that allows to fetch up to limit samples.
+func NewSampleLimiter(limit int64) *SampleLimiter {
+	return &SampleLimiter{limit: limit}
+}
+
+// Add accounts n fetched samples and returns an error if the limit is exceeded.
+func (l *SampleLimiter) Add(n int64) error {
+	if s := atomic.AddInt64(&l.samples, n); s > l.limit {
+		atomic.StoreInt32(&l.exceeded, 1)
+		return errors.Errorf("query fetched %d samples, which exceeds the limit of %d", s, l.limit)
+	}
+	return nil
+}
+
+// Exceeded returns true if the limit was exceeded.
+func (l *SampleLimiter) Exceeded() bool {
+	return atomic.LoadInt32(&l.exceeded) == 1
+}
+
+// WithSampleLimiter returns a context whose queries are limited by l.
+func WithSampleLimiter(ctx context.Context, l *SampleLimiter) context.Context {
+	return context.WithValue(ctx, sampleLimiterKey{}, l)
+}
+
+func sampleLimiterFromContext(ctx context.Context) *SampleLimiter {
+	l, _ := ctx.Value(sampleLimiterKey{}).(*SampleLimiter)
+	return l
+}
+
+// countSamples returns the number of samples in the chunks of the series. For downsampled chunks
+// each aggregate holds one sample per window, so only the first present aggregate is counted.
+func countSamples(set []storepb.Series) (n int64) {
+	for _, s := range set {
+		for _, c := range s.Chunks {
+			for _, chk := range []*storepb.Chunk{c.Raw, c.Count, c.Sum, c.Min, c.Max, c.Counter} {
+				if chk == nil {
+					continue
+				}
+				if x, err := chunkenc.FromData(chunkenc.EncXOR, chk.Data); err == nil {
+					n += int64(x.NumSamples())
+				}
+				break
+			}
+		}
+	}
+	return n
+}
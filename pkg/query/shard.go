@@ -0,0 +1,123 @@
+package query
+
+import (
+	"context"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+type shardKey struct{}
+
+// shard is a single shard of a sharded query.
+type shard struct {
+	index, total int64
+	by           []string
+}
+
+// WithShard returns a context in which queries only select the series of the given shard of total shards.
+// Series are assigned to shards by the values of the given labels.
+func WithShard(ctx context.Context, index, total int64, by []string) context.Context {
+	return context.WithValue(ctx, shardKey{}, &shard{index: index, total: total, by: by})
+}
+
+func shardFromContext(ctx context.Context) *shard {
+	s, _ := ctx.Value(shardKey{}).(*shard)
+	return s
+}
+
+// shardUnsafeFuncs are functions whose results depend on series of other shards or that create series
+// without selecting them.
+var shardUnsafeFuncs = map[string]struct{}{
+	"absent":        {},
+	"label_join":    {},
+	"label_replace": {},
+	"scalar":        {},
+	"vector":        {},
+}
+
+// ShardingLabels returns the labels by which the evaluation of the expression can be sharded. Only aggregations
+// that group by labels are sharded, every group is then computed from the series of a single shard. The aggregated
+// expression must keep the values of the grouping labels and only combine series with equal values, so it must not
+// contain other aggregations, binary operations between vectors or functions that change labels.
+func ShardingLabels(expr promql.Expr) ([]string, bool) {
+	for {
+		p, ok := expr.(*promql.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = p.Expr
+	}
+	agg, ok := expr.(*promql.AggregateExpr)
+	if !ok || agg.Without || len(agg.Grouping) == 0 {
+		return nil, false
+	}
+	for _, l := range agg.Grouping {
+		// Functions drop the metric name, so series with different names may end up in the same group.
+		if l == labels.MetricName {
+			return nil, false
+		}
+	}
+	shardable := true
+	promql.Inspect(agg.Expr, func(node promql.Node) bool {
+		switch n := node.(type) {
+		case *promql.AggregateExpr:
+			shardable = false
+		case *promql.BinaryExpr:
+			if n.LHS.Type() == promql.ValueTypeVector && n.RHS.Type() == promql.ValueTypeVector {
+				shardable = false
+			}
+		case *promql.Call:
+			if _, ok := shardUnsafeFuncs[n.Func.Name]; ok {
+				shardable = false
+			}
+		}
+		return shardable
+	})
+	if !shardable {
+		return nil, false
+	}
+	return agg.Grouping, true
+}
+
+// shardMetrics show how much sharding relies on stores that honor it.
+type shardMetrics struct {
+	shardedSelects      prometheus.Counter
+	shardFilteredSeries prometheus.Counter
+}
+
+func newShardMetrics(reg prometheus.Registerer) *shardMetrics {
+	var m shardMetrics
+
+	m.shardedSelects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_sharded_selects_total",
+		Help: "Total number of series selects against the store API for a single shard of a sharded query.",
+	})
+	m.shardFilteredSeries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_query_shard_filtered_series_total",
+		Help: "Total number of series of other shards dropped by the querier, because stores without sharding support returned them.",
+	})
+
+	if reg != nil {
+		reg.MustRegister(m.shardedSelects, m.shardFilteredSeries)
+	}
+	return &m
+}
+
+// filterShard removes the series that do not belong to the shard of the request. Stores without
+// sharding support return all series.
+func filterShard(req *storepb.SeriesRequest, set []storepb.Series) ([]storepb.Series, int) {
+	sm := req.ShardMatcher()
+	if sm == nil {
+		return set, 0
+	}
+	res := set[:0]
+	for _, s := range set {
+		if sm.Matches(s.Labels) {
+			res = append(res, s)
+		}
+	}
+	return res, len(set) - len(res)
+}
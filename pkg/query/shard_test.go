@@ -0,0 +1,79 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestShardingLabels(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		by    []string
+	}{
+		{query: `sum by (job) (rate(http_requests_total[5m]))`, by: []string{"job"}},
+		{query: `(max by (job, instance) (up * 2))`, by: []string{"job", "instance"}},
+		{query: `topk by (job) (3, http_requests_total)`, by: []string{"job"}},
+		{query: `histogram_quantile(0.9, sum by (le) (rate(x_bucket[5m])))`},
+		{query: `sum(rate(http_requests_total[5m]))`},
+		{query: `sum without (instance) (up)`},
+		{query: `sum by (__name__) (up)`},
+		{query: `sum by (job) (a / b)`},
+		{query: `sum by (job) (max by (job, instance) (up))`},
+		{query: `sum by (job) (label_replace(up, "job", "x", "", ""))`},
+		{query: `sum by (job) (up * scalar(sum(up)))`},
+		{query: `sum by (job) (absent(up))`},
+		{query: `up`},
+	} {
+		t.Run(c.query, func(t *testing.T) {
+			expr, err := promql.ParseExpr(c.query)
+			testutil.Ok(t, err)
+
+			by, ok := ShardingLabels(expr)
+			testutil.Equals(t, c.by != nil, ok)
+			testutil.Equals(t, c.by, by)
+		})
+	}
+}
+
+func TestQuerier_Select_Shards(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// The store does not support sharding and returns all series for every shard.
+	var resps []*storepb.SeriesResponse
+	for i := 0; i < 10; i++ {
+		for _, replica := range []string{"r0", "r1"} {
+			resps = append(resps, storeSeriesResponse(t, labels.FromStrings("job", fmt.Sprintf("job-%d", i), "replica", replica), []sample{{1, 1}}))
+		}
+	}
+	testProxy := &storeServer{resps: resps}
+	queryable := NewQueryableCreator(nil, nil, testProxy, "replica", false, 0)(true, 0, nil)
+
+	seen := map[string]int{}
+	for i := int64(0); i < 3; i++ {
+		// Replicas end up in the same shard, even if the replica label is a shard label.
+		q, err := queryable.Querier(WithShard(context.Background(), i, 3, []string{"job", "replica"}), 1, 300)
+		testutil.Ok(t, err)
+
+		res, err := q.Select(&storage.SelectParams{})
+		testutil.Ok(t, err)
+		for res.Next() {
+			seen[res.At().Labels().String()]++
+		}
+		testutil.Ok(t, res.Err())
+		testutil.Ok(t, q.Close())
+	}
+	// Every deduplicated series is returned by exactly one shard.
+	testutil.Equals(t, 10, len(seen))
+	for lset, n := range seen {
+		testutil.Assert(t, n == 1, "series %s returned by %d shards", lset, n)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/compression"
 	"github.com/improbable-eng/thanos/pkg/store"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/pkg/errors"
@@ -26,16 +27,20 @@ type StoreSpec interface {
 	// NOTE: It is implementation responsibility to retry until context timeout, but a caller responsibility to manage
 	// given store connection.
 	Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error)
+	// Compression returns the compression of gRPC messages exchanged with the store. If empty, the compression
+	// of the dial options of the store set is used.
+	Compression() string
 }
 
 type grpcStoreSpec struct {
-	addr string
+	addr        string
+	compression string
 }
 
 // NewGRPCStoreSpec creates store pure gRPC spec.
-// It uses Info gRPC call to get Metadata.
-func NewGRPCStoreSpec(addr string) StoreSpec {
-	return &grpcStoreSpec{addr: addr}
+// It uses Info gRPC call to get Metadata. A non-empty compression overrides the default compression.
+func NewGRPCStoreSpec(addr string, compression string) StoreSpec {
+	return &grpcStoreSpec{addr: addr, compression: compression}
 }
 
 func (s *grpcStoreSpec) Addr() string {
@@ -43,6 +48,10 @@ func (s *grpcStoreSpec) Addr() string {
 	return s.addr
 }
 
+func (s *grpcStoreSpec) Compression() string {
+	return s.compression
+}
+
 // Metadata method for gRPC store API tries to reach host Info method until context timeout. If we are unable to get metadata after
 // that time, we assume that the host is unhealthy and return error.
 func (s *grpcStoreSpec) Metadata(ctx context.Context, client storepb.StoreClient) (labels []storepb.Label, mint int64, maxt int64, err error) {
@@ -63,15 +72,27 @@ type StoreSet struct {
 	storeSpecs          func() []StoreSpec
 	dialOpts            []grpc.DialOption
 	gRPCInfoCallTimeout time.Duration
+	// Stores that failed all checks for longer than this are only checked once per timeout, or right away
+	// once they disappear from the store specs and are re-added. Zero disables it.
+	unhealthyTimeout time.Duration
+	// Clock skews of stores larger than this are logged as warnings. Zero disables the warnings.
+	clockSkewThreshold time.Duration
+	now                func() time.Time
 
 	mtx                  sync.RWMutex
 	stores               map[string]*storeRef
 	storeNodeConnections prometheus.Gauge
 	externalLabelStores  map[string]int
+	// Time since when each store address failed all checks.
+	unhealthySince map[string]time.Time
+	// Time at which stores that exceeded the unhealthy timeout were last checked.
+	unhealthyChecked map[string]time.Time
 }
 
 type storeSetNodeCollector struct {
 	externalLabelOccurrences func() map[string]int
+	unhealthyDurations       func() map[string]time.Duration
+	clockSkews               func() map[string]time.Duration
 }
 
 var (
@@ -80,10 +101,22 @@ var (
 		"Number of nodes with the same external labels identified by their hash. If any time-series is larger than 1, external label uniqueness is not true",
 		[]string{"external_labels"}, nil,
 	)
+	nodeUnhealthyDesc = prometheus.NewDesc(
+		"thanos_store_node_unhealthy_seconds",
+		"Number of seconds a store node has continuously failed its info checks.",
+		[]string{"address"}, nil,
+	)
+	clockSkewDesc = prometheus.NewDesc(
+		"thanos_query_store_clock_skew_seconds",
+		"Difference between the current time reported by a store node and the time of the querier. Positive if the clock of the store is ahead.",
+		[]string{"address"}, nil,
+	)
 )
 
 func (c *storeSetNodeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- nodeInfoDesc
+	ch <- nodeUnhealthyDesc
+	ch <- clockSkewDesc
 }
 
 func (c *storeSetNodeCollector) Collect(ch chan<- prometheus.Metric) {
@@ -91,14 +124,26 @@ func (c *storeSetNodeCollector) Collect(ch chan<- prometheus.Metric) {
 	for externalLabels, occurrences := range externalLabelOccurrences {
 		ch <- prometheus.MustNewConstMetric(nodeInfoDesc, prometheus.GaugeValue, float64(occurrences), externalLabels)
 	}
+	for addr, d := range c.unhealthyDurations() {
+		ch <- prometheus.MustNewConstMetric(nodeUnhealthyDesc, prometheus.GaugeValue, d.Seconds(), addr)
+	}
+	for addr, d := range c.clockSkews() {
+		ch <- prometheus.MustNewConstMetric(clockSkewDesc, prometheus.GaugeValue, d.Seconds(), addr)
+	}
 }
 
 // NewStoreSet returns a new set of stores from cluster peers and statically configured ones.
+// Stores that continuously failed their checks for longer than the unhealthy timeout are dropped and only checked
+// once per timeout, so that statically configured stores recover as well. Stores removed from and re-added to the
+// store specs are checked right away. An unhealthy timeout of 0 keeps checking them on every update.
+// Stores whose clock differs from ours by more than the clock skew threshold are logged as warnings.
 func NewStoreSet(
 	logger log.Logger,
 	reg *prometheus.Registry,
 	storeSpecs func() []StoreSpec,
 	dialOpts []grpc.DialOption,
+	unhealthyTimeout time.Duration,
+	clockSkewThreshold time.Duration,
 ) *StoreSet {
 	storeNodeConnections := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "thanos_store_nodes_grpc_connections",
@@ -121,11 +166,20 @@ func NewStoreSet(
 		dialOpts:             dialOpts,
 		storeNodeConnections: storeNodeConnections,
 		gRPCInfoCallTimeout:  10 * time.Second,
+		unhealthyTimeout:     unhealthyTimeout,
+		clockSkewThreshold:   clockSkewThreshold,
+		now:                  time.Now,
 		externalLabelStores:  map[string]int{},
 		stores:               make(map[string]*storeRef),
+		unhealthySince:       map[string]time.Time{},
+		unhealthyChecked:     map[string]time.Time{},
 	}
 
-	storeNodeCollector := &storeSetNodeCollector{externalLabelOccurrences: ss.externalLabelOccurrences}
+	storeNodeCollector := &storeSetNodeCollector{
+		externalLabelOccurrences: ss.externalLabelOccurrences,
+		unhealthyDurations:       ss.unhealthyDurations,
+		clockSkews:               ss.clockSkews,
+	}
 	if reg != nil {
 		reg.MustRegister(storeNodeCollector)
 	}
@@ -144,6 +198,14 @@ type storeRef struct {
 	labels  []storepb.Label
 	minTime int64
 	maxTime int64
+
+	// Clock skew measured on the last info call. It is only known if the store reports its current time.
+	clockSkew      time.Duration
+	clockSkewKnown bool
+	clockSkewed    bool
+	clockChecked   time.Time
+	// supportsSharding is reported by the store on info calls.
+	supportsSharding bool
 }
 
 func (s *storeRef) Update(labels []storepb.Label, minTime int64, maxTime int64) {
@@ -168,6 +230,14 @@ func (s *storeRef) TimeRange() (int64, int64) {
 	return s.minTime, s.maxTime
 }
 
+// SupportsSharding returns true if the store reported to only return the series of the requested shard of
+// sharded series requests.
+func (s *storeRef) SupportsSharding() bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.supportsSharding
+}
+
 func (s *storeRef) String() string {
 	return fmt.Sprintf("%s", s.addr)
 }
@@ -176,10 +246,73 @@ func (s *storeRef) close() {
 	s.cc.Close()
 }
 
+// clockCheckInterval is how often the clock of stores whose specs do not call Info on updates, like gossip
+// discovered stores, is checked with a separate Info call.
+const clockCheckInterval = time.Minute
+
+// clockCheckingClient records the clock skew of the store and whether it supports sharding on each Info call.
+type clockCheckingClient struct {
+	storepb.StoreClient
+	set *StoreSet
+	st  *storeRef
+}
+
+func (c *clockCheckingClient) Info(ctx context.Context, in *storepb.InfoRequest, opts ...grpc.CallOption) (*storepb.InfoResponse, error) {
+	begin := c.set.now()
+	resp, err := c.StoreClient.Info(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.set.recordClockSkew(c.st, resp.CurrentTime, begin, c.set.now())
+
+	c.st.mtx.Lock()
+	c.st.supportsSharding = resp.SupportsSharding
+	c.st.mtx.Unlock()
+	return resp, nil
+}
+
+// recordClockSkew records the clock skew of the store from the current time it reported for a call between begin and end.
+// The time of the store is assumed to be taken halfway through the call.
+func (s *StoreSet) recordClockSkew(st *storeRef, currentTime int64, begin, end time.Time) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	st.clockChecked = end
+
+	// Stores of older versions do not report their time.
+	if currentTime == 0 {
+		return
+	}
+	local := begin.Add(end.Sub(begin) / 2)
+	skew := time.Unix(0, currentTime*int64(time.Millisecond)).Sub(local)
+
+	st.clockSkew = skew
+	st.clockSkewKnown = true
+
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	skewed := s.clockSkewThreshold > 0 && abs > s.clockSkewThreshold
+	if skewed && !st.clockSkewed {
+		level.Warn(s.logger).Log("msg", "clock of store node is skewed, which breaks deduplication and time range based store selection", "address", st.addr, "skew", skew, "threshold", s.clockSkewThreshold)
+	} else if !skewed && st.clockSkewed {
+		level.Info(s.logger).Log("msg", "clock of store node is no longer skewed", "address", st.addr, "skew", skew)
+	}
+	st.clockSkewed = skewed
+}
+
+func (s *storeRef) lastClockCheck() time.Time {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.clockChecked
+}
+
 // Update updates the store set. It fetches current list of store specs from function and updates the fresh metadata
 // from all stores.
 func (s *StoreSet) Update(ctx context.Context) {
-	healthyStores := s.getHealthyStores(ctx)
+	specs := s.checkedSpecs()
+	healthyStores := s.getHealthyStores(ctx, specs)
 
 	// Record the number of occurrences of external label combinations for current store slice.
 	externalLabelStores := map[string]int{}
@@ -223,11 +356,94 @@ func (s *StoreSet) Update(ctx context.Context) {
 		level.Info(s.logger).Log("msg", "adding new store to query storeset", "address", addr)
 	}
 
+	// Track since when the checked stores are unhealthy.
+	now := s.now()
+	for _, spec := range specs {
+		addr := spec.Addr()
+		if _, ok := healthyStores[addr]; ok {
+			delete(s.unhealthySince, addr)
+			delete(s.unhealthyChecked, addr)
+			continue
+		}
+		if _, ok := s.unhealthySince[addr]; !ok {
+			s.unhealthySince[addr] = now
+		}
+	}
+
 	s.externalLabelStores = externalLabelStores
 	s.storeNodeConnections.Set(float64(len(s.stores)))
 }
 
-func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
+// checkedSpecs returns the current store specs without the ones that exceeded the unhealthy timeout, unless they
+// were not checked for the duration of the timeout. Stores that are no longer part of the specs are forgotten, so
+// that they are checked right away once they are re-added.
+func (s *StoreSet) checkedSpecs() []StoreSpec {
+	all := s.storeSpecs()
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	current := make(map[string]struct{}, len(all))
+	for _, spec := range all {
+		current[spec.Addr()] = struct{}{}
+	}
+	for addr := range s.unhealthySince {
+		if _, ok := current[addr]; !ok {
+			delete(s.unhealthySince, addr)
+			delete(s.unhealthyChecked, addr)
+		}
+	}
+	if s.unhealthyTimeout <= 0 {
+		return all
+	}
+
+	now := s.now()
+	specs := make([]StoreSpec, 0, len(all))
+	for _, spec := range all {
+		addr := spec.Addr()
+		since, ok := s.unhealthySince[addr]
+		if ok && now.Sub(since) > s.unhealthyTimeout {
+			checked, ok := s.unhealthyChecked[addr]
+			if !ok {
+				// The store was just dropped, it is checked again after another timeout.
+				s.unhealthyChecked[addr] = now
+			}
+			if !ok || now.Sub(checked) < s.unhealthyTimeout {
+				level.Debug(s.logger).Log("msg", "skipping store that exceeded the unhealthy timeout", "address", addr, "unhealthy_since", since)
+				continue
+			}
+			s.unhealthyChecked[addr] = now
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func (s *StoreSet) unhealthyDurations() map[string]time.Duration {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	now := s.now()
+	r := make(map[string]time.Duration, len(s.unhealthySince))
+	for addr, since := range s.unhealthySince {
+		r[addr] = now.Sub(since)
+	}
+	return r
+}
+
+// Unhealthy returns the addresses of the stores that failed their last checks.
+func (s *StoreSet) Unhealthy() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	addrs := make([]string, 0, len(s.unhealthySince))
+	for addr := range s.unhealthySince {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func (s *StoreSet) getHealthyStores(ctx context.Context, specs []StoreSpec) map[string]*storeRef {
 	var (
 		unique = make(map[string]struct{})
 
@@ -237,7 +453,7 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 	)
 
 	// Gather healthy stores map concurrently. Build new store if does not exist already.
-	for _, storeSpec := range s.storeSpecs() {
+	for _, storeSpec := range specs {
 		if _, ok := unique[storeSpec.Addr()]; ok {
 			level.Warn(s.logger).Log("msg", "duplicated address in gossip or static store nodes", "address", storeSpec.Addr())
 			continue
@@ -256,16 +472,29 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 			st, ok := s.stores[addr]
 			if ok {
 				// Check existing store. Is it healthy? What are current metadata?
-				labels, minTime, maxTime, err := spec.Metadata(ctx, st.StoreClient)
+				client := &clockCheckingClient{StoreClient: st.StoreClient, set: s, st: st}
+
+				labels, minTime, maxTime, err := spec.Metadata(ctx, client)
 				if err != nil {
 					// Peer unhealthy. Do not include in healthy stores.
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", err, "address", addr)
 					return
 				}
 				st.Update(labels, minTime, maxTime)
+
+				if s.now().Sub(st.lastClockCheck()) >= clockCheckInterval {
+					if _, err := client.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false)); err != nil {
+						level.Debug(s.logger).Log("msg", "checking clock of store node failed", "err", err, "address", addr)
+					}
+				}
 			} else {
 				// New store or was unhealthy and was removed in the past - create new one.
-				conn, err := grpc.DialContext(ctx, addr, s.dialOpts...)
+				dialOpts := s.dialOpts
+				if c := spec.Compression(); c != "" {
+					// Later call options override earlier ones.
+					dialOpts = append(dialOpts[:len(dialOpts):len(dialOpts)], compression.DialOption(c))
+				}
+				conn, err := grpc.DialContext(ctx, addr, dialOpts...)
 				if err != nil {
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", errors.Wrap(err, "dialing connection"), "address", addr)
 					return
@@ -273,7 +502,8 @@ func (s *StoreSet) getHealthyStores(ctx context.Context) map[string]*storeRef {
 				st = &storeRef{StoreClient: storepb.NewStoreClient(conn), cc: conn, addr: addr}
 
 				// Initial info call for all types of stores (gossip + static) to check gRPC StoreAPI.
-				resp, err := st.StoreClient.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
+				client := &clockCheckingClient{StoreClient: st.StoreClient, set: s, st: st}
+				resp, err := client.Info(ctx, &storepb.InfoRequest{}, grpc.FailFast(false))
 				if err != nil {
 					st.close()
 					level.Warn(s.logger).Log("msg", "update of store node failed", "err", errors.Wrap(err, "initial store client info fetch"), "address", addr)
@@ -319,6 +549,21 @@ func (s *StoreSet) externalLabelOccurrences() map[string]int {
 	return r
 }
 
+func (s *StoreSet) clockSkews() map[string]time.Duration {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	r := make(map[string]time.Duration, len(s.stores))
+	for addr, st := range s.stores {
+		st.mtx.RLock()
+		if st.clockSkewKnown {
+			r[addr] = st.clockSkew
+		}
+		st.mtx.RUnlock()
+	}
+	return r
+}
+
 // Get returns a list of all active stores.
 func (s *StoreSet) Get() []store.Client {
 	s.mtx.RLock()
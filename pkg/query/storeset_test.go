@@ -46,6 +46,18 @@ func (s *testStore) LabelValues(ctx context.Context, r *storepb.LabelValuesReque
 	return nil, status.Error(codes.Unimplemented, "not implemented")
 }
 
+func (s *testStore) MetricMetadata(ctx context.Context, r *storepb.MetricMetadataRequest) (
+	*storepb.MetricMetadataResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+func (s *testStore) Exemplars(ctx context.Context, r *storepb.ExemplarsRequest) (
+	*storepb.ExemplarsResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
 type testStores struct {
 	srvs map[string]*grpc.Server
 }
@@ -125,7 +137,7 @@ func (s *testStores) CloseOne(addr string) {
 func specsFromAddrFunc(addrs []string) func() []StoreSpec {
 	return func() (specs []StoreSpec) {
 		for _, addr := range addrs {
-			specs = append(specs, NewGRPCStoreSpec(addr))
+			specs = append(specs, NewGRPCStoreSpec(addr, ""))
 		}
 		return specs
 	}
@@ -142,7 +154,7 @@ func TestStoreSet_AllAvailable_ThenDown(t *testing.T) {
 
 	// Testing if duplicates can cause weird results.
 	initialStoreAddr = append(initialStoreAddr, initialStoreAddr[0])
-	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts)
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts, 0, 0)
 	storeSet.gRPCInfoCallTimeout = 2 * time.Second
 	defer storeSet.Close()
 
@@ -185,7 +197,7 @@ func TestStoreSet_StaticStores_OneAvailable(t *testing.T) {
 	initialStoreAddr := st.StoreAddresses()
 	st.CloseOne(initialStoreAddr[0])
 
-	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts)
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts, 0, 0)
 	storeSet.gRPCInfoCallTimeout = 2 * time.Second
 	defer storeSet.Close()
 
@@ -215,7 +227,7 @@ func TestStoreSet_StaticStores_NoneAvailable(t *testing.T) {
 	st.CloseOne(initialStoreAddr[0])
 	st.CloseOne(initialStoreAddr[1])
 
-	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts)
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts, 0, 0)
 	storeSet.gRPCInfoCallTimeout = 2 * time.Second
 
 	// Should not matter how many of these we run.
@@ -259,7 +271,7 @@ func TestStoreSet_AllAvailable_BlockExtLsetDuplicates(t *testing.T) {
 
 	initialStoreAddr := st.StoreAddresses()
 
-	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts)
+	storeSet := NewStoreSet(nil, nil, specsFromAddrFunc(initialStoreAddr), testGRPCOpts, 0, 0)
 	storeSet.gRPCInfoCallTimeout = 2 * time.Second
 	defer storeSet.Close()
 
@@ -292,3 +304,84 @@ func TestStoreSet_AllAvailable_BlockExtLsetDuplicates(t *testing.T) {
 		}
 	}
 }
+
+func TestStoreSet_UnhealthyTimeout(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st, err := newTestStores(2)
+	testutil.Ok(t, err)
+	defer st.Close()
+
+	addrs := st.StoreAddresses()
+	down, up := addrs[0], addrs[1]
+	st.CloseOne(down)
+
+	now := time.Unix(1000, 0)
+	storeSet := NewStoreSet(nil, nil, func() []StoreSpec { return specsFromAddrFunc(addrs)() }, testGRPCOpts, time.Minute, 0)
+	storeSet.gRPCInfoCallTimeout = 2 * time.Second
+	storeSet.now = func() time.Time { return now }
+	defer storeSet.Close()
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.stores))
+	testutil.Equals(t, map[string]time.Duration{down: 0}, storeSet.unhealthyDurations())
+	testutil.Equals(t, []string{down}, storeSet.Unhealthy())
+
+	// Within the timeout the unhealthy store is still checked.
+	now = now.Add(time.Minute)
+	testutil.Equals(t, 2, len(storeSet.checkedSpecs()))
+	storeSet.Update(context.Background())
+	testutil.Equals(t, map[string]time.Duration{down: time.Minute}, storeSet.unhealthyDurations())
+
+	// Afterwards it is no longer checked.
+	now = now.Add(time.Second)
+	specs := storeSet.checkedSpecs()
+	testutil.Equals(t, 1, len(specs))
+	testutil.Equals(t, up, specs[0].Addr())
+
+	storeSet.Update(context.Background())
+	testutil.Equals(t, 1, len(storeSet.stores))
+	_, ok := storeSet.stores[up]
+	testutil.Assert(t, ok, "healthy store removed")
+	// Stores that are no longer checked are still reported as unhealthy.
+	testutil.Equals(t, []string{down}, storeSet.Unhealthy())
+
+	// Dropped stores are checked again once per timeout, so that static stores recover.
+	now = now.Add(time.Minute)
+	testutil.Equals(t, 2, len(storeSet.checkedSpecs()))
+	testutil.Equals(t, 1, len(storeSet.checkedSpecs()))
+
+	// Once the store is removed from the specs, it is forgotten and checked again when re-added.
+	addrs = []string{up}
+	storeSet.Update(context.Background())
+	testutil.Equals(t, map[string]time.Duration{}, storeSet.unhealthyDurations())
+	testutil.Equals(t, []string{}, storeSet.Unhealthy())
+
+	addrs = []string{up, down}
+	testutil.Equals(t, 2, len(storeSet.checkedSpecs()))
+}
+
+func TestStoreSet_recordClockSkew(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	storeSet := NewStoreSet(nil, nil, nil, testGRPCOpts, 0, 10*time.Second)
+	st := &storeRef{addr: "a"}
+	storeSet.stores["a"] = st
+
+	begin := time.Unix(1000, 0)
+	end := begin.Add(2 * time.Second)
+
+	// Stores that do not report their time have no known skew.
+	storeSet.recordClockSkew(st, 0, begin, end)
+	testutil.Equals(t, map[string]time.Duration{}, storeSet.clockSkews())
+	testutil.Equals(t, end, st.lastClockCheck())
+
+	// The time of the store is compared with the middle of the call.
+	storeSet.recordClockSkew(st, 1016*1000, begin, end)
+	testutil.Equals(t, map[string]time.Duration{"a": 15 * time.Second}, storeSet.clockSkews())
+	testutil.Assert(t, st.clockSkewed, "store clock must be skewed")
+
+	storeSet.recordClockSkew(st, 996*1000, begin, end)
+	testutil.Equals(t, map[string]time.Duration{"a": -5 * time.Second}, storeSet.clockSkews())
+	testutil.Assert(t, !st.clockSkewed, "store clock must not be skewed")
+}
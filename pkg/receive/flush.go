@@ -0,0 +1,205 @@
+package receive
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Flush cuts a block from the head of the given tenant's TSDB and truncates its write ahead log, which frees
+// the memory of the head and avoids replaying the write ahead log on the next start. Writes of the tenant
+// are blocked during the flush and queries running concurrently may fail.
+func (t *MultiTSDB) Flush(id string) error {
+	t.mtx.RLock()
+	tn, ok := t.tenants[id]
+	t.mtx.RUnlock()
+	if !ok {
+		return errors.Errorf("unknown tenant %s", id)
+	}
+
+	tn.mtx.Lock()
+	defer tn.mtx.Unlock()
+
+	if tn.db == nil {
+		return errors.Errorf("TSDB of tenant %s is closed", id)
+	}
+	head := tn.db.Head()
+	if head.MaxTime() < head.MinTime() {
+		// Nothing was appended since the last block was cut.
+		return nil
+	}
+	begin := time.Now()
+
+	// The snapshot contains hard links of all persisted blocks and a new block of the head. The TSDB ignores
+	// directories that are not named like blocks, so a leftover of a failed flush does no harm.
+	tmp := filepath.Join(tn.dir, "flush.tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return errors.Wrap(err, "remove leftover flush directory")
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := tn.db.Snapshot(tmp, true); err != nil {
+		return errors.Wrapf(err, "snapshot TSDB of tenant %s", id)
+	}
+	if err := tn.db.Close(); err != nil {
+		return errors.Wrapf(err, "close TSDB of tenant %s", id)
+	}
+	tn.db = nil
+
+	if err := movePersistedHead(tmp, tn.dir); err != nil {
+		// The write ahead log is kept, so the head is restored when the TSDB is opened again.
+		level.Error(t.logger).Log("msg", "moving flushed head block failed", "tenant", id, "err", err)
+	} else if err := os.RemoveAll(filepath.Join(tn.dir, "wal")); err != nil {
+		level.Error(t.logger).Log("msg", "removing write ahead log failed", "tenant", id, "err", err)
+	}
+	if err := t.openDB(tn); err != nil {
+		return err
+	}
+	level.Info(t.logger).Log("msg", "flushed head", "tenant", id, "duration", time.Since(begin))
+	return nil
+}
+
+// movePersistedHead moves the blocks of the snapshot directory that do not exist in the data directory yet,
+// which is only the block of the head, into the data directory.
+func movePersistedHead(snapshotDir, dataDir string) error {
+	files, err := ioutil.ReadDir(snapshotDir)
+	if err != nil {
+		return errors.Wrap(err, "read snapshot directory")
+	}
+	for _, f := range files {
+		dst := filepath.Join(dataDir, f.Name())
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.Rename(filepath.Join(snapshotDir, f.Name()), dst); err != nil {
+			return errors.Wrapf(err, "move block %s", f.Name())
+		}
+	}
+	return nil
+}
+
+// FlushAll flushes the heads of all tenants. It continues with the remaining tenants if a flush fails
+// and returns the last error.
+func (t *MultiTSDB) FlushAll() error {
+	var merr error
+	for _, tn := range t.list() {
+		if err := t.Flush(tn.id); err != nil {
+			level.Error(t.logger).Log("msg", "flushing head failed", "tenant", tn.id, "err", err)
+			merr = err
+		}
+	}
+	return merr
+}
+
+// NewFlushHandler returns a handler that flushes the heads of all tenants on POST requests, or only the head
+// of the tenant given in the tenant parameter.
+func NewFlushHandler(logger log.Logger, dbs *MultiTSDB) http.Handler {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		var err error
+		if tenant := r.FormValue("tenant"); tenant != "" {
+			err = dbs.Flush(tenant)
+		} else {
+			err = dbs.FlushAll()
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "flush failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+var (
+	headSeriesDesc = prometheus.NewDesc(
+		"thanos_receive_head_series",
+		"Number of series in the head of the TSDB of a tenant.",
+		[]string{"tenant"}, nil,
+	)
+	headChunksDesc = prometheus.NewDesc(
+		"thanos_receive_head_chunks",
+		"Number of chunks in the head of the TSDB of a tenant.",
+		[]string{"tenant"}, nil,
+	)
+	lastBlockCutDesc = prometheus.NewDesc(
+		"thanos_receive_last_block_cut_timestamp_seconds",
+		"Time the newest block of the TSDB of a tenant was cut from its head or compacted.",
+		[]string{"tenant"}, nil,
+	)
+)
+
+// headCollector exposes the head statistics of the TSDBs of all tenants.
+type headCollector struct {
+	tenants func() []*tenant
+}
+
+func (c *headCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- headSeriesDesc
+	ch <- headChunksDesc
+	ch <- lastBlockCutDesc
+}
+
+func (c *headCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, tn := range c.tenants() {
+		series, chunks, ok := tn.headStats()
+		if ok {
+			ch <- prometheus.MustNewConstMetric(headSeriesDesc, prometheus.GaugeValue, series, tn.id)
+			ch <- prometheus.MustNewConstMetric(headChunksDesc, prometheus.GaugeValue, chunks, tn.id)
+		}
+		var lastCut uint64
+		for _, m := range tn.blockMetas() {
+			if m.ULID.Time() > lastCut {
+				lastCut = m.ULID.Time()
+			}
+		}
+		if lastCut > 0 {
+			ch <- prometheus.MustNewConstMetric(lastBlockCutDesc, prometheus.GaugeValue, float64(lastCut)/1000, tn.id)
+		}
+	}
+}
+
+// headStats returns the number of series and chunks in the head of the tenant's TSDB as reported by its metrics.
+func (tn *tenant) headStats() (series, chunks float64, ok bool) {
+	tn.mtx.RLock()
+	reg := tn.reg
+	tn.mtx.RUnlock()
+
+	if reg == nil {
+		return 0, 0, false
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "prometheus_tsdb_head_series":
+			series = gaugeValue(mf)
+		case "prometheus_tsdb_head_chunks":
+			chunks = gaugeValue(mf)
+		}
+	}
+	return series, chunks, true
+}
+
+func gaugeValue(mf *dto.MetricFamily) float64 {
+	if len(mf.Metric) == 0 {
+		return 0
+	}
+	return mf.Metric[0].GetGauge().GetValue()
+}
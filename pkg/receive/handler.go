@@ -0,0 +1,311 @@
+package receive
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Appendable returns appenders to write samples to a storage.
+type Appendable interface {
+	Appender() (storage.Appender, error)
+}
+
+// TenantAppendable returns the storage of a tenant.
+type TenantAppendable interface {
+	TenantAppendable(tenant string) (Appendable, error)
+}
+
+// MetadataUpdater stores metric metadata received for a tenant.
+type MetadataUpdater interface {
+	UpdateMetadata(tenant string, md []prompb.MetricMetadata) error
+}
+
+// Handler accepts Prometheus remote write requests and appends the received samples
+// to the local storage of the tenant set in the tenant header.
+type Handler struct {
+	logger        log.Logger
+	app           TenantAppendable
+	tenantHeader  string
+	defaultTenant string
+	labels        labels.Labels
+
+	maxRequestBytes int64
+	maxSeries       int
+	maxSamples      int
+	seriesLimiter   *seriesLimiter
+
+	enforcedLabels   *prometheus.CounterVec
+	skippedSamples   *prometheus.CounterVec
+	rejectedRequests *prometheus.CounterVec
+}
+
+// NewHandler returns a new Handler writing to the given storage. Requests without the tenant header
+// are written for the default tenant. The given external labels are set on all received series,
+// overwriting values sent by the client for the same label names.
+// Requests exceeding the uncompressed size, series or samples limits are rejected. A limit of 0
+// disables the respective check. New series of tenants exceeding their series limits are rejected if the
+// storage reports the series of its tenants.
+func NewHandler(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	app TenantAppendable,
+	tenantHeader string,
+	defaultTenant string,
+	externalLabels labels.Labels,
+	maxRequestBytes int64,
+	maxSeries int,
+	maxSamples int,
+	seriesLimits SeriesLimits,
+) *Handler {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	h := &Handler{
+		logger:          logger,
+		app:             app,
+		tenantHeader:    tenantHeader,
+		defaultTenant:   defaultTenant,
+		labels:          externalLabels,
+		maxRequestBytes: maxRequestBytes,
+		maxSeries:       maxSeries,
+		maxSamples:      maxSamples,
+	}
+	h.enforcedLabels = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_receive_enforced_external_labels_total",
+		Help: "Number of external labels set on received series. Action 'added' means the client did not send the label, 'overwritten' means a different value sent by the client was rejected.",
+	}, []string{"action"})
+	h.skippedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_receive_skipped_samples_total",
+		Help: "Number of received samples that were not appended to the storage.",
+	}, []string{"reason"})
+	h.rejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_receive_rejected_requests_total",
+		Help: "Number of write requests rejected as invalid or exceeding a limit.",
+	}, []string{"reason"})
+
+	if reg != nil {
+		reg.MustRegister(h.enforcedLabels, h.skippedSamples, h.rejectedRequests)
+	}
+	if ts, ok := app.(TenantSeries); ok && (seriesLimits.MaxActiveSeries > 0 || seriesLimits.NewSeriesPerSecond > 0) {
+		h.seriesLimiter = newSeriesLimiter(reg, seriesLimits, ts)
+	}
+	return h
+}
+
+// ServeHTTP handles a snappy compressed remote write request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	tenant := r.Header.Get(h.tenantHeader)
+	if tenant == "" {
+		tenant = h.defaultTenant
+	}
+	if err := validateTenant(tenant); err != nil {
+		h.rejectedRequests.WithLabelValues("invalid-tenant").Inc()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, reason, err := h.readRequest(r.Body)
+	if err == nil {
+		reason, err = h.validate(req)
+	}
+	if err != nil {
+		h.rejectedRequests.WithLabelValues(reason).Inc()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limitErr, err := h.write(tenant, req)
+	if errors.Cause(err) == ErrTooManyTenants {
+		h.rejectedRequests.WithLabelValues("too-many-tenants").Inc()
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		level.Error(h.logger).Log("msg", "write request failed", "tenant", tenant, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The samples of all admitted series are written, only new series exceeding the limits are rejected.
+	if limitErr != nil {
+		http.Error(w, limitErr.Error(), http.StatusTooManyRequests)
+	}
+}
+
+// readRequest reads and decodes a write request. On error, it returns the reason for rejecting the request.
+func (h *Handler) readRequest(r io.Reader) (*prompb.WriteRequest, string, error) {
+	// Stop reading a request body that already exceeds the limit. The uncompressed size is checked below.
+	if h.maxRequestBytes > 0 {
+		r = io.LimitReader(r, h.maxRequestBytes+1)
+	}
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "malformed", errors.Wrap(err, "read request body")
+	}
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, "malformed", errors.Wrap(err, "decode request body")
+	}
+	// Check the size before decompressing so that a huge request cannot exhaust memory.
+	if h.maxRequestBytes > 0 && (int64(len(compressed)) > h.maxRequestBytes || int64(n) > h.maxRequestBytes) {
+		return nil, "request-size", errors.Errorf("request exceeds the limit of %d bytes", h.maxRequestBytes)
+	}
+	reqBuf, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, "malformed", errors.Wrap(err, "decode request body")
+	}
+	var req prompb.WriteRequest
+	if err := proto.Unmarshal(reqBuf, &req); err != nil {
+		return nil, "malformed", errors.Wrap(err, "unmarshal write request")
+	}
+	return &req, "", nil
+}
+
+// validate checks the request against the limits and the label set of each series for sorted,
+// unique and valid UTF-8 labels. It returns the reason for rejecting an invalid request.
+func (h *Handler) validate(req *prompb.WriteRequest) (string, error) {
+	if h.maxSeries > 0 && len(req.Timeseries) > h.maxSeries {
+		return "series-limit", errors.Errorf("request has %d series, exceeding the limit of %d", len(req.Timeseries), h.maxSeries)
+	}
+	var samples int
+	for _, ts := range req.Timeseries {
+		samples += len(ts.Samples)
+	}
+	if h.maxSamples > 0 && samples > h.maxSamples {
+		return "samples-limit", errors.Errorf("request has %d samples, exceeding the limit of %d", samples, h.maxSamples)
+	}
+
+	for _, ts := range req.Timeseries {
+		for i, l := range ts.Labels {
+			if l.Name == "" {
+				return "invalid-labels", errors.Errorf("empty label name in series %s", formatLabels(ts.Labels))
+			}
+			if !utf8.ValidString(l.Name) || !utf8.ValidString(l.Value) {
+				return "invalid-utf8", errors.Errorf("label %q with value %q is not valid UTF-8", l.Name, l.Value)
+			}
+			if i == 0 {
+				continue
+			}
+			switch prev := ts.Labels[i-1].Name; {
+			case prev == l.Name:
+				return "duplicate-labels", errors.Errorf("duplicate label name %q in series %s", l.Name, formatLabels(ts.Labels))
+			case prev > l.Name:
+				return "unsorted-labels", errors.Errorf("labels are not sorted in series %s", formatLabels(ts.Labels))
+			}
+		}
+	}
+	return "", nil
+}
+
+func formatLabels(lbls []prompb.Label) string {
+	b := make([]byte, 0, 64)
+	b = append(b, '{')
+	for i, l := range lbls {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = append(b, fmt.Sprintf("%s=%q", l.Name, l.Value)...)
+	}
+	return string(append(b, '}'))
+}
+
+// validateTenant checks that the tenant can be used as a directory name.
+func validateTenant(tenant string) error {
+	if tenant == "" || tenant == "." || tenant == ".." || strings.ContainsAny(tenant, `/\`) {
+		return errors.Errorf("invalid tenant %q", tenant)
+	}
+	return nil
+}
+
+// write appends all samples of the request to the storage of the tenant in a single transaction.
+// Metadata sent along is stored if the storage supports it. New series exceeding the series limits of
+// the tenant are skipped, in which case the error of the limit is returned as limitErr.
+func (h *Handler) write(tenant string, req *prompb.WriteRequest) (limitErr error, err error) {
+	s, err := h.app.TenantAppendable(tenant)
+	if err != nil {
+		return nil, errors.Wrap(err, "get tenant storage")
+	}
+	lsets := make([]labels.Labels, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		lsets = append(lsets, h.enforceLabels(ts.Labels))
+	}
+	var admitted []bool
+	if h.seriesLimiter != nil {
+		admitted, limitErr = h.seriesLimiter.admit(tenant, lsets)
+		if admitted == nil {
+			return nil, errors.Wrap(limitErr, "check series limits")
+		}
+	}
+	app, err := s.Appender()
+	if err != nil {
+		return nil, errors.Wrap(err, "get appender")
+	}
+
+	for i, ts := range req.Timeseries {
+		lset := lsets[i]
+
+		if admitted != nil && !admitted[i] {
+			h.skippedSamples.WithLabelValues("series-limit").Add(float64(len(ts.Samples)))
+			continue
+		}
+		for _, s := range ts.Samples {
+			_, err := app.Add(lset, s.Timestamp, s.Value)
+			switch errors.Cause(err) {
+			case nil:
+			case storage.ErrOutOfOrderSample:
+				h.skippedSamples.WithLabelValues("out-of-order").Inc()
+			case storage.ErrDuplicateSampleForTimestamp:
+				h.skippedSamples.WithLabelValues("duplicate").Inc()
+			case storage.ErrOutOfBounds:
+				h.skippedSamples.WithLabelValues("out-of-bounds").Inc()
+			default:
+				if rerr := app.Rollback(); rerr != nil {
+					level.Warn(h.logger).Log("msg", "rollback failed", "err", rerr)
+				}
+				return nil, errors.Wrapf(err, "append sample for series %s", lset)
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit samples")
+	}
+	if u, ok := h.app.(MetadataUpdater); ok && len(req.Metadata) > 0 {
+		return limitErr, errors.Wrap(u.UpdateMetadata(tenant, req.Metadata), "update metadata")
+	}
+	return limitErr, nil
+}
+
+// enforceLabels converts the labels of a received series and sets the external labels on them.
+func (h *Handler) enforceLabels(lbls []prompb.Label) labels.Labels {
+	lset := make(labels.Labels, 0, len(lbls))
+	for _, l := range lbls {
+		lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	b := labels.NewBuilder(lset)
+
+	for _, l := range h.labels {
+		switch v := lset.Get(l.Name); {
+		case v == "":
+			h.enforcedLabels.WithLabelValues("added").Inc()
+		case v != l.Value:
+			h.enforcedLabels.WithLabelValues("overwritten").Inc()
+		}
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
@@ -0,0 +1,208 @@
+package receive
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type sample struct {
+	lset labels.Labels
+	t    int64
+	v    float64
+}
+
+type testAppendable struct {
+	samples []sample
+}
+
+type testTenants map[string]*testAppendable
+
+func (t testTenants) TenantAppendable(tenant string) (Appendable, error) {
+	if _, ok := t[tenant]; !ok {
+		t[tenant] = &testAppendable{}
+	}
+	return t[tenant], nil
+}
+
+func (a *testAppendable) Appender() (storage.Appender, error) {
+	return &testAppender{a: a}, nil
+}
+
+type testAppender struct {
+	a       *testAppendable
+	pending []sample
+}
+
+func (a *testAppender) Add(l labels.Labels, t int64, v float64) (uint64, error) {
+	if len(a.pending) > 0 && a.pending[len(a.pending)-1].t >= t {
+		return 0, storage.ErrOutOfOrderSample
+	}
+	a.pending = append(a.pending, sample{lset: l, t: t, v: v})
+	return 0, nil
+}
+
+func (a *testAppender) AddFast(l labels.Labels, ref uint64, t int64, v float64) error {
+	_, err := a.Add(l, t, v)
+	return err
+}
+
+func (a *testAppender) Commit() error {
+	a.a.samples = append(a.a.samples, a.pending...)
+	return nil
+}
+
+func (a *testAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
+
+func TestHandler_EnforcesExternalLabels(t *testing.T) {
+	tenants := testTenants{}
+	h := NewHandler(nil, nil, tenants, "THANOS-TENANT", "default-tenant", labels.FromStrings("tenant", "a", "region", "eu"), 0, 0, 0, SeriesLimits{})
+
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "tenant", Value: "b"}},
+				Samples: []prompb.Sample{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "region", Value: "eu"}},
+				Samples: []prompb.Sample{{Timestamp: 3, Value: 3}},
+			},
+		},
+	}
+	b, err := req.Marshal()
+	testutil.Ok(t, err)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/receive", bytes.NewReader(snappy.Encode(nil, b))))
+	testutil.Equals(t, http.StatusOK, rec.Code)
+	testutil.Equals(t, 1, len(tenants))
+
+	exp := labels.FromStrings("__name__", "up", "region", "eu", "tenant", "a")
+	testutil.Equals(t, []sample{
+		{lset: exp, t: 1, v: 1},
+		{lset: exp, t: 2, v: 2},
+		{lset: exp, t: 3, v: 3},
+	}, tenants["default-tenant"].samples)
+}
+
+func TestHandler_InvalidRequest(t *testing.T) {
+	h := NewHandler(nil, nil, testTenants{}, "THANOS-TENANT", "default-tenant", nil, 0, 0, 0, SeriesLimits{})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/receive", bytes.NewReader([]byte("not snappy"))))
+	testutil.Equals(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/receive", nil))
+	testutil.Equals(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHandler_Validate(t *testing.T) {
+	h := NewHandler(nil, nil, testTenants{}, "THANOS-TENANT", "default-tenant", nil, 0, 2, 3, SeriesLimits{})
+
+	for _, c := range []struct {
+		series []prompb.TimeSeries
+		reason string
+	}{
+		{
+			series: []prompb.TimeSeries{
+				{Labels: []prompb.Label{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, Samples: []prompb.Sample{{}, {}}},
+				{Labels: []prompb.Label{{Name: "a", Value: "2"}}, Samples: []prompb.Sample{{}}},
+			},
+		},
+		{
+			series: []prompb.TimeSeries{{}, {}, {}},
+			reason: "series-limit",
+		},
+		{
+			series: []prompb.TimeSeries{{Samples: []prompb.Sample{{}, {}}}, {Samples: []prompb.Sample{{}, {}}}},
+			reason: "samples-limit",
+		},
+		{
+			series: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "b", Value: "1"}, {Name: "a", Value: "2"}}}},
+			reason: "unsorted-labels",
+		},
+		{
+			series: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "a", Value: "1"}, {Name: "a", Value: "2"}}}},
+			reason: "duplicate-labels",
+		},
+		{
+			series: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "a", Value: "\xff"}}}},
+			reason: "invalid-utf8",
+		},
+		{
+			series: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "", Value: "1"}}}},
+			reason: "invalid-labels",
+		},
+	} {
+		reason, err := h.validate(&prompb.WriteRequest{Timeseries: c.series})
+		testutil.Equals(t, c.reason, reason)
+		testutil.Equals(t, c.reason == "", err == nil)
+	}
+}
+
+func TestHandler_RequestLimit(t *testing.T) {
+	h := NewHandler(nil, nil, testTenants{}, "THANOS-TENANT", "default-tenant", nil, 100, 0, 0, SeriesLimits{})
+
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "a", Value: string(make([]byte, 200))}},
+			Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+		}},
+	}
+	b, err := req.Marshal()
+	testutil.Ok(t, err)
+
+	// The label value compresses well, but the uncompressed size must be limited.
+	compressed := snappy.Encode(nil, b)
+	testutil.Assert(t, len(compressed) < 100, "request not compressed below limit")
+
+	_, reason, err := h.readRequest(bytes.NewReader(compressed))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, "request-size", reason)
+}
+
+func TestHandler_Tenants(t *testing.T) {
+	tenants := testTenants{}
+	h := NewHandler(nil, nil, tenants, "THANOS-TENANT", "default-tenant", nil, 0, 0, 0, SeriesLimits{})
+
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Timestamp: 1, Value: 1}},
+		}},
+	}
+	b, err := req.Marshal()
+	testutil.Ok(t, err)
+
+	for _, c := range []struct {
+		tenant string
+		code   int
+	}{
+		{tenant: "team-a", code: http.StatusOK},
+		{tenant: "team-b", code: http.StatusOK},
+		{tenant: "../team-a", code: http.StatusBadRequest},
+		{tenant: "..", code: http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("POST", "/api/v1/receive", bytes.NewReader(snappy.Encode(nil, b)))
+		r.Header.Set("THANOS-TENANT", c.tenant)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		testutil.Equals(t, c.code, rec.Code)
+	}
+	testutil.Equals(t, 2, len(tenants))
+	testutil.Equals(t, 1, len(tenants["team-a"].samples))
+	testutil.Equals(t, 1, len(tenants["team-b"].samples))
+}
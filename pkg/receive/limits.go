@@ -0,0 +1,198 @@
+package receive
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/chunks"
+	tsdblabels "github.com/prometheus/tsdb/labels"
+)
+
+// SeriesLimits limits the series of every tenant, protecting a shared receiver from the cardinality
+// of a single tenant. A zero value disables a limit.
+type SeriesLimits struct {
+	// MaxActiveSeries is the maximum number of series in the head of a tenant's TSDB.
+	MaxActiveSeries int
+	// NewSeriesPerSecond is the rate at which a tenant may create new series. Bursts of up to a minute
+	// worth of new series are allowed, e.g. for new scrape targets.
+	NewSeriesPerSecond float64
+}
+
+// TenantSeries reports the series of the storage of a tenant.
+type TenantSeries interface {
+	// TenantSeries returns the number of active series of the tenant, 0 if the tenant has no storage yet.
+	TenantSeries(tenant string) float64
+	// HasSeries returns for each of the given series whether it exists in the storage of the tenant.
+	HasSeries(tenant string, lsets []labels.Labels) ([]bool, error)
+}
+
+type seriesLimitState struct {
+	tokens  float64
+	updated time.Time
+}
+
+// seriesLimiter enforces SeriesLimits. It admits the series of each write one by one, so that only the new series
+// exceeding a limit are rejected, while samples of existing series are always appended. Concurrent writes of a
+// tenant may exceed the active series limit by the new series they admit at the same time.
+type seriesLimiter struct {
+	limits SeriesLimits
+	series TenantSeries
+	now    func() time.Time
+
+	mtx     sync.Mutex
+	tenants map[string]*seriesLimitState
+
+	rejected *prometheus.CounterVec
+}
+
+func newSeriesLimiter(reg prometheus.Registerer, limits SeriesLimits, series TenantSeries) *seriesLimiter {
+	l := &seriesLimiter{
+		limits:  limits,
+		series:  series,
+		now:     time.Now,
+		tenants: map[string]*seriesLimitState{},
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_rejected_series_total",
+			Help: "Number of new series of a tenant rejected because the tenant exceeded a series limit.",
+		}, []string{"tenant", "reason"}),
+	}
+	if reg != nil {
+		reg.MustRegister(l.rejected)
+	}
+	return l
+}
+
+func (l *seriesLimiter) burst() float64 {
+	return math.Max(1, 60*l.limits.NewSeriesPerSecond)
+}
+
+// admit returns for each of the series of a write of the tenant whether it may be appended. Series that exist in
+// the storage of the tenant are always admitted, new series as long as the tenant stays within its limits. If any
+// series is rejected, an error naming the tenant and the exceeded limit is returned along.
+func (l *seriesLimiter) admit(tenant string, lsets []labels.Labels) ([]bool, error) {
+	exists, err := l.series.HasSeries(tenant, lsets)
+	if err != nil {
+		return nil, errors.Wrap(err, "look up series")
+	}
+	active := l.series.TenantSeries(tenant)
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.now()
+	s, ok := l.tenants[tenant]
+	if !ok {
+		s = &seriesLimitState{tokens: l.burst(), updated: now}
+		l.tenants[tenant] = s
+	}
+	s.tokens = math.Min(l.burst(), s.tokens+now.Sub(s.updated).Seconds()*l.limits.NewSeriesPerSecond)
+	s.updated = now
+
+	var (
+		admitted                     = make([]bool, len(lsets))
+		activeRejected, rateRejected int
+	)
+	for i := range lsets {
+		switch {
+		case exists[i]:
+		case l.limits.MaxActiveSeries > 0 && active >= float64(l.limits.MaxActiveSeries):
+			activeRejected++
+			continue
+		case l.limits.NewSeriesPerSecond > 0 && s.tokens < 1:
+			rateRejected++
+			continue
+		default:
+			active++
+			s.tokens--
+		}
+		admitted[i] = true
+	}
+	if activeRejected > 0 {
+		l.rejected.WithLabelValues(tenant, "active-series-limit").Add(float64(activeRejected))
+		return admitted, errors.Errorf("tenant %q reached its limit of %d active series, %d new series were rejected",
+			tenant, l.limits.MaxActiveSeries, activeRejected+rateRejected)
+	}
+	if rateRejected > 0 {
+		l.rejected.WithLabelValues(tenant, "new-series-limit").Add(float64(rateRejected))
+		return admitted, errors.Errorf("tenant %q exceeded its limit of %g new series per second, %d new series were rejected",
+			tenant, l.limits.NewSeriesPerSecond, rateRejected)
+	}
+	return admitted, nil
+}
+
+// TenantSeries returns the number of series in the head of the tenant's TSDB.
+func (t *MultiTSDB) TenantSeries(id string) float64 {
+	t.mtx.RLock()
+	tn, ok := t.tenants[id]
+	t.mtx.RUnlock()
+
+	if !ok {
+		return 0
+	}
+	tn.mtx.RLock()
+	defer tn.mtx.RUnlock()
+
+	if tn.db == nil {
+		return 0
+	}
+	return float64(tn.db.Head().NumSeries())
+}
+
+// HasSeries returns for each of the given series whether it exists in the head of the tenant's TSDB.
+func (t *MultiTSDB) HasSeries(id string, lsets []labels.Labels) (_ []bool, err error) {
+	exists := make([]bool, len(lsets))
+
+	t.mtx.RLock()
+	tn, ok := t.tenants[id]
+	t.mtx.RUnlock()
+
+	if !ok {
+		return exists, nil
+	}
+	tn.mtx.RLock()
+	defer tn.mtx.RUnlock()
+
+	if tn.db == nil {
+		return exists, nil
+	}
+	ir, err := tn.db.Head().Index()
+	if err != nil {
+		return nil, errors.Wrap(err, "open head index")
+	}
+	defer runutil.BestEffortErr(nil, &err, ir, "head index reader")
+
+	var (
+		lset tsdblabels.Labels
+		chks []chunks.Meta
+	)
+	for i, ls := range lsets {
+		ms := make([]tsdblabels.Matcher, 0, len(ls))
+		for _, l := range ls {
+			ms = append(ms, tsdblabels.NewEqualMatcher(l.Name, l.Value))
+		}
+		p, err := tsdb.PostingsForMatchers(ir, ms...)
+		if err != nil {
+			return nil, errors.Wrap(err, "get postings")
+		}
+		// Series with all of the labels are equal if they have no other labels.
+		for p.Next() {
+			if err := ir.Series(p.At(), &lset, &chks); err != nil {
+				return nil, errors.Wrap(err, "read series")
+			}
+			if len(lset) == len(ls) {
+				exists[i] = true
+				break
+			}
+		}
+		if p.Err() != nil {
+			return nil, errors.Wrap(p.Err(), "iterate postings")
+		}
+	}
+	return exists, nil
+}
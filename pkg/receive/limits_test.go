@@ -0,0 +1,100 @@
+package receive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// testSeries holds the series of each tenant.
+type testSeries map[string]map[string]struct{}
+
+func (s testSeries) TenantSeries(tenant string) float64 {
+	return float64(len(s[tenant]))
+}
+
+func (s testSeries) HasSeries(tenant string, lsets []labels.Labels) ([]bool, error) {
+	exists := make([]bool, len(lsets))
+	for i, lset := range lsets {
+		_, exists[i] = s[tenant][lset.String()]
+	}
+	return exists, nil
+}
+
+// add adds the admitted series to the tenant, like a write would.
+func (s testSeries) add(tenant string, lsets []labels.Labels, admitted []bool) {
+	if s[tenant] == nil {
+		s[tenant] = map[string]struct{}{}
+	}
+	for i, lset := range lsets {
+		if admitted[i] {
+			s[tenant][lset.String()] = struct{}{}
+		}
+	}
+}
+
+func newTestSeries(from, to int) []labels.Labels {
+	var lsets []labels.Labels
+	for i := from; i < to; i++ {
+		lsets = append(lsets, labels.FromStrings("i", string(rune('a'+i/26))+string(rune('a'+i%26))))
+	}
+	return lsets
+}
+
+func TestSeriesLimiter(t *testing.T) {
+	series := testSeries{}
+	l := newSeriesLimiter(nil, SeriesLimits{MaxActiveSeries: 100, NewSeriesPerSecond: 1}, series)
+
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+
+	countAdmitted := func(admitted []bool) (n int) {
+		for _, a := range admitted {
+			if a {
+				n++
+			}
+		}
+		return n
+	}
+
+	// A burst of a minute worth of new series is allowed, even within a single write. Only the new series beyond
+	// it are rejected.
+	lsets := newTestSeries(0, 70)
+	admitted, err := l.admit("a", lsets)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 60, countAdmitted(admitted))
+	series.add("a", lsets, admitted)
+
+	// Existing series are still admitted once the rate is exceeded.
+	admitted, err = l.admit("a", newTestSeries(0, 61))
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 60, countAdmitted(admitted))
+	testutil.Assert(t, !admitted[60], "new series admitted")
+
+	// The tenant may create new series again once the rate caught up.
+	now = now.Add(10 * time.Second)
+	lsets = newTestSeries(60, 75)
+	admitted, err = l.admit("a", lsets)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 10, countAdmitted(admitted))
+	series.add("a", lsets, admitted)
+
+	// At the active series limit only existing series are admitted.
+	now = now.Add(time.Hour)
+	lsets = newTestSeries(0, 110)
+	admitted, err = l.admit("a", lsets)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 100, countAdmitted(admitted))
+	series.add("a", lsets, admitted)
+
+	admitted, err = l.admit("a", newTestSeries(0, 100))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 100, countAdmitted(admitted))
+
+	// Other tenants are not affected.
+	admitted, err = l.admit("b", newTestSeries(0, 10))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 10, countAdmitted(admitted))
+}
@@ -0,0 +1,63 @@
+package receive
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+)
+
+// metadataStore keeps the metric metadata received from remote write clients in memory.
+// Different clients may send differing metadata for the same metric, so all variants are kept.
+// The metadata is not persisted and is only available again once clients resend it after a restart.
+type metadataStore struct {
+	mtx      sync.RWMutex
+	metadata map[string]map[storepb.MetricMetadata]struct{}
+}
+
+func newMetadataStore() *metadataStore {
+	return &metadataStore{metadata: map[string]map[storepb.MetricMetadata]struct{}{}}
+}
+
+// add stores the given metadata.
+func (s *metadataStore) add(mds []prompb.MetricMetadata) {
+	if len(mds) == 0 {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, md := range mds {
+		m := storepb.MetricMetadata{
+			Metric: md.MetricFamilyName,
+			Type:   strings.ToLower(md.Type.String()),
+			Help:   md.Help,
+			Unit:   md.Unit,
+		}
+		variants, ok := s.metadata[m.Metric]
+		if !ok {
+			variants = map[storepb.MetricMetadata]struct{}{}
+			s.metadata[m.Metric] = variants
+		}
+		variants[m] = struct{}{}
+	}
+}
+
+// get returns the metadata of the given metric or of all metrics if it is empty.
+func (s *metadataStore) get(metric string) []storepb.MetricMetadata {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var res []storepb.MetricMetadata
+	for name, variants := range s.metadata {
+		if metric != "" && name != metric {
+			continue
+		}
+		for m := range variants {
+			res = append(res, m)
+		}
+	}
+	storepb.SortMetricMetadata(res)
+	return res
+}
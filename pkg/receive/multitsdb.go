@@ -0,0 +1,397 @@
+package receive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/improbable-eng/thanos/pkg/shipper"
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/tsdb"
+	promtsdb "github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc"
+)
+
+// ErrTooManyTenants is returned for new tenants once the maximum number of tenants is open.
+var ErrTooManyTenants = errors.New("too many tenants")
+
+// MultiTSDB manages a separate TSDB for each tenant in a sub-directory of the data directory
+// named after the tenant. The tenant is attached as an external label to the data of its TSDB.
+type MultiTSDB struct {
+	logger          log.Logger
+	dir             string
+	opts            *tsdb.Options
+	labels          labels.Labels
+	tenantLabelName string
+	bucket          objstore.Bucket
+	maxTenants      int
+
+	// Retention of uploaded blocks. If blocks are uploaded, they are deleted by the MultiTSDB once they were
+	// uploaded instead of by the retention of the TSDBs, which deletes blocks whether or not they were uploaded.
+	retention time.Duration
+
+	mtx     sync.RWMutex
+	tenants map[string]*tenant
+
+	walReplayDuration *prometheus.GaugeVec
+	shippedBlocks     *prometheus.GaugeVec
+	lastShippedTime   *prometheus.GaugeVec
+}
+
+type tenant struct {
+	id       string
+	dir      string
+	labels   labels.Labels
+	ship     *shipper.Shipper
+	metadata *metadataStore
+
+	// mtx guards the TSDB, which is reopened when its head is flushed. Appenders hold it for reading
+	// until they are committed or rolled back, so no samples are appended during a flush.
+	mtx   sync.RWMutex
+	db    *tsdb.DB
+	reg   *prometheus.Registry
+	store *store.TSDBStore
+}
+
+// NewMultiTSDB returns a new MultiTSDB. If the bucket is nil, blocks are not uploaded. Otherwise blocks are only
+// deleted by the retention once they were uploaded. At most maxTenants tenants are created, tenants that
+// already have a directory on startup are always opened. 0 allows any number of tenants.
+func NewMultiTSDB(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	dir string,
+	opts *tsdb.Options,
+	externalLabels labels.Labels,
+	tenantLabelName string,
+	bucket objstore.Bucket,
+	maxTenants int,
+) *MultiTSDB {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	t := &MultiTSDB{
+		logger:          logger,
+		dir:             dir,
+		opts:            opts,
+		labels:          externalLabels,
+		tenantLabelName: tenantLabelName,
+		bucket:          bucket,
+		maxTenants:      maxTenants,
+		tenants:         map[string]*tenant{},
+	}
+	if bucket != nil && opts.Retention > 0 {
+		o := *opts
+		t.opts, t.retention, o.Retention = &o, time.Duration(o.Retention), 0
+	}
+	t.walReplayDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_receive_tsdb_wal_replay_duration_seconds",
+		Help: "Time it took to open the TSDB of a tenant, which is dominated by replaying its write ahead log.",
+	}, []string{"tenant"})
+	t.shippedBlocks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_receive_shipped_blocks",
+		Help: "Number of blocks of a tenant that were uploaded to object storage.",
+	}, []string{"tenant"})
+	t.lastShippedTime = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_receive_last_shipped_block_max_time_seconds",
+		Help: "Maximum timestamp of the newest block of a tenant that was uploaded to object storage.",
+	}, []string{"tenant"})
+
+	if reg != nil {
+		reg.MustRegister(t.walReplayDuration, t.shippedBlocks, t.lastShippedTime, &headCollector{tenants: t.list})
+	}
+	return t
+}
+
+// Open opens the TSDBs of all tenants that already have a directory, which replays their write ahead logs.
+// Only directories with a valid tenant name holding a write ahead log are opened, others are left untouched.
+func (t *MultiTSDB) Open() error {
+	if err := os.MkdirAll(t.dir, 0777); err != nil {
+		return errors.Wrap(err, "create data directory")
+	}
+	files, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return errors.Wrap(err, "read data directory")
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if err := validateTenant(f.Name()); err != nil {
+			level.Warn(t.logger).Log("msg", "ignoring directory that is not a tenant TSDB", "dir", f.Name(), "err", err)
+			continue
+		}
+		if fi, err := os.Stat(filepath.Join(t.dir, f.Name(), "wal")); err != nil || !fi.IsDir() {
+			level.Warn(t.logger).Log("msg", "ignoring directory without write ahead log that is not a tenant TSDB", "dir", f.Name())
+			continue
+		}
+		if _, err := t.openTenant(f.Name(), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the TSDBs of all tenants.
+func (t *MultiTSDB) Close() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	var merr error
+	for id, tn := range t.tenants {
+		tn.mtx.Lock()
+		if tn.db != nil {
+			if err := tn.db.Close(); err != nil {
+				level.Error(t.logger).Log("msg", "closing TSDB failed", "tenant", id, "err", err)
+				merr = err
+			}
+			tn.db = nil
+		}
+		tn.mtx.Unlock()
+		delete(t.tenants, id)
+	}
+	return merr
+}
+
+// TenantAppendable returns the storage of the given tenant. Its TSDB is created if it does not exist yet.
+func (t *MultiTSDB) TenantAppendable(id string) (Appendable, error) {
+	return t.tenant(id)
+}
+
+// UpdateMetadata stores the metric metadata received for the given tenant.
+func (t *MultiTSDB) UpdateMetadata(id string, md []prompb.MetricMetadata) error {
+	tn, err := t.tenant(id)
+	if err != nil {
+		return err
+	}
+	tn.metadata.add(md)
+	return nil
+}
+
+func (t *MultiTSDB) tenant(id string) (*tenant, error) {
+	return t.openTenant(id, true)
+}
+
+// openTenant returns the tenant, whose TSDB is opened if it is not open yet. If limit is set, no new tenant
+// is opened once the maximum number of tenants is reached.
+func (t *MultiTSDB) openTenant(id string, limit bool) (*tenant, error) {
+	t.mtx.RLock()
+	tn, ok := t.tenants[id]
+	t.mtx.RUnlock()
+	if ok {
+		return tn, nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if tn, ok := t.tenants[id]; ok {
+		return tn, nil
+	}
+	if err := validateTenant(id); err != nil {
+		return nil, err
+	}
+	if limit && t.maxTenants > 0 && len(t.tenants) >= t.maxTenants {
+		return nil, errors.Wrapf(ErrTooManyTenants, "open tenant %s, %d tenants are open", id, len(t.tenants))
+	}
+	lset := append(labels.Labels{{Name: t.tenantLabelName, Value: id}}, t.labels...)
+	sort.Sort(lset)
+
+	tn = &tenant{
+		id:       id,
+		dir:      filepath.Join(t.dir, id),
+		labels:   lset,
+		metadata: newMetadataStore(),
+	}
+	if err := t.openDB(tn); err != nil {
+		return nil, err
+	}
+	if t.bucket != nil {
+		tn.ship = shipper.New(log.With(t.logger, "tenant", id), nil, tn.dir, t.bucket, func() labels.Labels { return lset }, block.ReceiveSource, false, false)
+	}
+	t.tenants[id] = tn
+
+	return tn, nil
+}
+
+// openDB opens the TSDB of the tenant, which replays its write ahead log.
+func (t *MultiTSDB) openDB(tn *tenant) error {
+	logger := log.With(t.logger, "tenant", tn.id)
+
+	// The metrics of each TSDB are registered with a separate registry as they would collide between tenants.
+	reg := prometheus.NewRegistry()
+
+	begin := time.Now()
+	db, err := tsdb.Open(tn.dir, log.With(logger, "component", "tsdb"), reg, t.opts)
+	if err != nil {
+		return errors.Wrapf(err, "open TSDB of tenant %s", tn.id)
+	}
+	t.walReplayDuration.WithLabelValues(tn.id).Set(time.Since(begin).Seconds())
+	level.Info(logger).Log("msg", "TSDB opened", "duration", time.Since(begin))
+
+	tn.db = db
+	tn.reg = reg
+	tn.store = store.NewTSDBStore(log.With(logger, "component", "store"), nil, db, tn.labels)
+	return nil
+}
+
+// Appender returns an appender for the TSDB of the tenant. The TSDB is not flushed until the appender
+// is committed or rolled back.
+func (tn *tenant) Appender() (storage.Appender, error) {
+	tn.mtx.RLock()
+	if tn.db == nil {
+		tn.mtx.RUnlock()
+		return nil, errors.Errorf("TSDB of tenant %s is closed", tn.id)
+	}
+	app, err := tsdb.Adapter(tn.db, 0).Appender()
+	if err != nil {
+		tn.mtx.RUnlock()
+		return nil, err
+	}
+	return &tenantAppender{Appender: app, release: tn.mtx.RUnlock}, nil
+}
+
+// blockMetas returns the metadata of the persisted blocks of the tenant's TSDB, ordered by time.
+func (tn *tenant) blockMetas() []promtsdb.BlockMeta {
+	tn.mtx.RLock()
+	defer tn.mtx.RUnlock()
+
+	if tn.db == nil {
+		return nil
+	}
+	var res []promtsdb.BlockMeta
+	for _, b := range tn.db.Blocks() {
+		res = append(res, b.Meta())
+	}
+	return res
+}
+
+// storeClient returns a client for the Store API of the tenant's current TSDB.
+func (tn *tenant) storeClient() store.Client {
+	tn.mtx.RLock()
+	defer tn.mtx.RUnlock()
+
+	return &tenantClient{
+		StoreClient: storepb.ServerAsClient(tn.store),
+		tenant:      tn,
+	}
+}
+
+// tenantAppender releases the tenant's TSDB once it is committed or rolled back.
+type tenantAppender struct {
+	storage.Appender
+
+	once    sync.Once
+	release func()
+}
+
+func (a *tenantAppender) Commit() error {
+	defer a.once.Do(a.release)
+	return a.Appender.Commit()
+}
+
+func (a *tenantAppender) Rollback() error {
+	defer a.once.Do(a.release)
+	return a.Appender.Rollback()
+}
+
+func (t *MultiTSDB) list() []*tenant {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	res := make([]*tenant, 0, len(t.tenants))
+	for _, tn := range t.tenants {
+		res = append(res, tn)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].id < res[j].id })
+	return res
+}
+
+// Sync uploads new blocks of all tenants to the bucket and deletes uploaded blocks beyond the retention.
+func (t *MultiTSDB) Sync(ctx context.Context) {
+	for _, tn := range t.list() {
+		if tn.ship == nil {
+			continue
+		}
+		tn.ship.Sync(ctx)
+
+		if t.retention > 0 {
+			before := time.Now().Add(-t.retention).UnixNano() / int64(time.Millisecond)
+			if _, err := tn.ship.DeleteUploaded(before); err != nil {
+				level.Warn(t.logger).Log("msg", "deleting uploaded blocks failed", "tenant", tn.id, "err", err)
+			}
+		}
+
+		meta, err := shipper.ReadMetaFile(tn.dir)
+		if err != nil {
+			level.Warn(t.logger).Log("msg", "reading shipper meta file failed", "tenant", tn.id, "err", err)
+			continue
+		}
+		t.shippedBlocks.WithLabelValues(tn.id).Set(float64(len(meta.Uploaded)))
+
+		_, maxSyncTime, err := tn.ship.Timestamps()
+		if err != nil {
+			level.Warn(t.logger).Log("msg", "reading timestamps failed", "tenant", tn.id, "err", err)
+			continue
+		}
+		if maxSyncTime != math.MinInt64 {
+			t.lastShippedTime.WithLabelValues(tn.id).Set(float64(maxSyncTime) / 1000)
+		}
+	}
+}
+
+// StoreClients returns clients for the Store APIs of all tenants.
+func (t *MultiTSDB) StoreClients(context.Context) ([]store.Client, error) {
+	tenants := t.list()
+
+	res := make([]store.Client, 0, len(tenants))
+	for _, tn := range tenants {
+		res = append(res, tn.storeClient())
+	}
+	return res, nil
+}
+
+// tenantClient is a store client for the TSDB of a single tenant.
+type tenantClient struct {
+	storepb.StoreClient
+	tenant *tenant
+}
+
+func (c *tenantClient) Labels() []storepb.Label {
+	res := make([]storepb.Label, 0, len(c.tenant.labels))
+	for _, l := range c.tenant.labels {
+		res = append(res, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+func (c *tenantClient) TimeRange() (int64, int64) {
+	if metas := c.tenant.blockMetas(); len(metas) > 0 {
+		return metas[0].MinTime, math.MaxInt64
+	}
+	return 0, math.MaxInt64
+}
+
+// MetricMetadata returns the metadata received for the tenant instead of asking its TSDB, which does not store it.
+func (c *tenantClient) MetricMetadata(_ context.Context, r *storepb.MetricMetadataRequest, _ ...grpc.CallOption) (*storepb.MetricMetadataResponse, error) {
+	return &storepb.MetricMetadataResponse{Metadata: c.tenant.metadata.get(r.Metric)}, nil
+}
+
+func (c *tenantClient) String() string {
+	return fmt.Sprintf("tenant %s", c.tenant.id)
+}
@@ -0,0 +1,230 @@
+package receive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/prompb"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage/tsdb"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestMultiTSDB(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "test_multitsdb")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := &tsdb.Options{
+		MinBlockDuration: model.Duration(2 * time.Hour),
+		MaxBlockDuration: model.Duration(2 * time.Hour),
+		Retention:        model.Duration(time.Hour),
+		NoLockfile:       true,
+	}
+	dbs := NewMultiTSDB(nil, nil, dir, opts, labels.FromStrings("replica", "01"), "tenant_id", nil, 0)
+	testutil.Ok(t, dbs.Open())
+
+	for _, tenant := range []string{"b", "a"} {
+		s, err := dbs.TenantAppendable(tenant)
+		testutil.Ok(t, err)
+
+		app, err := s.Appender()
+		testutil.Ok(t, err)
+		_, err = app.Add(promlabels.FromStrings("__name__", "up"), 1000, 1)
+		testutil.Ok(t, err)
+		testutil.Ok(t, app.Commit())
+	}
+
+	clients, err := dbs.StoreClients(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(clients))
+	testutil.Equals(t, "tenant a", clients[0].String())
+	testutil.Equals(t, []storepb.Label{{Name: "replica", Value: "01"}, {Name: "tenant_id", Value: "a"}}, clients[0].Labels())
+
+	testutil.Ok(t, dbs.Close())
+
+	// Other directories in the data directory are not opened as tenants.
+	testutil.Ok(t, os.MkdirAll(filepath.Join(dir, "lost+found"), 0777))
+
+	// Existing tenants are opened again on startup, even beyond the maximum number of tenants.
+	dbs = NewMultiTSDB(nil, nil, dir, opts, labels.FromStrings("replica", "01"), "tenant_id", nil, 1)
+	testutil.Ok(t, dbs.Open())
+	defer func() { testutil.Ok(t, dbs.Close()) }()
+
+	clients, err = dbs.StoreClients(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(clients))
+
+	// No new tenants are created beyond the maximum.
+	_, err = dbs.TenantAppendable("c")
+	testutil.Equals(t, ErrTooManyTenants, errors.Cause(err))
+	_, err = dbs.TenantAppendable("a")
+	testutil.Ok(t, err)
+
+	_, err = dbs.TenantAppendable("..")
+	testutil.NotOk(t, err)
+}
+
+func TestMultiTSDB_Metadata(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "test_multitsdb_metadata")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	dbs := NewMultiTSDB(nil, nil, dir, &tsdb.Options{
+		MinBlockDuration: model.Duration(2 * time.Hour),
+		MaxBlockDuration: model.Duration(2 * time.Hour),
+		Retention:        model.Duration(time.Hour),
+		NoLockfile:       true,
+	}, nil, "tenant_id", nil, 0)
+	testutil.Ok(t, dbs.Open())
+	defer func() { testutil.Ok(t, dbs.Close()) }()
+
+	testutil.Ok(t, dbs.UpdateMetadata("a", []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total", Help: "Number of requests."},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Target is up."},
+	}))
+	// Resent and differing metadata of another client.
+	testutil.Ok(t, dbs.UpdateMetadata("a", []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_requests_total", Help: "Total HTTP requests."},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up", Help: "Target is up."},
+	}))
+
+	clients, err := dbs.StoreClients(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(clients))
+
+	resp, err := clients[0].MetricMetadata(context.Background(), &storepb.MetricMetadataRequest{Metric: "http_requests_total"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.MetricMetadata{
+		{Metric: "http_requests_total", Type: "counter", Help: "Number of requests."},
+		{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests."},
+	}, resp.Metadata)
+
+	resp, err = clients[0].MetricMetadata(context.Background(), &storepb.MetricMetadataRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, len(resp.Metadata))
+}
+
+func TestMultiTSDB_TenantStoreClients(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "test_multitsdb_tenant_clients")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	dbs := NewMultiTSDB(nil, nil, dir, &tsdb.Options{
+		MinBlockDuration: model.Duration(2 * time.Hour),
+		MaxBlockDuration: model.Duration(2 * time.Hour),
+		Retention:        model.Duration(time.Hour),
+		NoLockfile:       true,
+	}, nil, "tenant_id", nil, 0)
+	testutil.Ok(t, dbs.Open())
+	defer func() { testutil.Ok(t, dbs.Close()) }()
+
+	for _, tenant := range []string{"a", "b"} {
+		_, err := dbs.TenantAppendable(tenant)
+		testutil.Ok(t, err)
+	}
+
+	// Queries without a tenant must not read the data of all tenants.
+	_, err = dbs.TenantStoreClients(context.Background())
+	testutil.NotOk(t, err)
+
+	clients, err := dbs.TenantStoreClients(WithTenant(context.Background(), "b"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(clients))
+	testutil.Equals(t, "tenant b", clients[0].String())
+
+	// Queries of unknown tenants do not create a TSDB.
+	clients, err = dbs.TenantStoreClients(WithTenant(context.Background(), "c"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(clients))
+
+	clients, err = dbs.StoreClients(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(clients))
+}
+
+func TestMultiTSDB_Flush(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "test_multitsdb_flush")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	dbs := NewMultiTSDB(nil, nil, dir, &tsdb.Options{
+		MinBlockDuration: model.Duration(2 * time.Hour),
+		MaxBlockDuration: model.Duration(2 * time.Hour),
+		Retention:        model.Duration(24 * time.Hour),
+		NoLockfile:       true,
+	}, nil, "tenant_id", nil, 0)
+	testutil.Ok(t, dbs.Open())
+	defer func() { testutil.Ok(t, dbs.Close()) }()
+
+	appendSample := func(ts int64) error {
+		s, err := dbs.TenantAppendable("a")
+		testutil.Ok(t, err)
+		app, err := s.Appender()
+		testutil.Ok(t, err)
+		if _, err := app.Add(promlabels.FromStrings("__name__", "up"), ts, 1); err != nil {
+			testutil.Ok(t, app.Rollback())
+			return err
+		}
+		return app.Commit()
+	}
+	for ts := int64(1000); ts <= 5000; ts += 1000 {
+		testutil.Ok(t, appendSample(ts))
+	}
+
+	testutil.NotOk(t, dbs.Flush("unknown"))
+	testutil.Ok(t, dbs.Flush("a"))
+
+	tn, err := dbs.tenant("a")
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(tn.blockMetas()))
+
+	series, _, ok := tn.headStats()
+	testutil.Assert(t, ok, "head stats missing")
+	testutil.Equals(t, float64(0), series)
+
+	// Samples older than the flushed block are rejected, newer ones are appended to the head.
+	testutil.NotOk(t, appendSample(2000))
+	testutil.Ok(t, appendSample(6000))
+
+	clients, err := dbs.StoreClients(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(clients))
+
+	sc, err := clients[0].Series(context.Background(), &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  10000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+	})
+	testutil.Ok(t, err)
+
+	var chunks int
+	for {
+		resp, err := sc.Recv()
+		if err == io.EOF {
+			break
+		}
+		testutil.Ok(t, err)
+		chunks += len(resp.GetSeries().Chunks)
+	}
+	// One chunk of the flushed block and one of the head.
+	testutil.Equals(t, 2, chunks)
+}
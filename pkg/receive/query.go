@@ -0,0 +1,55 @@
+package receive
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/improbable-eng/thanos/pkg/store"
+	"github.com/pkg/errors"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context for queries that may only read the data of the given tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func tenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// NewQueryHandler returns a handler that runs the queries of h for the tenant set in the tenant header,
+// or the default tenant if there is none. The queries only read the data of that tenant if h reads
+// through TenantStoreClients.
+func NewQueryHandler(h http.Handler, tenantHeader, defaultTenant string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if tenant == "" {
+			tenant = defaultTenant
+		}
+		if err := validateTenant(tenant); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	})
+}
+
+// TenantStoreClients returns a client for the Store API of the tenant set in the context only.
+// It fails for contexts without a tenant, so queries can never read the data of all tenants by accident.
+// Unlike writes, queries of unknown tenants do not create a TSDB, they find no stores instead.
+func (t *MultiTSDB) TenantStoreClients(ctx context.Context) ([]store.Client, error) {
+	id, ok := tenantFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no tenant set for query")
+	}
+	t.mtx.RLock()
+	tn, ok := t.tenants[id]
+	t.mtx.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return []store.Client{tn.storeClient()}, nil
+}
@@ -0,0 +1,153 @@
+// Package selfscrape stores the metrics of a component in its own TSDB, which allows small deployments
+// to monitor Thanos without a separate Prometheus server.
+package selfscrape
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// Appendable returns appenders to write samples to a storage.
+type Appendable interface {
+	Appender() (storage.Appender, error)
+}
+
+// Scraper appends the metrics gathered from a registry to a storage, like a scrape of the component's
+// /metrics endpoint would.
+type Scraper struct {
+	gatherer prometheus.Gatherer
+	app      Appendable
+	// lset are the target labels attached to all scraped series, e.g. the job.
+	lset labels.Labels
+}
+
+// New returns a new Scraper that attaches the given target labels to all scraped series.
+func New(gatherer prometheus.Gatherer, app Appendable, lset labels.Labels) *Scraper {
+	return &Scraper{gatherer: gatherer, app: app, lset: lset}
+}
+
+// Scrape gathers all metrics and appends them with the given timestamp. Metrics are not appended at all
+// if any of them fails to be gathered or appended.
+func (s *Scraper) Scrape(t time.Time) error {
+	mfs, err := s.gatherer.Gather()
+	if err != nil {
+		return errors.Wrap(err, "gather metrics")
+	}
+	app, err := s.app.Appender()
+	if err != nil {
+		return errors.Wrap(err, "get appender")
+	}
+	ts := timestamp.FromTime(t)
+
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			for _, smpl := range s.samples(mf, m) {
+				if _, err := app.Add(smpl.lset, ts, smpl.v); err != nil {
+					app.Rollback()
+					return errors.Wrapf(err, "append sample of %s", smpl.lset)
+				}
+			}
+		}
+	}
+	return errors.Wrap(app.Commit(), "commit samples")
+}
+
+type sample struct {
+	lset labels.Labels
+	v    float64
+}
+
+// samples returns the samples of a metric. Summaries and histograms are split into their quantiles or
+// buckets and their sums and counts, as in the text exposition format.
+func (s *Scraper) samples(mf *dto.MetricFamily, m *dto.Metric) []sample {
+	name := mf.GetName()
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return []sample{{lset: s.labels(name, m), v: m.GetCounter().GetValue()}}
+	case dto.MetricType_GAUGE:
+		return []sample{{lset: s.labels(name, m), v: m.GetGauge().GetValue()}}
+	case dto.MetricType_UNTYPED:
+		return []sample{{lset: s.labels(name, m), v: m.GetUntyped().GetValue()}}
+	case dto.MetricType_SUMMARY:
+		sum := m.GetSummary()
+		res := make([]sample, 0, len(sum.Quantile)+2)
+		for _, q := range sum.Quantile {
+			res = append(res, sample{
+				lset: s.labels(name, m, labels.Label{Name: "quantile", Value: formatFloat(q.GetQuantile())}),
+				v:    q.GetValue(),
+			})
+		}
+		return append(res,
+			sample{lset: s.labels(name+"_sum", m), v: sum.GetSampleSum()},
+			sample{lset: s.labels(name+"_count", m), v: float64(sum.GetSampleCount())},
+		)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		res := make([]sample, 0, len(h.Bucket)+3)
+		infSeen := false
+		for _, b := range h.Bucket {
+			if math.IsInf(b.GetUpperBound(), 1) {
+				infSeen = true
+			}
+			res = append(res, sample{
+				lset: s.labels(name+"_bucket", m, labels.Label{Name: "le", Value: formatFloat(b.GetUpperBound())}),
+				v:    float64(b.GetCumulativeCount()),
+			})
+		}
+		// The +Inf bucket is implicit in the client library.
+		if !infSeen {
+			res = append(res, sample{
+				lset: s.labels(name+"_bucket", m, labels.Label{Name: "le", Value: "+Inf"}),
+				v:    float64(h.GetSampleCount()),
+			})
+		}
+		return append(res,
+			sample{lset: s.labels(name+"_sum", m), v: h.GetSampleSum()},
+			sample{lset: s.labels(name+"_count", m), v: float64(h.GetSampleCount())},
+		)
+	}
+	return nil
+}
+
+// labels returns the labels of a series of the metric. Labels of the metric that collide with target labels are
+// kept with the exported_ prefix, like Prometheus does for targets that do not honor labels.
+func (s *Scraper) labels(name string, m *dto.Metric, extra ...labels.Label) labels.Labels {
+	lset := make(labels.Labels, 0, len(m.Label)+len(extra)+1)
+	lset = append(lset, labels.Label{Name: labels.MetricName, Value: name})
+
+	for _, l := range m.Label {
+		lset = append(lset, labels.Label{Name: l.GetName(), Value: l.GetValue()})
+	}
+	lset = append(lset, extra...)
+	sort.Sort(lset)
+
+	b := labels.NewBuilder(lset)
+	for _, l := range s.lset {
+		if v := lset.Get(l.Name); v != "" {
+			b.Set(model.ExportedLabelPrefix+l.Name, v)
+		}
+		b.Set(l.Name, l.Value)
+	}
+	return b.Labels()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,86 @@
+package selfscrape
+
+import (
+	"testing"
+	"time"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type testAppendable struct {
+	samples   map[string]float64
+	ts        []int64
+	failOn    string
+	committed bool
+}
+
+func (a *testAppendable) Appender() (storage.Appender, error) {
+	return &testAppender{a: a, samples: map[string]float64{}}, nil
+}
+
+type testAppender struct {
+	a       *testAppendable
+	samples map[string]float64
+	ts      []int64
+}
+
+func (a *testAppender) Add(l labels.Labels, t int64, v float64) (uint64, error) {
+	if l.Get(labels.MetricName) == a.a.failOn {
+		return 0, errors.New("fail")
+	}
+	a.samples[l.String()] = v
+	a.ts = append(a.ts, t)
+	return 0, nil
+}
+
+func (a *testAppender) AddFast(l labels.Labels, ref uint64, t int64, v float64) error {
+	_, err := a.Add(l, t, v)
+	return err
+}
+
+func (a *testAppender) Commit() error {
+	a.a.samples, a.a.ts, a.a.committed = a.samples, a.ts, true
+	return nil
+}
+
+func (a *testAppender) Rollback() error { return nil }
+
+func TestScraper_Scrape(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "Requests."}, []string{"code", "job"})
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Durations.", Buckets: []float64{0.5, 1}})
+	reg.MustRegister(c, h)
+
+	c.WithLabelValues("200", "other").Add(3)
+	h.Observe(0.2)
+	h.Observe(2)
+
+	app := &testAppendable{}
+	now := time.Now()
+	testutil.Ok(t, New(reg, app, labels.FromStrings("job", "thanos-rule")).Scrape(now))
+
+	testutil.Assert(t, app.committed, "samples not committed")
+	// Labels of the metrics colliding with target labels are kept with the exported_ prefix.
+	testutil.Equals(t, map[string]float64{
+		`{__name__="requests_total", code="200", exported_job="other", job="thanos-rule"}`: 3,
+		`{__name__="duration_seconds_bucket", job="thanos-rule", le="0.5"}`:                1,
+		`{__name__="duration_seconds_bucket", job="thanos-rule", le="1"}`:                  1,
+		`{__name__="duration_seconds_bucket", job="thanos-rule", le="+Inf"}`:               2,
+		`{__name__="duration_seconds_sum", job="thanos-rule"}`:                             2.2,
+		`{__name__="duration_seconds_count", job="thanos-rule"}`:                           2,
+	}, app.samples)
+	for _, ts := range app.ts {
+		testutil.Equals(t, timestamp.FromTime(now), ts)
+	}
+
+	// Nothing is committed if a sample cannot be appended.
+	app = &testAppendable{failOn: "duration_seconds_sum"}
+	testutil.NotOk(t, New(reg, app, labels.FromStrings("job", "thanos-rule")).Scrape(now))
+	testutil.Assert(t, !app.committed, "samples committed")
+}
@@ -27,6 +27,8 @@ type metrics struct {
 	dirSyncFailures prometheus.Counter
 	uploads         prometheus.Counter
 	uploadFailures  prometheus.Counter
+	skippedFiles    prometheus.Counter
+	corruptBlocks   prometheus.Counter
 }
 
 func newMetrics(r prometheus.Registerer) *metrics {
@@ -48,6 +50,15 @@ func newMetrics(r prometheus.Registerer) *metrics {
 		Name: "thanos_shipper_upload_failures_total",
 		Help: "Total number of failed object uploads",
 	})
+	m.skippedFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_upload_skipped_files_total",
+		Help: "Total number of block files not uploaded again when resuming an interrupted upload, as they were already present in the bucket",
+	})
+
+	m.corruptBlocks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_shipper_corrupted_blocks_total",
+		Help: "Total number of blocks that failed verification and were not uploaded",
+	})
 
 	if r != nil {
 		r.MustRegister(
@@ -55,6 +66,8 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			m.dirSyncFailures,
 			m.uploads,
 			m.uploadFailures,
+			m.skippedFiles,
+			m.corruptBlocks,
 		)
 	}
 	return &m
@@ -69,10 +82,17 @@ type Shipper struct {
 	bucket  objstore.Bucket
 	labels  func() labels.Labels
 	source  block.SourceType
+
+	verifyOnUpload bool
+	compressIndex  bool
+	// Blocks that failed verification. They are kept locally for inspection and not verified again.
+	corrupted map[ulid.ULID]struct{}
 }
 
 // New creates a new shipper that detects new TSDB blocks in dir and uploads them
 // to remote if necessary. It attaches the return value of the labels getter to uploaded data.
+// If verifyOnUpload is set, the index of each block is verified before upload and corrupted blocks are not uploaded.
+// If compressIndex is set, index files are compressed before upload.
 func New(
 	logger log.Logger,
 	r prometheus.Registerer,
@@ -80,6 +100,8 @@ func New(
 	bucket objstore.Bucket,
 	lbls func() labels.Labels,
 	source block.SourceType,
+	verifyOnUpload bool,
+	compressIndex bool,
 ) *Shipper {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -94,6 +116,10 @@ func New(
 		labels:  lbls,
 		metrics: newMetrics(r),
 		source:  source,
+
+		verifyOnUpload: verifyOnUpload,
+		compressIndex:  compressIndex,
+		corrupted:      map[ulid.ULID]struct{}{},
 	}
 }
 
@@ -133,6 +159,44 @@ func (s *Shipper) Timestamps() (minTime, maxSyncTime int64, err error) {
 	return minTime, maxSyncTime, nil
 }
 
+// DeleteUploaded deletes local blocks that were uploaded and only hold data before the given timestamp.
+// Blocks that were not uploaded yet are always kept. A block is moved out of sight of the TSDB before its
+// files are removed, so that the TSDB never opens a partially deleted block. The TSDB closes deleted blocks
+// on its next reload, as it does for blocks deleted by its own retention.
+// It returns the number of deleted blocks. It is not concurrency-safe with Sync.
+func (s *Shipper) DeleteUploaded(before int64) (int, error) {
+	meta, err := ReadMetaFile(s.dir)
+	if err != nil {
+		return 0, errors.Wrap(err, "read shipper meta file")
+	}
+	hasUploaded := make(map[ulid.ULID]struct{}, len(meta.Uploaded))
+	for _, id := range meta.Uploaded {
+		hasUploaded[id] = struct{}{}
+	}
+
+	var deleted int
+	if err := s.iterBlockMetas(func(m *block.Meta) error {
+		if _, ok := hasUploaded[m.ULID]; !ok || m.MaxTime > before {
+			return nil
+		}
+		dir := filepath.Join(s.dir, m.ULID.String())
+		tmp := dir + ".tmp-for-deletion"
+
+		if err := os.Rename(dir, tmp); err != nil {
+			return errors.Wrapf(err, "move block %s for deletion", m.ULID)
+		}
+		if err := os.RemoveAll(tmp); err != nil {
+			return errors.Wrapf(err, "delete block %s", m.ULID)
+		}
+		level.Info(s.logger).Log("msg", "deleted uploaded block", "id", m.ULID)
+		deleted++
+		return nil
+	}); err != nil {
+		return deleted, errors.Wrap(err, "iter block metas for deletion")
+	}
+	return deleted, nil
+}
+
 // Sync performs a single synchronization, which ensures all local blocks have been uploaded
 // to the object bucket once.
 // It is not concurrency-safe.
@@ -161,6 +225,9 @@ func (s *Shipper) Sync(ctx context.Context) {
 		// Do not sync a block if we already uploaded it. If it is no longer found in the bucket,
 		// it was generally removed by the compaction process.
 		if _, ok := hasUploaded[m.ULID]; !ok {
+			if _, ok := s.corrupted[m.ULID]; ok {
+				return nil
+			}
 			if err := s.sync(ctx, m); err != nil {
 				level.Error(s.logger).Log("msg", "shipping failed", "block", m.ULID, "err", err)
 				// No error returned, just log line. This is because we want other blocks to be uploaded even
@@ -188,7 +255,8 @@ func (s *Shipper) sync(ctx context.Context, meta *block.Meta) (err error) {
 		return nil
 	}
 
-	// Check against bucket if the meta file for this block exists.
+	// Check against bucket if the meta file for this block exists. As it is uploaded last,
+	// its presence means the block was uploaded completely.
 	ok, err := s.bucket.Exists(ctx, path.Join(meta.ULID.String(), block.MetaFilename))
 	if err != nil {
 		return errors.Wrap(err, "check exists")
@@ -197,6 +265,14 @@ func (s *Shipper) sync(ctx context.Context, meta *block.Meta) (err error) {
 		return nil
 	}
 
+	if s.verifyOnUpload {
+		if err := block.VerifyIndex(filepath.Join(dir, block.IndexFilename), meta.MinTime, meta.MaxTime); err != nil {
+			s.corrupted[meta.ULID] = struct{}{}
+			s.metrics.corruptBlocks.Inc()
+			return errors.Wrapf(err, "verify block, not uploading it and keeping it in %s for inspection", dir)
+		}
+	}
+
 	level.Info(s.logger).Log("msg", "upload new block", "id", meta.ULID)
 
 	// We hard-link the files into a temporary upload directory so we are not affected
@@ -222,7 +298,19 @@ func (s *Shipper) sync(ctx context.Context, meta *block.Meta) (err error) {
 	if err := block.WriteMetaFile(updir, meta); err != nil {
 		return errors.Wrap(err, "write meta file")
 	}
-	return block.Upload(ctx, s.bucket, updir)
+	// The compressed index replaces the hard link, the index in the TSDB directory is not modified.
+	if s.compressIndex {
+		if err := block.CompressIndex(updir); err != nil {
+			return errors.Wrap(err, "compress index")
+		}
+	}
+	// Files of a previously interrupted upload are kept in the bucket and not uploaded again.
+	skipped, err := block.UploadResumable(ctx, s.logger, s.bucket, updir)
+	if skipped > 0 {
+		level.Info(s.logger).Log("msg", "resumed block upload", "id", meta.ULID, "skipped", skipped)
+		s.metrics.skippedFiles.Add(float64(skipped))
+	}
+	return err
 }
 
 // iterBlockMetas calls f with the block meta for each block found in dir. It logs
@@ -30,7 +30,7 @@ func TestShipper_UploadBlocks_e2e(t *testing.T) {
 		defer os.RemoveAll(dir)
 
 		extLset := labels.FromStrings("prometheus", "prom-1")
-		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, block.TestSource)
+		shipper := New(log.NewLogfmtLogger(os.Stderr), nil, dir, bkt, func() labels.Labels { return extLset }, block.TestSource, false, false)
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
@@ -89,6 +89,7 @@ func TestShipper_UploadBlocks_e2e(t *testing.T) {
 
 			// The external labels must be attached to the meta file on upload.
 			meta.Thanos.Labels = extLset.Map()
+			meta.Thanos.Version = block.ThanosVersion1
 
 			var buf bytes.Buffer
 			enc := json.NewEncoder(&buf)
@@ -1,6 +1,7 @@
 package shipper
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -10,9 +11,11 @@ import (
 	"path"
 
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
 	"github.com/improbable-eng/thanos/pkg/testutil"
 	"github.com/oklog/ulid"
 	"github.com/prometheus/tsdb"
+	"github.com/prometheus/tsdb/labels"
 )
 
 func TestShipperTimestamps(t *testing.T) {
@@ -20,7 +23,7 @@ func TestShipperTimestamps(t *testing.T) {
 	testutil.Ok(t, err)
 	defer os.RemoveAll(dir)
 
-	s := New(nil, nil, dir, nil, nil, block.TestSource)
+	s := New(nil, nil, dir, nil, nil, block.TestSource, false, false)
 
 	// Missing thanos meta file.
 	_, _, err = s.Timestamps()
@@ -76,3 +79,151 @@ func TestShipperTimestamps(t *testing.T) {
 	testutil.Equals(t, int64(1000), mint)
 	testutil.Equals(t, int64(2000), maxt)
 }
+
+func TestShipper_DeleteUploaded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipper-test")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(nil, nil, dir, nil, nil, block.TestSource, false, false)
+
+	var ids []ulid.ULID
+	for i := int64(0); i < 3; i++ {
+		id := ulid.MustNew(uint64(i+1), nil)
+		testutil.Ok(t, os.Mkdir(path.Join(dir, id.String()), os.ModePerm))
+		testutil.Ok(t, block.WriteMetaFile(path.Join(dir, id.String()), &block.Meta{
+			Version: 1,
+			BlockMeta: tsdb.BlockMeta{
+				ULID:    id,
+				MinTime: i * 1000,
+				MaxTime: (i + 1) * 1000,
+			},
+		}))
+		ids = append(ids, id)
+	}
+	// The last block was not uploaded yet.
+	testutil.Ok(t, WriteMetaFile(dir, &Meta{Version: 1, Uploaded: ids[:2]}))
+
+	deleted, err := s.DeleteUploaded(1000)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, deleted)
+
+	_, err = os.Stat(path.Join(dir, ids[0].String()))
+	testutil.Assert(t, os.IsNotExist(err), "uploaded block was not deleted")
+
+	deleted, err = s.DeleteUploaded(math.MaxInt64)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, deleted)
+
+	mint, _, err := s.Timestamps()
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(2000), mint)
+}
+
+func TestShipper_ResumeUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipper-test")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	extLset := labels.FromStrings("prometheus", "prom-1")
+	s := New(nil, nil, dir, bkt, func() labels.Labels { return extLset }, block.TestSource, false, false)
+
+	id := ulid.MustNew(1, nil)
+	bdir := path.Join(dir, id.String())
+	testutil.Ok(t, os.MkdirAll(path.Join(bdir, block.ChunksDirname), os.ModePerm))
+	testutil.Ok(t, block.WriteMetaFile(bdir, &block.Meta{
+		Version: 1,
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MaxTime: 2000,
+			MinTime: 1000,
+		},
+	}))
+	testutil.Ok(t, ioutil.WriteFile(path.Join(bdir, block.IndexFilename), []byte("indexcontents"), 0666))
+	testutil.Ok(t, ioutil.WriteFile(path.Join(bdir, block.ChunksDirname, "0001"), []byte("chunkcontents1"), 0666))
+	testutil.Ok(t, ioutil.WriteFile(path.Join(bdir, block.ChunksDirname, "0002"), []byte("chunkcontents2"), 0666))
+
+	// Simulate an interrupted upload with one complete chunk file and a partial index.
+	bkt.Objects()[path.Join(id.String(), block.ChunksDirname, "0001")] = []byte("chunkcontents1")
+	bkt.Objects()[path.Join(id.String(), block.IndexFilename)] = []byte("index")
+
+	s.Sync(ctx)
+
+	meta, err := ReadMetaFile(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{id}, meta.Uploaded)
+
+	for fn, exp := range map[string]string{
+		path.Join(id.String(), block.IndexFilename):         "indexcontents",
+		path.Join(id.String(), block.ChunksDirname, "0001"): "chunkcontents1",
+		path.Join(id.String(), block.ChunksDirname, "0002"): "chunkcontents2",
+	} {
+		testutil.Equals(t, exp, string(bkt.Objects()[fn]))
+	}
+	ok, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "meta file was not uploaded")
+
+	// Without a meta file the block counts as not uploaded, but all other files are skipped.
+	testutil.Ok(t, bkt.Delete(ctx, path.Join(id.String(), block.MetaFilename)))
+	updir := path.Join(dir, "upload", id.String())
+	testutil.Ok(t, os.MkdirAll(updir, os.ModePerm))
+	testutil.Ok(t, hardlinkBlock(bdir, updir))
+
+	m, err := block.ReadMetaFile(updir)
+	testutil.Ok(t, err)
+	m.Thanos.Labels = extLset.Map()
+	testutil.Ok(t, block.WriteMetaFile(updir, m))
+
+	skipped, err := block.UploadResumable(ctx, nil, bkt, updir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 3, skipped)
+
+	ok, err = bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "meta file was not uploaded")
+}
+
+func TestShipper_VerifyOnUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shipper-test")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	bkt := inmem.NewBucket()
+	extLset := labels.FromStrings("prometheus", "prom-1")
+	s := New(nil, nil, dir, bkt, func() labels.Labels { return extLset }, block.TestSource, true, false)
+
+	series := []labels.Labels{labels.FromStrings("a", "1"), labels.FromStrings("a", "2")}
+	good, err := testutil.CreateBlock(dir, series, 100, 0, 1000, extLset, 0)
+	testutil.Ok(t, err)
+	corrupted, err := testutil.CreateBlock(dir, series, 100, 1000, 2000, extLset, 0)
+	testutil.Ok(t, err)
+
+	// Shrink the time range of the block so that its chunks are outside of it.
+	m, err := block.ReadMetaFile(path.Join(dir, corrupted.String()))
+	testutil.Ok(t, err)
+	m.MaxTime = 1500
+	testutil.Ok(t, block.WriteMetaFile(path.Join(dir, corrupted.String()), m))
+
+	for i := 0; i < 2; i++ {
+		s.Sync(ctx)
+
+		meta, err := ReadMetaFile(dir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, []ulid.ULID{good}, meta.Uploaded)
+
+		ok, err := bkt.Exists(ctx, path.Join(good.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "block %s was not uploaded", good)
+
+		ok, err = bkt.Exists(ctx, path.Join(corrupted.String(), block.IndexFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok, "corrupted block %s was uploaded", corrupted)
+
+		_, err = os.Stat(path.Join(dir, corrupted.String()))
+		testutil.Ok(t, err)
+	}
+}
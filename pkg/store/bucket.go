@@ -6,7 +6,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"os"
 	"path"
@@ -23,6 +22,7 @@ import (
 	"github.com/improbable-eng/thanos/pkg/compact/downsample"
 	"github.com/improbable-eng/thanos/pkg/objstore"
 	"github.com/improbable-eng/thanos/pkg/pool"
+	"github.com/improbable-eng/thanos/pkg/runutil"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/strutil"
 	"github.com/improbable-eng/thanos/pkg/tracing"
@@ -43,6 +43,7 @@ import (
 
 type bucketStoreMetrics struct {
 	blocksLoaded          prometheus.Gauge
+	blocksHidden          prometheus.Gauge
 	blockLoads            prometheus.Counter
 	blockLoadFailures     prometheus.Counter
 	blockDrops            prometheus.Counter
@@ -56,6 +57,18 @@ type bucketStoreMetrics struct {
 	seriesMergeDuration   prometheus.Histogram
 	resultSeriesCount     prometheus.Summary
 	chunkSizeBytes        prometheus.Histogram
+	labelRequestsLimited  *prometheus.CounterVec
+	chunkRangeReads       *prometheus.CounterVec
+	seriesChunks          *prometheus.CounterVec
+	seriesShardSkipped    prometheus.Counter
+	seriesBlocksSkipped   prometheus.Counter
+	indexFetchedBytes     *prometheus.CounterVec
+	indexMemoryBytes      *prometheus.HistogramVec
+	lazyIndexLoads        prometheus.Counter
+	lazyIndexLoadFailures prometheus.Counter
+	lazyIndexUnloads      prometheus.Counter
+	chunkDecodeWait       prometheus.Histogram
+	syncQueryStalls       prometheus.Counter
 }
 
 func newBucketStoreMetrics(reg prometheus.Registerer, s *BucketStore) *bucketStoreMetrics {
@@ -81,6 +94,10 @@ func newBucketStoreMetrics(reg prometheus.Registerer, s *BucketStore) *bucketSto
 		Name: "thanos_bucket_store_blocks_loaded",
 		Help: "Number of currently loaded blocks.",
 	})
+	m.blocksHidden = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "thanos_bucket_store_blocks_hidden",
+		Help: "Number of loaded blocks that are not queried, as their data is fully contained in another block.",
+	})
 
 	m.seriesDataTouched = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Name: "thanos_bucket_store_series_data_touched",
@@ -131,6 +148,63 @@ func newBucketStoreMetrics(reg prometheus.Registerer, s *BucketStore) *bucketSto
 		},
 	})
 
+	m.labelRequestsLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_label_requests_limited_total",
+		Help: "Total number of LabelNames and LabelValues requests rejected because they exceeded the configured limit or timeout.",
+	}, []string{"reason"})
+
+	m.chunkRangeReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_chunk_range_reads_total",
+		Help: "Total number of range reads of chunk files. Type 'coalesced' are reads of multiple nearby chunks at once, 'individual' reads of a single chunk.",
+	}, []string{"type"})
+
+	m.seriesChunks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_series_chunks_total",
+		Help: "Total number of chunks of matched series. Type 'pruned' are chunks skipped as they do not overlap with the requested time range, 'selected' chunks that were fetched.",
+	}, []string{"type"})
+	m.seriesBlocksSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_series_blocks_skipped_total",
+		Help: "Total number of blocks skipped by series requests because the label summary of the block ruled out any matching series.",
+	})
+	m.seriesShardSkipped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_series_shard_skipped_total",
+		Help: "Total number of matched series skipped because they belong to another shard of a sharded query.",
+	})
+
+	m.indexFetchedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_block_index_fetched_bytes_total",
+		Help: "Total number of bytes of block indexes fetched from the bucket to load blocks, partitioned by index load strategy.",
+	}, []string{"strategy"})
+	m.indexMemoryBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "thanos_bucket_store_block_index_memory_bytes",
+		Help: "Estimated memory held per loaded block by the index lookup structures, partitioned by index load strategy.",
+		Buckets: []float64{
+			1024, 16 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024, 16 * 1024 * 1024, 64 * 1024 * 1024, 256 * 1024 * 1024, 1024 * 1024 * 1024,
+		},
+	}, []string{"strategy"})
+
+	m.lazyIndexLoads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_lazy_index_loads_total",
+		Help: "Total number of times the index lookup structures of a block were loaded on first use.",
+	})
+	m.lazyIndexLoadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_lazy_index_load_failures_total",
+		Help: "Total number of failed attempts to load the index lookup structures of a block on first use.",
+	})
+	m.lazyIndexUnloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_lazy_index_unloads_total",
+		Help: "Total number of times the index lookup structures of a block were unloaded after being idle.",
+	})
+	m.chunkDecodeWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "thanos_bucket_store_chunk_decode_wait_seconds",
+		Help:    "Time chunk ranges of series requests waited to be read from the bucket because the per-query chunk read concurrency was exhausted.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10},
+	})
+	m.syncQueryStalls = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_sync_query_stall_seconds_total",
+		Help: "Total time queries waited for the set of blocks while a sync swapped it.",
+	})
+
 	if reg != nil {
 		reg.MustRegister(
 			m.blockLoads,
@@ -138,6 +212,7 @@ func newBucketStoreMetrics(reg prometheus.Registerer, s *BucketStore) *bucketSto
 			m.blockDrops,
 			m.blockDropFailures,
 			m.blocksLoaded,
+			m.blocksHidden,
 			m.seriesDataTouched,
 			m.seriesDataFetched,
 			m.seriesDataSizeTouched,
@@ -147,11 +222,55 @@ func newBucketStoreMetrics(reg prometheus.Registerer, s *BucketStore) *bucketSto
 			m.seriesMergeDuration,
 			m.resultSeriesCount,
 			m.chunkSizeBytes,
+			m.labelRequestsLimited,
+			m.chunkRangeReads,
+			m.seriesChunks,
+			m.seriesShardSkipped,
+			m.seriesBlocksSkipped,
+			m.indexFetchedBytes,
+			m.indexMemoryBytes,
+			m.lazyIndexLoads,
+			m.lazyIndexLoadFailures,
+			m.lazyIndexUnloads,
+			m.chunkDecodeWait,
+			m.syncQueryStalls,
+			&bufferPoolCollector{pools: s.bufferPools},
 		)
 	}
 	return &m
 }
 
+var (
+	bufferPoolHitsDesc = prometheus.NewDesc(
+		"thanos_bucket_store_buffer_pool_hits_total",
+		"Total number of buffers that were reused from a pool.",
+		[]string{"pool"}, nil,
+	)
+	bufferPoolAllocsDesc = prometheus.NewDesc(
+		"thanos_bucket_store_buffer_pool_allocations_total",
+		"Total number of buffers that had to be allocated as a pool had none to reuse.",
+		[]string{"pool"}, nil,
+	)
+)
+
+// bufferPoolCollector exposes how well the buffer pools of a bucket store are reused.
+type bufferPoolCollector struct {
+	pools func() map[string]*pool.BytesPool
+}
+
+func (c *bufferPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bufferPoolHitsDesc
+	ch <- bufferPoolAllocsDesc
+}
+
+func (c *bufferPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, p := range c.pools() {
+		hits, allocs := p.Stats()
+		ch <- prometheus.MustNewConstMetric(bufferPoolHitsDesc, prometheus.CounterValue, float64(hits), name)
+		ch <- prometheus.MustNewConstMetric(bufferPoolAllocsDesc, prometheus.CounterValue, float64(allocs), name)
+	}
+}
+
 // BucketStore implements the store API backed by a bucket. It loads all index
 // files to local disk.
 type BucketStore struct {
@@ -161,14 +280,92 @@ type BucketStore struct {
 	dir        string
 	indexCache *indexCache
 	chunkPool  *pool.BytesPool
+	// Pool for the buffers of index ranges read by queries. Nil if buffer pooling is disabled.
+	indexPool *pool.BytesPool
+	// Reader for the index and chunk ranges read by queries, which bounds each read by the operation timeout.
+	rangeBucket objstore.BucketReader
 
 	// Sets of blocks that have the same labels. They are indexed by a hash over their label set.
+	// Both maps are never modified, syncs build new ones and swap them in.
 	mtx       sync.RWMutex
 	blocks    map[ulid.ULID]*bucketBlock
 	blockSets map[uint64]*bucketBlockSet
+	// Blocks whose data is fully contained in another block. They stay loaded, so they are not loaded again
+	// on every sync, but are not part of the block sets and not queried.
+	hidden map[ulid.ULID]struct{}
+	// When the last swap of the set of blocks acquired and released the lock, guarded by mtx.
+	swapBegin, swapEnd time.Time
+
+	// Serializes syncs, which build the new set of blocks from the current one.
+	syncMtx sync.Mutex
 
 	// Verbose enabled additional logging.
 	debugLogging bool
+
+	// Maximum number of entries a single LabelNames or LabelValues response may hold
+	// and maximum time such a request may take. Zero disables the respective limit.
+	labelRequestLimit   int
+	labelRequestTimeout time.Duration
+
+	// Maximum gap in bytes between chunks that are fetched with a single range read.
+	chunkPrefetchGap uint64
+
+	// How the lookup structures of block indexes are loaded, either IndexCacheStrategy or IndexHeaderStrategy.
+	indexLoadStrategy string
+
+	// If negative, no data newer than this offset from now is advertised or served. Zero disables it.
+	advertiseMaxTime time.Duration
+	now              func() time.Time
+
+	// If true, the index lookup structures of a block are only loaded on the first query touching it
+	// and unloaded again once they were not used for the idle timeout. Zero never unloads them.
+	lazyIndex            bool
+	lazyIndexIdleTimeout time.Duration
+
+	// If true, blocks keep a summary of their label values to skip them in series requests that cannot match.
+	blockSummaries bool
+
+	// Maximum number of chunk ranges a single series request reads and decodes concurrently across all its blocks.
+	// Zero does not limit them.
+	chunkDecodeConcurrency int
+}
+
+// Strategies to load the lookup structures of block indexes, i.e. the symbols, label values and postings offsets.
+const (
+	// IndexCacheStrategy downloads the full index to build a JSON index cache file.
+	IndexCacheStrategy = "index-cache"
+	// IndexHeaderStrategy fetches only the required sections of the index into a binary index header file.
+	// It needs neither the disk space nor the memory to process the full index while loading. The file is
+	// mmapped and lookups are served from it, only the positions of its entries are held in memory.
+	IndexHeaderStrategy = "index-header"
+)
+
+// BucketStoreOptions configures the optional behaviour of a BucketStore. The zero value disables all of it.
+type BucketStoreOptions struct {
+	// ChunkPrefetchGap is the maximum gap in bytes between chunks of a chunk file that are fetched with a single range read.
+	ChunkPrefetchGap uint64
+	// A positive LabelRequestLimit caps the number of entries of a single LabelNames or LabelValues response.
+	LabelRequestLimit int
+	// A positive LabelRequestTimeout bounds the processing time of a single LabelNames or LabelValues request.
+	LabelRequestTimeout time.Duration
+	// IndexLoadStrategy is one of IndexCacheStrategy and IndexHeaderStrategy, it defaults to IndexCacheStrategy.
+	IndexLoadStrategy string
+	// A negative AdvertiseMaxTime caps the advertised and served time range at that offset from now,
+	// independent of the loaded blocks, so that newer data is read only from other stores like sidecars.
+	AdvertiseMaxTime time.Duration
+	// With LazyIndex the index lookup structures of blocks are loaded on first use instead of when syncing blocks.
+	LazyIndex bool
+	// A positive LazyIndexIdleTimeout unloads lazily loaded index lookup structures that were not used for that long.
+	LazyIndexIdleTimeout time.Duration
+	// With BufferPooling the buffers of index ranges read by queries are reused across queries, like the chunk buffers.
+	BufferPooling bool
+	// With BlockSummaries series requests skip blocks whose label values cannot match, see blockSummary.
+	BlockSummaries bool
+	// A positive ChunkDecodeConcurrency caps the chunk ranges a single series request reads concurrently, see chunkGate.
+	ChunkDecodeConcurrency int
+	// A positive ObjstoreOpTimeout bounds each index and chunk range read of queries against the bucket,
+	// see objstore.TimeoutBucketReader.
+	ObjstoreOpTimeout time.Duration
 }
 
 // NewBucketStore creates a new bucket backed store that implements the store API against
@@ -181,10 +378,21 @@ func NewBucketStore(
 	indexCacheSizeBytes uint64,
 	maxChunkPoolBytes uint64,
 	debugLogging bool,
+	opts BucketStoreOptions,
 ) (*BucketStore, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
+	if opts.AdvertiseMaxTime > 0 {
+		return nil, errors.Errorf("advertised max time %s must not be in the future", opts.AdvertiseMaxTime)
+	}
+	switch opts.IndexLoadStrategy {
+	case "":
+		opts.IndexLoadStrategy = IndexCacheStrategy
+	case IndexCacheStrategy, IndexHeaderStrategy:
+	default:
+		return nil, errors.Errorf("unknown index load strategy %q", opts.IndexLoadStrategy)
+	}
 	indexCache, err := newIndexCache(reg, indexCacheSizeBytes)
 	if err != nil {
 		return nil, errors.Wrap(err, "create index cache")
@@ -193,15 +401,41 @@ func NewBucketStore(
 	if err != nil {
 		return nil, errors.Wrap(err, "create chunk pool")
 	}
+	var indexPool *pool.BytesPool
+	if opts.BufferPooling {
+		indexPool, err = pool.NewBytesPool(1e3, 50e6, 2, 0)
+		if err != nil {
+			return nil, errors.Wrap(err, "create index pool")
+		}
+	}
+	rangeBucket := bucket
+	if opts.ObjstoreOpTimeout > 0 {
+		rangeBucket = objstore.NewTimeoutBucketReader(reg, bucket, opts.ObjstoreOpTimeout)
+	}
 	s := &BucketStore{
 		logger:       logger,
 		bucket:       bucket,
+		rangeBucket:  rangeBucket,
 		dir:          dir,
 		indexCache:   indexCache,
 		chunkPool:    chunkPool,
+		indexPool:    indexPool,
 		blocks:       map[ulid.ULID]*bucketBlock{},
 		blockSets:    map[uint64]*bucketBlockSet{},
 		debugLogging: debugLogging,
+
+		labelRequestLimit:   opts.LabelRequestLimit,
+		labelRequestTimeout: opts.LabelRequestTimeout,
+		chunkPrefetchGap:    opts.ChunkPrefetchGap,
+		indexLoadStrategy:   opts.IndexLoadStrategy,
+		advertiseMaxTime:    opts.AdvertiseMaxTime,
+		now:                 time.Now,
+
+		lazyIndex:            opts.LazyIndex,
+		lazyIndexIdleTimeout: opts.LazyIndexIdleTimeout,
+
+		blockSummaries:         opts.BlockSummaries,
+		chunkDecodeConcurrency: opts.ChunkDecodeConcurrency,
 	}
 	s.metrics = newBucketStoreMetrics(reg, s)
 
@@ -228,18 +462,30 @@ func (s *BucketStore) Close() (err error) {
 
 // SyncBlocks synchronizes the stores state with the Bucket bucket.
 // It will reuse disk space as persistent cache based on s.dir param.
+// New blocks are loaded off to the side and swapped in together with the removal of blocks that are no longer
+// present, so queries keep running against the previous, fully loaded set of blocks while syncing.
 func (s *BucketStore) SyncBlocks(ctx context.Context) error {
-	var wg sync.WaitGroup
-	blockc := make(chan ulid.ULID)
+	s.syncMtx.Lock()
+	defer s.syncMtx.Unlock()
 
+	var (
+		wg     sync.WaitGroup
+		blockc = make(chan ulid.ULID)
+		mtx    sync.Mutex
+		loaded []*bucketBlock
+	)
 	for i := 0; i < 20; i++ {
 		wg.Add(1)
 		go func() {
 			for id := range blockc {
-				if err := s.addBlock(ctx, id); err != nil {
+				b, err := s.loadBlock(ctx, id)
+				if err != nil {
 					level.Warn(s.logger).Log("msg", "loading block failed", "id", id, "err", err)
 					continue
 				}
+				mtx.Lock()
+				loaded = append(loaded, b)
+				mtx.Unlock()
 			}
 			wg.Done()
 		}()
@@ -253,6 +499,14 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		if err != nil {
 			return nil
 		}
+		// Blocks marked for deletion are no longer served and dropped if they were loaded.
+		marked, err := s.bucket.Exists(ctx, path.Join(id.String(), block.DeletionMarkFilename))
+		if err != nil {
+			return errors.Wrapf(err, "check deletion mark of block %s", id)
+		}
+		if marked {
+			return nil
+		}
 		allIDs[id] = struct{}{}
 
 		if b := s.getBlock(id); b != nil {
@@ -269,21 +523,157 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 	wg.Wait()
 
 	if err != nil {
+		// The listing is incomplete, so only add the loaded blocks and don't drop any.
+		s.swapBlocks(loaded, nil)
 		return errors.Wrap(err, "iter")
 	}
-	// Drop all blocks that are no longer present in the bucket.
-	for id := range s.blocks {
-		if _, ok := allIDs[id]; ok {
+	s.swapBlocks(loaded, allIDs)
+	return nil
+}
+
+// swapBlocks builds a new set of blocks from the current one with the added blocks and without the blocks not in keep,
+// and swaps it in. If keep is nil, no block is removed. Removed blocks are closed and deleted from disk after the swap,
+// which waits for the queries still reading them.
+func (s *BucketStore) swapBlocks(added []*bucketBlock, keep map[ulid.ULID]struct{}) {
+	var (
+		blocks    = make(map[ulid.ULID]*bucketBlock, len(s.blocks)+len(added))
+		blockSets = map[uint64]*bucketBlockSet{}
+		removed   []*bucketBlock
+		metas     = make([]*block.Meta, 0, len(s.blocks)+len(added))
+	)
+	for id, b := range s.blocks {
+		if _, ok := keep[id]; keep == nil || ok {
+			metas = append(metas, b.meta)
+		}
+	}
+	for _, b := range added {
+		metas = append(metas, b.meta)
+	}
+	hidden := coveredBlocks(metas)
+
+	add := func(b *bucketBlock) error {
+		if _, ok := hidden[b.meta.ULID]; ok {
+			blocks[b.meta.ULID] = b
+			return nil
+		}
+		lset := labels.FromMap(b.meta.Thanos.Labels)
+		h := lset.Hash()
+
+		set, ok := blockSets[h]
+		if !ok {
+			set = newBucketBlockSet(lset)
+			blockSets[h] = set
+		}
+		if err := set.add(b); err != nil {
+			return errors.Wrap(err, "add block to set")
+		}
+		blocks[b.meta.ULID] = b
+		return nil
+	}
+	// Only syncs modify the set of blocks, so the current one can be read without the lock.
+	for id, b := range s.blocks {
+		if _, ok := keep[id]; keep != nil && !ok {
+			removed = append(removed, b)
 			continue
 		}
-		if err := s.removeBlock(id); err != nil {
-			level.Warn(s.logger).Log("msg", "drop outdated block", "block", id, "err", err)
+		if err := add(b); err != nil {
+			level.Warn(s.logger).Log("msg", "keeping block failed", "id", id, "err", err)
+			removed = append(removed, b)
+		}
+	}
+	for _, b := range added {
+		if err := add(b); err != nil {
+			level.Warn(s.logger).Log("msg", "loading block failed", "id", b.meta.ULID, "err", err)
+			s.metrics.blockLoadFailures.Inc()
+			if err := b.Close(); err != nil {
+				level.Warn(s.logger).Log("msg", "closing block failed", "id", b.meta.ULID, "err", err)
+			}
+			if err := os.RemoveAll(b.dir); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to remove block we cannot load", "err", err)
+			}
+		}
+	}
+
+	s.mtx.Lock()
+	s.swapBegin = time.Now()
+	s.blocks = blocks
+	s.blockSets = blockSets
+	s.hidden = hidden
+	s.swapEnd = time.Now()
+	s.mtx.Unlock()
+
+	s.metrics.blocksLoaded.Set(float64(len(blocks)))
+	s.metrics.blocksHidden.Set(float64(len(hidden)))
+
+	// Drop all blocks that are no longer present in the bucket.
+	for _, b := range removed {
+		if err := s.dropBlock(b); err != nil {
+			level.Warn(s.logger).Log("msg", "drop outdated block", "block", b.meta.ULID, "err", err)
 			s.metrics.blockDropFailures.Inc()
 		}
 		s.metrics.blockDrops.Inc()
 	}
+}
 
-	return nil
+// coveredBlocks returns the blocks whose data is fully contained in another block with the same external labels and
+// resolution, i.e. whose compaction sources are a subset of the sources of the other block. Such blocks overlap with
+// the other block and would return its samples twice, e.g. the source blocks of a compaction until the compactor
+// deletes them, which it delays for --downsample.source-grace-period. Of blocks with the same sources, the one with
+// the highest ULID is kept.
+func coveredBlocks(metas []*block.Meta) map[ulid.ULID]struct{} {
+	type groupKey struct {
+		labels     uint64
+		resolution int64
+	}
+	// Blocks by each of their sources, per group.
+	bySource := map[groupKey]map[ulid.ULID][]*block.Meta{}
+
+	for _, m := range metas {
+		k := groupKey{labels: labels.FromMap(m.Thanos.Labels).Hash(), resolution: m.Thanos.Downsample.Resolution}
+		if bySource[k] == nil {
+			bySource[k] = map[ulid.ULID][]*block.Meta{}
+		}
+		for _, src := range m.Compaction.Sources {
+			bySource[k][src] = append(bySource[k][src], m)
+		}
+	}
+
+	covered := map[ulid.ULID]struct{}{}
+	for _, m := range metas {
+		if len(m.Compaction.Sources) == 0 {
+			continue
+		}
+		k := groupKey{labels: labels.FromMap(m.Thanos.Labels).Hash(), resolution: m.Thanos.Downsample.Resolution}
+
+		// A covering block contains all sources of the block, so it is among the blocks of any of its sources.
+		for _, o := range bySource[k][m.Compaction.Sources[0]] {
+			if o == m || len(o.Compaction.Sources) < len(m.Compaction.Sources) {
+				continue
+			}
+			if len(o.Compaction.Sources) == len(m.Compaction.Sources) && o.ULID.Compare(m.ULID) < 0 {
+				continue
+			}
+			if containsSources(o, m) {
+				covered[m.ULID] = struct{}{}
+				break
+			}
+		}
+	}
+	return covered
+}
+
+// containsSources returns true if all compaction sources of b are sources of a.
+func containsSources(a, b *block.Meta) bool {
+	srcs := make(map[ulid.ULID]struct{}, len(a.Compaction.Sources))
+	for _, id := range a.Compaction.Sources {
+		srcs[id] = struct{}{}
+	}
+	for _, id := range b.Compaction.Sources {
+		if _, ok := srcs[id]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 // InitialSync perform blocking sync with extra step at the end to delete locally saved blocks that are no longer
@@ -315,6 +705,15 @@ func (s *BucketStore) InitialSync(ctx context.Context) error {
 	return nil
 }
 
+// bufferPools returns the buffer pools of the store by name.
+func (s *BucketStore) bufferPools() map[string]*pool.BytesPool {
+	pools := map[string]*pool.BytesPool{"chunks": s.chunkPool}
+	if s.indexPool != nil {
+		pools["index"] = s.indexPool
+	}
+	return pools
+}
+
 func (s *BucketStore) numBlocks() int {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
@@ -327,7 +726,8 @@ func (s *BucketStore) getBlock(id ulid.ULID) *bucketBlock {
 	return s.blocks[id]
 }
 
-func (s *BucketStore) addBlock(ctx context.Context, id ulid.ULID) (err error) {
+// loadBlock loads the block with the given ID without adding it to the set of blocks.
+func (s *BucketStore) loadBlock(ctx context.Context, id ulid.ULID) (b *bucketBlock, err error) {
 	dir := filepath.Join(s.dir, id.String())
 
 	defer func() {
@@ -340,59 +740,105 @@ func (s *BucketStore) addBlock(ctx context.Context, id ulid.ULID) (err error) {
 	}()
 	s.metrics.blockLoads.Inc()
 
-	b, err := newBucketBlock(
+	b, err = newBucketBlock(
 		ctx,
 		log.With(s.logger, "block", id),
 		s.bucket,
+		s.rangeBucket,
 		id,
 		dir,
 		s.indexCache,
 		s.chunkPool,
+		s.indexPool,
+		s.chunkPrefetchGap,
+		s.indexLoadStrategy,
+		s.lazyIndex,
+		s.blockSummaries,
 	)
 	if err != nil {
-		return errors.Wrap(err, "new bucket block")
+		return nil, errors.Wrap(err, "new bucket block")
 	}
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
-
-	lset := labels.FromMap(b.meta.Thanos.Labels)
-	h := lset.Hash()
+	if !s.lazyIndex {
+		s.observeIndexLoad(b)
+	}
+	return b, nil
+}
 
-	set, ok := s.blockSets[h]
-	if !ok {
-		set = newBucketBlockSet(lset)
-		s.blockSets[h] = set
+// dropBlock closes a block that is no longer part of the set of blocks and removes it from disk.
+func (s *BucketStore) dropBlock(b *bucketBlock) error {
+	if err := b.Close(); err != nil {
+		return errors.Wrap(err, "close block")
 	}
+	return os.RemoveAll(b.dir)
+}
+
+// rlockBlocks read locks the set of blocks for a query and records how long the query waited for a sync swapping it.
+// Waits for other writers are not recorded.
+func (s *BucketStore) rlockBlocks() {
+	begin := time.Now()
+	s.mtx.RLock()
 
-	if err = set.add(b); err != nil {
-		return errors.Wrap(err, "add block to set")
+	// A swap that released the lock after the query started waiting held it while the query waited.
+	if s.swapEnd.After(begin) {
+		if s.swapBegin.After(begin) {
+			begin = s.swapBegin
+		}
+		s.metrics.syncQueryStalls.Add(s.swapEnd.Sub(begin).Seconds())
 	}
-	s.blocks[b.meta.ULID] = b
+}
 
-	s.metrics.blocksLoaded.Inc()
+// runlockBlocks releases the lock of rlockBlocks.
+func (s *BucketStore) runlockBlocks() {
+	s.mtx.RUnlock()
+}
 
-	return nil
+func (s *BucketStore) observeIndexLoad(b *bucketBlock) {
+	s.metrics.indexFetchedBytes.WithLabelValues(b.indexLoadStrategy).Add(float64(b.indexFetchedBytes))
+	s.metrics.indexMemoryBytes.WithLabelValues(b.indexLoadStrategy).Observe(float64(b.index.MemoryBytes()))
 }
 
-func (s *BucketStore) removeBlock(id ulid.ULID) error {
-	s.mtx.Lock()
-	b, ok := s.blocks[id]
-	if ok {
-		lset := labels.FromMap(b.meta.Thanos.Labels)
-		s.blockSets[lset.Hash()].remove(id)
-		delete(s.blocks, id)
+// blockIndexReader returns an index reader for the block. It must be called while holding the read lock of the
+// blocks, so that a block swapped out by a sync is not closed before the reader is registered with it. Lazily
+// loaded index lookup structures are kept until the reader is closed, but only loaded by loadIndex, which must be
+// called before the reader is used.
+func (s *BucketStore) blockIndexReader(ctx context.Context, b *bucketBlock) *bucketIndexReader {
+	b.useIndex()
+	return b.indexReader(ctx)
+}
+
+// loadIndex loads the lazily loaded index lookup structures of the block of the reader unless they are loaded.
+func (s *BucketStore) loadIndex(indexr *bucketIndexReader) error {
+	b := indexr.block
+
+	loaded, err := b.loadUsedIndex(indexr.ctx)
+	if err != nil {
+		s.metrics.lazyIndexLoadFailures.Inc()
+		return errors.Wrapf(err, "load index of block %s", b.meta.ULID)
 	}
-	s.mtx.Unlock()
+	if loaded {
+		s.metrics.lazyIndexLoads.Inc()
+		s.observeIndexLoad(b)
+	}
+	return nil
+}
 
-	if !ok {
-		return nil
+// UnloadIdleIndexes unloads the lazily loaded index lookup structures of all blocks that were not used
+// for the configured idle timeout. It returns the number of blocks of which they were unloaded.
+func (s *BucketStore) UnloadIdleIndexes() (n int) {
+	if !s.lazyIndex || s.lazyIndexIdleTimeout <= 0 {
+		return 0
 	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
 
-	s.metrics.blocksLoaded.Dec()
-	if err := b.Close(); err != nil {
-		return errors.Wrap(err, "close block")
+	now := s.now()
+	for _, b := range s.blocks {
+		if b.unloadIndexIfIdle(now, s.lazyIndexIdleTimeout) {
+			n++
+		}
 	}
-	return os.RemoveAll(b.dir)
+	s.metrics.lazyIndexUnloads.Add(float64(n))
+	return n
 }
 
 // TimeRange returns the minimum and maximum timestamp of data available in the store.
@@ -411,16 +857,29 @@ func (s *BucketStore) TimeRange() (mint, maxt int64) {
 			maxt = b.meta.MaxTime
 		}
 	}
+	if capt, ok := s.maxTime(); ok && maxt > capt {
+		maxt = capt
+	}
 	return mint, maxt
 }
 
+// maxTime returns the time up to which data is advertised and served, if it is capped.
+func (s *BucketStore) maxTime() (int64, bool) {
+	if s.advertiseMaxTime == 0 {
+		return 0, false
+	}
+	return s.now().Add(s.advertiseMaxTime).UnixNano() / int64(time.Millisecond), true
+}
+
 // Info implements the storepb.StoreServer interface.
 func (s *BucketStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	mint, maxt := s.TimeRange()
 	// Store nodes hold global data and thus have no labels.
 	return &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
+		MinTime:          mint,
+		MaxTime:          maxt,
+		CurrentTime:      s.now().UnixNano() / int64(time.Millisecond),
+		SupportsSharding: true,
 	}, nil
 }
 
@@ -495,7 +954,7 @@ func (s *BucketStore) blockSeries(
 	// we get have to account for that to get the correct offset.
 	// We do it right at the beginning as it's easier than doing it more fine-grained
 	// at the loading level.
-	if indexr.block.indexVersion >= 2 {
+	if indexr.block.index.Version() >= 2 {
 		for i, id := range ps {
 			ps[i] = id * 16
 		}
@@ -509,9 +968,10 @@ func (s *BucketStore) blockSeries(
 	// Transform all series into the response types and mark their relevant chunks
 	// for preloading.
 	var (
-		res  []seriesEntry
-		lset labels.Labels
-		chks []chunks.Meta
+		res   []seriesEntry
+		lset  labels.Labels
+		chks  []chunks.Meta
+		shard = req.ShardMatcher()
 	)
 	for _, id := range ps {
 		if err := indexr.Series(id, &lset, &chks); err != nil {
@@ -542,15 +1002,17 @@ func (s *BucketStore) blockSeries(
 		sort.Slice(s.lset, func(i, j int) bool {
 			return s.lset[i].Name < s.lset[j].Name
 		})
+		// Series of other shards are skipped before any of their chunks are loaded.
+		if !shard.Matches(s.lset) {
+			stats.seriesShardSkipped++
+			continue
+		}
 
-		for _, meta := range chks {
-			if meta.MaxTime < req.MinTime {
-				continue
-			}
-			if meta.MinTime > req.MaxTime {
-				break
-			}
+		selected := chunksInRange(chks, req.MinTime, req.MaxTime)
+		stats.chunksSelected += len(selected)
+		stats.chunksPruned += len(chks) - len(selected)
 
+		for _, meta := range selected {
 			if err := chunkr.addPreload(meta.Ref); err != nil {
 				return nil, stats, errors.Wrap(err, "add chunk preload")
 			}
@@ -589,6 +1051,14 @@ func (s *BucketStore) blockSeries(
 	return newBucketSeriesSet(res), stats, nil
 }
 
+// chunksInRange returns the chunks that overlap with the time range [mint, maxt]. The chunks of a series
+// are ordered by time and do not overlap, so the result is a sub-slice found by binary search.
+func chunksInRange(chks []chunks.Meta, mint, maxt int64) []chunks.Meta {
+	i := sort.Search(len(chks), func(i int) bool { return chks[i].MaxTime >= mint })
+	j := i + sort.Search(len(chks)-i, func(j int) bool { return chks[i+j].MinTime > maxt })
+	return chks[i:j]
+}
+
 func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Aggr) error {
 	if in.Encoding() == chunkenc.EncXOR {
 		out.Raw = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: in.Bytes()}
@@ -628,6 +1098,11 @@ func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Ag
 			out.Max = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
 		case storepb.Aggr_COUNTER:
 			x, err := ac.Get(downsample.AggrCounter)
+			if err == downsample.ErrAggrNotExist {
+				// Series downsampled as gauges have no counter aggregate. Their maximum
+				// per window is the closest approximation of it.
+				x, err = ac.Get(downsample.AggrMax)
+			}
 			if err != nil {
 				return errors.Errorf("aggregate %s does not exist", downsample.AggrCounter)
 			}
@@ -678,13 +1153,37 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 	if err != nil {
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
+	if maxt, ok := s.maxTime(); ok && req.MaxTime > maxt {
+		// Newer data is served by other stores, even if blocks of this store contain it.
+		if req.MinTime > maxt {
+			return nil
+		}
+		req.MaxTime = maxt
+	}
+	if req.HintsOnly || req.Hints {
+		// Hints are estimated from the postings of the blocks before any series or chunks are fetched. The
+		// postings are fetched again for the series below, usually from the index cache.
+		series, chunks, err := s.estimateSeries(srv.Context(), req, matchers)
+		if err != nil {
+			return status.Error(codes.Aborted, err.Error())
+		}
+		if err := srv.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: series, Chunks: chunks})); err != nil {
+			return status.Error(codes.Unknown, errors.Wrap(err, "send hints response").Error())
+		}
+		if req.HintsOnly {
+			return nil
+		}
+	}
 	var (
 		stats = &queryStats{}
 		g     run.Group
 		res   []storepb.SeriesSet
 		mtx   sync.Mutex
+		// Resolutions of the queried blocks.
+		resolutions = map[int64]struct{}{}
+		chunkGate   = newChunkGate(s.chunkDecodeConcurrency, s.metrics.chunkDecodeWait)
 	)
-	s.mtx.RLock()
+	s.rlockBlocks()
 
 	for _, bs := range s.blockSets {
 		blockMatchers, ok := bs.labelMatchers(matchers...)
@@ -698,18 +1197,30 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		}
 
 		for _, b := range blocks {
+			if !b.mayMatch(blockMatchers) {
+				stats.blocksSkipped++
+				continue
+			}
 			stats.blocksQueried++
+			resolutions[b.meta.Thanos.Downsample.Resolution] = struct{}{}
 
 			b := b
 			ctx, cancel := context.WithCancel(srv.Context())
 
-			// We must keep the readers open until all their data has been sent.
-			indexr := b.indexReader(ctx)
-			chunkr := b.chunkReader(ctx)
-			defer indexr.Close()
+			// We must keep the chunk reader open until all its data has been sent.
+			chunkr := b.chunkReader(ctx, chunkGate)
 			defer chunkr.Close()
 
+			// Readers are created while holding the lock, only the index is loaded concurrently.
+			indexr := s.blockIndexReader(ctx, b)
+
 			g.Add(func() error {
+				defer indexr.Close()
+
+				if err := s.loadIndex(indexr); err != nil {
+					return err
+				}
+
 				part, pstats, err := s.blockSeries(ctx,
 					b.meta.ULID,
 					b.meta.Thanos.Labels,
@@ -736,7 +1247,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 		}
 	}
 
-	s.mtx.RUnlock()
+	s.runlockBlocks()
 
 	// Concurrently get data from all blocks.
 	{
@@ -796,13 +1307,142 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 	s.metrics.seriesDataSizeTouched.WithLabelValues("chunks").Observe(float64(stats.chunksTouchedSizeSum))
 	s.metrics.seriesDataSizeFetched.WithLabelValues("chunks").Observe(float64(stats.chunksFetchedSizeSum))
 	s.metrics.resultSeriesCount.Observe(float64(stats.mergedSeriesCount))
+	s.metrics.chunkRangeReads.WithLabelValues("coalesced").Add(float64(stats.chunksFetchCoalesced))
+	s.metrics.chunkRangeReads.WithLabelValues("individual").Add(float64(stats.chunksFetchCount - stats.chunksFetchCoalesced))
+	s.metrics.seriesChunks.WithLabelValues("pruned").Add(float64(stats.chunksPruned))
+	s.metrics.seriesChunks.WithLabelValues("selected").Add(float64(stats.chunksSelected))
+	s.metrics.seriesShardSkipped.Add(float64(stats.seriesShardSkipped))
+	s.metrics.seriesBlocksSkipped.Add(float64(stats.blocksSkipped))
 
 	level.Debug(s.logger).Log("msg", "series query processed",
 		"stats", fmt.Sprintf("%+v", stats))
 
+	reportFetchedBytes(srv.Context(), int64(stats.postingsFetchedSizeSum+stats.seriesFetchedSizeSum+stats.chunksFetchedSizeSum))
+	resList := make([]int64, 0, len(resolutions))
+	for r := range resolutions {
+		resList = append(resList, r)
+	}
+	reportSourceResolutions(srv.Context(), resList...)
 	return nil
 }
 
+// estimateSeries estimates the number of series and chunks matching the request from the postings of the blocks
+// without fetching any series or chunks. A series spans all blocks of its time range, so counts of blocks are not
+// summed up over time. Within each block set the series of blocks overlapping in time are summed, as they may hold
+// different series, and the largest such sum of any block is taken. The sums of all block sets are added up, as
+// their series differ in their external labels. The result estimates the series selected at the same time, series
+// churning over the requested range are not accounted for. Chunks of different blocks are distinct, so the chunks
+// estimated for each block are summed up.
+func (s *BucketStore) estimateSeries(ctx context.Context, req *storepb.SeriesRequest, matchers []labels.Matcher) (series, chunks int64, err error) {
+	var (
+		g   errgroup.Group
+		mtx sync.Mutex
+		// Matched series of each block of each block set.
+		counts = map[*bucketBlockSet]map[*bucketBlock]int{}
+	)
+	s.rlockBlocks()
+
+	for _, bs := range s.blockSets {
+		blockMatchers, ok := bs.labelMatchers(matchers...)
+		if !ok {
+			continue
+		}
+		for _, b := range bs.getFor(req.MinTime, req.MaxTime, req.MaxResolutionWindow) {
+			if !b.mayMatch(blockMatchers) {
+				continue
+			}
+			bs, b := bs, b
+			indexr := s.blockIndexReader(ctx, b)
+
+			g.Go(func() error {
+				defer indexr.Close()
+
+				if err := s.loadIndex(indexr); err != nil {
+					return err
+				}
+
+				n, err := blockPostingsCount(indexr, blockMatchers)
+				if err != nil {
+					return errors.Wrapf(err, "count postings of block %s", b.meta.ULID)
+				}
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				if counts[bs] == nil {
+					counts[bs] = map[*bucketBlock]int{}
+				}
+				counts[bs][b] = n
+				chunks += blockChunksEstimate(b.meta, n, req.MinTime, req.MaxTime)
+				return nil
+			})
+		}
+	}
+
+	s.runlockBlocks()
+
+	if err := g.Wait(); err != nil {
+		return 0, 0, err
+	}
+	for _, bc := range counts {
+		series += int64(maxOverlappingSeries(bc))
+	}
+	return series, chunks, nil
+}
+
+// blockChunksEstimate estimates the chunks of n series of the block within the given time range, assuming the
+// chunks of the block are spread evenly over its series and time range.
+func blockChunksEstimate(meta *block.Meta, n int, mint, maxt int64) int64 {
+	if n == 0 || meta.Stats.NumSeries == 0 || meta.MaxTime <= meta.MinTime {
+		return 0
+	}
+	from, to := meta.MinTime, meta.MaxTime
+	if mint > from {
+		from = mint
+	}
+	if maxt < to {
+		to = maxt
+	}
+	if to <= from {
+		return 0
+	}
+	perSeries := float64(meta.Stats.NumChunks) / float64(meta.Stats.NumSeries)
+	return int64(math.Ceil(float64(n) * perSeries * float64(to-from) / float64(meta.MaxTime-meta.MinTime)))
+}
+
+// maxOverlappingSeries returns the largest sum of the series of a block and all blocks overlapping it in time.
+func maxOverlappingSeries(counts map[*bucketBlock]int) (max int) {
+	for b, n := range counts {
+		for o, on := range counts {
+			if o != b && o.meta.MinTime < b.meta.MaxTime && b.meta.MinTime < o.meta.MaxTime {
+				n += on
+			}
+		}
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// blockPostingsCount returns the number of series of the block matching all matchers.
+func blockPostingsCount(indexr *bucketIndexReader, matchers []labels.Matcher) (int, error) {
+	lazyPostings, err := tsdb.PostingsForMatchers(indexr, matchers...)
+	if err != nil {
+		return 0, errors.Wrap(err, "get postings for matchers")
+	}
+	if lazyPostings == index.EmptyPostings() {
+		return 0, nil
+	}
+	if err := indexr.preloadPostings(); err != nil {
+		return 0, errors.Wrap(err, "preload postings")
+	}
+	ps, err := index.ExpandPostings(lazyPostings)
+	if err != nil {
+		return 0, errors.Wrap(err, "expand postings")
+	}
+	return len(ps), nil
+}
+
 func chunksSize(chks []storepb.AggrChunk) (size int) {
 	for _, chk := range chks {
 		size += chk.Size() // This gets the encoded proto size.
@@ -811,30 +1451,101 @@ func chunksSize(chks []storepb.AggrChunk) (size int) {
 }
 
 // LabelNames implements the storepb.StoreServer interface.
-func (s *BucketStore) LabelNames(context.Context, *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "not implemented")
+func (s *BucketStore) LabelNames(ctx context.Context, _ *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	ctx, cancel := s.labelRequestContext(ctx)
+	defer cancel()
+
+	var g errgroup.Group
+
+	s.rlockBlocks()
+
+	var mtx sync.Mutex
+	var sets [][]string
+
+	for id, b := range s.blocks {
+		if _, ok := s.hidden[id]; ok {
+			continue
+		}
+		indexr := s.blockIndexReader(ctx, b)
+
+		g.Go(func() error {
+			defer indexr.Close()
+
+			if err := s.loadIndex(indexr); err != nil {
+				return err
+			}
+
+			names := indexr.LabelNames()
+
+			mtx.Lock()
+			sets = append(sets, names)
+			mtx.Unlock()
+
+			return nil
+		})
+	}
+
+	s.runlockBlocks()
+
+	if err := g.Wait(); err != nil {
+		if errors.Cause(err) == context.DeadlineExceeded {
+			return nil, s.checkLabelResponse(ctx, 0)
+		}
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	names := strutil.MergeSlices(sets...)
+	if err := s.checkLabelResponse(ctx, len(names)); err != nil {
+		return nil, err
+	}
+	return &storepb.LabelNamesResponse{Names: names}, nil
+}
+
+// MetricMetadata implements the storepb.StoreServer interface. Blocks do not contain metadata.
+func (s *BucketStore) MetricMetadata(context.Context, *storepb.MetricMetadataRequest) (*storepb.MetricMetadataResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "metadata is not stored in blocks")
+}
+
+// Exemplars implements the storepb.StoreServer interface. Blocks do not contain exemplars.
+func (s *BucketStore) Exemplars(context.Context, *storepb.ExemplarsRequest) (*storepb.ExemplarsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "exemplars are not stored in blocks")
 }
 
 // LabelValues implements the storepb.StoreServer interface.
 func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	ctx, cancel := s.labelRequestContext(ctx)
+	defer cancel()
+
 	var g errgroup.Group
 
-	s.mtx.RLock()
+	s.rlockBlocks()
 
 	var mtx sync.Mutex
 	var sets [][]string
 
-	for _, b := range s.blocks {
-		indexr := b.indexReader(ctx)
+	for id, b := range s.blocks {
+		if _, ok := s.hidden[id]; ok {
+			continue
+		}
+		indexr := s.blockIndexReader(ctx, b)
+
 		// TODO(fabxc): only aggregate chunk metas first and add a subsequent fetch stage
 		// where we consolidate requests.
 		g.Go(func() error {
 			defer indexr.Close()
 
+			if err := s.loadIndex(indexr); err != nil {
+				return err
+			}
+
 			tpls, err := indexr.LabelValues(req.Label)
 			if err != nil {
 				return errors.Wrap(err, "lookup label values")
 			}
+			// A single block exceeding the limit is enough to reject the request,
+			// don't bother materializing its values.
+			if s.labelRequestLimit > 0 && tpls.Len() > s.labelRequestLimit {
+				return errLabelRequestLimit
+			}
 			res := make([]string, 0, tpls.Len())
 
 			for i := 0; i < tpls.Len(); i++ {
@@ -849,20 +1560,55 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 			sets = append(sets, res)
 			mtx.Unlock()
 
-			return nil
+			return ctx.Err()
 		})
 	}
 
-	s.mtx.RUnlock()
+	s.runlockBlocks()
 
 	if err := g.Wait(); err != nil {
+		if errors.Cause(err) == errLabelRequestLimit {
+			s.metrics.labelRequestsLimited.WithLabelValues("limit").Inc()
+			return nil, status.Errorf(codes.ResourceExhausted, "label request exceeded limit of %d entries", s.labelRequestLimit)
+		}
+		if errors.Cause(err) == context.DeadlineExceeded {
+			return nil, s.checkLabelResponse(ctx, 0)
+		}
 		return nil, status.Error(codes.Aborted, err.Error())
 	}
+	vals := strutil.MergeSlices(sets...)
+	if err := s.checkLabelResponse(ctx, len(vals)); err != nil {
+		return nil, err
+	}
 	return &storepb.LabelValuesResponse{
-		Values: strutil.MergeSlices(sets...),
+		Values: vals,
 	}, nil
 }
 
+var errLabelRequestLimit = errors.New("label request limit exceeded")
+
+// labelRequestContext returns a context bounded by the configured label request timeout.
+func (s *BucketStore) labelRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.labelRequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.labelRequestTimeout)
+}
+
+// checkLabelResponse returns a ResourceExhausted error if a label response with n entries
+// exceeds the configured limit or the request ran out of time.
+func (s *BucketStore) checkLabelResponse(ctx context.Context, n int) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		s.metrics.labelRequestsLimited.WithLabelValues("timeout").Inc()
+		return status.Errorf(codes.ResourceExhausted, "label request exceeded timeout of %s", s.labelRequestTimeout)
+	}
+	if s.labelRequestLimit > 0 && n > s.labelRequestLimit {
+		s.metrics.labelRequestsLimited.WithLabelValues("limit").Inc()
+		return status.Errorf(codes.ResourceExhausted, "label request exceeded limit of %d entries", s.labelRequestLimit)
+	}
+	return nil
+}
+
 // bucketBlockSet holds all blocks of an equal label set. It internally splits
 // them up by downsampling resolution and allows querying
 type bucketBlockSet struct {
@@ -990,6 +1736,96 @@ func (s *bucketBlockSet) labelMatchers(matchers ...labels.Matcher) ([]labels.Mat
 
 // bucketBlock represents a block that is located in a bucket. It holds intermediate
 // state for the block on local disk.
+// indexLookup serves the lookups of the symbols, label values and postings offsets of a block index, which
+// queries need besides the ranges of the index they read from the bucket.
+type indexLookup interface {
+	// Version returns the format version of the index.
+	Version() int
+	// LookupSymbol returns the symbol with the given reference.
+	LookupSymbol(ref uint32) (string, error)
+	// LabelNames returns the sorted names of all labels.
+	LabelNames() []string
+	// LabelValues returns the sorted values of the label with the given name.
+	LabelValues(name string) ([]string, error)
+	// PostingsRange returns the range of the postings list of the given label pair in the index.
+	PostingsRange(name, value string) (index.Range, bool)
+	// MemoryBytes estimates the memory held by the lookup structures.
+	MemoryBytes() int
+	Close() error
+}
+
+// cachedIndex serves index lookups from the structures read from an index cache file.
+type cachedIndex struct {
+	version  int
+	symbols  map[uint32]string
+	lvals    map[string][]string
+	postings map[labels.Label]index.Range
+}
+
+func readCachedIndex(fn string) (*cachedIndex, error) {
+	var (
+		c   cachedIndex
+		err error
+	)
+	c.version, c.symbols, c.lvals, c.postings, err = block.ReadIndexCache(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *cachedIndex) Version() int {
+	return c.version
+}
+
+func (c *cachedIndex) LookupSymbol(ref uint32) (string, error) {
+	s, ok := c.symbols[ref]
+	if !ok {
+		return "", errors.Errorf("unknown symbol reference %d", ref)
+	}
+	return s, nil
+}
+
+func (c *cachedIndex) LabelNames() []string {
+	names := make([]string, 0, len(c.lvals))
+	for n := range c.lvals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *cachedIndex) LabelValues(name string) ([]string, error) {
+	return c.lvals[name], nil
+}
+
+func (c *cachedIndex) PostingsRange(name, value string) (index.Range, bool) {
+	r, ok := c.postings[labels.Label{Name: name, Value: value}]
+	return r, ok
+}
+
+// MemoryBytes estimates the memory held by the lookup structures. Strings shared between them are only
+// accounted once.
+func (c *cachedIndex) MemoryBytes() (n int) {
+	const (
+		stringHeader = 16
+		sliceHeader  = 24
+		mapEntry     = 8
+	)
+	for _, s := range c.symbols {
+		n += 4 + stringHeader + len(s) + mapEntry
+	}
+	for _, vals := range c.lvals {
+		n += stringHeader + sliceHeader + len(vals)*stringHeader + mapEntry
+	}
+	n += len(c.postings) * (2*stringHeader + 16 + mapEntry)
+	return n
+}
+
+func (c *cachedIndex) Close() error {
+	return nil
+}
+
 type bucketBlock struct {
 	logger     log.Logger
 	bucket     objstore.BucketReader
@@ -997,14 +1833,37 @@ type bucketBlock struct {
 	dir        string
 	indexCache *indexCache
 	chunkPool  *pool.BytesPool
+	// Pool for the buffers of index ranges, nil if they are not pooled.
+	indexPool *pool.BytesPool
+	// Reader for the index and chunk ranges read by queries.
+	rangeBucket objstore.BucketReader
 
-	indexVersion int
-	symbols      map[uint32]string
-	lvals        map[string][]string
-	postings     map[labels.Label]index.Range
+	// Lookups of the index, nil while lazily loaded lookup structures are not loaded.
+	index indexLookup
 
 	indexObj  string
 	chunkObjs []string
+	// Decompressed local copy of a compressed index. Index ranges are read from the bucket if it is nil.
+	indexFile *os.File
+	// Chunks closer than this many bytes are fetched with a single range read.
+	chunkPrefetchGap uint64
+
+	// The strategy the index lookup structures were loaded with and how many bytes of the index it fetched.
+	indexLoadStrategy string
+	indexFetchedBytes int64
+
+	// If true, the index lookup structures are loaded on first use and may be unloaded while no reader uses them.
+	lazyIndex     bool
+	indexMtx      sync.Mutex
+	indexLoaded   bool
+	indexUsers    int
+	indexLastUsed time.Time
+
+	// If summaries is true, summary is computed whenever the index lookup structures are loaded and kept
+	// when they are unloaded. It is nil until they were loaded once.
+	summaries  bool
+	summaryMtx sync.Mutex
+	summary    *blockSummary
 
 	pendingReaders sync.WaitGroup
 }
@@ -1013,24 +1872,54 @@ func newBucketBlock(
 	ctx context.Context,
 	logger log.Logger,
 	bkt objstore.BucketReader,
+	rangeBkt objstore.BucketReader,
 	id ulid.ULID,
 	dir string,
 	indexCache *indexCache,
 	chunkPool *pool.BytesPool,
+	indexPool *pool.BytesPool,
+	chunkPrefetchGap uint64,
+	indexLoadStrategy string,
+	lazyIndex bool,
+	summaries bool,
 ) (b *bucketBlock, err error) {
 	b = &bucketBlock{
-		logger:     logger,
-		bucket:     bkt,
-		indexObj:   path.Join(id.String(), block.IndexFilename),
-		indexCache: indexCache,
-		chunkPool:  chunkPool,
-		dir:        dir,
+		logger:           logger,
+		bucket:           bkt,
+		rangeBucket:      rangeBkt,
+		indexObj:         path.Join(id.String(), block.IndexFilename),
+		indexCache:       indexCache,
+		chunkPool:        chunkPool,
+		indexPool:        indexPool,
+		chunkPrefetchGap: chunkPrefetchGap,
+		dir:              dir,
+		lazyIndex:        lazyIndex,
+		summaries:        summaries,
 	}
 	if err = b.loadMeta(ctx, id); err != nil {
 		return nil, errors.Wrap(err, "load meta")
 	}
-	if err = b.loadIndexCache(ctx); err != nil {
-		return nil, errors.Wrap(err, "load index cache")
+	if b.meta.Thanos.IndexCompression != "" {
+		if err = b.loadLocalIndex(ctx); err != nil {
+			return nil, errors.Wrap(err, "load compressed index")
+		}
+		defer func() {
+			if err != nil {
+				runutil.LogOnErr(b.logger, b.indexFile, "close index file")
+			}
+		}()
+	}
+	// The index header is built by range reads, which are not possible for compressed indexes.
+	if indexLoadStrategy == IndexHeaderStrategy && b.indexFile == nil {
+		b.indexLoadStrategy = IndexHeaderStrategy
+	} else {
+		b.indexLoadStrategy = IndexCacheStrategy
+	}
+	if !lazyIndex {
+		if err = b.loadIndex(ctx); err != nil {
+			return nil, err
+		}
+		b.indexLoaded = true
 	}
 	// Get object handles for all chunk files.
 	err = bkt.Iter(ctx, path.Join(id.String(), block.ChunksDirname), func(n string) error {
@@ -1065,10 +1954,156 @@ func (b *bucketBlock) loadMeta(ctx context.Context, id ulid.ULID) error {
 	return nil
 }
 
+// loadLocalIndex downloads and decompresses a compressed index unless it is already on disk.
+// Compressed indexes cannot be read by range from the bucket, so the decompressed copy is kept for the lifetime of the block.
+func (b *bucketBlock) loadLocalIndex(ctx context.Context) error {
+	fn := filepath.Join(b.dir, block.IndexFilename)
+
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		compressed := fn + ".compressed"
+
+		if err := objstore.DownloadFile(ctx, b.bucket, b.indexObj, compressed); err != nil {
+			return errors.Wrap(err, "download index file")
+		}
+		defer os.Remove(compressed)
+
+		// The index is decompressed to a temporary file first, so an interrupted decompression does not
+		// leave a partial index behind that is used on the next start.
+		tmp := fn + ".tmp"
+		if err := block.DecompressIndexFile(b.meta.Thanos.IndexCompression, compressed, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, fn); err != nil {
+			os.Remove(tmp)
+			return errors.Wrap(err, "rename decompressed index file")
+		}
+	} else if err != nil {
+		return err
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return errors.Wrap(err, "open index file")
+	}
+	b.indexFile = f
+	return nil
+}
+
+// loadIndex loads the index lookup structures with the strategy of the block.
+func (b *bucketBlock) loadIndex(ctx context.Context) error {
+	b.indexFetchedBytes = 0
+
+	if b.indexLoadStrategy == IndexHeaderStrategy {
+		if err := b.loadIndexHeader(ctx); err != nil {
+			return errors.Wrap(err, "load index header")
+		}
+	} else if err := b.loadIndexCache(ctx); err != nil {
+		return errors.Wrap(err, "load index cache")
+	}
+	if b.summaries {
+		summary := &blockSummary{labels: map[string]*labelSummary{}}
+		for _, name := range b.index.LabelNames() {
+			vals, err := b.index.LabelValues(name)
+			if err != nil {
+				runutil.LogOnErr(b.logger, b.index, "close index lookups")
+				b.index = nil
+				return errors.Wrapf(err, "summarize values of label %s", name)
+			}
+			summary.add(name, vals)
+		}
+
+		b.summaryMtx.Lock()
+		b.summary = summary
+		b.summaryMtx.Unlock()
+	}
+	return nil
+}
+
+// mayMatch returns false if the summary of the block rules out series matching all the given matchers.
+// Without a summary it always returns true.
+func (b *bucketBlock) mayMatch(ms []labels.Matcher) bool {
+	b.summaryMtx.Lock()
+	summary := b.summary
+	b.summaryMtx.Unlock()
+
+	return summary == nil || summary.mayMatch(ms)
+}
+
+// acquireIndex marks the index lookup structures as used until releaseIndex is called. Lazily loaded
+// structures are loaded first, in which case it returns true.
+func (b *bucketBlock) acquireIndex(ctx context.Context) (loaded bool, err error) {
+	b.useIndex()
+
+	loaded, err = b.loadUsedIndex(ctx)
+	if err != nil {
+		b.releaseIndex()
+		return false, err
+	}
+	return loaded, nil
+}
+
+// useIndex marks the index lookup structures as used until releaseIndex is called, without loading them.
+func (b *bucketBlock) useIndex() {
+	if !b.lazyIndex {
+		return
+	}
+	b.indexMtx.Lock()
+	defer b.indexMtx.Unlock()
+
+	b.indexUsers++
+}
+
+// loadUsedIndex loads lazily loaded index lookup structures marked as used unless they are loaded already,
+// in which case it returns true.
+func (b *bucketBlock) loadUsedIndex(ctx context.Context) (loaded bool, err error) {
+	if !b.lazyIndex {
+		return false, nil
+	}
+	b.indexMtx.Lock()
+	defer b.indexMtx.Unlock()
+
+	if b.indexLoaded {
+		return false, nil
+	}
+	if err := b.loadIndex(ctx); err != nil {
+		return false, err
+	}
+	b.indexLoaded = true
+	return true, nil
+}
+
+func (b *bucketBlock) releaseIndex() {
+	if !b.lazyIndex {
+		return
+	}
+	b.indexMtx.Lock()
+	defer b.indexMtx.Unlock()
+
+	b.indexUsers--
+	b.indexLastUsed = time.Now()
+}
+
+// unloadIndexIfIdle unloads lazily loaded index lookup structures if no reader uses them and they
+// were last used longer than the timeout ago. The index cache or header file is kept on disk to reload them.
+func (b *bucketBlock) unloadIndexIfIdle(now time.Time, timeout time.Duration) bool {
+	if !b.lazyIndex {
+		return false
+	}
+	b.indexMtx.Lock()
+	defer b.indexMtx.Unlock()
+
+	if !b.indexLoaded || b.indexUsers > 0 || now.Sub(b.indexLastUsed) < timeout {
+		return false
+	}
+	runutil.LogOnErr(b.logger, b.index, "close index lookups")
+	b.index = nil
+	b.indexLoaded = false
+	return true
+}
+
 func (b *bucketBlock) loadIndexCache(ctx context.Context) (err error) {
 	cachefn := filepath.Join(b.dir, block.IndexCacheFilename)
 
-	b.indexVersion, b.symbols, b.lvals, b.postings, err = block.ReadIndexCache(cachefn)
+	b.index, err = readCachedIndex(cachefn)
 	if err == nil {
 		return nil
 	}
@@ -1078,10 +2113,17 @@ func (b *bucketBlock) loadIndexCache(ctx context.Context) (err error) {
 	// No cache exists is on disk yet, build it from a the downloaded index and retry.
 	fn := filepath.Join(b.dir, block.IndexFilename)
 
-	if err := objstore.DownloadFile(ctx, b.bucket, b.indexObj, fn); err != nil {
-		return errors.Wrap(err, "download index file")
+	// A decompressed index is already on disk and must be kept.
+	if b.indexFile == nil {
+		if err := objstore.DownloadFile(ctx, b.bucket, b.indexObj, fn); err != nil {
+			return errors.Wrap(err, "download index file")
+		}
+		defer os.Remove(fn)
+
+		if fi, err := os.Stat(fn); err == nil {
+			b.indexFetchedBytes = fi.Size()
+		}
 	}
-	defer os.Remove(fn)
 
 	indexr, err := index.NewFileReader(fn)
 	if err != nil {
@@ -1093,25 +2135,90 @@ func (b *bucketBlock) loadIndexCache(ctx context.Context) (err error) {
 		return errors.Wrap(err, "write index cache")
 	}
 
-	b.indexVersion, b.symbols, b.lvals, b.postings, err = block.ReadIndexCache(cachefn)
+	b.index, err = readCachedIndex(cachefn)
 	if err != nil {
 		return errors.Wrap(err, "read index cache")
 	}
 	return nil
 }
 
+func (b *bucketBlock) loadIndexHeader(ctx context.Context) (err error) {
+	fn := filepath.Join(b.dir, block.IndexHeaderFilename)
+
+	b.index, err = openIndexHeader(fn)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(errors.Cause(err)) {
+		return errors.Wrap(err, "open index header")
+	}
+	// No index header is on disk yet. Fetch it and retry, resuming earlier interrupted attempts.
+	if err := block.WriteIndexHeader(ctx, b.bucket, b.meta.ULID, fn); err != nil {
+		return errors.Wrap(err, "write index header")
+	}
+	if fi, err := os.Stat(fn); err == nil {
+		b.indexFetchedBytes = fi.Size()
+	}
+
+	b.index, err = openIndexHeader(fn)
+	if err != nil {
+		return errors.Wrap(err, "open index header")
+	}
+	return nil
+}
+
+// openIndexHeader opens the index header file. It returns a nil interface on error, which a nil
+// *block.IndexHeader would not be.
+func openIndexHeader(fn string) (indexLookup, error) {
+	h, err := block.OpenIndexHeader(fn)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readIndexRange reads the given range of the index. The range may exceed the end of the index, in which case
+// the returned bytes are shorter. If the block has an index pool, the bytes must be returned to it with putIndexRange.
 func (b *bucketBlock) readIndexRange(ctx context.Context, off, length int64) ([]byte, error) {
-	r, err := b.bucket.GetRange(ctx, b.indexObj, off, length)
+	var c []byte
+	if b.indexPool != nil {
+		pc, err := b.indexPool.Get(int(length))
+		if err != nil {
+			return nil, errors.Wrap(err, "allocate index bytes")
+		}
+		c = pc[:length]
+	} else {
+		c = make([]byte, length)
+	}
+
+	if b.indexFile != nil {
+		n, err := b.indexFile.ReadAt(c, off)
+		if err != nil && err != io.EOF {
+			b.putIndexRange(c)
+			return nil, errors.Wrap(err, "read range from local index")
+		}
+		return c[:n], nil
+	}
+	r, err := b.rangeBucket.GetRange(ctx, b.indexObj, off, length)
 	if err != nil {
+		b.putIndexRange(c)
 		return nil, errors.Wrap(err, "get range reader")
 	}
 	defer r.Close()
 
-	c, err := ioutil.ReadAll(r)
-	if err != nil {
+	n, err := io.ReadFull(r, c)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		b.putIndexRange(c)
 		return nil, errors.Wrap(err, "read range")
 	}
-	return c, nil
+	return c[:n], nil
+}
+
+// putIndexRange returns bytes read by readIndexRange to the index pool.
+func (b *bucketBlock) putIndexRange(c []byte) {
+	if b.indexPool != nil {
+		b.indexPool.Put(c)
+	}
 }
 
 func (b *bucketBlock) readChunkRange(ctx context.Context, seq int, off, length int64) ([]byte, error) {
@@ -1121,13 +2228,15 @@ func (b *bucketBlock) readChunkRange(ctx context.Context, seq int, off, length i
 	}
 	buf := bytes.NewBuffer(c)
 
-	r, err := b.bucket.GetRange(ctx, b.chunkObjs[seq], off, length)
+	r, err := b.rangeBucket.GetRange(ctx, b.chunkObjs[seq], off, length)
 	if err != nil {
+		b.chunkPool.Put(c)
 		return nil, errors.Wrap(err, "get range reader")
 	}
 	defer r.Close()
 
 	if _, err = io.Copy(buf, r); err != nil {
+		b.chunkPool.Put(c)
 		return nil, errors.Wrap(err, "read range")
 	}
 	return buf.Bytes(), nil
@@ -1138,14 +2247,27 @@ func (b *bucketBlock) indexReader(ctx context.Context) *bucketIndexReader {
 	return newBucketIndexReader(ctx, b.logger, b, b.indexCache)
 }
 
-func (b *bucketBlock) chunkReader(ctx context.Context) *bucketChunkReader {
+// chunkReader returns a reader for the chunks of the block. Chunk ranges are only loaded while holding a slot of
+// the gate, which may be shared by the readers of a request. A nil gate does not limit the loads.
+func (b *bucketBlock) chunkReader(ctx context.Context, gate *chunkGate) *bucketChunkReader {
 	b.pendingReaders.Add(1)
-	return newBucketChunkReader(ctx, b)
+	return newBucketChunkReader(ctx, b, gate)
 }
 
 // Close waits for all pending readers to finish and then closes all underlying resources.
 func (b *bucketBlock) Close() error {
 	b.pendingReaders.Wait()
+
+	b.indexMtx.Lock()
+	if b.index != nil {
+		runutil.LogOnErr(b.logger, b.index, "close index lookups")
+		b.index = nil
+	}
+	b.indexMtx.Unlock()
+
+	if b.indexFile != nil {
+		return b.indexFile.Close()
+	}
 	return nil
 }
 
@@ -1157,9 +2279,14 @@ type bucketIndexReader struct {
 	stats  *queryStats
 	cache  *indexCache
 
+	// Symbols looked up by the reader, so that symbols copied out of an index header are only copied once.
+	symbols map[uint32]string
+
 	mtx            sync.Mutex
 	loadedPostings []*lazyPostings
 	loadedSeries   map[uint64][]byte
+	// Index ranges read by the reader. Postings and series reference them until the reader is closed.
+	ranges [][]byte
 }
 
 func newBucketIndexReader(ctx context.Context, logger log.Logger, block *bucketBlock, cache *indexCache) *bucketIndexReader {
@@ -1170,9 +2297,9 @@ func newBucketIndexReader(ctx context.Context, logger log.Logger, block *bucketB
 		dec:          &index.Decoder{},
 		stats:        &queryStats{},
 		cache:        cache,
+		symbols:      map[uint32]string{},
 		loadedSeries: map[uint64][]byte{},
 	}
-	r.dec.SetSymbolTable(r.block.symbols)
 	return r
 }
 
@@ -1216,6 +2343,8 @@ func (r *bucketIndexReader) loadPostings(ctx context.Context, postings []*lazyPo
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
+	r.ranges = append(r.ranges, b)
+
 	r.stats.postingsFetchCount++
 	r.stats.postingsFetched += len(postings)
 	r.stats.postingsFetchDurationSum += time.Since(begin)
@@ -1283,6 +2412,8 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []uint64, start,
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
+	r.ranges = append(r.ranges, b)
+
 	r.stats.seriesFetchCount++
 	r.stats.seriesFetched += len(ids)
 	r.stats.seriesFetchDurationSum += time.Since(begin)
@@ -1340,7 +2471,11 @@ func (r *bucketIndexReader) LabelValues(names ...string) (index.StringTuples, er
 	if len(names) != 1 {
 		return nil, errors.New("label value lookups only supported for single name")
 	}
-	return index.NewStringTuples(r.block.lvals[names[0]], 1)
+	vals, err := r.block.index.LabelValues(names[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "get label values")
+	}
+	return index.NewStringTuples(vals, 1)
 }
 
 type lazyPostings struct {
@@ -1359,7 +2494,7 @@ func (p *lazyPostings) set(v index.Postings) {
 // background garbage collections.
 func (r *bucketIndexReader) Postings(name, value string) (index.Postings, error) {
 	l := labels.Label{Name: name, Value: value}
-	ptr, ok := r.block.postings[l]
+	ptr, ok := r.block.index.PostingsRange(name, value)
 	if !ok {
 		return index.EmptyPostings(), nil
 	}
@@ -1397,7 +2532,109 @@ func (r *bucketIndexReader) Series(ref uint64, lset *labels.Labels, chks *[]chun
 	r.stats.seriesTouched++
 	r.stats.seriesTouchedSizeSum += len(b)
 
-	return r.dec.Series(b, lset, chks)
+	return decodeSeries(b, r.lookupSymbol, lset, chks)
+}
+
+func (r *bucketIndexReader) lookupSymbol(ref uint32) (string, error) {
+	if s, ok := r.symbols[ref]; ok {
+		return s, nil
+	}
+	s, err := r.block.index.LookupSymbol(ref)
+	if err != nil {
+		return "", err
+	}
+	r.symbols[ref] = s
+	return s, nil
+}
+
+// decodeSeries decodes the labels and chunk metas of a series entry of the index like index.Decoder does,
+// but looks up symbols with the given function instead of a symbol table.
+func decodeSeries(b []byte, lookupSymbol func(uint32) (string, error), lset *labels.Labels, chks *[]chunks.Meta) error {
+	*lset = (*lset)[:0]
+	*chks = (*chks)[:0]
+
+	d := seriesDecbuf{b: b}
+
+	k := int(d.uvarint())
+	for i := 0; i < k; i++ {
+		lno := uint32(d.uvarint())
+		lvo := uint32(d.uvarint())
+		if d.err != nil {
+			return errors.Wrap(d.err, "read series label offsets")
+		}
+		ln, err := lookupSymbol(lno)
+		if err != nil {
+			return errors.Wrap(err, "lookup label name")
+		}
+		lv, err := lookupSymbol(lvo)
+		if err != nil {
+			return errors.Wrap(err, "lookup label value")
+		}
+		*lset = append(*lset, labels.Label{Name: ln, Value: lv})
+	}
+
+	k = int(d.uvarint())
+	if d.err != nil {
+		return errors.Wrap(d.err, "read series chunk count")
+	}
+	if k == 0 {
+		return nil
+	}
+
+	// The first chunk meta is stored in full, the following ones as deltas to their predecessor.
+	t0 := d.varint()
+	maxt := int64(d.uvarint()) + t0
+	ref0 := int64(d.uvarint())
+	if d.err != nil {
+		return errors.Wrap(d.err, "read meta of chunk 0")
+	}
+	*chks = append(*chks, chunks.Meta{Ref: uint64(ref0), MinTime: t0, MaxTime: maxt})
+	t0 = maxt
+
+	for i := 1; i < k; i++ {
+		mint := int64(d.uvarint()) + t0
+		maxt := int64(d.uvarint()) + mint
+		ref0 += d.varint()
+		if d.err != nil {
+			return errors.Wrapf(d.err, "read meta of chunk %d", i)
+		}
+		t0 = maxt
+
+		*chks = append(*chks, chunks.Meta{Ref: uint64(ref0), MinTime: mint, MaxTime: maxt})
+	}
+	return nil
+}
+
+// seriesDecbuf reads varints from a series entry and records the first error.
+type seriesDecbuf struct {
+	b   []byte
+	err error
+}
+
+func (d *seriesDecbuf) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.b)
+	if n < 1 {
+		d.err = errors.New("invalid uvarint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return x
+}
+
+func (d *seriesDecbuf) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.b)
+	if n < 1 {
+		d.err = errors.New("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return x
 }
 
 // LabelIndices returns the label pairs for which indices exist.
@@ -1405,8 +2642,17 @@ func (r *bucketIndexReader) LabelIndices() ([][]string, error) {
 	return nil, errors.New("not implemented")
 }
 
+// LabelNames returns the sorted names of all labels in the block.
+func (r *bucketIndexReader) LabelNames() []string {
+	return r.block.index.LabelNames()
+}
+
 // Close released the underlying resources of the reader.
 func (r *bucketIndexReader) Close() error {
+	for _, b := range r.ranges {
+		r.block.putIndexRange(b)
+	}
+	r.block.releaseIndex()
 	r.block.pendingReaders.Done()
 	return nil
 }
@@ -1415,6 +2661,7 @@ type bucketChunkReader struct {
 	ctx   context.Context
 	block *bucketBlock
 	stats *queryStats
+	gate  *chunkGate
 
 	preloads [][]uint32
 	mtx      sync.Mutex
@@ -1424,11 +2671,12 @@ type bucketChunkReader struct {
 	chunkBytes [][]byte
 }
 
-func newBucketChunkReader(ctx context.Context, block *bucketBlock) *bucketChunkReader {
+func newBucketChunkReader(ctx context.Context, block *bucketBlock, gate *chunkGate) *bucketChunkReader {
 	return &bucketChunkReader{
 		ctx:      ctx,
 		block:    block,
 		stats:    &queryStats{},
+		gate:     gate,
 		preloads: make([][]uint32, len(block.chunkObjs)),
 		chunks:   map[uint64]chunkenc.Chunk{},
 	}
@@ -1450,7 +2698,6 @@ func (r *bucketChunkReader) addPreload(id uint64) error {
 // preload all added chunk IDs. Must be called before the first call to Chunk is made.
 func (r *bucketChunkReader) preload() error {
 	const maxChunkSize = 16000
-	const maxGapSize = 512 * 1024
 
 	var g run.Group
 
@@ -1460,7 +2707,7 @@ func (r *bucketChunkReader) preload() error {
 		})
 		parts := partitionRanges(len(offsets), func(i int) (start, end uint64) {
 			return uint64(offsets[i]), uint64(offsets[i]) + maxChunkSize
-		}, maxGapSize)
+		}, r.block.chunkPrefetchGap)
 
 		seq := seq
 		offsets := offsets
@@ -1470,6 +2717,11 @@ func (r *bucketChunkReader) preload() error {
 			m, n := p[0], p[1]
 
 			g.Add(func() error {
+				if err := r.gate.start(ctx); err != nil {
+					return err
+				}
+				defer r.gate.done()
+
 				return r.loadChunks(ctx, offsets[m:n], seq, offsets[m], offsets[n-1]+maxChunkSize)
 			}, func(err error) {
 				if err != nil {
@@ -1488,12 +2740,18 @@ func (r *bucketChunkReader) loadChunks(ctx context.Context, offs []uint32, seq i
 	if err != nil {
 		return errors.Wrapf(err, "read range for %d", seq)
 	}
-	r.chunkBytes = append(r.chunkBytes, b)
 
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
+	// The bytes between and after the chunks are not referenced by any chunk. They are
+	// released together with the chunks once the reader is closed.
+	r.chunkBytes = append(r.chunkBytes, b)
+
 	r.stats.chunksFetchCount++
+	if len(offs) > 1 {
+		r.stats.chunksFetchCoalesced++
+	}
 	r.stats.chunksFetched += len(offs)
 	r.stats.chunksFetchDurationSum += time.Since(begin)
 	r.stats.chunksFetchedSizeSum += int(end - start)
@@ -1583,6 +2841,7 @@ func renameFile(from, to string) error {
 
 type queryStats struct {
 	blocksQueried int
+	blocksSkipped int
 
 	postingsTouched          int
 	postingsTouchedSizeSum   int
@@ -1597,12 +2856,16 @@ type queryStats struct {
 	seriesFetchedSizeSum   int
 	seriesFetchCount       int
 	seriesFetchDurationSum time.Duration
+	seriesShardSkipped     int
 
+	chunksSelected         int
+	chunksPruned           int
 	chunksTouched          int
 	chunksTouchedSizeSum   int
 	chunksFetched          int
 	chunksFetchedSizeSum   int
 	chunksFetchCount       int
+	chunksFetchCoalesced   int
 	chunksFetchDurationSum time.Duration
 
 	getAllDuration    time.Duration
@@ -1613,6 +2876,7 @@ type queryStats struct {
 
 func (s queryStats) merge(o *queryStats) *queryStats {
 	s.blocksQueried += o.blocksQueried
+	s.blocksSkipped += o.blocksSkipped
 
 	s.postingsTouched += o.postingsTouched
 	s.postingsTouchedSizeSum += o.postingsTouchedSizeSum
@@ -1627,12 +2891,16 @@ func (s queryStats) merge(o *queryStats) *queryStats {
 	s.seriesFetchedSizeSum += o.seriesFetchedSizeSum
 	s.seriesFetchCount += o.seriesFetchCount
 	s.seriesFetchDurationSum += o.seriesFetchDurationSum
+	s.seriesShardSkipped += o.seriesShardSkipped
 
+	s.chunksSelected += o.chunksSelected
+	s.chunksPruned += o.chunksPruned
 	s.chunksTouched += o.chunksTouched
 	s.chunksTouchedSizeSum += o.chunksTouchedSizeSum
 	s.chunksFetched += o.chunksFetched
 	s.chunksFetchedSizeSum += o.chunksFetchedSizeSum
 	s.chunksFetchCount += o.chunksFetchCount
+	s.chunksFetchCoalesced += o.chunksFetchCoalesced
 	s.chunksFetchDurationSum += o.chunksFetchDurationSum
 
 	s.getAllDuration += o.getAllDuration
@@ -21,171 +21,230 @@ import (
 
 func TestBucketStore_e2e(t *testing.T) {
 	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		dir, err := ioutil.TempDir("", "test_bucketstore_e2e")
-		testutil.Ok(t, err)
-		defer os.RemoveAll(dir)
-
-		series := []labels.Labels{
-			labels.FromStrings("a", "1", "b", "1"),
-			labels.FromStrings("a", "1", "b", "2"),
-			labels.FromStrings("a", "2", "b", "1"),
-			labels.FromStrings("a", "2", "b", "2"),
-			labels.FromStrings("a", "1", "c", "1"),
-			labels.FromStrings("a", "1", "c", "2"),
-			labels.FromStrings("a", "2", "c", "1"),
-			labels.FromStrings("a", "2", "c", "2"),
-		}
-		extLset := labels.FromStrings("ext1", "value1")
-
-		start := time.Now()
-		now := start
-
-		minTime := int64(0)
-		maxTime := int64(0)
-		for i := 0; i < 3; i++ {
-			mint := timestamp.FromTime(now)
-			now = now.Add(2 * time.Hour)
-			maxt := timestamp.FromTime(now)
-
-			if minTime == 0 {
-				minTime = mint
-			}
-			maxTime = maxt
-
-			// Create two blocks per time slot. Only add 10 samples each so only one chunk
-			// gets created each. This way we can easily verify we got 10 chunks per series below.
-			id1, err := testutil.CreateBlock(dir, series[:4], 10, mint, maxt, extLset, 0)
-			testutil.Ok(t, err)
-			id2, err := testutil.CreateBlock(dir, series[4:], 10, mint, maxt, extLset, 0)
-			testutil.Ok(t, err)
-
-			dir1, dir2 := filepath.Join(dir, id1.String()), filepath.Join(dir, id2.String())
-
-			// Add labels to the meta of the second block.
-			meta, err := block.ReadMetaFile(dir2)
-			testutil.Ok(t, err)
-			meta.Thanos.Labels = map[string]string{"ext2": "value2"}
-			testutil.Ok(t, block.WriteMetaFile(dir2, meta))
-
-			testutil.Ok(t, block.Upload(ctx, bkt, dir1))
-			testutil.Ok(t, block.Upload(ctx, bkt, dir2))
-
-			testutil.Ok(t, os.RemoveAll(dir1))
-			testutil.Ok(t, os.RemoveAll(dir2))
-		}
+		testBucketStoreE2E(t, bkt, IndexCacheStrategy, false, false, false)
+	})
+}
 
-		store, err := NewBucketStore(nil, nil, bkt, dir, 100, 0, false)
-		testutil.Ok(t, err)
+func TestBucketStore_IndexHeader_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		testBucketStoreE2E(t, bkt, IndexHeaderStrategy, false, false, false)
+	})
+}
 
-		go func() {
-			runutil.Repeat(100*time.Millisecond, ctx.Done(), func() error {
-				return store.SyncBlocks(ctx)
-			})
-		}()
+func TestBucketStore_LazyIndexHeader_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		testBucketStoreE2E(t, bkt, IndexHeaderStrategy, true, false, false)
+	})
+}
 
-		ctx, _ = context.WithTimeout(ctx, 30*time.Second)
+func TestBucketStore_BufferPooling_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		testBucketStoreE2E(t, bkt, IndexHeaderStrategy, false, true, false)
+	})
+}
 
-		err = runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
-			if store.numBlocks() < 6 {
-				return errors.New("not all blocks loaded")
-			}
-			return nil
-		})
-		testutil.Ok(t, err)
+func TestBucketStore_BlockSummaries_e2e(t *testing.T) {
+	objtesting.ForeachStore(t, func(t testing.TB, bkt objstore.Bucket) {
+		testBucketStoreE2E(t, bkt, IndexHeaderStrategy, true, false, true)
+	})
+}
 
-		mint, maxt := store.TimeRange()
-		testutil.Equals(t, minTime, mint)
-		testutil.Equals(t, maxTime, maxt)
+func testBucketStoreE2E(t testing.TB, bkt objstore.Bucket, indexLoadStrategy string, lazyIndex, bufferPooling, blockSummaries bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "test_bucketstore_e2e")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	series := []labels.Labels{
+		labels.FromStrings("a", "1", "b", "1"),
+		labels.FromStrings("a", "1", "b", "2"),
+		labels.FromStrings("a", "2", "b", "1"),
+		labels.FromStrings("a", "2", "b", "2"),
+		labels.FromStrings("a", "1", "c", "1"),
+		labels.FromStrings("a", "1", "c", "2"),
+		labels.FromStrings("a", "2", "c", "1"),
+		labels.FromStrings("a", "2", "c", "2"),
+	}
+	extLset := labels.FromStrings("ext1", "value1")
+
+	start := time.Now()
+	now := start
+
+	minTime := int64(0)
+	maxTime := int64(0)
+	for i := 0; i < 3; i++ {
+		mint := timestamp.FromTime(now)
+		now = now.Add(2 * time.Hour)
+		maxt := timestamp.FromTime(now)
+
+		if minTime == 0 {
+			minTime = mint
+		}
+		maxTime = maxt
 
-		vals, err := store.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+		// Create two blocks per time slot. Only add 10 samples each so only one chunk
+		// gets created each. This way we can easily verify we got 10 chunks per series below.
+		id1, err := testutil.CreateBlock(dir, series[:4], 10, mint, maxt, extLset, 0)
 		testutil.Ok(t, err)
-		testutil.Equals(t, []string{"1", "2"}, vals.Values)
-
-		pbseries := [][]storepb.Label{
-			{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}, {Name: "ext1", Value: "value1"}},
-			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
-			{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
-			{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
-			{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}, {Name: "ext1", Value: "value1"}},
-			{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
-			{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
-			{{Name: "a", Value: "2"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
-		}
-		srv := newStoreSeriesServer(ctx)
-
-		err = store.Series(&storepb.SeriesRequest{
-			Matchers: []storepb.LabelMatcher{
-				{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2"},
-			},
-			MinTime: timestamp.FromTime(start),
-			MaxTime: timestamp.FromTime(now),
-		}, srv)
+		id2, err := testutil.CreateBlock(dir, series[4:], 10, mint, maxt, extLset, 0)
 		testutil.Ok(t, err)
-		testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
 
-		for i, s := range srv.SeriesSet {
-			testutil.Equals(t, pbseries[i], s.Labels)
-			testutil.Equals(t, 3, len(s.Chunks))
-		}
+		dir1, dir2 := filepath.Join(dir, id1.String()), filepath.Join(dir, id2.String())
 
-		pbseries = [][]storepb.Label{
-			{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
-			{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
-		}
-		srv = newStoreSeriesServer(ctx)
-
-		err = store.Series(&storepb.SeriesRequest{
-			Matchers: []storepb.LabelMatcher{
-				{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
-			},
-			MinTime: timestamp.FromTime(start),
-			MaxTime: timestamp.FromTime(now),
-		}, srv)
+		// Add labels to the meta of the second block.
+		meta, err := block.ReadMetaFile(dir2)
 		testutil.Ok(t, err)
-		testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+		meta.Thanos.Labels = map[string]string{"ext2": "value2"}
+		testutil.Ok(t, block.WriteMetaFile(dir2, meta))
+		// Compress the index of the second block so blocks with compressed and uncompressed indexes are mixed.
+		testutil.Ok(t, block.CompressIndex(dir2))
+
+		testutil.Ok(t, block.Upload(ctx, bkt, dir1))
+		testutil.Ok(t, block.Upload(ctx, bkt, dir2))
+
+		testutil.Ok(t, os.RemoveAll(dir1))
+		testutil.Ok(t, os.RemoveAll(dir2))
+	}
+
+	store, err := NewBucketStore(nil, nil, bkt, dir, 100, 0, false, BucketStoreOptions{
+		ChunkPrefetchGap:     512 * 1024,
+		IndexLoadStrategy:    indexLoadStrategy,
+		LazyIndex:            lazyIndex,
+		LazyIndexIdleTimeout: time.Minute,
+		BufferPooling:        bufferPooling,
+		BlockSummaries:       blockSummaries,
+	})
+	testutil.Ok(t, err)
 
-		for i, s := range srv.SeriesSet {
-			testutil.Equals(t, pbseries[i], s.Labels)
-			testutil.Equals(t, 3, len(s.Chunks))
-		}
+	go func() {
+		runutil.Repeat(100*time.Millisecond, ctx.Done(), func() error {
+			return store.SyncBlocks(ctx)
+		})
+	}()
 
-		// Matching by external label should work as well.
-		pbseries = [][]storepb.Label{
-			{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
-			{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
-		}
-		srv = newStoreSeriesServer(ctx)
-
-		err = store.Series(&storepb.SeriesRequest{
-			Matchers: []storepb.LabelMatcher{
-				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
-				{Type: storepb.LabelMatcher_EQ, Name: "ext2", Value: "value2"},
-			},
-			MinTime: timestamp.FromTime(start),
-			MaxTime: timestamp.FromTime(now),
-		}, srv)
-		testutil.Ok(t, err)
-		testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+	ctx, _ = context.WithTimeout(ctx, 30*time.Second)
 
-		for i, s := range srv.SeriesSet {
-			testutil.Equals(t, pbseries[i], s.Labels)
-			testutil.Equals(t, 3, len(s.Chunks))
+	err = runutil.Retry(100*time.Millisecond, ctx.Done(), func() error {
+		if store.numBlocks() < 6 {
+			return errors.New("not all blocks loaded")
 		}
-
-		srv = newStoreSeriesServer(ctx)
-		err = store.Series(&storepb.SeriesRequest{
-			Matchers: []storepb.LabelMatcher{
-				{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
-				{Type: storepb.LabelMatcher_EQ, Name: "ext2", Value: "wrong-value"},
-			},
-			MinTime: timestamp.FromTime(start),
-			MaxTime: timestamp.FromTime(now),
-		}, srv)
-		testutil.Ok(t, err)
-		testutil.Equals(t, 0, len(srv.SeriesSet))
+		return nil
 	})
-
+	testutil.Ok(t, err)
+
+	mint, maxt := store.TimeRange()
+	testutil.Equals(t, minTime, mint)
+	testutil.Equals(t, maxTime, maxt)
+
+	vals, err := store.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"1", "2"}, vals.Values)
+
+	pbseries := [][]storepb.Label{
+		{{Name: "a", Value: "1"}, {Name: "b", Value: "1"}, {Name: "ext1", Value: "value1"}},
+		{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+		{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
+		{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
+		{{Name: "a", Value: "2"}, {Name: "b", Value: "1"}, {Name: "ext1", Value: "value1"}},
+		{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+		{{Name: "a", Value: "2"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
+		{{Name: "a", Value: "2"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
+	}
+	srv := newStoreSeriesServer(ctx)
+
+	err = store.Series(&storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2"},
+		},
+		MinTime: timestamp.FromTime(start),
+		MaxTime: timestamp.FromTime(now),
+	}, srv)
+	testutil.Ok(t, err)
+	testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+
+	for i, s := range srv.SeriesSet {
+		testutil.Equals(t, pbseries[i], s.Labels)
+		testutil.Equals(t, 3, len(s.Chunks))
+	}
+
+	pbseries = [][]storepb.Label{
+		{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+		{{Name: "a", Value: "2"}, {Name: "b", Value: "2"}, {Name: "ext1", Value: "value1"}},
+	}
+	srv = newStoreSeriesServer(ctx)
+
+	err = store.Series(&storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
+		},
+		MinTime: timestamp.FromTime(start),
+		MaxTime: timestamp.FromTime(now),
+		Hints:   true,
+	}, srv)
+	testutil.Ok(t, err)
+	testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+
+	// Hints are upper bounds, as series that span multiple blocks are counted for each block.
+	testutil.Equals(t, 1, len(srv.Hints))
+	testutil.Assert(t, srv.Hints[0].Series >= int64(len(pbseries)), "hinted %d series, got %d", srv.Hints[0].Series, len(pbseries))
+	testutil.Assert(t, srv.Hints[0].Chunks >= int64(3*len(pbseries)), "hinted %d chunks, got %d", srv.Hints[0].Chunks, 3*len(pbseries))
+
+	for i, s := range srv.SeriesSet {
+		testutil.Equals(t, pbseries[i], s.Labels)
+		testutil.Equals(t, 3, len(s.Chunks))
+	}
+
+	// Only hints are sent when requested, without fetching any series. Series spanning multiple blocks are
+	// counted once.
+	srv = newStoreSeriesServer(ctx)
+
+	err = store.Series(&storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "b", Value: "2"},
+		},
+		MinTime:   timestamp.FromTime(start),
+		MaxTime:   timestamp.FromTime(now),
+		Hints:     true,
+		HintsOnly: true,
+	}, srv)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(srv.SeriesSet))
+	testutil.Equals(t, []storepb.SeriesHints{{Series: int64(len(pbseries))}}, srv.Hints)
+
+	// Matching by external label should work as well.
+	pbseries = [][]storepb.Label{
+		{{Name: "a", Value: "1"}, {Name: "c", Value: "1"}, {Name: "ext2", Value: "value2"}},
+		{{Name: "a", Value: "1"}, {Name: "c", Value: "2"}, {Name: "ext2", Value: "value2"}},
+	}
+	srv = newStoreSeriesServer(ctx)
+
+	err = store.Series(&storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+			{Type: storepb.LabelMatcher_EQ, Name: "ext2", Value: "value2"},
+		},
+		MinTime: timestamp.FromTime(start),
+		MaxTime: timestamp.FromTime(now),
+	}, srv)
+	testutil.Ok(t, err)
+	testutil.Equals(t, len(pbseries), len(srv.SeriesSet))
+
+	for i, s := range srv.SeriesSet {
+		testutil.Equals(t, pbseries[i], s.Labels)
+		testutil.Equals(t, 3, len(s.Chunks))
+	}
+
+	srv = newStoreSeriesServer(ctx)
+	err = store.Series(&storepb.SeriesRequest{
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "1"},
+			{Type: storepb.LabelMatcher_EQ, Name: "ext2", Value: "wrong-value"},
+		},
+		MinTime: timestamp.FromTime(start),
+		MaxTime: timestamp.FromTime(now),
+	}, srv)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(srv.SeriesSet))
 }
@@ -1,6 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -10,7 +19,12 @@ import (
 
 	"github.com/fortytw2/leaktest"
 	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore/inmem"
+	"github.com/improbable-eng/thanos/pkg/pool"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/chunks"
 	"github.com/prometheus/tsdb/labels"
 )
 
@@ -112,6 +126,100 @@ func TestBucketBlockSet_addGet(t *testing.T) {
 	}
 }
 
+func TestBucketBlockSet_getFor_offset(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	const (
+		hour = int64(time.Hour / time.Millisecond)
+		day  = 24 * hour
+	)
+	set := newBucketBlockSet(labels.Labels{})
+
+	type resBlock struct {
+		mint, maxt int64
+		window     int64
+	}
+	// Old data is only available in 1h resolution, the last days in 5m resolution
+	// and the most recent day only in raw resolution.
+	input := []resBlock{
+		{window: downsample.ResLevel2, mint: 0, maxt: 2 * day},
+		{window: downsample.ResLevel1, mint: 2 * day, maxt: 4 * day},
+		{window: downsample.ResLevel0, mint: 4 * day, maxt: 5 * day},
+	}
+	for _, in := range input {
+		var m block.Meta
+		m.Thanos.Downsample.Resolution = in.window
+		m.MinTime = in.mint
+		m.MaxTime = in.maxt
+		set.add(&bucketBlock{meta: &m})
+	}
+
+	// rate(x[1h] offset 1d) evaluated from start to end selects data from
+	// [start - 1d - 1h, end - 1d]. The resolution must be chosen for that shifted
+	// range and not for the evaluated one.
+	offsetRange := func(start, end int64) (int64, int64) {
+		return start - day - hour, end - day
+	}
+	cases := []struct {
+		start, end    int64
+		minResolution int64
+		res           []resBlock
+	}{
+		{
+			// Evaluated range is raw only, but the shifted one spans 5m and raw data.
+			start:         5 * day,
+			end:           6 * day,
+			minResolution: downsample.ResLevel1,
+			res: []resBlock{
+				{window: downsample.ResLevel1, mint: 2 * day, maxt: 4 * day},
+				{window: downsample.ResLevel0, mint: 4 * day, maxt: 5 * day},
+			},
+		}, {
+			// Shifted range spans all of 1h, 5m and raw data.
+			start:         day,
+			end:           6 * day,
+			minResolution: downsample.ResLevel2,
+			res: []resBlock{
+				{window: downsample.ResLevel2, mint: 0, maxt: 2 * day},
+				{window: downsample.ResLevel1, mint: 2 * day, maxt: 4 * day},
+				{window: downsample.ResLevel0, mint: 4 * day, maxt: 5 * day},
+			},
+		}, {
+			// Evaluated range starts after the 1h data ends, but the shifted one does not.
+			start:         2*day + hour/2,
+			end:           4 * day,
+			minResolution: downsample.ResLevel2,
+			res: []resBlock{
+				{window: downsample.ResLevel2, mint: 0, maxt: 2 * day},
+				{window: downsample.ResLevel1, mint: 2 * day, maxt: 4 * day},
+			},
+		}, {
+			// Raw resolution requested, only raw data is served.
+			start:         day,
+			end:           6 * day,
+			minResolution: downsample.ResLevel0,
+			res: []resBlock{
+				{window: downsample.ResLevel0, mint: 4 * day, maxt: 5 * day},
+			},
+		},
+	}
+	for i, c := range cases {
+		t.Logf("case %d", i)
+
+		var exp []*bucketBlock
+		for _, b := range c.res {
+			var m block.Meta
+			m.Thanos.Downsample.Resolution = b.window
+			m.MinTime = b.mint
+			m.MaxTime = b.maxt
+			exp = append(exp, &bucketBlock{meta: &m})
+		}
+		mint, maxt := offsetRange(c.start, c.end)
+		res := set.getFor(mint, maxt, c.minResolution)
+		testutil.Equals(t, exp, res)
+	}
+}
+
 func TestBucketBlockSet_remove(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -234,3 +342,468 @@ func TestPartitionRanges(t *testing.T) {
 		testutil.Equals(t, c.expected, res)
 	}
 }
+
+func TestChunksInRange(t *testing.T) {
+	// A long-lived series with 100 consecutive chunks of 120 seconds each.
+	var chks []chunks.Meta
+	for i := int64(0); i < 100; i++ {
+		chks = append(chks, chunks.Meta{Ref: uint64(i), MinTime: i * 120000, MaxTime: i*120000 + 119999})
+	}
+
+	refs := func(chks []chunks.Meta) (res []uint64) {
+		for _, c := range chks {
+			res = append(res, c.Ref)
+		}
+		return res
+	}
+
+	for _, c := range []struct {
+		mint, maxt int64
+		expected   []uint64
+	}{
+		// Narrow range within a single chunk.
+		{mint: 50*120000 + 10, maxt: 50*120000 + 20, expected: []uint64{50}},
+		// Range touching the boundaries of chunks.
+		{mint: 10*120000 + 119999, maxt: 12 * 120000, expected: []uint64{10, 11, 12}},
+		{mint: 0, maxt: 0, expected: []uint64{0}},
+		{mint: 99*120000 + 119999, maxt: math.MaxInt64, expected: []uint64{99}},
+		// Ranges outside of the series.
+		{mint: math.MinInt64, maxt: -1, expected: nil},
+		{mint: 100 * 120000, maxt: math.MaxInt64, expected: nil},
+	} {
+		testutil.Equals(t, c.expected, refs(chunksInRange(chks, c.mint, c.maxt)))
+	}
+	testutil.Equals(t, 100, len(chunksInRange(chks, math.MinInt64, math.MaxInt64)))
+}
+
+func TestPopulateChunk_gaugeCounterFallback(t *testing.T) {
+	var chks [5]chunkenc.Chunk
+	for _, at := range []downsample.AggrType{downsample.AggrCount, downsample.AggrSum, downsample.AggrMin, downsample.AggrMax} {
+		chks[at] = chunkenc.NewXORChunk()
+		app, err := chks[at].Appender()
+		testutil.Ok(t, err)
+		app.Append(100, float64(at))
+	}
+	gauge := downsample.EncodeAggrChunk(chks)
+
+	// Series downsampled as gauges have no counter aggregate, the max aggregate is served instead.
+	var out storepb.AggrChunk
+	testutil.Ok(t, populateChunk(&out, gauge, []storepb.Aggr{storepb.Aggr_COUNTER}))
+	testutil.Equals(t, chks[downsample.AggrMax].Bytes(), out.Counter.Data)
+
+	chks[downsample.AggrMax] = nil
+	testutil.NotOk(t, populateChunk(&out, downsample.EncodeAggrChunk(chks), []storepb.Aggr{storepb.Aggr_COUNTER}))
+}
+
+func TestBucketChunkReader_preloadGap(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Write a chunk file with three chunks, the first two closer to each other than the last two.
+	offsets := []uint32{8, 18008, 40008}
+	file := make([]byte, 40100)
+	for i, o := range offsets {
+		n := binary.PutUvarint(file[o:], 3)
+		copy(file[int(o)+n:], []byte{1, byte(i), byte(i), byte(i)})
+	}
+	bkt := inmem.NewBucket()
+	testutil.Ok(t, bkt.Upload(context.Background(), "chunks/000001", bytes.NewReader(file)))
+
+	chunkPool, err := pool.NewBytesPool(2e5, 50e6, 2, 1e9)
+	testutil.Ok(t, err)
+
+	for _, c := range []struct {
+		gap                         uint64
+		expReads, expCoalescedReads int
+	}{
+		{gap: 0, expReads: 3, expCoalescedReads: 0},
+		{gap: 3000, expReads: 2, expCoalescedReads: 1},
+		{gap: 512 * 1024, expReads: 1, expCoalescedReads: 1},
+	} {
+		b := &bucketBlock{
+			bucket:           bkt,
+			chunkObjs:        []string{"chunks/000001"},
+			chunkPool:        chunkPool,
+			chunkPrefetchGap: c.gap,
+		}
+		r := b.chunkReader(context.Background(), nil)
+		for _, o := range offsets {
+			testutil.Ok(t, r.addPreload(uint64(o)))
+		}
+		testutil.Ok(t, r.preload())
+
+		testutil.Equals(t, c.expReads, r.stats.chunksFetchCount)
+		testutil.Equals(t, c.expCoalescedReads, r.stats.chunksFetchCoalesced)
+		testutil.Equals(t, 3, r.stats.chunksFetched)
+
+		for i, o := range offsets {
+			chk, err := r.Chunk(uint64(o))
+			testutil.Ok(t, err)
+			testutil.Equals(t, []byte{byte(i), byte(i), byte(i)}, chk.Bytes())
+		}
+		testutil.Ok(t, r.Close())
+	}
+}
+
+func TestBucketBlock_indexLoadStrategies(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "test-index-load")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	series := []labels.Labels{
+		labels.FromStrings("a", "1", "b", "1"),
+		labels.FromStrings("a", "1", "b", "2"),
+		labels.FromStrings("a", "2", "b", "1"),
+		labels.FromStrings("a", "2", "c", "3"),
+	}
+	id, err := testutil.CreateBlock(tmpDir, series, 100, 0, 1000, labels.FromStrings("ext1", "1"), 0)
+	testutil.Ok(t, err)
+
+	bkt := inmem.NewBucket()
+	testutil.Ok(t, block.Upload(ctx, bkt, filepath.Join(tmpDir, id.String())))
+
+	chunkPool, err := pool.NewBytesPool(2e5, 50e6, 2, 1e9)
+	testutil.Ok(t, err)
+
+	cacheBlock, err := newBucketBlock(ctx, nil, bkt, bkt, id, filepath.Join(tmpDir, "cache"), nil, chunkPool, nil, 0, IndexCacheStrategy, false, false)
+	testutil.Ok(t, err)
+	headerBlock, err := newBucketBlock(ctx, nil, bkt, bkt, id, filepath.Join(tmpDir, "header"), nil, chunkPool, nil, 0, IndexHeaderStrategy, false, false)
+	testutil.Ok(t, err)
+
+	// Only the index header is kept on disk.
+	_, err = os.Stat(filepath.Join(tmpDir, "header", block.IndexHeaderFilename))
+	testutil.Ok(t, err)
+	_, err = os.Stat(filepath.Join(tmpDir, "header", block.IndexFilename))
+	testutil.Assert(t, os.IsNotExist(err), "index must not be downloaded")
+
+	testutil.Equals(t, IndexHeaderStrategy, headerBlock.indexLoadStrategy)
+
+	// Lookups served from the mmapped index header equal those of the index cache.
+	cached := cacheBlock.index.(*cachedIndex)
+	testutil.Equals(t, cached.Version(), headerBlock.index.Version())
+	for ref, sym := range cached.symbols {
+		s, err := headerBlock.index.LookupSymbol(ref)
+		testutil.Ok(t, err)
+		testutil.Equals(t, sym, s)
+	}
+	testutil.Equals(t, cached.LabelNames(), headerBlock.index.LabelNames())
+	for name, vals := range cached.lvals {
+		hvals, err := headerBlock.index.LabelValues(name)
+		testutil.Ok(t, err)
+		testutil.Equals(t, vals, hvals)
+	}
+	for l, rng := range cached.postings {
+		hrng, ok := headerBlock.index.PostingsRange(l.Name, l.Value)
+		testutil.Assert(t, ok, "postings of %s missing", l)
+		testutil.Equals(t, rng, hrng)
+	}
+	_, ok := headerBlock.index.PostingsRange("a", "3")
+	testutil.Assert(t, !ok, "postings of unknown label pair found")
+	testutil.Assert(t, headerBlock.indexFetchedBytes < cacheBlock.indexFetchedBytes, "index header fetched %d bytes, the full index is %d bytes", headerBlock.indexFetchedBytes, cacheBlock.indexFetchedBytes)
+
+	// An interrupted index header download is resumed.
+	header, err := ioutil.ReadFile(filepath.Join(tmpDir, "header", block.IndexHeaderFilename))
+	testutil.Ok(t, err)
+	fn := filepath.Join(tmpDir, "resumed-"+block.IndexHeaderFilename)
+	testutil.Ok(t, ioutil.WriteFile(fn+".tmp", header[:len(header)/2], 0666))
+
+	testutil.Ok(t, block.WriteIndexHeader(ctx, bkt, id, fn))
+	resumed, err := ioutil.ReadFile(fn)
+	testutil.Ok(t, err)
+	testutil.Equals(t, header, resumed)
+
+	testutil.Ok(t, cacheBlock.Close())
+	testutil.Ok(t, headerBlock.Close())
+}
+
+func TestBucketStore_advertiseMaxTime(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "bucketstore-advertise-max-time")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	bs, err := NewBucketStore(nil, nil, inmem.NewBucket(), dir, 100, 0, false, BucketStoreOptions{AdvertiseMaxTime: -24 * time.Hour})
+	testutil.Ok(t, err)
+
+	now := time.Now()
+	bs.now = func() time.Time { return now }
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	capMs := nowMs - 24*3600*1000
+
+	// The loaded block covers the last 48 hours, but only data older than 24 hours is advertised.
+	var m block.Meta
+	m.ULID = ulid.MustNew(1, nil)
+	m.MinTime = nowMs - 48*3600*1000
+	m.MaxTime = nowMs
+	bs.blocks[m.ULID] = &bucketBlock{meta: &m}
+
+	mint, maxt := bs.TimeRange()
+	testutil.Equals(t, m.MinTime, mint)
+	testutil.Equals(t, capMs, maxt)
+
+	historical := &storeClient{}
+	live := &storeClient{}
+	cls := []Client{
+		&testClient{StoreClient: historical, minTime: mint, maxTime: maxt},
+		&testClient{StoreClient: live, minTime: nowMs - 24*3600*1000, maxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{
+			TimeSplitOffset: 2 * time.Hour,
+		},
+	)
+
+	// No series are requested from the store for the window it does not advertise.
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: nowMs - 3600*1000, MaxTime: nowMs}, newStoreSeriesServer(context.Background())))
+	testutil.Assert(t, historical.SeriesReq == nil, "series requested from the store for the last 24h")
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: nowMs - 3600*1000, MaxTime: nowMs}, live.SeriesReq)
+
+	// Longer ranges are partitioned at the advertised max time.
+	historical.SeriesReq, live.SeriesReq = nil, nil
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: m.MinTime, MaxTime: nowMs}, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: m.MinTime, MaxTime: capMs}, historical.SeriesReq)
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: capMs + 1, MaxTime: nowMs}, live.SeriesReq)
+
+	// The store itself does not serve data of the window either.
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, bs.Series(&storepb.SeriesRequest{MinTime: nowMs - 3600*1000, MaxTime: nowMs}, srv))
+	testutil.Equals(t, 0, len(srv.SeriesSet))
+}
+
+func TestBucketStore_swapBlocks(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	dir, err := ioutil.TempDir("", "bucketstore-swap-blocks")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	bs, err := NewBucketStore(nil, nil, inmem.NewBucket(), dir, 100, 0, false, BucketStoreOptions{})
+	testutil.Ok(t, err)
+
+	newBlock := func(id ulid.ULID, lbls map[string]string) *bucketBlock {
+		var m block.Meta
+		m.ULID = id
+		m.Thanos.Labels = lbls
+		b := &bucketBlock{meta: &m, dir: filepath.Join(dir, id.String())}
+		testutil.Ok(t, os.MkdirAll(b.dir, 0777))
+		return b
+	}
+	b1 := newBlock(ulid.MustNew(1, nil), map[string]string{"a": "1"})
+	b2 := newBlock(ulid.MustNew(2, nil), map[string]string{"a": "2"})
+
+	bs.swapBlocks([]*bucketBlock{b1, b2}, nil)
+	testutil.Equals(t, 2, bs.numBlocks())
+	testutil.Equals(t, 2, len(bs.blockSets))
+
+	// Queries holding the previous set of blocks are not affected by a swap.
+	prevBlocks, prevSets := bs.blocks, bs.blockSets
+
+	b3 := newBlock(ulid.MustNew(3, nil), map[string]string{"a": "1"})
+	bs.swapBlocks([]*bucketBlock{b3}, map[ulid.ULID]struct{}{b1.meta.ULID: {}, b3.meta.ULID: {}})
+
+	testutil.Equals(t, map[ulid.ULID]*bucketBlock{b1.meta.ULID: b1, b3.meta.ULID: b3}, bs.blocks)
+	testutil.Equals(t, 1, len(bs.blockSets))
+	testutil.Equals(t, []*bucketBlock{b1, b3}, bs.blockSets[labels.FromMap(b1.meta.Thanos.Labels).Hash()].blocks[2])
+
+	testutil.Equals(t, map[ulid.ULID]*bucketBlock{b1.meta.ULID: b1, b2.meta.ULID: b2}, prevBlocks)
+	testutil.Equals(t, 2, len(prevSets))
+	testutil.Equals(t, []*bucketBlock{b1}, prevSets[labels.FromMap(b1.meta.Thanos.Labels).Hash()].blocks[2])
+
+	// Dropped blocks are removed from disk.
+	_, err = os.Stat(b2.dir)
+	testutil.Assert(t, os.IsNotExist(err), "dropped block not removed from disk")
+	_, err = os.Stat(b1.dir)
+	testutil.Ok(t, err)
+}
+
+func TestCoveredBlocks(t *testing.T) {
+	newMeta := func(id uint64, res int64, lbls map[string]string, sources ...uint64) *block.Meta {
+		var m block.Meta
+		m.ULID = ulid.MustNew(id, nil)
+		m.Thanos.Labels = lbls
+		m.Thanos.Downsample.Resolution = res
+		for _, src := range sources {
+			m.Compaction.Sources = append(m.Compaction.Sources, ulid.MustNew(src, nil))
+		}
+		return &m
+	}
+	var (
+		src1 = newMeta(1, 0, map[string]string{"a": "1"}, 1)
+		src2 = newMeta(2, 0, map[string]string{"a": "1"}, 2)
+		// Compacted from both sources, which the compactor keeps until they are garbage collected.
+		compacted = newMeta(3, 0, map[string]string{"a": "1"}, 1, 2)
+		// Downsampled and other blocks with the same sources do not overlap with the compacted block.
+		downsampled = newMeta(4, 300000, map[string]string{"a": "1"}, 1, 2)
+		other       = newMeta(5, 0, map[string]string{"a": "2"}, 1)
+		// Of blocks with the same sources, only the newest one is queried.
+		duplicate = newMeta(6, 0, map[string]string{"a": "1"}, 1, 2)
+	)
+	testutil.Equals(t, map[ulid.ULID]struct{}{}, coveredBlocks([]*block.Meta{src1, src2, other}))
+	testutil.Equals(t, map[ulid.ULID]struct{}{
+		src1.ULID: {},
+		src2.ULID: {},
+	}, coveredBlocks([]*block.Meta{src1, src2, compacted, downsampled, other}))
+	testutil.Equals(t, map[ulid.ULID]struct{}{
+		src1.ULID:      {},
+		src2.ULID:      {},
+		compacted.ULID: {},
+	}, coveredBlocks([]*block.Meta{duplicate, src1, src2, compacted, downsampled, other}))
+}
+
+func TestMaxOverlappingSeries(t *testing.T) {
+	newBlock := func(mint, maxt int64) *bucketBlock {
+		var m block.Meta
+		m.MinTime, m.MaxTime = mint, maxt
+		return &bucketBlock{meta: &m}
+	}
+	testutil.Equals(t, 0, maxOverlappingSeries(nil))
+
+	// Series spanning consecutive blocks are counted once.
+	testutil.Equals(t, 30, maxOverlappingSeries(map[*bucketBlock]int{
+		newBlock(0, 100):   10,
+		newBlock(100, 200): 30,
+		newBlock(200, 300): 20,
+	}))
+	// Overlapping blocks may hold different series.
+	testutil.Equals(t, 50, maxOverlappingSeries(map[*bucketBlock]int{
+		newBlock(0, 100):   10,
+		newBlock(100, 200): 30,
+		newBlock(150, 250): 20,
+	}))
+}
+
+func TestBlockChunksEstimate(t *testing.T) {
+	var m block.Meta
+	m.MinTime, m.MaxTime = 0, 100
+	m.Stats.NumSeries, m.Stats.NumChunks = 10, 40
+
+	testutil.Equals(t, int64(20), blockChunksEstimate(&m, 5, 0, 100))
+	testutil.Equals(t, int64(10), blockChunksEstimate(&m, 5, 50, 1000))
+	testutil.Equals(t, int64(0), blockChunksEstimate(&m, 5, 100, 200))
+	testutil.Equals(t, int64(0), blockChunksEstimate(&m, 0, 0, 100))
+
+	// Blocks without stats give no estimate.
+	m.Stats.NumSeries = 0
+	testutil.Equals(t, int64(0), blockChunksEstimate(&m, 5, 0, 100))
+}
+
+func TestBucketBlock_lazyIndex(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "test-lazy-index")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	series := []labels.Labels{
+		labels.FromStrings("a", "1", "b", "1"),
+		labels.FromStrings("a", "2", "b", "1"),
+	}
+	id, err := testutil.CreateBlock(tmpDir, series, 100, 0, 1000, labels.FromStrings("ext1", "1"), 0)
+	testutil.Ok(t, err)
+
+	bkt := inmem.NewBucket()
+	testutil.Ok(t, block.Upload(ctx, bkt, filepath.Join(tmpDir, id.String())))
+
+	chunkPool, err := pool.NewBytesPool(2e5, 50e6, 2, 1e9)
+	testutil.Ok(t, err)
+
+	b, err := newBucketBlock(ctx, nil, bkt, bkt, id, filepath.Join(tmpDir, "lazy"), nil, chunkPool, nil, 0, IndexHeaderStrategy, true, true)
+	testutil.Ok(t, err)
+
+	// Nothing of the index is fetched before the first use.
+	testutil.Assert(t, !b.indexLoaded, "index must not be loaded")
+	_, err = os.Stat(filepath.Join(tmpDir, "lazy", block.IndexHeaderFilename))
+	testutil.Assert(t, os.IsNotExist(err), "index header must not be fetched")
+	// Without a summary the block is never skipped.
+	testutil.Assert(t, b.mayMatch([]labels.Matcher{labels.NewEqualMatcher("a", "3")}), "block without summary skipped")
+
+	loaded, err := b.acquireIndex(ctx)
+	testutil.Ok(t, err)
+	testutil.Assert(t, loaded, "index must be loaded on first use")
+	vals, err := b.index.LabelValues("a")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"1", "2"}, vals)
+
+	// Indexes in use are never unloaded.
+	now := time.Now().Add(time.Hour)
+	testutil.Assert(t, !b.unloadIndexIfIdle(now, time.Minute), "index in use must not be unloaded")
+	b.releaseIndex()
+
+	testutil.Assert(t, !b.unloadIndexIfIdle(time.Now(), time.Minute), "recently used index must not be unloaded")
+	testutil.Assert(t, b.unloadIndexIfIdle(now, time.Minute), "idle index must be unloaded")
+	testutil.Assert(t, b.index == nil, "unloaded index must not be held in memory")
+
+	// The summary is kept and still skips the block for series it cannot hold.
+	testutil.Assert(t, b.mayMatch([]labels.Matcher{labels.NewEqualMatcher("a", "1")}), "block with matching series skipped")
+	testutil.Assert(t, !b.mayMatch([]labels.Matcher{labels.NewEqualMatcher("a", "3")}), "block without matching series not skipped")
+
+	// The index header is kept on disk and reloaded from it.
+	loaded, err = b.acquireIndex(ctx)
+	testutil.Ok(t, err)
+	testutil.Assert(t, loaded, "unloaded index must be loaded again")
+	testutil.Equals(t, int64(0), b.indexFetchedBytes)
+	vals, err = b.index.LabelValues("a")
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"1", "2"}, vals)
+	b.releaseIndex()
+
+	testutil.Ok(t, b.Close())
+}
+
+func BenchmarkBucketStore_Series(b *testing.B) {
+	for _, bufferPooling := range []bool{false, true} {
+		b.Run(fmt.Sprintf("bufferPooling=%v", bufferPooling), func(b *testing.B) {
+			benchmarkBucketStoreSeries(b, bufferPooling)
+		})
+	}
+}
+
+func benchmarkBucketStoreSeries(b *testing.B, bufferPooling bool) {
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "bench-bucket-store-series")
+	testutil.Ok(b, err)
+	defer os.RemoveAll(tmpDir)
+
+	series := make([]labels.Labels, 0, 10000)
+	for i := 0; i < cap(series); i++ {
+		series = append(series, labels.FromStrings("a", strconv.Itoa(i%10), "b", strconv.Itoa(i)))
+	}
+	id, err := testutil.CreateBlock(tmpDir, series, 10, 0, 1000, labels.FromStrings("ext1", "1"), 0)
+	testutil.Ok(b, err)
+
+	bkt := inmem.NewBucket()
+	testutil.Ok(b, block.Upload(ctx, bkt, filepath.Join(tmpDir, id.String())))
+
+	// Keep the index cache small so that every query reads the index ranges from the bucket.
+	s, err := NewBucketStore(nil, nil, bkt, filepath.Join(tmpDir, "store"), 100, 0, false, BucketStoreOptions{
+		IndexLoadStrategy: IndexHeaderStrategy,
+		BufferPooling:     bufferPooling,
+	})
+	testutil.Ok(b, err)
+	defer s.Close()
+	testutil.Ok(b, s.SyncBlocks(ctx))
+
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  1000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "a", Value: "1|2|3"}},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		srv := newStoreSeriesServer(ctx)
+		testutil.Ok(b, s.Series(req, srv))
+		testutil.Equals(b, 3000, len(srv.SeriesSet))
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DrainStore wraps a store to drain it before a shutdown. Once draining, new requests are rejected with
+// Unavailable, including the Info requests queriers check the health of stores with, so that queriers stop
+// sending requests to it. Requests in flight are not affected.
+type DrainStore struct {
+	storepb.StoreServer
+
+	mtx      sync.Mutex
+	draining bool
+	inflight int
+
+	inflightGauge prometheus.GaugeFunc
+	drainingGauge prometheus.GaugeFunc
+}
+
+// NewDrainStore returns a DrainStore serving requests from the given store until it is drained.
+func NewDrainStore(reg prometheus.Registerer, s storepb.StoreServer) *DrainStore {
+	d := &DrainStore{StoreServer: s}
+
+	d.inflightGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_store_inflight_requests",
+		Help: "Number of Store API requests currently being processed.",
+	}, func() float64 {
+		_, inflight := d.Status()
+		return float64(inflight)
+	})
+	d.drainingGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_store_draining",
+		Help: "1 if the store is draining and rejects new requests, 0 otherwise.",
+	}, func() float64 {
+		if draining, _ := d.Status(); draining {
+			return 1
+		}
+		return 0
+	})
+
+	if reg != nil {
+		reg.MustRegister(d.inflightGauge, d.drainingGauge)
+	}
+	return d
+}
+
+// Drain starts rejecting new requests. It returns the number of requests still in flight.
+func (d *DrainStore) Drain() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.draining = true
+	return d.inflight
+}
+
+// Status returns whether the store is draining and the number of requests in flight.
+func (d *DrainStore) Status() (draining bool, inflight int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.draining, d.inflight
+}
+
+// acquire accounts for a new request, unless the store is draining.
+func (d *DrainStore) acquire() error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.draining {
+		return status.Error(codes.Unavailable, "store is draining")
+	}
+	d.inflight++
+	return nil
+}
+
+func (d *DrainStore) release() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.inflight--
+}
+
+// Info returns the information of the wrapped store.
+func (d *DrainStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	return d.StoreServer.Info(ctx, r)
+}
+
+// Series streams the series of the wrapped store. The request is in flight until the stream is finished.
+func (d *DrainStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
+
+	return d.StoreServer.Series(r, srv)
+}
+
+// LabelNames returns the label names of the wrapped store.
+func (d *DrainStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	return d.StoreServer.LabelNames(ctx, r)
+}
+
+// LabelValues returns the label values of the wrapped store.
+func (d *DrainStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	return d.StoreServer.LabelValues(ctx, r)
+}
+
+// MetricMetadata returns the metric metadata of the wrapped store.
+func (d *DrainStore) MetricMetadata(ctx context.Context, r *storepb.MetricMetadataRequest) (*storepb.MetricMetadataResponse, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	return d.StoreServer.MetricMetadata(ctx, r)
+}
+
+// Exemplars returns the exemplars of the wrapped store.
+func (d *DrainStore) Exemplars(ctx context.Context, r *storepb.ExemplarsRequest) (*storepb.ExemplarsResponse, error) {
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	return d.StoreServer.Exemplars(ctx, r)
+}
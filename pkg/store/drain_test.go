@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockingStoreServer blocks series requests until unblocked.
+type blockingStoreServer struct {
+	storepb.StoreServer
+
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockingStoreServer) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{}, nil
+}
+
+func (s *blockingStoreServer) Series(*storepb.SeriesRequest, storepb.Store_SeriesServer) error {
+	close(s.started)
+	<-s.unblock
+	return nil
+}
+
+func TestDrainStore(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	st := &blockingStoreServer{started: make(chan struct{}), unblock: make(chan struct{})}
+	d := NewDrainStore(nil, st)
+
+	_, err := d.Info(context.Background(), &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+
+	errc := make(chan error)
+	go func() {
+		errc <- d.Series(&storepb.SeriesRequest{}, newStoreSeriesServer(context.Background()))
+	}()
+	<-st.started
+
+	draining, inflight := d.Status()
+	testutil.Assert(t, !draining, "store draining before drained")
+	testutil.Equals(t, 1, inflight)
+	testutil.Equals(t, 1, d.Drain())
+
+	// New requests are rejected, including health checks.
+	_, err = d.Info(context.Background(), &storepb.InfoRequest{})
+	st1, ok := status.FromError(err)
+	testutil.Assert(t, ok, "expected gRPC status error, got %v", err)
+	testutil.Equals(t, codes.Unavailable, st1.Code())
+
+	err = d.Series(&storepb.SeriesRequest{}, newStoreSeriesServer(context.Background()))
+	st2, ok := status.FromError(err)
+	testutil.Assert(t, ok, "expected gRPC status error, got %v", err)
+	testutil.Equals(t, codes.Unavailable, st2.Code())
+
+	// Requests in flight finish.
+	close(st.unblock)
+	testutil.Ok(t, <-errc)
+
+	draining, inflight = d.Status()
+	testutil.Assert(t, draining, "store not draining")
+	testutil.Equals(t, 0, inflight)
+}
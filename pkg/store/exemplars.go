@@ -0,0 +1,97 @@
+package store
+
+import (
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/tsdb/labels"
+)
+
+// exemplarSelectors parses the query of an exemplars request and returns the label matchers
+// of all its selectors. Matchers on the given label names are removed from the returned query,
+// but not from the returned selectors, so results can be checked against them afterwards.
+func exemplarSelectors(query string, strip labels.Labels) (string, [][]*promlabels.Matcher, error) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return "", nil, err
+	}
+	var selectors [][]*promlabels.Matcher
+
+	stripMatchers := func(ms []*promlabels.Matcher) []*promlabels.Matcher {
+		selectors = append(selectors, ms)
+
+		res := make([]*promlabels.Matcher, 0, len(ms))
+		for _, m := range ms {
+			if strip.Get(m.Name) == "" {
+				res = append(res, m)
+			}
+		}
+		// A selector needs at least one matcher. Prometheus has none of the stripped labels,
+		// so keeping them correctly selects nothing.
+		if len(res) == 0 {
+			return ms
+		}
+		return res
+	}
+	promql.Inspect(expr, func(node promql.Node) bool {
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			n.LabelMatchers = stripMatchers(n.LabelMatchers)
+		case *promql.MatrixSelector:
+			n.LabelMatchers = stripMatchers(n.LabelMatchers)
+		}
+		return true
+	})
+	return expr.String(), selectors, nil
+}
+
+// selectorsMatch returns true if the labels match all matchers of at least one selector.
+func selectorsMatch(selectors [][]*promlabels.Matcher, lset []storepb.Label) bool {
+	for _, ms := range selectors {
+		if matchersMatch(ms, lset) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchersMatch(ms []*promlabels.Matcher, lset []storepb.Label) bool {
+	for _, m := range ms {
+		var v string
+		for _, l := range lset {
+			if l.Name == m.Name {
+				v = l.Value
+				break
+			}
+		}
+		if !m.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// exemplarStoreMatches returns true if the given store may hold exemplars for the given
+// time range and at least one of the selectors.
+func exemplarStoreMatches(s Client, mint, maxt int64, selectors [][]*promlabels.Matcher) bool {
+	storeMinTime, storeMaxTime := s.TimeRange()
+	if mint > storeMaxTime || maxt < storeMinTime {
+		return false
+	}
+	lset := s.Labels()
+
+	for _, ms := range selectors {
+		match := true
+		for _, m := range ms {
+			for _, l := range lset {
+				if l.Name == m.Name && !m.Matches(l.Value) {
+					match = false
+				}
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/runutil"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FederateClient is a Client for a Prometheus server without a sidecar that is read through its /federate
+// endpoint. Federation only returns the latest sample of each series, so the client only advertises a short
+// window of recent data and is meant to complement stores with historical data, e.g. store gateways.
+type FederateClient struct {
+	storepb.StoreClient
+	base   *url.URL
+	window time.Duration
+
+	mtx    sync.RWMutex
+	labels labels.Labels
+}
+
+// NewFederateClient returns a new FederateClient for the Prometheus server at the given URL that advertises
+// data of the given window before now. Until its external labels are updated, it advertises none.
+func NewFederateClient(logger log.Logger, client *http.Client, baseURL *url.URL, window time.Duration) (*FederateClient, error) {
+	c := &FederateClient{
+		base:   baseURL,
+		window: window,
+	}
+	p, err := NewPrometheusStore(logger, client, baseURL, c.externalLabels, c.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	c.StoreClient = storepb.ServerAsClient(federateStore{p})
+	return c, nil
+}
+
+// Update sets the external labels of the Prometheus server.
+func (c *FederateClient) Update(lset labels.Labels) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.labels = lset
+}
+
+func (c *FederateClient) externalLabels() labels.Labels {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.labels
+}
+
+// Labels returns the external labels of the Prometheus server.
+func (c *FederateClient) Labels() []storepb.Label {
+	lset := c.externalLabels()
+
+	res := make([]storepb.Label, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+// TimeRange returns the window of recent data that is read through federation.
+func (c *FederateClient) TimeRange() (mint int64, maxt int64) {
+	return time.Now().Add(-c.window).UnixNano() / int64(time.Millisecond), math.MaxInt64
+}
+
+func (c *FederateClient) String() string {
+	return c.base.String()
+}
+
+// federateStore reads series of a PrometheusStore through the /federate endpoint instead of remote read.
+type federateStore struct {
+	*PrometheusStore
+}
+
+// Series returns the latest sample of all series matching the request, if it is within the requested time range.
+func (p federateStore) Series(r *storepb.SeriesRequest, s storepb.Store_SeriesServer) error {
+	ext := p.externalLabels()
+
+	match, newMatchers, err := labelsMatches(ext, r.Matchers)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !match {
+		return nil
+	}
+	sel, err := federateSelector(newMatchers)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	mfs, err := p.federate(s.Context(), sel)
+	if err != nil {
+		return errors.Wrap(err, "federate from Prometheus")
+	}
+
+	span, _ := tracing.StartSpan(s.Context(), "transform_and_respond")
+	defer span.Finish()
+
+	var series []storepb.Series
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			v, ok := federateValue(mf.GetType(), m)
+			if !ok {
+				continue
+			}
+			t := m.GetTimestampMs()
+			if t < r.MinTime || t > r.MaxTime {
+				continue
+			}
+			c := chunkenc.NewXORChunk()
+			a, err := c.Appender()
+			if err != nil {
+				return status.Error(codes.Unknown, err.Error())
+			}
+			a.Append(t, v)
+
+			series = append(series, storepb.Series{
+				Labels: federateLabels(mf.GetName(), m, ext),
+				Chunks: []storepb.AggrChunk{{
+					MinTime: t,
+					MaxTime: t,
+					Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+				}},
+			})
+		}
+	}
+	// Series of different metric families are returned in order of their labels like by all other stores.
+	sort.Slice(series, func(i, j int) bool {
+		return storepb.CompareLabels(series[i].Labels, series[j].Labels) < 0
+	})
+	for i := range series {
+		if err := s.Send(storepb.NewSeriesResponse(&series[i])); err != nil {
+			return err
+		}
+	}
+	// Federation only returns raw data.
+	reportSourceResolutions(s.Context(), 0)
+	return nil
+}
+
+func (p federateStore) federate(ctx context.Context, selector string) (map[string]*dto.MetricFamily, error) {
+	span, ctx := tracing.StartSpan(ctx, "federate_prometheus")
+	defer span.Finish()
+
+	u := *p.base
+	u.Path = "/federate"
+	u.RawQuery = url.Values{"match[]": []string{selector}}.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create request")
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "send request")
+	}
+	defer runutil.LogOnErr(p.logger, resp.Body, "federate body")
+
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("request failed with code %s", resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse federated metrics")
+	}
+	return mfs, nil
+}
+
+// federateSelector returns a PromQL series selector for the given matchers.
+func federateSelector(ms []storepb.LabelMatcher) (string, error) {
+	if len(ms) == 0 {
+		return "", errors.New("federation requires at least one matcher besides external labels")
+	}
+	sel := make([]string, 0, len(ms))
+	for _, m := range ms {
+		var op string
+		switch m.Type {
+		case storepb.LabelMatcher_EQ:
+			op = "="
+		case storepb.LabelMatcher_NEQ:
+			op = "!="
+		case storepb.LabelMatcher_RE:
+			op = "=~"
+		case storepb.LabelMatcher_NRE:
+			op = "!~"
+		default:
+			return "", errors.New("unrecognized matcher type")
+		}
+		sel = append(sel, m.Name+op+strconv.Quote(m.Value))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(sel, ",")), nil
+}
+
+// federateValue returns the value of a federated metric. Federation returns all series as untyped,
+// but counters and gauges are accepted as well. Other types are not returned by federation.
+func federateValue(typ dto.MetricType, m *dto.Metric) (float64, bool) {
+	switch typ {
+	case dto.MetricType_UNTYPED:
+		return m.GetUntyped().GetValue(), true
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue(), true
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue(), true
+	}
+	return 0, false
+}
+
+// federateLabels returns the labels of a federated series with the given external labels. Prometheus attaches
+// its external labels to federated series already, they are overwritten to match the advertised ones.
+func federateLabels(name string, m *dto.Metric, extend labels.Labels) []storepb.Label {
+	lset := make([]storepb.Label, 0, len(m.Label)+len(extend)+1)
+	lset = append(lset, storepb.Label{Name: "__name__", Value: name})
+
+	for _, l := range m.Label {
+		if l.GetName() == "__name__" || extend.Get(l.GetName()) != "" {
+			continue
+		}
+		lset = append(lset, storepb.Label{Name: l.GetName(), Value: l.GetValue()})
+	}
+	return extendLset(lset, extend)
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestFederateClient_e2e(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	p, err := testutil.NewPrometheus()
+	testutil.Ok(t, err)
+
+	baseT := timestamp.FromTime(time.Now()) / 1000 * 1000
+
+	a := p.Appender()
+	a.Add(labels.FromStrings("__name__", "up", "a", "b"), baseT-200, 1)
+	a.Add(labels.FromStrings("__name__", "up", "a", "b"), baseT-100, 2)
+	a.Add(labels.FromStrings("__name__", "up", "a", "c"), baseT-100, 3)
+	a.Add(labels.FromStrings("__name__", "other", "a", "b"), baseT-100, 4)
+	testutil.Ok(t, a.Commit())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testutil.Ok(t, p.Start())
+	defer p.Stop()
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", p.Addr()))
+	testutil.Ok(t, err)
+
+	c, err := NewFederateClient(nil, nil, u, 5*time.Minute)
+	testutil.Ok(t, err)
+	c.Update(labels.FromStrings("region", "eu-west"))
+
+	info, err := c.Info(ctx, &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.Label{{Name: "region", Value: "eu-west"}}, info.Labels)
+	testutil.Assert(t, info.MinTime <= baseT-5*60*1000, "expected window of 5m, got min time %d", info.MinTime)
+
+	sc, err := c.Series(ctx, &storepb.SeriesRequest{
+		MinTime: baseT - 1000,
+		MaxTime: baseT + 1000,
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+			{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "eu-west"},
+		},
+	})
+	testutil.Ok(t, err)
+
+	var series []storepb.Series
+	for {
+		r, err := sc.Recv()
+		if err == io.EOF {
+			break
+		}
+		testutil.Ok(t, err)
+		series = append(series, *r.GetSeries())
+	}
+	testutil.Equals(t, 2, len(series))
+	testutil.Equals(t, []storepb.Label{
+		{Name: "__name__", Value: "up"},
+		{Name: "a", Value: "b"},
+		{Name: "region", Value: "eu-west"},
+	}, series[0].Labels)
+	testutil.Equals(t, []storepb.Label{
+		{Name: "__name__", Value: "up"},
+		{Name: "a", Value: "c"},
+		{Name: "region", Value: "eu-west"},
+	}, series[1].Labels)
+
+	// Only the latest sample is federated.
+	testutil.Equals(t, 1, len(series[0].Chunks))
+	chk, err := chunkenc.FromData(chunkenc.EncXOR, series[0].Chunks[0].Raw.Data)
+	testutil.Ok(t, err)
+	it := chk.Iterator()
+	testutil.Assert(t, it.Next(), "expected a sample")
+	ts, v := it.At()
+	testutil.Equals(t, baseT-100, ts)
+	testutil.Equals(t, 2.0, v)
+	testutil.Assert(t, !it.Next(), "expected a single sample")
+
+	// Series outside of the requested time range are skipped.
+	sc, err = c.Series(ctx, &storepb.SeriesRequest{
+		MinTime: baseT,
+		MaxTime: baseT + 1000,
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		},
+	})
+	testutil.Ok(t, err)
+	_, err = sc.Recv()
+	testutil.Equals(t, io.EOF, err)
+}
+
+func TestFederateSelector(t *testing.T) {
+	sel, err := federateSelector([]storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_NEQ, Name: "a", Value: "b"},
+		{Type: storepb.LabelMatcher_RE, Name: "job", Value: `thanos-.*`},
+		{Type: storepb.LabelMatcher_NRE, Name: "path", Value: `"/\d+"`},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, `{__name__="up",a!="b",job=~"thanos-.*",path!~"\"/\\d+\""}`, sel)
+
+	_, err = federateSelector(nil)
+	testutil.NotOk(t, err)
+}
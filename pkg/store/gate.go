@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// chunkGate limits the number of chunk ranges a series request reads from the bucket concurrently, so that a single
+// wide request does not read all ranges across all its blocks at once. Loads waiting for a slot still hold their
+// goroutine. The methods of a nil gate do not limit anything.
+type chunkGate struct {
+	slots chan struct{}
+	wait  prometheus.Histogram
+}
+
+// newChunkGate returns a gate admitting up to concurrency loads at once. It returns nil if concurrency is not positive.
+// The time loads wait for a slot is observed in wait.
+func newChunkGate(concurrency int, wait prometheus.Histogram) *chunkGate {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &chunkGate{slots: make(chan struct{}, concurrency), wait: wait}
+}
+
+// start blocks until a slot is free or the context is canceled. Every successful call must be followed by done.
+func (g *chunkGate) start(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	begin := time.Now()
+
+	select {
+	case g.slots <- struct{}{}:
+		g.wait.Observe(time.Since(begin).Seconds())
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// done releases the slot of a load.
+func (g *chunkGate) done() {
+	if g == nil {
+		return
+	}
+	<-g.slots
+}
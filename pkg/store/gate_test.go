@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestChunkGate(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// A nil gate does not limit anything.
+	var nilGate *chunkGate
+	testutil.Equals(t, nilGate, newChunkGate(0, nil))
+	testutil.Ok(t, nilGate.start(context.Background()))
+	nilGate.done()
+
+	g := newChunkGate(2, prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test"}))
+	testutil.Ok(t, g.start(context.Background()))
+	testutil.Ok(t, g.start(context.Background()))
+
+	// The third load waits until a slot is released.
+	started := make(chan error)
+	go func() {
+		started <- g.start(context.Background())
+	}()
+	select {
+	case <-started:
+		t.Fatal("load started although the gate is exhausted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	g.done()
+	testutil.Ok(t, <-started)
+
+	// Waiting loads are abandoned once their context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testutil.Equals(t, context.Canceled, g.start(ctx))
+}
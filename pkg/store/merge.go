@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+)
+
+// seriesBufferSize is the number of series buffered for each store stream and each merge group, which
+// bounds the memory of a request regardless of the size of the responses.
+const seriesBufferSize = 10
+
+// mergeSeriesSets merges the series sets like storepb.MergeSeriesSets. With a concurrency above one, the sets
+// are split into that many groups of consecutive sets. Each group is merged in its own goroutine and the merged
+// groups are merged in order, so the comparison of the labels of many stores is spread over multiple CPUs while
+// series and their chunks are returned in the same order as with a single goroutine.
+func mergeSeriesSets(ctx context.Context, concurrency int, all ...storepb.SeriesSet) storepb.SeriesSet {
+	if concurrency <= 1 || len(all) <= 2 {
+		return storepb.MergeSeriesSets(all...)
+	}
+	if concurrency > len(all)/2 {
+		// Merging a single set in a goroutine only adds overhead.
+		concurrency = len(all) / 2
+	}
+	groups := make([]storepb.SeriesSet, 0, concurrency)
+	for i := 0; i < concurrency; i++ {
+		from, to := i*len(all)/concurrency, (i+1)*len(all)/concurrency
+		groups = append(groups, startAsyncSeriesSet(ctx, storepb.MergeSeriesSets(all[from:to]...), seriesBufferSize))
+	}
+	return storepb.MergeSeriesSets(groups...)
+}
+
+// asyncSeriesSet iterates over a series set in a separate goroutine and buffers a limited number of its series.
+type asyncSeriesSet struct {
+	recvCh chan *storepb.Series
+
+	mtx sync.Mutex
+	err error
+
+	currSeries *storepb.Series
+}
+
+// startAsyncSeriesSet starts iterating over the given set. The iteration stops early if the context is canceled.
+func startAsyncSeriesSet(ctx context.Context, set storepb.SeriesSet, bufferSize int) *asyncSeriesSet {
+	s := &asyncSeriesSet{recvCh: make(chan *storepb.Series, bufferSize)}
+
+	go func() {
+		defer close(s.recvCh)
+
+		for set.Next() {
+			var series storepb.Series
+			series.Labels, series.Chunks = set.At()
+
+			select {
+			case s.recvCh <- &series:
+			case <-ctx.Done():
+				s.setErr(ctx.Err())
+				return
+			}
+		}
+		s.setErr(set.Err())
+	}()
+	return s
+}
+
+// Next blocks until the next series was merged or the set is exhausted.
+func (s *asyncSeriesSet) Next() (ok bool) {
+	s.currSeries, ok = <-s.recvCh
+	return ok
+}
+
+func (s *asyncSeriesSet) At() ([]storepb.Label, []storepb.AggrChunk) {
+	if s.currSeries == nil {
+		return nil, nil
+	}
+	return s.currSeries.Labels, s.currSeries.Chunks
+}
+
+func (s *asyncSeriesSet) setErr(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.err = err
+}
+
+// Err returns the error of the iterated set once it is exhausted.
+func (s *asyncSeriesSet) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err
+}
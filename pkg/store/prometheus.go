@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"path"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -72,9 +75,10 @@ func (p *PrometheusStore) Info(ctx context.Context, r *storepb.InfoRequest) (*st
 	mint, maxt := p.timestamps()
 
 	res := &storepb.InfoResponse{
-		MinTime: mint,
-		MaxTime: maxt,
-		Labels:  make([]storepb.Label, 0, len(lset)),
+		MinTime:     mint,
+		MaxTime:     maxt,
+		Labels:      make([]storepb.Label, 0, len(lset)),
+		CurrentTime: time.Now().UnixNano() / int64(time.Millisecond),
 	}
 	for _, l := range lset {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -99,6 +103,10 @@ func (p *PrometheusStore) putBuffer(b []byte) {
 
 // Series returns all series for a requested time range and label matcher.
 func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_SeriesServer) error {
+	// The remote read API cannot estimate series without reading all their samples.
+	if r.HintsOnly {
+		return nil
+	}
 	ext := p.externalLabels()
 
 	match, newMatchers, err := labelsMatches(ext, r.Matchers)
@@ -172,6 +180,8 @@ func (p *PrometheusStore) Series(r *storepb.SeriesRequest, s storepb.Store_Serie
 			return err
 		}
 	}
+	// Prometheus only has raw data.
+	reportSourceResolutions(s.Context(), 0)
 	return nil
 }
 
@@ -342,3 +352,164 @@ func (p *PrometheusStore) LabelValues(ctx context.Context, r *storepb.LabelValue
 
 	return &storepb.LabelValuesResponse{Values: m.Data}, nil
 }
+
+// MetricMetadata returns the metadata of all metrics scraped by Prometheus. Prometheus versions
+// without the metadata API are reported as Unimplemented.
+func (p *PrometheusStore) MetricMetadata(ctx context.Context, r *storepb.MetricMetadataRequest) (
+	*storepb.MetricMetadataResponse, error,
+) {
+	u := *p.base
+	u.Path = path.Join(u.Path, "/api/v1/metadata")
+	if r.Metric != "" {
+		u.RawQuery = url.Values{"metric": []string{r.Metric}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	span, ctx := tracing.StartSpan(ctx, "/prom_metadata HTTP[client]")
+	defer span.Finish()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, status.Error(codes.Unimplemented, "metadata API is not supported by this Prometheus version")
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, status.Errorf(codes.Unknown, "request metadata: unexpected status code %d", resp.StatusCode)
+	}
+
+	var m struct {
+		Data map[string][]struct {
+			Type string `json:"type"`
+			Help string `json:"help"`
+			Unit string `json:"unit"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	res := &storepb.MetricMetadataResponse{}
+	for metric, mds := range m.Data {
+		for _, md := range mds {
+			res.Metadata = append(res.Metadata, storepb.MetricMetadata{
+				Metric: metric,
+				Type:   md.Type,
+				Help:   md.Help,
+				Unit:   md.Unit,
+			})
+		}
+	}
+	storepb.SortMetricMetadata(res.Metadata)
+
+	return res, nil
+}
+
+// Exemplars returns the exemplars of the series selected by the query. Matchers on external labels
+// are not forwarded to Prometheus, as it does not know them, but are checked against the results.
+// Prometheus versions without the exemplars API are reported as Unimplemented.
+func (p *PrometheusStore) Exemplars(ctx context.Context, r *storepb.ExemplarsRequest) (
+	*storepb.ExemplarsResponse, error,
+) {
+	ext := p.externalLabels()
+
+	query, selectors, err := exemplarSelectors(r.Query, ext)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	u := *p.base
+	u.Path = path.Join(u.Path, "/api/v1/query_exemplars")
+	u.RawQuery = url.Values{
+		"query": []string{query},
+		"start": []string{formatTimestamp(r.MinTime)},
+		"end":   []string{formatTimestamp(r.MaxTime)},
+	}.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	span, ctx := tracing.StartSpan(ctx, "/prom_query_exemplars HTTP[client]")
+	defer span.Finish()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, status.Error(codes.Unimplemented, "exemplars API is not supported by this Prometheus version")
+	}
+
+	var m struct {
+		Error string `json:"error"`
+		Data  []struct {
+			SeriesLabels map[string]string `json:"seriesLabels"`
+			Exemplars    []struct {
+				Labels    map[string]string `json:"labels"`
+				Value     string            `json:"value"`
+				Timestamp float64           `json:"timestamp"`
+			} `json:"exemplars"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, status.Errorf(codes.Unknown, "query exemplars: unexpected status code %d: %s", resp.StatusCode, m.Error)
+	}
+
+	res := &storepb.ExemplarsResponse{}
+	for _, d := range m.Data {
+		var lset []storepb.Label
+		for n, v := range d.SeriesLabels {
+			if ext.Get(n) != "" {
+				continue
+			}
+			lset = append(lset, storepb.Label{Name: n, Value: v})
+		}
+		lset = extendLset(lset, ext)
+
+		if !selectorsMatch(selectors, lset) {
+			continue
+		}
+		data := storepb.ExemplarData{SeriesLabels: lset}
+
+		for _, e := range d.Exemplars {
+			v, err := strconv.ParseFloat(e.Value, 64)
+			if err != nil {
+				return nil, status.Error(codes.Unknown, errors.Wrap(err, "parse exemplar value").Error())
+			}
+			ex := storepb.Exemplar{
+				Value: v,
+				Ts:    int64(math.Round(e.Timestamp * 1000)),
+			}
+			for n, v := range e.Labels {
+				ex.Labels = append(ex.Labels, storepb.Label{Name: n, Value: v})
+			}
+			sort.Slice(ex.Labels, func(i, j int) bool {
+				return ex.Labels[i].Name < ex.Labels[j].Name
+			})
+			data.Exemplars = append(data.Exemplars, ex)
+		}
+		res.Data = append(res.Data, data)
+	}
+	res.Data = storepb.MergeExemplars(res.Data)
+
+	return res, nil
+}
+
+// formatTimestamp formats a timestamp in milliseconds as seconds for the Prometheus HTTP API.
+func formatTimestamp(t int64) string {
+	return strconv.FormatFloat(float64(t)/1000, 'f', -1, 64)
+}
@@ -3,6 +3,8 @@ package store
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -13,6 +15,8 @@ import (
 	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestPrometheusStore_Series_e2e(t *testing.T) {
@@ -206,3 +210,77 @@ func TestPrometheusStore_Info(t *testing.T) {
 	testutil.Equals(t, int64(123), resp.MinTime)
 	testutil.Equals(t, int64(456), resp.MaxTime)
 }
+
+func TestPrometheusStore_Exemplars(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	var reqs []url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_exemplars" {
+			http.NotFound(w, r)
+			return
+		}
+		reqs = append(reqs, r.URL.Query())
+
+		fmt.Fprint(w, `{"status":"success","data":[
+			{
+				"seriesLabels": {"__name__": "http_request_duration_seconds_bucket", "le": "0.5", "job": "api"},
+				"exemplars": [
+					{"labels": {"trace_id": "b"}, "value": "0.3", "timestamp": 2.5},
+					{"labels": {"trace_id": "a"}, "value": "0.2", "timestamp": 1}
+				]
+			},
+			{
+				"seriesLabels": {"__name__": "http_request_duration_seconds_bucket", "le": "0.5", "job": "db"},
+				"exemplars": [{"labels": {"trace_id": "c"}, "value": "0.1", "timestamp": 1}]
+			}
+		]}`)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	proxy, err := NewPrometheusStore(nil, nil, u,
+		func() labels.Labels {
+			return labels.FromStrings("region", "eu-west")
+		}, nil)
+	testutil.Ok(t, err)
+
+	resp, err := proxy.Exemplars(context.Background(), &storepb.ExemplarsRequest{
+		Query:   `http_request_duration_seconds_bucket{region="eu-west", job="api"} or foo{region="us-east"}`,
+		MinTime: 1000,
+		MaxTime: 3500,
+	})
+	testutil.Ok(t, err)
+
+	// Matchers on external labels are not sent to Prometheus.
+	testutil.Equals(t, 1, len(reqs))
+	testutil.Equals(t, `http_request_duration_seconds_bucket{job="api"} or foo`, reqs[0].Get("query"))
+	testutil.Equals(t, "1", reqs[0].Get("start"))
+	testutil.Equals(t, "3.5", reqs[0].Get("end"))
+
+	// The series of the second selector do not match the external labels.
+	testutil.Equals(t, []storepb.ExemplarData{
+		{
+			SeriesLabels: []storepb.Label{
+				{Name: "__name__", Value: "http_request_duration_seconds_bucket"},
+				{Name: "job", Value: "api"},
+				{Name: "le", Value: "0.5"},
+				{Name: "region", Value: "eu-west"},
+			},
+			Exemplars: []storepb.Exemplar{
+				{Labels: []storepb.Label{{Name: "trace_id", Value: "a"}}, Value: 0.2, Ts: 1000},
+				{Labels: []storepb.Label{{Name: "trace_id", Value: "b"}}, Value: 0.3, Ts: 2500},
+			},
+		},
+	}, resp.Data)
+
+	// Prometheus versions without exemplar support.
+	u.Path = "/old"
+	_, err = proxy.Exemplars(context.Background(), &storepb.ExemplarsRequest{Query: "up"})
+	testutil.NotOk(t, err)
+	se, ok := status.FromError(err)
+	testutil.Assert(t, ok, "expected gRPC status error")
+	testutil.Equals(t, codes.Unimplemented, se.Code())
+}
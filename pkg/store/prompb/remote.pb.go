@@ -16,6 +16,8 @@
 		TimeSeries
 		Label
 		LabelMatcher
+		WriteRequest
+		MetricMetadata
 */
 package prompb
 
@@ -66,6 +68,47 @@ func (x LabelMatcher_Type) String() string {
 }
 func (LabelMatcher_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptorRemote, []int{7, 0} }
 
+type MetricMetadata_MetricType int32
+
+const (
+	MetricMetadata_UNKNOWN        MetricMetadata_MetricType = 0
+	MetricMetadata_COUNTER        MetricMetadata_MetricType = 1
+	MetricMetadata_GAUGE          MetricMetadata_MetricType = 2
+	MetricMetadata_HISTOGRAM      MetricMetadata_MetricType = 3
+	MetricMetadata_GAUGEHISTOGRAM MetricMetadata_MetricType = 4
+	MetricMetadata_SUMMARY        MetricMetadata_MetricType = 5
+	MetricMetadata_INFO           MetricMetadata_MetricType = 6
+	MetricMetadata_STATESET       MetricMetadata_MetricType = 7
+)
+
+var MetricMetadata_MetricType_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "COUNTER",
+	2: "GAUGE",
+	3: "HISTOGRAM",
+	4: "GAUGEHISTOGRAM",
+	5: "SUMMARY",
+	6: "INFO",
+	7: "STATESET",
+}
+var MetricMetadata_MetricType_value = map[string]int32{
+	"UNKNOWN":        0,
+	"COUNTER":        1,
+	"GAUGE":          2,
+	"HISTOGRAM":      3,
+	"GAUGEHISTOGRAM": 4,
+	"SUMMARY":        5,
+	"INFO":           6,
+	"STATESET":       7,
+}
+
+func (x MetricMetadata_MetricType) String() string {
+	return proto.EnumName(MetricMetadata_MetricType_name, int32(x))
+}
+func (MetricMetadata_MetricType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptorRemote, []int{9, 0}
+}
+
 type ReadRequest struct {
 	Queries []Query `protobuf:"bytes,1,rep,name=queries" json:"queries"`
 }
@@ -147,6 +190,28 @@ func (m *LabelMatcher) String() string            { return proto.CompactTextStri
 func (*LabelMatcher) ProtoMessage()               {}
 func (*LabelMatcher) Descriptor() ([]byte, []int) { return fileDescriptorRemote, []int{7} }
 
+type WriteRequest struct {
+	Timeseries []TimeSeries     `protobuf:"bytes,1,rep,name=timeseries" json:"timeseries"`
+	Metadata   []MetricMetadata `protobuf:"bytes,3,rep,name=metadata" json:"metadata"`
+}
+
+func (m *WriteRequest) Reset()                    { *m = WriteRequest{} }
+func (m *WriteRequest) String() string            { return proto.CompactTextString(m) }
+func (*WriteRequest) ProtoMessage()               {}
+func (*WriteRequest) Descriptor() ([]byte, []int) { return fileDescriptorRemote, []int{8} }
+
+type MetricMetadata struct {
+	Type             MetricMetadata_MetricType `protobuf:"varint,1,opt,name=type,proto3,enum=prometheus.MetricMetadata_MetricType" json:"type,omitempty"`
+	MetricFamilyName string                    `protobuf:"bytes,2,opt,name=metric_family_name,json=metricFamilyName,proto3" json:"metric_family_name,omitempty"`
+	Help             string                    `protobuf:"bytes,4,opt,name=help,proto3" json:"help,omitempty"`
+	Unit             string                    `protobuf:"bytes,5,opt,name=unit,proto3" json:"unit,omitempty"`
+}
+
+func (m *MetricMetadata) Reset()                    { *m = MetricMetadata{} }
+func (m *MetricMetadata) String() string            { return proto.CompactTextString(m) }
+func (*MetricMetadata) ProtoMessage()               {}
+func (*MetricMetadata) Descriptor() ([]byte, []int) { return fileDescriptorRemote, []int{9} }
+
 func init() {
 	proto.RegisterType((*ReadRequest)(nil), "prometheus.ReadRequest")
 	proto.RegisterType((*ReadResponse)(nil), "prometheus.ReadResponse")
@@ -156,7 +221,10 @@ func init() {
 	proto.RegisterType((*TimeSeries)(nil), "prometheus.TimeSeries")
 	proto.RegisterType((*Label)(nil), "prometheus.Label")
 	proto.RegisterType((*LabelMatcher)(nil), "prometheus.LabelMatcher")
+	proto.RegisterType((*WriteRequest)(nil), "prometheus.WriteRequest")
+	proto.RegisterType((*MetricMetadata)(nil), "prometheus.MetricMetadata")
 	proto.RegisterEnum("prometheus.LabelMatcher_Type", LabelMatcher_Type_name, LabelMatcher_Type_value)
+	proto.RegisterEnum("prometheus.MetricMetadata_MetricType", MetricMetadata_MetricType_name, MetricMetadata_MetricType_value)
 }
 func (m *ReadRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
@@ -424,6 +492,89 @@ func (m *LabelMatcher) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *WriteRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *WriteRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Timeseries) > 0 {
+		for _, msg := range m.Timeseries {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRemote(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Metadata) > 0 {
+		for _, msg := range m.Metadata {
+			dAtA[i] = 0x1a
+			i++
+			i = encodeVarintRemote(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *MetricMetadata) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MetricMetadata) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Type != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRemote(dAtA, i, uint64(m.Type))
+	}
+	if len(m.MetricFamilyName) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintRemote(dAtA, i, uint64(len(m.MetricFamilyName)))
+		i += copy(dAtA[i:], m.MetricFamilyName)
+	}
+	if len(m.Help) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintRemote(dAtA, i, uint64(len(m.Help)))
+		i += copy(dAtA[i:], m.Help)
+	}
+	if len(m.Unit) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintRemote(dAtA, i, uint64(len(m.Unit)))
+		i += copy(dAtA[i:], m.Unit)
+	}
+	return i, nil
+}
+
 func encodeVarintRemote(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -548,6 +699,45 @@ func (m *LabelMatcher) Size() (n int) {
 	return n
 }
 
+func (m *WriteRequest) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Timeseries) > 0 {
+		for _, e := range m.Timeseries {
+			l = e.Size()
+			n += 1 + l + sovRemote(uint64(l))
+		}
+	}
+	if len(m.Metadata) > 0 {
+		for _, e := range m.Metadata {
+			l = e.Size()
+			n += 1 + l + sovRemote(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *MetricMetadata) Size() (n int) {
+	var l int
+	_ = l
+	if m.Type != 0 {
+		n += 1 + sovRemote(uint64(m.Type))
+	}
+	l = len(m.MetricFamilyName)
+	if l > 0 {
+		n += 1 + l + sovRemote(uint64(l))
+	}
+	l = len(m.Help)
+	if l > 0 {
+		n += 1 + l + sovRemote(uint64(l))
+	}
+	l = len(m.Unit)
+	if l > 0 {
+		n += 1 + l + sovRemote(uint64(l))
+	}
+	return n
+}
+
 func sovRemote(x uint64) (n int) {
 	for {
 		n++
@@ -1350,6 +1540,274 @@ func (m *LabelMatcher) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *WriteRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRemote
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: WriteRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: WriteRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Timeseries", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRemote
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Timeseries = append(m.Timeseries, TimeSeries{})
+			if err := m.Timeseries[len(m.Timeseries)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRemote
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metadata = append(m.Metadata, MetricMetadata{})
+			if err := m.Metadata[len(m.Metadata)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRemote(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRemote
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MetricMetadata) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRemote
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetricMetadata: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetricMetadata: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
+			}
+			m.Type = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Type |= (MetricMetadata_MetricType(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MetricFamilyName", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRemote
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MetricFamilyName = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Help", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRemote
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Help = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRemote
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRemote
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRemote(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRemote
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipRemote(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -1458,33 +1916,44 @@ var (
 func init() { proto.RegisterFile("remote.proto", fileDescriptorRemote) }
 
 var fileDescriptorRemote = []byte{
-	// 448 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x93, 0xc1, 0x8a, 0x13, 0x41,
-	0x10, 0x86, 0xd3, 0x33, 0xc9, 0xc4, 0xad, 0x84, 0x65, 0x2c, 0x16, 0x0d, 0xa2, 0x51, 0xe6, 0x94,
-	0x83, 0x64, 0x49, 0x3c, 0x08, 0xb2, 0x07, 0x59, 0x08, 0x1e, 0x74, 0x85, 0xf4, 0xee, 0xc9, 0xcb,
-	0x32, 0x31, 0xc5, 0xee, 0xc2, 0x4c, 0x66, 0xd2, 0xdd, 0x23, 0xe4, 0x41, 0x3c, 0xf9, 0x42, 0x39,
-	0xfa, 0x04, 0xa2, 0x79, 0x12, 0xe9, 0xea, 0x99, 0xa4, 0xc5, 0xdd, 0x5b, 0x77, 0xd5, 0x57, 0x7f,
-	0xfd, 0x55, 0x4d, 0x43, 0x5f, 0x51, 0x5e, 0x18, 0x1a, 0x97, 0xaa, 0x30, 0x05, 0x42, 0xa9, 0x8a,
-	0x9c, 0xcc, 0x2d, 0x55, 0xfa, 0xd9, 0xc9, 0x4d, 0x71, 0x53, 0x70, 0xf8, 0xd4, 0x9e, 0x1c, 0x91,
-	0xbc, 0x87, 0x9e, 0xa4, 0x74, 0x29, 0x69, 0x5d, 0x91, 0x36, 0x38, 0x81, 0xee, 0xba, 0x22, 0x75,
-	0x47, 0x7a, 0x20, 0x5e, 0x85, 0xa3, 0xde, 0xf4, 0xf1, 0xf8, 0x20, 0x31, 0x9e, 0x57, 0xa4, 0x36,
-	0xe7, 0xed, 0xed, 0xaf, 0x97, 0x2d, 0xd9, 0x70, 0xc9, 0x07, 0xe8, 0x3b, 0x05, 0x5d, 0x16, 0x2b,
-	0x4d, 0xf8, 0x16, 0xba, 0x8a, 0x74, 0x95, 0x99, 0x46, 0xe2, 0xe9, 0x7f, 0x12, 0x92, 0xf3, 0x8d,
-	0x50, 0x4d, 0x27, 0x3f, 0x04, 0x74, 0x38, 0x8d, 0xaf, 0x01, 0xb5, 0x49, 0x95, 0xb9, 0x36, 0x77,
-	0x39, 0x69, 0x93, 0xe6, 0xe5, 0x75, 0x6e, 0xd5, 0xc4, 0x28, 0x94, 0x31, 0x67, 0xae, 0x9a, 0xc4,
-	0x85, 0xc6, 0x11, 0xc4, 0xb4, 0x5a, 0xfe, 0xcb, 0x06, 0xcc, 0x1e, 0xd3, 0x6a, 0xe9, 0x93, 0xef,
-	0xe0, 0x51, 0x9e, 0x9a, 0xaf, 0xb7, 0xa4, 0xf4, 0x20, 0x64, 0x6f, 0x03, 0xdf, 0xdb, 0xa7, 0x74,
-	0x41, 0xd9, 0x85, 0x03, 0x6a, 0x73, 0x7b, 0x3e, 0xf9, 0x08, 0x3d, 0xcf, 0x3b, 0x9e, 0x01, 0x70,
-	0x43, 0x7f, 0x57, 0x4f, 0x7c, 0x31, 0xdb, 0xf7, 0x92, 0xb3, 0xb5, 0x94, 0xc7, 0x27, 0x67, 0x10,
-	0x5d, 0xa6, 0x79, 0x99, 0x11, 0x9e, 0x40, 0xe7, 0x5b, 0x9a, 0x55, 0xc4, 0xd3, 0x09, 0xe9, 0x2e,
-	0xf8, 0x1c, 0x8e, 0xf6, 0xe3, 0xd4, 0xb3, 0x1c, 0x02, 0xc9, 0x1a, 0xe0, 0xa0, 0x8e, 0xa7, 0x10,
-	0x65, 0xd6, 0xf8, 0xbd, 0x2f, 0xc6, 0x23, 0xd5, 0x06, 0x6a, 0x0c, 0xa7, 0xd0, 0xd5, 0xdc, 0xdc,
-	0xae, 0xc9, 0x56, 0xa0, 0x5f, 0xe1, 0x7c, 0x35, 0x6f, 0x53, 0x83, 0xc9, 0x04, 0x3a, 0x2c, 0x85,
-	0x08, 0xed, 0x55, 0x9a, 0x3b, 0xbb, 0x47, 0x92, 0xcf, 0x87, 0x19, 0x02, 0x0e, 0xba, 0x4b, 0xf2,
-	0x5d, 0x40, 0xdf, 0xdf, 0x28, 0x4e, 0xa0, 0x6d, 0x36, 0xa5, 0x2b, 0x3d, 0x9e, 0xbe, 0x78, 0x68,
-	0xf3, 0xe3, 0xab, 0x4d, 0x49, 0x92, 0xd1, 0x7d, 0xb7, 0xe0, 0xbe, 0x6e, 0xa1, 0xdf, 0x6d, 0x04,
-	0x6d, 0x5b, 0x87, 0x11, 0x04, 0xb3, 0x79, 0xdc, 0xc2, 0x2e, 0x84, 0x9f, 0x67, 0xf3, 0x58, 0xd8,
-	0x80, 0x9c, 0xc5, 0x01, 0x07, 0xe4, 0x2c, 0x0e, 0xcf, 0x07, 0xdb, 0x3f, 0xc3, 0xd6, 0x76, 0x37,
-	0x14, 0x3f, 0x77, 0x43, 0xf1, 0x7b, 0x37, 0x14, 0x5f, 0x22, 0xeb, 0xa4, 0x5c, 0x2c, 0x22, 0xfe,
-	0x12, 0x6f, 0xfe, 0x06, 0x00, 0x00, 0xff, 0xff, 0x64, 0x87, 0x06, 0x4f, 0x44, 0x03, 0x00, 0x00,
+	// 622 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa5, 0x54, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0x8d, 0xbf, 0xe2, 0x64, 0x12, 0x22, 0xb3, 0xaa, 0xc0, 0xaa, 0xa0, 0x20, 0x4b, 0x48, 0x39,
+	0xa0, 0x54, 0x0d, 0x07, 0x04, 0xea, 0x81, 0x14, 0xa5, 0xa1, 0x2a, 0x76, 0x54, 0xdb, 0x51, 0x05,
+	0x97, 0xc8, 0x69, 0x96, 0xc6, 0x92, 0x1d, 0xbb, 0xf6, 0x06, 0x29, 0x7f, 0x81, 0x3b, 0x27, 0xf8,
+	0x41, 0x39, 0xf2, 0x0b, 0x10, 0xf0, 0x4b, 0xd8, 0x5d, 0xdb, 0xb1, 0x23, 0xda, 0x13, 0x07, 0xcb,
+	0xb3, 0x33, 0x6f, 0xde, 0xbc, 0x7d, 0xbb, 0x36, 0xb4, 0x13, 0x1c, 0x46, 0x04, 0xf7, 0xe2, 0x24,
+	0x22, 0x11, 0x02, 0xfa, 0x0a, 0x31, 0x59, 0xe0, 0x55, 0xba, 0xbf, 0x77, 0x1d, 0x5d, 0x47, 0x3c,
+	0x7d, 0xc8, 0xa2, 0x0c, 0x61, 0xbc, 0x81, 0x96, 0x8d, 0xbd, 0xb9, 0x8d, 0x6f, 0x56, 0x38, 0x25,
+	0xe8, 0x08, 0x54, 0x1a, 0x24, 0x3e, 0x4e, 0x75, 0xe1, 0xa9, 0xd4, 0x6d, 0xf5, 0xef, 0xf7, 0x4a,
+	0x8a, 0xde, 0x05, 0x2d, 0xad, 0x4f, 0xe4, 0xcd, 0xcf, 0x27, 0x35, 0xbb, 0xc0, 0x19, 0x23, 0x68,
+	0x67, 0x0c, 0x69, 0x1c, 0x2d, 0x53, 0x8c, 0x5e, 0x82, 0x9a, 0xe0, 0x74, 0x15, 0x90, 0x82, 0xe2,
+	0xe1, 0x3f, 0x14, 0x36, 0xaf, 0x17, 0x44, 0x39, 0xda, 0xf8, 0x26, 0x80, 0xc2, 0xcb, 0xe8, 0x39,
+	0xa0, 0x94, 0x78, 0x09, 0x99, 0x12, 0x3f, 0xa4, 0xaa, 0xbc, 0x30, 0x9e, 0x86, 0x8c, 0x4d, 0xe8,
+	0x4a, 0xb6, 0xc6, 0x2b, 0x6e, 0x51, 0x30, 0x53, 0xd4, 0x05, 0x0d, 0x2f, 0xe7, 0xbb, 0x58, 0x91,
+	0x63, 0x3b, 0x34, 0x5f, 0x45, 0xbe, 0x86, 0x46, 0xe8, 0x91, 0xab, 0x05, 0x4e, 0x52, 0x5d, 0xe2,
+	0xda, 0xf4, 0xaa, 0xb6, 0xf7, 0xde, 0x0c, 0x07, 0x66, 0x06, 0xc8, 0xc5, 0x6d, 0xf1, 0xc6, 0x39,
+	0xb4, 0x2a, 0xda, 0xd1, 0x31, 0x00, 0x1f, 0x58, 0xf5, 0xea, 0x41, 0x95, 0x8c, 0xcd, 0x75, 0x78,
+	0x35, 0xa7, 0xaa, 0xe0, 0x8d, 0x63, 0xa8, 0x3b, 0x54, 0x52, 0x80, 0xd1, 0x1e, 0x28, 0x9f, 0xbd,
+	0x60, 0x85, 0xf9, 0xee, 0x04, 0x3b, 0x5b, 0xa0, 0x47, 0xd0, 0xdc, 0x6e, 0x27, 0xdf, 0x4b, 0x99,
+	0x30, 0x6e, 0x00, 0x4a, 0x76, 0x74, 0x08, 0xf5, 0x80, 0x09, 0xbf, 0xf5, 0xc4, 0xf8, 0x96, 0x72,
+	0x01, 0x39, 0x0c, 0xf5, 0x41, 0x4d, 0xf9, 0x70, 0x66, 0x13, 0xeb, 0x40, 0xd5, 0x8e, 0x4c, 0x57,
+	0x71, 0x36, 0x39, 0xd0, 0x38, 0x02, 0x85, 0x53, 0x21, 0x04, 0xf2, 0xd2, 0x0b, 0x33, 0xb9, 0x4d,
+	0x9b, 0xc7, 0xe5, 0x1e, 0x44, 0x9e, 0xcc, 0x16, 0xc6, 0x57, 0x01, 0xda, 0x55, 0x47, 0xe9, 0xdd,
+	0x92, 0xc9, 0x3a, 0xce, 0x5a, 0x3b, 0xfd, 0xc7, 0x77, 0x39, 0xdf, 0x73, 0x29, 0xc8, 0xe6, 0xd0,
+	0xed, 0x34, 0xf1, 0xb6, 0x69, 0x52, 0x75, 0x5a, 0x17, 0x64, 0xd6, 0x87, 0xea, 0x20, 0x0e, 0x2f,
+	0xb4, 0x1a, 0x52, 0x41, 0xb2, 0x68, 0x20, 0xb0, 0x84, 0x3d, 0xd4, 0x44, 0x9e, 0xa0, 0x81, 0x64,
+	0x7c, 0xa1, 0xba, 0x2e, 0x13, 0x9f, 0xe0, 0xe2, 0xce, 0xff, 0xd7, 0x51, 0xd2, 0xee, 0x06, 0xc5,
+	0x79, 0x73, 0x8f, 0x78, 0xf9, 0x9d, 0xda, 0xaf, 0xf6, 0x9a, 0x98, 0x24, 0xfe, 0x95, 0x99, 0x23,
+	0xb6, 0xb7, 0x2a, 0x5f, 0x1b, 0xdf, 0x45, 0xe8, 0xec, 0x42, 0xd0, 0xab, 0x1d, 0x9b, 0x9e, 0xdd,
+	0x4d, 0x96, 0x2f, 0x2b, 0x76, 0xd1, 0xef, 0x26, 0xe4, 0xb9, 0xe9, 0x27, 0x2f, 0xf4, 0x83, 0xf5,
+	0xb4, 0x62, 0x9e, 0x96, 0x55, 0x4e, 0x79, 0xc1, 0x62, 0x46, 0x52, 0x73, 0x17, 0x38, 0x88, 0x75,
+	0x39, 0x33, 0x97, 0xc5, 0x2c, 0xb7, 0x5a, 0xfa, 0x44, 0x57, 0xb2, 0x1c, 0x8b, 0x8d, 0x35, 0x40,
+	0x39, 0x09, 0xb5, 0x40, 0x9d, 0x58, 0xe7, 0xd6, 0xf8, 0xd2, 0xa2, 0x2e, 0xd3, 0xc5, 0xdb, 0xf1,
+	0xc4, 0x72, 0x87, 0x36, 0x75, 0xba, 0x09, 0xca, 0x68, 0x30, 0x19, 0x31, 0xb3, 0xef, 0x41, 0xf3,
+	0xdd, 0x99, 0xe3, 0x8e, 0x47, 0xf6, 0xc0, 0xd4, 0x24, 0xca, 0xda, 0xe1, 0x95, 0x32, 0x27, 0xb3,
+	0x56, 0x67, 0x62, 0x9a, 0x03, 0xfb, 0x83, 0xa6, 0xa0, 0x06, 0xc8, 0x67, 0xd6, 0xe9, 0x58, 0xab,
+	0xa3, 0x36, 0x34, 0x1c, 0x77, 0xe0, 0x0e, 0x9d, 0xa1, 0xab, 0xa9, 0x27, 0xfa, 0xe6, 0xf7, 0x41,
+	0x6d, 0xf3, 0xe7, 0x40, 0xf8, 0x41, 0x9f, 0x5f, 0xf4, 0xf9, 0x58, 0x67, 0x76, 0xc4, 0xb3, 0x59,
+	0x9d, 0xff, 0xbe, 0x5e, 0xfc, 0x05, 0x5c, 0x1f, 0xc3, 0xa1, 0xf0, 0x04, 0x00, 0x00,
 }
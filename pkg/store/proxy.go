@@ -4,7 +4,9 @@ import (
 	"context"
 	"io"
 	"math"
+	"strings"
 	"sync"
+	"time"
 
 	"fmt"
 
@@ -12,7 +14,11 @@ import (
 	"github.com/go-kit/kit/log/level"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
 	"github.com/improbable-eng/thanos/pkg/strutil"
+	"github.com/improbable-eng/thanos/pkg/tracing"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/tsdb/labels"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -29,39 +35,141 @@ type Client interface {
 
 	// Minimum and maximum time range of data in the store.
 	TimeRange() (mint int64, maxt int64)
+
+	// String returns the string representation of the store, e.g. its address.
+	String() string
 }
 
 // ProxyStore implements the store API that proxies request to all given underlying stores.
 type ProxyStore struct {
-	logger         log.Logger
-	stores         func(context.Context) ([]Client, error)
-	selectorLabels labels.Labels
+	logger          log.Logger
+	stores          func(context.Context) ([]Client, error)
+	selectorLabels  labels.Labels
+	timeSplitOffset time.Duration
+	responseTimeout time.Duration
+	requiredStores  RequiredStores
+	// mergeConcurrency is the number of goroutines merging the series of all stores.
+	mergeConcurrency int
+
+	truncatedLabelResponses *prometheus.CounterVec
+	timeSplitRequests       *prometheus.CounterVec
+	responseTimeouts        prometheus.Counter
+}
+
+// ProxyStoreOptions configures the optional behaviour of a ProxyStore. The zero value disables all of it.
+type ProxyStoreOptions struct {
+	// If TimeSplitOffset is positive, series requests are split in time between historical and live stores, see timeSplits.
+	TimeSplitOffset time.Duration
+	// If ResponseTimeout is positive, series streams of stores that take longer are abandoned and reported as partial response.
+	ResponseTimeout time.Duration
+	// Series requests fail instead of returning a partial response if any of the RequiredStores fails.
+	RequiredStores RequiredStores
+	// If MergeConcurrency is above one, the series of the stores are merged by as many goroutines.
+	MergeConcurrency int
 }
 
 // NewProxyStore returns a new ProxyStore that uses the given clients that implements storeAPI to fan-in all series to the client.
 // Note that there is no deduplication support. Deduplication should be done on the highest level (just before PromQL)
 func NewProxyStore(
 	logger log.Logger,
+	reg prometheus.Registerer,
 	stores func(context.Context) ([]Client, error),
 	selectorLabels labels.Labels,
+	opts ProxyStoreOptions,
 ) *ProxyStore {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
 	s := &ProxyStore{
-		logger:         logger,
-		stores:         stores,
-		selectorLabels: selectorLabels,
+		logger:           logger,
+		stores:           stores,
+		selectorLabels:   selectorLabels,
+		timeSplitOffset:  opts.TimeSplitOffset,
+		responseTimeout:  opts.ResponseTimeout,
+		requiredStores:   opts.RequiredStores,
+		mergeConcurrency: opts.MergeConcurrency,
+		truncatedLabelResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_truncated_label_responses_total",
+			Help: "Total number of LabelNames and LabelValues store responses dropped from the result because the store rejected them as too large or slow.",
+		}, []string{"rpc"}),
+		timeSplitRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_time_split_requests_total",
+			Help: "Total number of series requests by how their time range was split between historical and live stores.",
+		}, []string{"split"}),
+		responseTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_proxy_store_response_timeouts_total",
+			Help: "Total number of series streams of stores that were abandoned because the store exceeded the response timeout.",
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(s.truncatedLabelResponses, s.timeSplitRequests, s.responseTimeouts)
 	}
 	return s
 }
 
-// Info returns store information about the external labels this store have.
+// timeSplits returns, for each store taking part in a split, the time up to which a historical store serves data
+// and after which a live store serves data. Historical stores like store gateways advertise a bounded time range,
+// live stores like sidecars advertise no upper bound. A live store is paired with the historical stores that may
+// hold its data, i.e. those with the same external labels and those without any, like store gateways serving the
+// blocks of all label sets. Data older than the time split offset is expected to be uploaded to object storage
+// already. The split of a live store is never later than the newest data of its historical stores, so that old data
+// is preferably read from historical stores, which may serve it downsampled. A historical store serves data up to
+// the latest split of its live stores. Stores without a counterpart are not split.
+func (s *ProxyStore) timeSplits(stores []Client) map[Client]int64 {
+	if s.timeSplitOffset <= 0 {
+		return nil
+	}
+	var live, historical []Client
+	for _, st := range stores {
+		if _, maxt := st.TimeRange(); maxt == math.MaxInt64 {
+			live = append(live, st)
+		} else {
+			historical = append(historical, st)
+		}
+	}
+	offsetSplit := time.Now().Add(-s.timeSplitOffset).UnixNano() / int64(time.Millisecond)
+
+	splits := map[Client]int64{}
+	for _, l := range live {
+		var (
+			paired []Client
+			hmaxt  int64
+		)
+		for _, h := range historical {
+			if hl := h.Labels(); len(hl) > 0 && storepb.CompareLabels(hl, l.Labels()) != 0 {
+				continue
+			}
+			if _, maxt := h.TimeRange(); len(paired) == 0 || maxt > hmaxt {
+				hmaxt = maxt
+			}
+			paired = append(paired, h)
+		}
+		if len(paired) == 0 {
+			continue
+		}
+		split := offsetSplit
+		if hmaxt < split {
+			split = hmaxt
+		}
+		splits[l] = split
+
+		for _, h := range paired {
+			if hsplit, ok := splits[h]; !ok || split > hsplit {
+				splits[h] = split
+			}
+		}
+	}
+	return splits
+}
+
+// Info returns store information about the external labels this store have. It supports sharding if all its
+// stores do.
 func (s *ProxyStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	res := &storepb.InfoResponse{
-		MinTime: 0,
-		MaxTime: math.MaxInt64,
-		Labels:  make([]storepb.Label, 0, len(s.selectorLabels)),
+		MinTime:     0,
+		MaxTime:     math.MaxInt64,
+		Labels:      make([]storepb.Label, 0, len(s.selectorLabels)),
+		CurrentTime: time.Now().UnixNano() / int64(time.Millisecond),
 	}
 	for _, l := range s.selectorLabels {
 		res.Labels = append(res.Labels, storepb.Label{
@@ -69,9 +177,27 @@ func (s *ProxyStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb
 			Value: l.Value,
 		})
 	}
+	if stores, err := s.stores(ctx); err == nil && len(stores) > 0 {
+		res.SupportsSharding = true
+		for _, st := range stores {
+			if !supportsSharding(st) {
+				res.SupportsSharding = false
+				break
+			}
+		}
+	}
 	return res, nil
 }
 
+// supportsSharding returns true if the store is known to only return the series of the requested shard of sharded
+// series requests. Clients report it with a SupportsSharding method.
+func supportsSharding(c Client) bool {
+	sc, ok := c.(interface {
+		SupportsSharding() bool
+	})
+	return ok && sc.SupportsSharding()
+}
+
 // Series returns all series for a requested time range and label matcher. Requested series are taken from other
 // stores and proxied to RPC client. NOTE: Resulted data are not trimmed exactly to min and max time range.
 func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
@@ -83,45 +209,118 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 		return nil
 	}
 
+	span, ctx := tracing.StartSpan(srv.Context(), "proxy_series")
+	defer span.Finish()
+
 	var (
 		respCh    = make(chan *storepb.SeriesResponse, 10)
 		seriesSet []storepb.SeriesSet
 		g         errgroup.Group
+		stats     = &fanoutStats{}
 	)
+	// Tags are set once all streams are drained, so errored stores are accounted for as well.
+	defer stats.setTags(span)
 
-	stores, err := s.stores(srv.Context())
+	stores, err := s.stores(ctx)
 	if err != nil {
 		level.Error(s.logger).Log("err", err)
 		return status.Errorf(codes.Unknown, err.Error())
 	}
+
+	splits := s.timeSplits(stores)
+	var historical, live bool
+	for _, split := range splits {
+		historical = historical || r.MinTime <= split
+		live = live || r.MaxTime > split
+	}
+	switch {
+	case len(splits) == 0:
+		s.timeSplitRequests.WithLabelValues("none").Inc()
+	case historical && live:
+		s.timeSplitRequests.WithLabelValues("both").Inc()
+	case historical:
+		s.timeSplitRequests.WithLabelValues("historical").Inc()
+	default:
+		s.timeSplitRequests.WithLabelValues("live").Inc()
+	}
+
 	for _, st := range stores {
+		mint, maxt := r.MinTime, r.MaxTime
+		if split, ok := splits[st]; ok {
+			if _, stMaxt := st.TimeRange(); stMaxt == math.MaxInt64 {
+				if mint <= split {
+					mint = split + 1
+				}
+			} else if maxt > split {
+				maxt = split
+			}
+			if mint > maxt {
+				continue
+			}
+		}
 		// We might be able to skip the store if its meta information indicates
 		// it cannot have series matching our query.
 		// NOTE: all matchers are validated in labelsMatches method so we explicitly ignore error.
-		if ok, _ := storeMatches(st, r.MinTime, r.MaxTime, newMatchers...); !ok {
+		if ok, _ := storeMatches(st, mint, maxt, newMatchers...); !ok {
 			continue
 		}
-		sc, err := st.Series(srv.Context(), &storepb.SeriesRequest{
-			MinTime:             r.MinTime,
-			MaxTime:             r.MaxTime,
+		storeID := fmt.Sprintf("%v", st.Labels())
+		if storeID == "" {
+			storeID = "Store Gateway"
+		}
+
+		storeSpan, storeCtx := tracing.StartSpan(ctx, "store_series", opentracing.Tags{
+			"store.addr":   st.String(),
+			"store.labels": storeID,
+		})
+		stats.contacted++
+
+		// Every store gets its own deadline, so a slow store does not affect the others. Canceling the
+		// context of an abandoned stream releases its resources.
+		var cancel context.CancelFunc
+		if s.responseTimeout > 0 {
+			storeCtx, cancel = context.WithTimeout(storeCtx, s.responseTimeout)
+		} else {
+			storeCtx, cancel = context.WithCancel(storeCtx)
+		}
+
+		begin := time.Now()
+		sc, err := st.Series(storeCtx, &storepb.SeriesRequest{
+			MinTime:             mint,
+			MaxTime:             maxt,
 			Matchers:            newMatchers,
 			Aggregates:          r.Aggregates,
 			MaxResolutionWindow: r.MaxResolutionWindow,
+			Hints:               r.Hints,
+			ShardIndex:          r.ShardIndex,
+			TotalShards:         r.TotalShards,
+			ShardByLabels:       r.ShardByLabels,
+			HintsOnly:           r.HintsOnly,
 		})
 		if err != nil {
-			storeID := fmt.Sprintf("%v", st.Labels())
-			if storeID == "" {
-				storeID = "Store Gateway"
-			}
+			cancel()
 			err = errors.Wrapf(err, "fetch series for %s", storeID)
 			level.Error(s.logger).Log("err", err)
-			respCh <- storepb.NewWarnSeriesResponse(err)
+			respCh <- storepb.NewWarnSeriesResponse(storepb.NewStoreWarning(st.String(), err))
+
+			stats.record(st.String(), time.Since(begin), err)
+			finishSpanWithErr(storeSpan, err)
 			continue
 		}
 
-		seriesSet = append(seriesSet, startStreamSeriesSet(sc, respCh, 10))
+		seriesSet = append(seriesSet, startStreamSeriesSet(ctx, storeCtx, cancel, sc, respCh, seriesBufferSize, streamOptions{
+			responseTimeout:  s.responseTimeout,
+			responseTimeouts: s.responseTimeouts,
+			span:             storeSpan,
+			storeID:          st.String(),
+			stats:            stats,
+			hintsOnly:        r.HintsOnly,
+		}))
 	}
 	if len(seriesSet) == 0 {
+		if err := s.requiredStoresErr(stats); err != nil {
+			return err
+		}
 		err := errors.New("No store matched for this query")
 		level.Warn(s.logger).Log("err", err)
 		respCh <- storepb.NewWarnSeriesResponse(err)
@@ -131,7 +330,7 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 	g.Go(func() error {
 		defer close(respCh)
 
-		mergedSet := storepb.MergeSeriesSets(seriesSet...)
+		mergedSet := mergeSeriesSets(ctx, s.mergeConcurrency, seriesSet...)
 		for mergedSet.Next() {
 			var series storepb.Series
 			series.Labels, series.Chunks = mergedSet.At()
@@ -150,54 +349,229 @@ func (s *ProxyStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSe
 		level.Error(s.logger).Log("err", err)
 		return err
 	}
+	if ctx.Err() == context.Canceled {
+		// The client is gone, all streams were canceled and their data is incomplete.
+		return status.Error(codes.Canceled, "series request canceled")
+	}
+	if err := s.requiredStoresErr(stats); err != nil {
+		return err
+	}
+
+	reportFetchedBytes(srv.Context(), stats.totalFetchedBytes())
 	return nil
 
 }
 
+// requiredStoresErr returns an error listing the required stores that failed to respond, if any.
+// Their partial data was already sent, but the error makes the whole request fail.
+func (s *ProxyStore) requiredStoresErr(stats *fanoutStats) error {
+	failed := s.requiredStores.Filter(stats.erroredStores())
+	if len(failed) == 0 {
+		return nil
+	}
+	err := errors.Errorf("required stores unavailable: %s", strings.Join(failed, ", "))
+	level.Error(s.logger).Log("err", err)
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// fanoutStats accumulates per-store outcomes of a single Series fan-out so they can be
+// attached to the fan-out tracing span.
+type fanoutStats struct {
+	mtx sync.Mutex
+
+	contacted  int
+	responded  int
+	errored    int
+	slowest    string
+	slowestDur time.Duration
+	// Stores that failed to respond.
+	failed []string
+	// Bytes the stores reported to have fetched from object storage.
+	fetchedBytes int64
+}
+
+func (f *fanoutStats) record(store string, dur time.Duration, err error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if err != nil {
+		f.errored++
+		f.failed = append(f.failed, store)
+	} else {
+		f.responded++
+	}
+	if dur >= f.slowestDur {
+		f.slowest = store
+		f.slowestDur = dur
+	}
+}
+
+func (f *fanoutStats) erroredStores() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]string(nil), f.failed...)
+}
+
+func (f *fanoutStats) addFetchedBytes(n int64) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.fetchedBytes += n
+}
+
+func (f *fanoutStats) totalFetchedBytes() int64 {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.fetchedBytes
+}
+
+func (f *fanoutStats) setTags(span opentracing.Span) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	span.SetTag("store.contacted", f.contacted)
+	span.SetTag("store.responded", f.responded)
+	span.SetTag("store.errored", f.errored)
+	if f.slowest != "" {
+		span.SetTag("store.slowest", f.slowest)
+		span.SetTag("store.slowest_duration_ms", int64(f.slowestDur/time.Millisecond))
+	}
+}
+
+func finishSpanWithErr(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogKV("err", err.Error())
+	}
+	span.Finish()
+}
+
 // streamSeriesSet iterates over incoming stream of series.
 // All errors are sent out of band via warning channel.
 type streamSeriesSet struct {
+	// ctx is the context of the whole request, streamCtx the one of this store's stream.
+	ctx       context.Context
+	streamCtx context.Context
+	cancel    context.CancelFunc
+
 	stream storepb.Store_SeriesClient
 	warnCh chan<- *storepb.SeriesResponse
 
+	streamOptions
+
 	currSeries *storepb.Series
 	recvCh     chan *storepb.Series
 }
 
+// streamOptions holds the bookkeeping of a single store's series stream.
+type streamOptions struct {
+	// The stream is abandoned if the store does not finish within responseTimeout.
+	responseTimeout  time.Duration
+	responseTimeouts prometheus.Counter
+
+	// Span covering the whole stream of the store. It is finished once the stream is drained.
+	span    opentracing.Span
+	storeID string
+	stats   *fanoutStats
+
+	// If hintsOnly is set, the stream is abandoned once the store sends a series, as it does not support
+	// requests for hints only.
+	hintsOnly bool
+}
+
 func startStreamSeriesSet(
+	ctx context.Context,
+	streamCtx context.Context,
+	cancel context.CancelFunc,
 	stream storepb.Store_SeriesClient,
 	warnCh chan<- *storepb.SeriesResponse,
 	bufferSize int,
+	opts streamOptions,
 ) *streamSeriesSet {
 	s := &streamSeriesSet{
-		stream: stream,
-		warnCh: warnCh,
-		recvCh: make(chan *storepb.Series, bufferSize),
+		ctx:           ctx,
+		streamCtx:     streamCtx,
+		cancel:        cancel,
+		stream:        stream,
+		warnCh:        warnCh,
+		streamOptions: opts,
+		recvCh:        make(chan *storepb.Series, bufferSize),
 	}
 	go s.fetchLoop()
 	return s
 }
 
 func (s *streamSeriesSet) fetchLoop() {
-	defer close(s.recvCh)
+	var (
+		begin  = time.Now()
+		series int
+		err    error
+	)
+	defer func() {
+		s.span.SetTag("series", series)
+		s.stats.record(s.storeID, time.Since(begin), err)
+		finishSpanWithErr(s.span, err)
+
+		s.cancel()
+		close(s.recvCh)
+	}()
 	for {
-		r, err := s.stream.Recv()
+		var r *storepb.SeriesResponse
+		r, err = s.stream.Recv()
 		if err == io.EOF {
+			err = nil
+			trailer := s.stream.Trailer()
+			s.stats.addFetchedBytes(fetchedBytesFromTrailer(trailer))
+			if sr := SourceResolutionsFromContext(s.ctx); sr != nil {
+				sr.Add(s.storeID, sourceResolutionsFromTrailer(trailer)...)
+			}
+			return
+		}
+		if err != nil && s.ctx.Err() == context.Canceled {
+			// The whole request was canceled, there is no one left to warn.
+			err = errors.Wrap(err, "request canceled")
+			return
+		}
+		if err != nil && s.timedOut() {
+			s.responseTimeouts.Inc()
+			err = errors.Errorf("response timeout of %s exceeded, abandoned the store after %d series", s.responseTimeout, series)
+			s.warnCh <- storepb.NewWarnSeriesResponse(storepb.NewStoreWarning(s.storeID, err))
 			return
 		}
 		if err != nil {
-			s.warnCh <- storepb.NewWarnSeriesResponse(errors.Wrap(err, "receive series"))
+			err = errors.Wrap(err, "receive series")
+			s.warnCh <- storepb.NewWarnSeriesResponse(storepb.NewStoreWarning(s.storeID, err))
 			return
 		}
 
 		if w := r.GetWarning(); w != "" {
+			s.span.LogKV("warning", w)
 			s.warnCh <- storepb.NewWarnSeriesResponse(errors.New(w))
 			continue
 		}
+		// Hints of each store are passed on as they are. Clients sum them up.
+		if h := r.GetHints(); h != nil {
+			s.span.LogKV("hinted_series", h.Series, "hinted_chunks", h.Chunks)
+			s.warnCh <- r
+			continue
+		}
+		if s.hintsOnly {
+			s.span.LogKV("msg", "store does not support hints only requests")
+			return
+		}
+		series++
 		s.recvCh <- r.GetSeries()
 	}
 }
 
+// timedOut returns true if the stream was canceled because the store exceeded the response timeout, rather
+// than because the whole request was canceled or timed out.
+func (s *streamSeriesSet) timedOut() bool {
+	return s.responseTimeout > 0 && s.streamCtx.Err() == context.DeadlineExceeded && s.ctx.Err() == nil
+}
+
 // Next blocks until new message is received or stream is closed.
 func (s *streamSeriesSet) Next() (ok bool) {
 	s.currSeries, ok = <-s.recvCh
@@ -243,7 +617,40 @@ func storeMatches(s Client, mint, maxt int64, matchers ...storepb.LabelMatcher)
 func (s *ProxyStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest) (
 	*storepb.LabelNamesResponse, error,
 ) {
-	return nil, status.Error(codes.Unimplemented, "not implemented")
+	var (
+		warnings []string
+		all      [][]string
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+	)
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
+	for _, st := range stores {
+		wg.Add(1)
+		go func(st Client) {
+			defer wg.Done()
+			resp, err := st.LabelNames(ctx, &storepb.LabelNamesRequest{})
+			if err != nil {
+				mtx.Lock()
+				warnings = append(warnings, s.labelErrWarning("label_names", st, err))
+				mtx.Unlock()
+				return
+			}
+
+			mtx.Lock()
+			warnings = append(warnings, resp.Warnings...)
+			all = append(all, resp.Names)
+			mtx.Unlock()
+		}(st)
+	}
+
+	wg.Wait()
+	return &storepb.LabelNamesResponse{
+		Names:    strutil.MergeUnsortedSlices(all...),
+		Warnings: warnings,
+	}, nil
 }
 
 // LabelValues returns all known label values for a given label name.
@@ -262,14 +669,14 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 	}
 	for _, st := range stores {
 		wg.Add(1)
-		go func(s Client) {
+		go func(st Client) {
 			defer wg.Done()
-			resp, err := s.LabelValues(ctx, &storepb.LabelValuesRequest{
+			resp, err := st.LabelValues(ctx, &storepb.LabelValuesRequest{
 				Label: r.Label,
 			})
 			if err != nil {
 				mtx.Lock()
-				warnings = append(warnings, errors.Wrap(err, "fetch label values").Error())
+				warnings = append(warnings, s.labelErrWarning("label_values", st, err))
 				mtx.Unlock()
 				return
 			}
@@ -289,3 +696,114 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		Warnings: warnings,
 	}, nil
 }
+
+// MetricMetadata returns the metadata of metrics known to any of the stores. Identical entries
+// of different stores are returned once. Stores that do not support metadata are skipped.
+func (s *ProxyStore) MetricMetadata(ctx context.Context, r *storepb.MetricMetadataRequest) (
+	*storepb.MetricMetadataResponse, error,
+) {
+	var (
+		warnings []string
+		all      [][]storepb.MetricMetadata
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+	)
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
+	for _, st := range stores {
+		wg.Add(1)
+		go func(st Client) {
+			defer wg.Done()
+			resp, err := st.MetricMetadata(ctx, &storepb.MetricMetadataRequest{
+				Metric: r.Metric,
+			})
+			if err != nil {
+				if se, ok := status.FromError(err); ok && se.Code() == codes.Unimplemented {
+					return
+				}
+				mtx.Lock()
+				warnings = append(warnings, s.labelErrWarning("metric_metadata", st, err))
+				mtx.Unlock()
+				return
+			}
+
+			mtx.Lock()
+			warnings = append(warnings, resp.Warnings...)
+			all = append(all, resp.Metadata)
+			mtx.Unlock()
+		}(st)
+	}
+
+	wg.Wait()
+	return &storepb.MetricMetadataResponse{
+		Metadata: storepb.MergeMetricMetadata(all...),
+		Warnings: warnings,
+	}, nil
+}
+
+// Exemplars returns the exemplars of all stores that may hold data for the time range and the
+// selectors of the query. Exemplars of the same series from different stores are merged.
+// Stores that do not support exemplars are skipped.
+func (s *ProxyStore) Exemplars(ctx context.Context, r *storepb.ExemplarsRequest) (
+	*storepb.ExemplarsResponse, error,
+) {
+	_, selectors, err := exemplarSelectors(r.Query, nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var (
+		warnings []string
+		all      [][]storepb.ExemplarData
+		mtx      sync.Mutex
+		wg       sync.WaitGroup
+	)
+	stores, err := s.stores(ctx)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
+	for _, st := range stores {
+		if !exemplarStoreMatches(st, r.MinTime, r.MaxTime, selectors) {
+			continue
+		}
+		wg.Add(1)
+		go func(st Client) {
+			defer wg.Done()
+			resp, err := st.Exemplars(ctx, r)
+			if err != nil {
+				if se, ok := status.FromError(err); ok && se.Code() == codes.Unimplemented {
+					return
+				}
+				mtx.Lock()
+				warnings = append(warnings, storepb.NewStoreWarning(st.String(), errors.Wrap(err, "fetch exemplars")).Error())
+				mtx.Unlock()
+				return
+			}
+
+			mtx.Lock()
+			warnings = append(warnings, resp.Warnings...)
+			all = append(all, resp.Data)
+			mtx.Unlock()
+		}(st)
+	}
+
+	wg.Wait()
+	return &storepb.ExemplarsResponse{
+		Data:     storepb.MergeExemplars(all...),
+		Warnings: warnings,
+	}, nil
+}
+
+// labelErrWarning converts an error of a label RPC against the given store into a warning.
+// Stores reject label requests that exceed their limits with ResourceExhausted, in which
+// case the result is truncated by leaving out that store's response.
+func (s *ProxyStore) labelErrWarning(rpc string, st Client, err error) string {
+	w := storepb.NewStoreWarning(st.String(), errors.Wrapf(err, "fetch %s", rpc))
+
+	if se, ok := status.FromError(err); ok && se.Code() == codes.ResourceExhausted {
+		s.truncatedLabelResponses.WithLabelValues(rpc).Inc()
+		w.Message = fmt.Sprintf("%s response truncated, store exceeded its limits: %s", rpc, se.Message())
+	}
+	return w.Error()
+}
@@ -2,7 +2,10 @@ package store
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"math"
+	"strings"
 	"testing"
 
 	"time"
@@ -16,6 +19,7 @@ import (
 	tlabels "github.com/prometheus/tsdb/labels"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -26,6 +30,7 @@ type testClient struct {
 	labels  []storepb.Label
 	minTime int64
 	maxTime int64
+	addr    string
 }
 
 func (c *testClient) Labels() []storepb.Label {
@@ -37,6 +42,9 @@ func (c *testClient) TimeRange() (int64, int64) {
 }
 
 func (c *testClient) String() string {
+	if c.addr != "" {
+		return c.addr
+	}
 	return "test"
 }
 
@@ -93,9 +101,10 @@ func TestQueryStore_Series(t *testing.T) {
 			maxTime: 302,
 		},
 	}
-	q := NewProxyStore(nil,
+	q := NewProxyStore(nil, nil,
 		func(context.Context) ([]Client, error) { return cls, nil },
 		tlabels.FromStrings("fed", "a"),
+		ProxyStoreOptions{},
 	)
 
 	ctx := context.Background()
@@ -169,6 +178,174 @@ func TestQueryStore_Series(t *testing.T) {
 	testutil.Equals(t, 2, len(s2.Warnings))
 }
 
+func TestProxyStore_LabelValues_Truncated(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				Values: map[string][]string{"a": {"1", "2"}},
+			},
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				LabelsErr: status.Error(codes.ResourceExhausted, "label request exceeded limit of 2 entries"),
+			},
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				Values: map[string][]string{"a": {"3"}},
+			},
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	resp, err := q.LabelValues(context.Background(), &storepb.LabelValuesRequest{Label: "a"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []string{"1", "2", "3"}, resp.Values)
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Assert(t, strings.Contains(resp.Warnings[0], "truncated"), "expected truncation warning, got %q", resp.Warnings[0])
+}
+
+type shardingClient struct {
+	testClient
+}
+
+func (c *shardingClient) SupportsSharding() bool {
+	return true
+}
+
+func TestProxyStore_Info_SupportsSharding(t *testing.T) {
+	var cls []Client
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+	for _, tcase := range []struct {
+		stores   []Client
+		expected bool
+	}{
+		{stores: nil, expected: false},
+		{stores: []Client{&shardingClient{}, &shardingClient{}}, expected: true},
+		// A single store returning all series to every shard makes sharding useless.
+		{stores: []Client{&shardingClient{}, &testClient{}}, expected: false},
+	} {
+		cls = tcase.stores
+
+		resp, err := q.Info(context.Background(), &storepb.InfoRequest{})
+		testutil.Ok(t, err)
+		testutil.Equals(t, tcase.expected, resp.SupportsSharding)
+	}
+}
+
+func TestProxyStore_MetricMetadata(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				Metadata: []storepb.MetricMetadata{
+					{Metric: "up", Type: "gauge", Help: "Target is up."},
+					{Metric: "http_requests_total", Type: "counter", Help: "Number of requests."},
+				},
+			},
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				Metadata: []storepb.MetricMetadata{
+					{Metric: "up", Type: "gauge", Help: "Target is up."},
+					{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests."},
+				},
+			},
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				MetadataErr: status.Error(codes.Unimplemented, "not implemented"),
+			},
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				MetadataErr: status.Error(codes.Unavailable, "connection refused"),
+			},
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	resp, err := q.MetricMetadata(context.Background(), &storepb.MetricMetadataRequest{})
+	testutil.Ok(t, err)
+	// Identical entries are merged, conflicting ones are all returned.
+	testutil.Equals(t, []storepb.MetricMetadata{
+		{Metric: "http_requests_total", Type: "counter", Help: "Number of requests."},
+		{Metric: "http_requests_total", Type: "counter", Help: "Total HTTP requests."},
+		{Metric: "up", Type: "gauge", Help: "Target is up."},
+	}, resp.Metadata)
+	// Stores without metadata support are not reported as failures.
+	testutil.Equals(t, 1, len(resp.Warnings))
+	testutil.Assert(t, strings.Contains(resp.Warnings[0], "connection refused"), "unexpected warning %q", resp.Warnings[0])
+}
+
+func TestProxyStore_Exemplars(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	series := []storepb.Label{{Name: "__name__", Value: "up"}, {Name: "region", Value: "eu-west"}}
+	eu1 := &storeClient{ExemplarData: []storepb.ExemplarData{{
+		SeriesLabels: series,
+		Exemplars: []storepb.Exemplar{
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "a"}}, Value: 1, Ts: 1000},
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "c"}}, Value: 1, Ts: 3000},
+		},
+	}}}
+	eu2 := &storeClient{ExemplarData: []storepb.ExemplarData{{
+		SeriesLabels: series,
+		Exemplars: []storepb.Exemplar{
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "a"}}, Value: 1, Ts: 1000},
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "b"}}, Value: 1, Ts: 2000},
+		},
+	}}}
+	us := &storeClient{}
+	old := &storeClient{ExemplarsErr: status.Error(codes.Unimplemented, "not implemented")}
+
+	cls := []Client{
+		&testClient{StoreClient: eu1, labels: []storepb.Label{{Name: "region", Value: "eu-west"}}, minTime: 0, maxTime: 10000},
+		&testClient{StoreClient: eu2, labels: []storepb.Label{{Name: "region", Value: "eu-west"}}, minTime: 0, maxTime: 10000},
+		&testClient{StoreClient: us, labels: []storepb.Label{{Name: "region", Value: "us-east"}}, minTime: 0, maxTime: 10000},
+		&testClient{StoreClient: old, labels: []storepb.Label{{Name: "region", Value: "eu-west"}}, minTime: 0, maxTime: 10000},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	req := &storepb.ExemplarsRequest{Query: `rate(up{region="eu-west"}[5m])`, MinTime: 500, MaxTime: 5000}
+	resp, err := q.Exemplars(context.Background(), req)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Warnings))
+	testutil.Equals(t, req, eu1.ExemplarsReq)
+	testutil.Assert(t, us.ExemplarsReq == nil, "store with non-matching external labels must not be queried")
+
+	testutil.Equals(t, []storepb.ExemplarData{{
+		SeriesLabels: series,
+		Exemplars: []storepb.Exemplar{
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "a"}}, Value: 1, Ts: 1000},
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "b"}}, Value: 1, Ts: 2000},
+			{Labels: []storepb.Label{{Name: "trace_id", Value: "c"}}, Value: 1, Ts: 3000},
+		},
+	}}, resp.Data)
+
+	_, err = q.Exemplars(context.Background(), &storepb.ExemplarsRequest{Query: "up{"})
+	testutil.NotOk(t, err)
+}
+
 func TestStoreMatches(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -254,6 +431,7 @@ type storeSeriesServer struct {
 
 	SeriesSet []storepb.Series
 	Warnings  []string
+	Hints     []storepb.SeriesHints
 }
 
 func newStoreSeriesServer(ctx context.Context) *storeSeriesServer {
@@ -266,6 +444,11 @@ func (s *storeSeriesServer) Send(r *storepb.SeriesResponse) error {
 		return nil
 	}
 
+	if h := r.GetHints(); h != nil {
+		s.Hints = append(s.Hints, *h)
+		return nil
+	}
+
 	if r.GetSeries() == nil {
 		return errors.New("no seriesSet")
 	}
@@ -277,11 +460,128 @@ func (s *storeSeriesServer) Context() context.Context {
 	return s.ctx
 }
 
+func TestProxyStore_Series_TimeSplit(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	a := []storepb.Label{{Name: "replica", Value: "a"}}
+	historical := &storeClient{}
+	live := &storeClient{}
+	// The historical store of another replica lags behind, it must not move the split of the first one.
+	laggingHistorical := &storeClient{}
+	laggingLive := &storeClient{}
+	// Without a live store of its replica, the historical store serves the whole range.
+	onlyHistorical := &storeClient{}
+	cls := []Client{
+		&testClient{StoreClient: historical, labels: a, minTime: 0, maxTime: now - 3*3600*1000},
+		&testClient{StoreClient: live, labels: a, minTime: now - 24*3600*1000, maxTime: math.MaxInt64},
+		&testClient{StoreClient: laggingHistorical, labels: []storepb.Label{{Name: "replica", Value: "b"}}, minTime: 0, maxTime: now - 5*3600*1000},
+		&testClient{StoreClient: laggingLive, labels: []storepb.Label{{Name: "replica", Value: "b"}}, minTime: now - 24*3600*1000, maxTime: math.MaxInt64},
+		&testClient{StoreClient: onlyHistorical, labels: []storepb.Label{{Name: "replica", Value: "c"}}, minTime: 0, maxTime: now - 3*3600*1000},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{
+			TimeSplitOffset: 2 * time.Hour,
+		},
+	)
+
+	// The split is at the newest data of the historical store of each replica as it is older than the offset.
+	split := now - 3*3600*1000
+	laggingSplit := now - 5*3600*1000
+
+	for _, c := range []struct {
+		mint, maxt int64
+
+		historical, live, onlyHistorical *storepb.SeriesRequest
+	}{
+		{
+			mint:           0,
+			maxt:           now,
+			historical:     &storepb.SeriesRequest{MinTime: 0, MaxTime: split},
+			live:           &storepb.SeriesRequest{MinTime: split + 1, MaxTime: now},
+			onlyHistorical: &storepb.SeriesRequest{MinTime: 0, MaxTime: now},
+		},
+		{
+			mint:           split - 1000,
+			maxt:           split,
+			historical:     &storepb.SeriesRequest{MinTime: split - 1000, MaxTime: split},
+			onlyHistorical: &storepb.SeriesRequest{MinTime: split - 1000, MaxTime: split},
+		},
+		{
+			mint: split + 1000,
+			maxt: now,
+			live: &storepb.SeriesRequest{MinTime: split + 1000, MaxTime: now},
+		},
+	} {
+		historical.SeriesReq, live.SeriesReq, onlyHistorical.SeriesReq = nil, nil, nil
+
+		testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: c.mint, MaxTime: c.maxt}, newStoreSeriesServer(context.Background())))
+		testutil.Equals(t, c.historical, historical.SeriesReq)
+		testutil.Equals(t, c.live, live.SeriesReq)
+		testutil.Equals(t, c.onlyHistorical, onlyHistorical.SeriesReq)
+	}
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: now}, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: 0, MaxTime: laggingSplit}, laggingHistorical.SeriesReq)
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: laggingSplit + 1, MaxTime: now}, laggingLive.SeriesReq)
+}
+
+func TestProxyStore_Series_TimeSplit_StoreGateway(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	// Store gateways advertise no external labels, they serve the blocks of all sidecars.
+	gateway := &storeClient{}
+	laggingGateway := &storeClient{}
+	liveA := &storeClient{}
+	liveB := &storeClient{}
+	cls := []Client{
+		&testClient{StoreClient: gateway, minTime: 0, maxTime: now - 3*3600*1000},
+		&testClient{StoreClient: laggingGateway, minTime: 0, maxTime: now - 5*3600*1000},
+		&testClient{StoreClient: liveA, labels: []storepb.Label{{Name: "replica", Value: "a"}}, minTime: now - 24*3600*1000, maxTime: math.MaxInt64},
+		&testClient{StoreClient: liveB, labels: []storepb.Label{{Name: "replica", Value: "b"}}, minTime: now - 24*3600*1000, maxTime: math.MaxInt64},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{
+			TimeSplitOffset: 2 * time.Hour,
+		},
+	)
+
+	// The split is at the newest data of any gateway.
+	split := now - 3*3600*1000
+
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{MinTime: 0, MaxTime: now}, newStoreSeriesServer(context.Background())))
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: 0, MaxTime: split}, gateway.SeriesReq)
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: 0, MaxTime: split}, laggingGateway.SeriesReq)
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: split + 1, MaxTime: now}, liveA.SeriesReq)
+	testutil.Equals(t, &storepb.SeriesRequest{MinTime: split + 1, MaxTime: now}, liveB.SeriesReq)
+}
+
 // storeClient is test gRPC store API client.
 type storeClient struct {
-	Values map[string][]string
+	Values    map[string][]string
+	LabelsErr error
+
+	Metadata    []storepb.MetricMetadata
+	MetadataErr error
 
-	RespSet []*storepb.SeriesResponse
+	ExemplarData []storepb.ExemplarData
+	ExemplarsErr error
+	// ExemplarsReq is the last received exemplars request.
+	ExemplarsReq *storepb.ExemplarsRequest
+
+	RespSet   []*storepb.SeriesResponse
+	SeriesErr error
+	// SeriesTrailer is sent once all series were received.
+	SeriesTrailer metadata.MD
+	// SeriesReq is the last received series request.
+	SeriesReq *storepb.SeriesRequest
 }
 
 func (s *storeClient) Info(ctx context.Context, req *storepb.InfoRequest, _ ...grpc.CallOption) (*storepb.InfoResponse, error) {
@@ -289,7 +589,11 @@ func (s *storeClient) Info(ctx context.Context, req *storepb.InfoRequest, _ ...g
 }
 
 func (s *storeClient) Series(ctx context.Context, req *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
-	return &StoreSeriesClient{ctx: ctx, respSet: s.RespSet}, nil
+	s.SeriesReq = req
+	if s.SeriesErr != nil {
+		return nil, s.SeriesErr
+	}
+	return &StoreSeriesClient{ctx: ctx, respSet: s.RespSet, trailer: s.SeriesTrailer}, nil
 }
 
 func (s *storeClient) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest, _ ...grpc.CallOption) (*storepb.LabelNamesResponse, error) {
@@ -297,9 +601,393 @@ func (s *storeClient) LabelNames(ctx context.Context, req *storepb.LabelNamesReq
 }
 
 func (s *storeClient) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest, _ ...grpc.CallOption) (*storepb.LabelValuesResponse, error) {
+	if s.LabelsErr != nil {
+		return nil, s.LabelsErr
+	}
 	return &storepb.LabelValuesResponse{Values: s.Values[req.Label]}, nil
 }
 
+func (s *storeClient) MetricMetadata(ctx context.Context, req *storepb.MetricMetadataRequest, _ ...grpc.CallOption) (*storepb.MetricMetadataResponse, error) {
+	if s.MetadataErr != nil {
+		return nil, s.MetadataErr
+	}
+	return &storepb.MetricMetadataResponse{Metadata: s.Metadata}, nil
+}
+
+func (s *storeClient) Exemplars(ctx context.Context, req *storepb.ExemplarsRequest, _ ...grpc.CallOption) (*storepb.ExemplarsResponse, error) {
+	s.ExemplarsReq = req
+	if s.ExemplarsErr != nil {
+		return nil, s.ExemplarsErr
+	}
+	return &storepb.ExemplarsResponse{Data: s.ExemplarData}, nil
+}
+
+func TestProxyStore_Series_ResponseTimeout(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}, {2, 2}}),
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "fast"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			StoreClient: &slowStoreClient{
+				storeClient: storeClient{
+					RespSet: []*storepb.SeriesResponse{
+						storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+					},
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "slow"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{
+			ResponseTimeout: 100 * time.Millisecond,
+		},
+	)
+
+	s := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}, s))
+
+	// Series the slow store sent before it was abandoned are kept.
+	testutil.Equals(t, 2, len(s.SeriesSet))
+	testutil.Equals(t, 1, len(s.Warnings))
+	testutil.Assert(t, strings.Contains(s.Warnings[0], "response timeout of 100ms exceeded"), "unexpected warning %q", s.Warnings[0])
+}
+
+func TestProxyStore_Series_Canceled(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &slowStoreClient{
+				storeClient: storeClient{
+					RespSet: []*storepb.SeriesResponse{
+						storeSeriesResponse(t, labels.FromStrings("a", "a"), []sample{{1, 1}}),
+					},
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "slow"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	// The slow store only returns once its stream is canceled along with the request.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	s := newStoreSeriesServer(ctx)
+	err := q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}, s)
+	st, ok := status.FromError(err)
+	testutil.Assert(t, ok, "expected gRPC status error, got %v", err)
+	testutil.Equals(t, codes.Canceled, st.Code())
+	// The canceled stream is not reported as partial response.
+	testutil.Equals(t, 0, len(s.Warnings))
+}
+
+func TestProxyStore_Series_RequiredStores(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "1"}},
+			minTime: 1,
+			maxTime: 300,
+			addr:    "store-a:10901",
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				SeriesErr: errors.New("connection refused"),
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "2"}},
+			minTime: 1,
+			maxTime: 300,
+			addr:    "store-b:10901",
+		},
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, c := range []struct {
+		required []string
+		err      string
+	}{
+		{required: nil},
+		{required: []string{"store-a:.*"}},
+		{required: []string{"store-a:.*", "store-b:.*"}, err: "required stores unavailable: store-b:10901"},
+	} {
+		required, err := NewRequiredStores(c.required)
+		testutil.Ok(t, err)
+
+		q := NewProxyStore(nil, nil,
+			func(context.Context) ([]Client, error) { return cls, nil },
+			nil,
+			ProxyStoreOptions{
+				RequiredStores: required,
+			},
+		)
+
+		s := newStoreSeriesServer(context.Background())
+		err = q.Series(req, s)
+		if c.err == "" {
+			// Failing stores that are not required only degrade the response.
+			testutil.Ok(t, err)
+			testutil.Equals(t, 1, len(s.SeriesSet))
+			testutil.Equals(t, 1, len(s.Warnings))
+			continue
+		}
+		st, ok := status.FromError(err)
+		testutil.Assert(t, ok, "expected gRPC status error, got %v", err)
+		testutil.Equals(t, codes.Unavailable, st.Code())
+		testutil.Assert(t, strings.Contains(st.Message(), c.err), "unexpected error %q", err)
+	}
+}
+
+func TestProxyStore_Series_FetchedBytes(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+				},
+				SeriesTrailer: metadata.Pairs(FetchedBytesTrailer, "100"),
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "1"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "c"), []sample{{1, 1}}),
+				},
+				SeriesTrailer: metadata.Pairs(FetchedBytesTrailer, "23"),
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "2"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			// Stores that do not report fetched bytes, like sidecars, are fine.
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "d"), []sample{{1, 1}}),
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "3"}},
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	rs := &ResourceStats{}
+	s := newStoreSeriesServer(WithResourceStats(context.Background(), rs))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}, s))
+
+	testutil.Equals(t, 3, len(s.SeriesSet))
+	testutil.Equals(t, int64(123), rs.FetchedBytes())
+}
+
+func TestProxyStore_Series_SourceResolutions(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	cls := []Client{
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "b"), []sample{{1, 1}}),
+				},
+				SeriesTrailer: metadata.MD{SourceResolutionsTrailer: []string{"3600000", "0"}},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "1"}},
+			addr:    "store-1",
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "c"), []sample{{1, 1}}),
+				},
+				SeriesTrailer: metadata.Pairs(SourceResolutionsTrailer, "0"),
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "2"}},
+			addr:    "store-2",
+			minTime: 1,
+			maxTime: 300,
+		},
+		&testClient{
+			// Stores that do not report resolutions are left out.
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "d"), []sample{{1, 1}}),
+				},
+			},
+			labels:  []storepb.Label{{Name: "ext", Value: "3"}},
+			addr:    "store-3",
+			minTime: 1,
+			maxTime: 300,
+		},
+	}
+	q := NewProxyStore(nil, nil,
+		func(context.Context) ([]Client, error) { return cls, nil },
+		nil,
+		ProxyStoreOptions{},
+	)
+
+	sr := &SourceResolutions{}
+	s := newStoreSeriesServer(WithSourceResolutions(context.Background(), sr))
+	testutil.Ok(t, q.Series(&storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}, s))
+
+	testutil.Equals(t, 3, len(s.SeriesSet))
+	testutil.Equals(t, map[string][]int64{
+		"store-1": {0, 3600000},
+		"store-2": {0},
+	}, sr.ByStore())
+}
+
+func TestProxyStore_Series_MergeConcurrency(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Stores share some series, whose chunks must be merged in the order of the stores.
+	var cls []Client
+	for i := 0; i < 7; i++ {
+		cls = append(cls, &testClient{
+			StoreClient: &storeClient{
+				RespSet: []*storepb.SeriesResponse{
+					storeSeriesResponse(t, labels.FromStrings("a", "shared"), []sample{{int64(i), float64(i)}}),
+					storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("store-%d", i)), []sample{{1, 1}}),
+					storeSeriesResponse(t, labels.FromStrings("a", fmt.Sprintf("x-%d", i%3)), []sample{{int64(i), float64(i)}}),
+				},
+			},
+			minTime: 1,
+			maxTime: 300,
+		})
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}
+
+	expected := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, NewProxyStore(nil, nil, func(context.Context) ([]Client, error) { return cls, nil }, nil, ProxyStoreOptions{MergeConcurrency: 1}).Series(req, expected))
+	testutil.Equals(t, 11, len(expected.SeriesSet))
+
+	for _, concurrency := range []int{2, 3, 7, 100} {
+		s := newStoreSeriesServer(context.Background())
+		testutil.Ok(t, NewProxyStore(nil, nil, func(context.Context) ([]Client, error) { return cls, nil }, nil, ProxyStoreOptions{MergeConcurrency: concurrency}).Series(req, s))
+		testutil.Equals(t, expected.SeriesSet, s.SeriesSet)
+	}
+}
+
+func BenchmarkProxyStore_Series_Merge(b *testing.B) {
+	const (
+		numStores = 200
+		numSeries = 100
+	)
+	// Every store holds a distinct replica of the same series, like a highly available setup would.
+	cls := make([]Client, 0, numStores)
+	for i := 0; i < numStores; i++ {
+		var resps []*storepb.SeriesResponse
+		for j := 0; j < numSeries; j++ {
+			resps = append(resps, storeSeriesResponse(b, labels.FromStrings("a", fmt.Sprintf("%04d", j), "replica", fmt.Sprintf("%04d", i)), []sample{{1, 1}}))
+		}
+		cls = append(cls, &testClient{
+			StoreClient: &storeClient{RespSet: resps},
+			minTime:     1,
+			maxTime:     300,
+		})
+	}
+	req := &storepb.SeriesRequest{
+		MinTime:  1,
+		MaxTime:  300,
+		Matchers: []storepb.LabelMatcher{{Name: "a", Value: ".*", Type: storepb.LabelMatcher_RE}},
+	}
+
+	for _, concurrency := range []int{1, 4, 16} {
+		q := NewProxyStore(nil, nil, func(context.Context) ([]Client, error) { return cls, nil }, nil, ProxyStoreOptions{MergeConcurrency: concurrency})
+
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s := newStoreSeriesServer(context.Background())
+				testutil.Ok(b, q.Series(req, s))
+				testutil.Equals(b, numStores*numSeries, len(s.SeriesSet))
+			}
+		})
+	}
+}
+
+// slowStoreClient sends its series and then blocks until the request is canceled.
+type slowStoreClient struct {
+	storeClient
+}
+
+func (s *slowStoreClient) Series(ctx context.Context, req *storepb.SeriesRequest, _ ...grpc.CallOption) (storepb.Store_SeriesClient, error) {
+	return &slowSeriesClient{StoreSeriesClient: StoreSeriesClient{ctx: ctx, respSet: s.RespSet}}, nil
+}
+
+type slowSeriesClient struct {
+	StoreSeriesClient
+}
+
+func (c *slowSeriesClient) Recv() (*storepb.SeriesResponse, error) {
+	if c.i >= len(c.respSet) {
+		<-c.ctx.Done()
+		return nil, c.ctx.Err()
+	}
+	return c.StoreSeriesClient.Recv()
+}
+
 // StoreSeriesClient is test gRPC storeAPI series client.
 type StoreSeriesClient struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
@@ -307,6 +995,7 @@ type StoreSeriesClient struct {
 	ctx     context.Context
 	i       int
 	respSet []*storepb.SeriesResponse
+	trailer metadata.MD
 }
 
 func (c *StoreSeriesClient) Recv() (*storepb.SeriesResponse, error) {
@@ -323,6 +1012,10 @@ func (c *StoreSeriesClient) Context() context.Context {
 	return c.ctx
 }
 
+func (c *StoreSeriesClient) Trailer() metadata.MD {
+	return c.trailer
+}
+
 // storeSeriesResponse creates test storepb.SeriesResponse that includes series with single chunk that stores all the given samples.
 func storeSeriesResponse(t testing.TB, lset labels.Labels, smpls []sample) *storepb.SeriesResponse {
 	var s storepb.Series
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/relabel"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RelabelStore applies relabel configs to the labels of all series of the wrapped store before serving them,
+// e.g. to drop noisy series or to rewrite labels. Series are served if their relabeled labels match the request.
+// Matchers on labels that may be rewritten are not passed to the wrapped store, as it selects series by their
+// original labels. Label names and values are served as is.
+type RelabelStore struct {
+	storepb.StoreServer
+	cfgs []*config.RelabelConfig
+
+	// rewrites is true if any config changes labels instead of only dropping or keeping series.
+	rewrites bool
+
+	relabeled prometheus.Counter
+	dropped   prometheus.Counter
+}
+
+// NewRelabelStore returns a store serving the series of the given store relabeled with the given configs.
+// Configs writing labels whose names depend on the series are rejected, as any matcher could refer to such
+// a label, so that every request would select all series of the wrapped store.
+func NewRelabelStore(reg prometheus.Registerer, s storepb.StoreServer, cfgs []*config.RelabelConfig) (*RelabelStore, error) {
+	r := &RelabelStore{
+		StoreServer: s,
+		cfgs:        cfgs,
+		relabeled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_series_relabeled_total",
+			Help: "Total number of series of which the labels were changed by relabeling.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "thanos_store_series_relabel_dropped_total",
+			Help: "Total number of series dropped by relabeling.",
+		}),
+	}
+	for i, cfg := range cfgs {
+		switch cfg.Action {
+		case config.RelabelDrop, config.RelabelKeep:
+			continue
+		case config.RelabelReplace:
+			// The target label of replace actions may reference capture groups of the regex.
+			if strings.Contains(cfg.TargetLabel, "$") {
+				return nil, errors.Errorf("relabel config %d: target label %q referencing the regex is not supported", i, cfg.TargetLabel)
+			}
+		case config.RelabelLabelMap:
+			if strings.Contains(cfg.Replacement, "$") {
+				return nil, errors.Errorf("relabel config %d: labelmap replacement %q referencing the regex is not supported", i, cfg.Replacement)
+			}
+		}
+		r.rewrites = true
+	}
+	if reg != nil {
+		reg.MustRegister(r.relabeled, r.dropped)
+	}
+	return r, nil
+}
+
+// rewritten returns true if any config may change the value of the label with the given name.
+func (s *RelabelStore) rewritten(name string) bool {
+	for _, cfg := range s.cfgs {
+		switch cfg.Action {
+		case config.RelabelReplace, config.RelabelHashMod:
+			if name == cfg.TargetLabel {
+				return true
+			}
+		case config.RelabelLabelMap:
+			if name == cfg.Replacement {
+				return true
+			}
+		case config.RelabelLabelDrop:
+			if cfg.Regex.MatchString(name) {
+				return true
+			}
+		case config.RelabelLabelKeep:
+			if !cfg.Regex.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Series streams the relabeled series of the wrapped store. If labels are rewritten, the order of series may change
+// and different series may become equal, so all series are buffered and sorted before they are sent, merging equal
+// series. Otherwise series are streamed as they are received.
+func (s *RelabelStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := translateMatchers(r.Matchers)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.rewrites {
+		return s.StoreServer.Series(r, &relabelSeriesServer{Store_SeriesServer: srv, store: s})
+	}
+	req := *r
+	req.Matchers = s.selectMatchers(r.Matchers)
+
+	rsrv := &relabelSeriesServer{Store_SeriesServer: srv, store: s, matchers: matchers, buffer: true}
+	if err := s.StoreServer.Series(&req, rsrv); err != nil {
+		return err
+	}
+	return rsrv.flush()
+}
+
+// selectMatchers returns the matchers to select series from the wrapped store with. Matchers on labels that may be
+// rewritten are left out, as series with any original value of them may match after relabeling. If no matcher is
+// left, all series are selected.
+func (s *RelabelStore) selectMatchers(ms []storepb.LabelMatcher) []storepb.LabelMatcher {
+	var res []storepb.LabelMatcher
+	for _, m := range ms {
+		if !s.rewritten(m.Name) {
+			res = append(res, m)
+		}
+	}
+	if len(res) == 0 {
+		res = []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "__name__", Value: ".+"}}
+	}
+	return res
+}
+
+// relabel returns the relabeled labels, or nil if the series is dropped.
+func (s *RelabelStore) relabel(lset []storepb.Label) promlabels.Labels {
+	pl := make(promlabels.Labels, 0, len(lset))
+	for _, l := range lset {
+		pl = append(pl, promlabels.Label{Name: l.Name, Value: l.Value})
+	}
+	res := relabel.Process(pl, s.cfgs...)
+	if res == nil {
+		s.dropped.Inc()
+		return nil
+	}
+	if !promlabels.Equal(res, pl) {
+		s.relabeled.Inc()
+	}
+	return res
+}
+
+type relabelSeriesServer struct {
+	storepb.Store_SeriesServer
+	store    *RelabelStore
+	matchers []labels.Matcher
+
+	// buffer is set if series are relabeled and have to be sorted before they are sent.
+	buffer bool
+	series []storepb.Series
+}
+
+func (r *relabelSeriesServer) Send(resp *storepb.SeriesResponse) error {
+	series := resp.GetSeries()
+	if series == nil {
+		return r.Store_SeriesServer.Send(resp)
+	}
+	lset := r.store.relabel(series.Labels)
+	if lset == nil {
+		return nil
+	}
+	if !r.buffer {
+		// Series are only dropped or kept, so their labels still match the request.
+		return r.Store_SeriesServer.Send(resp)
+	}
+	for _, m := range r.matchers {
+		if !m.Matches(lset.Get(m.Name())) {
+			return nil
+		}
+	}
+	s := storepb.Series{
+		Labels: make([]storepb.Label, 0, len(lset)),
+		Chunks: series.Chunks,
+	}
+	for _, l := range lset {
+		s.Labels = append(s.Labels, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+	r.series = append(r.series, s)
+	return nil
+}
+
+// flush sends the buffered series in order. The chunks of equal series are merged into a single series.
+func (r *relabelSeriesServer) flush() error {
+	sort.SliceStable(r.series, func(i, j int) bool {
+		return storepb.CompareLabels(r.series[i].Labels, r.series[j].Labels) < 0
+	})
+	for i := 0; i < len(r.series); {
+		s := r.series[i]
+		for i++; i < len(r.series) && storepb.CompareLabels(s.Labels, r.series[i].Labels) == 0; i++ {
+			s.Chunks = append(s.Chunks, r.series[i].Chunks...)
+		}
+		sort.SliceStable(s.Chunks, func(j, k int) bool {
+			return s.Chunks[j].MinTime < s.Chunks[k].MinTime
+		})
+		if err := r.Store_SeriesServer.Send(storepb.NewSeriesResponse(&s)); err != nil {
+			return status.Error(codes.Unknown, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type seriesStore struct {
+	storepb.StoreServer
+	series []storepb.Series
+
+	matchers []storepb.LabelMatcher
+}
+
+func (s *seriesStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	s.matchers = r.Matchers
+	for i := range s.series {
+		if err := srv.Send(storepb.NewSeriesResponse(&s.series[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestRelabelStore_Series(t *testing.T) {
+	var cfgs []*config.RelabelConfig
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte(`
+- source_labels: [__name__]
+  regex: noisy
+  action: drop
+- source_labels: [instance]
+  target_label: instance
+  regex: '([^:]+):.*'
+`), &cfgs))
+
+	chk := func(mint, maxt int64) storepb.AggrChunk {
+		return storepb.AggrChunk{MinTime: mint, MaxTime: maxt, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR}}
+	}
+	series := func(name, instance string, chks ...storepb.AggrChunk) storepb.Series {
+		return storepb.Series{
+			Labels: []storepb.Label{{Name: "__name__", Value: name}, {Name: "instance", Value: instance}},
+			Chunks: chks,
+		}
+	}
+	inner := &seriesStore{series: []storepb.Series{
+		series("noisy", "a:1", chk(0, 10)),
+		series("up", "a:1", chk(10, 20)),
+		series("up", "a:2", chk(0, 10)),
+		series("up", "b", chk(0, 10)),
+		series("up", "ab:1", chk(0, 10)),
+	}}
+	s, err := NewRelabelStore(nil, inner, cfgs)
+	testutil.Ok(t, err)
+
+	// Relabeled series are sorted again and equal series merged.
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, s.Series(&storepb.SeriesRequest{}, srv))
+	testutil.Equals(t, []storepb.Series{
+		series("up", "a", chk(0, 10), chk(10, 20)),
+		series("up", "ab", chk(0, 10)),
+		series("up", "b", chk(0, 10)),
+	}, srv.SeriesSet)
+
+	// Series must match the request with their relabeled labels.
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, s.Series(&storepb.SeriesRequest{Matchers: []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_RE, Name: "instance", Value: "a.*"},
+	}}, srv))
+	testutil.Equals(t, []storepb.Series{
+		series("up", "a", chk(0, 10), chk(10, 20)),
+		series("up", "ab", chk(0, 10)),
+	}, srv.SeriesSet)
+
+	// Series are selected by the rewritten value, which the wrapped store does not know.
+	srv = newStoreSeriesServer(context.Background())
+	testutil.Ok(t, s.Series(&storepb.SeriesRequest{Matchers: []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "instance", Value: "a"},
+	}}, srv))
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+	}, inner.matchers)
+	testutil.Equals(t, []storepb.Series{
+		series("up", "a", chk(0, 10), chk(10, 20)),
+	}, srv.SeriesSet)
+}
+
+func TestRelabelStore_Series_DropOnly(t *testing.T) {
+	var cfgs []*config.RelabelConfig
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte(`
+- source_labels: [__name__]
+  regex: noisy
+  action: drop
+`), &cfgs))
+
+	series := func(name, instance string) storepb.Series {
+		return storepb.Series{Labels: []storepb.Label{{Name: "__name__", Value: name}, {Name: "instance", Value: instance}}}
+	}
+	inner := &seriesStore{series: []storepb.Series{
+		series("up", "b"),
+		series("noisy", "a"),
+		series("up", "a"),
+	}}
+	s, err := NewRelabelStore(nil, inner, cfgs)
+	testutil.Ok(t, err)
+
+	// Without rewritten labels, matchers are passed on as they are and series are streamed unsorted.
+	matchers := []storepb.LabelMatcher{{Type: storepb.LabelMatcher_RE, Name: "instance", Value: "a|b"}}
+	srv := newStoreSeriesServer(context.Background())
+	testutil.Ok(t, s.Series(&storepb.SeriesRequest{Matchers: matchers}, srv))
+	testutil.Equals(t, matchers, inner.matchers)
+	testutil.Equals(t, []storepb.Series{series("up", "b"), series("up", "a")}, srv.SeriesSet)
+}
+
+func TestRelabelStore_SelectMatchers(t *testing.T) {
+	var cfgs []*config.RelabelConfig
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte(`
+- regex: pod_.*
+  action: labeldrop
+- regex: kubernetes_namespace
+  replacement: namespace
+  action: labelmap
+`), &cfgs))
+
+	s, err := NewRelabelStore(nil, &seriesStore{}, cfgs)
+	testutil.Ok(t, err)
+
+	// Only matchers on labels no config touches are passed on.
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"},
+	}, s.selectMatchers([]storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "pod_name", Value: "a"},
+		{Type: storepb.LabelMatcher_EQ, Name: "namespace", Value: "a"},
+		{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"},
+	}))
+
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte(`
+- regex: pod
+  action: labelkeep
+`), &cfgs))
+	s, err = NewRelabelStore(nil, &seriesStore{}, cfgs)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "pod", Value: "a"},
+	}, s.selectMatchers([]storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "pod", Value: "a"},
+		{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"},
+	}))
+
+	// Labels written with names taken from series could be referred to by any matcher.
+	testutil.Ok(t, yaml.UnmarshalStrict([]byte(`
+- regex: __meta_(.+)
+  replacement: $1
+  action: labelmap
+`), &cfgs))
+	_, err = NewRelabelStore(nil, &seriesStore{}, cfgs)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RemoteReadClient is a Client for a Prometheus server without a sidecar. Series are read through
+// the remote read API of the server. Remote read has no label APIs, so LabelNames and LabelValues
+// return Unimplemented.
+type RemoteReadClient struct {
+	storepb.StoreClient
+	base *url.URL
+
+	mtx        sync.RWMutex
+	labels     labels.Labels
+	mint, maxt int64
+}
+
+// NewRemoteReadClient returns a new RemoteReadClient for the Prometheus server at the given URL.
+// Until its metadata is updated, it advertises no external labels and the full time range.
+func NewRemoteReadClient(logger log.Logger, client *http.Client, baseURL *url.URL) (*RemoteReadClient, error) {
+	c := &RemoteReadClient{
+		base: baseURL,
+		mint: 0,
+		maxt: math.MaxInt64,
+	}
+	p, err := NewPrometheusStore(logger, client, baseURL, c.externalLabels, c.TimeRange)
+	if err != nil {
+		return nil, err
+	}
+	c.StoreClient = storepb.ServerAsClient(remoteReadStore{p})
+	return c, nil
+}
+
+// Update sets the external labels and the time range of the Prometheus server.
+func (c *RemoteReadClient) Update(lset labels.Labels, mint, maxt int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.labels = lset
+	c.mint = mint
+	c.maxt = maxt
+}
+
+func (c *RemoteReadClient) externalLabels() labels.Labels {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.labels
+}
+
+// Labels returns the external labels of the Prometheus server.
+func (c *RemoteReadClient) Labels() []storepb.Label {
+	lset := c.externalLabels()
+
+	res := make([]storepb.Label, 0, len(lset))
+	for _, l := range lset {
+		res = append(res, storepb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+// TimeRange returns the time range of data in the Prometheus server.
+func (c *RemoteReadClient) TimeRange() (mint int64, maxt int64) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.mint, c.maxt
+}
+
+func (c *RemoteReadClient) String() string {
+	return c.base.String()
+}
+
+// remoteReadStore restricts a PrometheusStore to the remote read API.
+type remoteReadStore struct {
+	*PrometheusStore
+}
+
+// LabelValues is not supported by remote read.
+func (remoteReadStore) LabelValues(context.Context, *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "label values are not supported by the remote read API")
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/tsdb/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRemoteReadClient_e2e(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	p, err := testutil.NewPrometheus()
+	testutil.Ok(t, err)
+
+	baseT := timestamp.FromTime(time.Now()) / 1000 * 1000
+
+	a := p.Appender()
+	a.Add(labels.FromStrings("a", "b"), baseT+100, 1)
+	a.Add(labels.FromStrings("a", "b"), baseT+200, 2)
+	testutil.Ok(t, a.Commit())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testutil.Ok(t, p.Start())
+	defer p.Stop()
+
+	u, err := url.Parse(fmt.Sprintf("http://%s", p.Addr()))
+	testutil.Ok(t, err)
+
+	c, err := NewRemoteReadClient(nil, nil, u)
+	testutil.Ok(t, err)
+	c.Update(labels.FromStrings("region", "eu-west"), baseT, math.MaxInt64)
+
+	info, err := c.Info(ctx, &storepb.InfoRequest{})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.Label{{Name: "region", Value: "eu-west"}}, info.Labels)
+	testutil.Equals(t, baseT, info.MinTime)
+
+	sc, err := c.Series(ctx, &storepb.SeriesRequest{
+		MinTime: baseT,
+		MaxTime: baseT + 200,
+		Matchers: []storepb.LabelMatcher{
+			{Type: storepb.LabelMatcher_EQ, Name: "a", Value: "b"},
+		},
+	})
+	testutil.Ok(t, err)
+
+	var series []storepb.Series
+	for {
+		r, err := sc.Recv()
+		if err == io.EOF {
+			break
+		}
+		testutil.Ok(t, err)
+		series = append(series, *r.GetSeries())
+	}
+	testutil.Equals(t, 1, len(series))
+	testutil.Equals(t, []storepb.Label{
+		{Name: "a", Value: "b"},
+		{Name: "region", Value: "eu-west"},
+	}, series[0].Labels)
+
+	_, err = c.LabelValues(ctx, &storepb.LabelValuesRequest{Label: "a"})
+	s, ok := status.FromError(err)
+	testutil.Assert(t, ok, "expected gRPC status error, got %v", err)
+	testutil.Equals(t, codes.Unimplemented, s.Code())
+}
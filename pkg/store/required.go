@@ -0,0 +1,50 @@
+package store
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// RequiredStores matches the addresses of stores without which queries fail instead of returning a partial response.
+// The zero value requires no store.
+type RequiredStores []*regexp.Regexp
+
+// NewRequiredStores returns required stores matching the given address patterns. Patterns are anchored regular expressions.
+func NewRequiredStores(patterns []string) (RequiredStores, error) {
+	r := make(RequiredStores, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			return nil, errors.New("empty required store pattern")
+		}
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "compile required store pattern %q", p)
+		}
+		r = append(r, re)
+	}
+	return r, nil
+}
+
+// Matches returns true if the store with the given address is required.
+func (r RequiredStores) Matches(addr string) bool {
+	for _, re := range r {
+		if re.MatchString(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the sorted addresses of required stores among the given ones.
+func (r RequiredStores) Filter(addrs []string) []string {
+	var required []string
+	for _, addr := range addrs {
+		if r.Matches(addr) {
+			required = append(required, addr)
+		}
+	}
+	sort.Strings(required)
+	return required
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// FetchedBytesTrailer is the gRPC trailer in which StoreAPI servers report the number of bytes
+// they fetched from object storage to answer a Series request.
+const FetchedBytesTrailer = "thanos-fetched-bytes"
+
+type resourceStatsKey struct{}
+
+// ResourceStats accumulates the resources used by a single query. It is safe for concurrent use.
+type ResourceStats struct {
+	seriesTouched  int64
+	samplesScanned int64
+	fetchedBytes   int64
+}
+
+// AddSeriesTouched accounts n series received from the store APIs.
+func (s *ResourceStats) AddSeriesTouched(n int64) { atomic.AddInt64(&s.seriesTouched, n) }
+
+// AddSamplesScanned accounts n samples received from the store APIs.
+func (s *ResourceStats) AddSamplesScanned(n int64) { atomic.AddInt64(&s.samplesScanned, n) }
+
+// AddFetchedBytes accounts n bytes fetched from object storage.
+func (s *ResourceStats) AddFetchedBytes(n int64) { atomic.AddInt64(&s.fetchedBytes, n) }
+
+// SeriesTouched returns the number of series received from the store APIs.
+func (s *ResourceStats) SeriesTouched() int64 { return atomic.LoadInt64(&s.seriesTouched) }
+
+// SamplesScanned returns the number of samples received from the store APIs.
+func (s *ResourceStats) SamplesScanned() int64 { return atomic.LoadInt64(&s.samplesScanned) }
+
+// FetchedBytes returns the number of bytes fetched from object storage.
+func (s *ResourceStats) FetchedBytes() int64 { return atomic.LoadInt64(&s.fetchedBytes) }
+
+// WithResourceStats returns a context in which the resources used by queries are accounted in s.
+func WithResourceStats(ctx context.Context, s *ResourceStats) context.Context {
+	return context.WithValue(ctx, resourceStatsKey{}, s)
+}
+
+// ResourceStatsFromContext returns the resource stats of the context or nil if there are none.
+func ResourceStatsFromContext(ctx context.Context) *ResourceStats {
+	s, _ := ctx.Value(resourceStatsKey{}).(*ResourceStats)
+	return s
+}
+
+// reportFetchedBytes reports the bytes fetched to answer a Series call. Servers called through gRPC report
+// them in the trailer of the call. Servers called in-process account them in the resource stats of the request.
+func reportFetchedBytes(ctx context.Context, n int64) {
+	if s := ResourceStatsFromContext(ctx); s != nil {
+		s.AddFetchedBytes(n)
+	}
+	// Fails for servers that are not called through gRPC, which is fine.
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(FetchedBytesTrailer, strconv.FormatInt(n, 10)))
+}
+
+// fetchedBytesFromTrailer returns the fetched bytes reported in the trailer of a Series call.
+func fetchedBytesFromTrailer(md metadata.MD) int64 {
+	v := md[FetchedBytesTrailer]
+	if len(v) == 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(v[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
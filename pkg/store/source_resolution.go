@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SourceResolutionsTrailer is the gRPC trailer in which StoreAPI servers report the resolutions in milliseconds
+// of the data they answered a Series request from. Raw data has a resolution of 0.
+const SourceResolutionsTrailer = "thanos-source-resolutions"
+
+type sourceResolutionsKey struct{}
+
+// SourceResolutions records the resolutions of the data each store answered the Series requests of a query
+// from, e.g. to verify which stores served raw data for a range that could be served downsampled.
+// It is safe for concurrent use.
+type SourceResolutions struct {
+	mtx    sync.Mutex
+	stores map[string]map[int64]struct{}
+}
+
+// Add records that the given store answered from data of the given resolutions.
+func (s *SourceResolutions) Add(store string, res ...int64) {
+	if len(res) == 0 {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.stores == nil {
+		s.stores = map[string]map[int64]struct{}{}
+	}
+	if s.stores[store] == nil {
+		s.stores[store] = map[int64]struct{}{}
+	}
+	for _, r := range res {
+		s.stores[store][r] = struct{}{}
+	}
+}
+
+// ByStore returns the sorted resolutions recorded for each store.
+func (s *SourceResolutions) ByStore() map[string][]int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	res := make(map[string][]int64, len(s.stores))
+	for store, set := range s.stores {
+		for r := range set {
+			res[store] = append(res[store], r)
+		}
+		sort.Slice(res[store], func(i, j int) bool { return res[store][i] < res[store][j] })
+	}
+	return res
+}
+
+// WithSourceResolutions returns a context in which the resolutions the stores answer queries from are recorded in s.
+func WithSourceResolutions(ctx context.Context, s *SourceResolutions) context.Context {
+	return context.WithValue(ctx, sourceResolutionsKey{}, s)
+}
+
+// SourceResolutionsFromContext returns the source resolutions of the context or nil if they are not recorded.
+func SourceResolutionsFromContext(ctx context.Context) *SourceResolutions {
+	s, _ := ctx.Value(sourceResolutionsKey{}).(*SourceResolutions)
+	return s
+}
+
+// reportSourceResolutions reports the resolutions of the data a Series call was answered from in the trailer
+// of the call. It does nothing for servers that are not called through gRPC.
+func reportSourceResolutions(ctx context.Context, res ...int64) {
+	if len(res) == 0 {
+		return
+	}
+	vals := make([]string, 0, len(res))
+	for _, r := range res {
+		vals = append(vals, strconv.FormatInt(r, 10))
+	}
+	_ = grpc.SetTrailer(ctx, metadata.MD{SourceResolutionsTrailer: vals})
+}
+
+// sourceResolutionsFromTrailer returns the resolutions reported in the trailer of a Series call.
+func sourceResolutionsFromTrailer(md metadata.MD) []int64 {
+	var res []int64
+	for _, v := range md[SourceResolutionsTrailer] {
+		r, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
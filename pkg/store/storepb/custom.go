@@ -1,7 +1,14 @@
 package storepb
 
 import (
+	"context"
+	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func NewWarnSeriesResponse(err error) *SeriesResponse {
@@ -12,6 +19,79 @@ func NewWarnSeriesResponse(err error) *SeriesResponse {
 	}
 }
 
+// Categories of StoreWarning.
+const (
+	WarningCategoryTimeout           = "timeout"
+	WarningCategoryUnavailable       = "unavailable"
+	WarningCategoryResourceExhausted = "resource-exhausted"
+	WarningCategoryUnknown           = "unknown"
+)
+
+// StoreWarning describes a store that failed to respond during a fan-out request,
+// causing a partial response. It is sent as a plain warning string, so it stays
+// readable for clients that do not parse it.
+type StoreWarning struct {
+	Store    string
+	Category string
+	Message  string
+}
+
+// NewStoreWarning returns a StoreWarning for the given error of the given store.
+func NewStoreWarning(store string, err error) *StoreWarning {
+	return &StoreWarning{
+		Store:    store,
+		Category: warningCategory(err),
+		Message:  err.Error(),
+	}
+}
+
+func warningCategory(err error) string {
+	cause := errors.Cause(err)
+	if cause == context.DeadlineExceeded {
+		return WarningCategoryTimeout
+	}
+	s, ok := status.FromError(cause)
+	if !ok {
+		return WarningCategoryUnknown
+	}
+	switch s.Code() {
+	case codes.DeadlineExceeded:
+		return WarningCategoryTimeout
+	case codes.Unavailable:
+		return WarningCategoryUnavailable
+	case codes.ResourceExhausted:
+		return WarningCategoryResourceExhausted
+	}
+	return WarningCategoryUnknown
+}
+
+func (w *StoreWarning) Error() string {
+	return fmt.Sprintf("store %s [%s]: %s", w.Store, w.Category, w.Message)
+}
+
+// ParseStoreWarning parses a warning string created from a StoreWarning.
+// It returns false if the warning has a different format.
+func ParseStoreWarning(s string) (*StoreWarning, bool) {
+	if !strings.HasPrefix(s, "store ") {
+		return nil, false
+	}
+	s = s[len("store "):]
+
+	i := strings.Index(s, " [")
+	if i < 0 {
+		return nil, false
+	}
+	j := strings.Index(s[i:], "]: ")
+	if j < 0 {
+		return nil, false
+	}
+	return &StoreWarning{
+		Store:    s[:i],
+		Category: s[i+2 : i+j],
+		Message:  s[i+j+3:],
+	}, true
+}
+
 func NewSeriesResponse(series *Series) *SeriesResponse {
 	return &SeriesResponse{
 		Result: &SeriesResponse_Series{
@@ -20,6 +100,14 @@ func NewSeriesResponse(series *Series) *SeriesResponse {
 	}
 }
 
+func NewHintsSeriesResponse(hints *SeriesHints) *SeriesResponse {
+	return &SeriesResponse{
+		Result: &SeriesResponse_Hints{
+			Hints: hints,
+		},
+	}
+}
+
 // CompareLabels compares two sets of labels.
 func CompareLabels(a, b []Label) int {
 	l := len(a)
@@ -38,6 +126,93 @@ func CompareLabels(a, b []Label) int {
 	return len(a) - len(b)
 }
 
+// SortMetricMetadata sorts metadata by metric name and then by type, help and unit.
+func SortMetricMetadata(md []MetricMetadata) {
+	sort.Slice(md, func(i, j int) bool {
+		a, b := md[i], md[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Help != b.Help {
+			return a.Help < b.Help
+		}
+		return a.Unit < b.Unit
+	})
+}
+
+// MergeMetricMetadata returns the sorted union of the given metadata. Identical entries are
+// returned once, differing entries for the same metric are all kept.
+func MergeMetricMetadata(all ...[]MetricMetadata) []MetricMetadata {
+	seen := map[MetricMetadata]struct{}{}
+	var res []MetricMetadata
+
+	for _, md := range all {
+		for _, m := range md {
+			if _, ok := seen[m]; ok {
+				continue
+			}
+			seen[m] = struct{}{}
+			res = append(res, m)
+		}
+	}
+	SortMetricMetadata(res)
+	return res
+}
+
+// MergeExemplars returns the exemplars of all given series sorted by series labels. Exemplars
+// of the same series are sorted by timestamp and identical exemplars are returned once.
+func MergeExemplars(all ...[]ExemplarData) []ExemplarData {
+	var data []ExemplarData
+	for _, d := range all {
+		data = append(data, d...)
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return CompareLabels(data[i].SeriesLabels, data[j].SeriesLabels) < 0
+	})
+
+	var res []ExemplarData
+	for _, d := range data {
+		if n := len(res); n > 0 && CompareLabels(res[n-1].SeriesLabels, d.SeriesLabels) == 0 {
+			res[n-1].Exemplars = append(res[n-1].Exemplars, d.Exemplars...)
+			continue
+		}
+		res = append(res, ExemplarData{
+			SeriesLabels: d.SeriesLabels,
+			Exemplars:    append([]Exemplar(nil), d.Exemplars...),
+		})
+	}
+	for i := range res {
+		res[i].Exemplars = dedupExemplars(res[i].Exemplars)
+	}
+	return res
+}
+
+func dedupExemplars(es []Exemplar) []Exemplar {
+	sort.SliceStable(es, func(i, j int) bool {
+		if es[i].Ts != es[j].Ts {
+			return es[i].Ts < es[j].Ts
+		}
+		if es[i].Value != es[j].Value {
+			return es[i].Value < es[j].Value
+		}
+		return CompareLabels(es[i].Labels, es[j].Labels) < 0
+	})
+	res := es[:0]
+	for _, e := range es {
+		if n := len(res); n > 0 {
+			last := res[n-1]
+			if last.Ts == e.Ts && last.Value == e.Value && CompareLabels(last.Labels, e.Labels) == 0 {
+				continue
+			}
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
 type emptySeriesSet struct{}
 
 func (emptySeriesSet) Next() bool                 { return false }
@@ -0,0 +1,115 @@
+package storepb
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ServerAsClient returns a StoreClient that calls the given StoreServer in-process
+// without going through the network.
+func ServerAsClient(srv StoreServer) StoreClient {
+	return &serverAsClient{srv: srv}
+}
+
+type serverAsClient struct {
+	srv StoreServer
+}
+
+func (s *serverAsClient) Info(ctx context.Context, in *InfoRequest, _ ...grpc.CallOption) (*InfoResponse, error) {
+	return s.srv.Info(ctx, in)
+}
+
+func (s *serverAsClient) LabelNames(ctx context.Context, in *LabelNamesRequest, _ ...grpc.CallOption) (*LabelNamesResponse, error) {
+	return s.srv.LabelNames(ctx, in)
+}
+
+func (s *serverAsClient) LabelValues(ctx context.Context, in *LabelValuesRequest, _ ...grpc.CallOption) (*LabelValuesResponse, error) {
+	return s.srv.LabelValues(ctx, in)
+}
+
+func (s *serverAsClient) MetricMetadata(ctx context.Context, in *MetricMetadataRequest, _ ...grpc.CallOption) (*MetricMetadataResponse, error) {
+	return s.srv.MetricMetadata(ctx, in)
+}
+
+func (s *serverAsClient) Exemplars(ctx context.Context, in *ExemplarsRequest, _ ...grpc.CallOption) (*ExemplarsResponse, error) {
+	return s.srv.Exemplars(ctx, in)
+}
+
+func (s *serverAsClient) Series(ctx context.Context, in *SeriesRequest, _ ...grpc.CallOption) (Store_SeriesClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	respCh := make(chan *SeriesResponse)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(respCh)
+		errCh <- s.srv.Series(in, &inProcessSeriesServer{ctx: ctx, respCh: respCh})
+	}()
+	return &inProcessSeriesClient{ctx: ctx, cancel: cancel, respCh: respCh, errCh: errCh}, nil
+}
+
+// inProcessSeriesServer passes all responses to the inProcessSeriesClient reading from respCh.
+type inProcessSeriesServer struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	Store_SeriesServer
+	ctx context.Context
+
+	respCh chan<- *SeriesResponse
+}
+
+func (s *inProcessSeriesServer) Send(r *SeriesResponse) error {
+	select {
+	case s.respCh <- r:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *inProcessSeriesServer) Context() context.Context {
+	return s.ctx
+}
+
+type inProcessSeriesClient struct {
+	// This field just exist to pseudo-implement the unused methods of the interface.
+	grpc.ClientStream
+	ctx    context.Context
+	cancel func()
+
+	respCh <-chan *SeriesResponse
+	errCh  <-chan error
+	err    error
+}
+
+func (c *inProcessSeriesClient) Recv() (*SeriesResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	r, ok := <-c.respCh
+	if ok {
+		return r, nil
+	}
+	// The stream is finished, the context is not needed anymore.
+	c.cancel()
+
+	if c.err = <-c.errCh; c.err == nil {
+		c.err = io.EOF
+	}
+	return nil, c.err
+}
+
+func (c *inProcessSeriesClient) Context() context.Context {
+	return c.ctx
+}
+
+// Trailer returns no metadata. In-process servers report their resource usage through the request context instead.
+func (c *inProcessSeriesClient) Trailer() metadata.MD {
+	return nil
+}
+
+func (c *inProcessSeriesClient) CloseSend() error {
+	return nil
+}
@@ -17,6 +17,14 @@
 		LabelNamesResponse
 		LabelValuesRequest
 		LabelValuesResponse
+		MetricMetadataRequest
+		MetricMetadata
+		MetricMetadataResponse
+		ExemplarsRequest
+		Exemplar
+		ExemplarData
+		ExemplarsResponse
+		SeriesHints
 		Label
 		Chunk
 		Series
@@ -33,6 +41,8 @@ import _ "github.com/gogo/protobuf/gogoproto"
 import context "golang.org/x/net/context"
 import grpc "google.golang.org/grpc"
 
+import binary "encoding/binary"
+
 import io "io"
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -91,6 +101,12 @@ type InfoResponse struct {
 	Labels  []Label `protobuf:"bytes,1,rep,name=labels" json:"labels"`
 	MinTime int64   `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
 	MaxTime int64   `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	// Current time of the store in milliseconds since epoch, which allows clients to detect clock skew.
+	// Stores that do not report it leave it at zero.
+	CurrentTime int64 `protobuf:"varint,4,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	// Supports sharding is set by stores that only return the series of the requested shard of sharded
+	// series requests. Clients must not shard requests to stores without support.
+	SupportsSharding bool `protobuf:"varint,5,opt,name=supports_sharding,json=supportsSharding,proto3" json:"supports_sharding,omitempty"`
 }
 
 func (m *InfoResponse) Reset()                    { *m = InfoResponse{} }
@@ -104,6 +120,24 @@ type SeriesRequest struct {
 	Matchers            []LabelMatcher `protobuf:"bytes,3,rep,name=matchers" json:"matchers"`
 	MaxResolutionWindow int64          `protobuf:"varint,4,opt,name=max_resolution_window,json=maxResolutionWindow,proto3" json:"max_resolution_window,omitempty"`
 	Aggregates          []Aggr         `protobuf:"varint,5,rep,packed,name=aggregates,enum=thanos.Aggr" json:"aggregates,omitempty"`
+	// Deduplicate asks stores that know a replica label to merge series that only differ
+	// in it. Stores without deduplication support ignore it and return raw series.
+	Deduplicate bool `protobuf:"varint,6,opt,name=deduplicate,proto3" json:"deduplicate,omitempty"`
+	// Hints asks stores that support it to send SeriesHints as the first message of the response.
+	// Stores without support ignore it, so clients must not rely on receiving hints.
+	Hints bool `protobuf:"varint,7,opt,name=hints,proto3" json:"hints,omitempty"`
+	// Shard asks stores that support sharding to only return series whose hash over the values of
+	// shard_by_labels falls into shard shard_index of total_shards, see ShardMatcher.
+	// Stores without support ignore it and return all series, so clients must filter them as well.
+	ShardIndex    int64    `protobuf:"varint,8,opt,name=shard_index,json=shardIndex,proto3" json:"shard_index,omitempty"`
+	TotalShards   int64    `protobuf:"varint,9,opt,name=total_shards,json=totalShards,proto3" json:"total_shards,omitempty"`
+	ShardByLabels []string `protobuf:"bytes,10,rep,name=shard_by_labels,json=shardByLabels" json:"shard_by_labels,omitempty"`
+	// Hints only asks stores that support it to only send SeriesHints, estimated without fetching any series,
+	// e.g. to estimate the cost of a query before executing it. The series hint then estimates the number of
+	// series selected at the same time rather than bounding the series sent, as a series spans many blocks.
+	// Stores that cannot estimate the series cheaply send nothing. Stores without support ignore it, so clients
+	// must stop reading once they receive a series.
+	HintsOnly bool `protobuf:"varint,11,opt,name=hints_only,json=hintsOnly,proto3" json:"hints_only,omitempty"`
 }
 
 func (m *SeriesRequest) Reset()                    { *m = SeriesRequest{} }
@@ -115,6 +149,7 @@ type SeriesResponse struct {
 	// Types that are valid to be assigned to Result:
 	//	*SeriesResponse_Series
 	//	*SeriesResponse_Warning
+	//	*SeriesResponse_Hints
 	Result isSeriesResponse_Result `protobuf_oneof:"result"`
 }
 
@@ -135,9 +170,13 @@ type SeriesResponse_Series struct {
 type SeriesResponse_Warning struct {
 	Warning string `protobuf:"bytes,2,opt,name=warning,proto3,oneof"`
 }
+type SeriesResponse_Hints struct {
+	Hints *SeriesHints `protobuf:"bytes,3,opt,name=hints,oneof"`
+}
 
 func (*SeriesResponse_Series) isSeriesResponse_Result()  {}
 func (*SeriesResponse_Warning) isSeriesResponse_Result() {}
+func (*SeriesResponse_Hints) isSeriesResponse_Result()   {}
 
 func (m *SeriesResponse) GetResult() isSeriesResponse_Result {
 	if m != nil {
@@ -160,11 +199,19 @@ func (m *SeriesResponse) GetWarning() string {
 	return ""
 }
 
+func (m *SeriesResponse) GetHints() *SeriesHints {
+	if x, ok := m.GetResult().(*SeriesResponse_Hints); ok {
+		return x.Hints
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*SeriesResponse) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
 	return _SeriesResponse_OneofMarshaler, _SeriesResponse_OneofUnmarshaler, _SeriesResponse_OneofSizer, []interface{}{
 		(*SeriesResponse_Series)(nil),
 		(*SeriesResponse_Warning)(nil),
+		(*SeriesResponse_Hints)(nil),
 	}
 }
 
@@ -180,6 +227,11 @@ func _SeriesResponse_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 	case *SeriesResponse_Warning:
 		_ = b.EncodeVarint(2<<3 | proto.WireBytes)
 		_ = b.EncodeStringBytes(x.Warning)
+	case *SeriesResponse_Hints:
+		_ = b.EncodeVarint(3<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Hints); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("SeriesResponse.Result has unexpected type %T", x)
@@ -205,6 +257,14 @@ func _SeriesResponse_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto
 		x, err := b.DecodeStringBytes()
 		m.Result = &SeriesResponse_Warning{x}
 		return true, err
+	case 3: // result.hints
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SeriesHints)
+		err := b.DecodeMessage(msg)
+		m.Result = &SeriesResponse_Hints{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -223,6 +283,11 @@ func _SeriesResponse_OneofSizer(msg proto.Message) (n int) {
 		n += proto.SizeVarint(2<<3 | proto.WireBytes)
 		n += proto.SizeVarint(uint64(len(x.Warning)))
 		n += len(x.Warning)
+	case *SeriesResponse_Hints:
+		s := proto.Size(x.Hints)
+		n += proto.SizeVarint(3<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -267,6 +332,93 @@ func (m *LabelValuesResponse) String() string            { return proto.CompactT
 func (*LabelValuesResponse) ProtoMessage()               {}
 func (*LabelValuesResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{7} }
 
+type MetricMetadataRequest struct {
+	Metric string `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"`
+}
+
+func (m *MetricMetadataRequest) Reset()                    { *m = MetricMetadataRequest{} }
+func (m *MetricMetadataRequest) String() string            { return proto.CompactTextString(m) }
+func (*MetricMetadataRequest) ProtoMessage()               {}
+func (*MetricMetadataRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{8} }
+
+type MetricMetadata struct {
+	Metric string `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"`
+	Type   string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Help   string `protobuf:"bytes,3,opt,name=help,proto3" json:"help,omitempty"`
+	Unit   string `protobuf:"bytes,4,opt,name=unit,proto3" json:"unit,omitempty"`
+}
+
+func (m *MetricMetadata) Reset()                    { *m = MetricMetadata{} }
+func (m *MetricMetadata) String() string            { return proto.CompactTextString(m) }
+func (*MetricMetadata) ProtoMessage()               {}
+func (*MetricMetadata) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{9} }
+
+type MetricMetadataResponse struct {
+	Metadata []MetricMetadata `protobuf:"bytes,1,rep,name=metadata" json:"metadata"`
+	Warnings []string         `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+}
+
+func (m *MetricMetadataResponse) Reset()                    { *m = MetricMetadataResponse{} }
+func (m *MetricMetadataResponse) String() string            { return proto.CompactTextString(m) }
+func (*MetricMetadataResponse) ProtoMessage()               {}
+func (*MetricMetadataResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{10} }
+
+type ExemplarsRequest struct {
+	Query   string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	MinTime int64  `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
+	MaxTime int64  `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+}
+
+func (m *ExemplarsRequest) Reset()                    { *m = ExemplarsRequest{} }
+func (m *ExemplarsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ExemplarsRequest) ProtoMessage()               {}
+func (*ExemplarsRequest) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{11} }
+
+type Exemplar struct {
+	Labels []Label `protobuf:"bytes,1,rep,name=labels" json:"labels"`
+	Value  float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Ts     int64   `protobuf:"varint,3,opt,name=ts,proto3" json:"ts,omitempty"`
+}
+
+func (m *Exemplar) Reset()                    { *m = Exemplar{} }
+func (m *Exemplar) String() string            { return proto.CompactTextString(m) }
+func (*Exemplar) ProtoMessage()               {}
+func (*Exemplar) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{12} }
+
+type ExemplarData struct {
+	SeriesLabels []Label    `protobuf:"bytes,1,rep,name=series_labels,json=seriesLabels" json:"series_labels"`
+	Exemplars    []Exemplar `protobuf:"bytes,2,rep,name=exemplars" json:"exemplars"`
+}
+
+func (m *ExemplarData) Reset()                    { *m = ExemplarData{} }
+func (m *ExemplarData) String() string            { return proto.CompactTextString(m) }
+func (*ExemplarData) ProtoMessage()               {}
+func (*ExemplarData) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{13} }
+
+type ExemplarsResponse struct {
+	Data     []ExemplarData `protobuf:"bytes,1,rep,name=data" json:"data"`
+	Warnings []string       `protobuf:"bytes,2,rep,name=warnings" json:"warnings,omitempty"`
+}
+
+func (m *ExemplarsResponse) Reset()                    { *m = ExemplarsResponse{} }
+func (m *ExemplarsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ExemplarsResponse) ProtoMessage()               {}
+func (*ExemplarsResponse) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{14} }
+
+// SeriesHints estimate the size of a series response. Stores fill them in from the cardinality of
+// postings and send them before fetching any series or chunks, so clients can size their buffers and
+// give up on responses that are too large early. Chunks are estimated from the average chunks per
+// series of the blocks.
+type SeriesHints struct {
+	Series int64 `protobuf:"varint,1,opt,name=series,proto3" json:"series,omitempty"`
+	Chunks int64 `protobuf:"varint,2,opt,name=chunks,proto3" json:"chunks,omitempty"`
+}
+
+func (m *SeriesHints) Reset()                    { *m = SeriesHints{} }
+func (m *SeriesHints) String() string            { return proto.CompactTextString(m) }
+func (*SeriesHints) ProtoMessage()               {}
+func (*SeriesHints) Descriptor() ([]byte, []int) { return fileDescriptorRpc, []int{15} }
+
 func init() {
 	proto.RegisterType((*InfoRequest)(nil), "thanos.InfoRequest")
 	proto.RegisterType((*InfoResponse)(nil), "thanos.InfoResponse")
@@ -276,6 +428,14 @@ func init() {
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
 	proto.RegisterType((*LabelValuesResponse)(nil), "thanos.LabelValuesResponse")
+	proto.RegisterType((*MetricMetadataRequest)(nil), "thanos.MetricMetadataRequest")
+	proto.RegisterType((*MetricMetadata)(nil), "thanos.MetricMetadata")
+	proto.RegisterType((*MetricMetadataResponse)(nil), "thanos.MetricMetadataResponse")
+	proto.RegisterType((*ExemplarsRequest)(nil), "thanos.ExemplarsRequest")
+	proto.RegisterType((*Exemplar)(nil), "thanos.Exemplar")
+	proto.RegisterType((*ExemplarData)(nil), "thanos.ExemplarData")
+	proto.RegisterType((*ExemplarsResponse)(nil), "thanos.ExemplarsResponse")
+	proto.RegisterType((*SeriesHints)(nil), "thanos.SeriesHints")
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
 }
 
@@ -295,6 +455,10 @@ type StoreClient interface {
 	Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (Store_SeriesClient, error)
 	LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error)
 	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+	// MetricMetadata returns type, help and unit of metrics known to the store.
+	MetricMetadata(ctx context.Context, in *MetricMetadataRequest, opts ...grpc.CallOption) (*MetricMetadataResponse, error)
+	// Exemplars returns the exemplars of the series selected by a PromQL query.
+	Exemplars(ctx context.Context, in *ExemplarsRequest, opts ...grpc.CallOption) (*ExemplarsResponse, error)
 }
 
 type storeClient struct {
@@ -364,6 +528,24 @@ func (c *storeClient) LabelValues(ctx context.Context, in *LabelValuesRequest, o
 	return out, nil
 }
 
+func (c *storeClient) MetricMetadata(ctx context.Context, in *MetricMetadataRequest, opts ...grpc.CallOption) (*MetricMetadataResponse, error) {
+	out := new(MetricMetadataResponse)
+	err := grpc.Invoke(ctx, "/thanos.Store/MetricMetadata", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storeClient) Exemplars(ctx context.Context, in *ExemplarsRequest, opts ...grpc.CallOption) (*ExemplarsResponse, error) {
+	out := new(ExemplarsResponse)
+	err := grpc.Invoke(ctx, "/thanos.Store/Exemplars", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Store service
 
 type StoreServer interface {
@@ -372,6 +554,10 @@ type StoreServer interface {
 	Series(*SeriesRequest, Store_SeriesServer) error
 	LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error)
 	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+	// MetricMetadata returns type, help and unit of metrics known to the store.
+	MetricMetadata(context.Context, *MetricMetadataRequest) (*MetricMetadataResponse, error)
+	// Exemplars returns the exemplars of the series selected by a PromQL query.
+	Exemplars(context.Context, *ExemplarsRequest) (*ExemplarsResponse, error)
 }
 
 func RegisterStoreServer(s *grpc.Server, srv StoreServer) {
@@ -453,6 +639,42 @@ func _Store_LabelValues_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Store_MetricMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).MetricMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/thanos.Store/MetricMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).MetricMetadata(ctx, req.(*MetricMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Store_Exemplars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExemplarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).Exemplars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/thanos.Store/Exemplars",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).Exemplars(ctx, req.(*ExemplarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Store_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "thanos.Store",
 	HandlerType: (*StoreServer)(nil),
@@ -469,6 +691,14 @@ var _Store_serviceDesc = grpc.ServiceDesc{
 			MethodName: "LabelValues",
 			Handler:    _Store_LabelValues_Handler,
 		},
+		{
+			MethodName: "MetricMetadata",
+			Handler:    _Store_MetricMetadata_Handler,
+		},
+		{
+			MethodName: "Exemplars",
+			Handler:    _Store_Exemplars_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -535,6 +765,21 @@ func (m *InfoResponse) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
 	}
+	if m.CurrentTime != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.CurrentTime))
+	}
+	if m.SupportsSharding {
+		dAtA[i] = 0x28
+		i++
+		if m.SupportsSharding {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -597,6 +842,61 @@ func (m *SeriesRequest) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintRpc(dAtA, i, uint64(j1))
 		i += copy(dAtA[i:], dAtA2[:j1])
 	}
+	if m.Deduplicate {
+		dAtA[i] = 0x30
+		i++
+		if m.Deduplicate {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.Hints {
+		dAtA[i] = 0x38
+		i++
+		if m.Hints {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	if m.ShardIndex != 0 {
+		dAtA[i] = 0x40
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.ShardIndex))
+	}
+	if m.TotalShards != 0 {
+		dAtA[i] = 0x48
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.TotalShards))
+	}
+	if len(m.ShardByLabels) > 0 {
+		for _, s := range m.ShardByLabels {
+			dAtA[i] = 0x52
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if m.HintsOnly {
+		dAtA[i] = 0x58
+		i++
+		if m.HintsOnly {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -647,6 +947,20 @@ func (m *SeriesResponse_Warning) MarshalTo(dAtA []byte) (int, error) {
 	i += copy(dAtA[i:], m.Warning)
 	return i, nil
 }
+func (m *SeriesResponse_Hints) MarshalTo(dAtA []byte) (int, error) {
+	i := 0
+	if m.Hints != nil {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Hints.Size()))
+		n5, err := m.Hints.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n5
+	}
+	return i, nil
+}
 func (m *LabelNamesRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -785,158 +1099,1650 @@ func (m *LabelValuesResponse) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
-func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
+func (m *MetricMetadataRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
-}
-func (m *InfoRequest) Size() (n int) {
-	var l int
-	_ = l
-	return n
+	return dAtA[:n], nil
 }
 
-func (m *InfoResponse) Size() (n int) {
+func (m *MetricMetadataRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if len(m.Labels) > 0 {
-		for _, e := range m.Labels {
-			l = e.Size()
-			n += 1 + l + sovRpc(uint64(l))
-		}
-	}
-	if m.MinTime != 0 {
-		n += 1 + sovRpc(uint64(m.MinTime))
+	if len(m.Metric) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Metric)))
+		i += copy(dAtA[i:], m.Metric)
 	}
-	if m.MaxTime != 0 {
-		n += 1 + sovRpc(uint64(m.MaxTime))
+	return i, nil
+}
+
+func (m *MetricMetadata) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	return n
+	return dAtA[:n], nil
 }
 
-func (m *SeriesRequest) Size() (n int) {
+func (m *MetricMetadata) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if m.MinTime != 0 {
-		n += 1 + sovRpc(uint64(m.MinTime))
-	}
-	if m.MaxTime != 0 {
-		n += 1 + sovRpc(uint64(m.MaxTime))
-	}
-	if len(m.Matchers) > 0 {
-		for _, e := range m.Matchers {
-			l = e.Size()
-			n += 1 + l + sovRpc(uint64(l))
-		}
+	if len(m.Metric) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Metric)))
+		i += copy(dAtA[i:], m.Metric)
 	}
-	if m.MaxResolutionWindow != 0 {
-		n += 1 + sovRpc(uint64(m.MaxResolutionWindow))
+	if len(m.Type) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Type)))
+		i += copy(dAtA[i:], m.Type)
 	}
-	if len(m.Aggregates) > 0 {
-		l = 0
-		for _, e := range m.Aggregates {
-			l += sovRpc(uint64(e))
-		}
-		n += 1 + sovRpc(uint64(l)) + l
+	if len(m.Help) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Help)))
+		i += copy(dAtA[i:], m.Help)
 	}
-	return n
-}
-
-func (m *SeriesResponse) Size() (n int) {
-	var l int
-	_ = l
-	if m.Result != nil {
-		n += m.Result.Size()
+	if len(m.Unit) > 0 {
+		dAtA[i] = 0x22
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Unit)))
+		i += copy(dAtA[i:], m.Unit)
 	}
-	return n
+	return i, nil
 }
 
-func (m *SeriesResponse_Series) Size() (n int) {
-	var l int
-	_ = l
-	if m.Series != nil {
-		l = m.Series.Size()
-		n += 1 + l + sovRpc(uint64(l))
+func (m *MetricMetadataResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	return n
-}
-func (m *SeriesResponse_Warning) Size() (n int) {
-	var l int
-	_ = l
-	l = len(m.Warning)
-	n += 1 + l + sovRpc(uint64(l))
-	return n
-}
-func (m *LabelNamesRequest) Size() (n int) {
-	var l int
-	_ = l
-	return n
+	return dAtA[:n], nil
 }
 
-func (m *LabelNamesResponse) Size() (n int) {
+func (m *MetricMetadataResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if len(m.Names) > 0 {
-		for _, s := range m.Names {
-			l = len(s)
-			n += 1 + l + sovRpc(uint64(l))
+	if len(m.Metadata) > 0 {
+		for _, msg := range m.Metadata {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
 		}
 	}
 	if len(m.Warnings) > 0 {
 		for _, s := range m.Warnings {
+			dAtA[i] = 0x12
+			i++
 			l = len(s)
-			n += 1 + l + sovRpc(uint64(l))
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
 		}
 	}
-	return n
+	return i, nil
 }
 
-func (m *LabelValuesRequest) Size() (n int) {
+func (m *ExemplarsRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExemplarsRequest) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	l = len(m.Label)
-	if l > 0 {
-		n += 1 + l + sovRpc(uint64(l))
+	if len(m.Query) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Query)))
+		i += copy(dAtA[i:], m.Query)
 	}
-	return n
+	if m.MinTime != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
+	}
+	return i, nil
 }
 
-func (m *LabelValuesResponse) Size() (n int) {
+func (m *Exemplar) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Exemplar) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
 	var l int
 	_ = l
-	if len(m.Values) > 0 {
-		for _, s := range m.Values {
-			l = len(s)
-			n += 1 + l + sovRpc(uint64(l))
+	if len(m.Labels) > 0 {
+		for _, msg := range m.Labels {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if m.Value != 0 {
+		dAtA[i] = 0x11
+		i++
+		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i += 8
+	}
+	if m.Ts != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Ts))
+	}
+	return i, nil
+}
+
+func (m *ExemplarData) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExemplarData) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.SeriesLabels) > 0 {
+		for _, msg := range m.SeriesLabels {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	if len(m.Exemplars) > 0 {
+		for _, msg := range m.Exemplars {
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *ExemplarsResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *ExemplarsResponse) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Data) > 0 {
+		for _, msg := range m.Data {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintRpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
 		}
 	}
 	if len(m.Warnings) > 0 {
 		for _, s := range m.Warnings {
+			dAtA[i] = 0x12
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	return i, nil
+}
+
+func (m *SeriesHints) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SeriesHints) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Series != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Series))
+	}
+	if m.Chunks != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintRpc(dAtA, i, uint64(m.Chunks))
+	}
+	return i, nil
+}
+
+func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func (m *InfoRequest) Size() (n int) {
+	var l int
+	_ = l
+	return n
+}
+
+func (m *InfoResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if m.CurrentTime != 0 {
+		n += 1 + sovRpc(uint64(m.CurrentTime))
+	}
+	if m.SupportsSharding {
+		n += 2
+	}
+	return n
+}
+
+func (m *SeriesRequest) Size() (n int) {
+	var l int
+	_ = l
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if len(m.Matchers) > 0 {
+		for _, e := range m.Matchers {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.MaxResolutionWindow != 0 {
+		n += 1 + sovRpc(uint64(m.MaxResolutionWindow))
+	}
+	if len(m.Aggregates) > 0 {
+		l = 0
+		for _, e := range m.Aggregates {
+			l += sovRpc(uint64(e))
+		}
+		n += 1 + sovRpc(uint64(l)) + l
+	}
+	if m.Deduplicate {
+		n += 2
+	}
+	if m.Hints {
+		n += 2
+	}
+	if m.ShardIndex != 0 {
+		n += 1 + sovRpc(uint64(m.ShardIndex))
+	}
+	if m.TotalShards != 0 {
+		n += 1 + sovRpc(uint64(m.TotalShards))
+	}
+	if len(m.ShardByLabels) > 0 {
+		for _, s := range m.ShardByLabels {
 			l = len(s)
 			n += 1 + l + sovRpc(uint64(l))
 		}
 	}
-	return n
-}
+	if m.HintsOnly {
+		n += 2
+	}
+	return n
+}
+
+func (m *SeriesResponse) Size() (n int) {
+	var l int
+	_ = l
+	if m.Result != nil {
+		n += m.Result.Size()
+	}
+	return n
+}
+
+func (m *SeriesResponse_Series) Size() (n int) {
+	var l int
+	_ = l
+	if m.Series != nil {
+		l = m.Series.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+func (m *SeriesResponse_Warning) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Warning)
+	n += 1 + l + sovRpc(uint64(l))
+	return n
+}
+func (m *SeriesResponse_Hints) Size() (n int) {
+	var l int
+	_ = l
+	if m.Hints != nil {
+		l = m.Hints.Size()
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+func (m *LabelNamesRequest) Size() (n int) {
+	var l int
+	_ = l
+	return n
+}
+
+func (m *LabelNamesResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Names) > 0 {
+		for _, s := range m.Names {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *LabelValuesRequest) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Label)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+
+func (m *LabelValuesResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Values) > 0 {
+		for _, s := range m.Values {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *MetricMetadataRequest) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Metric)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+
+func (m *MetricMetadata) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Metric)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = len(m.Type)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = len(m.Help)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = len(m.Unit)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+
+func (m *MetricMetadataResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Metadata) > 0 {
+		for _, e := range m.Metadata {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *ExemplarsRequest) Size() (n int) {
+	var l int
+	_ = l
+	l = len(m.Query)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	return n
+}
+
+func (m *Exemplar) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.Value != 0 {
+		n += 9
+	}
+	if m.Ts != 0 {
+		n += 1 + sovRpc(uint64(m.Ts))
+	}
+	return n
+}
+
+func (m *ExemplarData) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.SeriesLabels) > 0 {
+		for _, e := range m.SeriesLabels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Exemplars) > 0 {
+		for _, e := range m.Exemplars {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *ExemplarsResponse) Size() (n int) {
+	var l int
+	_ = l
+	if len(m.Data) > 0 {
+		for _, e := range m.Data {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *SeriesHints) Size() (n int) {
+	var l int
+	_ = l
+	if m.Series != 0 {
+		n += 1 + sovRpc(uint64(m.Series))
+	}
+	if m.Chunks != 0 {
+		n += 1 + sovRpc(uint64(m.Chunks))
+	}
+	return n
+}
+
+func sovRpc(x uint64) (n int) {
+	for {
+		n++
+		x >>= 7
+		if x == 0 {
+			break
+		}
+	}
+	return n
+}
+func sozRpc(x uint64) (n int) {
+	return sovRpc(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *InfoRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: InfoRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *InfoResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: InfoResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CurrentTime", wireType)
+			}
+			m.CurrentTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.CurrentTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SupportsSharding", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.SupportsSharding = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Matchers = append(m.Matchers, LabelMatcher{})
+			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxResolutionWindow", wireType)
+			}
+			m.MaxResolutionWindow = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxResolutionWindow |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType == 0 {
+				var v Aggr
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowRpc
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					v |= (Aggr(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				m.Aggregates = append(m.Aggregates, v)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowRpc
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= (int(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthRpc
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				for iNdEx < postIndex {
+					var v Aggr
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowRpc
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						v |= (Aggr(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					m.Aggregates = append(m.Aggregates, v)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Deduplicate", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Deduplicate = bool(v != 0)
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hints", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Hints = bool(v != 0)
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShardIndex", wireType)
+			}
+			m.ShardIndex = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ShardIndex |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TotalShards", wireType)
+			}
+			m.TotalShards = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TotalShards |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ShardByLabels", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ShardByLabels = append(m.ShardByLabels, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HintsOnly", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HintsOnly = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &Series{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Series{v}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hints", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &SeriesHints{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Result = &SeriesResponse_Hints{v}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelNamesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelNamesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelNamesResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelNamesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Names", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Names = append(m.Names, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelValuesRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelValuesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Label = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: LabelValuesResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: LabelValuesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Values = append(m.Values, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
 
-func sovRpc(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
 	}
-	return n
-}
-func sozRpc(x uint64) (n int) {
-	return sovRpc(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+	return nil
 }
-func (m *InfoRequest) Unmarshal(dAtA []byte) error {
+func (m *MetricMetadataRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -959,12 +2765,41 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: InfoRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: MetricMetadataRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: MetricMetadataRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metric", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metric = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -986,7 +2821,7 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *InfoResponse) Unmarshal(dAtA []byte) error {
+func (m *MetricMetadata) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1009,17 +2844,17 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: InfoResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: MetricMetadata: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: MetricMetadata: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Metric", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1029,28 +2864,26 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Labels = append(m.Labels, Label{})
-			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
+			m.Metric = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Type", wireType)
 			}
-			m.MinTime = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1060,16 +2893,26 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MinTime |= (int64(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Type = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		case 3:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Help", wireType)
 			}
-			m.MaxTime = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1079,11 +2922,50 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MaxTime |= (int64(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Help = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1105,7 +2987,7 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
+func (m *MetricMetadataResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1128,53 +3010,15 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: SeriesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: MetricMetadataResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: SeriesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: MetricMetadataResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
-			}
-			m.MinTime = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.MinTime |= (int64(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 2:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
-			}
-			m.MaxTime = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.MaxTime |= (int64(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 3:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
 			}
 			var msglen int
 			for shift := uint(0); ; shift += 7 {
@@ -1194,96 +3038,44 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + msglen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Matchers = append(m.Matchers, LabelMatcher{})
-			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
-		case 4:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxResolutionWindow", wireType)
-			}
-			m.MaxResolutionWindow = 0
-			for shift := uint(0); ; shift += 7 {
-				if shift >= 64 {
-					return ErrIntOverflowRpc
-				}
-				if iNdEx >= l {
-					return io.ErrUnexpectedEOF
-				}
-				b := dAtA[iNdEx]
-				iNdEx++
-				m.MaxResolutionWindow |= (int64(b) & 0x7F) << shift
-				if b < 0x80 {
-					break
-				}
-			}
-		case 5:
-			if wireType == 0 {
-				var v Aggr
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return ErrIntOverflowRpc
-					}
-					if iNdEx >= l {
-						return io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					v |= (Aggr(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				m.Aggregates = append(m.Aggregates, v)
-			} else if wireType == 2 {
-				var packedLen int
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return ErrIntOverflowRpc
-					}
-					if iNdEx >= l {
-						return io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					packedLen |= (int(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				if packedLen < 0 {
-					return ErrInvalidLengthRpc
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metadata = append(m.Metadata, MetricMetadata{})
+			if err := m.Metadata[len(m.Metadata)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
 				}
-				postIndex := iNdEx + packedLen
-				if postIndex > l {
+				if iNdEx >= l {
 					return io.ErrUnexpectedEOF
 				}
-				for iNdEx < postIndex {
-					var v Aggr
-					for shift := uint(0); ; shift += 7 {
-						if shift >= 64 {
-							return ErrIntOverflowRpc
-						}
-						if iNdEx >= l {
-							return io.ErrUnexpectedEOF
-						}
-						b := dAtA[iNdEx]
-						iNdEx++
-						v |= (Aggr(b) & 0x7F) << shift
-						if b < 0x80 {
-							break
-						}
-					}
-					m.Aggregates = append(m.Aggregates, v)
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
 				}
-			} else {
-				return fmt.Errorf("proto: wrong wireType = %d for field Aggregates", wireType)
 			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1305,7 +3097,7 @@ func (m *SeriesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
+func (m *ExemplarsRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1328,17 +3120,17 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: SeriesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: ExemplarsRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: SeriesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ExemplarsRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Query", wireType)
 			}
-			var msglen int
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1348,29 +3140,26 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				stringLen |= (uint64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + msglen
+			postIndex := iNdEx + intStringLen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			v := &Series{}
-			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			m.Result = &SeriesResponse_Series{v}
+			m.Query = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warning", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
 			}
-			var stringLen uint64
+			m.MinTime = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1380,21 +3169,30 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				m.MinTime |= (int64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthRpc
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
 			}
-			postIndex := iNdEx + intStringLen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
 			}
-			m.Result = &SeriesResponse_Warning{string(dAtA[iNdEx:postIndex])}
-			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1416,7 +3214,7 @@ func (m *SeriesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
+func (m *Exemplar) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1439,12 +3237,73 @@ func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelNamesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: Exemplar: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelNamesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: Exemplar: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Value = float64(math.Float64frombits(v))
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Ts", wireType)
+			}
+			m.Ts = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Ts |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1466,7 +3325,7 @@ func (m *LabelNamesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
+func (m *ExemplarData) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1489,17 +3348,17 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelNamesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: ExemplarData: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelNamesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ExemplarData: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Names", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesLabels", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1509,26 +3368,28 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Names = append(m.Names, string(dAtA[iNdEx:postIndex]))
+			m.SeriesLabels = append(m.SeriesLabels, Label{})
+			if err := m.SeriesLabels[len(m.SeriesLabels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
 		case 2:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Exemplars", wireType)
 			}
-			var stringLen uint64
+			var msglen int
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1538,20 +3399,22 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				msglen |= (int(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
+			if msglen < 0 {
 				return ErrInvalidLengthRpc
 			}
-			postIndex := iNdEx + intStringLen
+			postIndex := iNdEx + msglen
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			m.Exemplars = append(m.Exemplars, Exemplar{})
+			if err := m.Exemplars[len(m.Exemplars)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
@@ -1574,7 +3437,7 @@ func (m *LabelNamesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
+func (m *ExemplarsResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1597,15 +3460,46 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelValuesRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: ExemplarsResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelValuesRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: ExemplarsResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
 			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Label", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Data = append(m.Data, ExemplarData{})
+			if err := m.Data[len(m.Data)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
 			}
 			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
@@ -1630,7 +3524,7 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
-			m.Label = string(dAtA[iNdEx:postIndex])
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
 			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
@@ -1653,7 +3547,7 @@ func (m *LabelValuesRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
+func (m *SeriesHints) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -1676,17 +3570,17 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: LabelValuesResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: SeriesHints: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: LabelValuesResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: SeriesHints: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Series", wireType)
 			}
-			var stringLen uint64
+			m.Series = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1696,26 +3590,16 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				m.Series |= (int64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthRpc
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Values = append(m.Values, string(dAtA[iNdEx:postIndex]))
-			iNdEx = postIndex
 		case 2:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Chunks", wireType)
 			}
-			var stringLen uint64
+			m.Chunks = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -1725,21 +3609,11 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				m.Chunks |= (int64(b) & 0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			intStringLen := int(stringLen)
-			if intStringLen < 0 {
-				return ErrInvalidLengthRpc
-			}
-			postIndex := iNdEx + intStringLen
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
-			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -1869,40 +3743,66 @@ var (
 func init() { proto.RegisterFile("rpc.proto", fileDescriptorRpc) }
 
 var fileDescriptorRpc = []byte{
-	// 555 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x5d, 0x6f, 0xd2, 0x50,
-	0x18, 0xa6, 0x94, 0x16, 0x78, 0x3b, 0x48, 0x3d, 0xb0, 0xa5, 0xd4, 0x04, 0x49, 0xaf, 0xc8, 0x34,
-	0xa8, 0x98, 0x98, 0x78, 0x09, 0x8b, 0xcb, 0x48, 0x04, 0x93, 0xc3, 0xe6, 0x8c, 0x37, 0xb3, 0xcc,
-	0x63, 0xd7, 0x84, 0xf6, 0xb0, 0x9e, 0x22, 0x78, 0xeb, 0xaf, 0xe3, 0xd2, 0x5f, 0xe0, 0x07, 0xbf,
-	0xc4, 0x9c, 0x8f, 0x32, 0x6a, 0xa6, 0x77, 0xef, 0xfb, 0x3c, 0x6f, 0x9f, 0xf7, 0xe3, 0x39, 0x29,
-	0x54, 0x93, 0xc5, 0x75, 0x6f, 0x91, 0xd0, 0x94, 0x22, 0x33, 0xbd, 0xf1, 0x63, 0xca, 0x5c, 0x2b,
-	0xfd, 0xba, 0x20, 0x4c, 0x82, 0x6e, 0x33, 0xa0, 0x01, 0x15, 0xe1, 0x53, 0x1e, 0x49, 0xd4, 0xab,
-	0x81, 0x35, 0x8a, 0x3f, 0x53, 0x4c, 0x6e, 0x97, 0x84, 0xa5, 0xde, 0x2d, 0x1c, 0xc8, 0x94, 0x2d,
-	0x68, 0xcc, 0x08, 0x7a, 0x0c, 0xe6, 0xdc, 0x9f, 0x91, 0x39, 0x73, 0xb4, 0x8e, 0xde, 0xb5, 0xfa,
-	0xb5, 0x9e, 0x94, 0xee, 0xbd, 0xe1, 0xe8, 0xb0, 0xb4, 0xf9, 0xf1, 0xa8, 0x80, 0x55, 0x09, 0x6a,
-	0x41, 0x25, 0x0a, 0xe3, 0xab, 0x34, 0x8c, 0x88, 0x53, 0xec, 0x68, 0x5d, 0x1d, 0x97, 0xa3, 0x30,
-	0x3e, 0x0f, 0x23, 0x22, 0x28, 0x7f, 0x2d, 0x29, 0x5d, 0x51, 0xfe, 0x9a, 0x53, 0xde, 0x4f, 0x0d,
-	0x6a, 0x53, 0x92, 0x84, 0x84, 0xa9, 0x21, 0x72, 0x3a, 0xda, 0xbf, 0x75, 0x8a, 0x39, 0x1d, 0xf4,
-	0x92, 0x53, 0xe9, 0xf5, 0x0d, 0x49, 0x98, 0xa3, 0x8b, 0x61, 0x9b, 0xb9, 0x61, 0xc7, 0x92, 0x54,
-	0x33, 0xef, 0x6a, 0x51, 0x1f, 0x0e, 0xb9, 0x64, 0x42, 0x18, 0x9d, 0x2f, 0xd3, 0x90, 0xc6, 0x57,
-	0xab, 0x30, 0xfe, 0x44, 0x57, 0x4e, 0x49, 0xe8, 0x37, 0x22, 0x7f, 0x8d, 0x77, 0xdc, 0xa5, 0xa0,
-	0xd0, 0x13, 0x00, 0x3f, 0x08, 0x12, 0x12, 0xf8, 0x29, 0x61, 0x8e, 0xd1, 0xd1, 0xbb, 0xf5, 0xfe,
-	0x41, 0xd6, 0x6d, 0x10, 0x04, 0x09, 0xde, 0xe3, 0xbd, 0x8f, 0x50, 0xcf, 0x16, 0x54, 0x67, 0xed,
-	0x82, 0xc9, 0x04, 0x22, 0xf6, 0xb3, 0xfa, 0xf5, 0xec, 0x5b, 0x59, 0x77, 0x56, 0xc0, 0x8a, 0x47,
-	0x2e, 0x94, 0x57, 0x7e, 0x12, 0x87, 0x71, 0x20, 0xf6, 0xad, 0x9e, 0x15, 0x70, 0x06, 0x0c, 0x2b,
-	0x60, 0x26, 0x84, 0x2d, 0xe7, 0xa9, 0xd7, 0x80, 0x07, 0x62, 0xc7, 0x89, 0x1f, 0xed, 0xce, 0xe8,
-	0x9d, 0x02, 0xda, 0x07, 0x55, 0xeb, 0x26, 0x18, 0x31, 0x07, 0x84, 0xa1, 0x55, 0x2c, 0x13, 0xe4,
-	0x42, 0x45, 0xa9, 0x32, 0xa7, 0x28, 0x88, 0x5d, 0xee, 0x1d, 0x2b, 0x9d, 0x77, 0xfe, 0x7c, 0x79,
-	0x67, 0x52, 0x13, 0x0c, 0x61, 0xbb, 0xd8, 0xa0, 0x8a, 0x65, 0xe2, 0x8d, 0xa0, 0x91, 0xab, 0x55,
-	0x4d, 0x8f, 0xc0, 0xfc, 0x22, 0x10, 0xd5, 0x55, 0x65, 0xff, 0x6b, 0x7b, 0x3c, 0x84, 0x12, 0xbf,
-	0x24, 0x2a, 0x83, 0x8e, 0x07, 0x97, 0x76, 0x01, 0x55, 0xc1, 0x38, 0x79, 0x7b, 0x31, 0x39, 0xb7,
-	0x35, 0x8e, 0x4d, 0x2f, 0xc6, 0x76, 0x91, 0x07, 0xe3, 0xd1, 0xc4, 0xd6, 0x45, 0x30, 0x78, 0x6f,
-	0x97, 0x90, 0x05, 0x65, 0x51, 0xf5, 0x1a, 0xdb, 0x46, 0xff, 0x5b, 0x11, 0x8c, 0x69, 0x4a, 0x13,
-	0x82, 0x9e, 0x43, 0x89, 0x3f, 0x6c, 0xd4, 0xc8, 0x2e, 0xbd, 0xf7, 0xea, 0xdd, 0x66, 0x1e, 0x54,
-	0x43, 0xbf, 0x02, 0x53, 0xda, 0x81, 0x0e, 0xf3, 0xf6, 0x64, 0x9f, 0x1d, 0xfd, 0x0d, 0xcb, 0x0f,
-	0x9f, 0x69, 0xe8, 0x04, 0xe0, 0xee, 0xf4, 0xa8, 0x95, 0x7b, 0x87, 0xfb, 0x1e, 0xb9, 0xee, 0x7d,
-	0x94, 0xea, 0x7f, 0x0a, 0xd6, 0xde, 0x2d, 0x51, 0xbe, 0x34, 0x67, 0x86, 0xfb, 0xf0, 0x5e, 0x4e,
-	0xea, 0x0c, 0x5b, 0x9b, 0xdf, 0xed, 0xc2, 0x66, 0xdb, 0xd6, 0xbe, 0x6f, 0xdb, 0xda, 0xaf, 0x6d,
-	0x5b, 0xfb, 0x50, 0x66, 0xfc, 0x26, 0x8b, 0xd9, 0xcc, 0x14, 0x3f, 0x81, 0x17, 0x7f, 0x02, 0x00,
-	0x00, 0xff, 0xff, 0x4c, 0xf3, 0x8b, 0x29, 0x3c, 0x04, 0x00, 0x00,
+	// 964 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9d, 0x56, 0xdd, 0x6e, 0xe3, 0x44,
+	0x14, 0xae, 0xeb, 0xfc, 0xf9, 0x38, 0x09, 0xe9, 0x34, 0xad, 0xdc, 0xa0, 0xed, 0x76, 0x7d, 0x81,
+	0xaa, 0x5d, 0xd4, 0x85, 0x80, 0xd0, 0x72, 0x81, 0x44, 0xb3, 0x3f, 0xa2, 0xd2, 0xb6, 0x95, 0xdc,
+	0x5d, 0x16, 0xad, 0xb4, 0x44, 0x6e, 0x32, 0x24, 0x16, 0x8e, 0x6d, 0x3c, 0x63, 0xda, 0xdc, 0xf0,
+	0x06, 0x5c, 0xf2, 0x02, 0xbc, 0x09, 0x77, 0xbd, 0xe4, 0x09, 0x10, 0xf0, 0x24, 0xcc, 0x9c, 0x19,
+	0xbb, 0x76, 0x68, 0x77, 0x05, 0x17, 0x89, 0xe6, 0x7c, 0xdf, 0x99, 0x73, 0xce, 0x9c, 0xbf, 0x04,
+	0xac, 0x34, 0x99, 0x1c, 0x24, 0x69, 0xcc, 0x63, 0xd2, 0xe0, 0x73, 0x3f, 0x8a, 0xd9, 0xc0, 0xe6,
+	0xcb, 0x84, 0x32, 0x05, 0x0e, 0xfa, 0xb3, 0x78, 0x16, 0xe3, 0xf1, 0xa1, 0x3c, 0x29, 0xd4, 0xed,
+	0x80, 0x7d, 0x14, 0x7d, 0x17, 0x7b, 0xf4, 0x87, 0x8c, 0x32, 0xee, 0xfe, 0x66, 0x40, 0x5b, 0xc9,
+	0x2c, 0x89, 0x23, 0x46, 0xc9, 0x03, 0x68, 0x84, 0xfe, 0x39, 0x0d, 0x99, 0x63, 0xec, 0x99, 0xfb,
+	0xf6, 0xb0, 0x73, 0xa0, 0x6c, 0x1f, 0x3c, 0x97, 0xe8, 0xa8, 0x76, 0xf5, 0xc7, 0xdd, 0x35, 0x4f,
+	0xab, 0x90, 0x1d, 0x68, 0x2d, 0x82, 0x68, 0xcc, 0x83, 0x05, 0x75, 0xd6, 0xf7, 0x8c, 0x7d, 0xd3,
+	0x6b, 0x0a, 0xf9, 0x85, 0x10, 0x91, 0xf2, 0x2f, 0x15, 0x65, 0x6a, 0xca, 0xbf, 0x44, 0xea, 0x1e,
+	0xb4, 0x27, 0x59, 0x9a, 0xd2, 0x88, 0x2b, 0xba, 0x86, 0xb4, 0xad, 0x31, 0x54, 0x79, 0x00, 0x1b,
+	0x2c, 0x4b, 0x92, 0x38, 0xe5, 0x6c, 0xcc, 0xe6, 0x7e, 0x3a, 0x0d, 0xa2, 0x99, 0x53, 0x17, 0x7a,
+	0x2d, 0xaf, 0x97, 0x13, 0x67, 0x1a, 0x77, 0x7f, 0x35, 0xa1, 0x73, 0x46, 0xd3, 0x80, 0x32, 0xfd,
+	0xaa, 0x4a, 0x5c, 0xc6, 0xed, 0x71, 0xad, 0x57, 0xe3, 0xfa, 0x4c, 0x52, 0x7c, 0x32, 0xa7, 0x29,
+	0x13, 0x21, 0xcb, 0xc7, 0xf7, 0x2b, 0x8f, 0x3f, 0x56, 0xa4, 0xce, 0x41, 0xa1, 0x4b, 0x86, 0xb0,
+	0x25, 0x4d, 0xa6, 0x94, 0xc5, 0x61, 0xc6, 0x83, 0x38, 0x1a, 0x5f, 0x04, 0xd1, 0x34, 0xbe, 0xd0,
+	0x0f, 0xdb, 0x14, 0xa4, 0x57, 0x70, 0xaf, 0x90, 0x22, 0x1f, 0x02, 0xf8, 0xb3, 0x59, 0x4a, 0x67,
+	0x3e, 0xa7, 0x4c, 0xbc, 0xcc, 0xdc, 0xef, 0x0e, 0xdb, 0xb9, 0xb7, 0x43, 0xc1, 0x78, 0x25, 0x9e,
+	0xec, 0x81, 0x3d, 0xa5, 0xd3, 0x2c, 0x09, 0x83, 0x89, 0x90, 0x9d, 0x06, 0x26, 0xa2, 0x0c, 0x91,
+	0x3e, 0xd4, 0xe7, 0x41, 0xc4, 0x99, 0xd3, 0x44, 0x4e, 0x09, 0xe4, 0x2e, 0xd8, 0x98, 0xbd, 0xb1,
+	0x70, 0x4a, 0x2f, 0x9d, 0x16, 0xc6, 0x03, 0x08, 0x1d, 0x49, 0x44, 0x96, 0x82, 0xc7, 0xdc, 0x0f,
+	0x55, 0x92, 0x99, 0x63, 0xa9, 0x52, 0x20, 0x86, 0xf9, 0x65, 0xe4, 0x03, 0x78, 0x4f, 0xd9, 0x38,
+	0x5f, 0x8e, 0x75, 0x67, 0x80, 0x08, 0xd7, 0xf2, 0x3a, 0x08, 0x8f, 0x96, 0xcf, 0x55, 0x2f, 0xdc,
+	0x01, 0x40, 0xa7, 0xe3, 0x38, 0x0a, 0x97, 0x8e, 0x8d, 0x61, 0x58, 0x88, 0x9c, 0x0a, 0xc0, 0xfd,
+	0xd9, 0x80, 0x6e, 0x5e, 0x24, 0xdd, 0x6a, 0xfb, 0xd0, 0x60, 0x88, 0x60, 0x8d, 0xec, 0x61, 0x37,
+	0x7f, 0xbf, 0xd2, 0xfb, 0x4a, 0xf4, 0x99, 0xe2, 0xc9, 0x00, 0x9a, 0x17, 0x7e, 0x1a, 0xc9, 0x26,
+	0x90, 0x35, 0xb3, 0x04, 0x95, 0x03, 0xa3, 0x16, 0x34, 0x44, 0xe6, 0xb3, 0x90, 0x8b, 0xa6, 0xd1,
+	0x39, 0x30, 0xd1, 0xdc, 0xe6, 0x8a, 0x39, 0x49, 0x89, 0x8b, 0x4a, 0xc7, 0xdd, 0x84, 0x0d, 0x0c,
+	0xfc, 0xc4, 0x5f, 0x14, 0x7d, 0xe3, 0x3e, 0x03, 0x52, 0x06, 0x75, 0x9c, 0x22, 0xb7, 0x91, 0x04,
+	0x70, 0x22, 0x2c, 0x4f, 0x09, 0x22, 0xa6, 0x96, 0x0e, 0x81, 0x89, 0xa0, 0x24, 0x51, 0xc8, 0xee,
+	0x7d, 0x6d, 0xe7, 0x6b, 0x3f, 0xcc, 0xae, 0xbb, 0x52, 0xd8, 0xc1, 0x04, 0xe2, 0x73, 0x85, 0x1d,
+	0x14, 0xdc, 0x23, 0xd8, 0xac, 0xe8, 0x6a, 0xa7, 0xdb, 0xd0, 0xf8, 0x11, 0x11, 0xed, 0x55, 0x4b,
+	0x6f, 0x75, 0xfb, 0x10, 0xb6, 0x8e, 0x29, 0x4f, 0x83, 0x89, 0xf8, 0xf6, 0xa7, 0x3e, 0xf7, 0x73,
+	0xcf, 0xc2, 0xd8, 0x02, 0x09, 0xed, 0x5a, 0x4b, 0xee, 0x14, 0xba, 0xd5, 0x0b, 0xb7, 0x69, 0x12,
+	0x02, 0x35, 0xb9, 0x5b, 0x54, 0xfa, 0x3d, 0x3c, 0x4b, 0x6c, 0x4e, 0xc3, 0x04, 0xd3, 0x2d, 0x30,
+	0x79, 0x96, 0x58, 0x16, 0x05, 0x1c, 0x5b, 0x5f, 0x60, 0xf2, 0xec, 0x46, 0xb0, 0xbd, 0x1a, 0x96,
+	0x7e, 0xe4, 0x23, 0x31, 0x71, 0x1a, 0xd3, 0xeb, 0x66, 0x3b, 0x2f, 0x5a, 0xf5, 0x46, 0x31, 0x73,
+	0x79, 0x9c, 0x6f, 0x4b, 0xc3, 0xb7, 0xd0, 0x7b, 0x7a, 0x49, 0x17, 0x49, 0xe8, 0xa7, 0xe5, 0xdc,
+	0x8b, 0x43, 0xba, 0xcc, 0x73, 0x8f, 0xc2, 0xff, 0xdb, 0x5f, 0xee, 0x1b, 0x68, 0xe5, 0xf6, 0xff,
+	0xdb, 0xba, 0x14, 0x41, 0x60, 0x15, 0xd1, 0x97, 0xe1, 0x29, 0x81, 0x74, 0x61, 0x5d, 0xf7, 0xac,
+	0xe9, 0x89, 0x93, 0xfb, 0x13, 0xb4, 0x73, 0xf3, 0x4f, 0xe4, 0x53, 0x1f, 0x41, 0x47, 0x8d, 0xc1,
+	0xf8, 0xdd, 0x9e, 0xda, 0x4a, 0x53, 0x8f, 0xe4, 0xa7, 0x60, 0xd1, 0x3c, 0x11, 0x98, 0x25, 0x7b,
+	0xd8, 0xcb, 0x6f, 0xe5, 0x2e, 0xf4, 0xc5, 0x6b, 0x45, 0x77, 0x0c, 0x1b, 0xa5, 0xf4, 0xe9, 0x4a,
+	0x1d, 0x40, 0xad, 0x54, 0xa5, 0xfe, 0xaa, 0x95, 0x27, 0xd7, 0x35, 0xaa, 0xbd, 0xb3, 0x3e, 0x5f,
+	0x80, 0x5d, 0x1a, 0x49, 0xd9, 0x72, 0xa5, 0x35, 0x60, 0x16, 0x43, 0x2f, 0xf0, 0xc9, 0x3c, 0x8b,
+	0xbe, 0x67, 0xba, 0x34, 0x5a, 0xba, 0x3f, 0x82, 0x9a, 0x5c, 0x90, 0xa4, 0x09, 0xa6, 0x77, 0xf8,
+	0xaa, 0xb7, 0x46, 0x2c, 0xa8, 0x3f, 0x3e, 0x7d, 0x79, 0xf2, 0xa2, 0x67, 0x48, 0xec, 0xec, 0xe5,
+	0x71, 0x6f, 0x5d, 0x1e, 0x8e, 0x8f, 0x4e, 0x7a, 0x26, 0x1e, 0x0e, 0xbf, 0xe9, 0xd5, 0x88, 0x0d,
+	0x4d, 0xd4, 0x7a, 0xea, 0xf5, 0xea, 0xc3, 0x5f, 0x4c, 0xa8, 0x9f, 0xf1, 0x38, 0xa5, 0xe4, 0x63,
+	0xa8, 0xc9, 0xdf, 0x3f, 0x52, 0x6c, 0x8b, 0xd2, 0xaf, 0xe3, 0xa0, 0x5f, 0x05, 0x75, 0x2e, 0x3e,
+	0x87, 0x86, 0x8a, 0x9f, 0x6c, 0x55, 0x57, 0x4c, 0x7e, 0x6d, 0x7b, 0x15, 0x56, 0x17, 0x3f, 0x32,
+	0xc8, 0x63, 0x80, 0xeb, 0x05, 0x43, 0x76, 0x2a, 0x25, 0x2c, 0x6f, 0xa2, 0xc1, 0xe0, 0x26, 0x4a,
+	0xfb, 0x7f, 0x06, 0x76, 0x69, 0x63, 0x90, 0xaa, 0x6a, 0x65, 0xe5, 0x0c, 0xde, 0xbf, 0x91, 0xd3,
+	0x76, 0x4e, 0xff, 0x35, 0xfd, 0x77, 0x6e, 0x9e, 0xbe, 0xdc, 0xda, 0xee, 0x6d, 0xb4, 0x36, 0xf8,
+	0x25, 0x58, 0x45, 0xe7, 0x10, 0x67, 0xb5, 0x47, 0x8a, 0xa0, 0x76, 0x6e, 0x60, 0x94, 0x85, 0xd1,
+	0xce, 0xd5, 0x5f, 0xbb, 0x6b, 0x57, 0x7f, 0xef, 0x1a, 0xbf, 0x8b, 0xcf, 0x9f, 0xe2, 0xf3, 0xba,
+	0xc9, 0x64, 0x99, 0x92, 0xf3, 0xf3, 0x06, 0xfe, 0x7f, 0xf9, 0xe4, 0x1f, 0x6d, 0x5a, 0xb2, 0xe0,
+	0xf7, 0x08, 0x00, 0x00,
 }
@@ -0,0 +1,47 @@
+package storepb
+
+import (
+	"hash/fnv"
+)
+
+// ShardMatcher matches the series of a single shard of a sharded SeriesRequest. Series are assigned to shards by
+// a hash over the values of the shard labels, so that all series with the same values end up in the same shard.
+type ShardMatcher struct {
+	index, total uint64
+	by           []string
+}
+
+// ShardMatcher returns the matcher for the shard requested by the request or nil if it is not sharded.
+func (m *SeriesRequest) ShardMatcher() *ShardMatcher {
+	if m.TotalShards <= 1 {
+		return nil
+	}
+	return &ShardMatcher{
+		index: uint64(m.ShardIndex),
+		total: uint64(m.TotalShards),
+		by:    m.ShardByLabels,
+	}
+}
+
+// Matches returns true if the series with the given labels belongs to the shard. A nil matcher matches all series.
+func (s *ShardMatcher) Matches(lset []Label) bool {
+	if s == nil {
+		return true
+	}
+	h := fnv.New64a()
+	for _, name := range s.by {
+		var value string
+		for _, l := range lset {
+			if l.Name == name {
+				value = l.Value
+				break
+			}
+		}
+		// The separator keeps differently split values from hashing the same.
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{0xff})
+		_, _ = h.Write([]byte(value))
+		_, _ = h.Write([]byte{0xff})
+	}
+	return h.Sum64()%s.total == s.index
+}
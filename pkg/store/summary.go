@@ -0,0 +1,126 @@
+package store
+
+import (
+	"hash/fnv"
+
+	"github.com/prometheus/tsdb/labels"
+)
+
+// blockSummary summarizes the label values of a block. It is much smaller than the label values themselves
+// and is kept while lazily loaded index lookup structures are unloaded, so blocks that cannot hold series
+// matching a query are skipped without loading their index or touching their postings.
+type blockSummary struct {
+	labels map[string]*labelSummary
+}
+
+// labelSummary holds the lexicographically smallest and largest value of a label and a bloom filter over all its values.
+type labelSummary struct {
+	min, max string
+	values   *bloomFilter
+}
+
+// newBlockSummary returns a summary of the given values of each label name of a block.
+func newBlockSummary(lvals map[string][]string) *blockSummary {
+	s := &blockSummary{labels: make(map[string]*labelSummary, len(lvals))}
+
+	for name, vals := range lvals {
+		s.add(name, vals)
+	}
+	return s
+}
+
+// add summarizes the given values of a label name.
+func (s *blockSummary) add(name string, vals []string) {
+	if len(vals) == 0 {
+		return
+	}
+	ls := &labelSummary{min: vals[0], max: vals[0], values: newBloomFilter(len(vals))}
+	for _, v := range vals {
+		if v < ls.min {
+			ls.min = v
+		}
+		if v > ls.max {
+			ls.max = v
+		}
+		ls.values.add(v)
+	}
+	s.labels[name] = ls
+}
+
+// mayMatch returns false if no series of the block can match all the given matchers. It may return true
+// even if no series matches.
+func (s *blockSummary) mayMatch(ms []labels.Matcher) bool {
+	for _, m := range ms {
+		ls, ok := s.labels[m.Name()]
+		if !ok {
+			// No series has the label, so only matchers that match the empty value can match.
+			if !m.Matches("") {
+				return false
+			}
+			continue
+		}
+		em, ok := m.(*labels.EqualMatcher)
+		if !ok || em.Value() == "" {
+			continue
+		}
+		v := em.Value()
+		if v < ls.min || v > ls.max || !ls.values.mayContain(v) {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// bloomBitsPerValue and bloomHashes result in a false positive rate of about 1%.
+	bloomBitsPerValue = 10
+	bloomHashes       = 7
+)
+
+// bloomFilter is a set of strings that may report strings as contained that were never added.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(n int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (n*bloomBitsPerValue+63)/64)}
+}
+
+// hashes returns two hashes of the value. All further hashes are derived from them.
+func (f *bloomFilter) hashes(v string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	h1 := h.Sum64()
+
+	// FNV spreads similar values like instance addresses poorly, so the second hash is mixed further
+	// with the finalizer of MurmurHash3.
+	h2 := h1 ^ h1>>33
+	h2 *= 0xff51afd7ed558ccd
+	h2 ^= h2 >> 33
+	h2 *= 0xc4ceb9fe1a85ec53
+	h2 ^= h2 >> 33
+	return h1, h2
+}
+
+func (f *bloomFilter) add(v string) {
+	h1, h2 := f.hashes(v)
+	n := uint64(len(f.bits) * 64)
+
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(v string) bool {
+	h1, h2 := f.hashes(v)
+	n := uint64(len(f.bits) * 64)
+
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/testutil"
+	"github.com/prometheus/tsdb/labels"
+)
+
+func TestBlockSummary_mayMatch(t *testing.T) {
+	var instances []string
+	for i := 0; i < 1000; i++ {
+		instances = append(instances, fmt.Sprintf("host-%04d:9100", i))
+	}
+	s := newBlockSummary(map[string][]string{
+		"job":      {"api", "node"},
+		"instance": instances,
+	})
+
+	mustRegexp := func(name, re string) labels.Matcher {
+		m, err := labels.NewRegexpMatcher(name, re)
+		testutil.Ok(t, err)
+		return m
+	}
+	for _, c := range []struct {
+		matchers []labels.Matcher
+		match    bool
+	}{
+		{matchers: nil, match: true},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "api")}, match: true},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "node"), labels.NewEqualMatcher("instance", "host-0042:9100")}, match: true},
+		// Values outside of the range of values.
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "aaa")}, match: false},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "zzz")}, match: false},
+		// Values within the range of values, that are ruled out by the bloom filter.
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "db")}, match: false},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("job", "node"), labels.NewEqualMatcher("instance", "host-0042:9101")}, match: false},
+		// Labels no series has.
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("env", "prod")}, match: false},
+		{matchers: []labels.Matcher{mustRegexp("env", "^(?:prod|dev)$")}, match: false},
+		{matchers: []labels.Matcher{labels.NewEqualMatcher("env", "")}, match: true},
+		{matchers: []labels.Matcher{labels.Not(labels.NewEqualMatcher("env", "prod"))}, match: true},
+		// Other matchers on existing labels are not ruled out.
+		{matchers: []labels.Matcher{mustRegexp("job", "^(?:db)$")}, match: true},
+		{matchers: []labels.Matcher{labels.Not(labels.NewEqualMatcher("job", "api"))}, match: true},
+	} {
+		testutil.Equals(t, c.match, s.mayMatch(c.matchers))
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.add(fmt.Sprintf("value-%d", i))
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		testutil.Assert(t, f.mayContain(fmt.Sprintf("value-%d", i)), "added value %d not contained", i)
+
+		if f.mayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	testutil.Assert(t, falsePositives < 30, "too many false positives: %d", falsePositives)
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"math"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context for requests of the given tenant, see TenantStores.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the context, if any.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// TenantStores wraps the stores function of a ProxyStore so that requests of a tenant only fan out to the stores of
+// that tenant. The tenant of a store is the value of its external label with the given name, like for a matcher on that
+// label. Stores without the label serve data of all tenants and are always used. All stores are used for requests
+// without a tenant in their context. The number of stores each tenant's requests are sent to is counted. Tenants without
+// a store of their own are counted as an empty tenant, as tenants of requests are untrusted and unbounded.
+func TenantStores(reg prometheus.Registerer, label string, stores func(context.Context) ([]Client, error)) func(context.Context) ([]Client, error) {
+	selected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_proxy_store_tenant_selected_stores_total",
+		Help: "Total number of stores selected for the requests of each tenant.",
+	}, []string{"tenant"})
+	if reg != nil {
+		reg.MustRegister(selected)
+	}
+
+	return func(ctx context.Context) ([]Client, error) {
+		all, err := stores(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tenant, ok := TenantFromContext(ctx)
+		if !ok {
+			return all, nil
+		}
+		m := storepb.LabelMatcher{Type: storepb.LabelMatcher_EQ, Name: label, Value: tenant}
+
+		var (
+			res   = make([]Client, 0, len(all))
+			known bool
+		)
+		for _, st := range all {
+			// Stores without the tenant label always match.
+			if ok, _ := storeMatches(st, math.MinInt64, math.MaxInt64, m); !ok {
+				continue
+			}
+			res = append(res, st)
+
+			for _, l := range st.Labels() {
+				if l.Name == label && l.Value == tenant {
+					known = true
+				}
+			}
+		}
+		if !known {
+			tenant = ""
+		}
+		selected.WithLabelValues(tenant).Add(float64(len(res)))
+		return res, nil
+	}
+}
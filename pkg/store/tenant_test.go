@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/improbable-eng/thanos/pkg/store/storepb"
+	"github.com/improbable-eng/thanos/pkg/testutil"
+)
+
+func TestTenantStores(t *testing.T) {
+	var (
+		teamA = &testClient{addr: "team-a", labels: []storepb.Label{{Name: "tenant", Value: "team-a"}, {Name: "region", Value: "eu"}}, maxTime: math.MaxInt64}
+		teamB = &testClient{addr: "team-b", labels: []storepb.Label{{Name: "tenant", Value: "team-b"}}, maxTime: math.MaxInt64}
+		// Stores without the tenant label serve data of all tenants.
+		global = &testClient{addr: "global", labels: []storepb.Label{{Name: "region", Value: "eu"}}, maxTime: math.MaxInt64}
+	)
+	stores := TenantStores(nil, "tenant", func(context.Context) ([]Client, error) {
+		return []Client{teamA, teamB, global}, nil
+	})
+
+	res, err := stores(WithTenant(context.Background(), "team-a"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Client{teamA, global}, res)
+
+	res, err = stores(WithTenant(context.Background(), "team-c"))
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Client{global}, res)
+
+	// Requests without a tenant are sent to all stores.
+	res, err = stores(context.Background())
+	testutil.Ok(t, err)
+	testutil.Equals(t, []Client{teamA, teamB, global}, res)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/improbable-eng/thanos/pkg/store/storepb"
@@ -40,9 +41,10 @@ func NewTSDBStore(logger log.Logger, reg prometheus.Registerer, db *tsdb.DB, ext
 // Info returns store information about the Prometheus instance.
 func (s *TSDBStore) Info(ctx context.Context, r *storepb.InfoRequest) (*storepb.InfoResponse, error) {
 	res := &storepb.InfoResponse{
-		MinTime: 0,
-		MaxTime: math.MaxInt64,
-		Labels:  make([]storepb.Label, 0, len(s.labels)),
+		MinTime:     0,
+		MaxTime:     math.MaxInt64,
+		Labels:      make([]storepb.Label, 0, len(s.labels)),
+		CurrentTime: time.Now().UnixNano() / int64(time.Millisecond),
 	}
 	if blocks := s.db.Blocks(); len(blocks) > 0 {
 		res.MinTime = blocks[0].Meta().MinTime
@@ -86,6 +88,21 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSer
 		return status.Error(codes.Internal, err.Error())
 	}
 
+	// Counting the series only reads the index, their samples are not touched.
+	if r.HintsOnly {
+		var n int64
+		for set.Next() {
+			n++
+		}
+		if set.Err() != nil {
+			return status.Error(codes.Internal, set.Err().Error())
+		}
+		if err := srv.Send(storepb.NewHintsSeriesResponse(&storepb.SeriesHints{Series: n})); err != nil {
+			return status.Error(codes.Aborted, err.Error())
+		}
+		return nil
+	}
+
 	var respSeries storepb.Series
 
 	for set.Next() {
@@ -103,6 +120,8 @@ func (s *TSDBStore) Series(r *storepb.SeriesRequest, srv storepb.Store_SeriesSer
 			return status.Error(codes.Aborted, err.Error())
 		}
 	}
+	// The TSDB only has raw data.
+	reportSourceResolutions(srv.Context(), 0)
 	return nil
 }
 
@@ -166,6 +185,20 @@ func (s *TSDBStore) LabelNames(ctx context.Context, r *storepb.LabelNamesRequest
 	return nil, status.Error(codes.Unimplemented, "not implemented")
 }
 
+// MetricMetadata is not supported as the TSDB does not store metadata.
+func (s *TSDBStore) MetricMetadata(ctx context.Context, r *storepb.MetricMetadataRequest) (
+	*storepb.MetricMetadataResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
+// Exemplars is not supported as the TSDB does not store exemplars.
+func (s *TSDBStore) Exemplars(ctx context.Context, r *storepb.ExemplarsRequest) (
+	*storepb.ExemplarsResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
 // LabelValues returns all known label values for a given label name.
 func (s *TSDBStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequest) (
 	*storepb.LabelValuesResponse, error,
@@ -30,7 +30,7 @@ func DuplicatedCompactionIssue(ctx context.Context, logger log.Logger, bkt objst
 
 	level.Info(logger).Log("msg", "started verifying issue", "with-repair", repair, "issue", DuplicatedCompactionIssueID)
 
-	overlaps, err := fetchOverlaps(ctx, bkt)
+	overlaps, err := fetchOverlaps(ctx, logger, bkt)
 	if err != nil {
 		return errors.Wrap(err, DuplicatedCompactionIssueID)
 	}
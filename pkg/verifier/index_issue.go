@@ -42,15 +42,25 @@ func IndexIssue(ctx context.Context, logger log.Logger, bkt objstore.Bucket, bac
 		}
 		defer os.RemoveAll(tmpdir)
 
-		if err = objstore.DownloadFile(ctx, bkt, path.Join(id.String(), block.IndexFilename), filepath.Join(tmpdir, block.IndexFilename)); err != nil {
-			return errors.Wrapf(err, "download index file %s", path.Join(id.String(), block.IndexFilename))
-		}
-
-		meta, err := block.DownloadMeta(ctx, bkt, id)
+		meta, err := block.DownloadMeta(ctx, logger, bkt, id)
 		if err != nil {
 			return errors.Wrapf(err, "download meta file %s", id)
 		}
 
+		indexFn := filepath.Join(tmpdir, block.IndexFilename)
+		if meta.Thanos.IndexCompression != "" {
+			// Issues are gathered from an uncompressed index.
+			indexFn += ".compressed"
+		}
+		if err = objstore.DownloadFile(ctx, bkt, path.Join(id.String(), block.IndexFilename), indexFn); err != nil {
+			return errors.Wrapf(err, "download index file %s", path.Join(id.String(), block.IndexFilename))
+		}
+		if meta.Thanos.IndexCompression != "" {
+			if err := block.DecompressIndexFile(meta.Thanos.IndexCompression, indexFn, filepath.Join(tmpdir, block.IndexFilename)); err != nil {
+				return errors.Wrapf(err, "decompress index file %s", id)
+			}
+		}
+
 		stats, err := block.GatherIndexIssueStats(filepath.Join(tmpdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
 		if err != nil {
 			return errors.Wrapf(err, "gather index issues %s", id)
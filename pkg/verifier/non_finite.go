@@ -0,0 +1,113 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/improbable-eng/thanos/pkg/block"
+	"github.com/improbable-eng/thanos/pkg/objstore"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const NonFiniteSamplesIssueID = "non_finite_samples"
+
+// NonFiniteSamplesIssue returns an issue that checks raw blocks for series with NaN or infinite sample values,
+// which break the counter aggregation of downsampling. Every affected chunk is logged with its block and series.
+// On repair, the affected blocks are rewritten with the non-finite samples dropped or, if zero is true, set to 0.
+// Downsampled blocks are skipped.
+func NonFiniteSamplesIssue(reg prometheus.Registerer, zero bool) Issue {
+	found := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "thanos_verify_non_finite_samples_total",
+		Help: "Total number of NaN or infinite sample values found in raw blocks.",
+	})
+	if reg != nil {
+		reg.MustRegister(found)
+	}
+
+	return func(ctx context.Context, logger log.Logger, bkt objstore.Bucket, backupBkt objstore.Bucket, repair bool, idMatcher func(ulid.ULID) bool) error {
+		level.Info(logger).Log("msg", "started verifying issue", "with-repair", repair, "issue", NonFiniteSamplesIssueID)
+
+		err := bkt.Iter(ctx, "", func(name string) error {
+			id, ok := block.IsBlockDir(name)
+			if !ok {
+				return nil
+			}
+			if idMatcher != nil && !idMatcher(id) {
+				return nil
+			}
+
+			meta, err := block.DownloadMeta(ctx, logger, bkt, id)
+			if err != nil {
+				return errors.Wrapf(err, "download meta file %s", id)
+			}
+			if meta.Thanos.Downsample.Resolution > 0 {
+				return nil
+			}
+
+			tmpdir, err := ioutil.TempDir("", fmt.Sprintf("non-finite-samples-block-%s-", id))
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmpdir)
+
+			bdir := filepath.Join(tmpdir, id.String())
+			if err := block.Download(ctx, bkt, id, bdir); err != nil {
+				return errors.Wrapf(err, "download block %s", id)
+			}
+
+			chks, err := block.FindNonFiniteChunks(bdir)
+			if err != nil {
+				return errors.Wrapf(err, "find non-finite samples %s", id)
+			}
+			if len(chks) == 0 {
+				return nil
+			}
+			for _, c := range chks {
+				found.Add(float64(c.Samples))
+				level.Warn(logger).Log("msg", "detected issue", "id", id, "series", c.Series, "chunk", c.ChunkRef,
+					"samples", c.Samples, "issue", NonFiniteSamplesIssueID)
+			}
+
+			if !repair {
+				// Only verify.
+				return nil
+			}
+
+			level.Info(logger).Log("msg", "repairing block", "id", id, "zero", zero, "issue", NonFiniteSamplesIssueID)
+			resid, err := block.RepairNonFiniteSamples(tmpdir, id, block.BucketRepairSource, zero)
+			if err != nil {
+				return errors.Wrapf(err, "repair failed for block %s", id)
+			}
+
+			// Verify repaired block before uploading it.
+			if err := block.VerifyIndex(filepath.Join(tmpdir, resid.String(), block.IndexFilename), meta.MinTime, meta.MaxTime); err != nil {
+				return errors.Wrapf(err, "repaired block is invalid %s", resid)
+			}
+
+			level.Info(logger).Log("msg", "uploading repaired block", "newID", resid, "issue", NonFiniteSamplesIssueID)
+			if err = block.Upload(ctx, bkt, filepath.Join(tmpdir, resid.String())); err != nil {
+				return errors.Wrapf(err, "upload of %s failed", resid)
+			}
+
+			level.Info(logger).Log("msg", "safe deleting broken block", "id", id, "issue", NonFiniteSamplesIssueID)
+			if err := SafeDelete(ctx, bkt, backupBkt, id); err != nil {
+				return errors.Wrapf(err, "safe deleting old block %s failed", id)
+			}
+			level.Info(logger).Log("msg", "all good, continuing", "id", id, "issue", NonFiniteSamplesIssueID)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "verify iter, issue %s", NonFiniteSamplesIssueID)
+		}
+
+		level.Info(logger).Log("msg", "verified issue", "with-repair", repair, "issue", NonFiniteSamplesIssueID)
+		return nil
+	}
+}